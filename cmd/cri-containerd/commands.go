@@ -0,0 +1,470 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/kubernetes-incubator/cri-containerd/cmd/cri-containerd/options"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/rpcrecord"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/server"
+)
+
+// debugRequestTimeout is the timeout of a request against the debug socket.
+// It is long because administrative requests, e.g. shutdown-pods, may need to
+// wait for all containers to stop.
+const debugRequestTimeout = 10 * time.Minute
+
+// runCommand runs a cri-containerd sub command.
+func runCommand(o *options.CRIContainerdOptions, args []string) error {
+	switch args[0] {
+	case "shutdown-pods":
+		// Only override the grace period of the server if it is specified,
+		// otherwise the flag default would always be sent.
+		query := url.Values{}
+		if pflag.CommandLine.Changed("shutdown-grace-period") {
+			query.Set("grace-period", o.ShutdownGracePeriod.String())
+		}
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/shutdown-pods", query)
+	case "check":
+		return runCheck(o, args[1:])
+	case "replay":
+		return runReplay(o, args[1:])
+	case "state":
+		return runState(o, args[1:])
+	case "log-level":
+		// Print current verbosity if no level is specified.
+		if len(args) < 2 {
+			return debugRequest(o.DebugSocketPath, http.MethodGet, "/log-level", nil)
+		}
+		query := url.Values{}
+		query.Set("v", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/log-level", query)
+	case "rpc-log":
+		fs := pflag.NewFlagSet("rpc-log", pflag.ExitOnError)
+		duration := fs.Duration("duration", 10*time.Minute, "Duration to log grpc requests and responses. 0 disables rpc logging.")
+		sampleRate := fs.Float64("sample-rate", 1, "Fraction of grpc requests to log, in [0, 1].")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		query := url.Values{}
+		query.Set("duration", duration.String())
+		query.Set("sample-rate", strconv.FormatFloat(*sampleRate, 'f', -1, 64))
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/rpc-log", query)
+	case "sandbox-network":
+		if len(args) < 2 {
+			return fmt.Errorf("sandbox id is required")
+		}
+		query := url.Values{}
+		query.Set("id", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/sandbox-network", query)
+	case "sandbox-network-stats":
+		// Print stats of all sandboxes if no sandbox is specified.
+		query := url.Values{}
+		if len(args) > 1 {
+			query.Set("id", args[1])
+		}
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/sandbox-network-stats", query)
+	case "image-pulls":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/image-pulls", nil)
+	case "image-pull-events":
+		fs := pflag.NewFlagSet("image-pull-events", pflag.ExitOnError)
+		pod := fs.String("pod", "", "Only show events of image pulls requested by the pod, in the form of namespace/name.")
+		image := fs.String("image", "", "Only show events of image pulls of the image reference.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		query := url.Values{}
+		if *pod != "" {
+			query.Set("pod", *pod)
+		}
+		if *image != "" {
+			query.Set("image", *image)
+		}
+		// Events are streamed until interrupted, so no timeout is set.
+		return streamDebugRequest(o.DebugSocketPath, http.MethodGet, "/image-pull-events", query)
+	case "image-usage":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/image-usage", nil)
+	case "snapshotter":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/snapshotter", nil)
+	case "runtime-timeouts":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/runtime-timeouts", nil)
+	case "runtime-handlers":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/runtime-handlers", nil)
+	case "dry-run":
+		// The request is read from the file, or stdin if no file is specified.
+		var req io.Reader = os.Stdin
+		if len(args) > 1 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return fmt.Errorf("failed to open dry run request: %v", err)
+			}
+			defer f.Close()
+			req = f
+		}
+		return doDebugRequest(o.DebugSocketPath, http.MethodPost, "/dry-run", nil, req, debugRequestTimeout)
+	case "rootfs-views":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/container-rootfs-views", nil)
+	case "mount-rootfs":
+		fs := pflag.NewFlagSet("mount-rootfs", pflag.ExitOnError)
+		duration := fs.Duration("duration", 10*time.Minute, "Duration before the rootfs is unmounted automatically.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("container id and host path are required")
+		}
+		query := url.Values{}
+		query.Set("id", fs.Arg(0))
+		query.Set("path", fs.Arg(1))
+		query.Set("duration", duration.String())
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/container-rootfs-views", query)
+	case "export-container":
+		fs := pflag.NewFlagSet("export-container", pflag.ExitOnError)
+		full := fs.Bool("full", false, "Export the full container rootfs instead of the writable layer.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("container id is required")
+		}
+		query := url.Values{}
+		query.Set("id", fs.Arg(0))
+		query.Set("full", strconv.FormatBool(*full))
+		// The tar is written to stdout, and may take long for a big rootfs.
+		return streamDebugRequest(o.DebugSocketPath, http.MethodGet, "/container-export", query)
+	case "commit-container":
+		fs := pflag.NewFlagSet("commit-container", pflag.ExitOnError)
+		tag := fs.String("tag", "", "Repo tag of the new image. The image is only referenced by its id if not set.")
+		author := fs.String("author", "", "Author recorded in the image history.")
+		comment := fs.String("comment", "", "Comment recorded in the image history.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("container id is required")
+		}
+		query := url.Values{}
+		query.Set("id", fs.Arg(0))
+		query.Set("tag", *tag)
+		query.Set("author", *author)
+		query.Set("comment", *comment)
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/container-commit", query)
+	case "snapshot-container":
+		if len(args) < 2 {
+			return fmt.Errorf("container id is required")
+		}
+		query := url.Values{}
+		query.Set("id", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/container-snapshots", query)
+	case "remove-container-snapshot":
+		if len(args) < 2 {
+			return fmt.Errorf("snapshot name is required")
+		}
+		query := url.Values{}
+		query.Set("name", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodDelete, "/container-snapshots", query)
+	case "build-image":
+		fs := pflag.NewFlagSet("build-image", pflag.ExitOnError)
+		tag := fs.StringP("tag", "t", "", "Repo tag of the new image. The image is only referenced by its id if not set.")
+		dockerfile := fs.StringP("file", "f", "", "Path of the Dockerfile in the build context, default to Dockerfile.")
+		buildArgs := fs.StringArray("build-arg", nil, "Build time variable in KEY=VALUE format, can be repeated.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("build context tar is required, - reads it from stdin")
+		}
+		var buildContext io.Reader = os.Stdin
+		if fs.Arg(0) != "-" {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				return fmt.Errorf("failed to open build context: %v", err)
+			}
+			defer f.Close()
+			buildContext = f
+		}
+		query := url.Values{}
+		query.Set("tag", *tag)
+		query.Set("dockerfile", *dockerfile)
+		for _, a := range *buildArgs {
+			query.Add("build-arg", a)
+		}
+		// The build may take long, so the request has no timeout.
+		return doDebugRequest(o.DebugSocketPath, http.MethodPost, "/image-build", query, buildContext, 0)
+	case "container-statuses":
+		fs := pflag.NewFlagSet("container-statuses", pflag.ExitOnError)
+		sandbox := fs.String("sandbox", "", "Return statuses of all containers in the sandbox.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		// Return statuses of all containers if neither ids nor sandbox is specified.
+		query := url.Values{"id": fs.Args()}
+		if *sandbox != "" {
+			query.Set("sandbox", *sandbox)
+		}
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/container-statuses", query)
+	case "ps":
+		if len(args) < 2 {
+			return fmt.Errorf("container id is required")
+		}
+		query := url.Values{}
+		query.Set("id", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/container-processes", query)
+	case "recent-logs":
+		if len(args) < 2 {
+			return fmt.Errorf("container id is required")
+		}
+		query := url.Values{}
+		query.Set("id", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/container-recent-logs", query)
+	case "container-cgroups":
+		// Print cgroups of all containers if no container is specified.
+		query := url.Values{}
+		for _, id := range args[1:] {
+			query.Add("id", id)
+		}
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/container-cgroups", query)
+	case "core-dump":
+		// Invoked by the kernel through the core pattern with the core dump
+		// as stdin. The kernel splits the executable name if it has spaces.
+		if len(args) < 4 {
+			return fmt.Errorf("pid, signal and executable name are required")
+		}
+		query := url.Values{}
+		query.Set("pid", args[1])
+		query.Set("signal", args[2])
+		query.Set("exe", strings.Join(args[3:], " "))
+		return doDebugRequest(o.DebugSocketPath, http.MethodPost, "/core-dumps", query, os.Stdin, 0)
+	case "namespace-usage":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/namespace-usage", nil)
+	case "prepull":
+		fs := pflag.NewFlagSet("prepull", pflag.ExitOnError)
+		remove := fs.Bool("remove", false, "Stop pre-pulling and pinning the images instead of adding them.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		// List pre-pulled images if no image is specified.
+		if fs.NArg() == 0 {
+			return debugRequest(o.DebugSocketPath, http.MethodGet, "/image-prepull", nil)
+		}
+		method := http.MethodPost
+		if *remove {
+			method = http.MethodDelete
+		}
+		return debugRequest(o.DebugSocketPath, method, "/image-prepull", url.Values{"image": fs.Args()})
+	case "failpoint":
+		fs := pflag.NewFlagSet("failpoint", pflag.ExitOnError)
+		action := fs.String("action", "error", "Action when the failpoint is evaluated, `error` or `delay`.")
+		delay := fs.Duration("delay", 0, "Sleep time of the delay action.")
+		count := fs.Int("count", 0, "Times the failpoint fires before it is disarmed. 0 means unlimited.")
+		remove := fs.Bool("remove", false, "Disarm the failpoint, or all failpoints if no failpoint is specified.")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		query := url.Values{}
+		if fs.NArg() > 0 {
+			query.Set("name", fs.Arg(0))
+		}
+		if *remove {
+			return debugRequest(o.DebugSocketPath, http.MethodDelete, "/failpoints", query)
+		}
+		// List armed failpoints if no failpoint is specified.
+		if fs.NArg() == 0 {
+			return debugRequest(o.DebugSocketPath, http.MethodGet, "/failpoints", nil)
+		}
+		query.Set("action", *action)
+		if *delay > 0 {
+			query.Set("delay", delay.String())
+		}
+		if *count > 0 {
+			query.Set("count", strconv.Itoa(*count))
+		}
+		return debugRequest(o.DebugSocketPath, http.MethodPost, "/failpoints", query)
+	case "unmount-rootfs":
+		if len(args) < 2 {
+			return fmt.Errorf("host path is required")
+		}
+		query := url.Values{}
+		query.Set("path", args[1])
+		return debugRequest(o.DebugSocketPath, http.MethodDelete, "/container-rootfs-views", query)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runCheck checks node state against containerd directly, and optionally repairs
+// inconsistencies found. It doesn't need cri-containerd to be running.
+func runCheck(o *options.CRIContainerdOptions, args []string) error {
+	fs := pflag.NewFlagSet("check", pflag.ExitOnError)
+	repair := fs.Bool("repair", false, "Repair inconsistencies found. cri-containerd should not be running.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	checker, err := server.NewStateChecker(o.ContainerdEndpoint, o.Snapshotter, o.RootDir)
+	if err != nil {
+		return fmt.Errorf("failed to create state checker: %v", err)
+	}
+	inconsistencies, err := checker.CheckState(context.Background(), *repair)
+	if err != nil {
+		return fmt.Errorf("failed to check state: %v", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(inconsistencies)
+}
+
+// replayTimeout is the timeout of each replayed request.
+const replayTimeout = 5 * time.Minute
+
+// runReplay replays cri requests recorded with --rpc-record-file in order
+// against the cri-containerd serving on the socket path, and prints the result
+// of each request. It returns error if any request succeeded when recorded
+// but fails when replayed, or the other way around.
+func runReplay(o *options.CRIContainerdOptions, args []string) error {
+	fs := pflag.NewFlagSet("replay", pflag.ExitOnError)
+	stopOnMismatch := fs.Bool("stop-on-mismatch", false, "Stop replaying at the first mismatched request.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("record file is required")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to open record file: %v", err)
+	}
+	defer f.Close()
+	records, err := rpcrecord.ReadRecords(f)
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.Dial(o.SocketPath, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithTimeout(replayTimeout),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %q: %v", o.SocketPath, err)
+	}
+	defer conn.Close()
+
+	replayer := rpcrecord.NewReplayer(conn)
+	enc := json.NewEncoder(os.Stdout)
+	mismatches := 0
+	for i, record := range records {
+		ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+		result, err := replayer.Replay(ctx, record)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to replay record %d: %v", i, err)
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+		if result.Mismatch {
+			mismatches++
+			if *stopOnMismatch {
+				break
+			}
+		}
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d of %d requests mismatch", mismatches, len(records))
+	}
+	return nil
+}
+
+// runState exports the CRI level view of sandboxes, containers and images of
+// the running cri-containerd to stdout, or imports state exported before from
+// a file, for node backup and restore.
+func runState(o *options.CRIContainerdOptions, args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("export or import is required")
+	}
+	switch args[0] {
+	case "export":
+		return debugRequest(o.DebugSocketPath, http.MethodGet, "/state", nil)
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("state file is required")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return fmt.Errorf("failed to open state file: %v", err)
+		}
+		defer f.Close()
+		return doDebugRequest(o.DebugSocketPath, http.MethodPost, "/state", nil, f, debugRequestTimeout)
+	default:
+		return fmt.Errorf("unknown state command %q", args[0])
+	}
+}
+
+// debugRequest sends a request to the cri-containerd debug socket, and copies
+// the response to stdout.
+func debugRequest(socket, method, path string, query url.Values) error {
+	return doDebugRequest(socket, method, path, query, nil, debugRequestTimeout)
+}
+
+// streamDebugRequest is the same with debugRequest, but without timeout, so
+// that streaming responses are copied until the connection is closed.
+func streamDebugRequest(socket, method, path string, query url.Values) error {
+	return doDebugRequest(socket, method, path, query, nil, 0)
+}
+
+func doDebugRequest(socket, method, path string, query url.Values, body io.Reader, timeout time.Duration) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+		Timeout: timeout,
+	}
+	// The host is ignored because the connection is always made to the socket.
+	u := url.URL{Scheme: "http", Host: "cri-containerd", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request for %q: %v", path, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request to %q: %v", socket, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("request %q failed with %q: %s", path, resp.Status, msg)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
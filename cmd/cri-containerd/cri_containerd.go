@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/golang/glog"
@@ -30,6 +31,12 @@ import (
 func main() {
 	o := options.NewCRIContainerdOptions()
 	o.AddFlags(pflag.CommandLine)
+	if path := options.ConfigFileFromArgs(os.Args[1:]); path != "" {
+		if err := o.LoadConfigFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
 	options.InitFlags()
 
 	if o.PrintVersion {
@@ -37,15 +44,32 @@ func main() {
 		os.Exit(0)
 	}
 
+	if args := pflag.Args(); len(args) > 0 {
+		if err := runCommand(o, args); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	glog.V(2).Infof("Run cri-containerd grpc server on socket %q", o.SocketPath)
-	service, err := server.NewCRIContainerdService(o.ContainerdEndpoint, o.RootDir, o.NetworkPluginBinDir, o.NetworkPluginConfDir)
+	service, err := server.NewCRIContainerdService(o.Config)
 	if err != nil {
 		glog.Exitf("Failed to create CRI containerd service %+v: %v", o, err)
 	}
 	service.Start()
 
-	s := server.NewCRIContainerdServer(o.SocketPath, service, service)
-	if err := s.Run(); err != nil {
+	glog.V(2).Infof("Run cri-containerd debug server on socket %q", o.DebugSocketPath)
+	go func() {
+		if err := server.NewCRIContainerdDebugServer(o.DebugSocketPath, service.DebugHandler()).Run(); err != nil {
+			glog.Errorf("Failed to run cri-containerd debug server: %v", err)
+		}
+	}()
+
+	s := server.NewCRIContainerdServer(o.SocketPath, service, service, service.UnaryInterceptor)
+	// Stop the service after the grpc server stops, so that node settings
+	// changed by the service are restored even on termination signals.
+	if err := s.Run(service.Stop); err != nil {
 		glog.Exitf("Failed to run cri-containerd grpc server: %v", err)
 	}
 }
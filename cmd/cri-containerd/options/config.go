@@ -0,0 +1,157 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// configFlag is the flag of the config file path.
+const configFlag = "config"
+
+// ConfigFileFromArgs returns the config file path in the command line
+// arguments, or empty if there is none. The config file is loaded before the
+// command line is parsed, so that flags override the config file. The command
+// line is pre-parsed with all flags, so that flag values are not mistaken for
+// sub commands. Parse errors are left to the real parse, empty is returned.
+func ConfigFileFromArgs(args []string) string {
+	fs := pflag.NewFlagSet("pre-parse", pflag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	// Flags stop at the first sub command.
+	fs.SetInterspersed(false)
+	o := NewCRIContainerdOptions()
+	o.AddFlags(fs)
+	// Go flags, e.g. glog flags, are parsed again by the real parse.
+	fs.AddGoFlagSet(flag.CommandLine)
+	if err := fs.Parse(args); err != nil {
+		return ""
+	}
+	return o.ConfigFile
+}
+
+// LoadConfigFile loads options from the config file. The config file is a
+// limited subset of toml, each line is a `key = value` pair whose key is a flag
+// name, e.g.
+//
+//	containerd-endpoint = "/run/containerd/containerd.sock"
+//	containerd-connection-timeout = "2m"
+//	network-bin-dir = ["/opt/cni/bin", "/home/kubernetes/bin"]
+//	image-gc-high-threshold = 85
+//
+// Values are strings, integers, booleans or single line arrays of strings and
+// integers. Durations are strings in the flag format. Lines starting with `#`
+// are comments, comments after a value are not supported. Tables, multi-line
+// strings and arrays, and dotted keys are not supported either. It must be
+// called before flags are parsed.
+func (c *CRIContainerdOptions) LoadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %q: %v", path, err)
+	}
+	// Set options through a separate flag set, so that flags in the command
+	// line are not considered set already, and replace slices in the config
+	// file instead of appending to them.
+	fs := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	c.AddFlags(fs)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := parseConfigLine(line)
+		if err != nil {
+			return fmt.Errorf("invalid line %d in config file %q: %v", n, path, err)
+		}
+		if key == configFlag {
+			return fmt.Errorf("invalid line %d in config file %q: config file can't be nested", n, path)
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("invalid line %d in config file %q: unknown option %q", n, path, key)
+		}
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("invalid line %d in config file %q: invalid value for %q: %v", n, path, key, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseConfigLine parses a `key = value` line, and returns the key and the
+// value in the flag format.
+func parseConfigLine(line string) (string, string, error) {
+	if strings.HasPrefix(line, "[") {
+		return "", "", fmt.Errorf("tables are not supported")
+	}
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("expect `key = value`")
+	}
+	key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if key == "" {
+		return "", "", fmt.Errorf("empty key")
+	}
+	switch {
+	case strings.HasPrefix(value, `"`):
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid string %s: %v", value, err)
+		}
+		return key, s, nil
+	case strings.HasPrefix(value, "'"):
+		// Literal strings have no escapes.
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", "", fmt.Errorf("invalid string %s", value)
+		}
+		return key, value[1 : len(value)-1], nil
+	case strings.HasPrefix(value, "["):
+		// Arrays of strings and numbers are valid json, except a trailing comma.
+		// Numbers are kept as written, instead of being converted to float64.
+		var items []interface{}
+		trimmed := strings.TrimSpace(strings.TrimSuffix(value, "]"))
+		decoder := json.NewDecoder(strings.NewReader(strings.TrimSuffix(trimmed, ",") + "]"))
+		decoder.UseNumber()
+		if err := decoder.Decode(&items); err != nil {
+			return "", "", fmt.Errorf("invalid array %s: %v", value, err)
+		}
+		var values []string
+		for _, item := range items {
+			values = append(values, fmt.Sprint(item))
+		}
+		// Slice flags take comma separated values.
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.Write(values); err != nil {
+			return "", "", err
+		}
+		w.Flush()
+		return key, strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+	if strings.Contains(value, "#") {
+		return "", "", fmt.Errorf("comments after a value are not supported")
+	}
+	return key, value, nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFileFromArgs(t *testing.T) {
+	for desc, test := range map[string]struct {
+		args     []string
+		expected string
+	}{
+		"no config flag":             {args: []string{"--root-dir", "/root"}},
+		"config flag with value":     {args: []string{"--root-dir=/root", "--config", "/etc/config.toml"}, expected: "/etc/config.toml"},
+		"config flag with = value":   {args: []string{"--config=/etc/config.toml"}, expected: "/etc/config.toml"},
+		"single dash config flag":    {args: []string{"-config", "/etc/config.toml"}},
+		"config flag of sub command": {args: []string{"shutdown-pods", "--config", "/etc/config.toml"}},
+		"config flag without value":  {args: []string{"--config"}},
+		"config flag after flag with separate value": {
+			args:     []string{"--root-dir", "/root", "--config", "/etc/config.toml"},
+			expected: "/etc/config.toml",
+		},
+		"config flag after bool flag": {args: []string{"--read-only", "--config", "/etc/config.toml"}, expected: "/etc/config.toml"},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, ConfigFileFromArgs(test.args))
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for desc, test := range map[string]struct {
+		config    string
+		args      []string
+		expectErr bool
+		check     func(*CRIContainerdOptions)
+	}{
+		"options should be loaded from config file": {
+			config: `# cri-containerd config
+containerd-endpoint = "/run/containerd/test.sock"
+sandbox-image = 'k8s.gcr.io/pause:3.1'
+containerd-connection-timeout = "30s"
+image-gc-high-threshold = 85
+pod-metrics = true
+network-bin-dir = ["/opt/cni/bin", "/home/kubernetes/bin",]
+`,
+			check: func(o *CRIContainerdOptions) {
+				assert.Equal(t, "/run/containerd/test.sock", o.ContainerdEndpoint)
+				assert.Equal(t, "k8s.gcr.io/pause:3.1", o.SandboxImage)
+				assert.Equal(t, 30*time.Second, o.ContainerdConnectionTimeout)
+				assert.Equal(t, 85, o.ImageGCHighThresholdPercent)
+				assert.True(t, o.PodMetrics)
+				assert.Equal(t, []string{"/opt/cni/bin", "/home/kubernetes/bin"}, o.NetworkPluginBinDirs)
+				// Options not in the config file keep their defaults.
+				assert.Equal(t, "/var/lib/cri-containerd", o.RootDir)
+			},
+		},
+		"flags should override config file": {
+			config: `containerd-endpoint = "/run/containerd/test.sock"
+network-bin-dir = ["/opt/cni/bin", "/home/kubernetes/bin"]
+root-dir = "/var/lib/test"
+`,
+			args: []string{"--containerd-endpoint=/run/containerd/flag.sock", "--network-bin-dir=/flag/bin"},
+			check: func(o *CRIContainerdOptions) {
+				assert.Equal(t, "/run/containerd/flag.sock", o.ContainerdEndpoint)
+				assert.Equal(t, []string{"/flag/bin"}, o.NetworkPluginBinDirs)
+				assert.Equal(t, "/var/lib/test", o.RootDir)
+			},
+		},
+		"unknown option should return error": {
+			config:    `unknown-option = "value"`,
+			expectErr: true,
+		},
+		"invalid value should return error": {
+			config:    `image-gc-high-threshold = "high"`,
+			expectErr: true,
+		},
+		"numeric array should be loaded as written": {
+			config: `pinned-images = [1000000]
+`,
+			check: func(o *CRIContainerdOptions) {
+				assert.Equal(t, []string{"1000000"}, o.PinnedImages)
+			},
+		},
+		"comment after value should return error": {
+			config:    `root-dir = /var/lib/test # comment`,
+			expectErr: true,
+		},
+		"table should return error": {
+			config:    `[plugins]`,
+			expectErr: true,
+		},
+		"nested config file should return error": {
+			config:    `config = "/etc/other.toml"`,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		path := filepath.Join(dir, "config.toml")
+		require.NoError(t, ioutil.WriteFile(path, []byte(test.config), 0644))
+		o := NewCRIContainerdOptions()
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		o.AddFlags(fs)
+		err := o.LoadConfigFile(path)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, fs.Parse(test.args))
+		test.check(o)
+	}
+}
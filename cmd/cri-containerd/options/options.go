@@ -23,23 +23,262 @@ import (
 	"github.com/spf13/pflag"
 )
 
-// CRIContainerdOptions contains cri-containerd command line options.
-type CRIContainerdOptions struct {
+// Config contains cri-containerd configurations.
+type Config struct {
 	// SocketPath is the path to the socket which cri-containerd serves on.
 	SocketPath string
+	// DebugSocketPath is the path to the socket which cri-containerd serves
+	// debug and administrative endpoints on.
+	DebugSocketPath string
 	// RootDir is the root directory path for managing cri-containerd files
 	// (metadata checkpoint etc.)
 	RootDir string
-	// PrintVersion indicates to print version information of cri-containerd.
-	PrintVersion bool
 	// ContainerdEndpoint is the containerd endpoint path.
 	ContainerdEndpoint string
+	// ReadOnly only serves cri requests which don't change the node, and
+	// disables background work which does.
+	ReadOnly bool
+	// SandboxImage is the image used by sandbox containers.
+	SandboxImage string
+	// SandboxImagePrepull enables pulling the sandbox image in the background
+	// before any sandbox is run.
+	SandboxImagePrepull bool
 	// ContainerdConnectionTimeout is the connection timeout for containerd client.
 	ContainerdConnectionTimeout time.Duration
-	// NetworkPluginBinDir is the directory in which the binaries for the plugin is kept.
-	NetworkPluginBinDir string
+	// ContainerdConnectionPoolSize is the number of connections to containerd, task
+	// and content requests are distributed across them.
+	ContainerdConnectionPoolSize int
+	// Snapshotter is the containerd snapshotter to use, empty means the
+	// containerd default snapshotter.
+	Snapshotter string
+	// SnapshotterFallback is the snapshotter used when the configured one is not
+	// available or misses required capabilities. Empty means to fail instead.
+	SnapshotterFallback string
+	// SnapshotterRequiredCapabilities are capabilities the snapshotter must have.
+	SnapshotterRequiredCapabilities []string
+	// NetworkPluginBinDirs are the directories in which the binaries for the plugin are kept.
+	NetworkPluginBinDirs []string
 	// NetworkPluginConfDir is the directory in which the admin places a CNI conf.
 	NetworkPluginConfDir string
+	// NetworkPluginCacheDir is the directory in which CNI results are cached.
+	NetworkPluginCacheDir string
+	// NetworkPluginMaxConfWait is the grace period after startup during which a
+	// missing CNI conf is reported as waiting instead of an error.
+	NetworkPluginMaxConfWait time.Duration
+	// NetworkPluginConfTemplate is the path of a network plugin configuration
+	// template, which is rendered with the pod cidr pushed by the kubelet.
+	NetworkPluginConfTemplate string
+	// HairpinMode is how pods are configured to reach themselves through service
+	// vips, it should match the kubelet setting.
+	HairpinMode string
+	// NetworkSetupRetries is the number of retries when CNI ADD fails.
+	NetworkSetupRetries int
+	// NetworkSetupBackoff is the initial backoff between CNI ADD retries, it
+	// doubles after each retry.
+	NetworkSetupBackoff time.Duration
+	// PodNetworkStatsPeriod is the period to collect sandbox network stats from
+	// host side veths. 0 disables the collector.
+	PodNetworkStatsPeriod time.Duration
+	// PodMetrics enables per pod and container usage metrics labeled with
+	// namespace, pod and container names.
+	PodMetrics bool
+	// PodMetricsLabelAllowlist is the pod and container labels exported as
+	// labels of pod metrics.
+	PodMetricsLabelAllowlist []string
+	// ImagePullTimeout is the maximum duration of a single image pull. When it is
+	// set, image pulls are not bounded by the deadline of the request. 0 means
+	// image pulls are only bounded by the request deadline.
+	ImagePullTimeout time.Duration
+	// ImagePullProgressTimeout is the maximum duration an image pull is allowed to
+	// make no download progress before it is cancelled. 0 disables the check.
+	ImagePullProgressTimeout time.Duration
+	// MaxConcurrentImagePulls is the maximum number of concurrent image pulls,
+	// 0 means no limit.
+	MaxConcurrentImagePulls int
+	// MaxConcurrentDownloads is the maximum number of layers downloaded
+	// concurrently by all image pulls, 0 means no limit.
+	MaxConcurrentDownloads int
+	// ImagePullSystemNamespaces are namespaces of system pods, whose images are
+	// pulled before application images when pulls are limited.
+	ImagePullSystemNamespaces []string
+	// OCILayoutHostDirs are "host=dir" pairs. Images of the host are pulled from
+	// oci image layouts in the directory instead of a registry.
+	OCILayoutHostDirs []string
+	// ImageRewriteRules are "from=to" rules rewriting image references to pull
+	// images from, e.g. to pull images from a mirror in air-gapped clusters.
+	ImageRewriteRules []string
+	// RegistryMirrors are "host=endpoint" pairs. Images of the host are pulled
+	// from the mirror endpoints in order, and then from the host itself.
+	RegistryMirrors []string
+	// RegistryEndpoints are "host=endpoint" pairs. Images of the host are only
+	// pulled from the endpoint.
+	RegistryEndpoints []string
+	// InsecureRegistries are registry hosts whose tls certificates are not verified.
+	InsecureRegistries []string
+	// RegistryCertsDir is the directory containing ca and client certificates of
+	// registry hosts in the docker certs.d layout.
+	RegistryCertsDir string
+	// ImageDigestPolicy is the policy of referencing images by digest, one of
+	// empty, enforce and resolve.
+	ImageDigestPolicy string
+	// ImageStatusVerifyContent enables verifying content of images in ImageStatus.
+	ImageStatusVerifyContent bool
+	// ImageRepairPolicy is the policy of repairing images whose snapshots fail
+	// to be prepared, one of empty, unpack and pull.
+	ImageRepairPolicy string
+	// ImageBuilderAddress is the address of buildkitd to build images with.
+	// Image build is disabled if it is empty.
+	ImageBuilderAddress string
+	// ImageBuilderBinary is the buildctl binary driving the image builder.
+	ImageBuilderBinary string
+	// ImageFsPath is the path on the filesystem storing images, which is watched
+	// for disk pressure.
+	ImageFsPath string
+	// ImageGCHighThresholdPercent is the image filesystem usage percent above
+	// which unused images are removed. 0 disables image garbage collection.
+	ImageGCHighThresholdPercent int
+	// ImageGCLowThresholdPercent is the image filesystem usage percent image
+	// garbage collection tries to free space down to.
+	ImageGCLowThresholdPercent int
+	// ImageGCPeriod is the period to check image filesystem usage.
+	ImageGCPeriod time.Duration
+	// ContentIngestGCPeriod is the period to abort stale content ingests, 0
+	// disables it.
+	ContentIngestGCPeriod time.Duration
+	// ContentIngestMaxAge is the duration a content ingest is not updated, after
+	// which it is considered stale.
+	ContentIngestMaxAge time.Duration
+	// PinnedImages are images never removed by image garbage collection.
+	PinnedImages []string
+	// ShutdownGracePeriod is the grace period given to each container when all
+	// pods are stopped for node shutdown.
+	ShutdownGracePeriod time.Duration
+	// DefaultProcessPath is the PATH of container processes if neither the
+	// image nor the container config sets it.
+	DefaultProcessPath string
+	// SeccompDefaultProfile is the path to the seccomp profile used for
+	// `runtime/default`. A built-in profile is used if it doesn't exist.
+	SeccompDefaultProfile string
+	// ApparmorDefaultProfile is the path to the apparmor profile used for
+	// `runtime/default`, which is loaded at startup.
+	ApparmorDefaultProfile string
+	// UnmaskedProcMountNamespaces is the list of pod namespaces allowed to
+	// request unmasked /proc for their containers.
+	UnmaskedProcMountNamespaces []string
+	// PrivilegedWithoutHostDevices indicates that privileged containers get all
+	// capabilities, but not all host devices. Runtime handlers can override it.
+	PrivilegedWithoutHostDevices bool
+	// DeviceAccessGids are additional gids added to containers with devices, so
+	// that non-root users in the containers could access the devices.
+	DeviceAccessGids []uint
+	// LocaltimeFile is the zone file bind mounted to /etc/localtime of containers
+	// which don't set TZ. Empty means not to mount localtime.
+	LocaltimeFile string
+	// DefaultCPUShares is the cpu shares of containers whose resources don't
+	// set cpu shares, e.g. of best-effort pods. 0 means the runtime default.
+	DefaultCPUShares int64
+	// DefaultOOMScoreAdj is the oom score adj of containers whose resources
+	// don't set oom score adj, e.g. of best-effort pods. 0 means the runtime
+	// default.
+	DefaultOOMScoreAdj int64
+	// AdmissionPolicyFile is the path to the json admission policy evaluated
+	// before RunPodSandbox and CreateContainer. Empty means no policy.
+	AdmissionPolicyFile string
+	// AdmissionWebhook is the url the admission requests are posted to. Empty
+	// means no webhook.
+	AdmissionWebhook string
+	// AdmissionWebhookTimeout is the timeout of calling the admission webhook.
+	AdmissionWebhookTimeout time.Duration
+	// AdmissionWebhookFailOpen allows requests when the admission webhook
+	// can't be reached.
+	AdmissionWebhookFailOpen bool
+	// NamespaceQuotaFile is the path to the json file mapping kubernetes
+	// namespaces to their quotas on the node. Empty means no quota.
+	NamespaceQuotaFile string
+	// ImagePrepullManifest is the path to the file listing images pre-pulled
+	// and pinned by the daemon, one image reference per line.
+	ImagePrepullManifest string
+	// ImagePrepullPeriod is the period to reload the pre-pull manifest and pull
+	// missing images.
+	ImagePrepullPeriod time.Duration
+	// HookFailureAsWarning starts containers without prestart hooks when the
+	// hooks fail, instead of failing the start.
+	HookFailureAsWarning bool
+	// EnvInjectionFile is the path to the json config of environment variables
+	// injected into containers. Empty means no injection.
+	EnvInjectionFile string
+	// MountPolicyFile is the path to the json mount policy blocking or
+	// rewriting host path mounts. Empty means no policy.
+	MountPolicyFile string
+	// RuntimeHandlersFile is the path to the json config of runtime handlers
+	// with low level runc options. Empty means containerd runtime defaults.
+	RuntimeHandlersFile string
+	// ContainerLogIndexInterval is the interval in bytes of checkpoints in the
+	// index file written next to each container log. 0 means container logs
+	// are not indexed.
+	ContainerLogIndexInterval int64
+	// ContainerLogRingSize is the size in bytes of the most recent log lines of
+	// each container kept in memory. 0 means no log lines are kept.
+	ContainerLogRingSize int
+	// CoreDumpCapture captures core dumps of crashed container processes into
+	// the pod log directory.
+	CoreDumpCapture bool
+	// CoreDumpSizeLimit is the maximum size of a captured core dump.
+	CoreDumpSizeLimit int64
+	// CoreDumpTotalLimit is the maximum total size of captured core dumps on
+	// the node. 0 means no limit.
+	CoreDumpTotalLimit int64
+	// TaskCreateTimeout is the timeout of creating a containerd task. 0 means
+	// no timeout.
+	TaskCreateTimeout time.Duration
+	// TaskStartTimeout is the timeout of starting a containerd task. 0 means
+	// no timeout.
+	TaskStartTimeout time.Duration
+	// TaskStopTimeout is the timeout of waiting for a container to exit after
+	// it is killed.
+	TaskStopTimeout time.Duration
+	// ExecSetupTimeout is the timeout of creating an exec process. It doesn't
+	// bound how long the process runs. 0 means no timeout.
+	ExecSetupTimeout time.Duration
+	// ExecSyncDirect runs ExecSync commands with runc directly instead of
+	// through containerd, e.g. for exec probes.
+	ExecSyncDirect bool
+	// RuncBinary is the runc binary used by ExecSync with ExecSyncDirect.
+	RuncBinary string
+	// RuncRoot is the root directory of runc states used by the containerd
+	// linux runtime.
+	RuncRoot string
+	// TaskDeleteRetryPeriod is the period of retrying failed task deletions.
+	TaskDeleteRetryPeriod time.Duration
+	// TaskDeleteRetries is the number of failed task deletions before the shim
+	// is killed.
+	TaskDeleteRetries int
+	// ContainerdRuntimeStateDir is the state directory of the containerd linux
+	// runtime, which contains shim pid files.
+	ContainerdRuntimeStateDir string
+	// ContainerdRuntimeRootDir is the root directory of the containerd linux
+	// runtime.
+	ContainerdRuntimeRootDir string
+	// RPCRecordFile is the file which cri requests are recorded into for
+	// replay. Empty means no recording.
+	RPCRecordFile string
+	// ContainerMemoryMetric is the memory metric reported as the working set
+	// of container stats, one of working-set, rss and usage.
+	ContainerMemoryMetric string
+	// EventWorkers is the number of workers handling containerd events. Events
+	// of the same container are handled by the same worker in order.
+	EventWorkers int
+}
+
+// CRIContainerdOptions contains cri-containerd command line options.
+type CRIContainerdOptions struct {
+	// Config contains cri-containerd configurations.
+	Config
+	// PrintVersion indicates to print version information of cri-containerd.
+	PrintVersion bool
+	// ConfigFile is the path of the config file. Flags override options in
+	// the config file.
+	ConfigFile string
 }
 
 // NewCRIContainerdOptions returns a reference to CRIContainerdOptions
@@ -49,20 +288,182 @@ func NewCRIContainerdOptions() *CRIContainerdOptions {
 
 // AddFlags adds cri-containerd command line options to pflag.
 func (c *CRIContainerdOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, configFlag,
+		"", "Path to the config file, in which each line is a flag name and its value in toml, e.g. network-conf-dir = \"/etc/cni/net.d\". Flags in the command line override the config file.")
 	fs.StringVar(&c.SocketPath, "socket-path",
 		"/var/run/cri-containerd.sock", "Path to the socket which cri-containerd serves on.")
+	fs.StringVar(&c.DebugSocketPath, "debug-socket-path",
+		"/var/run/cri-containerd-debug.sock", "Path to the socket which cri-containerd serves debug and administrative endpoints on.")
 	fs.StringVar(&c.RootDir, "root-dir",
 		"/var/lib/cri-containerd", "Root directory path for cri-containerd managed files (metadata checkpoint etc).")
 	fs.StringVar(&c.ContainerdEndpoint, "containerd-endpoint",
 		"/run/containerd/containerd.sock", "Path to the containerd endpoint.")
+	fs.BoolVar(&c.ReadOnly, "read-only",
+		false, "Only serve cri requests which don't change the node, e.g. list, status and stats. Other requests, including exec, attach and port forward, are rejected. Debug socket requests which change the node are also rejected. Image garbage collection, pre-pull, the task reaper and image content repair are disabled, and recovery doesn't kill or delete tasks. This is useful for inspecting a quarantined node.")
+	fs.StringVar(&c.SandboxImage, "sandbox-image",
+		"gcr.io/google_containers/pause:3.0", "The image used by sandbox containers. It is never removed by image garbage collection.")
+	fs.BoolVar(&c.SandboxImagePrepull, "sandbox-image-prepull",
+		false, "Pull the sandbox image in the background at startup and whenever it is missing, along with images in the pre-pull manifest, so that the first sandbox doesn't wait for it.")
 	fs.DurationVar(&c.ContainerdConnectionTimeout, "containerd-connection-timeout",
 		2*time.Minute, "Connection timeout for containerd client.")
+	fs.IntVar(&c.ContainerdConnectionPoolSize, "containerd-connection-pool-size",
+		1, "Number of connections to containerd. Task and content requests are distributed across them to avoid a single connection becoming a bottleneck during mass pod creation.")
+	fs.StringVar(&c.Snapshotter, "snapshotter",
+		"", "The containerd snapshotter to use. Empty means the containerd default snapshotter.")
+	fs.StringVar(&c.SnapshotterFallback, "snapshotter-fallback",
+		"overlayfs", "The snapshotter used when the configured snapshotter is not available or misses required capabilities. Empty means to fail instead.")
+	fs.StringSliceVar(&c.SnapshotterRequiredCapabilities, "snapshotter-required-capabilities",
+		nil, "Capabilities the snapshotter must have, any of `usage` and `remote-layers`.")
 	fs.BoolVar(&c.PrintVersion, "version",
 		false, "Print cri-containerd version information and quit.")
-	fs.StringVar(&c.NetworkPluginBinDir, "network-bin-dir",
-		"/etc/cni/net.d", "The directory for putting network binaries.")
+	fs.StringSliceVar(&c.NetworkPluginBinDirs, "network-bin-dir",
+		[]string{"/opt/cni/bin"}, "The directories for putting network binaries, searched in order.")
 	fs.StringVar(&c.NetworkPluginConfDir, "network-conf-dir",
-		"/opt/cni/bin", "The directory for putting network plugin configuration files.")
+		"/etc/cni/net.d", "The directory for putting network plugin configuration files.")
+	fs.StringVar(&c.NetworkPluginCacheDir, "network-cache-dir",
+		"/var/lib/cni/results", "The directory for caching network plugin results.")
+	fs.DurationVar(&c.NetworkPluginMaxConfWait, "network-conf-max-wait",
+		time.Minute, "Grace period after startup during which a missing network plugin configuration is reported as waiting instead of an error.")
+	fs.StringVar(&c.NetworkPluginConfTemplate, "network-conf-template",
+		"", "The network plugin configuration template, `{{.PodCIDR}}` in it is replaced with the pod cidr pushed by the kubelet and the result is written into the network conf dir. Empty means the pod cidr is ignored.")
+	fs.StringVar(&c.HairpinMode, "hairpin-mode",
+		"none", "How pods are configured to reach themselves through service vips, one of `hairpin-veth`, `promiscuous-bridge` and `none`. It should match the kubelet setting.")
+	fs.IntVar(&c.NetworkSetupRetries, "network-setup-retries",
+		2, "Number of retries when adding a sandbox into the network fails.")
+	fs.DurationVar(&c.NetworkSetupBackoff, "network-setup-backoff",
+		time.Second, "Initial backoff between retries of adding a sandbox into the network, it doubles after each retry.")
+	fs.DurationVar(&c.PodNetworkStatsPeriod, "pod-network-stats-period",
+		0, "Period to collect sandbox network throughput and drops from host side veths. 0 disables the collector.")
+	fs.BoolVar(&c.PodMetrics, "pod-metrics",
+		false, "Export cpu, memory and network usage metrics of each pod and container, labeled with namespace, pod and container names, on the metrics endpoint. Usage is read from cgroups when metrics are scraped, and network usage is only available when pod network stats are collected.")
+	fs.StringSliceVar(&c.PodMetricsLabelAllowlist, "pod-metrics-label-allowlist",
+		nil, "Pod and container labels exported as `label_<name>` labels of pod metrics. Other labels are not exported to bound the cardinality of the metrics.")
+	fs.DurationVar(&c.ImagePullTimeout, "image-pull-timeout",
+		0, "Maximum duration of a single image pull, decoupled from the request deadline. 0 means image pulls are only bounded by the request deadline.")
+	fs.DurationVar(&c.ImagePullProgressTimeout, "image-pull-progress-timeout",
+		0, "Maximum duration an image pull is allowed to download nothing before it fails. 0 disables the check.")
+	fs.IntVar(&c.MaxConcurrentImagePulls, "max-concurrent-image-pulls",
+		0, "Maximum number of concurrent image pulls. 0 means no limit. Waiting pulls start in the order of priority: the sandbox image first, then images of pods in system namespaces, then other images.")
+	fs.IntVar(&c.MaxConcurrentDownloads, "max-concurrent-downloads",
+		3, "Maximum number of layers downloaded concurrently by all image pulls. 0 means no limit. Layers are unpacked in order as soon as they are downloaded.")
+	fs.StringSliceVar(&c.ImagePullSystemNamespaces, "image-pull-system-namespaces",
+		[]string{"kube-system"}, "Comma separated namespaces of system pods, whose images are pulled before application images when concurrent image pulls are limited.")
+	fs.StringSliceVar(&c.OCILayoutHostDirs, "oci-layout-host-dirs",
+		nil, "Comma separated `host=dir` pairs. Image `host/name:tag` is pulled from the oci image layout `dir/name` instead of a registry, with tag matching the ref name annotation.")
+	fs.StringSliceVar(&c.ImageRewriteRules, "image-rewrite-rules",
+		nil, "Comma separated `from=to` rules rewriting normalized image references to pull images from, e.g. `k8s.gcr.io/*=registry.internal/k8s/*`. A trailing * matches any suffix. The first matching rule applies, and images are still named with the original reference.")
+	fs.StringSliceVar(&c.RegistryMirrors, "registry-mirrors",
+		nil, "Comma separated `host=endpoint` pairs, e.g. `docker.io=https://mirror.gcr.io`. Images of the host are pulled from its mirrors in the order they are listed, falling back to the host itself. An endpoint is `[scheme://]host[:port]`, https is used unless the scheme is http.")
+	fs.StringSliceVar(&c.RegistryEndpoints, "registry-endpoints",
+		nil, "Comma separated `host=endpoint` pairs. Images of the host are only pulled from the endpoint, without falling back to the host. Endpoints are in the same form as registry mirrors.")
+	fs.StringSliceVar(&c.InsecureRegistries, "insecure-registries",
+		nil, "Comma separated registry and mirror hosts whose tls certificates are not verified. Use an http endpoint for registries served over plain http.")
+	fs.StringVar(&c.RegistryCertsDir, "registry-certs-dir",
+		"/etc/cri-containerd/certs.d", "Directory of registry certificates in the docker certs.d layout. `<dir>/<host>/*.crt` are ca certificates, and `<dir>/<host>/<name>.cert` and `<name>.key` are client certificates of the host.")
+	fs.StringVar(&c.ImageDigestPolicy, "image-digest-policy",
+		"", "Policy of referencing images by digest in PullImage and CreateContainer. `enforce` rejects image references without digest, `resolve` accepts tags but requires them to be resolved to a recorded repo digest. Empty means no policy. The sandbox image is exempt.")
+	fs.BoolVar(&c.ImageStatusVerifyContent, "image-status-verify-content",
+		false, "Verify that all blobs of an image exist in the content store and match their digests the first time ImageStatus returns the image. An image with missing or corrupt blobs is reported as absent and counted in `cri_containerd_corrupt_images_total`, so that kubelet pulls it again. The corrupt blobs are deleted by that pull.")
+	fs.StringVar(&c.ImageRepairPolicy, "image-repair-policy",
+		"", "Policy of repairing an image when its snapshot fails to be prepared in CreateContainer. `unpack` unpacks the image layers from the content store again, `pull` also pulls missing and corrupt blobs from the registry again. Empty means images are not repaired.")
+	fs.StringVar(&c.ImageBuilderAddress, "image-builder-address",
+		"", "Address of buildkitd to build images with, e.g. unix:///run/buildkit/buildkitd.sock. Built images are registered into the CRI image store. Image build is disabled if not set.")
+	fs.StringVar(&c.ImageBuilderBinary, "image-builder-binary",
+		"buildctl", "Path of the buildctl binary driving the image builder.")
+	fs.StringVar(&c.ImageFsPath, "image-fs-path",
+		"/var/lib/containerd", "Path on the filesystem storing images, which is watched by image garbage collection.")
+	fs.IntVar(&c.ImageGCHighThresholdPercent, "image-gc-high-threshold",
+		0, "Image filesystem usage percent above which least recently used images not used by any container are removed. 0 disables image garbage collection.")
+	fs.IntVar(&c.ImageGCLowThresholdPercent, "image-gc-low-threshold",
+		80, "Image filesystem usage percent image garbage collection tries to free space down to.")
+	fs.DurationVar(&c.ImageGCPeriod, "image-gc-period",
+		time.Minute, "Period to check image filesystem usage for image garbage collection.")
+	fs.DurationVar(&c.ContentIngestGCPeriod, "content-ingest-gc-period",
+		10*time.Minute, "Period to abort stale content ingests left by interrupted image pulls, which hold partial blobs and block pulling the content again. They are also aborted at startup. 0 disables it.")
+	fs.DurationVar(&c.ContentIngestMaxAge, "content-ingest-max-age",
+		time.Hour, "Duration a content ingest is not updated, after which it is considered stale and aborted.")
+	fs.StringSliceVar(&c.PinnedImages, "pinned-images",
+		nil, "Images never removed by image garbage collection. The sandbox image is always pinned.")
+	fs.DurationVar(&c.ShutdownGracePeriod, "shutdown-grace-period",
+		30*time.Second, "Grace period given to each container when all pods are stopped with `shutdown-pods`.")
+	fs.StringVar(&c.DefaultProcessPath, "default-process-path",
+		"/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "PATH of container processes if neither the image nor the container config sets it. It is overridden by the pod annotation `io.kubernetes.cri-containerd.default-path`.")
+	fs.StringVar(&c.SeccompDefaultProfile, "seccomp-default-profile",
+		"/etc/cri-containerd/seccomp/default.json", "Path to the docker compatible seccomp profile used for `runtime/default`. A built-in profile is used if it doesn't exist.")
+	fs.StringVar(&c.ApparmorDefaultProfile, "apparmor-default-profile",
+		"/etc/cri-containerd/apparmor/cri-containerd-default", "Path to the apparmor profile `cri-containerd-default` used for `runtime/default`. It is loaded with apparmor_parser at startup if apparmor is enabled and it isn't loaded yet.")
+	fs.StringSliceVar(&c.UnmaskedProcMountNamespaces, "unmasked-proc-mount-namespaces",
+		nil, "Pod namespaces allowed to request unmasked /proc for their containers.")
+	fs.BoolVar(&c.PrivilegedWithoutHostDevices, "privileged-without-host-devices",
+		false, "Do not expose host devices to privileged containers, only devices requested in container config are added. Runtime handlers can override it.")
+	fs.UintSliceVar(&c.DeviceAccessGids, "device-access-gids",
+		nil, "Additional gids added to containers with devices, e.g. gids of the audio and video groups.")
+	fs.StringVar(&c.LocaltimeFile, "localtime-file",
+		"", "Zone file bind mounted readonly to /etc/localtime of containers which don't set TZ, e.g. /etc/localtime. Empty means not to mount localtime.")
+	fs.Int64Var(&c.DefaultCPUShares, "default-cpu-shares",
+		2, "Cpu shares set explicitly in the spec of containers whose resources from the kubelet don't set cpu shares, e.g. containers of best-effort pods, so that they don't depend on the default of the runc version. 0 means the runtime default.")
+	fs.Int64Var(&c.DefaultOOMScoreAdj, "default-oom-score-adj",
+		1000, "Oom score adj set explicitly in the spec of containers whose resources from the kubelet don't set oom score adj, e.g. containers of best-effort pods, so that they are killed first on node oom. 0 means the runtime default.")
+	fs.StringVar(&c.AdmissionPolicyFile, "admission-policy-file",
+		"", "Path to the json admission policy evaluated before running pod sandboxes and creating containers. Empty means no policy.")
+	fs.StringVar(&c.AdmissionWebhook, "admission-webhook",
+		"", "Url admission requests of running pod sandboxes and creating containers are posted to. Requests of dry runs are flagged with `dryRun`, and should not have side effects. Empty means no webhook.")
+	fs.DurationVar(&c.AdmissionWebhookTimeout, "admission-webhook-timeout",
+		5*time.Second, "Timeout of calling the admission webhook.")
+	fs.BoolVar(&c.AdmissionWebhookFailOpen, "admission-webhook-fail-open",
+		false, "Allow requests when the admission webhook can't be reached, instead of rejecting them.")
+	fs.StringVar(&c.NamespaceQuotaFile, "namespace-quota-file",
+		"", "Path to the json file mapping kubernetes namespaces to caps of sandboxes, containers and image bytes, e.g. `{\"ns\": {\"sandboxes\": 10, \"containers\": 50, \"imageBytes\": 10737418240}}`. The `*` namespace applies to namespaces not in the file. Stopped sandboxes and exited containers count until they are removed. The image size is checked against the quota before layers are fetched. Empty means no quota.")
+	fs.StringVar(&c.ImagePrepullManifest, "image-prepull-manifest",
+		"", "Path to the file listing images pre-pulled and never garbage collected, one image reference per line. The file is watched for changes. Empty means no manifest.")
+	fs.DurationVar(&c.ImagePrepullPeriod, "image-prepull-period",
+		time.Minute, "Period to reload the image pre-pull manifest and pull missing images.")
+	fs.StringVar(&c.EnvInjectionFile, "env-injection-file",
+		"", "Path to the json config of environment variables injected into every container, or containers whose config or sandbox config matches annotations, e.g. proxy settings or node identity. Variables are read from the config and node-local env files when the container is created, and are overridden by variables in the container config. Empty means no injection.")
+	fs.StringVar(&c.MountPolicyFile, "mount-policy-file",
+		"", "Path to the json mount policy evaluated when creating containers. Its rules deny host path mounts, e.g. of `/` or `/var/lib/kubelet`, make them read only or rewrite them to other host paths, optionally only for privileged containers or host network pods. Kubelet pod volumes under a denied path are still allowed. Pods with the allow annotation of the policy are exempt. Empty means no policy.")
+	fs.StringVar(&c.RuntimeHandlersFile, "runtime-handlers-file",
+		"", "Path to the json config of runtime handlers, each with low level runc options passed through containerd runtime options, e.g. `noPivotRoot` needed on ramdisk rooted systems, `noNewKeyring`, `shimCgroup` and `criuPath`, and `mounts` of `hostPath`, `containerPath` and `readonly` bind mounted into every container of the handler. Pods select a handler with the `io.kubernetes.cri-containerd.runtime-handler` annotation, and the `default` handler of the config is used otherwise. Empty means containerd runtime defaults.")
+	fs.Int64Var(&c.ContainerLogIndexInterval, "container-log-index-interval",
+		0, "Interval in bytes of checkpoints in the index file written next to each container log. A json `<log>.meta` file with pod and container identity is written when the container starts, and a `<log>.index` file with json lines of time, stream and offset is appended as the log grows, including offsets after the log is truncated by rotation. 0 means container logs are not indexed.")
+	fs.IntVar(&c.ContainerLogRingSize, "container-log-ring-size",
+		0, "Size in bytes of the most recent stdout and stderr log lines of each container kept in memory, available through the `recent-logs` command even after the log file is rotated or lost, until the container is removed. 0 means no log lines are kept.")
+	fs.BoolVar(&c.CoreDumpCapture, "core-dump-capture",
+		false, "Capture core dumps of crashed container processes into the `cores` directory of the pod log directory. The kernel core pattern is set to pipe core dumps into cri-containerd through the debug socket, so core dumps of processes not in any container are discarded.")
+	fs.Int64Var(&c.CoreDumpSizeLimit, "core-dump-size-limit",
+		512*1024*1024, "Maximum size in bytes of a captured core dump. Larger core dumps are truncated.")
+	fs.Int64Var(&c.CoreDumpTotalLimit, "core-dump-total-limit",
+		4*1024*1024*1024, "Maximum total size in bytes of core dumps kept in the pod log directories of the node. Core dumps are truncated or discarded once the limit is reached, until old core dumps are removed. 0 means no limit.")
+	fs.DurationVar(&c.TaskCreateTimeout, "task-create-timeout",
+		0, "Timeout of creating a containerd task for a sandbox or container, which includes running prestart hooks and setting up the rootfs. 0 means no timeout.")
+	fs.DurationVar(&c.TaskStartTimeout, "task-start-timeout",
+		0, "Timeout of starting a containerd task for a sandbox or container. 0 means no timeout.")
+	fs.DurationVar(&c.TaskStopTimeout, "task-stop-timeout",
+		2*time.Minute, "Timeout of waiting for a container to exit after it is killed with SIGKILL.")
+	fs.DurationVar(&c.ExecSetupTimeout, "exec-setup-timeout",
+		0, "Timeout of creating an exec process in a container. It doesn't bound how long the process runs. 0 means no timeout.")
+	fs.BoolVar(&c.ExecSyncDirect, "exec-sync-direct",
+		false, "Run ExecSync commands, e.g. exec liveness and readiness probes, with `runc exec` directly with pipes attached, instead of through the containerd shim and fifos. It cuts the latency and file descriptor churn of each probe on nodes running many probes. Containers must be run by runc with the runc root of --runc-root. ExecSync falls back to containerd if runc can't be started.")
+	fs.StringVar(&c.RuncBinary, "runc-binary",
+		"runc", "The runc binary used by ExecSync with --exec-sync-direct.")
+	fs.StringVar(&c.RuncRoot, "runc-root",
+		"/run/containerd/runc", "Root directory of runc states used by the containerd linux runtime. States of containers are in the subdirectory of the containerd namespace.")
+	fs.DurationVar(&c.TaskDeleteRetryPeriod, "task-delete-retry-period",
+		10*time.Second, "Period of retrying deletion of containerd tasks whose deletion failed, e.g. because the shim is wedged.")
+	fs.IntVar(&c.TaskDeleteRetries, "task-delete-retries",
+		5, "Number of failed retries of deleting a containerd task before its shim is killed and the leftover shim directories are removed.")
+	fs.StringVar(&c.ContainerdRuntimeStateDir, "containerd-runtime-state-dir",
+		"/run/containerd/io.containerd.runtime.v1.linux", "State directory of the containerd linux runtime, which contains shim pid files and task bundles.")
+	fs.StringVar(&c.ContainerdRuntimeRootDir, "containerd-runtime-root-dir",
+		"/var/lib/containerd/io.containerd.runtime.v1.linux", "Root directory of the containerd linux runtime, which contains shim work directories.")
+	fs.BoolVar(&c.HookFailureAsWarning, "hook-failure-as-warning",
+		false, "Start containers without prestart hooks when the hooks fail, and report the failure as a warning in container status. Poststart hook failures always fail the container start.")
+	fs.StringVar(&c.RPCRecordFile, "rpc-record-file",
+		"", "Path to the file which all cri requests are appended to as json lines, with registry credentials and container environment variable values redacted. The file could be replayed with the `replay` command. Empty means no recording.")
+	fs.StringVar(&c.ContainerMemoryMetric, "container-memory-metric",
+		"working-set", "Memory metric reported as the working set bytes of container stats, which is the only memory field of the cri stats. One of `working-set` (usage excluding inactive file cache), `rss` and `usage` (including all file cache).")
+	fs.IntVar(&c.EventWorkers, "event-workers",
+		16, "Number of workers handling containerd events. Events of the same container are handled in order by the same worker.")
 }
 
 // InitFlags must be called after adding all cli options flags are defined and
@@ -71,5 +472,8 @@ func (c *CRIContainerdOptions) AddFlags(fs *pflag.FlagSet) {
 // pflag.Parse().
 func InitFlags() {
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	// Stop parsing at the first sub command, so that sub commands could have
+	// their own flags.
+	pflag.CommandLine.SetInterspersed(false)
 	pflag.Parse()
 }
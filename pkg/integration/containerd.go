@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+const (
+	// containerdStartTimeout is the timeout to wait for containerd to serve.
+	containerdStartTimeout = 30 * time.Second
+	// containerdStopTimeout is the timeout to wait for containerd to exit
+	// before it is killed.
+	containerdStopTimeout = 10 * time.Second
+)
+
+// containerdConfigTemplate is the containerd config of a disposable containerd.
+// All directories and sockets are under the scratch directory, so that it
+// doesn't conflict with containerd running on the host.
+const containerdConfigTemplate = `root = "%[1]s/root"
+state = "%[1]s/state"
+
+[grpc]
+  address = "%[1]s/containerd.sock"
+
+[debug]
+  address = "%[1]s/containerd-debug.sock"
+
+[metrics]
+  address = ""
+`
+
+// Containerd is a disposable containerd with its own root and state
+// directories and socket.
+type Containerd struct {
+	// Dir is the scratch directory of the containerd.
+	Dir string
+	// Address is the containerd grpc socket.
+	Address string
+	// LogPath is the path of the containerd log.
+	LogPath string
+	// cmd is the containerd process.
+	cmd *exec.Cmd
+	// exited is closed when the containerd process exits.
+	exited chan struct{}
+}
+
+// StartContainerd starts a containerd binary with a scratch directory, and
+// waits until it serves.
+func StartContainerd(binary string) (_ *Containerd, retErr error) {
+	dir, err := ioutil.TempDir("", "cri-containerd-integration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %v", err)
+	}
+	defer func() {
+		if retErr != nil {
+			if err := os.RemoveAll(dir); err != nil {
+				glog.Errorf("Failed to remove scratch directory %q: %v", dir, err)
+			}
+		}
+	}()
+	configPath := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(configPath, []byte(fmt.Sprintf(containerdConfigTemplate, dir)), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write containerd config: %v", err)
+	}
+	logPath := filepath.Join(dir, "containerd.log")
+	log, err := os.Create(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create containerd log: %v", err)
+	}
+	defer log.Close()
+
+	cmd := exec.Command(binary, "--config", configPath, "--log-level", "debug")
+	cmd.Stdout = log
+	cmd.Stderr = log
+	// Kill containerd if the test process dies.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start containerd %q: %v", binary, err)
+	}
+	c := &Containerd{
+		Dir:     dir,
+		Address: filepath.Join(dir, "containerd.sock"),
+		LogPath: logPath,
+		cmd:     cmd,
+		exited:  make(chan struct{}),
+	}
+	go func() {
+		cmd.Wait() // nolint: errcheck
+		close(c.exited)
+	}()
+	defer func() {
+		if retErr != nil {
+			c.kill()
+		}
+	}()
+	if err := c.waitServing(containerdStartTimeout); err != nil {
+		return nil, fmt.Errorf("containerd is not serving, see log %q: %v", logPath, err)
+	}
+	return c, nil
+}
+
+// waitServing waits until the containerd serves on its socket.
+func (c *Containerd) waitServing(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		if _, err := os.Stat(c.Address); err == nil {
+			client, err := containerd.New(c.Address)
+			if err != nil {
+				return err
+			}
+			serving, err := client.IsServing(ctx)
+			client.Close() // nolint: errcheck
+			if serving {
+				return nil
+			}
+			glog.V(4).Infof("Containerd is not serving yet: %v", err)
+		}
+		select {
+		case <-c.exited:
+			return fmt.Errorf("containerd exited")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Stop stops the containerd and removes its scratch directory. Mounts left
+// by containers must be cleaned up before.
+func (c *Containerd) Stop() error {
+	if err := c.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		glog.Errorf("Failed to send SIGTERM to containerd: %v", err)
+	}
+	select {
+	case <-c.exited:
+	case <-time.After(containerdStopTimeout):
+		glog.Errorf("Containerd doesn't exit in %v, kill it", containerdStopTimeout)
+		c.kill()
+	}
+	if err := os.RemoveAll(c.Dir); err != nil {
+		return fmt.Errorf("failed to remove scratch directory %q: %v", c.Dir, err)
+	}
+	return nil
+}
+
+// kill kills the containerd and waits for it to exit.
+func (c *Containerd) kill() {
+	if err := c.cmd.Process.Kill(); err != nil {
+		glog.Errorf("Failed to kill containerd: %v", err)
+	}
+	<-c.exited
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package integration provides a framework to run integration tests of
+// cri-containerd against a disposable containerd.
+package integration
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/golang/glog"
+	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/cmd/cri-containerd/options"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/server"
+)
+
+const (
+	// BusyboxImage is the pinned busybox image used by integration tests.
+	BusyboxImage = "docker.io/library/busybox:1.27.2"
+	// criConnectionTimeout is the timeout to connect to cri-containerd.
+	criConnectionTimeout = 10 * time.Second
+	// pollInterval is the interval to poll container state.
+	pollInterval = 100 * time.Millisecond
+)
+
+// Framework runs cri-containerd against a disposable containerd, and serves
+// the cri grpc api on a socket in the containerd scratch directory.
+type Framework struct {
+	// Containerd is the disposable containerd.
+	Containerd *Containerd
+	// Config is the config of cri-containerd.
+	Config options.Config
+	// Service is the cri-containerd service. It could be used to test the
+	// debug handler.
+	Service server.CRIContainerdService
+	// Runtime is the cri runtime service client.
+	Runtime runtime.RuntimeServiceClient
+	// Image is the cri image service client.
+	Image runtime.ImageServiceClient
+	// server is the cri-containerd grpc server.
+	server *server.CRIContainerdServer
+	// conn is the grpc connection to cri-containerd.
+	conn *grpc.ClientConn
+}
+
+// DefaultConfig returns the default cri-containerd config with all files and
+// sockets under dir. Pods should use host network, because no cni network
+// is configured.
+func DefaultConfig(dir string) options.Config {
+	o := options.NewCRIContainerdOptions()
+	// Register flags only to get the default values.
+	o.AddFlags(pflag.NewFlagSet("integration", pflag.ContinueOnError))
+	config := o.Config
+	config.SocketPath = filepath.Join(dir, "cri-containerd.sock")
+	config.DebugSocketPath = filepath.Join(dir, "cri-containerd-debug.sock")
+	config.RootDir = filepath.Join(dir, "cri-containerd")
+	config.NetworkPluginConfDir = filepath.Join(dir, "cni", "net.d")
+	config.NetworkPluginCacheDir = filepath.Join(dir, "cni", "results")
+	config.NetworkPluginMaxConfWait = 0
+	return config
+}
+
+// NewFramework starts a disposable containerd from the containerd binary,
+// and runs cri-containerd against it. The config could be changed with
+// configure functions before cri-containerd is started. Cleanup must be
+// called after the test.
+func NewFramework(containerdBinary string, configure ...func(*options.Config)) (_ *Framework, retErr error) {
+	c, err := StartContainerd(containerdBinary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start containerd: %v", err)
+	}
+	f := &Framework{Containerd: c}
+	defer func() {
+		if retErr != nil {
+			if err := f.Cleanup(); err != nil {
+				glog.Errorf("Failed to cleanup integration framework: %v", err)
+			}
+		}
+	}()
+
+	f.Config = DefaultConfig(c.Dir)
+	f.Config.ContainerdEndpoint = c.Address
+	for _, fn := range configure {
+		fn(&f.Config)
+	}
+	f.Service, err = server.NewCRIContainerdService(f.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cri-containerd service: %v", err)
+	}
+	f.Service.Start()
+	f.server = server.NewCRIContainerdServer(f.Config.SocketPath, f.Service, f.Service, f.Service.UnaryInterceptor)
+	go func() {
+		if err := f.server.Run(); err != nil {
+			glog.Errorf("Failed to run cri-containerd grpc server: %v", err)
+		}
+	}()
+
+	f.conn, err = grpc.Dial(f.Config.SocketPath, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithTimeout(criConnectionTimeout),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to cri-containerd: %v", err)
+	}
+	f.Runtime = runtime.NewRuntimeServiceClient(f.conn)
+	f.Image = runtime.NewImageServiceClient(f.conn)
+	return f, nil
+}
+
+// Cleanup removes all pods, and stops cri-containerd and containerd.
+func (f *Framework) Cleanup() error {
+	if f.Runtime != nil {
+		if err := f.removeAllPods(context.Background()); err != nil {
+			glog.Errorf("Failed to remove all pods: %v", err)
+		}
+	}
+	if f.conn != nil {
+		f.conn.Close() // nolint: errcheck
+	}
+	if f.server != nil {
+		f.server.Stop()
+	}
+	return f.Containerd.Stop()
+}
+
+// removeAllPods stops and removes all pods with their containers.
+func (f *Framework) removeAllPods(ctx context.Context) error {
+	resp, err := f.Runtime.ListPodSandbox(ctx, &runtime.ListPodSandboxRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to list pods: %v", err)
+	}
+	for _, pod := range resp.GetItems() {
+		if _, err := f.Runtime.StopPodSandbox(ctx, &runtime.StopPodSandboxRequest{PodSandboxId: pod.GetId()}); err != nil {
+			return fmt.Errorf("failed to stop pod %q: %v", pod.GetId(), err)
+		}
+		if _, err := f.Runtime.RemovePodSandbox(ctx, &runtime.RemovePodSandboxRequest{PodSandboxId: pod.GetId()}); err != nil {
+			return fmt.Errorf("failed to remove pod %q: %v", pod.GetId(), err)
+		}
+	}
+	return nil
+}
+
+// PullImage pulls the image and returns the image id.
+func (f *Framework) PullImage(ctx context.Context, ref string) (string, error) {
+	resp, err := f.Image.PullImage(ctx, &runtime.PullImageRequest{Image: &runtime.ImageSpec{Image: ref}})
+	if err != nil {
+		return "", fmt.Errorf("failed to pull image %q: %v", ref, err)
+	}
+	return resp.GetImageRef(), nil
+}
+
+// PodSandboxConfig returns a host network pod config with a unique uid.
+func PodSandboxConfig(name, namespace string) *runtime.PodSandboxConfig {
+	return &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{
+			Name:      name,
+			Uid:       stringid.GenerateNonCryptoID(),
+			Namespace: namespace,
+		},
+		Linux: &runtime.LinuxPodSandboxConfig{
+			SecurityContext: &runtime.LinuxSandboxSecurityContext{
+				NamespaceOptions: &runtime.NamespaceOption{HostNetwork: true},
+			},
+		},
+	}
+}
+
+// ContainerConfig returns a busybox container config running the command.
+func ContainerConfig(name string, command ...string) *runtime.ContainerConfig {
+	return &runtime.ContainerConfig{
+		Metadata: &runtime.ContainerMetadata{Name: name},
+		Image:    &runtime.ImageSpec{Image: BusyboxImage},
+		Command:  command,
+	}
+}
+
+// RunPod runs the pod and returns the pod id.
+func (f *Framework) RunPod(ctx context.Context, config *runtime.PodSandboxConfig) (string, error) {
+	resp, err := f.Runtime.RunPodSandbox(ctx, &runtime.RunPodSandboxRequest{Config: config})
+	if err != nil {
+		return "", fmt.Errorf("failed to run pod %q: %v", config.GetMetadata().GetName(), err)
+	}
+	return resp.GetPodSandboxId(), nil
+}
+
+// RunContainer creates and starts the container in the pod, and returns the
+// container id.
+func (f *Framework) RunContainer(ctx context.Context, podID string, podConfig *runtime.PodSandboxConfig,
+	config *runtime.ContainerConfig) (string, error) {
+	resp, err := f.Runtime.CreateContainer(ctx, &runtime.CreateContainerRequest{
+		PodSandboxId:  podID,
+		Config:        config,
+		SandboxConfig: podConfig,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create container %q: %v", config.GetMetadata().GetName(), err)
+	}
+	id := resp.GetContainerId()
+	if _, err := f.Runtime.StartContainer(ctx, &runtime.StartContainerRequest{ContainerId: id}); err != nil {
+		return "", fmt.Errorf("failed to start container %q: %v", id, err)
+	}
+	return id, nil
+}
+
+// WaitContainerState waits until the container is in the state, or the
+// context is done.
+func (f *Framework) WaitContainerState(ctx context.Context, id string, state runtime.ContainerState) (*runtime.ContainerStatus, error) {
+	for {
+		resp, err := f.Runtime.ContainerStatus(ctx, &runtime.ContainerStatusRequest{ContainerId: id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get container %q status: %v", id, err)
+		}
+		if resp.GetStatus().GetState() == state {
+			return resp.GetStatus(), nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("container %q is in state %v instead of %v: %v",
+				id, resp.GetStatus().GetState(), state, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
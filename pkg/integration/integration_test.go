@@ -0,0 +1,70 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+var containerdBinary = flag.String("containerd-binary", "",
+	"Path to the containerd binary run by integration tests. Empty skips integration tests.")
+
+// testTimeout is the timeout of each integration test.
+const testTimeout = 5 * time.Minute
+
+// newTestFramework creates the integration framework, or skips the test if
+// the containerd binary is not specified.
+func newTestFramework(t *testing.T) *Framework {
+	if *containerdBinary == "" {
+		t.Skip("containerd binary is not specified")
+	}
+	f, err := NewFramework(*containerdBinary)
+	require.NoError(t, err)
+	return f
+}
+
+func TestRunContainer(t *testing.T) {
+	f := newTestFramework(t)
+	defer func() {
+		assert.NoError(t, f.Cleanup())
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	t.Logf("Pull busybox image")
+	_, err := f.PullImage(ctx, BusyboxImage)
+	require.NoError(t, err)
+
+	t.Logf("Run pod")
+	podConfig := PodSandboxConfig("run-container", "integration")
+	podID, err := f.RunPod(ctx, podConfig)
+	require.NoError(t, err)
+
+	t.Logf("Run container exiting with code 3")
+	id, err := f.RunContainer(ctx, podID, podConfig, ContainerConfig("exit", "sh", "-c", "exit 3"))
+	require.NoError(t, err)
+	status, err := f.WaitContainerState(ctx, id, runtime.ContainerState_CONTAINER_EXITED)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, status.GetExitCode())
+}
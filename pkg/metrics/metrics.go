@@ -0,0 +1,330 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics implements a minimal metrics registry, which exposes metrics
+// in the prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// gaugeType is the prometheus type of gauge metrics.
+	gaugeType = "gauge"
+	// counterType is the prometheus type of counter metrics.
+	counterType = "counter"
+)
+
+// Metric is a single metric with a float value.
+type Metric interface {
+	// Name returns the name of the metric.
+	Name() string
+	// Help returns the description of the metric.
+	Help() string
+	// Type returns the prometheus type of the metric.
+	Type() string
+	// Value returns the current value of the metric.
+	Value() float64
+}
+
+// desc contains the name and description of a metric.
+type desc struct {
+	name string
+	help string
+}
+
+// Name returns the name of the metric.
+func (d desc) Name() string { return d.name }
+
+// Help returns the description of the metric.
+func (d desc) Help() string { return d.help }
+
+// Gauge is a metric which could go up and down.
+type Gauge struct {
+	desc
+	value int64
+}
+
+// NewGauge creates a gauge.
+func NewGauge(name, help string) *Gauge {
+	return &Gauge{desc: desc{name: name, help: help}}
+}
+
+// Type returns the prometheus type of the gauge.
+func (g *Gauge) Type() string { return gaugeType }
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 { return float64(atomic.LoadInt64(&g.value)) }
+
+// Set sets the gauge to the value.
+func (g *Gauge) Set(v int64) { atomic.StoreInt64(&g.value, v) }
+
+// Add adds the delta to the gauge.
+func (g *Gauge) Add(delta int64) { atomic.AddInt64(&g.value, delta) }
+
+// Inc increments the gauge by 1.
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec decrements the gauge by 1.
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Counter is a metric which could only go up.
+type Counter struct {
+	desc
+	value uint64
+}
+
+// NewCounter creates a counter.
+func NewCounter(name, help string) *Counter {
+	return &Counter{desc: desc{name: name, help: help}}
+}
+
+// Type returns the prometheus type of the counter.
+func (c *Counter) Type() string { return counterType }
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 { return float64(atomic.LoadUint64(&c.value)) }
+
+// Add adds the delta to the counter.
+func (c *Counter) Add(delta uint64) { atomic.AddUint64(&c.value, delta) }
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() { c.Add(1) }
+
+// GaugeFunc is a gauge whose value is computed by a function when it is collected.
+// It is useful for values which are already tracked somewhere else, e.g. store sizes.
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc creates a gauge func.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return &GaugeFunc{desc: desc{name: name, help: help}, fn: fn}
+}
+
+// Type returns the prometheus type of the gauge func.
+func (g *GaugeFunc) Type() string { return gaugeType }
+
+// Value returns the current value of the gauge func.
+func (g *GaugeFunc) Value() float64 { return g.fn() }
+
+// Sample is a value of a labeled metric.
+type Sample struct {
+	// Labels are the label names and values of the sample.
+	Labels map[string]string
+	// Value is the value of the sample.
+	Value float64
+}
+
+// LabeledMetric is a metric with a sample for each set of label values.
+type LabeledMetric interface {
+	Metric
+	// Samples returns the current samples of the metric.
+	Samples() []Sample
+}
+
+// LabeledGaugeFunc is a labeled gauge whose samples are computed by a function
+// when it is collected. It is useful for per sandbox or container values.
+type LabeledGaugeFunc struct {
+	desc
+	fn func() []Sample
+}
+
+// NewLabeledGaugeFunc creates a labeled gauge func.
+func NewLabeledGaugeFunc(name, help string, fn func() []Sample) *LabeledGaugeFunc {
+	return &LabeledGaugeFunc{desc: desc{name: name, help: help}, fn: fn}
+}
+
+// Type returns the prometheus type of the labeled gauge func.
+func (g *LabeledGaugeFunc) Type() string { return gaugeType }
+
+// Value returns the sum of all samples.
+func (g *LabeledGaugeFunc) Value() float64 {
+	var sum float64
+	for _, s := range g.fn() {
+		sum += s.Value
+	}
+	return sum
+}
+
+// Samples returns the current samples of the labeled gauge func.
+func (g *LabeledGaugeFunc) Samples() []Sample { return g.fn() }
+
+// LabeledCounterFunc is a labeled counter whose samples are computed by a
+// function when it is collected. It is useful for cumulative values which are
+// already tracked somewhere else, e.g. cgroup cpu usage.
+type LabeledCounterFunc struct {
+	LabeledGaugeFunc
+}
+
+// NewLabeledCounterFunc creates a labeled counter func.
+func NewLabeledCounterFunc(name, help string, fn func() []Sample) *LabeledCounterFunc {
+	return &LabeledCounterFunc{LabeledGaugeFunc{desc: desc{name: name, help: help}, fn: fn}}
+}
+
+// Type returns the prometheus type of the labeled counter func.
+func (c *LabeledCounterFunc) Type() string { return counterType }
+
+// Collector collects samples of several metrics at once, e.g. with one walk of
+// all containers. The registry calls Collect once per scrape before metrics are
+// written.
+type Collector interface {
+	// Collect updates the samples of the metrics.
+	Collect()
+}
+
+// Snapshot is a collector storing samples of labeled metrics which are
+// collected together, keyed by metric name.
+type Snapshot struct {
+	collect func() map[string][]Sample
+	lock    sync.RWMutex
+	samples map[string][]Sample
+}
+
+// NewSnapshot creates a snapshot collected by the function.
+func NewSnapshot(collect func() map[string][]Sample) *Snapshot {
+	return &Snapshot{collect: collect}
+}
+
+// Collect replaces the samples with newly collected ones.
+func (s *Snapshot) Collect() {
+	samples := s.collect()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples = samples
+}
+
+// Samples returns a function returning the samples of the metric in the last
+// collected snapshot, which could be used by labeled metric funcs.
+func (s *Snapshot) Samples(name string) func() []Sample {
+	return func() []Sample {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+		return s.samples[name]
+	}
+}
+
+// Registry stores all registered metrics.
+// Registry is safe for concurrent access.
+type Registry struct {
+	lock       sync.RWMutex
+	metrics    map[string]Metric
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+// Register registers metrics into the registry. Metric names must be unique.
+func (r *Registry) Register(metrics ...Metric) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, m := range metrics {
+		if _, ok := r.metrics[m.Name()]; ok {
+			return fmt.Errorf("metric %q is already registered", m.Name())
+		}
+		r.metrics[m.Name()] = m
+	}
+	return nil
+}
+
+// MustRegister registers metrics into the registry, and panics on error.
+func (r *Registry) MustRegister(metrics ...Metric) {
+	if err := r.Register(metrics...); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterCollector registers collectors called before each scrape.
+func (r *Registry) RegisterCollector(collectors ...Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Get returns the metric with the name, or nil if it is not registered.
+func (r *Registry) Get(name string) Metric {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.metrics[name]
+}
+
+// ServeHTTP writes all metrics sorted by name in prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	r.lock.RLock()
+	for _, c := range r.collectors {
+		c.Collect()
+	}
+	var names []string
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		m := r.metrics[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, m.Help())
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, m.Type())
+		if lm, ok := m.(LabeledMetric); ok {
+			for _, s := range lm.Samples() {
+				fmt.Fprintf(&buf, "%s%s %s\n", name, formatLabels(s.Labels), formatValue(s.Value))
+			}
+			continue
+		}
+		fmt.Fprintf(&buf, "%s %s\n", name, formatValue(m.Value()))
+	}
+	r.lock.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes()) // nolint: errcheck
+}
+
+// formatValue formats a metric value in prometheus text format.
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatLabels formats labels sorted by name in prometheus text format,
+// e.g. `{a="1",b="2"}`.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s=%q", name, labels[name])
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
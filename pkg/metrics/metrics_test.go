@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	assert := assertlib.New(t)
+	gauge := NewGauge("test_gauge", "Test gauge.")
+	counter := NewCounter("test_counter", "Test counter.")
+	gaugeFunc := NewGaugeFunc("test_gauge_func", "Test gauge func.", func() float64 { return 1.5 })
+
+	t.Logf("should be able to register metrics")
+	assert.NoError(r.Register(gauge, counter, gaugeFunc))
+
+	t.Logf("should not be able to register duplicated metric")
+	assert.Error(r.Register(NewGauge("test_gauge", "Duplicated gauge.")))
+
+	t.Logf("should be able to get registered metric")
+	assert.Equal(gauge, r.Get("test_gauge"))
+	assert.Nil(r.Get("not_exist"))
+
+	t.Logf("should be able to update metrics")
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+	counter.Add(3)
+	assert.Equal(float64(1), gauge.Value())
+	assert.Equal(float64(3), counter.Value())
+
+	t.Logf("should write metrics in prometheus text format")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(`# HELP test_counter Test counter.
+# TYPE test_counter counter
+test_counter 3
+# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge 1
+# HELP test_gauge_func Test gauge func.
+# TYPE test_gauge_func gauge
+test_gauge_func 1.5
+`, w.Body.String())
+}
+
+func TestLabeledGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	assert := assertlib.New(t)
+	g := NewLabeledGaugeFunc("test_labeled_gauge", "Test labeled gauge.", func() []Sample {
+		return []Sample{
+			{Labels: map[string]string{"id": "1", "name": "a\"b"}, Value: 2},
+			{Labels: map[string]string{"id": "2"}, Value: 3},
+		}
+	})
+	assert.NoError(r.Register(g))
+	assert.Equal(float64(5), g.Value())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(`# HELP test_labeled_gauge Test labeled gauge.
+# TYPE test_labeled_gauge gauge
+test_labeled_gauge{id="1",name="a\"b"} 2
+test_labeled_gauge{id="2"} 3
+`, w.Body.String())
+}
+
+func TestLabeledCounterFunc(t *testing.T) {
+	r := NewRegistry()
+	assert := assertlib.New(t)
+	c := NewLabeledCounterFunc("test_labeled_counter_total", "Test labeled counter.", func() []Sample {
+		return []Sample{{Labels: map[string]string{"id": "1"}, Value: 1.5}}
+	})
+	assert.NoError(r.Register(c))
+	assert.Equal(float64(1.5), c.Value())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(`# HELP test_labeled_counter_total Test labeled counter.
+# TYPE test_labeled_counter_total counter
+test_labeled_counter_total{id="1"} 1.5
+`, w.Body.String())
+}
+
+func TestSnapshot(t *testing.T) {
+	r := NewRegistry()
+	assert := assertlib.New(t)
+	collected := 0
+	s := NewSnapshot(func() map[string][]Sample {
+		collected++
+		return map[string][]Sample{
+			"test_a": {{Labels: map[string]string{"id": "1"}, Value: float64(collected)}},
+			"test_b": {{Labels: map[string]string{"id": "1"}, Value: float64(collected * 10)}},
+		}
+	})
+	assert.NoError(r.Register(
+		NewLabeledGaugeFunc("test_a", "Test a.", s.Samples("test_a")),
+		NewLabeledGaugeFunc("test_b", "Test b.", s.Samples("test_b")),
+	))
+	r.RegisterCollector(s)
+
+	t.Logf("should not collect before scrape")
+	assert.Equal(0, collected)
+	assert.Empty(s.Samples("test_a")())
+
+	t.Logf("should collect once per scrape")
+	for i := 1; i <= 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(i, collected)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(`# HELP test_a Test a.
+# TYPE test_a gauge
+test_a{id="1"} 3
+# HELP test_b Test b.
+# TYPE test_b gauge
+test_b{id="1"} 30
+`, w.Body.String())
+}
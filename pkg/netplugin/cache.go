@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// cacheEntry is the cached cni result of a pod network. The network
+// configuration is cached as well, so that the pod can be torn down with the
+// configuration it is set up with, even if the configuration has been changed.
+type cacheEntry struct {
+	ContainerID string          `json:"containerId"`
+	NetworkName string          `json:"networkName"`
+	IfName      string          `json:"ifName"`
+	Config      []byte          `json:"config"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// resultCache persists cni results on disk, one file per pod network.
+type resultCache struct {
+	dir string
+}
+
+func (r *resultCache) path(networkName, id, ifName string) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s-%s-%s", networkName, id, ifName))
+}
+
+// find returns the path of the cached entry of the pod interface, regardless
+// of the network name, or "" if it doesn't exist.
+func (r *resultCache) find(id, ifName string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(r.dir, fmt.Sprintf("*-%s-%s", id, ifName)))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", nil
+	}
+	return matches[0], nil
+}
+
+// get returns the cached entry of the pod interface, or nil if it doesn't exist.
+func (r *resultCache) get(id, ifName string) (*cacheEntry, error) {
+	path, err := r.find(id, ifName)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cached result %q: %v", path, err)
+	}
+	return &entry, nil
+}
+
+// add writes the entry into the cache atomically.
+func (r *resultCache) add(entry *cacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %v", err)
+	}
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache dir %q: %v", r.dir, err)
+	}
+	path := r.path(entry.NetworkName, entry.ContainerID, entry.IfName)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cache file %q: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return fmt.Errorf("failed to rename cache file %q: %v", tmp, err)
+	}
+	return nil
+}
+
+// remove removes the cached entry of the pod interface. It is a no-op if
+// the entry doesn't exist.
+func (r *resultCache) remove(id, ifName string) error {
+	path, err := r.find(id, ifName)
+	if err != nil || path == "" {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
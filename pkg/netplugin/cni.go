@@ -0,0 +1,558 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netplugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/golang/glog"
+)
+
+// vendorCNIDirTemplate is the template for looking up vendor specific cni binaries.
+const vendorCNIDirTemplate = "/opt/%s/bin"
+
+// loopbackNetworkConfig is the configuration of the loopback network.
+const loopbackNetworkConfig = `{
+  "cniVersion": "0.1.0",
+  "name": "cni-loopback",
+  "type": "loopback"
+}`
+
+var errUninitialized = errors.New("cni config uninitialized")
+
+// execFunc executes the plugin binary with the given stdin and environment,
+// and returns the stdout.
+type execFunc func(pluginPath string, stdin []byte, environ []string) ([]byte, error)
+
+type cniNetworkPlugin struct {
+	sync.RWMutex
+	defaultNetwork *libcni.NetworkConfig
+
+	loNetwork   *libcni.NetworkConfig
+	nsenterPath string
+	confDir     string
+	binDirs     []string
+	cache       *resultCache
+	exec        execFunc
+	hairpinMode string
+	retries     int
+	backoff     time.Duration
+	// waitUntil is the end of the grace period for the network configuration.
+	waitUntil time.Time
+	// netOps are the network operations done directly instead of via plugins.
+	netOps netOps
+	// hostVeths caches the host side veth of pods, keyed by pod id.
+	hostVethsLock sync.Mutex
+	hostVeths     map[string]string
+	// confTemplate is the path of the network configuration template.
+	confTemplate string
+	// podCIDRLock protects podCIDR.
+	podCIDRLock sync.Mutex
+	// podCIDR is the pod cidr the template was last rendered with.
+	podCIDR string
+}
+
+// InitCNI creates the cni network plugin with the given configuration.
+func InitCNI(config Config) (CNIPlugin, error) {
+	if config.ConfDir == "" {
+		config.ConfDir = DefaultNetDir
+	}
+	if config.CacheDir == "" {
+		config.CacheDir = DefaultCacheDir
+	}
+	if len(config.BinDirs) == 0 {
+		config.BinDirs = []string{DefaultCNIDir}
+	}
+	switch config.HairpinMode {
+	case "":
+		config.HairpinMode = HairpinNone
+	case HairpinVeth, PromiscuousBridge, HairpinNone:
+	default:
+		return nil, fmt.Errorf("unknown hairpin mode %q", config.HairpinMode)
+	}
+	nsenterPath, err := exec.LookPath("nsenter")
+	if err != nil {
+		return nil, err
+	}
+	lo, err := libcni.ConfFromBytes([]byte(loopbackNetworkConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load loopback network config: %v", err)
+	}
+	plugin := &cniNetworkPlugin{
+		loNetwork:    lo,
+		nsenterPath:  nsenterPath,
+		confDir:      config.ConfDir,
+		binDirs:      config.BinDirs,
+		cache:        &resultCache{dir: config.CacheDir},
+		waitUntil:    time.Now().Add(config.MaxConfWait),
+		hairpinMode:  config.HairpinMode,
+		retries:      config.SetUpRetries,
+		backoff:      config.SetUpBackoff,
+		exec:         (&invoke.RawExec{Stderr: os.Stderr}).ExecPlugin,
+		netOps:       &nsenterNetOps{nsenterPath: nsenterPath},
+		hostVeths:    make(map[string]string),
+		confTemplate: config.ConfTemplate,
+	}
+	// Load the network config in best effort, it is reloaded until it succeeds.
+	if err := plugin.syncNetworkConfig(); err != nil {
+		glog.Warningf("Failed to load cni network config: %v", err)
+	}
+	return plugin, nil
+}
+
+// syncNetworkConfig loads the first valid network configuration in the conf dir.
+func (plugin *cniNetworkPlugin) syncNetworkConfig() error {
+	files, err := libcni.ConfFiles(plugin.confDir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no network config found in %s", plugin.confDir)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		conf, err := libcni.ConfFromFile(file)
+		if err != nil {
+			glog.Warningf("Failed to load cni config file %q: %v", file, err)
+			continue
+		}
+		plugin.Lock()
+		plugin.defaultNetwork = conf
+		plugin.Unlock()
+		return nil
+	}
+	return fmt.Errorf("no valid network config found in %s", plugin.confDir)
+}
+
+// getDefaultNetwork returns the default network, and tries to load it if it
+// is not loaded yet.
+func (plugin *cniNetworkPlugin) getDefaultNetwork() (*libcni.NetworkConfig, error) {
+	plugin.RLock()
+	network := plugin.defaultNetwork
+	plugin.RUnlock()
+	if network != nil {
+		return network, nil
+	}
+	if err := plugin.syncNetworkConfig(); err != nil {
+		glog.V(4).Infof("Failed to load cni network config: %v", err)
+		return nil, errUninitialized
+	}
+	plugin.RLock()
+	defer plugin.RUnlock()
+	return plugin.defaultNetwork, nil
+}
+
+// Name returns the name of the plugin.
+func (plugin *cniNetworkPlugin) Name() string {
+	return CNIPluginName
+}
+
+// SetUpPod brings up the loopback interface, adds the pod into the default
+// network, and caches the result of the default network.
+func (plugin *cniNetworkPlugin) SetUpPod(network PodNetwork) error {
+	if err := plugin.setUpLoopback(network); err != nil {
+		return err
+	}
+	conf, err := plugin.getDefaultNetwork()
+	if err != nil {
+		return err
+	}
+	result, err := plugin.addToNetwork(conf, network)
+	if err != nil {
+		return fmt.Errorf("failed to add pod to cni network %q: %v", conf.Network.Name, err)
+	}
+	if err := plugin.cache.add(&cacheEntry{
+		ContainerID: network.ID,
+		NetworkName: conf.Network.Name,
+		IfName:      DefaultInterfaceName,
+		Config:      conf.Bytes,
+		Result:      result,
+	}); err != nil {
+		plugin.deleteFromNetwork(conf, network)
+		return fmt.Errorf("failed to cache cni result: %v", err)
+	}
+	if network.QoS != nil {
+		if err := plugin.netOps.setQoS(network.NetNS, DefaultInterfaceName, *network.QoS); err != nil {
+			return fmt.Errorf("failed to set network qos: %v", err)
+		}
+	}
+	// Hairpin is best effort, pods still work without it.
+	if err := plugin.setUpHairpin(conf, network); err != nil {
+		glog.Warningf("Failed to set up hairpin mode %q for pod %q: %v", plugin.hairpinMode, network.ID, err)
+	}
+	return nil
+}
+
+// addToNetwork runs ADD of the network, and retries with exponential backoff
+// on failure. Partial setup of each failed attempt is cleaned up with DEL.
+func (plugin *cniNetworkPlugin) addToNetwork(conf *libcni.NetworkConfig, network PodNetwork) ([]byte, error) {
+	backoff := plugin.backoff
+	for attempt := 0; ; attempt++ {
+		result, err := plugin.execPlugin("ADD", conf, conf.Bytes, network, DefaultInterfaceName)
+		if err == nil {
+			return result, nil
+		}
+		plugin.deleteFromNetwork(conf, network)
+		if attempt >= plugin.retries {
+			return nil, err
+		}
+		glog.Warningf("Failed to add pod %q to cni network %q, retry in %v: %v",
+			network.ID, conf.Network.Name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// deleteFromNetwork runs DEL of the network to clean up partial setup in best effort.
+func (plugin *cniNetworkPlugin) deleteFromNetwork(conf *libcni.NetworkConfig, network PodNetwork) {
+	if _, err := plugin.execPlugin("DEL", conf, conf.Bytes, network, DefaultInterfaceName); err != nil {
+		glog.Warningf("Failed to clean up pod %q from cni network %q: %v", network.ID, conf.Network.Name, err)
+	}
+}
+
+// setUpHairpin configures the pod to be able to reach itself through service
+// vips according to the hairpin mode.
+func (plugin *cniNetworkPlugin) setUpHairpin(conf *libcni.NetworkConfig, network PodNetwork) error {
+	switch plugin.hairpinMode {
+	case HairpinVeth:
+		return plugin.netOps.setHairpinVeth(network.NetNS, DefaultInterfaceName)
+	case PromiscuousBridge:
+		bridge, err := getBridgeName(conf)
+		if err != nil {
+			return err
+		}
+		if bridge == "" {
+			return fmt.Errorf("network %q is not a bridge network", conf.Network.Name)
+		}
+		return plugin.netOps.setBridgePromisc(bridge)
+	}
+	return nil
+}
+
+// getBridgeName returns the bridge of the bridge plugin network, or "" for
+// other plugins.
+func getBridgeName(conf *libcni.NetworkConfig) (string, error) {
+	if conf.Network.Type != "bridge" {
+		return "", nil
+	}
+	var c struct {
+		Bridge string `json:"bridge"`
+	}
+	if err := json.Unmarshal(conf.Bytes, &c); err != nil {
+		return "", fmt.Errorf("failed to unmarshal bridge network config: %v", err)
+	}
+	if c.Bridge == "" {
+		// Default bridge of the bridge plugin.
+		return "cni0", nil
+	}
+	return c.Bridge, nil
+}
+
+// setUpLoopback brings up the loopback interface of the pod, regardless of
+// whether the default network configures it. The loopback plugin is used if
+// it is installed, otherwise the interface is brought up directly.
+func (plugin *cniNetworkPlugin) setUpLoopback(network PodNetwork) error {
+	if _, err := plugin.findPlugin(plugin.loNetwork.Network.Type); err != nil {
+		glog.V(4).Infof("Loopback plugin not found, bring up lo directly for pod %q: %v", network.ID, err)
+		if err := plugin.netOps.setLoopbackUp(network.NetNS); err != nil {
+			return fmt.Errorf("failed to bring up loopback interface: %v", err)
+		}
+		return nil
+	}
+	if _, err := plugin.execPlugin("ADD", plugin.loNetwork, plugin.loNetwork.Bytes, network, "lo"); err != nil {
+		return fmt.Errorf("failed to add pod to cni loopback network: %v", err)
+	}
+	return nil
+}
+
+// TearDownPod removes the pod from the network it is set up with. The cached
+// configuration and result are used if there is one, so that the teardown works
+// across daemon restarts and configuration changes.
+func (plugin *cniNetworkPlugin) TearDownPod(network PodNetwork) error {
+	entry, err := plugin.cache.get(network.ID, DefaultInterfaceName)
+	if err != nil {
+		glog.Warningf("Failed to get cached cni result for %q: %v", network.ID, err)
+	}
+	var conf *libcni.NetworkConfig
+	var stdin []byte
+	if entry != nil {
+		conf, err = libcni.ConfFromBytes(entry.Config)
+		if err != nil {
+			return fmt.Errorf("failed to load cached network config: %v", err)
+		}
+		stdin = conf.Bytes
+		if supportsCheck(conf.Network.CNIVersion) && len(entry.Result) > 0 {
+			if stdin, err = injectPrevResult(conf.Bytes, entry.Result); err != nil {
+				return err
+			}
+		}
+	} else {
+		if conf, err = plugin.getDefaultNetwork(); err != nil {
+			return err
+		}
+		stdin = conf.Bytes
+	}
+	if _, err := plugin.execPlugin("DEL", conf, stdin, network, DefaultInterfaceName); err != nil {
+		return fmt.Errorf("failed to remove pod from cni network %q: %v", conf.Network.Name, err)
+	}
+	plugin.hostVethsLock.Lock()
+	delete(plugin.hostVeths, network.ID)
+	plugin.hostVethsLock.Unlock()
+	if err := plugin.cache.remove(network.ID, DefaultInterfaceName); err != nil {
+		return fmt.Errorf("failed to remove cached cni result: %v", err)
+	}
+	return nil
+}
+
+// CheckPod runs CHECK against the cached result. Pods without cached result and
+// networks with cni version older than 0.4.0 are not checked.
+func (plugin *cniNetworkPlugin) CheckPod(network PodNetwork) error {
+	entry, err := plugin.cache.get(network.ID, DefaultInterfaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get cached cni result: %v", err)
+	}
+	if entry == nil {
+		return nil
+	}
+	conf, err := libcni.ConfFromBytes(entry.Config)
+	if err != nil {
+		return fmt.Errorf("failed to load cached network config: %v", err)
+	}
+	if !supportsCheck(conf.Network.CNIVersion) {
+		return nil
+	}
+	stdin, err := injectPrevResult(conf.Bytes, entry.Result)
+	if err != nil {
+		return err
+	}
+	if _, err := plugin.execPlugin("CHECK", conf, stdin, network, DefaultInterfaceName); err != nil {
+		return fmt.Errorf("failed to check pod in cni network %q: %v", conf.Network.Name, err)
+	}
+	return nil
+}
+
+// GetPodNetworkStatus returns the ip of the pod from the cached result, and
+// falls back to inspect the network namespace.
+func (plugin *cniNetworkPlugin) GetPodNetworkStatus(network PodNetwork) (string, error) {
+	entry, err := plugin.cache.get(network.ID, DefaultInterfaceName)
+	if err != nil {
+		glog.Warningf("Failed to get cached cni result for %q: %v", network.ID, err)
+	}
+	if entry != nil {
+		result, err := parseResult(entry.Result)
+		if err != nil {
+			glog.Warningf("Failed to parse cached cni result for %q: %v", network.ID, err)
+		} else if ip := result.IP(); ip != "" {
+			return ip, nil
+		}
+	}
+	ip, err := getContainerIP(plugin.nsenterPath, network.NetNS, DefaultInterfaceName, "-4")
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// GetPodNetworkResult returns the cached cni result of the pod.
+func (plugin *cniNetworkPlugin) GetPodNetworkResult(network PodNetwork) (*Result, error) {
+	entry, err := plugin.cache.get(network.ID, DefaultInterfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached cni result: %v", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return parseResult(entry.Result)
+}
+
+// GetPodNetworkStats returns the counters of the pod interface. The host side
+// veth counters are swapped, because traffic received by the veth is sent by
+// the pod.
+func (plugin *cniNetworkPlugin) GetPodNetworkStats(network PodNetwork) (*InterfaceStats, error) {
+	plugin.hostVethsLock.Lock()
+	veth, ok := plugin.hostVeths[network.ID]
+	plugin.hostVethsLock.Unlock()
+	if !ok {
+		var err error
+		veth, err = plugin.netOps.getHostVeth(network.NetNS, DefaultInterfaceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get host veth: %v", err)
+		}
+		plugin.hostVethsLock.Lock()
+		plugin.hostVeths[network.ID] = veth
+		plugin.hostVethsLock.Unlock()
+	}
+	stats, err := plugin.netOps.getHostInterfaceStats(veth)
+	if err != nil {
+		// The veth may be recreated, look it up again next time.
+		plugin.hostVethsLock.Lock()
+		delete(plugin.hostVeths, network.ID)
+		plugin.hostVethsLock.Unlock()
+		return nil, fmt.Errorf("failed to get stats of host veth %q: %v", veth, err)
+	}
+	return &InterfaceStats{
+		RxBytes:   stats.TxBytes,
+		RxPackets: stats.TxPackets,
+		RxDropped: stats.TxDropped,
+		TxBytes:   stats.RxBytes,
+		TxPackets: stats.RxPackets,
+		TxDropped: stats.RxDropped,
+	}, nil
+}
+
+// Status returns error if the network config is not loaded. ErrWaitingForConfig
+// is returned during the grace period after startup.
+func (plugin *cniNetworkPlugin) Status() error {
+	if _, err := plugin.getDefaultNetwork(); err != nil {
+		if time.Now().Before(plugin.waitUntil) {
+			return ErrWaitingForConfig
+		}
+		return err
+	}
+	return nil
+}
+
+// templateData is the data the network configuration template is rendered with.
+type templateData struct {
+	// PodCIDR is the cidr of the pods on the node.
+	PodCIDR string
+}
+
+// UpdatePodCIDR renders the network configuration template with the pod cidr
+// into the conf dir, and reloads the network configuration.
+func (plugin *cniNetworkPlugin) UpdatePodCIDR(cidr string) error {
+	if plugin.confTemplate == "" || cidr == "" {
+		return nil
+	}
+	plugin.podCIDRLock.Lock()
+	defer plugin.podCIDRLock.Unlock()
+	if cidr == plugin.podCIDR {
+		return nil
+	}
+	t, err := template.ParseFiles(plugin.confTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse network config template %q: %v", plugin.confTemplate, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, templateData{PodCIDR: cidr}); err != nil {
+		return fmt.Errorf("failed to render network config template %q: %v", plugin.confTemplate, err)
+	}
+	if _, err := libcni.ConfFromBytes(buf.Bytes()); err != nil {
+		return fmt.Errorf("invalid network config rendered from template %q: %v", plugin.confTemplate, err)
+	}
+	if err := os.MkdirAll(plugin.confDir, 0755); err != nil {
+		return fmt.Errorf("failed to create network config dir %q: %v", plugin.confDir, err)
+	}
+	// Write to a temporary file first, so that the plugin never loads a
+	// partially written configuration.
+	path := filepath.Join(plugin.confDir, TemplateConfFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write network config %q: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return fmt.Errorf("failed to rename network config %q to %q: %v", tmp, path, err)
+	}
+	if err := plugin.syncNetworkConfig(); err != nil {
+		return fmt.Errorf("failed to reload network config: %v", err)
+	}
+	plugin.podCIDR = cidr
+	glog.V(2).Infof("Updated network config %q with pod cidr %q", path, cidr)
+	return nil
+}
+
+// execPlugin executes the plugin of the network with the given command, and
+// returns the stdout.
+func (plugin *cniNetworkPlugin) execPlugin(command string, conf *libcni.NetworkConfig, stdin []byte,
+	network PodNetwork, ifName string) ([]byte, error) {
+	pluginPath, err := plugin.findPlugin(conf.Network.Type)
+	if err != nil {
+		return nil, err
+	}
+	stdin, err = injectRuntimeConfig(conf, stdin, network)
+	if err != nil {
+		return nil, err
+	}
+	args := &invoke.Args{
+		Command:     command,
+		ContainerID: network.ID,
+		NetNS:       network.NetNS,
+		PluginArgs:  buildCNIArgs(network),
+		IfName:      ifName,
+		Path:        strings.Join(plugin.pluginPaths(conf.Network.Type), ":"),
+	}
+	glog.V(4).Infof("Run cni plugin %q with command %s for pod %q", pluginPath, command, network.ID)
+	return plugin.exec(pluginPath, stdin, args.AsEnv())
+}
+
+// pluginPaths returns the directories to look up the plugin binary in.
+func (plugin *cniNetworkPlugin) pluginPaths(pluginType string) []string {
+	return append(append([]string{}, plugin.binDirs...), fmt.Sprintf(vendorCNIDirTemplate, pluginType))
+}
+
+// findPlugin returns the path of the plugin binary.
+func (plugin *cniNetworkPlugin) findPlugin(pluginType string) (string, error) {
+	return invoke.FindInPath(pluginType, plugin.pluginPaths(pluginType))
+}
+
+// injectRuntimeConfig sets the runtimeConfig of the capabilities declared
+// by the network configuration.
+func injectRuntimeConfig(conf *libcni.NetworkConfig, stdin []byte, network PodNetwork) ([]byte, error) {
+	var c struct {
+		Capabilities map[string]bool `json:"capabilities"`
+	}
+	if err := json.Unmarshal(conf.Bytes, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal network config capabilities: %v", err)
+	}
+	runtimeConfig := map[string]interface{}{}
+	if c.Capabilities[PodAnnotationsCapability] {
+		annotations := network.Annotations
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		runtimeConfig[PodAnnotationsCapability] = annotations
+	}
+	if len(runtimeConfig) == 0 {
+		return stdin, nil
+	}
+	return injectConf(stdin, "runtimeConfig", runtimeConfig)
+}
+
+// buildCNIArgs returns the CNI_ARGS of the pod.
+func buildCNIArgs(network PodNetwork) [][2]string {
+	return [][2]string{
+		{"IgnoreUnknown", "1"},
+		{"K8S_POD_NAMESPACE", network.Namespace},
+		{"K8S_POD_NAME", network.Name},
+		{"K8S_POD_INFRA_CONTAINER_ID", network.ID},
+	}
+}
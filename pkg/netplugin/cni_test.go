@@ -0,0 +1,644 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containernetworking/cni/libcni"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExecCall struct {
+	command string
+	stdin   []byte
+}
+
+// newTestCNIPlugin creates a cni plugin with fake plugin binaries of the given
+// types, and records all plugin calls.
+func newTestCNIPlugin(t *testing.T, conf string, result string, types ...string) (*cniNetworkPlugin, *[]fakeExecCall, func()) {
+	dir, err := ioutil.TempDir("", "test-cni")
+	require.NoError(t, err)
+	binDir := filepath.Join(dir, "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	for _, typ := range types {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(binDir, typ), nil, 0755))
+	}
+	network, err := libcni.ConfFromBytes([]byte(conf))
+	require.NoError(t, err)
+	lo, err := libcni.ConfFromBytes([]byte(loopbackNetworkConfig))
+	require.NoError(t, err)
+	var calls []fakeExecCall
+	plugin := &cniNetworkPlugin{
+		defaultNetwork: network,
+		loNetwork:      lo,
+		confDir:        filepath.Join(dir, "net.d"),
+		binDirs:        []string{binDir},
+		cache:          &resultCache{dir: filepath.Join(dir, "results")},
+		exec: func(pluginPath string, stdin []byte, environ []string) ([]byte, error) {
+			var command string
+			for _, e := range environ {
+				if strings.HasPrefix(e, "CNI_COMMAND=") {
+					command = strings.TrimPrefix(e, "CNI_COMMAND=")
+				}
+			}
+			calls = append(calls, fakeExecCall{command: command, stdin: stdin})
+			if command == "ADD" && filepath.Base(pluginPath) != "loopback" {
+				return []byte(result), nil
+			}
+			return nil, nil
+		},
+		hairpinMode: HairpinNone,
+		hostVeths:   make(map[string]string),
+	}
+	plugin.netOps = &fakeNetOps{calls: &calls}
+	return plugin, &calls, func() { os.RemoveAll(dir) } // nolint: errcheck
+}
+
+// fakeNetOps records network operations as exec calls.
+type fakeNetOps struct {
+	calls *[]fakeExecCall
+}
+
+func (f *fakeNetOps) setLoopbackUp(netNS string) error {
+	*f.calls = append(*f.calls, fakeExecCall{command: "LOOPBACK_UP"})
+	return nil
+}
+
+func (f *fakeNetOps) setHairpinVeth(netNS, ifName string) error {
+	*f.calls = append(*f.calls, fakeExecCall{command: "HAIRPIN_VETH", stdin: []byte(ifName)})
+	return nil
+}
+
+func (f *fakeNetOps) setBridgePromisc(bridge string) error {
+	*f.calls = append(*f.calls, fakeExecCall{command: "BRIDGE_PROMISC", stdin: []byte(bridge)})
+	return nil
+}
+
+func (f *fakeNetOps) setQoS(netNS, ifName string, qos NetworkQoS) error {
+	*f.calls = append(*f.calls, fakeExecCall{command: "QOS", stdin: []byte(fmt.Sprintf("%s %v", ifName, qosRules(ifName, qos)))})
+	return nil
+}
+
+func (f *fakeNetOps) getHostVeth(netNS, ifName string) (string, error) {
+	*f.calls = append(*f.calls, fakeExecCall{command: "HOST_VETH", stdin: []byte(ifName)})
+	return "veth1234", nil
+}
+
+func (f *fakeNetOps) getHostInterfaceStats(name string) (*InterfaceStats, error) {
+	*f.calls = append(*f.calls, fakeExecCall{command: "HOST_STATS", stdin: []byte(name)})
+	return &InterfaceStats{RxBytes: 1, RxPackets: 2, RxDropped: 3, TxBytes: 4, TxPackets: 5, TxDropped: 6}, nil
+}
+
+func TestCNIPluginResultCache(t *testing.T) {
+	const result = `{"cniVersion":"0.4.0","ips":[{"version":"4","address":"10.1.2.3/24"}]}`
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id", NetNS: "/test/netns"}
+	for desc, test := range map[string]struct {
+		conf        string
+		expectCheck bool
+	}{
+		"0.4.0 network should be checked and deleted with prevResult": {
+			conf:        `{"cniVersion":"0.4.0","name":"test-net","type":"bridge"}`,
+			expectCheck: true,
+		},
+		"0.3.1 network should not be checked": {
+			conf: `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		plugin, calls, cleanup := newTestCNIPlugin(t, test.conf, result, "loopback", "bridge", "other")
+		defer cleanup()
+
+		require.NoError(t, plugin.SetUpPod(network))
+		require.Len(t, *calls, 2)
+		entry, err := plugin.cache.get(network.ID, DefaultInterfaceName)
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+		assert.Equal(t, "test-net", entry.NetworkName)
+		assert.JSONEq(t, result, string(entry.Result))
+
+		ip, err := plugin.GetPodNetworkStatus(network)
+		require.NoError(t, err)
+		assert.Equal(t, "10.1.2.3", ip)
+		r, err := plugin.GetPodNetworkResult(network)
+		require.NoError(t, err)
+		require.NotNil(t, r)
+		require.Len(t, r.IPs, 1)
+		assert.Equal(t, "10.1.2.3/24", r.IPs[0].Address)
+
+		// Change the network config, teardown should still use the cached one.
+		changed, err := libcni.ConfFromBytes([]byte(`{"cniVersion":"0.4.0","name":"other-net","type":"other"}`))
+		require.NoError(t, err)
+		plugin.defaultNetwork = changed
+
+		*calls = nil
+		require.NoError(t, plugin.CheckPod(network))
+		if test.expectCheck {
+			require.Len(t, *calls, 1)
+			assert.Equal(t, "CHECK", (*calls)[0].command)
+			assertPrevResult(t, (*calls)[0].stdin, result)
+		} else {
+			assert.Empty(t, *calls)
+		}
+
+		*calls = nil
+		require.NoError(t, plugin.TearDownPod(network))
+		require.Len(t, *calls, 1)
+		assert.Equal(t, "DEL", (*calls)[0].command)
+		if test.expectCheck {
+			assertPrevResult(t, (*calls)[0].stdin, result)
+		} else {
+			assert.Equal(t, []byte(test.conf), (*calls)[0].stdin)
+		}
+		entry, err = plugin.cache.get(network.ID, DefaultInterfaceName)
+		assert.NoError(t, err)
+		assert.Nil(t, entry)
+		r, err = plugin.GetPodNetworkResult(network)
+		assert.NoError(t, err)
+		assert.Nil(t, r)
+
+		// Teardown should be idempotent, and fall back to the current config.
+		*calls = nil
+		require.NoError(t, plugin.TearDownPod(network))
+		require.Len(t, *calls, 1)
+		assert.Equal(t, changed.Bytes, (*calls)[0].stdin)
+	}
+}
+
+func TestCNIPluginSetUpLoopback(t *testing.T) {
+	const conf = `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id", NetNS: "/test/netns"}
+	for desc, test := range map[string]struct {
+		types          []string
+		noNetwork      bool
+		expectCommands []string
+		expectErr      bool
+	}{
+		"loopback plugin should be used if installed": {
+			types:          []string{"loopback", "bridge"},
+			expectCommands: []string{"ADD", "ADD"},
+		},
+		"loopback should be brought up directly without loopback plugin": {
+			types:          []string{"bridge"},
+			expectCommands: []string{"LOOPBACK_UP", "ADD"},
+		},
+		"loopback should be brought up even if network is not ready": {
+			types:          []string{"loopback", "bridge"},
+			noNetwork:      true,
+			expectCommands: []string{"ADD"},
+			expectErr:      true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		plugin, calls, cleanup := newTestCNIPlugin(t, conf, `{}`, test.types...)
+		defer cleanup()
+		if test.noNetwork {
+			plugin.defaultNetwork = nil
+		}
+		err := plugin.SetUpPod(network)
+		if test.expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		var commands []string
+		for _, c := range *calls {
+			commands = append(commands, c.command)
+		}
+		assert.Equal(t, test.expectCommands, commands)
+	}
+}
+
+func assertPrevResult(t *testing.T, stdin []byte, result string) {
+	var conf map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(stdin, &conf))
+	assert.JSONEq(t, result, string(conf["prevResult"]))
+}
+
+func TestResultIP(t *testing.T) {
+	for desc, test := range map[string]struct {
+		result   string
+		expected string
+	}{
+		"pre-0.3.0 result": {
+			result:   `{"ip4":{"ip":"10.1.2.3/24"},"ip6":{"ip":"fd00::1/64"}}`,
+			expected: "10.1.2.3",
+		},
+		"ipv4 should be preferred": {
+			result:   `{"cniVersion":"0.3.1","ips":[{"version":"6","address":"fd00::1/64"},{"version":"4","address":"10.1.2.3/24"}]}`,
+			expected: "10.1.2.3",
+		},
+		"ipv6 only": {
+			result:   `{"cniVersion":"0.3.1","ips":[{"version":"6","address":"fd00::1/64"}]}`,
+			expected: "fd00::1",
+		},
+		"no ip": {
+			result: `{"cniVersion":"0.3.1"}`,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		r, err := parseResult([]byte(test.result))
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, r.IP())
+	}
+}
+
+func TestSupportsCheck(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"":      false,
+		"0.1.0": false,
+		"0.3.1": false,
+		"0.4.0": true,
+		"1.0.0": true,
+	} {
+		assert.Equal(t, expected, supportsCheck(version), version)
+	}
+}
+
+func TestInjectRuntimeConfig(t *testing.T) {
+	network := PodNetwork{
+		Name:        "test-name",
+		Namespace:   "test-ns",
+		ID:          "test-id",
+		Annotations: map[string]string{"a": "b"},
+	}
+	for desc, test := range map[string]struct {
+		conf     string
+		expected string
+	}{
+		"runtimeConfig should not be set without capabilities": {
+			conf:     `{"cniVersion":"0.3.1","name":"test-net","type":"calico"}`,
+			expected: `{"cniVersion":"0.3.1","name":"test-net","type":"calico"}`,
+		},
+		"pod annotations should be set with capability": {
+			conf: `{"cniVersion":"0.3.1","name":"test-net","type":"calico","capabilities":{"io.kubernetes.cri.pod-annotations":true}}`,
+			expected: `{"cniVersion":"0.3.1","name":"test-net","type":"calico","capabilities":{"io.kubernetes.cri.pod-annotations":true},` +
+				`"runtimeConfig":{"io.kubernetes.cri.pod-annotations":{"a":"b"}}}`,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		conf, err := libcni.ConfFromBytes([]byte(test.conf))
+		require.NoError(t, err)
+		stdin, err := injectRuntimeConfig(conf, conf.Bytes, network)
+		require.NoError(t, err)
+		assert.JSONEq(t, test.expected, string(stdin))
+	}
+}
+
+func TestBuildCNIArgs(t *testing.T) {
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id"}
+	assert.Equal(t, [][2]string{
+		{"IgnoreUnknown", "1"},
+		{"K8S_POD_NAMESPACE", "test-ns"},
+		{"K8S_POD_NAME", "test-name"},
+		{"K8S_POD_INFRA_CONTAINER_ID", "test-id"},
+	}, buildCNIArgs(network))
+}
+
+func TestCNIPluginStatus(t *testing.T) {
+	for desc, test := range map[string]struct {
+		noNetwork bool
+		waitUntil time.Time
+		expectErr error
+	}{
+		"status should be ok when network config is loaded": {},
+		"status should be waiting within max conf wait": {
+			noNetwork: true,
+			waitUntil: time.Now().Add(time.Hour),
+			expectErr: ErrWaitingForConfig,
+		},
+		"status should be error after max conf wait": {
+			noNetwork: true,
+			expectErr: errUninitialized,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		plugin, _, cleanup := newTestCNIPlugin(t, `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`, `{}`)
+		defer cleanup()
+		if test.noNetwork {
+			plugin.defaultNetwork = nil
+		}
+		plugin.waitUntil = test.waitUntil
+		assert.Equal(t, test.expectErr, plugin.Status())
+	}
+}
+
+func TestCNIPluginHairpin(t *testing.T) {
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id", NetNS: "/test/netns"}
+	for desc, test := range map[string]struct {
+		mode         string
+		conf         string
+		expectCall   string
+		expectTarget string
+	}{
+		"none should not configure hairpin": {
+			mode: HairpinNone,
+			conf: `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`,
+		},
+		"hairpin-veth should enable hairpin on veth": {
+			mode:         HairpinVeth,
+			conf:         `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`,
+			expectCall:   "HAIRPIN_VETH",
+			expectTarget: DefaultInterfaceName,
+		},
+		"promiscuous-bridge should set default bridge promiscuous": {
+			mode:         PromiscuousBridge,
+			conf:         `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`,
+			expectCall:   "BRIDGE_PROMISC",
+			expectTarget: "cni0",
+		},
+		"promiscuous-bridge should set configured bridge promiscuous": {
+			mode:         PromiscuousBridge,
+			conf:         `{"cniVersion":"0.3.1","name":"test-net","type":"bridge","bridge":"cbr0"}`,
+			expectCall:   "BRIDGE_PROMISC",
+			expectTarget: "cbr0",
+		},
+		"promiscuous-bridge should be skipped for non-bridge network": {
+			mode: PromiscuousBridge,
+			conf: `{"cniVersion":"0.3.1","name":"test-net","type":"ptp"}`,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		plugin, calls, cleanup := newTestCNIPlugin(t, test.conf, `{}`, "loopback", "bridge", "ptp")
+		defer cleanup()
+		plugin.hairpinMode = test.mode
+		require.NoError(t, plugin.SetUpPod(network))
+		var found *fakeExecCall
+		for i, c := range *calls {
+			if c.command == "HAIRPIN_VETH" || c.command == "BRIDGE_PROMISC" {
+				found = &(*calls)[i]
+			}
+		}
+		if test.expectCall == "" {
+			assert.Nil(t, found)
+			continue
+		}
+		require.NotNil(t, found)
+		assert.Equal(t, test.expectCall, found.command)
+		assert.Equal(t, test.expectTarget, string(found.stdin))
+	}
+}
+
+func TestParsePeerIndex(t *testing.T) {
+	for desc, test := range map[string]struct {
+		output    string
+		expected  int
+		expectErr bool
+	}{
+		"veth": {
+			output:   "3: eth0@if12: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP",
+			expected: 12,
+		},
+		"not veth": {
+			output:    "3: eth0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP",
+			expectErr: true,
+		},
+		"invalid output": {
+			output:    "",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		index, err := parsePeerIndex(test.output)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, index)
+	}
+}
+
+func TestFindInterfaceByIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-sys-class-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, index := range map[string]string{"eth0": "2\n", "veth1234": "12\n"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name, "ifindex"), []byte(index), 0644))
+	}
+	name, err := findInterfaceByIndex(dir, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, "veth1234", name)
+	_, err = findInterfaceByIndex(dir, 13)
+	assert.Error(t, err)
+}
+
+func TestCNIPluginQoS(t *testing.T) {
+	plugin, calls, cleanup := newTestCNIPlugin(t, `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`, `{}`, "loopback", "bridge")
+	defer cleanup()
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id", NetNS: "/test/netns"}
+	require.NoError(t, plugin.SetUpPod(network))
+	for _, c := range *calls {
+		assert.NotEqual(t, "QOS", c.command, "qos should not be set without qos config")
+	}
+
+	*calls = nil
+	network.QoS = &NetworkQoS{Priority: "1:10"}
+	require.NoError(t, plugin.SetUpPod(network))
+	var found bool
+	for _, c := range *calls {
+		if c.command == "QOS" {
+			found = true
+		}
+	}
+	assert.True(t, found, "qos should be set")
+}
+
+func TestQoSRules(t *testing.T) {
+	dscp := uint(46)
+	for desc, test := range map[string]struct {
+		qos      NetworkQoS
+		expected [][]string
+	}{
+		"empty qos": {},
+		"dscp": {
+			qos: NetworkQoS{DSCP: &dscp},
+			expected: [][]string{
+				{"-t", "mangle", "-A", "POSTROUTING", "-o", "eth0", "-j", "DSCP", "--set-dscp", "46"},
+			},
+		},
+		"dscp and priority": {
+			qos: NetworkQoS{DSCP: &dscp, Priority: "1:10"},
+			expected: [][]string{
+				{"-t", "mangle", "-A", "POSTROUTING", "-o", "eth0", "-j", "DSCP", "--set-dscp", "46"},
+				{"-t", "mangle", "-A", "POSTROUTING", "-o", "eth0", "-j", "CLASSIFY", "--set-class", "1:10"},
+			},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, qosRules("eth0", test.qos))
+	}
+}
+
+func TestCNIPluginSetUpRetry(t *testing.T) {
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id", NetNS: "/test/netns"}
+	for desc, test := range map[string]struct {
+		retries        int
+		failures       int
+		expectErr      bool
+		expectCommands []string
+	}{
+		"should not retry by default": {
+			failures:       1,
+			expectErr:      true,
+			expectCommands: []string{"ADD", "DEL"},
+		},
+		"should succeed after retries": {
+			retries:        2,
+			failures:       2,
+			expectCommands: []string{"ADD", "DEL", "ADD", "DEL", "ADD"},
+		},
+		"should fail after all retries": {
+			retries:        1,
+			failures:       2,
+			expectErr:      true,
+			expectCommands: []string{"ADD", "DEL", "ADD", "DEL"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		plugin, calls, cleanup := newTestCNIPlugin(t, `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`, `{}`, "bridge")
+		defer cleanup()
+		plugin.retries = test.retries
+		require.NoError(t, plugin.setUpLoopback(network))
+		*calls = nil
+		failures := test.failures
+		exec := plugin.exec
+		plugin.exec = func(pluginPath string, stdin []byte, environ []string) ([]byte, error) {
+			result, err := exec(pluginPath, stdin, environ)
+			if (*calls)[len(*calls)-1].command == "ADD" && failures > 0 {
+				failures--
+				return nil, errors.New("add failure")
+			}
+			return result, err
+		}
+		err := plugin.SetUpPod(network)
+		if test.expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		var commands []string
+		for _, c := range *calls {
+			commands = append(commands, c.command)
+		}
+		// The first call brings up loopback directly.
+		assert.Equal(t, append([]string{"LOOPBACK_UP"}, test.expectCommands...), commands)
+	}
+}
+
+func TestCNIPluginGetPodNetworkStats(t *testing.T) {
+	plugin, calls, cleanup := newTestCNIPlugin(t, `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`, `{}`, "bridge")
+	defer cleanup()
+	network := PodNetwork{Name: "test-name", Namespace: "test-ns", ID: "test-id", NetNS: "/test/netns"}
+	for i := 0; i < 2; i++ {
+		stats, err := plugin.GetPodNetworkStats(network)
+		require.NoError(t, err)
+		// Host veth counters should be swapped.
+		assert.Equal(t, &InterfaceStats{RxBytes: 4, RxPackets: 5, RxDropped: 6, TxBytes: 1, TxPackets: 2, TxDropped: 3}, stats)
+	}
+	var vethLookups int
+	for _, c := range *calls {
+		if c.command == "HOST_VETH" {
+			vethLookups++
+		}
+	}
+	assert.Equal(t, 1, vethLookups, "host veth should be cached")
+
+	require.NoError(t, plugin.TearDownPod(network))
+	assert.Empty(t, plugin.hostVeths)
+}
+
+func TestReadInterfaceStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-sys-class-net")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	statsDir := filepath.Join(dir, "veth1234", "statistics")
+	require.NoError(t, os.MkdirAll(statsDir, 0755))
+	for i, file := range []string{"rx_bytes", "rx_packets", "rx_dropped", "tx_bytes", "tx_packets", "tx_dropped"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(statsDir, file), []byte(fmt.Sprintf("%d\n", i+1)), 0644))
+	}
+	stats, err := readInterfaceStats(dir, "veth1234")
+	require.NoError(t, err)
+	assert.Equal(t, &InterfaceStats{RxBytes: 1, RxPackets: 2, RxDropped: 3, TxBytes: 4, TxPackets: 5, TxDropped: 6}, stats)
+	_, err = readInterfaceStats(dir, "unknown")
+	assert.Error(t, err)
+}
+
+func TestCNIPluginUpdatePodCIDR(t *testing.T) {
+	for desc, test := range map[string]struct {
+		template     string
+		noTemplate   bool
+		currentCIDR  string
+		expectConf   bool
+		expectSubnet string
+		expectErr    bool
+	}{
+		"no template should be a no-op": {
+			noTemplate: true,
+		},
+		"template should be rendered and reloaded": {
+			template:     `{"cniVersion":"0.3.1","name":"template-net","type":"bridge","ipam":{"type":"host-local","subnet":"{{.PodCIDR}}"}}`,
+			expectConf:   true,
+			expectSubnet: "10.0.0.0/24",
+		},
+		"unchanged pod cidr should not rewrite config": {
+			template:    `{"cniVersion":"0.3.1","name":"template-net","type":"bridge","ipam":{"type":"host-local","subnet":"{{.PodCIDR}}"}}`,
+			currentCIDR: "10.0.0.0/24",
+		},
+		"invalid rendered config should return error": {
+			template:  `{"name":`,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		plugin, _, cleanup := newTestCNIPlugin(t, `{"cniVersion":"0.3.1","name":"test-net","type":"bridge"}`, `{}`)
+		defer cleanup()
+		if !test.noTemplate {
+			plugin.confTemplate = filepath.Join(filepath.Dir(plugin.confDir), "template.conf")
+			require.NoError(t, ioutil.WriteFile(plugin.confTemplate, []byte(test.template), 0644))
+		}
+		plugin.podCIDR = test.currentCIDR
+		err := plugin.UpdatePodCIDR("10.0.0.0/24")
+		assert.Equal(t, test.expectErr, err != nil)
+		data, readErr := ioutil.ReadFile(filepath.Join(plugin.confDir, TemplateConfFile))
+		if !test.expectConf {
+			assert.True(t, os.IsNotExist(readErr))
+			assert.Equal(t, "test-net", plugin.defaultNetwork.Network.Name)
+			continue
+		}
+		require.NoError(t, readErr)
+		var conf struct {
+			IPAM struct {
+				Subnet string `json:"subnet"`
+			} `json:"ipam"`
+		}
+		require.NoError(t, json.Unmarshal(data, &conf))
+		assert.Equal(t, test.expectSubnet, conf.IPAM.Subnet)
+		assert.Equal(t, "template-net", plugin.defaultNetwork.Network.Name)
+		assert.Equal(t, "10.0.0.0/24", plugin.podCIDR)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netplugin
+
+import (
+	"errors"
+	"time"
+
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+)
+
+const (
+	// DefaultInterfaceName is the name of the interface created inside the pod network namespace.
+	DefaultInterfaceName = "eth0"
+	// CNIPluginName is the name of the cni network plugin.
+	CNIPluginName = "cni"
+	// DefaultNetDir is the default directory of cni network configurations.
+	DefaultNetDir = "/etc/cni/net.d"
+	// DefaultCNIDir is the default directory of cni plugin binaries.
+	DefaultCNIDir = "/opt/cni/bin"
+	// DefaultCacheDir is the default directory where cni results are cached.
+	DefaultCacheDir = "/var/lib/cni/results"
+	// TemplateConfFile is the name of the network configuration rendered from
+	// the template. It sorts first so that it takes precedence over the other
+	// configurations in the conf dir.
+	TemplateConfFile = "00-cri-containerd-net.conf"
+	// PodAnnotationsCapability is the capability of plugins which accept pod
+	// annotations in runtimeConfig.
+	PodAnnotationsCapability = "io.kubernetes.cri.pod-annotations"
+)
+
+const (
+	// HairpinVeth enables hairpin mode on the host side veth of pods.
+	HairpinVeth = "hairpin-veth"
+	// PromiscuousBridge sets the network bridge into promiscuous mode.
+	PromiscuousBridge = "promiscuous-bridge"
+	// HairpinNone doesn't configure hairpin.
+	HairpinNone = "none"
+)
+
+// ErrWaitingForConfig is returned by Status when the network configuration
+// is not found within the max conf wait after startup.
+var ErrWaitingForConfig = errors.New("waiting for cni config")
+
+// Config is the configuration of the cni network plugin.
+type Config struct {
+	// ConfDir is the directory of network configurations.
+	ConfDir string
+	// BinDirs are the directories of plugin binaries, searched in order.
+	BinDirs []string
+	// CacheDir is the directory where cni results are cached.
+	CacheDir string
+	// MaxConfWait is the grace period after startup during which missing
+	// network configuration is reported as ErrWaitingForConfig.
+	MaxConfWait time.Duration
+	// HairpinMode is one of HairpinVeth, PromiscuousBridge and HairpinNone.
+	HairpinMode string
+	// SetUpRetries is the number of retries when adding a pod into the network fails.
+	SetUpRetries int
+	// SetUpBackoff is the initial backoff between retries, it doubles after each retry.
+	SetUpBackoff time.Duration
+	// ConfTemplate is the path of a network configuration template, which is
+	// rendered into ConfDir when the pod cidr is updated. Empty means the pod
+	// cidr is ignored and the network configuration is managed by the admin.
+	ConfTemplate string
+}
+
+// PodNetwork identifies the network of a pod sandbox.
+type PodNetwork struct {
+	// Name is the name of the pod.
+	Name string
+	// Namespace is the namespace of the pod.
+	Namespace string
+	// ID is the id of the sandbox container.
+	ID string
+	// NetNS is the path of the pod network namespace.
+	NetNS string
+	// Annotations are the pod annotations, which are passed to plugins
+	// declaring PodAnnotationsCapability.
+	Annotations map[string]string
+	// QoS is the qos marking of the pod egress traffic, nil means no marking.
+	QoS *NetworkQoS
+}
+
+// NetworkQoS is the qos marking of pod egress traffic.
+type NetworkQoS struct {
+	// DSCP is the dscp value set on egress packets, nil means not to set.
+	DSCP *uint
+	// Priority is the tc class "major:minor" egress packets are classified
+	// into, empty means not to classify.
+	Priority string
+}
+
+// CNIPlugin is the interface used to setup and teardown pod network.
+type CNIPlugin interface {
+	// Name returns the name of the plugin.
+	Name() string
+	// SetUpPod adds the pod into the network, and caches the result.
+	SetUpPod(network PodNetwork) error
+	// TearDownPod removes the pod from the network. It is idempotent, and uses
+	// the cached result and configuration when there is one.
+	TearDownPod(network PodNetwork) error
+	// CheckPod checks whether the pod network is still as expected. It is a no-op
+	// for networks whose cni version doesn't support CHECK.
+	CheckPod(network PodNetwork) error
+	// GetPodNetworkStatus returns the ip of the pod.
+	GetPodNetworkStatus(network PodNetwork) (string, error)
+	// GetPodNetworkResult returns the cached cni result of the pod, or nil if
+	// there is none.
+	GetPodNetworkResult(network PodNetwork) (*Result, error)
+	// GetPodNetworkStats returns the counters of the pod interface, read from
+	// its host side veth.
+	GetPodNetworkStats(network PodNetwork) (*InterfaceStats, error)
+	// Status returns error if the network plugin is not ready.
+	Status() error
+	// UpdatePodCIDR renders the network configuration template with the pod
+	// cidr and reloads the network configuration. It is a no-op when there is
+	// no template or the cidr is unchanged.
+	UpdatePodCIDR(cidr string) error
+}
+
+// InterfaceStats are the counters of a pod interface from the pod's perspective.
+type InterfaceStats struct {
+	RxBytes   uint64 `json:"rxBytes"`
+	RxPackets uint64 `json:"rxPackets"`
+	RxDropped uint64 `json:"rxDropped"`
+	TxBytes   uint64 `json:"txBytes"`
+	TxPackets uint64 `json:"txPackets"`
+	TxDropped uint64 `json:"txDropped"`
+}
+
+// Result is the cni result, it supports both the pre-0.3.0 and the current result format.
+type Result struct {
+	CNIVersion string `json:"cniVersion,omitempty"`
+	// IP4 and IP6 are only set by pre-0.3.0 plugins.
+	IP4 *cnitypes.IPConfig `json:"ip4,omitempty"`
+	IP6 *cnitypes.IPConfig `json:"ip6,omitempty"`
+	// Interfaces and IPs are set by 0.3.0 and later plugins.
+	Interfaces []*Interface `json:"interfaces,omitempty"`
+	IPs        []*IPConfig  `json:"ips,omitempty"`
+	Routes     []*Route     `json:"routes,omitempty"`
+	DNS        cnitypes.DNS `json:"dns,omitempty"`
+}
+
+// Interface is an interface in the cni result.
+type Interface struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// IPConfig is an ip address in the cni result.
+type IPConfig struct {
+	Version   string `json:"version,omitempty"`
+	Interface *int   `json:"interface,omitempty"`
+	Address   string `json:"address"`
+	Gateway   string `json:"gateway,omitempty"`
+}
+
+// Route is a route in the cni result.
+type Route struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netplugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// IP returns the first ipv4 address in the result, or the first ipv6 address
+// if there is no ipv4 address.
+func (r *Result) IP() string {
+	if r.IP4 != nil {
+		return r.IP4.IP.IP.String()
+	}
+	var ip6 string
+	for _, c := range r.IPs {
+		ip, _, err := net.ParseCIDR(c.Address)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip.String()
+		}
+		if ip6 == "" {
+			ip6 = ip.String()
+		}
+	}
+	if ip6 == "" && r.IP6 != nil {
+		ip6 = r.IP6.IP.IP.String()
+	}
+	return ip6
+}
+
+// parseResult parses the raw cni result.
+func parseResult(data []byte) (*Result, error) {
+	var r Result
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cni result %q: %v", data, err)
+	}
+	return &r, nil
+}
+
+// supportsCheck returns whether the cni version supports the CHECK command
+// and prevResult, which are introduced in 0.4.0.
+func supportsCheck(cniVersion string) bool {
+	parts := strings.SplitN(cniVersion, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return major > 0 || minor >= 4
+}
+
+// injectPrevResult returns the network configuration with prevResult set to
+// the given result.
+func injectPrevResult(conf []byte, result []byte) ([]byte, error) {
+	return injectConf(conf, "prevResult", json.RawMessage(result))
+}
+
+// injectConf returns the network configuration with the key set to the value.
+func injectConf(conf []byte, key string, value interface{}) ([]byte, error) {
+	var c map[string]interface{}
+	if err := json.Unmarshal(conf, &c); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal network config: %v", err)
+	}
+	c[key] = value
+	return json.Marshal(c)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netplugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// getContainerIP returns the ip of the interface inside the network namespace.
+func getContainerIP(nsenterPath, netnsPath, interfaceName, addrType string) (net.IP, error) {
+	output, err := exec.Command(nsenterPath, fmt.Sprintf("--net=%s", netnsPath), "-F", "--",
+		"ip", "-o", addrType, "addr", "show", "dev", interfaceName, "scope", "global").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unexpected command output %s with error: %v", output, err)
+	}
+	lines := strings.Split(string(output), "\n")
+	fields := strings.Fields(lines[0])
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("unexpected address output %s", lines[0])
+	}
+	ip, _, err := net.ParseCIDR(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ip from output %s: %v", output, err)
+	}
+	return ip, nil
+}
+
+// netOps are the network operations done directly on the host or inside pod
+// network namespaces.
+type netOps interface {
+	// setLoopbackUp brings up the loopback interface inside the network namespace.
+	setLoopbackUp(netNS string) error
+	// setHairpinVeth enables hairpin mode on the host side veth of the interface
+	// inside the network namespace.
+	setHairpinVeth(netNS, ifName string) error
+	// setBridgePromisc sets the bridge into promiscuous mode.
+	setBridgePromisc(bridge string) error
+	// setQoS marks the egress traffic of the interface inside the network namespace.
+	setQoS(netNS, ifName string, qos NetworkQoS) error
+	// getHostVeth returns the host side veth of the interface inside the network namespace.
+	getHostVeth(netNS, ifName string) (string, error)
+	// getHostInterfaceStats returns the counters of the host interface.
+	getHostInterfaceStats(name string) (*InterfaceStats, error)
+}
+
+// sysClassNet is the sysfs directory of host network interfaces.
+const sysClassNet = "/sys/class/net"
+
+// nsenterNetOps implements netOps with nsenter and ip.
+type nsenterNetOps struct {
+	nsenterPath string
+}
+
+// runInNetNS runs the command inside the network namespace, and returns the output.
+func (n *nsenterNetOps) runInNetNS(netNS string, args ...string) ([]byte, error) {
+	args = append([]string{fmt.Sprintf("--net=%s", netNS), "-F", "--"}, args...)
+	output, err := exec.Command(n.nsenterPath, args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("unexpected command output %s with error: %v", output, err)
+	}
+	return output, nil
+}
+
+func (n *nsenterNetOps) setLoopbackUp(netNS string) error {
+	_, err := n.runInNetNS(netNS, "ip", "link", "set", "lo", "up")
+	return err
+}
+
+func (n *nsenterNetOps) getHostVeth(netNS, ifName string) (string, error) {
+	output, err := n.runInNetNS(netNS, "ip", "-o", "link", "show", "dev", ifName)
+	if err != nil {
+		return "", err
+	}
+	peerIndex, err := parsePeerIndex(string(output))
+	if err != nil {
+		return "", err
+	}
+	return findInterfaceByIndex(sysClassNet, peerIndex)
+}
+
+func (n *nsenterNetOps) getHostInterfaceStats(name string) (*InterfaceStats, error) {
+	return readInterfaceStats(sysClassNet, name)
+}
+
+func (n *nsenterNetOps) setHairpinVeth(netNS, ifName string) error {
+	hostVeth, err := n.getHostVeth(netNS, ifName)
+	if err != nil {
+		return err
+	}
+	hairpinFile := filepath.Join(sysClassNet, hostVeth, "brport", "hairpin_mode")
+	if err := ioutil.WriteFile(hairpinFile, []byte("1"), 0644); err != nil {
+		return fmt.Errorf("failed to enable hairpin mode on %q: %v", hostVeth, err)
+	}
+	return nil
+}
+
+func (n *nsenterNetOps) setBridgePromisc(bridge string) error {
+	output, err := exec.Command("ip", "link", "set", "dev", bridge, "promisc", "on").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unexpected command output %s with error: %v", output, err)
+	}
+	return nil
+}
+
+func (n *nsenterNetOps) setQoS(netNS, ifName string, qos NetworkQoS) error {
+	for _, rule := range qosRules(ifName, qos) {
+		if _, err := n.runInNetNS(netNS, append([]string{"iptables", "-w"}, rule...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// qosRules returns the iptables rules marking the egress traffic of the interface.
+func qosRules(ifName string, qos NetworkQoS) [][]string {
+	var rules [][]string
+	if qos.DSCP != nil {
+		rules = append(rules, []string{"-t", "mangle", "-A", "POSTROUTING", "-o", ifName,
+			"-j", "DSCP", "--set-dscp", strconv.FormatUint(uint64(*qos.DSCP), 10)})
+	}
+	if qos.Priority != "" {
+		rules = append(rules, []string{"-t", "mangle", "-A", "POSTROUTING", "-o", ifName,
+			"-j", "CLASSIFY", "--set-class", qos.Priority})
+	}
+	return rules
+}
+
+// parsePeerIndex parses the peer interface index from the output of
+// `ip -o link show`, e.g. "3: eth0@if12: <BROADCAST,MULTICAST,UP> ...".
+func parsePeerIndex(output string) (int, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected link output %q", output)
+	}
+	name := strings.TrimSuffix(fields[1], ":")
+	i := strings.LastIndex(name, "@if")
+	if i < 0 {
+		return 0, fmt.Errorf("interface %q is not a veth", name)
+	}
+	index, err := strconv.Atoi(name[i+len("@if"):])
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse peer index of %q: %v", name, err)
+	}
+	return index, nil
+}
+
+// findInterfaceByIndex returns the name of the host interface with the index.
+func findInterfaceByIndex(dir string, index int) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name(), "ifindex"))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == strconv.Itoa(index) {
+			return info.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("interface with index %d not found", index)
+}
+
+// readInterfaceStats reads the counters of the interface from sysfs.
+func readInterfaceStats(dir, name string) (*InterfaceStats, error) {
+	var stats InterfaceStats
+	for file, v := range map[string]*uint64{
+		"rx_bytes":   &stats.RxBytes,
+		"rx_packets": &stats.RxPackets,
+		"rx_dropped": &stats.RxDropped,
+		"tx_bytes":   &stats.TxBytes,
+		"tx_packets": &stats.TxPackets,
+		"tx_dropped": &stats.TxDropped,
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name, "statistics", file))
+		if err != nil {
+			return nil, err
+		}
+		*v, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s of %q: %v", file, name, err)
+		}
+	}
+	return &stats, nil
+}
@@ -17,10 +17,16 @@ limitations under the License.
 package os
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 
+	containerdmount "github.com/containerd/containerd/mount"
 	"github.com/containerd/fifo"
 	"github.com/docker/docker/pkg/mount"
 	"golang.org/x/net/context"
@@ -36,8 +42,16 @@ type OS interface {
 	Stat(name string) (os.FileInfo, error)
 	CopyFile(src, dest string, perm os.FileMode) error
 	WriteFile(filename string, data []byte, perm os.FileMode) error
+	AtomicWriteFile(filename string, data []byte, perm os.FileMode) error
 	Mount(source string, target string, fstype string, flags uintptr, data string) error
 	Unmount(target string, flags int) error
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	ReadFile(filename string) ([]byte, error)
+	ReadFileInRoot(root, path string) ([]byte, error)
+	MountAll(mounts []containerdmount.Mount, target string) error
+	FsUsage(path string) (used uint64, capacity uint64, err error)
+	Kill(pid int, sig syscall.Signal) error
+	NewNetNS(path string) error
 }
 
 // RealOS is used to dispatch the real system level operations.
@@ -86,6 +100,30 @@ func (RealOS) WriteFile(filename string, data []byte, perm os.FileMode) error {
 	return ioutil.WriteFile(filename, data, perm)
 }
 
+// AtomicWriteFile writes data into a temporary file and renames it to the
+// file, so that the file is either the old or the new content after a crash.
+func (RealOS) AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+	}
+	return err
+}
+
 // Mount will call unix.Mount to mount the file.
 func (RealOS) Mount(source string, target string, fstype string, flags uintptr, data string) error {
 	return unix.Mount(source, target, fstype, flags, data)
@@ -100,3 +138,147 @@ func (RealOS) Unmount(target string, flags int) error {
 	}
 	return unix.Unmount(target, flags)
 }
+
+// ReadDir will call ioutil.ReadDir to list the directory entries.
+func (RealOS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+// ReadFile will call ioutil.ReadFile to read the file content.
+func (RealOS) ReadFile(filename string) ([]byte, error) {
+	return ioutil.ReadFile(filename)
+}
+
+// maxSymlinks is the maximum number of symlinks followed when resolving a path.
+const maxSymlinks = 255
+
+// ReadFileInRoot reads the file at path inside root. Symlinks are resolved inside
+// root, so that a file in an untrusted root, e.g. image rootfs, can't point to a
+// host file.
+func (RealOS) ReadFileInRoot(root, path string) ([]byte, error) {
+	p, err := resolveInRoot(root, path)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(p)
+}
+
+// resolveInRoot resolves all symlinks in path as if root is "/", and returns
+// the resolved path on the host.
+func resolveInRoot(root, path string) (string, error) {
+	resolved := "/"
+	remaining := path
+	links := 0
+	for remaining != "" {
+		part := remaining
+		remaining = ""
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			part, remaining = part[:i], part[i+1:]
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %q", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// MountAll will call containerd mount.MountAll to mount all mounts to the target.
+func (RealOS) MountAll(mounts []containerdmount.Mount, target string) error {
+	return containerdmount.MountAll(mounts, target)
+}
+
+// FsUsage will call unix.Statfs to get used bytes and capacity of the filesystem
+// containing path.
+func (RealOS) FsUsage(path string) (uint64, uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	bsize := uint64(st.Bsize)
+	return (st.Blocks - st.Bfree) * bsize, st.Blocks * bsize, nil
+}
+
+// Kill will call unix.Kill to send the signal to the process.
+func (RealOS) Kill(pid int, sig syscall.Signal) error {
+	return unix.Kill(pid, sig)
+}
+
+// NewNetNS creates a new network namespace and bind mounts it to path, so that
+// it persists without any process in it. The namespace is removed by unmounting
+// and removing path.
+func (RealOS) NewNetNS(path string) (retErr error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0711); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	f.Close() // nolint: errcheck
+	defer func() {
+		if retErr != nil {
+			os.Remove(path) // nolint: errcheck
+		}
+	}()
+	errCh := make(chan error, 1)
+	// Unshare on a dedicated locked thread, and switch the thread back to the
+	// original network namespace before unlocking it, so that no other
+	// goroutine runs in the new network namespace.
+	go func() {
+		runtime.LockOSThread()
+		threadNS := fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid())
+		origin, err := os.Open(threadNS)
+		if err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("failed to open current network namespace: %v", err)
+			return
+		}
+		defer origin.Close() // nolint: errcheck
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			errCh <- fmt.Errorf("failed to unshare network namespace: %v", err)
+			return
+		}
+		mountErr := unix.Mount(threadNS, path, "none", unix.MS_BIND, "")
+		if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+			// Leave the thread locked, it exits with the goroutine instead of
+			// being reused in the wrong network namespace.
+			if mountErr == nil {
+				unix.Unmount(path, unix.MNT_DETACH) // nolint: errcheck
+			}
+			errCh <- fmt.Errorf("failed to switch back to original network namespace: %v", err)
+			return
+		}
+		runtime.UnlockOSThread()
+		if mountErr != nil {
+			mountErr = fmt.Errorf("failed to bind mount network namespace: %v", mountErr)
+		}
+		errCh <- mountErr
+	}()
+	return <-errCh
+}
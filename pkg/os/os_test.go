@@ -0,0 +1,90 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package os
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFileInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "test-read-file-in-root")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "etc", "passwd"), []byte("root"), 0644))
+	require.NoError(t, os.Symlink("/etc/passwd", filepath.Join(root, "abs-link")))
+	require.NoError(t, os.Symlink("../../etc/passwd", filepath.Join(root, "etc", "rel-link")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	for desc, test := range map[string]struct {
+		path      string
+		expectErr bool
+	}{
+		"should read regular file": {
+			path: "/etc/passwd",
+		},
+		"should resolve absolute symlink inside root": {
+			path: "/abs-link",
+		},
+		"should not escape root with relative symlink": {
+			path: "/etc/rel-link",
+		},
+		"should not escape root with ..": {
+			path: "/../../etc/passwd",
+		},
+		"should return error for symlink loop": {
+			path:      "/loop",
+			expectErr: true,
+		},
+		"should return error for non-existing file": {
+			path:      "/etc/group",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		data, err := RealOS{}.ReadFileInRoot(root, test.path)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, "root", string(data))
+	}
+}
+
+func TestAtomicWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-atomic-write-file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+	for _, data := range []string{"old", "new"} {
+		require.NoError(t, RealOS{}.AtomicWriteFile(path, []byte(data), 0600))
+		got, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, string(got))
+	}
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err), "temporary file should be renamed")
+	// The write fails without leaving any file if the directory is missing.
+	assert.Error(t, RealOS{}.AtomicWriteFile(filepath.Join(dir, "missing", "file"), []byte("data"), 0600))
+}
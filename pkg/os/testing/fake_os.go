@@ -20,7 +20,9 @@ import (
 	"io"
 	"os"
 	"sync"
+	"syscall"
 
+	containerdmount "github.com/containerd/containerd/mount"
 	"golang.org/x/net/context"
 
 	osInterface "github.com/kubernetes-incubator/cri-containerd/pkg/os"
@@ -39,16 +41,24 @@ type CalledDetail struct {
 // of the real call.
 type FakeOS struct {
 	sync.Mutex
-	MkdirAllFn  func(string, os.FileMode) error
-	RemoveAllFn func(string) error
-	OpenFifoFn  func(context.Context, string, int, os.FileMode) (io.ReadWriteCloser, error)
-	StatFn      func(string) (os.FileInfo, error)
-	CopyFileFn  func(string, string, os.FileMode) error
-	WriteFileFn func(string, []byte, os.FileMode) error
-	MountFn     func(source string, target string, fstype string, flags uintptr, data string) error
-	UnmountFn   func(target string, flags int) error
-	calls       []CalledDetail
-	errors      map[string]error
+	MkdirAllFn        func(string, os.FileMode) error
+	RemoveAllFn       func(string) error
+	OpenFifoFn        func(context.Context, string, int, os.FileMode) (io.ReadWriteCloser, error)
+	StatFn            func(string) (os.FileInfo, error)
+	CopyFileFn        func(string, string, os.FileMode) error
+	WriteFileFn       func(string, []byte, os.FileMode) error
+	AtomicWriteFileFn func(string, []byte, os.FileMode) error
+	MountFn           func(source string, target string, fstype string, flags uintptr, data string) error
+	UnmountFn         func(target string, flags int) error
+	ReadDirFn         func(string) ([]os.FileInfo, error)
+	ReadFileFn        func(string) ([]byte, error)
+	ReadFileInRootFn  func(string, string) ([]byte, error)
+	MountAllFn        func([]containerdmount.Mount, string) error
+	FsUsageFn         func(string) (uint64, uint64, error)
+	KillFn            func(int, syscall.Signal) error
+	NewNetNSFn        func(string) error
+	calls             []CalledDetail
+	errors            map[string]error
 }
 
 var _ osInterface.OS = &FakeOS{}
@@ -186,6 +196,19 @@ func (f *FakeOS) WriteFile(filename string, data []byte, perm os.FileMode) error
 	return nil
 }
 
+// AtomicWriteFile is a fake call that invokes AtomicWriteFileFn or just return nil.
+func (f *FakeOS) AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	f.appendCalls("AtomicWriteFile", filename, data, perm)
+	if err := f.getError("AtomicWriteFile"); err != nil {
+		return err
+	}
+
+	if f.AtomicWriteFileFn != nil {
+		return f.AtomicWriteFileFn(filename, data, perm)
+	}
+	return nil
+}
+
 // Mount is a fake call that invokes MountFn or just return nil.
 func (f *FakeOS) Mount(source string, target string, fstype string, flags uintptr, data string) error {
 	f.appendCalls("Mount", source, target, fstype, flags, data)
@@ -211,3 +234,94 @@ func (f *FakeOS) Unmount(target string, flags int) error {
 	}
 	return nil
 }
+
+// ReadDir is a fake call that invokes ReadDirFn or just return nil.
+func (f *FakeOS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	f.appendCalls("ReadDir", dirname)
+	if err := f.getError("ReadDir"); err != nil {
+		return nil, err
+	}
+
+	if f.ReadDirFn != nil {
+		return f.ReadDirFn(dirname)
+	}
+	return nil, nil
+}
+
+// ReadFile is a fake call that invokes ReadFileFn or just return nil.
+func (f *FakeOS) ReadFile(filename string) ([]byte, error) {
+	f.appendCalls("ReadFile", filename)
+	if err := f.getError("ReadFile"); err != nil {
+		return nil, err
+	}
+
+	if f.ReadFileFn != nil {
+		return f.ReadFileFn(filename)
+	}
+	return nil, nil
+}
+
+// ReadFileInRoot is a fake call that invokes ReadFileInRootFn or just return nil.
+func (f *FakeOS) ReadFileInRoot(root, path string) ([]byte, error) {
+	f.appendCalls("ReadFileInRoot", root, path)
+	if err := f.getError("ReadFileInRoot"); err != nil {
+		return nil, err
+	}
+
+	if f.ReadFileInRootFn != nil {
+		return f.ReadFileInRootFn(root, path)
+	}
+	return nil, nil
+}
+
+// MountAll is a fake call that invokes MountAllFn or just return nil.
+func (f *FakeOS) MountAll(mounts []containerdmount.Mount, target string) error {
+	f.appendCalls("MountAll", mounts, target)
+	if err := f.getError("MountAll"); err != nil {
+		return err
+	}
+
+	if f.MountAllFn != nil {
+		return f.MountAllFn(mounts, target)
+	}
+	return nil
+}
+
+// FsUsage is a fake call that invokes FsUsageFn or just return 0.
+func (f *FakeOS) FsUsage(path string) (uint64, uint64, error) {
+	f.appendCalls("FsUsage", path)
+	if err := f.getError("FsUsage"); err != nil {
+		return 0, 0, err
+	}
+
+	if f.FsUsageFn != nil {
+		return f.FsUsageFn(path)
+	}
+	return 0, 0, nil
+}
+
+// Kill is a fake call that invokes KillFn or just return nil.
+func (f *FakeOS) Kill(pid int, sig syscall.Signal) error {
+	f.appendCalls("Kill", pid, sig)
+	if err := f.getError("Kill"); err != nil {
+		return err
+	}
+
+	if f.KillFn != nil {
+		return f.KillFn(pid, sig)
+	}
+	return nil
+}
+
+// NewNetNS is a fake call that invokes NewNetNSFn or just return nil.
+func (f *FakeOS) NewNetNS(path string) error {
+	f.appendCalls("NewNetNS", path)
+	if err := f.getError("NewNetNS"); err != nil {
+		return err
+	}
+
+	if f.NewNetNSFn != nil {
+		return f.NewNetNSFn(path)
+	}
+	return nil
+}
@@ -99,3 +99,10 @@ func (r *Registrar) ReleaseByKey(key string) {
 	delete(r.nameToKey, name)
 	delete(r.keyToName, key)
 }
+
+// Len returns the number of reserved name<->key mappings.
+func (r *Registrar) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.nameToKey)
+}
@@ -39,6 +39,9 @@ func TestRegistrar(t *testing.T) {
 	assert.Error(r.Reserve("test-name-1", "test-id-conflict"))
 	assert.Error(r.Reserve("test-name-conflict", "test-id-2"))
 
+	t.Logf("should be able to get the number of reserved mappings")
+	assert.Equal(2, r.Len())
+
 	t.Logf("should be able to release name<->key mapping by key")
 	r.ReleaseByKey("test-id-1")
 
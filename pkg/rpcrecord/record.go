@@ -0,0 +1,218 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rpcrecord records cri requests served by cri-containerd into a
+// file, and replays them against another cri-containerd, so that node-level
+// regressions found in production could be reproduced as test cases.
+package rpcrecord
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// redacted replaces sensitive values in recorded requests.
+const redacted = "<redacted>"
+
+// Record is a recorded cri request. Records are written as json lines.
+type Record struct {
+	// Time is the time the request is received.
+	Time time.Time `json:"time"`
+	// Method is the full grpc method of the request.
+	Method string `json:"method"`
+	// Request is the json encoded request with sensitive values redacted.
+	Request json.RawMessage `json:"request"`
+	// ID is the id of the sandbox or container created by the request. It
+	// is used to map ids in later requests when replaying.
+	ID string `json:"id,omitempty"`
+	// Error is the error returned by the request, empty if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// message creates a new request and response for a cri method.
+type message func() (req proto.Message, resp proto.Message)
+
+// methods are all unary cri methods with their request and response types.
+var methods = map[string]message{
+	"/runtime.RuntimeService/Version": func() (proto.Message, proto.Message) {
+		return &runtime.VersionRequest{}, &runtime.VersionResponse{}
+	},
+	"/runtime.RuntimeService/RunPodSandbox": func() (proto.Message, proto.Message) {
+		return &runtime.RunPodSandboxRequest{}, &runtime.RunPodSandboxResponse{}
+	},
+	"/runtime.RuntimeService/StopPodSandbox": func() (proto.Message, proto.Message) {
+		return &runtime.StopPodSandboxRequest{}, &runtime.StopPodSandboxResponse{}
+	},
+	"/runtime.RuntimeService/RemovePodSandbox": func() (proto.Message, proto.Message) {
+		return &runtime.RemovePodSandboxRequest{}, &runtime.RemovePodSandboxResponse{}
+	},
+	"/runtime.RuntimeService/PodSandboxStatus": func() (proto.Message, proto.Message) {
+		return &runtime.PodSandboxStatusRequest{}, &runtime.PodSandboxStatusResponse{}
+	},
+	"/runtime.RuntimeService/ListPodSandbox": func() (proto.Message, proto.Message) {
+		return &runtime.ListPodSandboxRequest{}, &runtime.ListPodSandboxResponse{}
+	},
+	"/runtime.RuntimeService/CreateContainer": func() (proto.Message, proto.Message) {
+		return &runtime.CreateContainerRequest{}, &runtime.CreateContainerResponse{}
+	},
+	"/runtime.RuntimeService/StartContainer": func() (proto.Message, proto.Message) {
+		return &runtime.StartContainerRequest{}, &runtime.StartContainerResponse{}
+	},
+	"/runtime.RuntimeService/StopContainer": func() (proto.Message, proto.Message) {
+		return &runtime.StopContainerRequest{}, &runtime.StopContainerResponse{}
+	},
+	"/runtime.RuntimeService/RemoveContainer": func() (proto.Message, proto.Message) {
+		return &runtime.RemoveContainerRequest{}, &runtime.RemoveContainerResponse{}
+	},
+	"/runtime.RuntimeService/ListContainers": func() (proto.Message, proto.Message) {
+		return &runtime.ListContainersRequest{}, &runtime.ListContainersResponse{}
+	},
+	"/runtime.RuntimeService/ContainerStatus": func() (proto.Message, proto.Message) {
+		return &runtime.ContainerStatusRequest{}, &runtime.ContainerStatusResponse{}
+	},
+	"/runtime.RuntimeService/ExecSync": func() (proto.Message, proto.Message) {
+		return &runtime.ExecSyncRequest{}, &runtime.ExecSyncResponse{}
+	},
+	"/runtime.RuntimeService/Exec": func() (proto.Message, proto.Message) {
+		return &runtime.ExecRequest{}, &runtime.ExecResponse{}
+	},
+	"/runtime.RuntimeService/Attach": func() (proto.Message, proto.Message) {
+		return &runtime.AttachRequest{}, &runtime.AttachResponse{}
+	},
+	"/runtime.RuntimeService/PortForward": func() (proto.Message, proto.Message) {
+		return &runtime.PortForwardRequest{}, &runtime.PortForwardResponse{}
+	},
+	"/runtime.RuntimeService/ContainerStats": func() (proto.Message, proto.Message) {
+		return &runtime.ContainerStatsRequest{}, &runtime.ContainerStatsResponse{}
+	},
+	"/runtime.RuntimeService/ListContainerStats": func() (proto.Message, proto.Message) {
+		return &runtime.ListContainerStatsRequest{}, &runtime.ListContainerStatsResponse{}
+	},
+	"/runtime.RuntimeService/UpdateRuntimeConfig": func() (proto.Message, proto.Message) {
+		return &runtime.UpdateRuntimeConfigRequest{}, &runtime.UpdateRuntimeConfigResponse{}
+	},
+	"/runtime.RuntimeService/Status": func() (proto.Message, proto.Message) {
+		return &runtime.StatusRequest{}, &runtime.StatusResponse{}
+	},
+	"/runtime.ImageService/ListImages": func() (proto.Message, proto.Message) {
+		return &runtime.ListImagesRequest{}, &runtime.ListImagesResponse{}
+	},
+	"/runtime.ImageService/ImageStatus": func() (proto.Message, proto.Message) {
+		return &runtime.ImageStatusRequest{}, &runtime.ImageStatusResponse{}
+	},
+	"/runtime.ImageService/PullImage": func() (proto.Message, proto.Message) {
+		return &runtime.PullImageRequest{}, &runtime.PullImageResponse{}
+	},
+	"/runtime.ImageService/RemoveImage": func() (proto.Message, proto.Message) {
+		return &runtime.RemoveImageRequest{}, &runtime.RemoveImageResponse{}
+	},
+	"/runtime.ImageService/ImageFsInfo": func() (proto.Message, proto.Message) {
+		return &runtime.ImageFsInfoRequest{}, &runtime.ImageFsInfoResponse{}
+	},
+}
+
+// Sanitize returns a copy of the request with registry credentials and
+// container environment variable values redacted. Other values, e.g.
+// responses, are returned as is.
+func Sanitize(req interface{}) interface{} {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return req
+	}
+	msg = proto.Clone(msg)
+	switch r := msg.(type) {
+	case *runtime.PullImageRequest:
+		if r.Auth != nil {
+			r.Auth = &runtime.AuthConfig{ServerAddress: r.Auth.ServerAddress}
+		}
+	case *runtime.CreateContainerRequest:
+		for _, env := range r.GetConfig().GetEnvs() {
+			env.Value = redacted
+		}
+	}
+	return msg
+}
+
+// createdID returns the id of the sandbox or container in the response.
+func createdID(resp interface{}) string {
+	switch r := resp.(type) {
+	case *runtime.RunPodSandboxResponse:
+		return r.GetPodSandboxId()
+	case *runtime.CreateContainerResponse:
+		return r.GetContainerId()
+	}
+	return ""
+}
+
+// Recorder records cri requests into a file.
+type Recorder struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewRecorder creates a recorder appending records to the file.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rpc record file %q: %v", path, err)
+	}
+	return &Recorder{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record records the request with its result. A nil recorder records nothing.
+func (r *Recorder) Record(start time.Time, method string, req, resp interface{}, err error) error {
+	if r == nil {
+		return nil
+	}
+	if _, ok := methods[method]; !ok {
+		return nil
+	}
+	data, encErr := json.Marshal(Sanitize(req))
+	if encErr != nil {
+		return fmt.Errorf("failed to marshal %s request: %v", method, encErr)
+	}
+	record := Record{
+		Time:    start,
+		Method:  method,
+		Request: data,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.ID = createdID(resp)
+	}
+	r.Lock()
+	defer r.Unlock()
+	if err := r.enc.Encode(record); err != nil {
+		return fmt.Errorf("failed to write %s record: %v", method, err)
+	}
+	return nil
+}
+
+// Close closes the record file.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
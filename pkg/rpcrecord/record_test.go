@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpcrecord
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func TestSanitize(t *testing.T) {
+	for desc, test := range map[string]struct {
+		req    interface{}
+		expect interface{}
+	}{
+		"pull image credentials should be redacted": {
+			req: &runtime.PullImageRequest{
+				Image: &runtime.ImageSpec{Image: "busybox"},
+				Auth: &runtime.AuthConfig{
+					Username:      "user",
+					Password:      "password",
+					ServerAddress: "gcr.io",
+					RegistryToken: "token",
+				},
+			},
+			expect: &runtime.PullImageRequest{
+				Image: &runtime.ImageSpec{Image: "busybox"},
+				Auth:  &runtime.AuthConfig{ServerAddress: "gcr.io"},
+			},
+		},
+		"container environment variable values should be redacted": {
+			req: &runtime.CreateContainerRequest{
+				PodSandboxId: "sandbox-id",
+				Config: &runtime.ContainerConfig{
+					Envs: []*runtime.KeyValue{{Key: "PASSWORD", Value: "secret"}},
+				},
+			},
+			expect: &runtime.CreateContainerRequest{
+				PodSandboxId: "sandbox-id",
+				Config: &runtime.ContainerConfig{
+					Envs: []*runtime.KeyValue{{Key: "PASSWORD", Value: redacted}},
+				},
+			},
+		},
+		"other requests should not be changed": {
+			req:    &runtime.StopContainerRequest{ContainerId: "container-id", Timeout: 10},
+			expect: &runtime.StopContainerRequest{ContainerId: "container-id", Timeout: 10},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expect, Sanitize(test.req))
+	}
+
+	t.Logf("original request should not be changed")
+	req := &runtime.PullImageRequest{Auth: &runtime.AuthConfig{Password: "password"}}
+	Sanitize(req)
+	assert.Equal(t, "password", req.Auth.Password)
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc-record-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records")
+
+	r, err := NewRecorder(path)
+	require.NoError(t, err)
+	start := time.Unix(0, 100).UTC()
+	require.NoError(t, r.Record(start, "/runtime.RuntimeService/RunPodSandbox",
+		&runtime.RunPodSandboxRequest{}, &runtime.RunPodSandboxResponse{PodSandboxId: "sandbox-id"}, nil))
+	require.NoError(t, r.Record(start, "/runtime.RuntimeService/StartContainer",
+		&runtime.StartContainerRequest{ContainerId: "container-id"}, nil, errors.New("start failed")))
+	t.Logf("non-cri methods should not be recorded")
+	require.NoError(t, r.Record(start, "/grpc.health.v1.Health/Check", nil, nil, nil))
+	require.NoError(t, r.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := ReadRecords(f)
+	require.NoError(t, err)
+	assert.Equal(t, []Record{
+		{
+			Time:    start,
+			Method:  "/runtime.RuntimeService/RunPodSandbox",
+			Request: []byte(`{}`),
+			ID:      "sandbox-id",
+		},
+		{
+			Time:    start,
+			Method:  "/runtime.RuntimeService/StartContainer",
+			Request: []byte(`{"container_id":"container-id"}`),
+			Error:   "start failed",
+		},
+	}, records)
+
+	t.Logf("nil recorder should record nothing")
+	var nilRecorder *Recorder
+	assert.NoError(t, nilRecorder.Record(start, "/runtime.RuntimeService/Version", &runtime.VersionRequest{}, nil, nil))
+	assert.NoError(t, nilRecorder.Close())
+}
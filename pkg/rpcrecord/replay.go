@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpcrecord
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// maxRecordSize is the max size of a record line.
+const maxRecordSize = 16 * 1024 * 1024
+
+// Result is the result of a replayed request.
+type Result struct {
+	// Method is the full grpc method of the request.
+	Method string `json:"method"`
+	// RecordedError is the error of the request when it was recorded.
+	RecordedError string `json:"recordedError,omitempty"`
+	// Error is the error of the request when it is replayed.
+	Error string `json:"error,omitempty"`
+	// Mismatch means the request succeeded when it was recorded but fails
+	// when it is replayed, or the other way around.
+	Mismatch bool `json:"mismatch"`
+}
+
+// ReadRecords reads json line records.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record at line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read records: %v", err)
+	}
+	return records, nil
+}
+
+// Replayer replays records in order against a cri-containerd. Sandbox and
+// container ids in the records are mapped to the ids created when replaying.
+type Replayer struct {
+	conn *grpc.ClientConn
+	// ids maps recorded sandbox and container ids to replayed ones.
+	ids map[string]string
+}
+
+// NewReplayer creates a replayer sending requests through the connection.
+func NewReplayer(conn *grpc.ClientConn) *Replayer {
+	return &Replayer{conn: conn, ids: make(map[string]string)}
+}
+
+// Replay replays the record. An error is returned if the record can't be
+// replayed, failures of the request itself are reported in the result.
+func (r *Replayer) Replay(ctx context.Context, record Record) (*Result, error) {
+	newMessage, ok := methods[record.Method]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %q", record.Method)
+	}
+	req, resp := newMessage()
+	data, err := r.mapIDs(record.Request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map ids in %s request: %v", record.Method, err)
+	}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s request: %v", record.Method, err)
+	}
+	result := &Result{Method: record.Method, RecordedError: record.Error}
+	if err := grpc.Invoke(ctx, record.Method, req, resp, r.conn); err != nil {
+		result.Error = err.Error()
+	} else if id := createdID(resp); record.ID != "" && id != "" {
+		r.ids[record.ID] = id
+	}
+	result.Mismatch = (result.Error == "") != (result.RecordedError == "")
+	return result, nil
+}
+
+// mapIDs replaces recorded sandbox and container ids in the json encoded
+// request with the replayed ones. Ids are random, so any string equal to a
+// recorded id is replaced.
+func (r *Replayer) mapIDs(data json.RawMessage) ([]byte, error) {
+	if len(r.ids) == 0 {
+		return data, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.replaceIDs(v))
+}
+
+// replaceIDs replaces recorded ids in the decoded json value.
+func (r *Replayer) replaceIDs(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		if id, ok := r.ids[t]; ok {
+			return id
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = r.replaceIDs(t[i])
+		}
+	case map[string]interface{}:
+		for k := range t {
+			t[k] = r.replaceIDs(t[k])
+		}
+	}
+	return v
+}
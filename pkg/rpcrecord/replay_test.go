@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rpcrecord
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// fakeRuntimeService implements RunPodSandbox and StopPodSandbox, other
+// methods panic.
+type fakeRuntimeService struct {
+	runtime.RuntimeServiceServer
+	stopped []string
+}
+
+func (f *fakeRuntimeService) RunPodSandbox(context.Context, *runtime.RunPodSandboxRequest) (*runtime.RunPodSandboxResponse, error) {
+	return &runtime.RunPodSandboxResponse{PodSandboxId: "replayed-sandbox-id"}, nil
+}
+
+func (f *fakeRuntimeService) StopPodSandbox(_ context.Context, r *runtime.StopPodSandboxRequest) (*runtime.StopPodSandboxResponse, error) {
+	f.stopped = append(f.stopped, r.GetPodSandboxId())
+	return &runtime.StopPodSandboxResponse{}, nil
+}
+
+func TestReadRecords(t *testing.T) {
+	records, err := ReadRecords(strings.NewReader(`{"method":"/runtime.RuntimeService/Version","request":{}}
+
+{"method":"/runtime.RuntimeService/Status","request":{},"error":"not ready"}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []Record{
+		{Method: "/runtime.RuntimeService/Version", Request: []byte(`{}`)},
+		{Method: "/runtime.RuntimeService/Status", Request: []byte(`{}`), Error: "not ready"},
+	}, records)
+
+	_, err = ReadRecords(strings.NewReader("not json\n"))
+	assert.Error(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rpc-replay-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "cri.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	fake := &fakeRuntimeService{}
+	s := grpc.NewServer()
+	runtime.RegisterRuntimeServiceServer(s, fake)
+	go s.Serve(l) // nolint: errcheck
+	defer s.Stop()
+
+	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(10*time.Second),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", addr, timeout)
+		}),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	r := NewReplayer(conn)
+	ctx := context.Background()
+	for desc, test := range []struct {
+		record       Record
+		expectResult *Result
+		expectErr    bool
+	}{
+		{
+			record: Record{
+				Method:  "/runtime.RuntimeService/RunPodSandbox",
+				Request: []byte(`{}`),
+				ID:      "recorded-sandbox-id",
+			},
+			expectResult: &Result{Method: "/runtime.RuntimeService/RunPodSandbox"},
+		},
+		{
+			record: Record{
+				Method:  "/runtime.RuntimeService/StopPodSandbox",
+				Request: []byte(`{"pod_sandbox_id":"recorded-sandbox-id"}`),
+				Error:   "stop failed",
+			},
+			expectResult: &Result{
+				Method:        "/runtime.RuntimeService/StopPodSandbox",
+				RecordedError: "stop failed",
+				Mismatch:      true,
+			},
+		},
+		{
+			record:    Record{Method: "/unknown/Method", Request: []byte(`{}`)},
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %d", desc)
+		result, err := r.Replay(ctx, test.record)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectResult, result)
+	}
+	assert.Equal(t, []string{"replayed-sandbox-id"}, fake.stopped)
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+const (
+	// admissionOperationRunPodSandbox is the admission operation of RunPodSandbox.
+	admissionOperationRunPodSandbox = "RunPodSandbox"
+	// admissionOperationCreateContainer is the admission operation of CreateContainer.
+	admissionOperationCreateContainer = "CreateContainer"
+)
+
+// admissionPolicy is a set of simple rules evaluated before RunPodSandbox and
+// CreateContainer. It is a node-level backstop, a request is rejected if it
+// breaks any rule.
+type admissionPolicy struct {
+	// AllowedRegistries are registries container images must come from.
+	// Empty means images from any registry are allowed.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// DenyPrivileged rejects privileged sandboxes and containers.
+	DenyPrivileged bool `json:"denyPrivileged,omitempty"`
+	// DenyHostNetwork rejects pods using the host network namespace.
+	DenyHostNetwork bool `json:"denyHostNetwork,omitempty"`
+	// DenyHostPID rejects pods using the host pid namespace.
+	DenyHostPID bool `json:"denyHostPID,omitempty"`
+	// DenyHostIPC rejects pods using the host ipc namespace.
+	DenyHostIPC bool `json:"denyHostIPC,omitempty"`
+	// DeniedAnnotations are annotations, in the form of `key` or `key=value`,
+	// a request must not have.
+	DeniedAnnotations []string `json:"deniedAnnotations,omitempty"`
+	// ExemptNamespaces are pod namespaces the policy doesn't apply to.
+	ExemptNamespaces []string `json:"exemptNamespaces,omitempty"`
+}
+
+// admissionRequest describes a request under admission. It is also the body
+// posted to the admission webhook.
+type admissionRequest struct {
+	// Operation is either RunPodSandbox or CreateContainer.
+	Operation string `json:"operation"`
+	// Namespace is the namespace of the pod.
+	Namespace string `json:"namespace"`
+	// Pod is the name of the pod.
+	Pod string `json:"pod"`
+	// Container is the name of the container, empty for RunPodSandbox.
+	Container string `json:"container,omitempty"`
+	// Images are the known names of the container image, empty for RunPodSandbox.
+	Images []string `json:"images,omitempty"`
+	// Privileged is whether the sandbox or container is privileged.
+	Privileged bool `json:"privileged"`
+	// HostNetwork is whether the pod uses the host network namespace.
+	HostNetwork bool `json:"hostNetwork"`
+	// HostPID is whether the pod uses the host pid namespace.
+	HostPID bool `json:"hostPID"`
+	// HostIPC is whether the pod uses the host ipc namespace.
+	HostIPC bool `json:"hostIPC"`
+	// Annotations are annotations of the sandbox or container.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// DryRun is whether the request is from a dry run, which doesn't create
+	// anything. The webhook should not have side effects for it.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// admissionResponse is the response of the admission webhook.
+type admissionResponse struct {
+	// Allowed is whether the request is allowed.
+	Allowed bool `json:"allowed"`
+	// Reason is the reason of rejection.
+	Reason string `json:"reason,omitempty"`
+}
+
+// admissionController admits requests with the policy and the webhook.
+type admissionController struct {
+	// policy is the local admission policy, nil means no policy.
+	policy *admissionPolicy
+	// webhook is the url of the admission webhook, empty means no webhook.
+	webhook string
+	// client is the http client calling the webhook.
+	client *http.Client
+	// failOpen allows requests when the webhook can't be reached.
+	failOpen bool
+}
+
+// newAdmissionController creates an admission controller. The policy is loaded
+// from the json policy file if it is specified.
+func newAdmissionController(policyFile, webhook string, timeout time.Duration, failOpen bool) (*admissionController, error) {
+	a := &admissionController{
+		webhook:  webhook,
+		client:   &http.Client{Timeout: timeout},
+		failOpen: failOpen,
+	}
+	if policyFile != "" {
+		var policy admissionPolicy
+		if err := readJSONFile(policyFile, &policy); err != nil {
+			return nil, fmt.Errorf("failed to load admission policy %q: %v", policyFile, err)
+		}
+		a.policy = &policy
+	}
+	return a, nil
+}
+
+// admit evaluates the policy and then calls the webhook. It returns a cri
+// error with PermissionDenied code if the request is rejected.
+func (a *admissionController) admit(ctx context.Context, req *admissionRequest) error {
+	if a == nil {
+		return nil
+	}
+	if a.policy != nil {
+		if reason := a.policy.evaluate(req); reason != "" {
+			return newCRIError(codes.PermissionDenied, ReasonAdmissionDenied, "%s of %s/%s rejected by admission policy: %s",
+				req.Operation, req.Namespace, req.Pod, reason)
+		}
+	}
+	if a.webhook == "" {
+		return nil
+	}
+	resp, err := a.callWebhook(ctx, req)
+	if err != nil {
+		if a.failOpen {
+			glog.Warningf("Allow %s of %s/%s because admission webhook failed: %v",
+				req.Operation, req.Namespace, req.Pod, err)
+			return nil
+		}
+		return newCRIError(codes.Unavailable, ReasonAdmissionDenied, "%s of %s/%s failed admission: %v",
+			req.Operation, req.Namespace, req.Pod, err)
+	}
+	if !resp.Allowed {
+		return newCRIError(codes.PermissionDenied, ReasonAdmissionDenied, "%s of %s/%s rejected by admission webhook: %s",
+			req.Operation, req.Namespace, req.Pod, resp.Reason)
+	}
+	return nil
+}
+
+// callWebhook posts the request to the admission webhook.
+func (a *admissionController) callWebhook(ctx context.Context, req *admissionRequest) (*admissionResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal admission request: %v", err)
+	}
+	httpResp, err := ctxhttp.Post(ctx, a.client, a.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call admission webhook %q: %v", a.webhook, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admission webhook %q returns unexpected status %q", a.webhook, httpResp.Status)
+	}
+	var resp admissionResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode admission webhook response: %v", err)
+	}
+	return &resp, nil
+}
+
+// evaluate returns the reason if the request breaks any rule of the policy,
+// or empty string if the request is allowed.
+func (p *admissionPolicy) evaluate(req *admissionRequest) string {
+	for _, ns := range p.ExemptNamespaces {
+		if ns == req.Namespace {
+			return ""
+		}
+	}
+	if p.DenyPrivileged && req.Privileged {
+		return "privileged is not allowed"
+	}
+	if p.DenyHostNetwork && req.HostNetwork {
+		return "host network is not allowed"
+	}
+	if p.DenyHostPID && req.HostPID {
+		return "host pid is not allowed"
+	}
+	if p.DenyHostIPC && req.HostIPC {
+		return "host ipc is not allowed"
+	}
+	for _, denied := range p.DeniedAnnotations {
+		if hasAnnotation(req.Annotations, denied) {
+			return fmt.Sprintf("annotation %q is not allowed", denied)
+		}
+	}
+	if len(p.AllowedRegistries) > 0 && len(req.Images) > 0 && !p.registryAllowed(req.Images) {
+		return fmt.Sprintf("image %q is not from an allowed registry", req.Images[0])
+	}
+	return ""
+}
+
+// registryAllowed returns true if any of the image names is from an allowed
+// registry. Names which are not valid references, e.g. image ids, are ignored.
+func (p *admissionPolicy) registryAllowed(images []string) bool {
+	for _, image := range images {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			continue
+		}
+		domain := reference.Domain(named)
+		for _, registry := range p.AllowedRegistries {
+			if domain == registry {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newSandboxAdmissionRequest creates the admission request of RunPodSandbox.
+func newSandboxAdmissionRequest(config *runtime.PodSandboxConfig) *admissionRequest {
+	securityContext := config.GetLinux().GetSecurityContext()
+	nsOptions := securityContext.GetNamespaceOptions()
+	return &admissionRequest{
+		Operation:   admissionOperationRunPodSandbox,
+		Namespace:   config.GetMetadata().GetNamespace(),
+		Pod:         config.GetMetadata().GetName(),
+		Privileged:  securityContext.GetPrivileged(),
+		HostNetwork: nsOptions.GetHostNetwork(),
+		HostPID:     nsOptions.GetHostPid(),
+		HostIPC:     nsOptions.GetHostIpc(),
+		Annotations: config.GetAnnotations(),
+	}
+}
+
+// newContainerAdmissionRequest creates the admission request of CreateContainer.
+// The image names include the requested image and all references of the
+// resolved image, because the kubelet may create containers with image ids.
+func newContainerAdmissionRequest(config *runtime.ContainerConfig, sandboxConfig *runtime.PodSandboxConfig,
+	image *imagestore.Image) *admissionRequest {
+	nsOptions := sandboxConfig.GetLinux().GetSecurityContext().GetNamespaceOptions()
+	images := []string{config.GetImage().GetImage()}
+	images = append(images, image.RepoTags...)
+	images = append(images, image.RepoDigests...)
+	return &admissionRequest{
+		Operation:   admissionOperationCreateContainer,
+		Namespace:   sandboxConfig.GetMetadata().GetNamespace(),
+		Pod:         sandboxConfig.GetMetadata().GetName(),
+		Container:   config.GetMetadata().GetName(),
+		Images:      images,
+		Privileged:  config.GetLinux().GetSecurityContext().GetPrivileged(),
+		HostNetwork: nsOptions.GetHostNetwork(),
+		HostPID:     nsOptions.GetHostPid(),
+		HostIPC:     nsOptions.GetHostIpc(),
+		Annotations: config.GetAnnotations(),
+	}
+}
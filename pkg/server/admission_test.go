@@ -0,0 +1,212 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+func TestAdmissionPolicyEvaluate(t *testing.T) {
+	policy := &admissionPolicy{
+		AllowedRegistries: []string{"docker.io", "gcr.io"},
+		DenyPrivileged:    true,
+		DenyHostNetwork:   true,
+		DenyHostPID:       true,
+		DenyHostIPC:       true,
+		DeniedAnnotations: []string{"a", "b=c"},
+		ExemptNamespaces:  []string{"kube-system"},
+	}
+	for desc, test := range map[string]struct {
+		req    admissionRequest
+		denied bool
+	}{
+		"plain request should be allowed": {
+			req: admissionRequest{Namespace: "ns", Images: []string{"busybox"}},
+		},
+		"privileged should be denied": {
+			req:    admissionRequest{Namespace: "ns", Privileged: true},
+			denied: true,
+		},
+		"host network should be denied": {
+			req:    admissionRequest{Namespace: "ns", HostNetwork: true},
+			denied: true,
+		},
+		"host pid should be denied": {
+			req:    admissionRequest{Namespace: "ns", HostPID: true},
+			denied: true,
+		},
+		"host ipc should be denied": {
+			req:    admissionRequest{Namespace: "ns", HostIPC: true},
+			denied: true,
+		},
+		"denied annotation key should be denied": {
+			req:    admissionRequest{Namespace: "ns", Annotations: map[string]string{"a": "any"}},
+			denied: true,
+		},
+		"denied annotation key value should be denied": {
+			req:    admissionRequest{Namespace: "ns", Annotations: map[string]string{"b": "c"}},
+			denied: true,
+		},
+		"annotation with other value should be allowed": {
+			req: admissionRequest{Namespace: "ns", Annotations: map[string]string{"b": "d"}},
+		},
+		"image from other registry should be denied": {
+			req:    admissionRequest{Namespace: "ns", Images: []string{"quay.io/test/image:latest"}},
+			denied: true,
+		},
+		"image id with allowed repo tag should be allowed": {
+			req: admissionRequest{Namespace: "ns", Images: []string{
+				"sha256:c75bebcdd211f41b3a460c7bf82970ed6c75acaab9cd4c9a4e125b03ca113798",
+				"gcr.io/test/image:latest",
+			}},
+		},
+		"exempt namespace should be allowed": {
+			req: admissionRequest{Namespace: "kube-system", Privileged: true, HostNetwork: true},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		reason := policy.evaluate(&test.req)
+		assert.Equal(t, test.denied, reason != "", reason)
+	}
+}
+
+func TestAdmissionWebhook(t *testing.T) {
+	var got admissionRequest
+	allowed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(admissionResponse{Allowed: allowed, Reason: "test reason"}) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	a := &admissionController{webhook: server.URL, client: &http.Client{Timeout: time.Second}}
+	req := &admissionRequest{Operation: admissionOperationRunPodSandbox, Namespace: "ns", Pod: "pod"}
+
+	t.Logf("should allow request allowed by webhook")
+	assert.NoError(t, a.admit(context.Background(), req))
+	assert.Equal(t, *req, got)
+
+	t.Logf("should reject request rejected by webhook")
+	allowed = false
+	err := a.admit(context.Background(), req)
+	assert.Equal(t, ReasonAdmissionDenied, ErrorReason(err))
+	assert.Equal(t, codes.PermissionDenied, grpc.Code(toGRPCError(err)))
+	assert.Contains(t, err.Error(), "test reason")
+
+	t.Logf("should reject request when webhook is unreachable")
+	server.Close()
+	err = a.admit(context.Background(), req)
+	assert.Equal(t, codes.Unavailable, grpc.Code(toGRPCError(err)))
+
+	t.Logf("should allow request when webhook is unreachable with fail open")
+	a.failOpen = true
+	assert.NoError(t, a.admit(context.Background(), req))
+}
+
+func TestNewAdmissionController(t *testing.T) {
+	dir, err := ioutil.TempDir("", "admission-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	require.NoError(t, ioutil.WriteFile(policyFile, []byte(`{"denyPrivileged": true, "allowedRegistries": ["gcr.io"]}`), 0644))
+	a, err := newAdmissionController(policyFile, "", time.Second, false)
+	require.NoError(t, err)
+	assert.Equal(t, &admissionPolicy{DenyPrivileged: true, AllowedRegistries: []string{"gcr.io"}}, a.policy)
+
+	_, err = newAdmissionController(filepath.Join(dir, "not-exist.json"), "", time.Second, false)
+	assert.Error(t, err)
+
+	a, err = newAdmissionController("", "", time.Second, false)
+	require.NoError(t, err)
+	assert.NoError(t, a.admit(context.Background(), &admissionRequest{Privileged: true}))
+}
+
+func TestAdmissionRequests(t *testing.T) {
+	sandboxConfig := &runtime.PodSandboxConfig{
+		Metadata:    &runtime.PodSandboxMetadata{Name: "pod", Namespace: "ns"},
+		Annotations: map[string]string{"a": "b"},
+		Linux: &runtime.LinuxPodSandboxConfig{
+			SecurityContext: &runtime.LinuxSandboxSecurityContext{
+				Privileged:       true,
+				NamespaceOptions: &runtime.NamespaceOption{HostNetwork: true, HostIpc: true},
+			},
+		},
+	}
+	assert.Equal(t, &admissionRequest{
+		Operation:   admissionOperationRunPodSandbox,
+		Namespace:   "ns",
+		Pod:         "pod",
+		Privileged:  true,
+		HostNetwork: true,
+		HostIPC:     true,
+		Annotations: map[string]string{"a": "b"},
+	}, newSandboxAdmissionRequest(sandboxConfig))
+
+	config := &runtime.ContainerConfig{
+		Metadata:    &runtime.ContainerMetadata{Name: "container"},
+		Image:       &runtime.ImageSpec{Image: "sha256:abc"},
+		Annotations: map[string]string{"c": "d"},
+	}
+	image := &imagestore.Image{
+		RepoTags:    []string{"gcr.io/test/image:latest"},
+		RepoDigests: []string{"gcr.io/test/image@sha256:def"},
+	}
+	assert.Equal(t, &admissionRequest{
+		Operation:   admissionOperationCreateContainer,
+		Namespace:   "ns",
+		Pod:         "pod",
+		Container:   "container",
+		Images:      []string{"sha256:abc", "gcr.io/test/image:latest", "gcr.io/test/image@sha256:def"},
+		HostNetwork: true,
+		HostIPC:     true,
+		Annotations: map[string]string{"c": "d"},
+	}, newContainerAdmissionRequest(config, sandboxConfig, image))
+}
+
+func TestRunPodSandboxAdmissionDenied(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.admission = &admissionController{policy: &admissionPolicy{DenyHostNetwork: true}}
+	_, err := c.RunPodSandbox(context.Background(), &runtime.RunPodSandboxRequest{
+		Config: &runtime.PodSandboxConfig{
+			Metadata: &runtime.PodSandboxMetadata{Name: "pod", Namespace: "ns"},
+			Linux: &runtime.LinuxPodSandboxConfig{
+				SecurityContext: &runtime.LinuxSandboxSecurityContext{
+					NamespaceOptions: &runtime.NamespaceOption{HostNetwork: true},
+				},
+			},
+		},
+	})
+	assert.Equal(t, ReasonAdmissionDenied, ErrorReason(err))
+	assert.Empty(t, c.sandboxStore.List())
+}
@@ -38,14 +38,51 @@ type Agent interface {
 // AgentFactory is the factory to create required agents.
 type AgentFactory interface {
 	// NewSandboxLogger creates a sandbox logging agent.
-	NewSandboxLogger(io.ReadCloser) Agent
+	NewSandboxLogger(string, io.ReadCloser) Agent
 	// NewContainerLogger creates a container logging agent.
-	NewContainerLogger(string, StreamType, io.ReadCloser) Agent
+	NewContainerLogger(string, string, StreamType, io.ReadCloser) Agent
+	// CheckLeaks stops tracking resources owned by agents of the sandbox or
+	// container, and returns resources which are not released yet. It should
+	// be called after the sandbox or container is removed.
+	CheckLeaks(string) map[ResourceType]int
+	// RecentLogs returns the most recent CRI formatted log lines of a
+	// container kept in memory, and whether they are kept at all.
+	RecentLogs(string) ([]byte, bool)
+	// RemoveRecentLogs drops the recent log lines of a container kept in
+	// memory. It should be called after the container is removed.
+	RemoveRecentLogs(string)
 }
 
-type agentFactory struct{}
+type agentFactory struct {
+	tracker *resourceTracker
+	// logIndexInterval is the interval in bytes of container log index
+	// checkpoints, 0 means container logs are not indexed.
+	logIndexInterval int64
+	// rings keeps the recent log lines of containers in memory.
+	rings *logRings
+}
+
+// NewAgentFactory creates a new agent factory. Container loggers write a log
+// index next to the log file with a checkpoint every logIndexInterval bytes
+// of each stream, if logIndexInterval is positive. The most recent
+// logRingSize bytes of log lines of each container are also kept in memory,
+// if logRingSize is positive.
+func NewAgentFactory(logIndexInterval int64, logRingSize int) AgentFactory {
+	return &agentFactory{
+		tracker:          newResourceTracker(),
+		logIndexInterval: logIndexInterval,
+		rings:            newLogRings(logRingSize),
+	}
+}
+
+func (f *agentFactory) CheckLeaks(id string) map[ResourceType]int {
+	return f.tracker.untrack(id)
+}
+
+func (f *agentFactory) RecentLogs(id string) ([]byte, bool) {
+	return f.rings.read(id)
+}
 
-// NewAgentFactory creates a new agent factory.
-func NewAgentFactory() AgentFactory {
-	return &agentFactory{}
+func (f *agentFactory) RemoveRecentLogs(id string) {
+	f.rings.remove(id)
 }
@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// logIndexSuffix is the suffix of the index file next to the container log.
+const logIndexSuffix = ".index"
+
+// GetLogIndexPath returns the path of the index file of the container log.
+func GetLogIndexPath(logPath string) string {
+	return logPath + logIndexSuffix
+}
+
+// logIndexEntry is a checkpoint in the log index file, which maps a time to
+// the offset of a log line in the log file, so that readers can seek to a
+// time without scanning the whole log. Each entry is a json line.
+type logIndexEntry struct {
+	// Time is the timestamp of the log line.
+	Time time.Time `json:"time"`
+	// Stream is the stream of the log line.
+	Stream StreamType `json:"stream"`
+	// Offset is the offset of the log line in the log file.
+	Offset int64 `json:"offset"`
+	// Rotated means the log file was truncated by rotation before the log
+	// line, and earlier offsets refer to the rotated content.
+	Rotated bool `json:"rotated,omitempty"`
+}
+
+// logIndexer writes checkpoints of a log stream into the log index file. A
+// checkpoint is written for the first log line, the first log line after
+// every interval bytes of the stream, and the first log line after rotation.
+type logIndexer struct {
+	w        io.WriteCloser
+	stream   StreamType
+	interval int64
+	// started is whether any log line is indexed.
+	started bool
+	// checkpoint is the offset of the last checkpoint.
+	checkpoint int64
+	// end is the end offset of the last log line.
+	end int64
+}
+
+// record indexes the log line written at [start, end) of the log file.
+func (x *logIndexer) record(t time.Time, start, end int64) error {
+	// The log file only grows unless it is truncated by rotation, even though
+	// the other stream of the container writes into the same file.
+	rotated := x.started && start < x.end
+	defer func() {
+		x.started = true
+		x.end = end
+	}()
+	if x.started && !rotated && start-x.checkpoint < x.interval {
+		return nil
+	}
+	x.checkpoint = start
+	data, err := json.Marshal(&logIndexEntry{Time: t, Stream: x.stream, Offset: start, Rotated: rotated})
+	if err != nil {
+		return fmt.Errorf("failed to marshal log index entry: %v", err)
+	}
+	_, err = x.w.Write(append(data, eol))
+	return err
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agents
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeLogIndex decodes entries in the log index.
+func decodeLogIndex(t *testing.T, data []byte) []logIndexEntry {
+	var entries []logIndexEntry
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var e logIndexEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLogIndexerRecord(t *testing.T) {
+	buf := &writeCloserBuffer{bytes.NewBuffer(nil)}
+	x := &logIndexer{w: buf, stream: Stdout, interval: 100}
+	now := time.Now()
+	for _, line := range []struct{ start, end int64 }{
+		{0, 50},    // First line is a checkpoint.
+		{50, 90},   // Within the interval.
+		{120, 160}, // The other stream wrote 90-120, past the interval.
+		{160, 200},
+		{10, 40}, // Truncated by rotation.
+		{40, 80},
+	} {
+		require.NoError(t, x.record(now, line.start, line.end))
+	}
+	entries := decodeLogIndex(t, buf.Bytes())
+	require.Len(t, entries, 3)
+	for i, expected := range []struct {
+		offset  int64
+		rotated bool
+	}{{0, false}, {120, false}, {10, true}} {
+		assert.Equal(t, expected.offset, entries[i].Offset)
+		assert.Equal(t, expected.rotated, entries[i].Rotated)
+		assert.Equal(t, Stdout, entries[i].Stream)
+		assert.True(t, now.Equal(entries[i].Time))
+	}
+}
+
+func TestContainerLoggerIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log-index")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "0.log")
+	f := NewAgentFactory(1, 0)
+	rc := ioutil.NopCloser(strings.NewReader("line 1\nline 2\n"))
+	c := f.NewContainerLogger("test-id", logPath, Stderr, rc).(*containerLogger)
+	require.NoError(t, c.Start())
+	tracker := f.(*agentFactory).tracker
+	released := func() bool {
+		tracker.lock.Lock()
+		defer tracker.lock.Unlock()
+		return len(tracker.resources["test-id"]) == 0
+	}
+	for start := time.Now(); !released() && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, f.CheckLeaks("test-id"))
+
+	log, err := ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	index, err := ioutil.ReadFile(GetLogIndexPath(logPath))
+	require.NoError(t, err)
+	entries := decodeLogIndex(t, index)
+	require.Len(t, entries, 2)
+	for i, e := range entries {
+		assert.Equal(t, Stderr, e.Stream)
+		lines := strings.SplitN(string(log[e.Offset:]), "\n", 2)
+		assert.True(t, strings.HasSuffix(lines[0], fmt.Sprintf("stderr line %d", i+1)),
+			"offset should point to the log line, got %q", lines[0])
+	}
+}
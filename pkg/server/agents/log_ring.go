@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agents
+
+import (
+	"bytes"
+	"sync"
+)
+
+// logRing keeps the most recent CRI formatted log lines of a container in
+// memory, so that they are still available for crash triage after the log
+// file is rotated or lost. Both streams of the container share one ring.
+type logRing struct {
+	sync.Mutex
+	// size is the maximum total size in bytes of lines kept in the ring.
+	size  int
+	lines [][]byte
+	bytes int
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{size: size}
+}
+
+// write appends a log line into the ring, and drops the oldest lines when the
+// ring is full. A line larger than the ring is not kept.
+func (r *logRing) write(line []byte) {
+	r.Lock()
+	defer r.Unlock()
+	r.lines = append(r.lines, append([]byte(nil), line...))
+	r.bytes += len(line)
+	for r.bytes > r.size && len(r.lines) > 0 {
+		r.bytes -= len(r.lines[0])
+		r.lines[0] = nil
+		r.lines = r.lines[1:]
+	}
+}
+
+// read returns all lines in the ring, oldest first.
+func (r *logRing) read() []byte {
+	r.Lock()
+	defer r.Unlock()
+	return bytes.Join(r.lines, nil)
+}
+
+// logRings is the set of log rings of all containers.
+type logRings struct {
+	sync.Mutex
+	// size is the size of each ring, 0 means no ring is kept.
+	size  int
+	rings map[string]*logRing
+}
+
+func newLogRings(size int) *logRings {
+	return &logRings{size: size, rings: make(map[string]*logRing)}
+}
+
+// get returns the log ring of a container, and creates one if it doesn't
+// exist. Returns nil if log rings are disabled.
+func (l *logRings) get(id string) *logRing {
+	if l.size <= 0 {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	r, ok := l.rings[id]
+	if !ok {
+		r = newLogRing(l.size)
+		l.rings[id] = r
+	}
+	return r
+}
+
+// read returns the recent log lines of a container.
+func (l *logRings) read(id string) ([]byte, bool) {
+	l.Lock()
+	r, ok := l.rings[id]
+	l.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return r.read(), true
+}
+
+// remove drops the log ring of a container.
+func (l *logRings) remove(id string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.rings, id)
+}
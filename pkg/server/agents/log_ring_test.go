@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agents
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogRingWrite(t *testing.T) {
+	for desc, test := range map[string]struct {
+		size     int
+		lines    []string
+		expected string
+	}{
+		"all lines fit": {
+			size:     10,
+			lines:    []string{"a\n", "b\n"},
+			expected: "a\nb\n",
+		},
+		"oldest lines dropped": {
+			size:     5,
+			lines:    []string{"aa\n", "bb\n", "c\n"},
+			expected: "bb\nc\n",
+		},
+		"line larger than ring": {
+			size:     5,
+			lines:    []string{"a\n", "bbbbbb\n"},
+			expected: "",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		r := newLogRing(test.size)
+		for _, line := range test.lines {
+			r.write([]byte(line))
+		}
+		assert.Equal(t, test.expected, string(r.read()))
+	}
+}
+
+// failingWriteCloser fails all writes, e.g. when the log file is lost.
+type failingWriteCloser struct{}
+
+func (failingWriteCloser) Write([]byte) (int, error) { return 0, errors.New("write error") }
+
+func (failingWriteCloser) Close() error { return nil }
+
+func TestContainerLoggerRecentLogs(t *testing.T) {
+	f := NewAgentFactory(0, 1024)
+	for _, stream := range []StreamType{Stdout, Stderr} {
+		rc := ioutil.NopCloser(strings.NewReader("test " + string(stream) + " log\n"))
+		c := f.NewContainerLogger("test-id", "test-path", stream, rc).(*containerLogger)
+		c.redirectLogs(failingWriteCloser{})
+	}
+	logs, ok := f.RecentLogs("test-id")
+	assert.True(t, ok)
+	lines := strings.Split(strings.TrimSuffix(string(logs), "\n"), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.True(t, strings.HasSuffix(lines[0], " stdout test stdout log"))
+		assert.True(t, strings.HasSuffix(lines[1], " stderr test stderr log"))
+	}
+
+	f.RemoveRecentLogs("test-id")
+	_, ok = f.RecentLogs("test-id")
+	assert.False(t, ok)
+
+	t.Logf("recent logs should not be kept when disabled")
+	f = NewAgentFactory(0, 0)
+	rc := ioutil.NopCloser(strings.NewReader("test log\n"))
+	f.NewContainerLogger("test-id", "test-path", Stdout, rc).(*containerLogger).redirectLogs(failingWriteCloser{})
+	_, ok = f.RecentLogs("test-id")
+	assert.False(t, ok)
+}
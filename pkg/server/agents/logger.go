@@ -45,18 +45,24 @@ const (
 // sandboxLogger is the log agent used for sandbox.
 // It discards sandbox all output for now.
 type sandboxLogger struct {
-	rc io.ReadCloser
+	id      string
+	rc      io.ReadCloser
+	tracker *resourceTracker
 }
 
-func (*agentFactory) NewSandboxLogger(rc io.ReadCloser) Agent {
-	return &sandboxLogger{rc: rc}
+func (f *agentFactory) NewSandboxLogger(id string, rc io.ReadCloser) Agent {
+	return &sandboxLogger{id: id, rc: rc, tracker: f.tracker}
 }
 
 func (s *sandboxLogger) Start() error {
+	s.tracker.acquire(s.id, FIFO)
+	s.tracker.acquire(s.id, Goroutine)
 	go func() {
+		defer s.tracker.release(s.id, Goroutine)
 		// Discard the output for now.
 		io.Copy(ioutil.Discard, s.rc) // nolint: errcheck
 		s.rc.Close()
+		s.tracker.release(s.id, FIFO)
 	}()
 	return nil
 }
@@ -65,16 +71,29 @@ func (s *sandboxLogger) Start() error {
 // It redirect container log into CRI log file, and decorate the log
 // line into CRI defined format.
 type containerLogger struct {
-	path   string
-	stream StreamType
-	rc     io.ReadCloser
+	id      string
+	path    string
+	stream  StreamType
+	rc      io.ReadCloser
+	tracker *resourceTracker
+	// indexInterval is the interval in bytes of log index checkpoints, 0 means
+	// the log is not indexed.
+	indexInterval int64
+	// index indexes the log, nil if the log is not indexed.
+	index *logIndexer
+	// ring keeps the recent log lines in memory, nil if disabled.
+	ring *logRing
 }
 
-func (*agentFactory) NewContainerLogger(path string, stream StreamType, rc io.ReadCloser) Agent {
+func (f *agentFactory) NewContainerLogger(id, path string, stream StreamType, rc io.ReadCloser) Agent {
 	return &containerLogger{
-		path:   path,
-		stream: stream,
-		rc:     rc,
+		id:            id,
+		path:          path,
+		stream:        stream,
+		rc:            rc,
+		tracker:       f.tracker,
+		indexInterval: f.logIndexInterval,
+		ring:          f.rings.get(id),
 	}
 }
 
@@ -84,13 +103,41 @@ func (c *containerLogger) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to open log file %q: %v", c.path, err)
 	}
-	go c.redirectLogs(wc)
+	if c.indexInterval > 0 {
+		indexPath := GetLogIndexPath(c.path)
+		iwc, err := os.OpenFile(indexPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+		if err != nil {
+			wc.Close()
+			return fmt.Errorf("failed to open log index file %q: %v", indexPath, err)
+		}
+		c.tracker.acquire(c.id, FD)
+		c.index = &logIndexer{w: iwc, stream: c.stream, interval: c.indexInterval}
+	}
+	c.tracker.acquire(c.id, FD)
+	c.tracker.acquire(c.id, FIFO)
+	c.tracker.acquire(c.id, Goroutine)
+	go func() {
+		defer c.tracker.release(c.id, Goroutine)
+		c.redirectLogs(wc)
+	}()
 	return nil
 }
 
 func (c *containerLogger) redirectLogs(wc io.WriteCloser) {
-	defer c.rc.Close()
-	defer wc.Close()
+	defer func() {
+		c.rc.Close()
+		c.tracker.release(c.id, FIFO)
+	}()
+	defer func() {
+		wc.Close()
+		c.tracker.release(c.id, FD)
+	}()
+	if c.index != nil {
+		defer func() {
+			c.index.w.Close()
+			c.tracker.release(c.id, FD)
+		}()
+	}
 	streamBytes := []byte(c.stream)
 	delimiterBytes := []byte{delimiter}
 	r := bufio.NewReaderSize(c.rc, bufSize)
@@ -105,12 +152,39 @@ func (c *containerLogger) redirectLogs(wc io.WriteCloser) {
 			glog.Errorf("An error occurred when redirecting log file %q: %v", c.path, err)
 			return
 		}
-		timestampBytes := time.Now().AppendFormat(nil, time.RFC3339Nano)
+		timestamp := time.Now()
+		timestampBytes := timestamp.AppendFormat(nil, time.RFC3339Nano)
 		data := bytes.Join([][]byte{timestampBytes, streamBytes, lineBytes}, delimiterBytes)
 		data = append(data, eol)
+		if c.ring != nil {
+			// Keep the line even if it can't be written into the log file.
+			c.ring.write(data)
+		}
 		if _, err := wc.Write(data); err != nil {
 			glog.Errorf("Fail to write log line %q: %v", data, err)
+			// Continue on write error to drain the input.
+			continue
 		}
-		// Continue on write error to drain the input.
+		c.indexLine(wc, timestamp, int64(len(data)))
+	}
+}
+
+// indexLine records the log line just written into the log index. The log
+// file is opened in append mode, so the current offset is the end of the line.
+func (c *containerLogger) indexLine(wc io.WriteCloser, timestamp time.Time, size int64) {
+	if c.index == nil {
+		return
+	}
+	seeker, ok := wc.(io.Seeker)
+	if !ok {
+		return
+	}
+	end, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		glog.Errorf("Failed to get offset of log file %q: %v", c.path, err)
+		return
+	}
+	if err := c.index.record(timestamp, end-size, end); err != nil {
+		glog.Errorf("Failed to index log file %q: %v", c.path, err)
 	}
 }
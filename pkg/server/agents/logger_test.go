@@ -37,7 +37,7 @@ type writeCloserBuffer struct {
 func (*writeCloserBuffer) Close() error { return nil }
 
 func TestRedirectLogs(t *testing.T) {
-	f := NewAgentFactory()
+	f := NewAgentFactory(0, 0)
 	// f.NewContainerLogger(
 	for desc, test := range map[string]struct {
 		input   string
@@ -71,7 +71,7 @@ func TestRedirectLogs(t *testing.T) {
 	} {
 		t.Logf("TestCase %q", desc)
 		rc := ioutil.NopCloser(strings.NewReader(test.input))
-		c := f.NewContainerLogger("test-path", test.stream, rc).(*containerLogger)
+		c := f.NewContainerLogger("test-id", "test-path", test.stream, rc).(*containerLogger)
 		wc := &writeCloserBuffer{bytes.NewBuffer(nil)}
 		c.redirectLogs(wc)
 		output := wc.String()
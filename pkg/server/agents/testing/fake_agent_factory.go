@@ -40,11 +40,24 @@ func NewFakeAgentFactory() agents.AgentFactory {
 }
 
 // NewSandboxLogger creates a fake agent as sandbox logger.
-func (*FakeAgentFactory) NewSandboxLogger(rc io.ReadCloser) agents.Agent {
+func (*FakeAgentFactory) NewSandboxLogger(string, io.ReadCloser) agents.Agent {
 	return &FakeAgent{}
 }
 
 // NewContainerLogger creates a fake agent as container logger.
-func (*FakeAgentFactory) NewContainerLogger(string, agents.StreamType, io.ReadCloser) agents.Agent {
+func (*FakeAgentFactory) NewContainerLogger(string, string, agents.StreamType, io.ReadCloser) agents.Agent {
 	return &FakeAgent{}
 }
+
+// CheckLeaks always returns no leak.
+func (*FakeAgentFactory) CheckLeaks(string) map[agents.ResourceType]int {
+	return nil
+}
+
+// RecentLogs always returns no recent logs.
+func (*FakeAgentFactory) RecentLogs(string) ([]byte, bool) {
+	return nil, false
+}
+
+// RemoveRecentLogs does nothing.
+func (*FakeAgentFactory) RemoveRecentLogs(string) {}
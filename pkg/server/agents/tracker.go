@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agents
+
+import "sync"
+
+// ResourceType is the type of a resource owned by a container.
+type ResourceType string
+
+const (
+	// FIFO is a container stream fifo.
+	FIFO ResourceType = "fifo"
+	// Goroutine is a goroutine started for a container.
+	Goroutine ResourceType = "goroutine"
+	// FD is a file descriptor opened for a container, e.g. log file.
+	FD ResourceType = "fd"
+)
+
+// resourceTracker tracks resources owned by each container, so that resources
+// not released after the container is removed could be detected.
+// resourceTracker is safe for concurrent access.
+type resourceTracker struct {
+	lock      sync.Mutex
+	resources map[string]map[ResourceType]int
+}
+
+// newResourceTracker creates an empty resource tracker.
+func newResourceTracker() *resourceTracker {
+	return &resourceTracker{resources: make(map[string]map[ResourceType]int)}
+}
+
+// acquire records that the container owns one more resource of the type.
+func (t *resourceTracker) acquire(id string, r ResourceType) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.resources[id]; !ok {
+		t.resources[id] = make(map[ResourceType]int)
+	}
+	t.resources[id][r]++
+}
+
+// release records that one resource of the type owned by the container is released.
+// Resources of untracked containers are ignored, because they have been reported.
+func (t *resourceTracker) release(id string, r ResourceType) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	owned, ok := t.resources[id]
+	if !ok {
+		return
+	}
+	owned[r]--
+	if owned[r] <= 0 {
+		delete(owned, r)
+	}
+}
+
+// untrack stops tracking the container, and returns resources it still owns.
+func (t *resourceTracker) untrack(id string) map[ResourceType]int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	owned := t.resources[id]
+	delete(t.resources, id)
+	if len(owned) == 0 {
+		return nil
+	}
+	return owned
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agents
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestResourceTracker(t *testing.T) {
+	tracker := newResourceTracker()
+	assert := assertlib.New(t)
+
+	t.Logf("should report no leak for untracked container")
+	assert.Nil(tracker.untrack("test-id"))
+
+	t.Logf("should report no leak when all resources are released")
+	tracker.acquire("test-id", FIFO)
+	tracker.acquire("test-id", Goroutine)
+	tracker.release("test-id", FIFO)
+	tracker.release("test-id", Goroutine)
+	assert.Nil(tracker.untrack("test-id"))
+
+	t.Logf("should report resources not released")
+	tracker.acquire("test-id", FIFO)
+	tracker.acquire("test-id", FIFO)
+	tracker.acquire("test-id", FD)
+	tracker.release("test-id", FIFO)
+	assert.Equal(map[ResourceType]int{FIFO: 1, FD: 1}, tracker.untrack("test-id"))
+
+	t.Logf("should ignore release after untrack")
+	tracker.release("test-id", FIFO)
+	assert.Nil(tracker.untrack("test-id"))
+}
+
+func TestSandboxLoggerReleaseResources(t *testing.T) {
+	f := NewAgentFactory(0, 0)
+	tracker := f.(*agentFactory).tracker
+	rc := ioutil.NopCloser(strings.NewReader("test sandbox log"))
+	assertlib.NoError(t, f.NewSandboxLogger("test-id", rc).Start())
+	released := func() bool {
+		tracker.lock.Lock()
+		defer tracker.lock.Unlock()
+		return len(tracker.resources["test-id"]) == 0
+	}
+	for start := time.Now(); !released() && time.Since(start) < time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertlib.Nil(t, f.CheckLeaks("test-id"))
+}
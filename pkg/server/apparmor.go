@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// apparmorUnconfined means no apparmor confinement.
+	apparmorUnconfined = "unconfined"
+	// apparmorRuntimeDefault means the default apparmor profile of the runtime.
+	apparmorRuntimeDefault = "runtime/default"
+	// apparmorLocalhostPrefix is the prefix of an apparmor profile loaded on the node.
+	apparmorLocalhostPrefix = "localhost/"
+	// defaultApparmorProfile is the apparmor profile used for `runtime/default`,
+	// which is bundled in contrib/apparmor and loaded at startup.
+	defaultApparmorProfile = "cri-containerd-default"
+	// apparmorParserBinary is the binary loading apparmor profiles.
+	apparmorParserBinary = "apparmor_parser"
+	// apparmorEnabledFile is the file indicating whether apparmor is enabled.
+	apparmorEnabledFile = "/sys/module/apparmor/parameters/enabled"
+	// apparmorProfilesFile is the file listing all loaded apparmor profiles.
+	apparmorProfilesFile = "/sys/kernel/security/apparmor/profiles"
+)
+
+// getApparmorProfile returns the name of the apparmor profile, or empty for
+// unconfined. An error is returned if the profile is not loaded, so that the
+// container is never started unconfined silently. The only exception is the
+// runtime default profile without apparmor in the kernel, which is logged at
+// startup.
+func (c *criContainerdService) getApparmorProfile(profile string) (string, error) {
+	var name string
+	switch {
+	case profile == "" || profile == apparmorUnconfined:
+		return "", nil
+	case profile == apparmorRuntimeDefault:
+		// The default profile is skipped without apparmor in the kernel,
+		// like docker does.
+		if !c.kernelFeatures.has(kernelFeatureApparmor) {
+			return "", nil
+		}
+		if err := c.checkApparmorProfileLoaded(defaultApparmorProfile); err != nil {
+			return "", fmt.Errorf("default apparmor profile is not available on the node, it should be loaded from %q at startup: %v",
+				c.config.ApparmorDefaultProfile, err)
+		}
+		return defaultApparmorProfile, nil
+	case strings.HasPrefix(profile, apparmorLocalhostPrefix):
+		name = strings.TrimPrefix(profile, apparmorLocalhostPrefix)
+	default:
+		return "", fmt.Errorf("unsupported apparmor profile %q", profile)
+	}
+	if err := c.checkApparmorProfileLoaded(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// checkApparmorProfileLoaded checks whether apparmor is enabled and the profile is
+// loaded on the node.
+func (c *criContainerdService) checkApparmorProfileLoaded(name string) error {
+	enabled, err := c.os.ReadFile(apparmorEnabledFile)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check whether apparmor is enabled from %q: %v", apparmorEnabledFile, err)
+	}
+	if err != nil || !bytes.HasPrefix(enabled, []byte("Y")) {
+		return fmt.Errorf("apparmor is not enabled on the node, apparmor profile %q can not be applied", name)
+	}
+	profiles, err := c.os.ReadFile(apparmorProfilesFile)
+	if err != nil {
+		return fmt.Errorf("failed to read loaded apparmor profiles from %q: %v", apparmorProfilesFile, err)
+	}
+	// Each line is in the format of "<name> (<mode>)".
+	scanner := bufio.NewScanner(bytes.NewReader(profiles))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[0] == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("apparmor profile %q is not loaded, it should be loaded with apparmor_parser first", name)
+}
+
+// loadDefaultApparmorProfile loads the default apparmor profile into the kernel
+// with apparmor_parser, unless apparmor is not in the kernel or the profile is
+// already loaded.
+func (c *criContainerdService) loadDefaultApparmorProfile() error {
+	if !c.kernelFeatures.has(kernelFeatureApparmor) {
+		return nil
+	}
+	if c.checkApparmorProfileLoaded(defaultApparmorProfile) == nil {
+		return nil
+	}
+	path := c.config.ApparmorDefaultProfile
+	if path == "" {
+		return fmt.Errorf("default apparmor profile %q is not loaded and no profile path is configured", defaultApparmorProfile)
+	}
+	if out, err := exec.Command(apparmorParserBinary, "-r", "-W", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load apparmor profile %q with %s: %v, output: %q", path, apparmorParserBinary, err, string(out))
+	}
+	return nil
+}
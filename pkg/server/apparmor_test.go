@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+func TestGetApparmorProfile(t *testing.T) {
+	loadedProfiles := "cri-containerd-default (enforce)\ntest-profile (complain)\n"
+	for desc, test := range map[string]struct {
+		profile       string
+		disabled      bool
+		noKernel      bool
+		noDefault     bool
+		expectErr     bool
+		expectProfile string
+	}{
+		"should not set profile for empty profile": {},
+		"should not set profile for unconfined profile": {
+			profile: apparmorUnconfined,
+		},
+		"should set default profile": {
+			profile:       apparmorRuntimeDefault,
+			expectProfile: defaultApparmorProfile,
+		},
+		"should return error if default profile is not loaded": {
+			profile:   apparmorRuntimeDefault,
+			noDefault: true,
+			expectErr: true,
+		},
+		"should set localhost profile": {
+			profile:       "localhost/test-profile",
+			expectProfile: "test-profile",
+		},
+		"should return error if localhost profile is not loaded": {
+			profile:   "localhost/not-loaded",
+			expectErr: true,
+		},
+		"should return error if apparmor is disabled": {
+			profile:   "localhost/test-profile",
+			disabled:  true,
+			expectErr: true,
+		},
+		"should not return error for unconfined profile if apparmor is disabled": {
+			profile:  apparmorUnconfined,
+			disabled: true,
+		},
+		"should not set default profile without apparmor in the kernel": {
+			profile:  apparmorRuntimeDefault,
+			disabled: true,
+			noKernel: true,
+		},
+		"should return error for localhost profile without apparmor in the kernel": {
+			profile:   "localhost/test-profile",
+			disabled:  true,
+			noKernel:  true,
+			expectErr: true,
+		},
+		"should return error for unknown profile": {
+			profile:   "unknown",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.kernelFeatures = kernelFeatures{kernelFeatureApparmor: !test.noKernel}
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.ReadFileFn = func(filename string) ([]byte, error) {
+			switch filename {
+			case apparmorEnabledFile:
+				if test.disabled {
+					return nil, os.ErrNotExist
+				}
+				return []byte("Y\n"), nil
+			case apparmorProfilesFile:
+				if test.noDefault {
+					return []byte("test-profile (complain)\n"), nil
+				}
+				return []byte(loadedProfiles), nil
+			}
+			return nil, os.ErrNotExist
+		}
+		name, err := c.getApparmorProfile(test.profile)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectProfile, name)
+	}
+}
+
+func TestLoadDefaultApparmorProfile(t *testing.T) {
+	for desc, test := range map[string]struct {
+		noKernel  bool
+		loaded    bool
+		expectErr bool
+	}{
+		"should skip without apparmor in the kernel": {
+			noKernel: true,
+		},
+		"should skip if default profile is already loaded": {
+			loaded: true,
+		},
+		"should return error if default profile is not configured": {
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.ApparmorDefaultProfile = ""
+		c.kernelFeatures = kernelFeatures{kernelFeatureApparmor: !test.noKernel}
+		c.os.(*ostesting.FakeOS).ReadFileFn = func(filename string) ([]byte, error) {
+			switch filename {
+			case apparmorEnabledFile:
+				return []byte("Y\n"), nil
+			case apparmorProfilesFile:
+				if test.loaded {
+					return []byte(defaultApparmorProfile + " (enforce)\n"), nil
+				}
+				return []byte{}, nil
+			}
+			return nil, os.ErrNotExist
+		}
+		err := c.loadDefaultApparmorProfile()
+		if test.expectErr {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshot"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+
+	osinterface "github.com/kubernetes-incubator/cri-containerd/pkg/os"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// hostLocalIPAMDir is the directory where the CNI host-local IPAM plugin keeps
+// its ip allocations. Each allocation is a file named after the ip, containing
+// the id of the sandbox owning it.
+const hostLocalIPAMDir = "/var/lib/cni/networks"
+
+const (
+	// orphanSandboxDirectory is a sandbox root directory without corresponding
+	// containerd container.
+	orphanSandboxDirectory = "OrphanSandboxDirectory"
+	// orphanContainerDirectory is a container root directory without corresponding
+	// containerd container.
+	orphanContainerDirectory = "OrphanContainerDirectory"
+	// orphanSnapshot is an active or view snapshot without corresponding containerd
+	// container.
+	orphanSnapshot = "OrphanSnapshot"
+	// orphanContainerdContainer is a containerd container without corresponding
+	// sandbox or container root directory.
+	orphanContainerdContainer = "OrphanContainerdContainer"
+	// leakedIP is an ip allocated to a sandbox which doesn't exist anymore.
+	leakedIP = "LeakedIP"
+	// orphanNetNS is a persistent network namespace without corresponding
+	// containerd container.
+	orphanNetNS = "OrphanNetNS"
+	// missingLogDirectory is a log directory of a sandbox which doesn't exist,
+	// so that logs of its containers can't be written after restart.
+	missingLogDirectory = "MissingLogDirectory"
+)
+
+// Inconsistency is an inconsistency found between cri-containerd state, containerd
+// state and CNI state.
+type Inconsistency struct {
+	// Kind is the kind of the inconsistency.
+	Kind string `json:"kind"`
+	// ID is the id of the sandbox or container the inconsistency belongs to.
+	ID string `json:"id"`
+	// Path is the path of the leaked file or directory if there is one.
+	Path string `json:"path,omitempty"`
+	// Repaired indicates whether the inconsistency has been repaired.
+	Repaired bool `json:"repaired"`
+	// Error is the error message if the repair fails.
+	Error string `json:"error,omitempty"`
+}
+
+// repair runs the repair function and records the result.
+func (i *Inconsistency) repair(repairFn func() error) {
+	if err := repairFn(); err != nil {
+		i.Error = err.Error()
+		return
+	}
+	i.Repaired = true
+}
+
+// StateChecker checks node state against containerd.
+type StateChecker interface {
+	// CheckState checks and optionally repairs inconsistent node state.
+	CheckState(ctx context.Context, repair bool) ([]Inconsistency, error)
+}
+
+// NewStateChecker returns a StateChecker which only needs a containerd client,
+// the snapshotter of cri-containerd and the root directory, so that state could
+// be checked on a node where the full service can't be created.
+func NewStateChecker(containerdEndpoint, snapshotter, rootDir string) (StateChecker, error) {
+	clients, err := newContainerdClients(containerdEndpoint, 1)
+	if err != nil {
+		return nil, err
+	}
+	client := clients[0]
+	return &criContainerdService{
+		os:               osinterface.RealOS{},
+		rootDir:          rootDir,
+		containerService: client.ContainerService(),
+		taskService:      client.TaskService(),
+		snapshotService:  client.SnapshotService(snapshotter),
+	}, nil
+}
+
+// CheckState cross-references containerd containers, snapshots, cri-containerd root
+// directories, network namespaces, sandbox log directories and CNI ip allocations, and
+// returns all inconsistencies found. If repair is true, inconsistencies are fixed by
+// removing the leaked resources, and missing log directories are created again.
+// CheckState only relies on containerd and on-disk state, so that it could be used
+// on a broken node. It should not be run while cri-containerd is serving, because
+// resources being created could be treated as leaked.
+func (c *criContainerdService) CheckState(ctx context.Context, repair bool) ([]Inconsistency, error) {
+	cntrs, err := c.containerService.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containerd containers: %v", err)
+	}
+	known := make(map[string]bool)
+	for _, cntr := range cntrs {
+		known[cntr.ID] = true
+	}
+	resp, err := c.taskService.List(ctx, &tasks.ListTasksRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containerd tasks: %v", err)
+	}
+	running := make(map[string]bool)
+	for _, t := range resp.Tasks {
+		running[t.ID] = t.Status != task.StatusStopped
+	}
+
+	var inconsistencies []Inconsistency
+	add := func(i Inconsistency, repairFn func() error) {
+		if repair {
+			i.repair(repairFn)
+		}
+		inconsistencies = append(inconsistencies, i)
+	}
+
+	// Check sandbox and container root directories.
+	for kind, dir := range map[string]string{
+		orphanSandboxDirectory:   filepath.Join(c.rootDir, sandboxesDir),
+		orphanContainerDirectory: filepath.Join(c.rootDir, containersDir),
+	} {
+		entries, err := c.os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read directory %q: %v", dir, err)
+		}
+		for _, e := range entries {
+			id := e.Name()
+			if known[id] {
+				continue
+			}
+			path := filepath.Join(dir, id)
+			isSandbox := kind == orphanSandboxDirectory
+			add(Inconsistency{Kind: kind, ID: id, Path: path}, func() error {
+				if isSandbox {
+					// Sandbox shm may still be mounted.
+					if err := c.os.Unmount(getSandboxDevShm(path), unix.MNT_DETACH); err != nil && !os.IsNotExist(err) {
+						return fmt.Errorf("failed to unmount sandbox shm: %v", err)
+					}
+				}
+				return c.os.RemoveAll(path)
+			})
+		}
+	}
+
+	// Check snapshots. Active and view snapshots are keyed by container id, committed
+	// snapshots are image layers.
+	if err := c.snapshotService.Walk(ctx, func(_ gocontext.Context, info snapshot.Info) error {
+		if info.Kind == snapshot.KindCommitted || known[info.Name] {
+			return nil
+		}
+		add(Inconsistency{Kind: orphanSnapshot, ID: info.Name}, func() error {
+			return c.snapshotService.Remove(ctx, info.Name)
+		})
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to walk snapshots: %v", err)
+	}
+
+	// Check containerd containers.
+	for _, cntr := range cntrs {
+		id := cntr.ID
+		if c.rootDirExists(getSandboxRootDir(c.rootDir, id)) || c.rootDirExists(getContainerRootDir(c.rootDir, id)) {
+			continue
+		}
+		add(Inconsistency{Kind: orphanContainerdContainer, ID: id}, func() error {
+			if running[id] {
+				return fmt.Errorf("task is still running")
+			}
+			if _, err := c.taskService.Delete(ctx, &tasks.DeleteTaskRequest{ContainerID: id}); err != nil &&
+				!isContainerdGRPCNotFoundError(err) {
+				return fmt.Errorf("failed to delete task: %v", err)
+			}
+			if err := c.snapshotService.Remove(ctx, id); err != nil && !errdefs.IsNotFound(err) {
+				return fmt.Errorf("failed to remove snapshot: %v", err)
+			}
+			return c.containerService.Delete(ctx, id)
+		})
+	}
+
+	// Check persistent network namespaces.
+	netnsEntries, err := c.os.ReadDir(sandboxNetNSDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read directory %q: %v", sandboxNetNSDir, err)
+	}
+	for _, e := range netnsEntries {
+		// Network namespaces not created by cri-containerd are skipped.
+		if !strings.HasPrefix(e.Name(), sandboxNetNSPrefix) {
+			continue
+		}
+		id := strings.TrimPrefix(e.Name(), sandboxNetNSPrefix)
+		if known[id] {
+			continue
+		}
+		path := filepath.Join(sandboxNetNSDir, e.Name())
+		add(Inconsistency{Kind: orphanNetNS, ID: id, Path: path}, func() error {
+			return c.removeSandboxNetNS(path)
+		})
+	}
+
+	// Check log directories of sandboxes.
+	for _, cntr := range cntrs {
+		label, ok := cntr.Labels[sandboxMetadataLabel]
+		if !ok {
+			continue
+		}
+		var meta sandboxstore.Metadata
+		if err := meta.Decode([]byte(label)); err != nil {
+			return nil, fmt.Errorf("failed to decode metadata of sandbox %q: %v", cntr.ID, err)
+		}
+		logDir := meta.Config.GetLogDirectory()
+		if logDir == "" || c.rootDirExists(logDir) {
+			continue
+		}
+		add(Inconsistency{Kind: missingLogDirectory, ID: cntr.ID, Path: logDir}, func() error {
+			return c.os.MkdirAll(logDir, 0755)
+		})
+	}
+
+	// Check CNI ip allocations.
+	networks, err := c.os.ReadDir(hostLocalIPAMDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read directory %q: %v", hostLocalIPAMDir, err)
+	}
+	for _, network := range networks {
+		if !network.IsDir() {
+			continue
+		}
+		networkDir := filepath.Join(hostLocalIPAMDir, network.Name())
+		ips, err := c.os.ReadDir(networkDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %q: %v", networkDir, err)
+		}
+		for _, ip := range ips {
+			// Skip lock and last reserved ip files.
+			if net.ParseIP(ip.Name()) == nil {
+				continue
+			}
+			path := filepath.Join(networkDir, ip.Name())
+			data, err := c.os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ip allocation %q: %v", path, err)
+			}
+			id := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+			if known[id] {
+				continue
+			}
+			add(Inconsistency{Kind: leakedIP, ID: id, Path: path}, func() error {
+				return c.os.RemoveAll(path)
+			})
+		}
+	}
+	return inconsistencies, nil
+}
+
+// rootDirExists returns whether the root directory exists.
+func (c *criContainerdService) rootDirExists(dir string) bool {
+	_, err := c.os.Stat(dir)
+	return err == nil
+}
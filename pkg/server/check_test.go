@@ -0,0 +1,143 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/containers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestCheckState(t *testing.T) {
+	const (
+		rootDir   = "/test/root"
+		logDir    = "/var/log/pods/test-uid"
+		networkID = "test-network"
+	)
+	ipamDir := filepath.Join(hostLocalIPAMDir, networkID)
+	for desc, test := range map[string]struct {
+		repair bool
+	}{
+		"inconsistencies should be reported": {},
+		"inconsistencies should be repaired": {repair: true},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.rootDir = rootDir
+		c.snapshotService = &fakeSnapshotter{}
+		containerStore := newFakeContainerStore()
+		c.containerService = containerStore
+		c.taskService = &fakeRecoveryTaskService{tasks: map[string]*task.Task{
+			"orphan-containerd": {ID: "orphan-containerd", Status: task.StatusStopped},
+		}}
+		labels, err := sandboxMetadataLabels(sandboxstore.Metadata{
+			ID:     "sandbox-id",
+			Config: &runtime.PodSandboxConfig{LogDirectory: logDir},
+		})
+		require.NoError(t, err)
+		containerStore.containers["sandbox-id"] = containers.Container{ID: "sandbox-id", Labels: labels}
+		containerStore.containers["container-id"] = containers.Container{ID: "container-id"}
+		containerStore.containers["orphan-containerd"] = containers.Container{ID: "orphan-containerd"}
+
+		dirs := map[string][]os.FileInfo{
+			filepath.Join(rootDir, sandboxesDir):  {fakeFileInfo{name: "sandbox-id"}, fakeFileInfo{name: "orphan-sandbox"}},
+			filepath.Join(rootDir, containersDir): {fakeFileInfo{name: "container-id"}},
+			sandboxNetNSDir:                       {fakeFileInfo{name: sandboxNetNSPrefix + "sandbox-id"}, fakeFileInfo{name: sandboxNetNSPrefix + "orphan-netns"}, fakeFileInfo{name: "other-netns"}},
+			hostLocalIPAMDir:                      {fakeFileInfo{name: networkID, mode: os.ModeDir}},
+			ipamDir:                               {fakeFileInfo{name: "10.0.0.2"}, fakeFileInfo{name: "10.0.0.3"}, fakeFileInfo{name: "lock"}},
+		}
+		files := map[string]string{
+			filepath.Join(ipamDir, "10.0.0.2"): "sandbox-id",
+			filepath.Join(ipamDir, "10.0.0.3"): "orphan-ip",
+		}
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.ReadDirFn = func(dir string) ([]os.FileInfo, error) {
+			entries, ok := dirs[dir]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return entries, nil
+		}
+		fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+			data, ok := files[path]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return []byte(data), nil
+		}
+		fakeOS.StatFn = func(path string) (os.FileInfo, error) {
+			switch path {
+			case getSandboxRootDir(rootDir, "sandbox-id"), getContainerRootDir(rootDir, "container-id"):
+				return fakeFileInfo{name: filepath.Base(path), mode: os.ModeDir}, nil
+			}
+			return nil, os.ErrNotExist
+		}
+		var removed, created []string
+		fakeOS.RemoveAllFn = func(path string) error {
+			removed = append(removed, path)
+			return nil
+		}
+		fakeOS.MkdirAllFn = func(path string, _ os.FileMode) error {
+			created = append(created, path)
+			return nil
+		}
+
+		inconsistencies, err := c.CheckState(context.Background(), test.repair)
+		require.NoError(t, err)
+		var found []string
+		for _, i := range inconsistencies {
+			found = append(found, i.Kind+" "+i.ID+" "+i.Path)
+			assert.Equal(t, test.repair, i.Repaired, "inconsistency %+v", i)
+			assert.Empty(t, i.Error)
+		}
+		sort.Strings(found)
+		assert.Equal(t, []string{
+			leakedIP + " orphan-ip " + filepath.Join(ipamDir, "10.0.0.3"),
+			missingLogDirectory + " sandbox-id " + logDir,
+			orphanContainerdContainer + " orphan-containerd ",
+			orphanNetNS + " orphan-netns " + getSandboxNetNSPath("orphan-netns"),
+			orphanSandboxDirectory + " orphan-sandbox " + getSandboxRootDir(rootDir, "orphan-sandbox"),
+		}, found)
+
+		if !test.repair {
+			assert.Empty(t, removed)
+			assert.Empty(t, created)
+			assert.Contains(t, containerStore.containers, "orphan-containerd")
+			continue
+		}
+		sort.Strings(removed)
+		assert.Equal(t, []string{
+			getSandboxRootDir(rootDir, "orphan-sandbox"),
+			filepath.Join(ipamDir, "10.0.0.3"),
+			getSandboxNetNSPath("orphan-netns"),
+		}, removed)
+		assert.Equal(t, []string{logDir}, created)
+		assert.NotContains(t, containerStore.containers, "orphan-containerd")
+		assert.Contains(t, containerStore.containers, "sandbox-id")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+// unifiedCgroup is the key of the cgroup v2 unified hierarchy in container
+// cgroup paths.
+const unifiedCgroup = "unified"
+
+// containerCgroups is the cgroup paths of a container.
+type containerCgroups struct {
+	ID        string `json:"id"`
+	SandboxID string `json:"sandboxID"`
+	// CgroupsPath is the cgroups path in the oci spec of the container,
+	// relative to the root of each hierarchy.
+	CgroupsPath string `json:"cgroupsPath,omitempty"`
+	// Paths maps cgroup controllers, e.g. memory, to absolute cgroup paths on
+	// the host. The cgroup v2 unified hierarchy is keyed by "unified". It is
+	// discovered from the init process, so it is empty if the container is not
+	// running.
+	Paths map[string]string `json:"paths,omitempty"`
+}
+
+// getContainerCgroups returns the cgroup paths of the container.
+func (c *criContainerdService) getContainerCgroups(container containerstore.Container) (*containerCgroups, error) {
+	result := &containerCgroups{
+		ID:          container.ID,
+		SandboxID:   container.SandboxID,
+		CgroupsPath: c.getContainerCgroupsPath(container),
+	}
+	status := container.Status.Get()
+	if status.Pid == 0 || status.FinishedAt != 0 {
+		return result, nil
+	}
+	paths, err := c.getProcessCgroups(status.Pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cgroups of container %q: %v", container.ID, err)
+	}
+	result.Paths = paths
+	return result, nil
+}
+
+// getContainerCgroupsPath returns the cgroups path in the oci spec of the
+// container, or empty if its sandbox has no cgroup parent.
+func (c *criContainerdService) getContainerCgroupsPath(container containerstore.Container) string {
+	sandbox, err := c.sandboxStore.Get(container.SandboxID)
+	if err != nil {
+		return ""
+	}
+	if parent := sandbox.Config.GetLinux().GetCgroupParent(); parent != "" {
+		return getCgroupsPath(parent, container.ID)
+	}
+	return ""
+}
+
+// getProcessCgroups discovers absolute cgroup paths of the process from
+// /proc/<pid>/cgroup, whose lines are in the form of
+// `hierarchy-id:controller-list:path`.
+func (c *criContainerdService) getProcessCgroups(pid uint32) (map[string]string, error) {
+	path := filepath.Join("/proc", strconv.FormatUint(uint64(pid), 10), "cgroup")
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	paths := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line %q in %q", line, path)
+		}
+		controllers, cgroupPath := fields[1], fields[2]
+		if controllers == "" {
+			// Cgroup v2 is mounted at the cgroup root, or at "unified" under
+			// it in hybrid mode.
+			root := cgroupRoot
+			if _, err := c.os.Stat(filepath.Join(cgroupRoot, unifiedCgroup)); err == nil {
+				root = filepath.Join(cgroupRoot, unifiedCgroup)
+			}
+			paths[unifiedCgroup] = filepath.Join(root, cgroupPath)
+			continue
+		}
+		// Co-mounted controllers, e.g. cpu,cpuacct, share a hierarchy, and
+		// named hierarchies, e.g. name=systemd, are mounted with the name.
+		dir := strings.TrimPrefix(controllers, "name=")
+		for _, controller := range strings.Split(controllers, ",") {
+			paths[strings.TrimPrefix(controller, "name=")] = filepath.Join(cgroupRoot, dir, cgroupPath)
+		}
+	}
+	return paths, nil
+}
+
+// handleContainerCgroups handles the container-cgroups debug endpoint. It
+// returns cgroup paths of containers with the "id"s, or of all containers if
+// no id is specified, so that node agents can attach to container cgroups
+// without guessing path conventions.
+func (c *criContainerdService) handleContainerCgroups(w http.ResponseWriter, r *http.Request) {
+	var containers []containerstore.Container
+	if ids := r.URL.Query()["id"]; len(ids) > 0 {
+		for _, id := range ids {
+			container, err := c.containerStore.Get(id)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to find container %q: %v", id, err), http.StatusNotFound)
+				return
+			}
+			containers = append(containers, container)
+		}
+	} else {
+		containers = c.containerStore.List()
+		sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+	}
+	result := []*containerCgroups{}
+	for _, container := range containers {
+		cgroups, err := c.getContainerCgroups(container)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result = append(result, cgroups)
+	}
+	writeJSON(w, result)
+}
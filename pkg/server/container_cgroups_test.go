@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestGetProcessCgroups(t *testing.T) {
+	for desc, test := range map[string]struct {
+		content   string
+		noUnified bool
+		expected  map[string]string
+		expectErr bool
+	}{
+		"cgroup v1 with named hierarchy": {
+			content: "11:cpu,cpuacct:/kubepods/pod-1/container-1\n" +
+				"4:memory:/kubepods/pod-1/container-1\n" +
+				"1:name=systemd:/kubepods/pod-1/container-1\n",
+			expected: map[string]string{
+				"cpu":     "/sys/fs/cgroup/cpu,cpuacct/kubepods/pod-1/container-1",
+				"cpuacct": "/sys/fs/cgroup/cpu,cpuacct/kubepods/pod-1/container-1",
+				"memory":  "/sys/fs/cgroup/memory/kubepods/pod-1/container-1",
+				"systemd": "/sys/fs/cgroup/systemd/kubepods/pod-1/container-1",
+			},
+		},
+		"hybrid cgroup": {
+			content: "4:memory:/kubepods/pod-1/container-1\n0::/kubepods/pod-1/container-1\n",
+			expected: map[string]string{
+				"memory":  "/sys/fs/cgroup/memory/kubepods/pod-1/container-1",
+				"unified": "/sys/fs/cgroup/unified/kubepods/pod-1/container-1",
+			},
+		},
+		"cgroup v2": {
+			content:   "0::/kubepods/pod-1/container-1\n",
+			noUnified: true,
+			expected: map[string]string{
+				"unified": "/sys/fs/cgroup/kubepods/pod-1/container-1",
+			},
+		},
+		"invalid content": {
+			content:   "memory\n",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+			assert.Equal(t, "/proc/100/cgroup", path)
+			return []byte(test.content), nil
+		}
+		fakeOS.StatFn = func(path string) (os.FileInfo, error) {
+			assert.Equal(t, "/sys/fs/cgroup/unified", path)
+			if test.noUnified {
+				return nil, os.ErrNotExist
+			}
+			return nil, nil
+		}
+		paths, err := c.getProcessCgroups(100)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, paths)
+	}
+}
+
+func TestHandleContainerCgroups(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+		assert.Equal(t, "/proc/100/cgroup", path)
+		return []byte("4:memory:/kubepods/pod-1/running\n"), nil
+	}
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID:     "sandbox",
+		Config: &runtime.PodSandboxConfig{Linux: &runtime.LinuxPodSandboxConfig{CgroupParent: "/kubepods/pod-1"}},
+	}}))
+	now := time.Now().UnixNano()
+	for id, status := range map[string]containerstore.Status{
+		"running": {Pid: 100, CreatedAt: now, StartedAt: now},
+		"exited":  {Pid: 101, CreatedAt: now, StartedAt: now, FinishedAt: now},
+	} {
+		container, err := containerstore.NewContainer(containerstore.Metadata{ID: id, SandboxID: "sandbox"}, status)
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+
+	w := httptest.NewRecorder()
+	c.handleContainerCgroups(w, httptest.NewRequest(http.MethodGet, "/container-cgroups", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var result []*containerCgroups
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, []*containerCgroups{
+		{ID: "exited", SandboxID: "sandbox", CgroupsPath: "/kubepods/pod-1/exited"},
+		{
+			ID:          "running",
+			SandboxID:   "sandbox",
+			CgroupsPath: "/kubepods/pod-1/running",
+			Paths:       map[string]string{"memory": "/sys/fs/cgroup/memory/kubepods/pod-1/running"},
+		},
+	}, result)
+
+	w = httptest.NewRecorder()
+	c.handleContainerCgroups(w, httptest.NewRequest(http.MethodGet, "/container-cgroups?id=not-exist", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
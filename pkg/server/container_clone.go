@@ -0,0 +1,235 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/rootfs"
+	"github.com/containerd/containerd/snapshot"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+// rootfsSnapshotAnnotationKey is the container annotation key to create the
+// container rootfs from a snapshot of another container, instead of from the
+// image. The snapshot must be created from a container of the same image in
+// the same pod namespace.
+const rootfsSnapshotAnnotationKey = "io.kubernetes.cri-containerd.rootfs-snapshot"
+
+// containerSnapshotsDir contains the records of container snapshots, which
+// keep the owner namespace and the layer of each snapshot.
+const containerSnapshotsDir = "container-snapshots"
+
+// containerSnapshot is a committed snapshot of a container rootfs, which new
+// containers of the same image could be cloned from.
+type containerSnapshot struct {
+	// Name is the name of the committed snapshot.
+	Name string `json:"name"`
+	// ContainerID is the id of the container snapshotted.
+	ContainerID string `json:"containerId"`
+	// ImageRef is the image of the container, which the snapshot is on top of.
+	ImageRef string `json:"imageRef"`
+	// Parent is the chain id of the image.
+	Parent string `json:"parent"`
+	// Namespace is the pod namespace of the container. Only containers in
+	// the namespace can be cloned from the snapshot.
+	Namespace string `json:"namespace"`
+	// Layer is the committed writable layer in the content store.
+	Layer digest.Digest `json:"layer"`
+}
+
+// getContainerSnapshotRecordPath returns the path of the record of the
+// container snapshot.
+func getContainerSnapshotRecordPath(rootDir, name string) string {
+	return filepath.Join(rootDir, containerSnapshotsDir, name)
+}
+
+// getContainerSnapshot returns the record of the container snapshot.
+func (c *criContainerdService) getContainerSnapshot(name string) (*containerSnapshot, error) {
+	data, err := c.os.ReadFile(getContainerSnapshotRecordPath(c.rootDir, name))
+	if err != nil {
+		return nil, err
+	}
+	var s containerSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal record of snapshot %q: %v", name, err)
+	}
+	return &s, nil
+}
+
+// snapshotContainer commits the writable layer of the container into a
+// snapshot on top of the container image. The snapshot is named by the chain
+// id of the image layers and the writable layer, so snapshotting the same
+// content again returns the existing snapshot.
+func (c *criContainerdService) snapshotContainer(ctx context.Context, id string) (*containerSnapshot, error) {
+	container, err := c.containerStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find container %q: %v", id, err)
+	}
+	image, err := c.imageStore.Get(container.ImageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %q of container %q: %v", container.ImageRef, container.ID, err)
+	}
+	sandbox, err := c.sandboxStore.Get(container.SandboxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sandbox %q of container %q: %v", container.SandboxID, container.ID, err)
+	}
+	namespace := sandbox.Config.GetMetadata().GetNamespace()
+	layer, _, err := c.diffContainerRootfs(ctx, container.ID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writable layer of container %q: %v", container.ID, err)
+	}
+	// The layer is uncompressed, so its digest is also the diff id. The chain
+	// id of the image is used as the chain of the single layer below.
+	chain := []digest.Digest{digest.Digest(image.ChainID)}
+	if _, err := rootfs.ApplyLayer(ctx, rootfs.Layer{Diff: layer, Blob: layer}, chain,
+		c.snapshotService, c.diffService); err != nil {
+		return nil, fmt.Errorf("failed to apply writable layer of container %q: %v", container.ID, err)
+	}
+	name := identity.ChainID(append(chain, layer.Digest)).String()
+	// The same content snapshotted in another namespace has the same name,
+	// it is not shared across namespaces.
+	if existing, err := c.getContainerSnapshot(name); err == nil && existing.Namespace != namespace {
+		return nil, fmt.Errorf("snapshot %q of container %q is owned by another namespace", name, container.ID)
+	}
+	s := &containerSnapshot{
+		Name:        name,
+		ContainerID: container.ID,
+		ImageRef:    image.ID,
+		Parent:      image.ChainID,
+		Namespace:   namespace,
+		Layer:       layer.Digest,
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal record of snapshot %q: %v", name, err)
+	}
+	if err := c.os.MkdirAll(filepath.Dir(getContainerSnapshotRecordPath(c.rootDir, name)), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create container snapshots directory: %v", err)
+	}
+	if err := c.os.AtomicWriteFile(getContainerSnapshotRecordPath(c.rootDir, name), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write record of snapshot %q: %v", name, err)
+	}
+	glog.Infof("Snapshotted container %q into %q", container.ID, name)
+	return s, nil
+}
+
+// removeContainerSnapshot removes a snapshot created by snapshotContainer,
+// together with its record and committed layer. Snapshots of images are not
+// removed, and the snapshotter refuses to remove snapshots which containers are
+// still cloned from.
+func (c *criContainerdService) removeContainerSnapshot(ctx context.Context, name string) error {
+	for _, image := range c.imageStore.List() {
+		if image.ChainID == name {
+			return fmt.Errorf("snapshot %q belongs to image %q", name, image.ID)
+		}
+	}
+	info, err := c.snapshotService.Stat(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to stat snapshot %q: %v", name, err)
+	}
+	if info.Kind != snapshot.KindCommitted {
+		return fmt.Errorf("snapshot %q is not committed", name)
+	}
+	record, err := c.getContainerSnapshot(name)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to get record of snapshot %q: %v", name, err)
+	}
+	if err := c.snapshotService.Remove(ctx, name); err != nil {
+		return fmt.Errorf("failed to remove snapshot %q: %v", name, err)
+	}
+	if record == nil {
+		return nil
+	}
+	if err := c.contentStoreService.Delete(ctx, record.Layer); err != nil && !isContainerdGRPCNotFoundError(err) {
+		return fmt.Errorf("failed to remove layer %q of snapshot %q: %v", record.Layer, name, err)
+	}
+	if err := c.os.RemoveAll(getContainerSnapshotRecordPath(c.rootDir, name)); err != nil {
+		return fmt.Errorf("failed to remove record of snapshot %q: %v", name, err)
+	}
+	return nil
+}
+
+// getContainerRootfsParent returns the snapshot which the container rootfs is
+// prepared from, i.e. the snapshot in the rootfs snapshot annotation, or the
+// image snapshot if the annotation is not set. The annotated snapshot must be
+// created by snapshotContainer in the namespace of the sandbox, and committed
+// on top of the image snapshot.
+func (c *criContainerdService) getContainerRootfsParent(ctx context.Context, config *runtime.ContainerConfig,
+	sandboxConfig *runtime.PodSandboxConfig, image imagestore.Image) (string, error) {
+	name, ok := config.GetAnnotations()[rootfsSnapshotAnnotationKey]
+	if !ok {
+		return image.ChainID, nil
+	}
+	record, err := c.getContainerSnapshot(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get record of rootfs snapshot %q: %v", name, err)
+	}
+	if namespace := sandboxConfig.GetMetadata().GetNamespace(); record.Namespace != namespace {
+		return "", fmt.Errorf("rootfs snapshot %q is not created in namespace %q", name, namespace)
+	}
+	info, err := c.snapshotService.Stat(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat rootfs snapshot %q: %v", name, err)
+	}
+	if info.Kind != snapshot.KindCommitted {
+		return "", fmt.Errorf("rootfs snapshot %q is not committed", name)
+	}
+	for parent := info.Parent; parent != ""; {
+		if parent == image.ChainID {
+			return name, nil
+		}
+		info, err := c.snapshotService.Stat(ctx, parent)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat snapshot %q: %v", parent, err)
+		}
+		parent = info.Parent
+	}
+	return "", fmt.Errorf("rootfs snapshot %q is not created from image %q", name, image.ID)
+}
+
+// handleContainerSnapshots handles the container-snapshots debug endpoint. POST
+// snapshots container "id", and DELETE removes the snapshot "name".
+func (c *criContainerdService) handleContainerSnapshots(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodPost:
+		s, err := c.snapshotContainer(r.Context(), query.Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, s)
+	case http.MethodDelete:
+		if err := c.removeContainerSnapshot(r.Context(), query.Get("name")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	containerdmount "github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshot"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// cloneSnapshotter is a fake snapshotter which keeps the info of prepared and
+// committed snapshots.
+type cloneSnapshotter struct {
+	*fakeSnapshotter
+	snapshots map[string]snapshot.Info
+}
+
+func newCloneSnapshotter() *cloneSnapshotter {
+	return &cloneSnapshotter{
+		fakeSnapshotter: &fakeSnapshotter{},
+		snapshots: map[string]snapshot.Info{
+			"test-chain-id": {Kind: snapshot.KindCommitted, Name: "test-chain-id"},
+		},
+	}
+}
+
+func (f *cloneSnapshotter) Stat(_ gocontext.Context, key string) (snapshot.Info, error) {
+	info, ok := f.snapshots[key]
+	if !ok {
+		return snapshot.Info{}, errdefs.ErrNotFound
+	}
+	return info, nil
+}
+
+func (f *cloneSnapshotter) Prepare(_ gocontext.Context, key, parent string) ([]containerdmount.Mount, error) {
+	f.snapshots[key] = snapshot.Info{Kind: snapshot.KindActive, Name: key, Parent: parent}
+	return []containerdmount.Mount{{Type: "bind", Source: parent}}, nil
+}
+
+func (f *cloneSnapshotter) Commit(_ gocontext.Context, name, key string) error {
+	info := f.snapshots[key]
+	delete(f.snapshots, key)
+	f.snapshots[name] = snapshot.Info{Kind: snapshot.KindCommitted, Name: name, Parent: info.Parent}
+	return nil
+}
+
+func (f *cloneSnapshotter) Remove(ctx gocontext.Context, key string) error {
+	delete(f.snapshots, key)
+	return f.fakeSnapshotter.Remove(ctx, key)
+}
+
+// newTestCloneService returns a test service keeping snapshot records in a
+// temporary root directory, which should be removed by the caller.
+func newTestCloneService(t *testing.T) *criContainerdService {
+	c := newTestCRIContainerdService()
+	rootDir, err := ioutil.TempDir("", "clone-test")
+	require.NoError(t, err)
+	c.rootDir = rootDir
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.MkdirAllFn = os.MkdirAll
+	fakeOS.ReadFileFn = ioutil.ReadFile
+	fakeOS.AtomicWriteFileFn = ioutil.WriteFile
+	fakeOS.RemoveAllFn = os.RemoveAll
+	return c
+}
+
+// addContainerSnapshotRecord adds the record of a container snapshot.
+func addContainerSnapshotRecord(t *testing.T, c *criContainerdService, s containerSnapshot) {
+	path := getContainerSnapshotRecordPath(c.rootDir, s.Name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+}
+
+func TestSnapshotContainer(t *testing.T) {
+	layer := imagespec.Descriptor{MediaType: imagespec.MediaTypeImageLayer, Digest: "sha256:writable", Size: 10}
+	c := newTestCloneService(t)
+	defer os.RemoveAll(c.rootDir)
+	snapshotter := newCloneSnapshotter()
+	differ := &fakeDiffService{desc: layer}
+	c.snapshotService = snapshotter
+	c.diffService = differ
+	c.contentStoreService = &fakeContentStore{createdAt: time.Now().Add(time.Hour)}
+	c.imageStore.Add(imagestore.Image{ID: "test-image", ChainID: "test-chain-id"})
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID:     "test-sandbox-id",
+		Config: &runtime.PodSandboxConfig{Metadata: &runtime.PodSandboxMetadata{Namespace: "test-ns"}},
+	}}))
+	container, err := containerstore.NewContainer(containerstore.Metadata{ID: "test-id", SandboxID: "test-sandbox-id",
+		ImageRef: "test-image"}, containerstore.Status{})
+	require.NoError(t, err)
+	require.NoError(t, c.containerStore.Add(container))
+
+	expectedName := identity.ChainID([]digest.Digest{"test-chain-id", layer.Digest}).String()
+	for i := 0; i < 2; i++ {
+		s, err := c.snapshotContainer(context.Background(), "test-id")
+		require.NoError(t, err)
+		assert.Equal(t, &containerSnapshot{
+			Name:        expectedName,
+			ContainerID: "test-id",
+			ImageRef:    "test-image",
+			Parent:      "test-chain-id",
+			Namespace:   "test-ns",
+			Layer:       layer.Digest,
+		}, s)
+		record, err := c.getContainerSnapshot(expectedName)
+		require.NoError(t, err)
+		assert.Equal(t, s, record)
+		// The same content is only applied once.
+		assert.Equal(t, 1, differ.applied)
+	}
+	assert.Equal(t, snapshot.Info{Kind: snapshot.KindCommitted, Name: expectedName, Parent: "test-chain-id"},
+		snapshotter.snapshots[expectedName])
+	assert.Empty(t, snapshotter.views, "diff view should be removed")
+
+	_, err = c.snapshotContainer(context.Background(), "unknown-id")
+	assert.Error(t, err)
+
+	t.Logf("snapshot of the same content owned by another namespace should fail")
+	addContainerSnapshotRecord(t, c, containerSnapshot{Name: expectedName, Namespace: "other-ns"})
+	_, err = c.snapshotContainer(context.Background(), "test-id")
+	assert.Error(t, err)
+}
+
+func TestGetContainerRootfsParent(t *testing.T) {
+	image := imagestore.Image{ID: "test-image", ChainID: "test-chain-id"}
+	for desc, test := range map[string]struct {
+		annotations    map[string]string
+		namespace      string
+		expectErr      bool
+		expectedParent string
+	}{
+		"image snapshot without annotation": {
+			expectedParent: "test-chain-id",
+		},
+		"snapshot on top of the image": {
+			annotations:    map[string]string{rootfsSnapshotAnnotationKey: "clone"},
+			expectedParent: "clone",
+		},
+		"snapshot of another namespace": {
+			annotations: map[string]string{rootfsSnapshotAnnotationKey: "clone"},
+			namespace:   "other-ns",
+			expectErr:   true,
+		},
+		"snapshot not created by cri-containerd": {
+			annotations: map[string]string{rootfsSnapshotAnnotationKey: "unrecorded"},
+			expectErr:   true,
+		},
+		"snapshot on top of another snapshot of the image": {
+			annotations:    map[string]string{rootfsSnapshotAnnotationKey: "clone-of-clone"},
+			expectedParent: "clone-of-clone",
+		},
+		"snapshot of another image": {
+			annotations: map[string]string{rootfsSnapshotAnnotationKey: "other-clone"},
+			expectErr:   true,
+		},
+		"active snapshot": {
+			annotations: map[string]string{rootfsSnapshotAnnotationKey: "active"},
+			expectErr:   true,
+		},
+		"snapshot not found": {
+			annotations: map[string]string{rootfsSnapshotAnnotationKey: "unknown"},
+			expectErr:   true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCloneService(t)
+		defer os.RemoveAll(c.rootDir)
+		snapshotter := newCloneSnapshotter()
+		for _, info := range []snapshot.Info{
+			{Kind: snapshot.KindCommitted, Name: "clone", Parent: "test-chain-id"},
+			{Kind: snapshot.KindCommitted, Name: "clone-of-clone", Parent: "clone"},
+			{Kind: snapshot.KindCommitted, Name: "other-chain-id"},
+			{Kind: snapshot.KindCommitted, Name: "other-clone", Parent: "other-chain-id"},
+			{Kind: snapshot.KindActive, Name: "active", Parent: "test-chain-id"},
+			{Kind: snapshot.KindCommitted, Name: "unrecorded", Parent: "test-chain-id"},
+		} {
+			snapshotter.snapshots[info.Name] = info
+			if info.Name != "unrecorded" {
+				addContainerSnapshotRecord(t, c, containerSnapshot{Name: info.Name, Namespace: "test-ns"})
+			}
+		}
+		c.snapshotService = snapshotter
+		namespace := test.namespace
+		if namespace == "" {
+			namespace = "test-ns"
+		}
+		config := &runtime.ContainerConfig{Annotations: test.annotations}
+		sandboxConfig := &runtime.PodSandboxConfig{Metadata: &runtime.PodSandboxMetadata{Namespace: namespace}}
+		parent, err := c.getContainerRootfsParent(context.Background(), config, sandboxConfig, image)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectedParent, parent)
+	}
+}
+
+func TestRemoveContainerSnapshot(t *testing.T) {
+	c := newTestCloneService(t)
+	defer os.RemoveAll(c.rootDir)
+	contentStore := &fakeContentStore{}
+	c.contentStoreService = contentStore
+	snapshotter := newCloneSnapshotter()
+	snapshotter.snapshots["clone"] = snapshot.Info{Kind: snapshot.KindCommitted, Name: "clone", Parent: "test-chain-id"}
+	snapshotter.snapshots["active"] = snapshot.Info{Kind: snapshot.KindActive, Name: "active", Parent: "clone"}
+	c.snapshotService = snapshotter
+	c.imageStore.Add(imagestore.Image{ID: "test-image", ChainID: "test-chain-id"})
+
+	assert.Error(t, c.removeContainerSnapshot(context.Background(), "test-chain-id"), "image snapshot should not be removed")
+	assert.Contains(t, snapshotter.snapshots, "test-chain-id")
+	assert.Error(t, c.removeContainerSnapshot(context.Background(), "active"), "active snapshot should not be removed")
+	assert.Contains(t, snapshotter.snapshots, "active")
+	addContainerSnapshotRecord(t, c, containerSnapshot{Name: "clone", Namespace: "test-ns", Layer: "sha256:layer"})
+	assert.NoError(t, c.removeContainerSnapshot(context.Background(), "clone"))
+	assert.NotContains(t, snapshotter.snapshots, "clone")
+	assert.Equal(t, []digest.Digest{"sha256:layer"}, contentStore.deleted, "committed layer should be removed")
+	_, err := c.getContainerSnapshot("clone")
+	assert.True(t, os.IsNotExist(err), "snapshot record should be removed")
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/docker/distribution/reference"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	imagespecs "github.com/opencontainers/image-spec/specs-go"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+)
+
+// commitCreatedBy is recorded in the history of committed images.
+const commitCreatedBy = "cri-containerd commit"
+
+// commitOptions are options to commit a container into an image.
+type commitOptions struct {
+	// Tag is the repo tag of the new image. The image is only referenced by
+	// its id if it is empty.
+	Tag string
+	// Author is the author of the new layer.
+	Author string
+	// Comment is the comment of the new layer.
+	Comment string
+}
+
+// commitResult is the result of a container commit.
+type commitResult struct {
+	ID      string `json:"id"`
+	RepoTag string `json:"repoTag,omitempty"`
+}
+
+// commitContainer creates an image from the image of the container with the
+// container writable layer added on top, and registers it into the image stores.
+func (c *criContainerdService) commitContainer(ctx context.Context, id string, opts commitOptions) (*commitResult, error) {
+	var repoTag string
+	if opts.Tag != "" {
+		named, err := normalizeImageRef(opts.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tag %q: %v", opts.Tag, err)
+		}
+		if _, ok := named.(reference.NamedTagged); !ok {
+			return nil, fmt.Errorf("%q is not a tag", opts.Tag)
+		}
+		repoTag = named.String()
+	}
+	container, err := c.containerStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find container %q: %v", id, err)
+	}
+	baseImage, err := c.imageStoreService.Get(ctx, container.ImageRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %q from containerd image store: %v", container.ImageRef, err)
+	}
+	var baseManifest imagespec.Manifest
+	if err := readJSONBlob(ctx, c.contentStoreService, baseImage.Target.Digest, &baseManifest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest of image %q: %v", container.ImageRef, err)
+	}
+	var baseConfig imagespec.Image
+	if err := readJSONBlob(ctx, c.contentStoreService, baseManifest.Config.Digest, &baseConfig); err != nil {
+		return nil, fmt.Errorf("failed to read config of image %q: %v", container.ImageRef, err)
+	}
+
+	layer, _, err := c.diffContainerRootfs(ctx, container.ID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writable layer of container %q: %v", container.ID, err)
+	}
+	config, manifest := buildCommitImage(baseConfig, baseManifest, layer, opts, time.Now())
+	configDesc, err := writeJSONBlob(ctx, c.contentStoreService, imagespec.MediaTypeImageConfig, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write image config: %v", err)
+	}
+	manifest.Config = configDesc
+	manifestDesc, err := writeJSONBlob(ctx, c.contentStoreService, imagespec.MediaTypeImageManifest, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write image manifest: %v", err)
+	}
+
+	imageID, err := c.registerImage(ctx, manifestDesc, repoTag)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Committed container %q into image %q %q", container.ID, imageID, repoTag)
+	return &commitResult{ID: imageID, RepoTag: repoTag}, nil
+}
+
+// buildCommitImage returns the config and manifest of the image with the layer
+// added on top of the base image. The config descriptor of the manifest is not set.
+func buildCommitImage(config imagespec.Image, manifest imagespec.Manifest, layer imagespec.Descriptor,
+	opts commitOptions, now time.Time) (imagespec.Image, imagespec.Manifest) {
+	now = now.UTC()
+	config.Created = &now
+	if opts.Author != "" {
+		config.Author = opts.Author
+	}
+	// The layer is uncompressed, so its digest is also the diff id.
+	config.RootFS.DiffIDs = append(append([]digest.Digest{}, config.RootFS.DiffIDs...), layer.Digest)
+	config.History = append(append([]imagespec.History{}, config.History...), imagespec.History{
+		Created:   &now,
+		CreatedBy: commitCreatedBy,
+		Author:    opts.Author,
+		Comment:   opts.Comment,
+	})
+	return config, imagespec.Manifest{
+		Versioned: imagespecs.Versioned{SchemaVersion: 2},
+		Layers:    append(append([]imagespec.Descriptor{}, manifest.Layers...), layer),
+	}
+}
+
+// readJSONBlob reads the json blob from the content store into v.
+func readJSONBlob(ctx context.Context, cs content.Provider, dgst digest.Digest, v interface{}) error {
+	p, err := content.ReadBlob(ctx, cs, dgst)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// writeJSONBlob writes v as a json blob into the content store, and returns its
+// descriptor.
+func writeJSONBlob(ctx context.Context, cs content.Ingester, mediaType string, v interface{}) (imagespec.Descriptor, error) {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	desc := imagespec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(p),
+		Size:      int64(len(p)),
+	}
+	if err := content.WriteBlob(ctx, cs, desc.Digest.String(), bytes.NewReader(p), desc.Size, desc.Digest); err != nil {
+		return imagespec.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// handleContainerCommit handles the container-commit debug endpoint. It commits
+// container "id" into an image tagged with "tag", with optional "author" and
+// "comment" recorded in the image history.
+func (c *criContainerdService) handleContainerCommit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	result, err := c.commitContainer(r.Context(), query.Get("id"), commitOptions{
+		Tag:     query.Get("tag"),
+		Author:  query.Get("author"),
+		Comment: query.Get("comment"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestBuildCommitImage(t *testing.T) {
+	now := time.Now()
+	baseLayer := imagespec.Descriptor{MediaType: imagespec.MediaTypeImageLayerGzip, Digest: "sha256:base-blob", Size: 100}
+	newLayer := imagespec.Descriptor{MediaType: imagespec.MediaTypeImageLayer, Digest: "sha256:new-layer", Size: 10}
+	baseConfig := imagespec.Image{
+		Author:  "base-author",
+		Config:  imagespec.ImageConfig{User: "test-user"},
+		RootFS:  imagespec.RootFS{Type: "layers", DiffIDs: []digest.Digest{"sha256:base-diff"}},
+		History: []imagespec.History{{CreatedBy: "base"}},
+	}
+	baseManifest := imagespec.Manifest{Layers: []imagespec.Descriptor{baseLayer}}
+
+	config, manifest := buildCommitImage(baseConfig, baseManifest, newLayer,
+		commitOptions{Author: "test-author", Comment: "test-comment"}, now)
+	assert.Equal(t, "test-author", config.Author)
+	assert.Equal(t, "test-user", config.Config.User)
+	assert.Equal(t, []digest.Digest{"sha256:base-diff", "sha256:new-layer"}, config.RootFS.DiffIDs)
+	assert.Len(t, config.History, 2)
+	assert.Equal(t, commitCreatedBy, config.History[1].CreatedBy)
+	assert.Equal(t, "test-comment", config.History[1].Comment)
+	assert.Equal(t, 2, manifest.SchemaVersion)
+	assert.Equal(t, []imagespec.Descriptor{baseLayer, newLayer}, manifest.Layers)
+	// Base image should not be mutated.
+	assert.Equal(t, []digest.Digest{"sha256:base-diff"}, baseConfig.RootFS.DiffIDs)
+	assert.Len(t, baseManifest.Layers, 1)
+
+	config, _ = buildCommitImage(baseConfig, baseManifest, newLayer, commitOptions{}, now)
+	assert.Equal(t, "base-author", config.Author, "author should be kept if not set")
+}
+
+func TestCommitContainerInvalidTag(t *testing.T) {
+	c := newTestCRIContainerdService()
+	for _, tag := range []string{"Invalid:Tag", "busybox@sha256:e6693c20186f837fc393390135d8a598a96a833917917789d63766cab6c59582"} {
+		_, err := c.commitContainer(context.Background(), "test-id", commitOptions{Tag: tag})
+		assert.Error(t, err, tag)
+	}
+}
@@ -19,20 +19,21 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/containers"
 	prototypes "github.com/gogo/protobuf/types"
 	"github.com/golang/glog"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/opencontainers/runc/libcontainer/devices"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
-	"github.com/opencontainers/runtime-tools/generate"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/spec"
 	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
 )
 
 // CreateContainer creates a new container in the given PodSandbox.
@@ -49,7 +50,7 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 	sandboxConfig := r.GetSandboxConfig()
 	sandbox, err := c.sandboxStore.Get(r.GetPodSandboxId())
 	if err != nil {
-		return nil, fmt.Errorf("failed to find sandbox id %q: %v", r.GetPodSandboxId(), err)
+		return nil, sandboxLookupError(r.GetPodSandboxId(), err)
 	}
 	sandboxID := sandbox.ID
 
@@ -59,7 +60,7 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 	id := generateID()
 	name := makeContainerName(config.GetMetadata(), sandboxConfig.GetMetadata())
 	if err = c.containerNameIndex.Reserve(name, id); err != nil {
-		return nil, fmt.Errorf("failed to reserve container name %q: %v", name, err)
+		return nil, newCRIError(codes.AlreadyExists, ReasonNameConflict, "failed to reserve container name %q: %v", name, err)
 	}
 	defer func() {
 		// Release the name if the function returns with an error.
@@ -67,6 +68,14 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 			c.containerNameIndex.ReleaseByName(name)
 		}
 	}()
+	if err := c.namespaceQuotas.reserveContainer(id, sandbox.Config.GetMetadata().GetNamespace()); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			c.namespaceQuotas.releaseContainer(id)
+		}
+	}()
 
 	// Create initial internal container metadata.
 	meta := containerstore.Metadata{
@@ -78,20 +87,92 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 
 	// Prepare container image snapshot. For container, the image should have
 	// been pulled before creating the container, so do not ensure the image.
-	imageRef := config.GetImage().GetImage()
-	image, err := c.localResolve(ctx, imageRef)
+	image, err := c.validateContainerConfig(ctx, config, sandboxConfig, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve image %q: %v", imageRef, err)
+		return nil, err
 	}
-	if image == nil {
-		return nil, fmt.Errorf("image %q not found", imageRef)
+	c.imageLastUsed.markUsed(image.ID)
+	handler, err := c.getRuntimeHandler(sandbox.Config.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runtime handler: %v", err)
 	}
-
-	// Generate container runtime spec.
-	mounts := c.generateContainerMounts(getSandboxRootDir(c.rootDir, sandboxID), config)
-	spec, err := c.generateContainerSpec(id, sandbox.Pid, config, sandboxConfig, image.Config, mounts)
+	rootfsParent, err := c.getContainerRootfsParent(ctx, config, sandboxConfig, *image)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate container %q spec: %v", id, err)
+		return nil, newPhaseError(phaseRootfs, err, "failed to get rootfs parent of container %q", name)
+	}
+
+	// Generate the container spec, prepare the container rootfs and create the
+	// container root directory concurrently. They are independent, and the
+	// rootfs preparation is a containerd round trip on the critical path of
+	// pod start.
+	var (
+		wg                             sync.WaitGroup
+		spec                           *runtimespec.Spec
+		specErr, rootfsErr, rootDirErr error
+	)
+	containerRootDir := getContainerRootDir(c.rootDir, id)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		mounts := c.generateContainerMounts(getSandboxRootDir(c.rootDir, sandboxID), config)
+		mounts = append(mounts, handler.containerMounts()...)
+		spec, specErr = c.generateContainerSpec(id, sandbox.Pid, config, sandboxConfig, image.Config, mounts)
+	}()
+	go func() {
+		defer wg.Done()
+		if rootfsParent != image.ChainID {
+			// Image repair doesn't apply to containers cloned from a snapshot.
+			rootfsErr = c.prepareContainerRootfs(ctx, id, config, rootfsParent)
+			return
+		}
+		rootfsErr = c.prepareContainerRootfsWithRepair(ctx, id, config, *image)
+	}()
+	go func() {
+		defer wg.Done()
+		rootDirErr = c.os.MkdirAll(containerRootDir, 0755)
+	}()
+	wg.Wait()
+	// Register cleanup of the steps which succeeded before checking errors,
+	// so that they are rolled back if any other step failed.
+	if rootfsErr == nil {
+		defer func() {
+			if retErr != nil {
+				if err := c.snapshotService.Remove(ctx, id); err != nil {
+					glog.Errorf("Failed to remove container snapshot %q: %v", id, err)
+				}
+			}
+		}()
+	}
+	if rootDirErr == nil {
+		defer func() {
+			if retErr != nil {
+				// Cleanup the container root directory.
+				if err := c.os.RemoveAll(containerRootDir); err != nil {
+					glog.Errorf("Failed to remove container root directory %q: %v",
+						containerRootDir, err)
+				}
+			}
+		}()
+	}
+	if specErr != nil {
+		return nil, newPhaseError(phaseSpec, specErr, "failed to generate container %q spec", id)
+	}
+	if rootfsErr != nil {
+		return nil, rootfsErr
+	}
+	if rootDirErr != nil {
+		return nil, newPhaseError(phaseFiles, rootDirErr, "failed to create container root directory %q",
+			containerRootDir)
+	}
+	if err := c.failpoints.eval(failpointSnapshotPrepared); err != nil {
+		return nil, newPhaseError(phaseRootfs, err, "failed after preparing container rootfs")
+	}
+	meta.ImageRef = image.ID
+
+	// Set user after the rootfs is prepared, because users and groups are looked
+	// up in the rootfs.
+	if err := c.setOCIUser(ctx, spec, id, config, image.Config); err != nil {
+		return nil, newPhaseError(phaseSpec, err, "failed to set user for container %q", id)
 	}
 	rawSpec, err := json.Marshal(spec)
 	if err != nil {
@@ -99,54 +180,29 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 	}
 	glog.V(4).Infof("Container spec: %+v", spec)
 
-	// Prepare container rootfs.
-	if config.GetLinux().GetSecurityContext().GetReadonlyRootfs() {
-		if _, err := c.snapshotService.View(ctx, id, image.ChainID); err != nil {
-			return nil, fmt.Errorf("failed to view container rootfs %q: %v", image.ChainID, err)
-		}
-	} else {
-		if _, err := c.snapshotService.Prepare(ctx, id, image.ChainID); err != nil {
-			return nil, fmt.Errorf("failed to prepare container rootfs %q: %v", image.ChainID, err)
-		}
+	// Create containerd container with the runtime handler of the sandbox.
+	runtimeInfo, err := handler.runtimeInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runtime info: %v", err)
 	}
-	defer func() {
-		if retErr != nil {
-			if err := c.snapshotService.Remove(ctx, id); err != nil {
-				glog.Errorf("Failed to remove container snapshot %q: %v", id, err)
-			}
-		}
-	}()
-	meta.ImageRef = image.ID
-
-	// Create container root directory.
-	containerRootDir := getContainerRootDir(c.rootDir, id)
-	if err = c.os.MkdirAll(containerRootDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create container root directory %q: %v",
-			containerRootDir, err)
+	// Checkpoint the metadata, so that the container is recovered after restart.
+	labels, err := containerMetadataLabels(meta)
+	if err != nil {
+		return nil, err
 	}
-	defer func() {
-		if retErr != nil {
-			// Cleanup the container root directory.
-			if err = c.os.RemoveAll(containerRootDir); err != nil {
-				glog.Errorf("Failed to remove container root directory %q: %v",
-					containerRootDir, err)
-			}
-		}
-	}()
-
-	// Create containerd container.
 	if _, err = c.containerService.Create(ctx, containers.Container{
-		ID: id,
-		// TODO(random-liu): Checkpoint metadata into container labels.
+		ID:      id,
+		Labels:  labels,
 		Image:   image.ID,
-		Runtime: containers.RuntimeInfo{Name: defaultRuntime},
+		Runtime: runtimeInfo,
 		Spec: &prototypes.Any{
 			TypeUrl: runtimespec.Version,
 			Value:   rawSpec,
 		},
-		RootFS: id,
+		RootFS:      id,
+		Snapshotter: c.snapshotterCaps.Name,
 	}); err != nil {
-		return nil, fmt.Errorf("failed to create containerd container: %v", err)
+		return nil, newPhaseError(phaseContainer, err, "failed to create containerd container")
 	}
 	defer func() {
 		if retErr != nil {
@@ -156,7 +212,8 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 		}
 	}()
 
-	container, err := containerstore.NewContainer(meta, containerstore.Status{CreatedAt: time.Now().UnixNano()})
+	container, err := containerstore.NewContainer(meta, containerstore.Status{CreatedAt: time.Now().UnixNano()},
+		containerstore.WithStatusCheckpoint(c.os, getContainerStatusPath(c.rootDir, id)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create internal container object for %q: %v",
 			id, err)
@@ -178,75 +235,106 @@ func (c *criContainerdService) CreateContainer(ctx context.Context, r *runtime.C
 	return &runtime.CreateContainerResponse{ContainerId: id}, nil
 }
 
-func (c *criContainerdService) generateContainerSpec(id string, sandboxPid uint32, config *runtime.ContainerConfig,
-	sandboxConfig *runtime.PodSandboxConfig, imageConfig *imagespec.ImageConfig, extraMounts []*runtime.Mount) (*runtimespec.Spec, error) {
-	// Creates a spec Generator with the default spec.
-	g := generate.New()
-
-	// Set the relative path to the rootfs of the container from containerd's
-	// pre-defined directory.
-	g.SetRootPath(relativeRootfsPath)
-
-	if err := setOCIProcessArgs(&g, config, imageConfig); err != nil {
+// validateContainerConfig resolves the image of the container, and validates
+// and admits the container config before anything is created for the
+// container. Mounts of the config are replaced with the ones allowed by the
+// mount policy. It is shared by CreateContainer and dry run, and dry run
+// admission requests are flagged for the admission webhook.
+func (c *criContainerdService) validateContainerConfig(ctx context.Context, config *runtime.ContainerConfig,
+	sandboxConfig *runtime.PodSandboxConfig, dryRun bool) (*imagestore.Image, error) {
+	imageRef := config.GetImage().GetImage()
+	image, err := c.localResolve(ctx, imageRef)
+	if err != nil {
+		return nil, newPhaseError(phaseImage, err, "failed to resolve image %q", imageRef)
+	}
+	if image == nil {
+		return nil, newPhaseError(phaseImage, newCRIError(codes.NotFound, ReasonImageNotFound, "image %q not found", imageRef),
+			"image %q is not pulled", imageRef)
+	}
+	if err := c.checkImageDigestRef(imageRef); err != nil {
 		return nil, err
 	}
-
-	if config.GetWorkingDir() != "" {
-		g.SetProcessCwd(config.GetWorkingDir())
-	} else if imageConfig.WorkingDir != "" {
-		g.SetProcessCwd(imageConfig.WorkingDir)
+	repoDigest, err := c.checkImageRepoDigest(imageRef, image.RepoDigests)
+	if err != nil {
+		return nil, err
 	}
-
-	// Apply envs from image config first, so that envs from container config
-	// can override them.
-	if err := addImageEnvs(&g, imageConfig.Env); err != nil {
+	if repoDigest != "" {
+		glog.V(2).Infof("Image %q of container %q is pinned to %q", imageRef, config.GetMetadata().GetName(), repoDigest)
+	}
+	req := newContainerAdmissionRequest(config, sandboxConfig, image)
+	req.DryRun = dryRun
+	if err := c.admission.admit(ctx, req); err != nil {
 		return nil, err
 	}
-	for _, e := range config.GetEnvs() {
-		g.AddProcessEnv(e.GetKey(), e.GetValue())
+	mounts, err := c.mountPolicy.apply(config, sandboxConfig)
+	if err != nil {
+		return nil, err
 	}
+	config.Mounts = mounts
+	return image, nil
+}
 
-	// TODO: add setOCIPrivileged group all privileged logic together
-	securityContext := config.GetLinux().GetSecurityContext()
-
-	// Add extra mounts first so that CRI specified mounts can override.
-	addOCIBindMounts(&g, append(extraMounts, config.GetMounts()...), securityContext.GetPrivileged())
-
-	g.SetRootReadonly(securityContext.GetReadonlyRootfs())
-
-	if err := addOCIDevices(&g, config.GetDevices(), securityContext.GetPrivileged()); err != nil {
-		return nil, fmt.Errorf("failed to set devices mapping %+v: %v", config.GetDevices(), err)
+// prepareContainerRootfs prepares the container rootfs snapshot from the image,
+// the snapshot is readonly if the container rootfs is readonly.
+func (c *criContainerdService) prepareContainerRootfs(ctx context.Context, id string, config *runtime.ContainerConfig,
+	chainID string) error {
+	if err := c.prepareContainerSnapshot(ctx, id, config, chainID); err != nil {
+		return newPhaseError(phaseRootfs, err, "failed to prepare container rootfs %q", chainID)
 	}
+	return nil
+}
 
-	setOCILinuxResource(&g, config.GetLinux().GetResources())
-
-	if sandboxConfig.GetLinux().GetCgroupParent() != "" {
-		cgroupsPath := getCgroupsPath(sandboxConfig.GetLinux().GetCgroupParent(), id)
-		g.SetLinuxCgroupsPath(cgroupsPath)
+// prepareContainerSnapshot prepares the container rootfs snapshot, and returns
+// the snapshotter error as is.
+func (c *criContainerdService) prepareContainerSnapshot(ctx context.Context, id string, config *runtime.ContainerConfig,
+	chainID string) error {
+	if config.GetLinux().GetSecurityContext().GetReadonlyRootfs() {
+		_, err := c.snapshotService.View(ctx, id, chainID)
+		return err
 	}
+	_, err := c.snapshotService.Prepare(ctx, id, chainID)
+	return err
+}
 
-	g.SetProcessTerminal(config.GetTty())
-
-	if err := setOCICapabilities(&g, securityContext.GetCapabilities(), securityContext.GetPrivileged()); err != nil {
-		return nil, fmt.Errorf("failed to set capabilities %+v: %v",
-			securityContext.GetCapabilities(), err)
+// generateContainerSpec resolves the injected envs and security profiles of
+// the container on the node, and generates the container spec.
+func (c *criContainerdService) generateContainerSpec(id string, sandboxPid uint32, config *runtime.ContainerConfig,
+	sandboxConfig *runtime.PodSandboxConfig, imageConfig *imagespec.ImageConfig, extraMounts []*runtime.Mount) (*runtimespec.Spec, error) {
+	injectedEnvs, err := c.getInjectedEnvs(config, sandboxConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get injected envs: %v", err)
 	}
-
-	// Set namespaces, share namespace with sandbox container.
-	setOCINamespaces(&g, securityContext.GetNamespaceOptions(), sandboxPid)
-
-	// TODO(random-liu): [P1] Set selinux options.
-
-	// TODO(random-liu): [P1] Set user/username.
-
-	supplementalGroups := securityContext.GetSupplementalGroups()
-	for _, group := range supplementalGroups {
-		g.AddProcessAdditionalGid(uint32(group))
+	handler, err := c.getRuntimeHandler(sandboxConfig.GetAnnotations())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runtime handler: %v", err)
+	}
+	opts := spec.ContainerOptions{
+		ID:                           id,
+		SandboxPid:                   sandboxPid,
+		Config:                       config,
+		SandboxConfig:                sandboxConfig,
+		ImageConfig:                  imageConfig,
+		ExtraMounts:                  extraMounts,
+		InjectedEnvs:                 injectedEnvs,
+		DefaultPath:                  c.config.DefaultProcessPath,
+		LocaltimeFile:                c.config.LocaltimeFile,
+		PrivilegedWithoutHostDevices: handler.privilegedWithoutHostDevices(c.config.PrivilegedWithoutHostDevices),
+		UnmaskedProcMountNamespaces:  c.config.UnmaskedProcMountNamespaces,
+		DefaultCPUShares:             c.config.DefaultCPUShares,
+		DefaultOOMScoreAdj:           c.config.DefaultOOMScoreAdj,
+	}
+	// Privileged containers are not confined by apparmor and seccomp.
+	securityContext := config.GetLinux().GetSecurityContext()
+	if !securityContext.GetPrivileged() {
+		if opts.ApparmorProfile, err = c.getApparmorProfile(securityContext.GetApparmorProfile()); err != nil {
+			return nil, fmt.Errorf("failed to set apparmor profile %q: %v", securityContext.GetApparmorProfile(), err)
+		}
+		profile := getSeccompProfile(sandboxConfig.GetAnnotations(), config.GetMetadata().GetName())
+		if opts.Seccomp, err = c.loadSeccompProfile(profile); err != nil {
+			return nil, fmt.Errorf("failed to set seccomp profile %q: %v", profile, err)
+		}
 	}
-
-	// TODO(random-liu): [P2] Add apparmor and seccomp.
-
-	return g.Spec(), nil
+	return spec.GenerateContainerSpec(opts)
 }
 
 // generateContainerMounts sets up necessary container mounts including /dev/shm, /etc/hosts
@@ -279,178 +367,3 @@ func (c *criContainerdService) generateContainerMounts(sandboxRootDir string, co
 	})
 	return mounts
 }
-
-// setOCIProcessArgs sets process args. It returns error if the final arg list
-// is empty.
-func setOCIProcessArgs(g *generate.Generator, config *runtime.ContainerConfig, imageConfig *imagespec.ImageConfig) error {
-	command, args := config.GetCommand(), config.GetArgs()
-	// The following logic is migrated from https://github.com/moby/moby/blob/master/daemon/commit.go
-	// TODO(random-liu): Clearly define the commands overwrite behavior.
-	if len(command) == 0 {
-		if len(args) == 0 {
-			args = imageConfig.Cmd
-		}
-		if command == nil {
-			command = imageConfig.Entrypoint
-		}
-	}
-	if len(command) == 0 && len(args) == 0 {
-		return fmt.Errorf("no command specified")
-	}
-	g.SetProcessArgs(append(command, args...))
-	return nil
-}
-
-// addImageEnvs adds environment variables from image config. It returns error if
-// an invalid environment variable is encountered.
-func addImageEnvs(g *generate.Generator, imageEnvs []string) error {
-	for _, e := range imageEnvs {
-		kv := strings.Split(e, "=")
-		if len(kv) != 2 {
-			return fmt.Errorf("invalid environment variable %q", e)
-		}
-		g.AddProcessEnv(kv[0], kv[1])
-	}
-	return nil
-}
-
-func clearReadOnly(m *runtimespec.Mount) {
-	var opt []string
-	for _, o := range m.Options {
-		if o != "ro" {
-			opt = append(opt, o)
-		}
-	}
-	m.Options = opt
-}
-
-// addDevices set device mapping.
-func addOCIDevices(g *generate.Generator, devs []*runtime.Device, privileged bool) error {
-	spec := g.Spec()
-	if privileged {
-		hostDevices, err := devices.HostDevices()
-		if err != nil {
-			return err
-		}
-		for _, hostDevice := range hostDevices {
-			rd := runtimespec.LinuxDevice{
-				Path:  hostDevice.Path,
-				Type:  string(hostDevice.Type),
-				Major: hostDevice.Major,
-				Minor: hostDevice.Minor,
-				UID:   &hostDevice.Uid,
-				GID:   &hostDevice.Gid,
-			}
-			g.AddDevice(rd)
-		}
-		spec.Linux.Resources.Devices = []runtimespec.LinuxDeviceCgroup{
-			{
-				Allow:  true,
-				Access: "rwm",
-			},
-		}
-		return nil
-	}
-	for _, device := range devs {
-		dev, err := devices.DeviceFromPath(device.HostPath, device.Permissions)
-		if err != nil {
-			return err
-		}
-		rd := runtimespec.LinuxDevice{
-			Path:  device.ContainerPath,
-			Type:  string(dev.Type),
-			Major: dev.Major,
-			Minor: dev.Minor,
-			UID:   &dev.Uid,
-			GID:   &dev.Gid,
-		}
-		g.AddDevice(rd)
-		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, runtimespec.LinuxDeviceCgroup{
-			Allow:  true,
-			Type:   string(dev.Type),
-			Major:  &dev.Major,
-			Minor:  &dev.Minor,
-			Access: dev.Permissions,
-		})
-	}
-	return nil
-}
-
-// addOCIBindMounts adds bind mounts.
-// TODO(random-liu): Figure out whether we need to change all CRI mounts to readonly when
-// rootfs is readonly. (https://github.com/moby/moby/blob/master/daemon/oci_linux.go)
-func addOCIBindMounts(g *generate.Generator, mounts []*runtime.Mount, privileged bool) {
-	// Mount cgroup into the container as readonly, which inherits docker's behavior.
-	g.AddCgroupsMount("ro") // nolint: errcheck
-	for _, mount := range mounts {
-		dst := mount.GetContainerPath()
-		src := mount.GetHostPath()
-		options := []string{"rw"}
-		if mount.GetReadonly() {
-			options = []string{"ro"}
-		}
-		// TODO(random-liu): [P1] Apply selinux label
-		g.AddBindMount(src, dst, options)
-	}
-	if !privileged {
-		return
-	}
-	spec := g.Spec()
-	// clear readonly for /sys and cgroup
-	for i, m := range spec.Mounts {
-		if spec.Mounts[i].Destination == "/sys" && !spec.Root.Readonly {
-			clearReadOnly(&spec.Mounts[i])
-		}
-		if m.Type == "cgroup" {
-			clearReadOnly(&spec.Mounts[i])
-		}
-	}
-	spec.Linux.ReadonlyPaths = nil
-	spec.Linux.MaskedPaths = nil
-}
-
-// setOCILinuxResource set container resource limit.
-func setOCILinuxResource(g *generate.Generator, resources *runtime.LinuxContainerResources) {
-	if resources == nil {
-		return
-	}
-	g.SetLinuxResourcesCPUPeriod(uint64(resources.GetCpuPeriod()))
-	g.SetLinuxResourcesCPUQuota(resources.GetCpuQuota())
-	g.SetLinuxResourcesCPUShares(uint64(resources.GetCpuShares()))
-	g.SetLinuxResourcesMemoryLimit(resources.GetMemoryLimitInBytes())
-	g.SetProcessOOMScoreAdj(int(resources.GetOomScoreAdj()))
-}
-
-// setOCICapabilities adds/drops process capabilities.
-func setOCICapabilities(g *generate.Generator, capabilities *runtime.Capability, privileged bool) error {
-	if privileged {
-		// Add all capabilities in privileged mode.
-		g.SetupPrivileged(true)
-		return nil
-	}
-	if capabilities == nil {
-		return nil
-	}
-
-	// Capabilities in CRI doesn't have `CAP_` prefix, so add it.
-	for _, c := range capabilities.GetAddCapabilities() {
-		if err := g.AddProcessCapability("CAP_" + c); err != nil {
-			return err
-		}
-	}
-
-	for _, c := range capabilities.GetDropCapabilities() {
-		if err := g.DropProcessCapability("CAP_" + c); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// setOCINamespaces sets namespaces.
-func setOCINamespaces(g *generate.Generator, namespaces *runtime.NamespaceOption, sandboxPid uint32) {
-	g.AddOrReplaceLinuxNamespace(string(runtimespec.NetworkNamespace), getNetworkNamespace(sandboxPid)) // nolint: errcheck
-	g.AddOrReplaceLinuxNamespace(string(runtimespec.IPCNamespace), getIPCNamespace(sandboxPid))         // nolint: errcheck
-	g.AddOrReplaceLinuxNamespace(string(runtimespec.UTSNamespace), getUTSNamespace(sandboxPid))         // nolint: errcheck
-	g.AddOrReplaceLinuxNamespace(string(runtimespec.PIDNamespace), getPIDNamespace(sandboxPid))         // nolint: errcheck
-}
@@ -17,14 +17,24 @@ limitations under the License.
 package server
 
 import (
+	gocontext "context"
+	"errors"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
+	containerdmount "github.com/containerd/containerd/mount"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
-	"github.com/opencontainers/runtime-tools/generate"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/spec"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
 )
 
 func checkMount(t *testing.T, mounts []runtimespec.Mount, src, dest, typ string,
@@ -110,61 +120,61 @@ func getCreateContainerTestData() (*runtime.ContainerConfig, *runtime.PodSandbox
 		Cmd:        []string{"cmd"},
 		WorkingDir: "/workspace",
 	}
-	specCheck := func(t *testing.T, id string, sandboxPid uint32, spec *runtimespec.Spec) {
-		assert.Equal(t, relativeRootfsPath, spec.Root.Path)
-		assert.Equal(t, []string{"test", "command", "test", "args"}, spec.Process.Args)
-		assert.Equal(t, "test-cwd", spec.Process.Cwd)
-		assert.Contains(t, spec.Process.Env, "k1=v1", "k2=v2", "ik1=iv1", "ik2=iv2")
+	specCheck := func(t *testing.T, id string, sandboxPid uint32, s *runtimespec.Spec) {
+		assert.Equal(t, spec.RelativeRootfsPath, s.Root.Path)
+		assert.Equal(t, []string{"test", "command", "test", "args"}, s.Process.Args)
+		assert.Equal(t, "test-cwd", s.Process.Cwd)
+		assert.Contains(t, s.Process.Env, "k1=v1", "k2=v2", "ik1=iv1", "ik2=iv2")
 
 		t.Logf("Check cgroups bind mount")
-		checkMount(t, spec.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", []string{"ro"}, nil)
+		checkMount(t, s.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", []string{"ro"}, nil)
 
 		t.Logf("Check bind mount")
-		checkMount(t, spec.Mounts, "host-path-1", "container-path-1", "bind", []string{"rw"}, nil)
-		checkMount(t, spec.Mounts, "host-path-2", "container-path-2", "bind", []string{"ro"}, nil)
+		checkMount(t, s.Mounts, "host-path-1", "container-path-1", "bind", []string{"rw"}, nil)
+		checkMount(t, s.Mounts, "host-path-2", "container-path-2", "bind", []string{"ro"}, nil)
 
 		t.Logf("Check resource limits")
-		assert.EqualValues(t, *spec.Linux.Resources.CPU.Period, 100)
-		assert.EqualValues(t, *spec.Linux.Resources.CPU.Quota, 200)
-		assert.EqualValues(t, *spec.Linux.Resources.CPU.Shares, 300)
-		assert.EqualValues(t, *spec.Linux.Resources.Memory.Limit, 400)
-		assert.EqualValues(t, *spec.Process.OOMScoreAdj, 500)
+		assert.EqualValues(t, *s.Linux.Resources.CPU.Period, 100)
+		assert.EqualValues(t, *s.Linux.Resources.CPU.Quota, 200)
+		assert.EqualValues(t, *s.Linux.Resources.CPU.Shares, 300)
+		assert.EqualValues(t, *s.Linux.Resources.Memory.Limit, 400)
+		assert.EqualValues(t, *s.Process.OOMScoreAdj, 500)
 
 		t.Logf("Check capabilities")
-		assert.Contains(t, spec.Process.Capabilities.Bounding, "CAP_SYS_ADMIN")
-		assert.Contains(t, spec.Process.Capabilities.Effective, "CAP_SYS_ADMIN")
-		assert.Contains(t, spec.Process.Capabilities.Inheritable, "CAP_SYS_ADMIN")
-		assert.Contains(t, spec.Process.Capabilities.Permitted, "CAP_SYS_ADMIN")
-		assert.Contains(t, spec.Process.Capabilities.Ambient, "CAP_SYS_ADMIN")
-		assert.NotContains(t, spec.Process.Capabilities.Bounding, "CAP_CHOWN")
-		assert.NotContains(t, spec.Process.Capabilities.Effective, "CAP_CHOWN")
-		assert.NotContains(t, spec.Process.Capabilities.Inheritable, "CAP_CHOWN")
-		assert.NotContains(t, spec.Process.Capabilities.Permitted, "CAP_CHOWN")
-		assert.NotContains(t, spec.Process.Capabilities.Ambient, "CAP_CHOWN")
+		assert.Contains(t, s.Process.Capabilities.Bounding, "CAP_SYS_ADMIN")
+		assert.Contains(t, s.Process.Capabilities.Effective, "CAP_SYS_ADMIN")
+		assert.Contains(t, s.Process.Capabilities.Inheritable, "CAP_SYS_ADMIN")
+		assert.Contains(t, s.Process.Capabilities.Permitted, "CAP_SYS_ADMIN")
+		assert.Contains(t, s.Process.Capabilities.Ambient, "CAP_SYS_ADMIN")
+		assert.NotContains(t, s.Process.Capabilities.Bounding, "CAP_CHOWN")
+		assert.NotContains(t, s.Process.Capabilities.Effective, "CAP_CHOWN")
+		assert.NotContains(t, s.Process.Capabilities.Inheritable, "CAP_CHOWN")
+		assert.NotContains(t, s.Process.Capabilities.Permitted, "CAP_CHOWN")
+		assert.NotContains(t, s.Process.Capabilities.Ambient, "CAP_CHOWN")
 
 		t.Logf("Check supplemental groups")
-		assert.Contains(t, spec.Process.User.AdditionalGids, uint32(1111))
-		assert.Contains(t, spec.Process.User.AdditionalGids, uint32(2222))
+		assert.Contains(t, s.Process.User.AdditionalGids, uint32(1111))
+		assert.Contains(t, s.Process.User.AdditionalGids, uint32(2222))
 
 		t.Logf("Check cgroup path")
-		assert.Equal(t, getCgroupsPath("/test/cgroup/parent", id), spec.Linux.CgroupsPath)
+		assert.Equal(t, getCgroupsPath("/test/cgroup/parent", id), s.Linux.CgroupsPath)
 
 		t.Logf("Check namespaces")
-		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+		assert.Contains(t, s.Linux.Namespaces, runtimespec.LinuxNamespace{
 			Type: runtimespec.NetworkNamespace,
-			Path: getNetworkNamespace(sandboxPid),
+			Path: spec.NamespacePath(sandboxPid, runtimespec.NetworkNamespace),
 		})
-		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+		assert.Contains(t, s.Linux.Namespaces, runtimespec.LinuxNamespace{
 			Type: runtimespec.IPCNamespace,
-			Path: getIPCNamespace(sandboxPid),
+			Path: spec.NamespacePath(sandboxPid, runtimespec.IPCNamespace),
 		})
-		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+		assert.Contains(t, s.Linux.Namespaces, runtimespec.LinuxNamespace{
 			Type: runtimespec.UTSNamespace,
-			Path: getUTSNamespace(sandboxPid),
+			Path: spec.NamespacePath(sandboxPid, runtimespec.UTSNamespace),
 		})
-		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+		assert.Contains(t, s.Linux.Namespaces, runtimespec.LinuxNamespace{
 			Type: runtimespec.PIDNamespace,
-			Path: getPIDNamespace(sandboxPid),
+			Path: spec.NamespacePath(sandboxPid, runtimespec.PIDNamespace),
 		})
 	}
 	return config, sandboxConfig, imageConfig, specCheck
@@ -241,64 +251,51 @@ func TestContainerSpecWithExtraMounts(t *testing.T) {
 	assert.Contains(t, mounts[1].Options, "rw")
 }
 
-func TestContainerSpecCommand(t *testing.T) {
+func TestContainerSpecPrivilegedWithoutHostDevices(t *testing.T) {
+	testID := "test-id"
+	testPid := uint32(1234)
+	enabled, disabled := true, false
 	for desc, test := range map[string]struct {
-		criEntrypoint   []string
-		criArgs         []string
-		imageEntrypoint []string
-		imageArgs       []string
-		expected        []string
-		expectErr       bool
+		withoutHostDevices bool
+		handler            *runtimeHandler
+		expectHostDevices  bool
 	}{
-		"should use cri entrypoint if it's specified": {
-			criEntrypoint:   []string{"a", "b"},
-			imageEntrypoint: []string{"c", "d"},
-			imageArgs:       []string{"e", "f"},
-			expected:        []string{"a", "b"},
-		},
-		"should use cri entrypoint if it's specified even if it's empty": {
-			criEntrypoint:   []string{},
-			criArgs:         []string{"a", "b"},
-			imageEntrypoint: []string{"c", "d"},
-			imageArgs:       []string{"e", "f"},
-			expected:        []string{"a", "b"},
-		},
-		"should use cri entrypoint and args if they are specified": {
-			criEntrypoint:   []string{"a", "b"},
-			criArgs:         []string{"c", "d"},
-			imageEntrypoint: []string{"e", "f"},
-			imageArgs:       []string{"g", "h"},
-			expected:        []string{"a", "b", "c", "d"},
+		"global option should apply without runtime handler": {
+			withoutHostDevices: true,
 		},
-		"should use image entrypoint if cri entrypoint is not specified": {
-			criArgs:         []string{"a", "b"},
-			imageEntrypoint: []string{"c", "d"},
-			imageArgs:       []string{"e", "f"},
-			expected:        []string{"c", "d", "a", "b"},
+		"global option should apply if runtime handler doesn't set it": {
+			withoutHostDevices: true,
+			handler:            &runtimeHandler{},
 		},
-		"should use image args if both cri entrypoint and args are not specified": {
-			imageEntrypoint: []string{"c", "d"},
-			imageArgs:       []string{"e", "f"},
-			expected:        []string{"c", "d", "e", "f"},
+		"runtime handler should be able to hide host devices": {
+			handler: &runtimeHandler{PrivilegedWithoutHostDevices: &enabled},
 		},
-		"should return error if both entrypoint and args are empty": {
-			expectErr: true,
+		"runtime handler should be able to expose host devices": {
+			withoutHostDevices: true,
+			handler:            &runtimeHandler{PrivilegedWithoutHostDevices: &disabled},
+			expectHostDevices:  true,
 		},
 	} {
-
-		config, _, imageConfig, _ := getCreateContainerTestData()
-		g := generate.New()
-		config.Command = test.criEntrypoint
-		config.Args = test.criArgs
-		imageConfig.Entrypoint = test.imageEntrypoint
-		imageConfig.Cmd = test.imageArgs
-		err := setOCIProcessArgs(&g, config, imageConfig)
-		if test.expectErr {
-			assert.Error(t, err)
-			continue
+		t.Logf("TestCase %q", desc)
+		config, sandboxConfig, imageConfig, _ := getCreateContainerTestData()
+		config.Linux.SecurityContext.Privileged = true
+		c := newTestCRIContainerdService()
+		c.config.PrivilegedWithoutHostDevices = test.withoutHostDevices
+		if test.handler != nil {
+			c.runtimeHandlers = &runtimeHandlersConfig{
+				Default:  "test-handler",
+				Handlers: map[string]*runtimeHandler{"test-handler": test.handler},
+			}
 		}
-		assert.NoError(t, err)
-		assert.Equal(t, test.expected, g.Spec().Process.Args, desc)
+		spec, err := c.generateContainerSpec(testID, testPid, config, sandboxConfig, imageConfig, nil)
+		require.NoError(t, err)
+		allowAll := false
+		for _, d := range spec.Linux.Resources.Devices {
+			if d.Allow && d.Type == "" && d.Major == nil && d.Minor == nil && d.Access == "rwm" {
+				allowAll = true
+			}
+		}
+		assert.Equal(t, test.expectHostDevices, allowAll)
 	}
 }
 
@@ -388,43 +385,162 @@ func TestGenerateContainerMounts(t *testing.T) {
 	}
 }
 
-func TestPrivilegedBindMount(t *testing.T) {
+// prepareSnapshotter is a fake snapshotter which invokes prepareFn on Prepare.
+type prepareSnapshotter struct {
+	*fakeSnapshotter
+	mu        sync.Mutex
+	prepareFn func(key, parent string) error
+	prepared  map[string]string
+}
+
+func (p *prepareSnapshotter) Prepare(_ gocontext.Context, key, parent string) ([]containerdmount.Mount, error) {
+	if p.prepareFn != nil {
+		if err := p.prepareFn(key, parent); err != nil {
+			return nil, err
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.prepared == nil {
+		p.prepared = make(map[string]string)
+	}
+	p.prepared[key] = parent
+	return []containerdmount.Mount{{Type: "bind", Source: parent}}, nil
+}
+
+func (p *prepareSnapshotter) Remove(ctx gocontext.Context, key string) error {
+	p.mu.Lock()
+	delete(p.prepared, key)
+	p.mu.Unlock()
+	return p.fakeSnapshotter.Remove(ctx, key)
+}
+
+// newTestCreateContainerService creates a service with a sandbox and an image
+// ready for CreateContainer, and returns the request to create a container.
+func newTestCreateContainerService(t testing.TB) (*criContainerdService, *prepareSnapshotter,
+	*runtime.CreateContainerRequest) {
+	c, snapshotter, _ := newTestSandboxImageService()
+	prepare := &prepareSnapshotter{fakeSnapshotter: snapshotter}
+	c.snapshotService = prepare
+	config, sandboxConfig, _, _ := getCreateContainerTestData()
+	config.Image.Image = testSandboxImage
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID:     "test-sandbox-id",
+		Config: sandboxConfig,
+		Pid:    1234,
+	}}))
+	return c, prepare, &runtime.CreateContainerRequest{
+		PodSandboxId:  "test-sandbox-id",
+		Config:        config,
+		SandboxConfig: sandboxConfig,
+	}
+}
+
+func TestCreateContainerConcurrentSetup(t *testing.T) {
+	c, snapshotter, req := newTestCreateContainerService(t)
+	fakeOS := c.os.(*ostesting.FakeOS)
+	rootDirCreated := make(chan struct{})
+	var once sync.Once
+	fakeOS.MkdirAllFn = func(string, os.FileMode) error {
+		once.Do(func() { close(rootDirCreated) })
+		return nil
+	}
+	// Prepare only succeeds if the container root directory is created
+	// while the snapshot is being prepared.
+	snapshotter.prepareFn = func(string, string) error {
+		select {
+		case <-rootDirCreated:
+			return nil
+		case <-time.After(5 * time.Second):
+			return errors.New("root directory is not created concurrently")
+		}
+	}
+	resp, err := c.CreateContainer(context.Background(), req)
+	require.NoError(t, err)
+	id := resp.GetContainerId()
+	assert.Equal(t, "test-chain-id", snapshotter.prepared[id])
+	_, err = c.containerStore.Get(id)
+	assert.NoError(t, err)
+}
+
+func TestCreateContainerSetupRollback(t *testing.T) {
 	for desc, test := range map[string]struct {
-		privileged         bool
-		readonlyRootFS     bool
-		expectedSysFSRO    bool
-		expectedCgroupFSRO bool
+		prepareErr    error
+		mkdirErr      error
+		expectedPhase string
+		expectRemove  bool
 	}{
-		"sysfs and cgroupfs should mount as 'ro' by default": {
-			expectedSysFSRO:    true,
-			expectedCgroupFSRO: true,
+		"snapshot preparation failure should remove container root directory": {
+			prepareErr:    errors.New("prepare error"),
+			expectedPhase: phaseRootfs,
+			expectRemove:  true,
 		},
-		"sysfs and cgroupfs should not mount as 'ro' if privileged": {
-			privileged:         true,
-			expectedSysFSRO:    false,
-			expectedCgroupFSRO: false,
+		"root directory creation failure should remove container snapshot": {
+			mkdirErr:      errors.New("mkdir error"),
+			expectedPhase: phaseFiles,
 		},
-		"sysfs should mount as 'ro' if root filrsystem is readonly": {
-			privileged:         true,
-			readonlyRootFS:     true,
-			expectedSysFSRO:    true,
-			expectedCgroupFSRO: false,
+		"both failures should be reported": {
+			prepareErr:    errors.New("prepare error"),
+			mkdirErr:      errors.New("mkdir error"),
+			expectedPhase: phaseRootfs,
 		},
 	} {
 		t.Logf("TestCase %q", desc)
-		g := generate.New()
-		g.SetRootReadonly(test.readonlyRootFS)
-		addOCIBindMounts(&g, nil, test.privileged)
-		spec := g.Spec()
-		if test.expectedSysFSRO {
-			checkMount(t, spec.Mounts, "sysfs", "/sys", "sysfs", []string{"ro"}, nil)
-		} else {
-			checkMount(t, spec.Mounts, "sysfs", "/sys", "sysfs", nil, []string{"ro"})
+		c, snapshotter, req := newTestCreateContainerService(t)
+		fakeOS := c.os.(*ostesting.FakeOS)
+		if test.mkdirErr != nil {
+			fakeOS.InjectError("MkdirAll", test.mkdirErr)
 		}
-		if test.expectedCgroupFSRO {
-			checkMount(t, spec.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", []string{"ro"}, nil)
-		} else {
-			checkMount(t, spec.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", nil, []string{"ro"})
+		snapshotter.prepareFn = func(string, string) error { return test.prepareErr }
+		removed := false
+		fakeOS.RemoveAllFn = func(string) error {
+			removed = true
+			return nil
 		}
+		_, err := c.CreateContainer(context.Background(), req)
+		require.Error(t, err)
+		detail, ok := GetErrorDetail(err)
+		require.True(t, ok)
+		assert.Equal(t, test.expectedPhase, detail.Phase)
+		assert.Equal(t, test.expectRemove, removed)
+		assert.Empty(t, snapshotter.prepared)
+		assert.Empty(t, c.containerStore.List())
+		assert.Zero(t, c.containerNameIndex.Len())
+	}
+}
+
+// BenchmarkCreateContainer measures CreateContainer with latency injected
+// into snapshot preparation and root directory creation. Because they run
+// concurrently, the time per operation follows the slower step rather than
+// the sum of both.
+func BenchmarkCreateContainer(b *testing.B) {
+	for desc, latency := range map[string]struct {
+		prepare time.Duration
+		mkdir   time.Duration
+	}{
+		"no latency":                {},
+		"prepare latency":           {prepare: 2 * time.Millisecond},
+		"prepare and mkdir latency": {prepare: 2 * time.Millisecond, mkdir: 2 * time.Millisecond},
+	} {
+		latency := latency
+		b.Run(desc, func(b *testing.B) {
+			c, snapshotter, req := newTestCreateContainerService(b)
+			c.os.(*ostesting.FakeOS).MkdirAllFn = func(string, os.FileMode) error {
+				time.Sleep(latency.mkdir)
+				return nil
+			}
+			snapshotter.prepareFn = func(string, string) error {
+				time.Sleep(latency.prepare)
+				return nil
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Use a unique attempt for each container to avoid name conflict.
+				req.Config.Metadata.Attempt = uint32(i)
+				if _, err := c.CreateContainer(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
 }
@@ -17,14 +17,14 @@ limitations under the License.
 package server
 
 import (
-	"errors"
-
 	"golang.org/x/net/context"
-
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
 // Exec prepares a streaming endpoint to execute a command in the container, and returns the address.
+// There is no streaming server to serve the endpoint, so it always fails with codes.Unimplemented.
 func (c *criContainerdService) Exec(ctx context.Context, r *runtime.ExecRequest) (*runtime.ExecResponse, error) {
-	return nil, errors.New("not implemented")
+	return nil, newCRIError(codes.Unimplemented, ReasonStreamingUnsupported,
+		"failed to exec in container %q: streaming server is not available", r.GetContainerId())
 }
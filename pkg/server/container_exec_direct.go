@@ -0,0 +1,267 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// execProcessFile is the oci process spec of a direct exec.
+	execProcessFile = "process.json"
+	// execPidFile is the pid file of a direct exec process written by runc.
+	execPidFile = "pid"
+)
+
+// errRuncNotStarted means the exec process is not started by runc for a
+// direct exec, e.g. runc is not found or fails before starting the process, or
+// the container doesn't support direct exec. The exec could fall back to
+// containerd.
+type errRuncNotStarted struct {
+	err error
+}
+
+func (e *errRuncNotStarted) Error() string {
+	return fmt.Sprintf("failed to start runc: %v", e.err)
+}
+
+// execSyncDirect executes a command in the running container with `runc exec`
+// with stdout and stderr pipes attached to the exec process directly, and
+// returns the exit code after it exits. It bypasses the containerd shim, fifos
+// and the event stream, so it is much cheaper than execInContainer, e.g. for
+// exec probes. The exec process is killed on timeout, 0 means no timeout, or
+// when the context is cancelled. errRuncNotStarted is returned if the exec
+// process is not started, output written by runc should be discarded then.
+// Only containers using the default runtime handler are supported, because
+// other handlers may need runtime options runc exec doesn't know about.
+func (c *criContainerdService) execSyncDirect(ctx context.Context, id string, cmd []string, stdout, stderr io.Writer,
+	timeout time.Duration) (uint32, error) {
+	id, err := c.getExecContainerID(id)
+	if err != nil {
+		return unknownExitCode, err
+	}
+	if err := c.checkDirectExecHandler(id); err != nil {
+		return unknownExitCode, &errRuncNotStarted{err: err}
+	}
+	processSpec, err := c.getExecProcessSpec(ctx, id, cmd)
+	if err != nil {
+		return unknownExitCode, err
+	}
+	execDir, err := ioutil.TempDir(getContainerRootDir(c.rootDir, id), "exec")
+	if err != nil {
+		return unknownExitCode, fmt.Errorf("failed to create exec directory: %v", err)
+	}
+	defer func() {
+		if err := c.os.RemoveAll(execDir); err != nil {
+			glog.Errorf("Failed to remove exec directory %q: %v", execDir, err)
+		}
+	}()
+	processPath := filepath.Join(execDir, execProcessFile)
+	if err := c.os.WriteFile(processPath, processSpec.Value, 0600); err != nil {
+		return unknownExitCode, fmt.Errorf("failed to write exec process spec: %v", err)
+	}
+	pidPath := filepath.Join(execDir, execPidFile)
+
+	// The write ends of pipes are passed to runc and inherited by the exec
+	// process, so that the output doesn't go through runc.
+	var (
+		wg      sync.WaitGroup
+		readers []io.Closer
+		writers []*os.File
+	)
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+	redirect := func(w io.Writer) (*os.File, error) {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, r)
+		writers = append(writers, pw)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(w, r) // nolint: errcheck
+		}()
+		return pw, nil
+	}
+	runc := exec.Command(c.config.RuncBinary, "--root", filepath.Join(c.config.RuncRoot, k8sContainerdNamespace),
+		"exec", "--process", processPath, "--pid-file", pidPath, id)
+	if runc.Stdout, err = redirect(stdout); err != nil {
+		return unknownExitCode, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+	if runc.Stderr, err = redirect(stderr); err != nil {
+		for _, w := range writers {
+			w.Close()
+		}
+		return unknownExitCode, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+	err = runc.Start()
+	// Close the write ends in cri-containerd, so that the output is drained
+	// once the exec process and its children exit.
+	for _, w := range writers {
+		w.Close()
+	}
+	if err != nil {
+		return unknownExitCode, &errRuncNotStarted{err: err}
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	exitCode, timedOut, err := c.waitDirectExec(ctx, id, runc, pidPath, timeout)
+	if err != nil {
+		return unknownExitCode, err
+	}
+	if exitCode != 0 && !timedOut {
+		// Runc writes the pid file once the exec process is started, so a
+		// failure without pid file is a failure of runc itself, e.g. the
+		// container is not known by runc.
+		if _, err := c.os.Stat(pidPath); os.IsNotExist(err) {
+			return unknownExitCode, &errRuncNotStarted{
+				err: fmt.Errorf("runc exited with %d before starting the exec process", exitCode),
+			}
+		}
+	}
+	if timedOut {
+		return unknownExitCode, newCRIError(codes.DeadlineExceeded, ReasonExecTimeout,
+			"timeout %v exceeded running command %v in container %q", timeout, cmd, id)
+	}
+	select {
+	case <-drained:
+	case <-time.After(execDrainTimeout):
+		glog.Warningf("Output of direct exec in container %q is not drained after the exec exits", id)
+	}
+	return exitCode, nil
+}
+
+// checkDirectExecHandler returns an error if the container doesn't use the
+// default runtime handler.
+func (c *criContainerdService) checkDirectExecHandler(id string) error {
+	cntr, err := c.containerStore.Get(id)
+	if err != nil {
+		return fmt.Errorf("failed to get container %q: %v", id, err)
+	}
+	sandbox, err := c.sandboxStore.Get(cntr.SandboxID)
+	if err != nil {
+		return fmt.Errorf("failed to get sandbox %q: %v", cntr.SandboxID, err)
+	}
+	handler, err := c.getRuntimeHandler(sandbox.Config.GetAnnotations())
+	if err != nil {
+		return fmt.Errorf("failed to get runtime handler: %v", err)
+	}
+	if defaultHandler, _ := c.getRuntimeHandler(nil); handler != defaultHandler {
+		return fmt.Errorf("container %q doesn't use the default runtime handler", id)
+	}
+	return nil
+}
+
+// waitDirectExec waits for runc to exit, which exits with the exit code of the
+// exec process. If the exec process doesn't exit before the timeout, or the
+// context is cancelled, it is killed and waited again. Returns whether the
+// exec process is killed on timeout.
+func (c *criContainerdService) waitDirectExec(ctx context.Context, id string, runc *exec.Cmd, pidPath string,
+	timeout time.Duration) (uint32, bool, error) {
+	exitCh := make(chan error, 1)
+	go func() {
+		exitCh <- runc.Wait()
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	timedOut := false
+	var err error
+	select {
+	case err = <-exitCh:
+	case <-ctx.Done():
+		glog.V(2).Infof("Kill direct exec in container %q on cancellation", id)
+		if killErr := c.killDirectExec(pidPath); killErr != nil {
+			glog.Errorf("Failed to kill direct exec in container %q: %v", id, killErr)
+			runc.Process.Kill() // nolint: errcheck
+		}
+		select {
+		case <-exitCh:
+		case <-time.After(execKillTimeout):
+		}
+		return unknownExitCode, false, fmt.Errorf("direct exec in container %q is cancelled: %v", id, ctx.Err())
+	case <-timeoutCh:
+		timedOut = true
+		glog.V(2).Infof("Kill direct exec in container %q on timeout %v", id, timeout)
+		// Runc can't forward SIGKILL, so the exec process is killed with its pid.
+		if killErr := c.killDirectExec(pidPath); killErr != nil {
+			glog.Errorf("Failed to kill direct exec in container %q: %v", id, killErr)
+			runc.Process.Kill() // nolint: errcheck
+		}
+		select {
+		case err = <-exitCh:
+		case <-time.After(execKillTimeout):
+			return unknownExitCode, true, fmt.Errorf("direct exec in container %q is not stopped in %v after killed on timeout",
+				id, execKillTimeout)
+		}
+	}
+	if err == nil {
+		return 0, timedOut, nil
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return unknownExitCode, timedOut, fmt.Errorf("failed to wait for runc: %v", err)
+	}
+	status := exitErr.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		return uint32(128 + status.Signal()), timedOut, nil
+	}
+	return uint32(status.ExitStatus()), timedOut, nil
+}
+
+// killDirectExec kills the direct exec process with the pid in the pid file.
+func (c *criContainerdService) killDirectExec(pidPath string) error {
+	data, err := c.os.ReadFile(pidPath)
+	if err != nil {
+		return fmt.Errorf("failed to read pid file %q: %v", pidPath, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid in %q: %v", pidPath, err)
+	}
+	if err := unix.Kill(pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+		return fmt.Errorf("failed to kill pid %d: %v", pid, err)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/containers"
+	prototypes "github.com/gogo/protobuf/types"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestExecSyncDirect(t *testing.T) {
+	const testID = "test-id"
+	tmpDir, err := ioutil.TempDir("", "exec-direct-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	spec, err := json.Marshal(&runtimespec.Spec{Process: &runtimespec.Process{Args: []string{"sleep", "1000"}}})
+	require.NoError(t, err)
+
+	for desc, test := range map[string]struct {
+		// runc is the fake runc script, which is called with
+		// `--root <root> exec --process <process> --pid-file <pid> <id>`.
+		runc           string
+		timeout        time.Duration
+		ctxTimeout     time.Duration
+		handler        string
+		expectExitCode uint32
+		expectStderr   string
+		expectNotStart bool
+		expectTimeout  bool
+		expectCancel   bool
+	}{
+		"exec exits with exit code": {
+			runc: `echo $$ > "$7"
+echo "$@"
+echo test stderr >&2
+exit 3`,
+			expectExitCode: 3,
+			expectStderr:   "test stderr\n",
+		},
+		"exec is killed on timeout": {
+			runc: `echo $$ > "$7"
+exec sleep 10`,
+			timeout:       100 * time.Millisecond,
+			expectTimeout: true,
+		},
+		"exec is killed on cancellation without timeout": {
+			runc: `echo $$ > "$7"
+exec sleep 10`,
+			ctxTimeout:   100 * time.Millisecond,
+			expectCancel: true,
+		},
+		"runc not found": {
+			expectNotStart: true,
+		},
+		"runc fails before starting the exec process": {
+			runc: `echo "container not running" >&2
+exit 1`,
+			expectNotStart: true,
+		},
+		"container with non-default runtime handler": {
+			runc: `echo $$ > "$7"
+exit 0`,
+			handler:        "test-handler",
+			expectNotStart: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.rootDir = tmpDir
+		c.config.RuncRoot = "/run/runc"
+		c.config.RuncBinary = filepath.Join(tmpDir, "not-exist")
+		if test.runc != "" {
+			c.config.RuncBinary = filepath.Join(tmpDir, "runc")
+			require.NoError(t, ioutil.WriteFile(c.config.RuncBinary, []byte("#!/bin/sh\n"+test.runc+"\n"), 0755))
+		}
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.WriteFileFn = ioutil.WriteFile
+		fakeOS.ReadFileFn = ioutil.ReadFile
+		fakeOS.RemoveAllFn = os.RemoveAll
+		fakeOS.StatFn = os.Stat
+		require.NoError(t, os.MkdirAll(getContainerRootDir(tmpDir, testID), 0755))
+		containerStore := newFakeContainerStore()
+		containerStore.containers[testID] = containers.Container{
+			ID:   testID,
+			Spec: &prototypes.Any{TypeUrl: runtimespec.Version, Value: spec},
+		}
+		c.containerService = containerStore
+		c.runtimeHandlers = &runtimeHandlersConfig{Handlers: map[string]*runtimeHandler{"test-handler": {}}}
+		require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+			ID: "sandbox-id",
+			Config: &runtime.PodSandboxConfig{
+				Annotations: map[string]string{runtimeHandlerAnnotationKey: test.handler},
+			},
+		}}))
+		container, err := containerstore.NewContainer(containerstore.Metadata{ID: testID, SandboxID: "sandbox-id"},
+			containerstore.Status{CreatedAt: time.Now().UnixNano(), StartedAt: time.Now().UnixNano()})
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+
+		stdout, stderr := newCappedBuffer(1024), newCappedBuffer(1024)
+		ctx, cancel := context.WithCancel(context.Background())
+		if test.ctxTimeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), test.ctxTimeout)
+		}
+		exitCode, err := c.execSyncDirect(ctx, testID, []string{"sh"}, stdout, stderr, test.timeout)
+		cancel()
+		c.containerStore.Delete(testID)
+		c.sandboxStore.Delete("sandbox-id")
+		if test.expectNotStart {
+			_, ok := err.(*errRuncNotStarted)
+			assert.True(t, ok, "runc should not be started")
+			continue
+		}
+		if test.expectCancel {
+			require.Error(t, err)
+			assert.NotEqual(t, ReasonExecTimeout, ErrorReason(err))
+			continue
+		}
+		if test.expectTimeout {
+			require.Error(t, err)
+			assert.Equal(t, ReasonExecTimeout, ErrorReason(err))
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectExitCode, exitCode)
+		stdoutBytes, _ := stdout.Bytes()
+		stderrBytes, _ := stderr.Bytes()
+		assert.Equal(t, test.expectStderr, string(stderrBytes))
+		// The exec directory is random, and removed after the exec.
+		fields := bytes.Fields(stdoutBytes)
+		require.Len(t, fields, 8)
+		execDir := filepath.Dir(string(fields[4]))
+		assert.Equal(t, filepath.Join(execDir, execPidFile), string(fields[6]))
+		assert.Equal(t, getContainerRootDir(tmpDir, testID), filepath.Dir(execDir))
+		assert.Equal(t, []string{"--root", "/run/runc/k8s.io", "exec", "--process"}, toStrings(fields[:4]))
+		assert.Equal(t, testID, string(fields[7]))
+		_, err = os.Stat(execDir)
+		assert.True(t, os.IsNotExist(err), "exec directory should be removed")
+	}
+}
+
+func toStrings(fields [][]byte) []string {
+	var s []string
+	for _, f := range fields {
+		s = append(s, string(f))
+	}
+	return s
+}
@@ -22,6 +22,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sync"
+	"time"
 
 	"github.com/containerd/containerd/api/services/events/v1"
 	"github.com/containerd/containerd/api/services/tasks/v1"
@@ -30,13 +32,66 @@ import (
 	"github.com/golang/glog"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
-// ExecSync executes a command in the container, and returns the stdout output.
-// If command exits with a non-zero exit code, an error is returned.
+const (
+	// execSyncOutputLimit is the maximum size of each of stdout and stderr
+	// returned by ExecSync. Output beyond the limit is discarded.
+	execSyncOutputLimit = 4 * 1024 * 1024
+	// execKillTimeout is the time to wait for the exec process to exit
+	// after it is killed on timeout.
+	execKillTimeout = 10 * time.Second
+	// execDrainTimeout is the time to wait for the exec output to be
+	// drained after the exec process exits. The output is never closed if it
+	// is inherited by a process running in background.
+	execDrainTimeout = 2 * time.Second
+)
+
+// cappedBuffer is a buffer discarding writes beyond its limit, so that the
+// writer is still drained.
+type cappedBuffer struct {
+	sync.Mutex
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func newCappedBuffer(limit int) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+// Write writes data into the buffer until the limit is reached. It never
+// fails, so that the writer is drained.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	data := p
+	if remain := b.limit - b.buf.Len(); len(data) > remain {
+		data = data[:remain]
+		b.truncated = true
+	}
+	b.buf.Write(data) // nolint: errcheck
+	return len(p), nil
+}
+
+// Bytes returns a copy of the data in the buffer, and whether data beyond
+// the limit is discarded.
+func (b *cappedBuffer) Bytes() ([]byte, bool) {
+	b.Lock()
+	defer b.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...), b.truncated
+}
+
+// ExecSync executes a command in the container, and returns its stdout and
+// stderr output and exit code. The command is killed and an error is returned
+// if it doesn't exit before the timeout of the request.
 func (c *criContainerdService) ExecSync(ctx context.Context, r *runtime.ExecSyncRequest) (retRes *runtime.ExecSyncResponse, retErr error) {
 	glog.V(2).Infof("ExecSync for %q with command %+v and timeout %d (s)", r.GetContainerId(), r.GetCmd(), r.GetTimeout())
+	c.metrics.execSessions.Inc()
+	defer c.metrics.execSessions.Dec()
 	defer func() {
 		if retErr == nil {
 			glog.V(2).Infof("ExecSync for %q returns with exit code %d", r.GetContainerId(), retRes.GetExitCode())
@@ -45,99 +100,236 @@ func (c *criContainerdService) ExecSync(ctx context.Context, r *runtime.ExecSync
 		}
 	}()
 
-	// Get container from our container store.
-	cntr, err := c.containerStore.Get(r.GetContainerId())
+	stdoutBuf, stderrBuf := newCappedBuffer(execSyncOutputLimit), newCappedBuffer(execSyncOutputLimit)
+	opts := execOptions{
+		cmd:     r.GetCmd(),
+		stdout:  stdoutBuf,
+		stderr:  stderrBuf,
+		timeout: time.Duration(r.GetTimeout()) * time.Second,
+	}
+	var exitCode uint32
+	var err error
+	if c.config.ExecSyncDirect {
+		exitCode, err = c.execSyncDirect(ctx, r.GetContainerId(), opts.cmd, opts.stdout, opts.stderr, opts.timeout)
+		if _, ok := err.(*errRuncNotStarted); ok {
+			glog.Warningf("Fall back to containerd for ExecSync in %q: %v", r.GetContainerId(), err)
+			// Discard the output of runc.
+			stdoutBuf, stderrBuf = newCappedBuffer(execSyncOutputLimit), newCappedBuffer(execSyncOutputLimit)
+			opts.stdout, opts.stderr = stdoutBuf, stderrBuf
+			exitCode, err = c.execInContainer(ctx, r.GetContainerId(), opts)
+		}
+	} else {
+		exitCode, err = c.execInContainer(ctx, r.GetContainerId(), opts)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when try to find container %q: %v", r.GetContainerId(), err)
+		return nil, err
 	}
-	id := cntr.ID
-
-	state := cntr.Status.Get().State()
-	if state != runtime.ContainerState_CONTAINER_RUNNING {
-		return nil, fmt.Errorf("container %q is in %s state", id, criContainerStateToString(state))
+	stdoutBytes, stdoutTruncated := stdoutBuf.Bytes()
+	stderrBytes, stderrTruncated := stderrBuf.Bytes()
+	if stdoutTruncated || stderrTruncated {
+		glog.Warningf("Output of ExecSync for %q is truncated to %d bytes", r.GetContainerId(), execSyncOutputLimit)
 	}
+	return &runtime.ExecSyncResponse{
+		Stdout:   stdoutBytes,
+		Stderr:   stderrBytes,
+		ExitCode: int32(exitCode),
+	}, nil
+}
 
-	// Get exec process spec.
-	container, err := c.containerService.Get(ctx, id)
+// execOptions are the command and output streams of an exec process.
+type execOptions struct {
+	cmd []string
+	// stdout receives the stdout of the exec process, nil means no stdout.
+	stdout io.Writer
+	// stderr receives the stderr of the exec process, nil means no stderr.
+	stderr io.Writer
+	// timeout is the timeout of the exec process, after which it is killed.
+	// 0 means no timeout.
+	timeout time.Duration
+}
+
+// execInContainer executes a command in the running container with output
+// redirected, and returns the exit code after it exits. An error is returned
+// if the exec process is killed on timeout.
+func (c *criContainerdService) execInContainer(ctx context.Context, id string, opts execOptions) (uint32, error) {
+	id, err := c.getExecContainerID(id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get container %q from containerd: %v", id, err)
-	}
-	var spec runtimespec.Spec
-	if err := json.Unmarshal(container.Spec.Value, &spec); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal container spec: %v", err)
+		return unknownExitCode, err
 	}
-	pspec := spec.Process
-	pspec.Args = r.GetCmd()
-	rawSpec, err := json.Marshal(pspec)
+	processSpec, err := c.getExecProcessSpec(ctx, id, opts.cmd)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal oci process spec %+v: %v", pspec, err)
+		return unknownExitCode, err
 	}
 
-	// TODO(random-liu): Replace the following logic with containerd client and add unit test.
 	// Prepare streaming pipes.
 	execDir, err := ioutil.TempDir(getContainerRootDir(c.rootDir, id), "exec")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create exec streaming directory: %v", err)
+		return unknownExitCode, fmt.Errorf("failed to create exec streaming directory: %v", err)
 	}
 	defer func() {
-		if err = c.os.RemoveAll(execDir); err != nil {
+		if err := c.os.RemoveAll(execDir); err != nil {
 			glog.Errorf("Failed to remove exec streaming directory %q: %v", execDir, err)
 		}
 	}()
 	_, stdout, stderr := getStreamingPipes(execDir)
+	if opts.stdout == nil {
+		stdout = ""
+	}
+	if opts.stderr == nil {
+		stderr = ""
+	}
 	_, stdoutPipe, stderrPipe, err := c.prepareStreamingPipes(ctx, "", stdout, stderr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare streaming pipes: %v", err)
+		return unknownExitCode, fmt.Errorf("failed to prepare streaming pipes: %v", err)
+	}
+	for _, pipe := range []io.Closer{stdoutPipe, stderrPipe} {
+		if pipe != nil {
+			defer pipe.Close()
+		}
 	}
-	defer stdoutPipe.Close()
-	defer stderrPipe.Close()
 
 	// Start redirecting exec output.
-	stdoutBuf, stderrBuf := new(bytes.Buffer), new(bytes.Buffer)
-	go io.Copy(stdoutBuf, stdoutPipe) // nolint: errcheck
-	go io.Copy(stderrBuf, stderrPipe) // nolint: errcheck
-
+	var wg sync.WaitGroup
+	redirect := func(w io.Writer, r io.Reader) {
+		if r == nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(w, r) // nolint: errcheck
+		}()
+	}
+	redirect(opts.stdout, stdoutPipe)
+	redirect(opts.stderr, stderrPipe)
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
 	// Get containerd event client first, so that we won't miss any events.
 	// TODO(random-liu): Add filter to only subscribe events of the exec process.
 	cancellable, cancel := context.WithCancel(ctx)
+	defer cancel()
 	eventstream, err := c.eventService.Subscribe(cancellable, &events.SubscribeRequest{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get containerd event: %v", err)
+		return unknownExitCode, fmt.Errorf("failed to get containerd event: %v", err)
 	}
-	defer cancel()
 
 	execID := generateID()
-	_, err = c.taskService.Exec(ctx, &tasks.ExecProcessRequest{
+	execCtx, cancelExec := withRuntimeTimeout(ctx, c.config.ExecSetupTimeout)
+	defer cancelExec()
+	if _, err := c.taskService.Exec(execCtx, &tasks.ExecProcessRequest{
 		ContainerID: id,
 		Terminal:    false,
 		Stdout:      stdout,
 		Stderr:      stderr,
-		Spec: &prototypes.Any{
-			TypeUrl: runtimespec.Version,
-			Value:   rawSpec,
-		},
-		ExecID: execID,
+		Spec:        processSpec,
+		ExecID:      execID,
+	}); err != nil {
+		return unknownExitCode, fmt.Errorf("failed to exec in container %q: %v", id, err)
+	}
+
+	exitCode, timedOut, err := c.waitExecWithTimeout(ctx, id, execID, opts.timeout, func() (uint32, error) {
+		return c.waitContainerExec(eventstream, id, execID)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to exec in container %q: %v", id, err)
+		return unknownExitCode, fmt.Errorf("failed to wait for exec in container %q to finish: %v", id, err)
+	}
+	if _, err := c.taskService.DeleteProcess(ctx, &tasks.DeleteProcessRequest{
+		ContainerID: id,
+		ExecID:      execID,
+	}); err != nil && !isContainerdGRPCNotFoundError(err) {
+		return unknownExitCode, fmt.Errorf("failed to delete exec %q in container %q: %v", execID, id, err)
+	}
+	if timedOut {
+		return unknownExitCode, newCRIError(codes.DeadlineExceeded, ReasonExecTimeout,
+			"timeout %v exceeded running command %v in container %q", opts.timeout, opts.cmd, id)
 	}
-	exitCode, err := c.waitContainerExec(eventstream, id, execID)
+	select {
+	case <-drained:
+	case <-time.After(execDrainTimeout):
+		glog.Warningf("Output of exec %q in container %q is not drained after the exec exits", execID, id)
+	}
+	return exitCode, nil
+}
+
+// getExecContainerID returns the full id of the container to exec in, which
+// must be running.
+func (c *criContainerdService) getExecContainerID(id string) (string, error) {
+	cntr, err := c.containerStore.Get(id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to wait for exec in container %q to finish: %v", id, err)
+		return "", containerLookupError(id, err)
 	}
-	if _, err := c.taskService.DeleteProcess(ctx, &tasks.DeleteProcessRequest{
+	if state := cntr.Status.Get().State(); state != runtime.ContainerState_CONTAINER_RUNNING {
+		return "", newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState,
+			"container %q is in %s state", cntr.ID, criContainerStateToString(state))
+	}
+	return cntr.ID, nil
+}
+
+// waitExecWithTimeout waits for the exec process to exit with wait. If the
+// exec process doesn't exit before the timeout, it is killed and waited again.
+// 0 timeout means no timeout. Returns the exit code, and whether the exec
+// process is killed on timeout.
+func (c *criContainerdService) waitExecWithTimeout(ctx context.Context, id, execID string, timeout time.Duration,
+	wait func() (uint32, error)) (uint32, bool, error) {
+	type exit struct {
+		code uint32
+		err  error
+	}
+	exitCh := make(chan exit, 1)
+	go func() {
+		code, err := wait()
+		exitCh <- exit{code: code, err: err}
+	}()
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case e := <-exitCh:
+		return e.code, false, e.err
+	case <-timeoutCh:
+	}
+	glog.V(2).Infof("Kill exec %q in container %q on timeout %v", execID, id, timeout)
+	if _, err := c.taskService.Kill(ctx, &tasks.KillRequest{
 		ContainerID: id,
 		ExecID:      execID,
+		Signal:      uint32(unix.SIGKILL),
 	}); err != nil && !isContainerdGRPCNotFoundError(err) {
-		return nil, fmt.Errorf("failed to delete exec %q in container %q: %v", execID, id, err)
+		return unknownExitCode, true, fmt.Errorf("failed to kill exec %q on timeout: %v", execID, err)
+	}
+	select {
+	case e := <-exitCh:
+		return e.code, true, e.err
+	case <-time.After(execKillTimeout):
+		return unknownExitCode, true, fmt.Errorf("exec %q is not stopped in %v after killed on timeout", execID, execKillTimeout)
 	}
-	// TODO(random-liu): [P1] Deal with timeout, kill and wait again on timeout.
+}
 
-	// TODO(random-liu): Make sure stdout/stderr are drained.
-	return &runtime.ExecSyncResponse{
-		Stdout:   stdoutBuf.Bytes(),
-		Stderr:   stderrBuf.Bytes(),
-		ExitCode: int32(exitCode),
+// getExecProcessSpec returns the oci process spec of an exec process in the
+// container, which is the process spec of the container with the command.
+func (c *criContainerdService) getExecProcessSpec(ctx context.Context, id string, cmd []string) (*prototypes.Any, error) {
+	container, err := c.containerService.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container %q from containerd: %v", id, err)
+	}
+	var spec runtimespec.Spec
+	if err := json.Unmarshal(container.Spec.Value, &spec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal container spec: %v", err)
+	}
+	pspec := spec.Process
+	pspec.Args = cmd
+	rawSpec, err := json.Marshal(pspec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal oci process spec %+v: %v", pspec, err)
+	}
+	return &prototypes.Any{
+		TypeUrl: runtimespec.Version,
+		Value:   rawSpec,
 	}, nil
 }
 
@@ -0,0 +1,301 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/typeurl"
+	prototypes "github.com/gogo/protobuf/types"
+	"github.com/golang/protobuf/ptypes/empty"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	for desc, test := range map[string]struct {
+		writes    []string
+		expected  string
+		truncated bool
+	}{
+		"within limit": {
+			writes:   []string{"abc", "de"},
+			expected: "abcde",
+		},
+		"beyond limit": {
+			writes:    []string{"abc", "def", "gh"},
+			expected:  "abcde",
+			truncated: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		b := newCappedBuffer(5)
+		for _, w := range test.writes {
+			n, err := b.Write([]byte(w))
+			assert.NoError(t, err)
+			assert.Equal(t, len(w), n, "writes should never be short")
+		}
+		data, truncated := b.Bytes()
+		assert.Equal(t, test.expected, string(data))
+		assert.Equal(t, test.truncated, truncated)
+	}
+}
+
+// killTaskService is a fake task service recording kill requests.
+type killTaskService struct {
+	tasks.TasksClient
+	killed chan *tasks.KillRequest
+	err    error
+}
+
+func (f *killTaskService) Kill(_ context.Context, r *tasks.KillRequest, _ ...grpc.CallOption) (*empty.Empty, error) {
+	f.killed <- r
+	return &empty.Empty{}, f.err
+}
+
+func TestWaitExecWithTimeout(t *testing.T) {
+	for desc, test := range map[string]struct {
+		timeout   time.Duration
+		exitAfter time.Duration
+		killErr   error
+		exitCode  uint32
+		timedOut  bool
+		expectErr bool
+	}{
+		"exec exits before timeout": {
+			timeout:  time.Minute,
+			exitCode: 1,
+		},
+		"exec without timeout": {
+			exitAfter: 10 * time.Millisecond,
+			exitCode:  1,
+		},
+		"exec killed on timeout": {
+			timeout:   10 * time.Millisecond,
+			exitAfter: time.Hour,
+			exitCode:  137,
+			timedOut:  true,
+		},
+		"exec fails to be killed on timeout": {
+			timeout:   10 * time.Millisecond,
+			exitAfter: time.Hour,
+			killErr:   errors.New("kill error"),
+			exitCode:  unknownExitCode,
+			timedOut:  true,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		taskService := &killTaskService{killed: make(chan *tasks.KillRequest, 1), err: test.killErr}
+		c.taskService = taskService
+		wait := func() (uint32, error) {
+			select {
+			case r := <-taskService.killed:
+				assert.Equal(t, "test-exec-id", r.ExecID)
+				assert.EqualValues(t, unix.SIGKILL, r.Signal)
+				return 137, nil
+			case <-time.After(test.exitAfter):
+				return 1, nil
+			}
+		}
+		exitCode, timedOut, err := c.waitExecWithTimeout(context.Background(), "test-id", "test-exec-id", test.timeout, wait)
+		if test.expectErr {
+			require.Error(t, err)
+			assert.True(t, strings.Contains(err.Error(), "kill error"))
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, test.exitCode, exitCode)
+		assert.Equal(t, test.timedOut, timedOut)
+	}
+}
+
+// pipeEnd is one end of an in-memory fifo.
+type pipeEnd struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// fakeFifos are in-memory fifos, the exec process gets the other ends.
+type fakeFifos struct {
+	sync.Mutex
+	process map[string]*pipeEnd
+}
+
+func (f *fakeFifos) open(_ context.Context, path string, flag int, _ os.FileMode) (io.ReadWriteCloser, error) {
+	f.Lock()
+	defer f.Unlock()
+	r, w := io.Pipe()
+	if strings.HasSuffix(path, stdinNamedPipe) {
+		f.process[path] = &pipeEnd{Reader: r, Closer: r}
+		return &pipeEnd{Writer: w, Closer: w}, nil
+	}
+	f.process[path] = &pipeEnd{Writer: w, Closer: w}
+	return &pipeEnd{Reader: r, Closer: r}, nil
+}
+
+func (f *fakeFifos) get(path string) *pipeEnd {
+	f.Lock()
+	defer f.Unlock()
+	return f.process[path]
+}
+
+// fakeEventStream is a fake containerd event stream.
+type fakeEventStream struct {
+	grpc.ClientStream
+	ctx    context.Context
+	events chan *events.Envelope
+}
+
+func (f *fakeEventStream) Recv() (*events.Envelope, error) {
+	select {
+	case e := <-f.events:
+		return e, nil
+	case <-f.ctx.Done():
+		return nil, f.ctx.Err()
+	}
+}
+
+// fakeExecEventService is a fake event service publishing exec exit events.
+type fakeExecEventService struct {
+	events.EventsClient
+	events chan *events.Envelope
+}
+
+func (f *fakeExecEventService) Subscribe(ctx context.Context, _ *events.SubscribeRequest, _ ...grpc.CallOption) (events.Events_SubscribeClient, error) {
+	return &fakeEventStream{ctx: ctx, events: f.events}, nil
+}
+
+// fakeExecTaskService is a fake task service running an exec process, which
+// writes fixed output into its stdout and stderr.
+type fakeExecTaskService struct {
+	tasks.TasksClient
+	fifos  *fakeFifos
+	events chan *events.Envelope
+	mu     sync.Mutex
+	exec   *tasks.ExecProcessRequest
+}
+
+func (f *fakeExecTaskService) Exec(_ context.Context, r *tasks.ExecProcessRequest, _ ...grpc.CallOption) (*tasks.ExecProcessResponse, error) {
+	f.mu.Lock()
+	f.exec = r
+	f.mu.Unlock()
+	go func() {
+		for path, output := range map[string]string{r.Stdout: "test stdout", r.Stderr: "test stderr"} {
+			if pipe := f.fifos.get(path); pipe != nil {
+				pipe.Write([]byte(output)) // nolint: errcheck
+				pipe.Close()
+			}
+		}
+		any, _ := typeurl.MarshalAny(&events.TaskExit{ContainerID: r.ContainerID, ID: r.ExecID, ExitStatus: 3})
+		f.events <- &events.Envelope{Event: any}
+	}()
+	return &tasks.ExecProcessResponse{}, nil
+}
+
+func (f *fakeExecTaskService) DeleteProcess(context.Context, *tasks.DeleteProcessRequest, ...grpc.CallOption) (*tasks.DeleteResponse, error) {
+	return &tasks.DeleteResponse{}, nil
+}
+
+func TestExecInContainer(t *testing.T) {
+	const testID = "test-id"
+	rootDir, err := ioutil.TempDir("", "exec-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(rootDir)
+	spec, err := json.Marshal(&runtimespec.Spec{Process: &runtimespec.Process{Args: []string{"sleep", "1000"}}})
+	require.NoError(t, err)
+
+	for desc, test := range map[string]struct {
+		noStderr       bool
+		expectedStderr string
+	}{
+		"exec with stdout and stderr": {
+			expectedStderr: "test stderr",
+		},
+		"exec without stderr": {
+			noStderr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.rootDir = rootDir
+		require.NoError(t, os.MkdirAll(getContainerRootDir(rootDir, testID), 0755))
+		fifos := &fakeFifos{process: make(map[string]*pipeEnd)}
+		c.os.(*ostesting.FakeOS).OpenFifoFn = fifos.open
+		c.os.(*ostesting.FakeOS).RemoveAllFn = os.RemoveAll
+		containerStore := newFakeContainerStore()
+		containerStore.containers[testID] = containers.Container{
+			ID:   testID,
+			Spec: &prototypes.Any{TypeUrl: runtimespec.Version, Value: spec},
+		}
+		c.containerService = containerStore
+		execEvents := make(chan *events.Envelope, 1)
+		c.eventService = &fakeExecEventService{events: execEvents}
+		taskService := &fakeExecTaskService{fifos: fifos, events: execEvents}
+		c.taskService = taskService
+		container, err := containerstore.NewContainer(containerstore.Metadata{ID: testID},
+			containerstore.Status{CreatedAt: time.Now().UnixNano(), StartedAt: time.Now().UnixNano()})
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		opts := execOptions{cmd: []string{"sh"}, stdout: stdout, stderr: stderr}
+		if test.noStderr {
+			opts.stderr = nil
+		}
+		exitCode, err := c.execInContainer(context.Background(), testID, opts)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, exitCode)
+		assert.Equal(t, "test stdout", stdout.String())
+		assert.Equal(t, test.expectedStderr, stderr.String())
+
+		taskService.mu.Lock()
+		assert.False(t, taskService.exec.Terminal)
+		assert.Empty(t, taskService.exec.Stdin)
+		assert.Equal(t, test.noStderr, taskService.exec.Stderr == "")
+		var pspec runtimespec.Process
+		require.NoError(t, json.Unmarshal(taskService.exec.Spec.Value, &pspec))
+		assert.Equal(t, []string{"sh"}, pspec.Args)
+		assert.False(t, pspec.Terminal)
+		taskService.mu.Unlock()
+		_, err = os.Stat(filepath.Dir(taskService.exec.Stdout))
+		assert.True(t, os.IsNotExist(err), "exec streaming directory should be removed")
+		c.containerStore.Delete(testID)
+	}
+}
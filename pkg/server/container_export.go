@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+)
+
+// diffContainerRootfs computes the diff between the container rootfs and the image
+// rootfs, i.e. the container writable layer, or between the container rootfs and
+// an empty rootfs if full is true. The diff is written into the content store as
+// an uncompressed layer tar. The returned bool indicates whether the content is
+// newly created, so that the caller could clean it up.
+func (c *criContainerdService) diffContainerRootfs(ctx context.Context, id string, full bool) (imagespec.Descriptor, bool, error) {
+	container, err := c.containerStore.Get(id)
+	if err != nil {
+		return imagespec.Descriptor{}, false, fmt.Errorf("failed to find container %q: %v", id, err)
+	}
+	var parent string
+	if !full {
+		image, err := c.imageStore.Get(container.ImageRef)
+		if err != nil {
+			return imagespec.Descriptor{}, false, fmt.Errorf("failed to get image %q of container %q: %v",
+				container.ImageRef, container.ID, err)
+		}
+		parent = image.ChainID
+	}
+	// Create a temporary view of the parent as the lower side of the diff.
+	key := fmt.Sprintf("%s-diff-%s", container.ID, generateID())
+	lower, err := c.snapshotService.View(ctx, key, parent)
+	if err != nil {
+		return imagespec.Descriptor{}, false, fmt.Errorf("failed to create view of %q: %v", parent, err)
+	}
+	defer func() {
+		if err := c.snapshotService.Remove(ctx, key); err != nil {
+			glog.Errorf("Failed to remove diff view snapshot %q: %v", key, err)
+		}
+	}()
+	upper, err := c.snapshotService.Mounts(ctx, container.ID)
+	if err != nil {
+		return imagespec.Descriptor{}, false, fmt.Errorf("failed to get rootfs mounts of container %q: %v", container.ID, err)
+	}
+	start := time.Now()
+	desc, err := c.diffService.DiffMounts(ctx, lower, upper, imagespec.MediaTypeImageLayer, key)
+	if err != nil {
+		return imagespec.Descriptor{}, false, fmt.Errorf("failed to diff rootfs of container %q: %v", container.ID, err)
+	}
+	// The same content may exist before, e.g. an empty diff.
+	info, err := c.contentStoreService.Info(ctx, desc.Digest)
+	if err != nil {
+		return imagespec.Descriptor{}, false, fmt.Errorf("failed to get content %q: %v", desc.Digest, err)
+	}
+	return desc, !info.CreatedAt.Before(start), nil
+}
+
+// handleContainerExport handles the container-export debug endpoint. It streams
+// the writable layer of container "id" as a tar, or the full rootfs if "full" is
+// true.
+func (c *criContainerdService) handleContainerExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var full bool
+	if f := query.Get("full"); f != "" {
+		var err error
+		if full, err = strconv.ParseBool(f); err != nil {
+			http.Error(w, fmt.Sprintf("invalid full %q: %v", f, err), http.StatusBadRequest)
+			return
+		}
+	}
+	ctx := r.Context()
+	desc, created, err := c.diffContainerRootfs(ctx, query.Get("id"), full)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The exported tar is only kept in the content store during streaming.
+	if created {
+		defer func() {
+			if err := c.contentStoreService.Delete(context.Background(), desc.Digest); err != nil {
+				glog.Errorf("Failed to delete exported content %q: %v", desc.Digest, err)
+			}
+		}()
+	}
+	rc, err := c.contentStoreService.Reader(ctx, desc.Digest)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read content %q: %v", desc.Digest, err), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	if _, err := io.Copy(w, rc); err != nil {
+		glog.Errorf("Failed to stream export of container %q: %v", query.Get("id"), err)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	containerdmount "github.com/containerd/containerd/mount"
+	diffservice "github.com/containerd/containerd/services/diff"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+// fakeDiffService records lower mounts of the diff.
+type fakeDiffService struct {
+	diffservice.DiffService
+	lower []containerdmount.Mount
+	desc  imagespec.Descriptor
+	// applied is the number of layers applied.
+	applied int
+}
+
+func (f *fakeDiffService) DiffMounts(_ context.Context, lower, _ []containerdmount.Mount, _, _ string) (imagespec.Descriptor, error) {
+	f.lower = lower
+	return f.desc, nil
+}
+
+func (f *fakeDiffService) Apply(context.Context, imagespec.Descriptor, []containerdmount.Mount) (imagespec.Descriptor, error) {
+	f.applied++
+	return f.desc, nil
+}
+
+// fakeContentStore returns content info with the created time.
+type fakeContentStore struct {
+	content.Store
+	createdAt time.Time
+	deleted   []digest.Digest
+}
+
+func (f *fakeContentStore) Delete(_ gocontext.Context, dgst digest.Digest) error {
+	f.deleted = append(f.deleted, dgst)
+	return nil
+}
+
+func (f *fakeContentStore) Info(_ gocontext.Context, dgst digest.Digest) (content.Info, error) {
+	return content.Info{Digest: dgst, CreatedAt: f.createdAt}, nil
+}
+
+func TestDiffContainerRootfs(t *testing.T) {
+	desc := imagespec.Descriptor{MediaType: imagespec.MediaTypeImageLayer, Digest: "sha256:diff", Size: 10}
+	for name, test := range map[string]struct {
+		full            bool
+		createdAt       time.Time
+		expectedParent  string
+		expectedCreated bool
+	}{
+		"writable layer": {
+			createdAt:       time.Now().Add(time.Hour),
+			expectedParent:  "test-chain-id",
+			expectedCreated: true,
+		},
+		"full rootfs": {
+			full:            true,
+			createdAt:       time.Now().Add(time.Hour),
+			expectedParent:  "",
+			expectedCreated: true,
+		},
+		"existing content": {
+			createdAt:       time.Now().Add(-time.Hour),
+			expectedParent:  "test-chain-id",
+			expectedCreated: false,
+		},
+	} {
+		t.Logf("TestCase %q", name)
+		c := newTestCRIContainerdService()
+		snapshotter := &fakeSnapshotter{}
+		differ := &fakeDiffService{desc: desc}
+		c.snapshotService = snapshotter
+		c.diffService = differ
+		c.contentStoreService = &fakeContentStore{createdAt: test.createdAt}
+		c.imageStore.Add(imagestore.Image{ID: "test-image", ChainID: "test-chain-id"})
+		container, err := containerstore.NewContainer(containerstore.Metadata{ID: "test-id", ImageRef: "test-image"},
+			containerstore.Status{})
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+
+		got, created, err := c.diffContainerRootfs(context.Background(), "test-id", test.full)
+		require.NoError(t, err)
+		assert.Equal(t, desc, got)
+		assert.Equal(t, test.expectedCreated, created)
+		assert.Equal(t, []containerdmount.Mount{{Type: "bind", Source: test.expectedParent}}, differ.lower)
+		assert.Empty(t, snapshotter.views, "diff view should be removed")
+	}
+}
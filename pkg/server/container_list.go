@@ -34,6 +34,19 @@ func (c *criContainerdService) ListContainers(ctx context.Context, r *runtime.Li
 		}
 	}()
 
+	containers := c.listCRIContainers()
+	containers = c.filterCRIContainers(containers, r.GetFilter())
+	return &runtime.ListContainersResponse{Containers: containers}, nil
+}
+
+// listCRIContainers lists all containers in CRI format, the result is cached
+// until the container store changes.
+func (c *criContainerdService) listCRIContainers() []*runtime.Container {
+	generation := c.containerListGeneration()
+	if items, ok := c.containerListCache.get(generation); ok {
+		return copyCRIContainers(items.([]*runtime.Container))
+	}
+
 	// List all containers from store.
 	containersInStore := c.containerStore.List()
 
@@ -41,9 +54,8 @@ func (c *criContainerdService) ListContainers(ctx context.Context, r *runtime.Li
 	for _, container := range containersInStore {
 		containers = append(containers, toCRIContainer(container))
 	}
-
-	containers = c.filterCRIContainers(containers, r.GetFilter())
-	return &runtime.ListContainersResponse{Containers: containers}, nil
+	c.containerListCache.set(generation, containers)
+	return copyCRIContainers(containers)
 }
 
 // toCRIContainer converts internal container object into CRI container.
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/server/agents"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+// logMetadataSuffix is the suffix of the metadata file next to the container
+// log.
+const logMetadataSuffix = ".meta"
+
+// containerLogMetadata describes the container writing the log, so that log
+// collectors can enrich log entries without querying the apiserver. It is
+// written as json next to the container log when the container starts.
+type containerLogMetadata struct {
+	// PodUID is the uid of the pod.
+	PodUID string `json:"podUID"`
+	// PodName is the name of the pod.
+	PodName string `json:"podName"`
+	// PodNamespace is the namespace of the pod.
+	PodNamespace string `json:"podNamespace"`
+	// SandboxID is the id of the pod sandbox.
+	SandboxID string `json:"sandboxID"`
+	// ContainerName is the name of the container.
+	ContainerName string `json:"containerName"`
+	// ContainerID is the id of the container.
+	ContainerID string `json:"containerID"`
+	// Attempt is the attempt of the container.
+	Attempt uint32 `json:"attempt"`
+	// Image is the image the container is created with.
+	Image string `json:"image"`
+	// ImageRef is the id of the image.
+	ImageRef string `json:"imageRef"`
+	// StartedAt is the time the container log is started.
+	StartedAt time.Time `json:"startedAt"`
+	// IndexPath is the path of the log index file.
+	IndexPath string `json:"indexPath"`
+}
+
+// writeContainerLogMetadata writes the metadata file of the container log.
+func (c *criContainerdService) writeContainerLogMetadata(logPath string, meta containerstore.Metadata,
+	sandboxConfig *runtime.PodSandboxConfig) error {
+	data, err := json.Marshal(&containerLogMetadata{
+		PodUID:        sandboxConfig.GetMetadata().GetUid(),
+		PodName:       sandboxConfig.GetMetadata().GetName(),
+		PodNamespace:  sandboxConfig.GetMetadata().GetNamespace(),
+		SandboxID:     meta.SandboxID,
+		ContainerName: meta.Config.GetMetadata().GetName(),
+		ContainerID:   meta.ID,
+		Attempt:       meta.Config.GetMetadata().GetAttempt(),
+		Image:         meta.Config.GetImage().GetImage(),
+		ImageRef:      meta.ImageRef,
+		StartedAt:     time.Now(),
+		IndexPath:     agents.GetLogIndexPath(logPath),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal log metadata: %v", err)
+	}
+	metaPath := logPath + logMetadataSuffix
+	if err := c.os.WriteFile(metaPath, data, 0640); err != nil {
+		return fmt.Errorf("failed to write log metadata file %q: %v", metaPath, err)
+	}
+	return nil
+}
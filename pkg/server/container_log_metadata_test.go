@@ -0,0 +1,75 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+func TestWriteContainerLogMetadata(t *testing.T) {
+	c := newTestCRIContainerdService()
+	var (
+		path string
+		data []byte
+	)
+	c.os.(*ostesting.FakeOS).WriteFileFn = func(p string, d []byte, _ os.FileMode) error {
+		path, data = p, d
+		return nil
+	}
+	meta := containerstore.Metadata{
+		ID:        "test-id",
+		Name:      "test-name",
+		SandboxID: "test-sandbox-id",
+		ImageRef:  "sha256:test-image-id",
+		Config: &runtime.ContainerConfig{
+			Metadata: &runtime.ContainerMetadata{Name: "test-container", Attempt: 2},
+			Image:    &runtime.ImageSpec{Image: "busybox"},
+		},
+	}
+	sandboxConfig := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{Name: "test-pod", Namespace: "test-ns", Uid: "test-uid"},
+	}
+	before := time.Now()
+	require.NoError(t, c.writeContainerLogMetadata("/var/log/pods/test-uid/test-container_2.log", meta, sandboxConfig))
+	assert.Equal(t, "/var/log/pods/test-uid/test-container_2.log.meta", path)
+	var logMeta containerLogMetadata
+	require.NoError(t, json.Unmarshal(data, &logMeta))
+	assert.False(t, logMeta.StartedAt.Before(before.Truncate(time.Second)))
+	logMeta.StartedAt = time.Time{}
+	assert.Equal(t, containerLogMetadata{
+		PodUID:        "test-uid",
+		PodName:       "test-pod",
+		PodNamespace:  "test-ns",
+		SandboxID:     "test-sandbox-id",
+		ContainerName: "test-container",
+		ContainerID:   "test-id",
+		Attempt:       2,
+		Image:         "busybox",
+		ImageRef:      "sha256:test-image-id",
+		IndexPath:     "/var/log/pods/test-uid/test-container_2.log.index",
+	}, logMeta)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+// processStats is the process and open file descriptor count of a container.
+type processStats struct {
+	// Processes is the number of processes and threads in the container pids cgroup.
+	Processes uint64
+	// OpenFDs is the total number of file descriptors opened by processes
+	// in the container.
+	OpenFDs uint64
+}
+
+// getProcessStats returns the process and open fd count of the cgroup.
+func (c *criContainerdService) getProcessStats(cgroupsPath string) (*processStats, error) {
+	dir := filepath.Join(cgroupRoot, "pids", cgroupsPath)
+	processes, err := c.readCgroupValue(filepath.Join(dir, "pids.current"))
+	if err != nil {
+		return nil, err
+	}
+	procsPath := filepath.Join(dir, "cgroup.procs")
+	data, err := c.os.ReadFile(procsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", procsPath, err)
+	}
+	stats := &processStats{Processes: processes}
+	// Threads share the fd table of their process, so only processes are
+	// listed in cgroup.procs.
+	for _, pid := range strings.Fields(string(data)) {
+		fds, err := c.os.ReadDir(filepath.Join("/proc", pid, "fd"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The process exits after listed.
+				continue
+			}
+			return nil, fmt.Errorf("failed to list fds of process %s: %v", pid, err)
+		}
+		stats.OpenFDs += uint64(len(fds))
+	}
+	return stats, nil
+}
+
+// collectProcessStats returns metric samples of process and open fd counts of
+// all running containers.
+func (c *criContainerdService) collectProcessStats() (processes, fds []metrics.Sample) {
+	for _, container := range c.containerStore.List() {
+		cgroupsPath, err := c.getRunningContainerCgroupsPath(container)
+		if err != nil || cgroupsPath == "" {
+			continue
+		}
+		stats, err := c.getProcessStats(cgroupsPath)
+		if err != nil {
+			// The container may exit during collection.
+			glog.V(4).Infof("Failed to get process stats of container %q: %v", container.ID, err)
+			continue
+		}
+		labels := map[string]string{
+			"container_id":   container.ID,
+			"pod_sandbox_id": container.SandboxID,
+			"container_name": container.Config.GetMetadata().GetName(),
+		}
+		processes = append(processes, metrics.Sample{Labels: labels, Value: float64(stats.Processes)})
+		fds = append(fds, metrics.Sample{Labels: labels, Value: float64(stats.OpenFDs)})
+	}
+	return processes, fds
+}
+
+const (
+	// containerProcessesMetric is the name of the container processes gauge.
+	containerProcessesMetric = "cri_containerd_container_processes"
+	// containerOpenFDsMetric is the name of the container open fds gauge.
+	containerOpenFDsMetric = "cri_containerd_container_open_fds"
+)
+
+// registerProcessStatsMetrics registers per container process and open fd
+// gauges, so that fd leaks could be caught before they exhaust the node. Both
+// gauges are collected with one walk of all containers per scrape.
+func (c *criContainerdService) registerProcessStatsMetrics() {
+	snapshot := metrics.NewSnapshot(func() map[string][]metrics.Sample {
+		processes, fds := c.collectProcessStats()
+		return map[string][]metrics.Sample{
+			containerProcessesMetric: processes,
+			containerOpenFDsMetric:   fds,
+		}
+	})
+	c.metrics.registry.MustRegister(
+		metrics.NewLabeledGaugeFunc(containerProcessesMetric,
+			"Number of processes and threads in each running container.",
+			snapshot.Samples(containerProcessesMetric)),
+		metrics.NewLabeledGaugeFunc(containerOpenFDsMetric,
+			"Number of file descriptors opened by processes in each running container.",
+			snapshot.Samples(containerOpenFDsMetric)),
+	)
+	c.metrics.registry.RegisterCollector(snapshot)
+}
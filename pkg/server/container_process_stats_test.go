@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// newFakeProcessStatsOS fakes pids cgroup files of container-1 in
+// /kubepods/pod-1, whose process 100 has 3 fds, process 101 has 1 fd and
+// process 102 exits after listed.
+func newFakeProcessStatsOS() *ostesting.FakeOS {
+	fakeOS := ostesting.NewFakeOS()
+	files := map[string]string{
+		"/sys/fs/cgroup/pids/kubepods/pod-1/container-1/pids.current": "5\n",
+		"/sys/fs/cgroup/pids/kubepods/pod-1/container-1/cgroup.procs": "100\n101\n102\n",
+	}
+	fds := map[string]int{
+		"/proc/100/fd": 3,
+		"/proc/101/fd": 1,
+	}
+	fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("%q not found", path)
+		}
+		return []byte(content), nil
+	}
+	fakeOS.ReadDirFn = func(path string) ([]os.FileInfo, error) {
+		n, ok := fds[path]
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: syscall.ENOENT}
+		}
+		return make([]os.FileInfo, n), nil
+	}
+	return fakeOS
+}
+
+func TestGetProcessStats(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.os = newFakeProcessStatsOS()
+	stats, err := c.getProcessStats("/kubepods/pod-1/container-1")
+	require.NoError(t, err)
+	assert.Equal(t, &processStats{Processes: 5, OpenFDs: 4}, stats)
+
+	_, err = c.getProcessStats("/kubepods/pod-1/container-2")
+	assert.Error(t, err)
+}
+
+// addProcessStatsTestContainers adds running container-1 and exited
+// container-2 of sandbox-1 in cgroup /kubepods/pod-1.
+func addProcessStatsTestContainers(t *testing.T, c *criContainerdService) {
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID: "sandbox-1",
+		Config: &runtime.PodSandboxConfig{
+			Linux: &runtime.LinuxPodSandboxConfig{CgroupParent: "/kubepods/pod-1"},
+		},
+	}}))
+	for id, status := range map[string]containerstore.Status{
+		"container-1": {CreatedAt: 1, StartedAt: 2},
+		"container-2": {CreatedAt: 1, StartedAt: 2, FinishedAt: 3},
+	} {
+		container, err := containerstore.NewContainer(containerstore.Metadata{
+			ID:        id,
+			SandboxID: "sandbox-1",
+			Config: &runtime.ContainerConfig{
+				Metadata: &runtime.ContainerMetadata{Name: "name-" + id},
+			},
+		}, status)
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+}
+
+func TestCollectProcessStats(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.os = newFakeProcessStatsOS()
+	addProcessStatsTestContainers(t, c)
+
+	labels := map[string]string{
+		"container_id":   "container-1",
+		"pod_sandbox_id": "sandbox-1",
+		"container_name": "name-container-1",
+	}
+	processes, fds := c.collectProcessStats()
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 5}}, processes)
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 4}}, fds)
+}
+
+func TestProcessStatsMetrics(t *testing.T) {
+	c := newTestCRIContainerdService()
+	fakeOS := newFakeProcessStatsOS()
+	c.os = fakeOS
+	addProcessStatsTestContainers(t, c)
+	c.registerProcessStatsMetrics()
+
+	w := httptest.NewRecorder()
+	c.metrics.registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), containerProcessesMetric+`{container_id="container-1",container_name="name-container-1",pod_sandbox_id="sandbox-1"} 5`)
+	assert.Contains(t, w.Body.String(), containerOpenFDsMetric+`{container_id="container-1",container_name="name-container-1",pod_sandbox_id="sandbox-1"} 4`)
+	t.Logf("containers should be walked once per scrape")
+	reads := 0
+	for _, call := range fakeOS.GetCalls() {
+		if call.Name == "ReadFile" && call.Arguments[0] == "/sys/fs/cgroup/pids/kubepods/pod-1/container-1/pids.current" {
+			reads++
+		}
+	}
+	assert.Equal(t, 1, reads)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// containerProcess is a process in the container.
+type containerProcess struct {
+	// Pid is the pid of the process on the host.
+	Pid uint32 `json:"pid"`
+	// Args are the command line arguments of the process, empty if they can't
+	// be read, e.g. the process is a zombie or has exited.
+	Args []string `json:"args,omitempty"`
+}
+
+// containerProcesses is the process list of a container.
+type containerProcesses struct {
+	ID        string             `json:"id"`
+	Processes []containerProcess `json:"processes"`
+}
+
+// listContainerProcesses lists processes of the running container from its
+// containerd task, sorted by pid.
+func (c *criContainerdService) listContainerProcesses(ctx context.Context, id string) (*containerProcesses, error) {
+	container, err := c.containerStore.Get(id)
+	if err != nil {
+		return nil, containerLookupError(id, err)
+	}
+	id = container.ID
+	if state := container.Status.Get().State(); state != runtime.ContainerState_CONTAINER_RUNNING {
+		return nil, newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState, "container %q is in %s state",
+			id, criContainerStateToString(state))
+	}
+	resp, err := c.taskService.ListPids(ctx, &tasks.ListPidsRequest{ContainerID: id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pids of container %q: %v", id, err)
+	}
+	pids := resp.Pids
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	result := &containerProcesses{ID: id, Processes: []containerProcess{}}
+	for _, pid := range pids {
+		result.Processes = append(result.Processes, containerProcess{Pid: pid, Args: c.getProcessArgs(pid)})
+	}
+	return result, nil
+}
+
+// getProcessArgs reads command line arguments of the process from procfs.
+func (c *criContainerdService) getProcessArgs(pid uint32) []string {
+	path := filepath.Join("/proc", strconv.FormatUint(uint64(pid), 10), "cmdline")
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		// The process may exit after listed.
+		glog.V(4).Infof("Failed to read %q: %v", path, err)
+		return nil
+	}
+	data = bytes.TrimRight(data, "\x00")
+	if len(data) == 0 {
+		return nil
+	}
+	var args []string
+	for _, arg := range bytes.Split(data, []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+// handleContainerProcesses handles the container-processes debug endpoint. It
+// returns the processes of the running container "id", like `ps` inside the
+// container without exec.
+func (c *criContainerdService) handleContainerProcesses(w http.ResponseWriter, r *http.Request) {
+	processes, err := c.listContainerProcesses(r.Context(), r.URL.Query().Get("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch ErrorReason(err) {
+		case ReasonContainerNotFound:
+			status = http.StatusNotFound
+		case ReasonInvalidContainerState:
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, processes)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+// pidsTaskService is a fake task service listing fixed pids.
+type pidsTaskService struct {
+	tasks.TasksClient
+	pids []uint32
+}
+
+func (f *pidsTaskService) ListPids(_ context.Context, r *tasks.ListPidsRequest, _ ...grpc.CallOption) (*tasks.ListPidsResponse, error) {
+	return &tasks.ListPidsResponse{Pids: f.pids}, nil
+}
+
+func TestListContainerProcesses(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.taskService = &pidsTaskService{pids: []uint32{102, 100, 101}}
+	c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+		switch path {
+		case "/proc/100/cmdline":
+			return []byte("/bin/sh\x00-c\x00sleep 1000\x00"), nil
+		case "/proc/101/cmdline":
+			// Zombie process has empty cmdline.
+			return []byte{}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	for id, status := range map[string]containerstore.Status{
+		"running": {Pid: 100, CreatedAt: time.Now().UnixNano(), StartedAt: time.Now().UnixNano()},
+		"created": {CreatedAt: time.Now().UnixNano()},
+	} {
+		container, err := containerstore.NewContainer(containerstore.Metadata{ID: id}, status)
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+
+	processes, err := c.listContainerProcesses(context.Background(), "running")
+	require.NoError(t, err)
+	assert.Equal(t, &containerProcesses{ID: "running", Processes: []containerProcess{
+		{Pid: 100, Args: []string{"/bin/sh", "-c", "sleep 1000"}},
+		{Pid: 101},
+		{Pid: 102},
+	}}, processes)
+
+	for id, expected := range map[string]int{
+		"running":   http.StatusOK,
+		"created":   http.StatusConflict,
+		"not-exist": http.StatusNotFound,
+	} {
+		t.Logf("TestCase %q", id)
+		w := httptest.NewRecorder()
+		c.handleContainerProcesses(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/container-processes?id=%s", id), nil))
+		assert.Equal(t, expected, w.Code)
+		if expected == http.StatusOK {
+			var result containerProcesses
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+			assert.Len(t, result.Processes, 3)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleContainerRecentLogs handles the container-recent-logs debug endpoint.
+// It returns the most recent CRI formatted log lines of container "id" kept
+// in memory, which are still available after the log file is rotated or
+// lost, e.g. to triage a crashed container.
+func (c *criContainerdService) handleContainerRecentLogs(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	container, err := c.containerStore.Get(id)
+	if err != nil {
+		err = containerLookupError(id, err)
+		status := http.StatusInternalServerError
+		if ErrorReason(err) == ReasonContainerNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logs, ok := c.agentFactory.RecentLogs(container.ID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("recent logs of container %q are not kept", container.ID), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(logs) // nolint: errcheck
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	agentstesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/agents/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+// recentLogsAgentFactory is a fake agent factory keeping recent logs of
+// containers.
+type recentLogsAgentFactory struct {
+	agentstesting.FakeAgentFactory
+	logs map[string][]byte
+}
+
+func (f *recentLogsAgentFactory) RecentLogs(id string) ([]byte, bool) {
+	logs, ok := f.logs[id]
+	return logs, ok
+}
+
+func TestHandleContainerRecentLogs(t *testing.T) {
+	c := newTestCRIContainerdService()
+	logs := []byte("2017-10-15T00:00:00Z stderr panic: test\n")
+	c.agentFactory = &recentLogsAgentFactory{logs: map[string][]byte{"with-logs": logs}}
+	for _, id := range []string{"with-logs", "without-logs"} {
+		container, err := containerstore.NewContainer(containerstore.Metadata{ID: id},
+			containerstore.Status{CreatedAt: time.Now().UnixNano()})
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+	for desc, test := range map[string]struct {
+		id     string
+		status int
+		body   []byte
+	}{
+		"container with recent logs": {
+			id:     "with-logs",
+			status: http.StatusOK,
+			body:   logs,
+		},
+		"container without recent logs": {
+			id:     "without-logs",
+			status: http.StatusNotFound,
+		},
+		"container not exist": {
+			id:     "not-exist",
+			status: http.StatusNotFound,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		w := httptest.NewRecorder()
+		c.handleContainerRecentLogs(w, httptest.NewRequest(http.MethodGet, "/container-recent-logs?id="+test.id, nil))
+		assert.Equal(t, test.status, w.Code)
+		if test.body != nil {
+			assert.Equal(t, test.body, w.Body.Bytes())
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/containerd/containerd/errdefs"
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
 	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
@@ -51,7 +52,7 @@ func (c *criContainerdService) RemoveContainer(ctx context.Context, r *runtime.R
 	// Set removing state to prevent other start/remove operations against this container
 	// while it's being removed.
 	if err := setContainerRemoving(container); err != nil {
-		return nil, fmt.Errorf("failed to set removing state for container %q: %v", id, err)
+		return nil, wrapCRIError(err, "failed to set removing state for container %q", id)
 	}
 	defer func() {
 		if retErr != nil {
@@ -68,6 +69,11 @@ func (c *criContainerdService) RemoveContainer(ctx context.Context, r *runtime.R
 	// kubelet implementation, we'll never start a container once we decide to remove it,
 	// so we don't need the "Dead" state for now.
 
+	// Unmount rootfs views of the container before removing the snapshot.
+	if err := c.unmountContainerRootfsViews(id); err != nil {
+		return nil, fmt.Errorf("failed to unmount rootfs views of container %q: %v", id, err)
+	}
+
 	// Remove container snapshot.
 	if err := c.snapshotService.Remove(ctx, id); err != nil {
 		if !errdefs.IsNotFound(err) {
@@ -92,13 +98,19 @@ func (c *criContainerdService) RemoveContainer(ctx context.Context, r *runtime.R
 		if !isContainerdGRPCNotFoundError(err) {
 			return nil, fmt.Errorf("failed to delete containerd container %q: %v", id, err)
 		}
-		glog.V(5).Infof("Remove called for containerd container %q that does not exist", id, err)
+		glog.V(5).Infof("Remove called for containerd container %q that does not exist", id)
 	}
 
 	c.containerStore.Delete(id)
 
 	c.containerNameIndex.ReleaseByKey(id)
 
+	c.namespaceQuotas.releaseContainer(id)
+
+	c.verifyRemoval(removedResources{ID: id, CgroupsPath: c.getContainerCgroupsPath(container)})
+	c.checkLeaks(id)
+	c.agentFactory.RemoveRecentLogs(id)
+
 	return &runtime.RemoveContainerResponse{}, nil
 }
 
@@ -108,10 +120,10 @@ func setContainerRemoving(container containerstore.Container) error {
 	return container.Status.Update(func(status containerstore.Status) (containerstore.Status, error) {
 		// Do not remove container if it's still running.
 		if status.State() == runtime.ContainerState_CONTAINER_RUNNING {
-			return status, fmt.Errorf("container is still running")
+			return status, newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState, "container is still running")
 		}
 		if status.Removing {
-			return status, fmt.Errorf("container is already in removing state")
+			return status, newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState, "container is already in removing state")
 		}
 		status.Removing = true
 		return status, nil
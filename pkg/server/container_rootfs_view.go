@@ -0,0 +1,217 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/golang/glog"
+	imagedigest "github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultRootfsViewDuration is the default duration of a container rootfs view.
+	defaultRootfsViewDuration = 10 * time.Minute
+	// maxRootfsViewDuration is the maximum duration of a container rootfs view.
+	maxRootfsViewDuration = 24 * time.Hour
+)
+
+// rootfsView is a read-only mount of a container rootfs at a host path, e.g.
+// for vulnerability scanners and forensic tools.
+type rootfsView struct {
+	ContainerID string `json:"containerId"`
+	Path        string `json:"path"`
+	// ExpiresAt is the time the view is unmounted automatically, in nanoseconds.
+	ExpiresAt int64 `json:"expiresAt"`
+	// snapshot is the key of the view snapshot mounted at the path.
+	snapshot string
+	// mounts is the number of mounts stacked at the path.
+	mounts int
+	timer  *time.Timer
+}
+
+// rootfsViewStore keeps all container rootfs views by path.
+type rootfsViewStore struct {
+	sync.Mutex
+	views map[string]*rootfsView
+}
+
+func newRootfsViewStore() *rootfsViewStore {
+	return &rootfsViewStore{views: make(map[string]*rootfsView)}
+}
+
+// list returns all container rootfs views.
+func (s *rootfsViewStore) list() []rootfsView {
+	s.Lock()
+	defer s.Unlock()
+	var views []rootfsView
+	for _, v := range s.views {
+		views = append(views, *v)
+	}
+	return views
+}
+
+// getRootfsViewSnapshotKey returns the key of the view snapshot of a rootfs view
+// at the path.
+func getRootfsViewSnapshotKey(id, path string) string {
+	return fmt.Sprintf("rootfs-view-%s-%s", id, imagedigest.FromString(path).Hex()[:12])
+}
+
+// mountRootfsView mounts the container rootfs read-only at the host path, it is
+// unmounted automatically after the duration or before the container is removed.
+// The view is a view snapshot of the committed parent of the container snapshot,
+// so that it doesn't change under the reader and the container upper directory
+// is never mounted twice. Note that changes made by the container are not in
+// the view.
+func (c *criContainerdService) mountRootfsView(ctx context.Context, id, path string, duration time.Duration) (retView *rootfsView, retErr error) {
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("path %q is not absolute", path)
+	}
+	path = filepath.Clean(path)
+	if duration <= 0 || duration > maxRootfsViewDuration {
+		return nil, fmt.Errorf("invalid duration %v, should be in (0, %v]", duration, maxRootfsViewDuration)
+	}
+	container, err := c.containerStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find container %q: %v", id, err)
+	}
+	c.rootfsViews.Lock()
+	defer c.rootfsViews.Unlock()
+	if _, ok := c.rootfsViews.views[path]; ok {
+		return nil, fmt.Errorf("path %q is already used by another rootfs view", path)
+	}
+	info, err := c.snapshotService.Stat(ctx, container.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat rootfs snapshot of container %q: %v", container.ID, err)
+	}
+	if info.Parent == "" {
+		return nil, fmt.Errorf("rootfs snapshot of container %q has no committed parent", container.ID)
+	}
+	// The view snapshot keeps its parent from being removed until the view is
+	// unmounted.
+	key := getRootfsViewSnapshotKey(container.ID, path)
+	mounts, err := c.snapshotService.View(ctx, key, info.Parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create view snapshot of %q: %v", info.Parent, err)
+	}
+	defer func() {
+		if retErr != nil {
+			if err := c.snapshotService.Remove(ctx, key); err != nil {
+				glog.Errorf("Failed to remove view snapshot %q: %v", key, err)
+			}
+		}
+	}()
+	if err := c.os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create rootfs view directory %q: %v", path, err)
+	}
+	if err := c.os.MountAll(mounts, path); err != nil {
+		return nil, fmt.Errorf("failed to mount rootfs of container %q at %q: %v", container.ID, path, err)
+	}
+	view := &rootfsView{
+		ContainerID: container.ID,
+		Path:        path,
+		ExpiresAt:   time.Now().Add(duration).UnixNano(),
+		snapshot:    key,
+		mounts:      len(mounts),
+	}
+	view.timer = time.AfterFunc(duration, func() {
+		if err := c.unmountRootfsView(path); err != nil {
+			glog.Errorf("Failed to unmount expired rootfs view %q: %v", path, err)
+		}
+	})
+	c.rootfsViews.views[path] = view
+	glog.Infof("Mounted rootfs of container %q read-only at %q for %v", container.ID, path, duration)
+	return view, nil
+}
+
+// unmountRootfsView unmounts the rootfs view at the host path, and removes its
+// view snapshot.
+func (c *criContainerdService) unmountRootfsView(path string) error {
+	path = filepath.Clean(path)
+	c.rootfsViews.Lock()
+	defer c.rootfsViews.Unlock()
+	view, ok := c.rootfsViews.views[path]
+	if !ok {
+		return fmt.Errorf("rootfs view %q not found", path)
+	}
+	view.timer.Stop()
+	for ; view.mounts > 0; view.mounts-- {
+		if err := c.os.Unmount(path, 0); err != nil {
+			return fmt.Errorf("failed to unmount rootfs view %q: %v", path, err)
+		}
+	}
+	if err := c.snapshotService.Remove(context.Background(), view.snapshot); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove view snapshot %q: %v", view.snapshot, err)
+	}
+	delete(c.rootfsViews.views, path)
+	glog.Infof("Unmounted rootfs view of container %q at %q", view.ContainerID, path)
+	return nil
+}
+
+// unmountContainerRootfsViews unmounts all rootfs views of the container, so that
+// the container snapshot could be removed.
+func (c *criContainerdService) unmountContainerRootfsViews(id string) error {
+	for _, view := range c.rootfsViews.list() {
+		if view.ContainerID != id {
+			continue
+		}
+		if err := c.unmountRootfsView(view.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleContainerRootfsViews handles the container-rootfs-views debug endpoint.
+// GET lists all views, POST mounts a view of container "id" at "path" for
+// "duration", and DELETE unmounts the view at "path".
+func (c *criContainerdService) handleContainerRootfsViews(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, c.rootfsViews.list())
+	case http.MethodPost:
+		duration := defaultRootfsViewDuration
+		if d := query.Get("duration"); d != "" {
+			var err error
+			if duration, err = time.ParseDuration(d); err != nil {
+				http.Error(w, fmt.Sprintf("invalid duration %q: %v", d, err), http.StatusBadRequest)
+				return
+			}
+		}
+		view, err := c.mountRootfsView(r.Context(), query.Get("id"), query.Get("path"), duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, view)
+	case http.MethodDelete:
+		if err := c.unmountRootfsView(query.Get("path")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, struct{}{})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
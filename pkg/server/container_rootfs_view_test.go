@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	containerdmount "github.com/containerd/containerd/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+func TestRootfsView(t *testing.T) {
+	c := newTestCRIContainerdService()
+	snapshotter := &fakeSnapshotter{parents: map[string]string{"test-id": "test-chain-id"}}
+	c.snapshotService = snapshotter
+	fakeOS := c.os.(*ostesting.FakeOS)
+	container, err := containerstore.NewContainer(containerstore.Metadata{ID: "test-id"}, containerstore.Status{})
+	require.NoError(t, err)
+	require.NoError(t, c.containerStore.Add(container))
+
+	_, err = c.mountRootfsView(context.Background(), "test-id", "relative/path", time.Minute)
+	assert.Error(t, err, "relative path should be rejected")
+	_, err = c.mountRootfsView(context.Background(), "test-id", "/test/path", 48*time.Hour)
+	assert.Error(t, err, "too long duration should be rejected")
+	_, err = c.mountRootfsView(context.Background(), "not-exist", "/test/path", time.Minute)
+	assert.Error(t, err, "non-existing container should be rejected")
+
+	view, err := c.mountRootfsView(context.Background(), "test-id", "/test/path/", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "/test/path", view.Path)
+	assert.Len(t, c.rootfsViews.list(), 1)
+	viewKey := getRootfsViewSnapshotKey("test-id", "/test/path")
+	assert.Equal(t, map[string]string{viewKey: "test-chain-id"}, snapshotter.views,
+		"view snapshot of the committed parent should be mounted")
+	_, err = c.mountRootfsView(context.Background(), "test-id", "/test/path", time.Minute)
+	assert.Error(t, err, "path should not be reused")
+
+	require.NoError(t, c.unmountContainerRootfsViews("test-id"))
+	assert.Empty(t, c.rootfsViews.list())
+	assert.Empty(t, snapshotter.views, "view snapshot should be removed")
+	assert.Error(t, c.unmountRootfsView("/test/path"))
+	expectedCalls := []ostesting.CalledDetail{
+		{Name: "MkdirAll", Arguments: []interface{}{"/test/path", os.FileMode(0755)}},
+		{Name: "MountAll", Arguments: []interface{}{[]containerdmount.Mount{
+			{Type: "bind", Source: "test-chain-id"},
+		}, "/test/path"}},
+		{Name: "Unmount", Arguments: []interface{}{"/test/path", 0}},
+	}
+	assert.Equal(t, expectedCalls, fakeOS.GetCalls())
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
 	"github.com/kubernetes-incubator/cri-containerd/pkg/server/agents"
@@ -45,7 +46,7 @@ func (c *criContainerdService) StartContainer(ctx context.Context, r *runtime.St
 
 	container, err := c.containerStore.Get(r.GetContainerId())
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when try to find container %q: %v", r.GetContainerId(), err)
+		return nil, containerLookupError(r.GetContainerId(), err)
 	}
 	id := container.ID
 
@@ -70,12 +71,13 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 	config := meta.Config
 	// Return error if container is not in created state.
 	if status.State() != runtime.ContainerState_CONTAINER_CREATED {
-		return fmt.Errorf("container %q is in %s state", id, criContainerStateToString(status.State()))
+		return newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState, "container %q is in %s state",
+			id, criContainerStateToString(status.State()))
 	}
 
 	// Do not start the container when there is a removal in progress.
 	if status.Removing {
-		return fmt.Errorf("container %q is in removing state", id)
+		return newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState, "container %q is in removing state", id)
 	}
 
 	defer func() {
@@ -85,6 +87,9 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 			status.FinishedAt = time.Now().UnixNano()
 			status.ExitCode = errorStartExitCode
 			status.Reason = errorStartReason
+			if ErrorReason(retErr) == ReasonHookError {
+				status.Reason = ReasonHookError
+			}
 			status.Message = retErr.Error()
 		}
 	}()
@@ -92,7 +97,7 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 	// Get sandbox config from sandbox store.
 	sandbox, err := c.sandboxStore.Get(meta.SandboxID)
 	if err != nil {
-		return fmt.Errorf("sandbox %q not found: %v", meta.SandboxID, err)
+		return sandboxLookupError(meta.SandboxID, err)
 	}
 	sandboxConfig := sandbox.Config
 	sandboxID := meta.SandboxID
@@ -104,7 +109,7 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 	// This is only a best effort check, sandbox may still exit after this. If sandbox fails
 	// before starting the container, the start will fail.
 	if sandboxInfo.Task.Status != task.StatusRunning {
-		return fmt.Errorf("sandbox container %q is not running", sandboxID)
+		return newCRIError(codes.FailedPrecondition, ReasonSandboxNotReady, "sandbox container %q is not running", sandboxID)
 	}
 
 	containerRootDir := getContainerRootDir(c.rootDir, id)
@@ -137,12 +142,17 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 	if config.GetLogPath() != "" {
 		// Only generate container log when log path is specified.
 		logPath := filepath.Join(sandboxConfig.GetLogDirectory(), config.GetLogPath())
-		if err = c.agentFactory.NewContainerLogger(logPath, agents.Stdout, stdoutPipe).Start(); err != nil {
+		if c.config.ContainerLogIndexInterval > 0 {
+			if err = c.writeContainerLogMetadata(logPath, meta, sandboxConfig); err != nil {
+				return err
+			}
+		}
+		if err = c.agentFactory.NewContainerLogger(id, logPath, agents.Stdout, stdoutPipe).Start(); err != nil {
 			return fmt.Errorf("failed to start container stdout logger: %v", err)
 		}
 		// Only redirect stderr when there is no tty.
 		if !config.GetTty() {
-			if err = c.agentFactory.NewContainerLogger(logPath, agents.Stderr, stderrPipe).Start(); err != nil {
+			if err = c.agentFactory.NewContainerLogger(id, logPath, agents.Stderr, stderrPipe).Start(); err != nil {
 				return fmt.Errorf("failed to start container stderr logger: %v", err)
 			}
 		}
@@ -163,6 +173,14 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 	}
 
 	// Create containerd task.
+	handler, err := c.getRuntimeHandler(sandboxConfig.GetAnnotations())
+	if err != nil {
+		return fmt.Errorf("failed to get runtime handler: %v", err)
+	}
+	taskOpts, err := handler.taskOptions()
+	if err != nil {
+		return fmt.Errorf("failed to get task options: %v", err)
+	}
 	createOpts := &tasks.CreateTaskRequest{
 		ContainerID: id,
 		Rootfs:      rootfs,
@@ -170,29 +188,65 @@ func (c *criContainerdService) startContainer(ctx context.Context, id string, me
 		Stdout:      stdout,
 		Stderr:      stderr,
 		Terminal:    config.GetTty(),
+		Options:     taskOpts,
 	}
 	glog.V(5).Infof("Create containerd task (id=%q, name=%q) with options %+v.",
 		id, meta.Name, createOpts)
-	createResp, err := c.taskService.Create(ctx, createOpts)
+	createResp, hookWarning, err := c.createTask(ctx, createOpts)
 	if err != nil {
-		return fmt.Errorf("failed to create containerd task: %v", err)
+		return err
 	}
 	defer func() {
 		if retErr != nil {
 			// Cleanup the containerd task if an error is returned.
-			if _, err := c.taskService.Delete(ctx, &tasks.DeleteTaskRequest{ContainerID: id}); err != nil {
-				glog.Errorf("Failed to delete containerd task %q: %v", id, err)
+			if err := c.deleteTask(ctx, id, nil); err != nil {
+				glog.Errorf("Failed to delete containerd task %q, retry in background: %v", id, err)
 			}
 		}
 	}()
 
 	// Start containerd task.
-	if _, err := c.taskService.Start(ctx, &tasks.StartTaskRequest{ContainerID: id}); err != nil {
-		return fmt.Errorf("failed to start containerd task %q: %v", id, err)
+	if err := c.failpoints.eval(failpointBeforeTaskStart); err != nil {
+		return fmt.Errorf("failed before starting containerd task %q: %v", id, err)
+	}
+	startCtx, cancel := withRuntimeTimeout(ctx, c.config.TaskStartTimeout)
+	defer cancel()
+	if _, err := c.taskService.Start(startCtx, &tasks.StartTaskRequest{ContainerID: id}); err != nil {
+		_, err = c.toHookError(ctx, id, err)
+		return wrapCRIError(err, "failed to start containerd task %q", id)
 	}
 
 	// Update container start timestamp.
 	status.Pid = createResp.Pid
 	status.StartedAt = time.Now().UnixNano()
+	status.Message = hookWarning
 	return nil
 }
+
+// createTask creates the containerd task. If a prestart hook fails and hook
+// failures are treated as warnings, the task is created again without
+// prestart hooks, and the hook failure is returned as a warning.
+func (c *criContainerdService) createTask(ctx context.Context, createOpts *tasks.CreateTaskRequest) (*tasks.CreateTaskResponse, string, error) {
+	id := createOpts.ContainerID
+	createCtx, cancel := withRuntimeTimeout(ctx, c.config.TaskCreateTimeout)
+	defer cancel()
+	createResp, err := c.taskService.Create(createCtx, createOpts)
+	if err == nil {
+		return createResp, "", nil
+	}
+	hookErr, err := c.toHookError(ctx, id, err)
+	if hookErr == nil || hookErr.Stage != hookStagePrestart || !c.config.HookFailureAsWarning {
+		return nil, "", wrapCRIError(err, "failed to create containerd task")
+	}
+	glog.Warningf("Ignore %v for container %q", hookErr, id)
+	if err := c.removeContainerHooks(ctx, id, hookStagePrestart); err != nil {
+		return nil, "", fmt.Errorf("failed to remove prestart hooks: %v", err)
+	}
+	retryCtx, retryCancel := withRuntimeTimeout(ctx, c.config.TaskCreateTimeout)
+	defer retryCancel()
+	createResp, err = c.taskService.Create(retryCtx, createOpts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create containerd task without prestart hooks: %v", err)
+	}
+	return createResp, "Warning: " + hookErr.Error(), nil
+}
@@ -17,15 +17,240 @@ limitations under the License.
 package server
 
 import (
-	"errors"
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/golang/glog"
 	"golang.org/x/net/context"
-
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+// Memory metrics which could be reported as the working set bytes of
+// container stats. Kubelet versions use the working set differently in
+// eviction, so the metric is configurable.
+const (
+	// memoryMetricWorkingSet is the memory usage excluding inactive file cache.
+	memoryMetricWorkingSet = "working-set"
+	// memoryMetricRSS is the anonymous and swap cache memory.
+	memoryMetricRSS = "rss"
+	// memoryMetricUsage is the memory usage including all file cache.
+	memoryMetricUsage = "usage"
 )
 
+// cgroupRoot is the mount point of cgroup v1 hierarchies.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// memoryStats is the memory usage of a container cgroup.
+type memoryStats struct {
+	// Usage is the memory usage including file cache.
+	Usage uint64
+	// RSS is the anonymous and swap cache memory.
+	RSS uint64
+	// WorkingSet is the memory usage excluding inactive file cache.
+	WorkingSet uint64
+}
+
+// validateMemoryMetric validates the memory metric reported in container stats.
+// Empty means working set.
+func validateMemoryMetric(metric string) error {
+	switch metric {
+	case "", memoryMetricWorkingSet, memoryMetricRSS, memoryMetricUsage:
+		return nil
+	}
+	return fmt.Errorf("invalid container memory metric %q, should be one of %q, %q and %q",
+		metric, memoryMetricWorkingSet, memoryMetricRSS, memoryMetricUsage)
+}
+
+// value returns the memory metric, working set is returned by default.
+func (s *memoryStats) value(metric string) uint64 {
+	switch metric {
+	case memoryMetricRSS:
+		return s.RSS
+	case memoryMetricUsage:
+		return s.Usage
+	}
+	return s.WorkingSet
+}
+
 // ContainerStats returns stats of the container. If the container does not
 // exist, the call returns an error.
-func (c *criContainerdService) ContainerStats(ctx context.Context, in *runtime.ContainerStatsRequest) (*runtime.ContainerStatsResponse, error) {
-	return nil, errors.New("not implemented")
+func (c *criContainerdService) ContainerStats(ctx context.Context, r *runtime.ContainerStatsRequest) (retRes *runtime.ContainerStatsResponse, retErr error) {
+	glog.V(4).Infof("ContainerStats for container %q", r.GetContainerId())
+	defer func() {
+		if retErr == nil {
+			glog.V(4).Infof("ContainerStats for container %q returns stats %+v", r.GetContainerId(), retRes.GetStats())
+		}
+	}()
+
+	container, err := c.containerStore.Get(r.GetContainerId())
+	if err != nil {
+		return nil, containerLookupError(r.GetContainerId(), err)
+	}
+	stats, err := c.getContainerStats(ctx, container)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats of container %q: %v", container.ID, err)
+	}
+	return &runtime.ContainerStatsResponse{Stats: stats}, nil
+}
+
+// getContainerStats returns stats of the container. Cpu and memory stats are
+// only returned for running containers with cgroup parent.
+func (c *criContainerdService) getContainerStats(ctx context.Context, container containerstore.Container) (*runtime.ContainerStats, error) {
+	stats := &runtime.ContainerStats{
+		Attributes: &runtime.ContainerAttributes{
+			Id:          container.ID,
+			Metadata:    container.Config.GetMetadata(),
+			Labels:      container.Config.GetLabels(),
+			Annotations: container.Config.GetAnnotations(),
+		},
+	}
+
+	timestamp := time.Now().UnixNano()
+	usage, err := c.snapshotService.Usage(ctx, container.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get writable layer usage: %v", err)
+	}
+	stats.WritableLayer = &runtime.FilesystemUsage{
+		Timestamp:  timestamp,
+		UsedBytes:  &runtime.UInt64Value{Value: uint64(usage.Size)},
+		InodesUsed: &runtime.UInt64Value{Value: uint64(usage.Inodes)},
+	}
+
+	cgroupsPath, err := c.getRunningContainerCgroupsPath(container)
+	if err != nil || cgroupsPath == "" {
+		return stats, err
+	}
+
+	cpu, err := c.getCPUUsage(cgroupsPath)
+	if err != nil {
+		return nil, err
+	}
+	stats.Cpu = &runtime.CpuUsage{
+		Timestamp:            timestamp,
+		UsageCoreNanoSeconds: &runtime.UInt64Value{Value: cpu},
+	}
+	memory, err := c.getMemoryStats(cgroupsPath)
+	if err != nil {
+		return nil, err
+	}
+	stats.Memory = &runtime.MemoryUsage{
+		Timestamp:       timestamp,
+		WorkingSetBytes: &runtime.UInt64Value{Value: memory.value(c.config.ContainerMemoryMetric)},
+	}
+	return stats, nil
+}
+
+// getRunningContainerCgroupsPath returns the cgroups path of a running
+// container, or empty string if the container is not running. The cgroups
+// path of a container in the default cgroup of the runtime is discovered from
+// its init process, and is empty if the discovery fails.
+func (c *criContainerdService) getRunningContainerCgroupsPath(container containerstore.Container) (string, error) {
+	status := container.Status.Get()
+	if status.State() != runtime.ContainerState_CONTAINER_RUNNING {
+		return "", nil
+	}
+	sandbox, err := c.sandboxStore.Get(container.SandboxID)
+	if err != nil {
+		return "", sandboxLookupError(container.SandboxID, err)
+	}
+	if cgroupParent := sandbox.Config.GetLinux().GetCgroupParent(); cgroupParent != "" {
+		return getCgroupsPath(cgroupParent, container.ID), nil
+	}
+	if status.Pid == 0 {
+		return "", nil
+	}
+	paths, err := c.getProcessCgroups(status.Pid)
+	if err != nil {
+		// The container may exit after its status is read.
+		glog.V(4).Infof("Failed to discover cgroups of container %q: %v", container.ID, err)
+		return "", nil
+	}
+	return relativeCgroupsPath(paths["memory"]), nil
+}
+
+// relativeCgroupsPath returns the cgroups path relative to the root of its
+// hierarchy, given the absolute path of a cgroup v1 controller.
+func relativeCgroupsPath(path string) string {
+	rel, err := filepath.Rel(cgroupRoot, path)
+	if err != nil || path == "" {
+		return ""
+	}
+	// Strip the hierarchy directory, e.g. memory or cpu,cpuacct.
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	if len(parts) != 2 || parts[0] == ".." {
+		return ""
+	}
+	return "/" + parts[1]
+}
+
+// getCPUUsage returns the cumulative cpu time of the cgroup in nanoseconds.
+func (c *criContainerdService) getCPUUsage(cgroupsPath string) (uint64, error) {
+	return c.readCgroupValue(filepath.Join(cgroupRoot, "cpuacct", cgroupsPath, "cpuacct.usage"))
+}
+
+// getMemoryStats returns the memory usage of the cgroup.
+func (c *criContainerdService) getMemoryStats(cgroupsPath string) (*memoryStats, error) {
+	dir := filepath.Join(cgroupRoot, "memory", cgroupsPath)
+	usage, err := c.readCgroupValue(filepath.Join(dir, "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "memory.stat")
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	stat, err := parseCgroupStat(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %v", path, err)
+	}
+	stats := &memoryStats{
+		Usage: usage,
+		RSS:   stat["total_rss"],
+	}
+	if inactiveFile := stat["total_inactive_file"]; usage > inactiveFile {
+		stats.WorkingSet = usage - inactiveFile
+	}
+	return stats, nil
+}
+
+// readCgroupValue reads a cgroup file with a single integer value.
+func (c *criContainerdService) readCgroupValue(path string) (uint64, error) {
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %q: %v", path, err)
+	}
+	return value, nil
+}
+
+// parseCgroupStat parses a cgroup stat file with a "key value" pair per line.
+func parseCgroupStat(data []byte) (map[string]uint64, error) {
+	stat := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %q", scanner.Text())
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in line %q: %v", scanner.Text(), err)
+		}
+		stat[fields[0]] = value
+	}
+	return stat, scanner.Err()
 }
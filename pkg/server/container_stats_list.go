@@ -17,14 +17,48 @@ limitations under the License.
 package server
 
 import (
-	"errors"
-
+	"github.com/golang/glog"
 	"golang.org/x/net/context"
-
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
 )
 
-// ListContainerStats returns stats of all running containers.
-func (c *criContainerdService) ListContainerStats(ctx context.Context, in *runtime.ListContainerStatsRequest) (*runtime.ListContainerStatsResponse, error) {
-	return nil, errors.New("not implemented")
+// ListContainerStats returns stats of all running containers. Containers whose
+// stats can't be collected are skipped.
+func (c *criContainerdService) ListContainerStats(ctx context.Context, r *runtime.ListContainerStatsRequest) (retRes *runtime.ListContainerStatsResponse, retErr error) {
+	glog.V(4).Infof("ListContainerStats with filter %+v", r.GetFilter())
+	defer func() {
+		if retErr == nil {
+			glog.V(4).Infof("ListContainerStats returns stats %+v", retRes.GetStats())
+		}
+	}()
+
+	filter := &runtime.ContainerFilter{
+		Id:            r.GetFilter().GetId(),
+		PodSandboxId:  r.GetFilter().GetPodSandboxId(),
+		State:         &runtime.ContainerStateValue{State: runtime.ContainerState_CONTAINER_RUNNING},
+		LabelSelector: r.GetFilter().GetLabelSelector(),
+	}
+	containers := c.filterCRIContainers(c.listCRIContainers(), filter)
+	var stats []*runtime.ContainerStats
+	for _, cntr := range containers {
+		container, err := c.containerStore.Get(cntr.Id)
+		if err != nil {
+			if err == store.ErrNotExist {
+				// The container is removed after listed.
+				continue
+			}
+			return nil, containerLookupError(cntr.Id, err)
+		}
+		s, err := c.getContainerStats(ctx, container)
+		if err != nil {
+			// Stats of other containers are still returned, so that a single
+			// broken container doesn't blind the kubelet to the whole node.
+			glog.Errorf("Failed to get stats of container %q: %v", cntr.Id, err)
+			continue
+		}
+		stats = append(stats, s)
+	}
+	return &runtime.ListContainerStatsResponse{Stats: stats}, nil
 }
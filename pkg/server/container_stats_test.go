@@ -0,0 +1,238 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+const testMemoryStat = `cache 300
+rss 100
+total_cache 3000
+total_rss 1000
+total_inactive_file 2500
+`
+
+func TestParseCgroupStat(t *testing.T) {
+	stat, err := parseCgroupStat([]byte(testMemoryStat))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint64{
+		"cache":               300,
+		"rss":                 100,
+		"total_cache":         3000,
+		"total_rss":           1000,
+		"total_inactive_file": 2500,
+	}, stat)
+
+	_, err = parseCgroupStat([]byte("rss 1 2\n"))
+	assert.Error(t, err)
+	_, err = parseCgroupStat([]byte("rss -1\n"))
+	assert.Error(t, err)
+}
+
+func TestValidateMemoryMetric(t *testing.T) {
+	for _, metric := range []string{"", memoryMetricWorkingSet, memoryMetricRSS, memoryMetricUsage} {
+		assert.NoError(t, validateMemoryMetric(metric))
+	}
+	assert.Error(t, validateMemoryMetric("cache"))
+}
+
+func TestContainerStats(t *testing.T) {
+	const (
+		cgroupParent = "/kubepods/pod-1"
+		containerID  = "container-id"
+		sandboxID    = "sandbox-id"
+		testPid      = 1234
+	)
+	cgroupFiles := map[string]string{
+		"/sys/fs/cgroup/cpuacct/kubepods/pod-1/container-id/cpuacct.usage":        "123456789\n",
+		"/sys/fs/cgroup/memory/kubepods/pod-1/container-id/memory.usage_in_bytes": "4000\n",
+		"/sys/fs/cgroup/memory/kubepods/pod-1/container-id/memory.stat":           testMemoryStat,
+		"/proc/1234/cgroup": "5:cpu,cpuacct:/kubepods/pod-1/container-id\n" +
+			"4:memory:/kubepods/pod-1/container-id\n1:name=systemd:/system.slice\n",
+	}
+	for desc, test := range map[string]struct {
+		metric       string
+		state        runtime.ContainerState
+		cgroupParent string
+		pid          uint32
+		expectCPU    uint64
+		expectMemory uint64
+		expectNoCPU  bool
+	}{
+		"working set should exclude inactive file": {
+			metric:       memoryMetricWorkingSet,
+			state:        runtime.ContainerState_CONTAINER_RUNNING,
+			cgroupParent: cgroupParent,
+			expectCPU:    123456789,
+			expectMemory: 1500,
+		},
+		"working set should be reported by default": {
+			state:        runtime.ContainerState_CONTAINER_RUNNING,
+			cgroupParent: cgroupParent,
+			expectCPU:    123456789,
+			expectMemory: 1500,
+		},
+		"rss should be reported": {
+			metric:       memoryMetricRSS,
+			state:        runtime.ContainerState_CONTAINER_RUNNING,
+			cgroupParent: cgroupParent,
+			expectCPU:    123456789,
+			expectMemory: 1000,
+		},
+		"usage should be reported": {
+			metric:       memoryMetricUsage,
+			state:        runtime.ContainerState_CONTAINER_RUNNING,
+			cgroupParent: cgroupParent,
+			expectCPU:    123456789,
+			expectMemory: 4000,
+		},
+		"exited container should not have cpu and memory stats": {
+			state:        runtime.ContainerState_CONTAINER_EXITED,
+			cgroupParent: cgroupParent,
+			expectNoCPU:  true,
+		},
+		"container without cgroup parent should use cgroups of its init process": {
+			state:        runtime.ContainerState_CONTAINER_RUNNING,
+			pid:          testPid,
+			expectCPU:    123456789,
+			expectMemory: 1500,
+		},
+		"container without cgroup parent should not have cpu and memory stats if discovery fails": {
+			state:       runtime.ContainerState_CONTAINER_RUNNING,
+			pid:         testPid + 1,
+			expectNoCPU: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.snapshotService = &fakeSnapshotter{}
+		c.config.ContainerMemoryMetric = test.metric
+		c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+			content, ok := cgroupFiles[path]
+			if !ok {
+				return nil, fmt.Errorf("%q not found", path)
+			}
+			return []byte(content), nil
+		}
+		require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+			ID: sandboxID,
+			Config: &runtime.PodSandboxConfig{
+				Linux: &runtime.LinuxPodSandboxConfig{CgroupParent: test.cgroupParent},
+			},
+		}}))
+		status := containerstore.Status{Pid: test.pid, CreatedAt: 1, StartedAt: 2}
+		if test.state == runtime.ContainerState_CONTAINER_EXITED {
+			status.FinishedAt = 3
+		}
+		container, err := containerstore.NewContainer(containerstore.Metadata{
+			ID:        containerID,
+			SandboxID: sandboxID,
+			Config: &runtime.ContainerConfig{
+				Metadata: &runtime.ContainerMetadata{Name: "test-name"},
+				Labels:   map[string]string{"a": "b"},
+			},
+		}, status)
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+
+		resp, err := c.ContainerStats(context.Background(), &runtime.ContainerStatsRequest{ContainerId: containerID})
+		require.NoError(t, err)
+		stats := resp.GetStats()
+		assert.Equal(t, containerID, stats.GetAttributes().GetId())
+		assert.Equal(t, map[string]string{"a": "b"}, stats.GetAttributes().GetLabels())
+		assert.NotNil(t, stats.GetWritableLayer())
+		if test.expectNoCPU {
+			assert.Nil(t, stats.GetCpu())
+			assert.Nil(t, stats.GetMemory())
+		} else {
+			assert.Equal(t, test.expectCPU, stats.GetCpu().GetUsageCoreNanoSeconds().GetValue())
+			assert.Equal(t, test.expectMemory, stats.GetMemory().GetWorkingSetBytes().GetValue())
+		}
+
+		listResp, err := c.ListContainerStats(context.Background(), &runtime.ListContainerStatsRequest{})
+		require.NoError(t, err)
+		if test.state == runtime.ContainerState_CONTAINER_RUNNING {
+			require.Len(t, listResp.GetStats(), 1)
+			listStats := listResp.GetStats()[0]
+			assert.Equal(t, stats.GetAttributes(), listStats.GetAttributes())
+			assert.Equal(t, stats.GetMemory().GetWorkingSetBytes(), listStats.GetMemory().GetWorkingSetBytes())
+		} else {
+			assert.Empty(t, listResp.GetStats())
+		}
+	}
+}
+
+func TestListContainerStatsSkipsFailedContainers(t *testing.T) {
+	const sandboxID = "sandbox-id"
+	c := newTestCRIContainerdService()
+	c.snapshotService = &fakeSnapshotter{}
+	cgroupFiles := map[string]string{
+		"/sys/fs/cgroup/cpuacct/kubepods/pod-1/container-1/cpuacct.usage":        "123456789\n",
+		"/sys/fs/cgroup/memory/kubepods/pod-1/container-1/memory.usage_in_bytes": "4000\n",
+		"/sys/fs/cgroup/memory/kubepods/pod-1/container-1/memory.stat":           testMemoryStat,
+	}
+	c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+		content, ok := cgroupFiles[path]
+		if !ok {
+			return nil, fmt.Errorf("%q not found", path)
+		}
+		return []byte(content), nil
+	}
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID: sandboxID,
+		Config: &runtime.PodSandboxConfig{
+			Linux: &runtime.LinuxPodSandboxConfig{CgroupParent: "/kubepods/pod-1"},
+		},
+	}}))
+	for _, id := range []string{"container-1", "container-2"} {
+		container, err := containerstore.NewContainer(containerstore.Metadata{
+			ID:        id,
+			SandboxID: sandboxID,
+			Config:    &runtime.ContainerConfig{Metadata: &runtime.ContainerMetadata{Name: id}},
+		}, containerstore.Status{CreatedAt: 1, StartedAt: 2})
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+
+	resp, err := c.ListContainerStats(context.Background(), &runtime.ListContainerStatsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetStats(), 1)
+	assert.Equal(t, "container-1", resp.GetStats()[0].GetAttributes().GetId())
+}
+
+func TestRelativeCgroupsPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/sys/fs/cgroup/memory/k8s.io/test-id":      "/k8s.io/test-id",
+		"/sys/fs/cgroup/cpu,cpuacct/k8s.io/test-id": "/k8s.io/test-id",
+		"/sys/fs/cgroup/memory":                     "",
+		"/other/memory/k8s.io/test-id":              "",
+		"":                                          "",
+	} {
+		assert.Equal(t, expected, relativeCgroupsPath(path), path)
+	}
+}
@@ -17,8 +17,6 @@ limitations under the License.
 package server
 
 import (
-	"fmt"
-
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
@@ -37,7 +35,7 @@ func (c *criContainerdService) ContainerStatus(ctx context.Context, r *runtime.C
 
 	container, err := c.containerStore.Get(r.GetContainerId())
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when try to find container %q: %v", r.GetContainerId(), err)
+		return nil, containerLookupError(r.GetContainerId(), err)
 	}
 
 	return &runtime.ContainerStatusResponse{
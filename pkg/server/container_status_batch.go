@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// containerStatuses is the result of a batch container status request.
+type containerStatuses struct {
+	Statuses []*runtime.ContainerStatus `json:"statuses"`
+	// NotFound are ids of requested containers which are not found.
+	NotFound []string `json:"notFound,omitempty"`
+}
+
+// getContainerStatuses returns statuses of containers with the ids, or of all
+// containers in the sandbox if sandboxID is not empty, or of all containers if
+// neither is specified. Statuses are assembled from the in-memory store only.
+func (c *criContainerdService) getContainerStatuses(ids []string, sandboxID string) containerStatuses {
+	result := containerStatuses{Statuses: []*runtime.ContainerStatus{}}
+	if len(ids) > 0 {
+		for _, id := range ids {
+			container, err := c.containerStore.Get(id)
+			if err != nil {
+				result.NotFound = append(result.NotFound, id)
+				continue
+			}
+			result.Statuses = append(result.Statuses, toCRIContainerStatus(container))
+		}
+		return result
+	}
+	for _, container := range c.containerStore.List() {
+		if sandboxID != "" && container.SandboxID != sandboxID {
+			continue
+		}
+		result.Statuses = append(result.Statuses, toCRIContainerStatus(container))
+	}
+	return result
+}
+
+// handleContainerStatuses handles the container-statuses debug endpoint. It
+// returns statuses of containers with the "id"s, or of all containers in the
+// "sandbox", in one request.
+func (c *criContainerdService) handleContainerStatuses(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	writeJSON(w, c.getContainerStatuses(query["id"], query.Get("sandbox")))
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+func TestGetContainerStatuses(t *testing.T) {
+	c := newTestCRIContainerdService()
+	for _, meta := range []containerstore.Metadata{
+		{ID: "container-1", SandboxID: "sandbox-1", Config: &runtime.ContainerConfig{}},
+		{ID: "container-2", SandboxID: "sandbox-1", Config: &runtime.ContainerConfig{}},
+		{ID: "container-3", SandboxID: "sandbox-2", Config: &runtime.ContainerConfig{}},
+	} {
+		container, err := containerstore.NewContainer(meta, containerstore.Status{})
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+	for desc, test := range map[string]struct {
+		ids              []string
+		sandboxID        string
+		expectedIDs      []string
+		expectedNotFound []string
+	}{
+		"containers with ids": {
+			ids:              []string{"container-1", "container-3", "not-exist"},
+			expectedIDs:      []string{"container-1", "container-3"},
+			expectedNotFound: []string{"not-exist"},
+		},
+		"containers in sandbox": {
+			sandboxID:   "sandbox-1",
+			expectedIDs: []string{"container-1", "container-2"},
+		},
+		"all containers": {
+			expectedIDs: []string{"container-1", "container-2", "container-3"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		result := c.getContainerStatuses(test.ids, test.sandboxID)
+		var ids []string
+		for _, status := range result.Statuses {
+			ids = append(ids, status.Id)
+		}
+		assert.Len(t, ids, len(test.expectedIDs))
+		for _, id := range test.expectedIDs {
+			assert.Contains(t, ids, id)
+		}
+		assert.Equal(t, test.expectedNotFound, result.NotFound)
+	}
+}
@@ -35,10 +35,6 @@ const (
 	// stopCheckPollInterval is the the interval to check whether a container
 	// is stopped successfully.
 	stopCheckPollInterval = 100 * time.Millisecond
-
-	// killContainerTimeout is the timeout that we wait for the container to
-	// be SIGKILLed.
-	killContainerTimeout = 2 * time.Minute
 )
 
 // StopContainer stops a running container with a grace period (i.e., timeout).
@@ -53,7 +49,7 @@ func (c *criContainerdService) StopContainer(ctx context.Context, r *runtime.Sto
 	// Get container config from container store.
 	container, err := c.containerStore.Get(r.GetContainerId())
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when try to find container %q: %v", r.GetContainerId(), err)
+		return nil, containerLookupError(r.GetContainerId(), err)
 	}
 
 	if err := c.stopContainer(ctx, container, time.Duration(r.GetTimeout())*time.Second); err != nil {
@@ -127,8 +123,9 @@ func (c *criContainerdService) stopContainer(ctx context.Context, container cont
 		// Move on to make sure container status is updated.
 	}
 
-	// Wait for a fixed timeout until container stop is observed by event monitor.
-	if err := c.waitContainerStop(ctx, id, killContainerTimeout); err != nil {
+	// Wait for the task stop timeout until container stop is observed by event
+	// monitor.
+	if err := c.waitContainerStop(ctx, id, c.config.TaskStopTimeout); err != nil {
 		return fmt.Errorf("an error occurs during waiting for container %q to stop: %v", id, err)
 	}
 	return nil
@@ -0,0 +1,216 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/content"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// newContainerdClients creates size containerd clients, each with its own
+// connection to containerd.
+func newContainerdClients(endpoint string, size int) ([]*containerd.Client, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("invalid containerd connection pool size %d", size)
+	}
+	var clients []*containerd.Client
+	for i := 0; i < size; i++ {
+		client, err := containerd.New(endpoint, containerd.WithDefaultNamespace(k8sContainerdNamespace))
+		if err != nil {
+			for _, c := range clients {
+				c.Close() // nolint: errcheck
+			}
+			return nil, fmt.Errorf("failed to initialize containerd client with endpoint %q: %v", endpoint, err)
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// roundRobin picks indexes of a pool in round robin.
+type roundRobin struct {
+	size uint32
+	next uint32
+}
+
+// pick returns the next index.
+func (r *roundRobin) pick() int {
+	return int((atomic.AddUint32(&r.next, 1) - 1) % r.size)
+}
+
+// taskServicePool distributes containerd task requests across a pool of clients,
+// because a single connection becomes a throughput bottleneck when many pods are
+// created at the same time.
+type taskServicePool struct {
+	roundRobin
+	services []tasks.TasksClient
+}
+
+// newTaskServicePool returns a task service distributing requests across clients.
+// The task service of the only client is returned directly if there is only one.
+func newTaskServicePool(clients []*containerd.Client) tasks.TasksClient {
+	if len(clients) == 1 {
+		return clients[0].TaskService()
+	}
+	p := &taskServicePool{roundRobin: roundRobin{size: uint32(len(clients))}}
+	for _, c := range clients {
+		p.services = append(p.services, c.TaskService())
+	}
+	return p
+}
+
+var _ tasks.TasksClient = &taskServicePool{}
+
+func (p *taskServicePool) get() tasks.TasksClient {
+	return p.services[p.pick()]
+}
+
+func (p *taskServicePool) Create(ctx context.Context, in *tasks.CreateTaskRequest, opts ...grpc.CallOption) (*tasks.CreateTaskResponse, error) {
+	return p.get().Create(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Start(ctx context.Context, in *tasks.StartTaskRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().Start(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Delete(ctx context.Context, in *tasks.DeleteTaskRequest, opts ...grpc.CallOption) (*tasks.DeleteResponse, error) {
+	return p.get().Delete(ctx, in, opts...)
+}
+
+func (p *taskServicePool) DeleteProcess(ctx context.Context, in *tasks.DeleteProcessRequest, opts ...grpc.CallOption) (*tasks.DeleteResponse, error) {
+	return p.get().DeleteProcess(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Get(ctx context.Context, in *tasks.GetTaskRequest, opts ...grpc.CallOption) (*tasks.GetTaskResponse, error) {
+	return p.get().Get(ctx, in, opts...)
+}
+
+func (p *taskServicePool) List(ctx context.Context, in *tasks.ListTasksRequest, opts ...grpc.CallOption) (*tasks.ListTasksResponse, error) {
+	return p.get().List(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Kill(ctx context.Context, in *tasks.KillRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().Kill(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Exec(ctx context.Context, in *tasks.ExecProcessRequest, opts ...grpc.CallOption) (*tasks.ExecProcessResponse, error) {
+	return p.get().Exec(ctx, in, opts...)
+}
+
+func (p *taskServicePool) ResizePty(ctx context.Context, in *tasks.ResizePtyRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().ResizePty(ctx, in, opts...)
+}
+
+func (p *taskServicePool) CloseIO(ctx context.Context, in *tasks.CloseIORequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().CloseIO(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Pause(ctx context.Context, in *tasks.PauseTaskRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().Pause(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Resume(ctx context.Context, in *tasks.ResumeTaskRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().Resume(ctx, in, opts...)
+}
+
+func (p *taskServicePool) ListPids(ctx context.Context, in *tasks.ListPidsRequest, opts ...grpc.CallOption) (*tasks.ListPidsResponse, error) {
+	return p.get().ListPids(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Checkpoint(ctx context.Context, in *tasks.CheckpointTaskRequest, opts ...grpc.CallOption) (*tasks.CheckpointTaskResponse, error) {
+	return p.get().Checkpoint(ctx, in, opts...)
+}
+
+func (p *taskServicePool) Update(ctx context.Context, in *tasks.UpdateTaskRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return p.get().Update(ctx, in, opts...)
+}
+
+// contentStorePool distributes containerd content requests across a pool of
+// clients. A writer sticks to the connection it is created on.
+type contentStorePool struct {
+	roundRobin
+	stores []content.Store
+}
+
+// newContentStorePool returns a content store distributing requests across clients.
+// The content store of the only client is returned directly if there is only one.
+func newContentStorePool(clients []*containerd.Client) content.Store {
+	if len(clients) == 1 {
+		return clients[0].ContentStore()
+	}
+	p := &contentStorePool{roundRobin: roundRobin{size: uint32(len(clients))}}
+	for _, c := range clients {
+		p.stores = append(p.stores, c.ContentStore())
+	}
+	return p
+}
+
+var _ content.Store = &contentStorePool{}
+
+func (p *contentStorePool) get() content.Store {
+	return p.stores[p.pick()]
+}
+
+func (p *contentStorePool) Info(ctx gocontext.Context, dgst digest.Digest) (content.Info, error) {
+	return p.get().Info(ctx, dgst)
+}
+
+func (p *contentStorePool) Update(ctx gocontext.Context, info content.Info, fieldpaths ...string) (content.Info, error) {
+	return p.get().Update(ctx, info, fieldpaths...)
+}
+
+func (p *contentStorePool) Walk(ctx gocontext.Context, fn content.WalkFunc, filters ...string) error {
+	return p.get().Walk(ctx, fn, filters...)
+}
+
+func (p *contentStorePool) Delete(ctx gocontext.Context, dgst digest.Digest) error {
+	return p.get().Delete(ctx, dgst)
+}
+
+func (p *contentStorePool) Reader(ctx gocontext.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	return p.get().Reader(ctx, dgst)
+}
+
+func (p *contentStorePool) ReaderAt(ctx gocontext.Context, dgst digest.Digest) (io.ReaderAt, error) {
+	return p.get().ReaderAt(ctx, dgst)
+}
+
+func (p *contentStorePool) Writer(ctx gocontext.Context, ref string, size int64, expected digest.Digest) (content.Writer, error) {
+	return p.get().Writer(ctx, ref, size, expected)
+}
+
+func (p *contentStorePool) Status(ctx gocontext.Context, ref string) (content.Status, error) {
+	return p.get().Status(ctx, ref)
+}
+
+func (p *contentStorePool) ListStatuses(ctx gocontext.Context, filters ...string) ([]content.Status, error) {
+	return p.get().ListStatuses(ctx, filters...)
+}
+
+func (p *contentStorePool) Abort(ctx gocontext.Context, ref string) error {
+	return p.get().Abort(ctx, ref)
+}
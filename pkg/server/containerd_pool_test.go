@@ -0,0 +1,79 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// countingTaskService is a task service counting Get requests.
+type countingTaskService struct {
+	tasks.TasksClient
+	mu   sync.Mutex
+	gets int
+}
+
+func (c *countingTaskService) Get(context.Context, *tasks.GetTaskRequest, ...grpc.CallOption) (*tasks.GetTaskResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets++
+	return &tasks.GetTaskResponse{}, nil
+}
+
+func TestRoundRobin(t *testing.T) {
+	r := &roundRobin{size: 3}
+	var picked []int
+	for i := 0; i < 7; i++ {
+		picked = append(picked, r.pick())
+	}
+	assert.Equal(t, []int{0, 1, 2, 0, 1, 2, 0}, picked)
+}
+
+func TestTaskServicePool(t *testing.T) {
+	const size, requests = 3, 30
+	p := &taskServicePool{roundRobin: roundRobin{size: size}}
+	var services []*countingTaskService
+	for i := 0; i < size; i++ {
+		s := &countingTaskService{}
+		services = append(services, s)
+		p.services = append(p.services, s)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Get(context.Background(), &tasks.GetTaskRequest{})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	for _, s := range services {
+		assert.Equal(t, requests/size, s.gets)
+	}
+}
+
+func TestNewContainerdClientsInvalidSize(t *testing.T) {
+	_, err := newContainerdClients("/invalid/containerd.sock", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+const (
+	// corePatternPath is the path of the kernel core pattern.
+	corePatternPath = "/proc/sys/kernel/core_pattern"
+	// coreDumpDir is the directory in the pod log directory core dumps are
+	// written into.
+	coreDumpDir = "cores"
+	// savedCorePatternFile is the file in the root directory keeping the core
+	// pattern before cri-containerd changes it, so that it is restored even
+	// after cri-containerd crashes.
+	savedCorePatternFile = "core_pattern"
+)
+
+// unsafeFileNameChars matches characters not allowed in core dump file names.
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// coreDumpResult is the result of capturing a core dump.
+type coreDumpResult struct {
+	// ContainerID is the id of the container of the crashed process.
+	ContainerID string `json:"containerID"`
+	// Path is the path of the core dump file.
+	Path string `json:"path"`
+	// Size is the size of the core dump file.
+	Size int64 `json:"size"`
+	// Truncated means the core dump exceeds the size limit, and only the
+	// beginning is kept.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// getCorePattern returns the kernel core pattern piping core dumps into the
+// core-dump command of the cri-containerd binary, which sends them to the debug
+// socket. %P is the host pid, %s is the signal and %e is the executable name.
+func getCorePattern(exe, debugSocketPath string) string {
+	return fmt.Sprintf("|%s core-dump --debug-socket-path=%s %%P %%s %%e", exe, debugSocketPath)
+}
+
+// isCriContainerdCorePattern returns whether the core pattern is set by
+// cri-containerd.
+func isCriContainerdCorePattern(pattern string) bool {
+	return strings.HasPrefix(pattern, "|") && strings.Contains(pattern, " core-dump --debug-socket-path=")
+}
+
+// setCorePattern routes core dumps on the node into cri-containerd. The
+// previous core pattern is saved, and restored by restoreCorePattern. If the
+// current pattern is already set by cri-containerd, e.g. after a crash, the
+// pattern saved before is kept.
+func (c *criContainerdService) setCorePattern() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %v", err)
+	}
+	current, err := c.os.ReadFile(corePatternPath)
+	if err != nil {
+		return fmt.Errorf("failed to read core pattern: %v", err)
+	}
+	savedPath := filepath.Join(c.rootDir, savedCorePatternFile)
+	if !isCriContainerdCorePattern(strings.TrimSpace(string(current))) {
+		if err := c.os.AtomicWriteFile(savedPath, current, 0644); err != nil {
+			return fmt.Errorf("failed to save core pattern %q: %v", current, err)
+		}
+	}
+	pattern := getCorePattern(exe, c.config.DebugSocketPath)
+	if err := c.os.WriteFile(corePatternPath, []byte(pattern), 0644); err != nil {
+		return fmt.Errorf("failed to set core pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// restoreCorePattern restores the core pattern saved by setCorePattern.
+func (c *criContainerdService) restoreCorePattern() error {
+	savedPath := filepath.Join(c.rootDir, savedCorePatternFile)
+	saved, err := c.os.ReadFile(savedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read saved core pattern: %v", err)
+	}
+	if err := c.os.WriteFile(corePatternPath, saved, 0644); err != nil {
+		return fmt.Errorf("failed to restore core pattern %q: %v", saved, err)
+	}
+	if err := c.os.RemoveAll(savedPath); err != nil {
+		return fmt.Errorf("failed to remove saved core pattern: %v", err)
+	}
+	return nil
+}
+
+// getCoreDumpUsage returns the total size of core dumps in the pod log
+// directories of all sandboxes.
+func (c *criContainerdService) getCoreDumpUsage() int64 {
+	var total int64
+	for _, sandbox := range c.sandboxStore.List() {
+		logDir := sandbox.Config.GetLogDirectory()
+		if logDir == "" {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(logDir, coreDumpDir))
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.Mode().IsRegular() {
+				total += f.Size()
+			}
+		}
+	}
+	return total
+}
+
+// reserveCoreDump reserves the size limit of a core dump within the total
+// limit, and returns the reserved size. Core dumps being captured are counted
+// with both their reserved size and the size already written, which may only
+// make the limit of a concurrent core dump smaller than necessary.
+func (c *criContainerdService) reserveCoreDump() (int64, error) {
+	limit := c.config.CoreDumpSizeLimit
+	if c.config.CoreDumpTotalLimit <= 0 {
+		return limit, nil
+	}
+	// Walk core dump directories without the lock, so that a slow walk
+	// doesn't block other core dumps.
+	usage := c.getCoreDumpUsage()
+	c.coreDumpLock.Lock()
+	defer c.coreDumpLock.Unlock()
+	if remaining := c.config.CoreDumpTotalLimit - usage - c.coreDumpReserved; remaining < limit {
+		limit = remaining
+	}
+	if limit <= 0 {
+		return 0, fmt.Errorf("total core dump size limit %d is reached", c.config.CoreDumpTotalLimit)
+	}
+	c.coreDumpReserved += limit
+	return limit, nil
+}
+
+// releaseCoreDump releases the size reserved by reserveCoreDump.
+func (c *criContainerdService) releaseCoreDump(limit int64) {
+	if c.config.CoreDumpTotalLimit <= 0 {
+		return
+	}
+	c.coreDumpLock.Lock()
+	defer c.coreDumpLock.Unlock()
+	c.coreDumpReserved -= limit
+}
+
+// getProcessContainer returns the container of the host process, which is
+// found by the container id in the cgroup path of the process.
+func (c *criContainerdService) getProcessContainer(pid uint32) (containerstore.Container, error) {
+	paths, err := c.getProcessCgroups(pid)
+	if err != nil {
+		return containerstore.Container{}, err
+	}
+	for _, path := range paths {
+		if container, err := c.containerStore.Get(filepath.Base(path)); err == nil {
+			return container, nil
+		}
+	}
+	return containerstore.Container{}, fmt.Errorf("process %d is not in any container", pid)
+}
+
+// captureCoreDump writes the core dump of the crashed process into the pod log
+// directory of its container, keeping at most CoreDumpSizeLimit bytes, and at
+// most CoreDumpTotalLimit bytes of core dumps on the node.
+func (c *criContainerdService) captureCoreDump(pid uint32, signal, exe string, core io.Reader) (*coreDumpResult, error) {
+	limit, err := c.reserveCoreDump()
+	if err != nil {
+		io.Copy(ioutil.Discard, core) // nolint: errcheck
+		return nil, err
+	}
+	defer c.releaseCoreDump(limit)
+	container, err := c.getProcessContainer(pid)
+	if err != nil {
+		return nil, err
+	}
+	sandbox, err := c.sandboxStore.Get(container.SandboxID)
+	if err != nil {
+		return nil, sandboxLookupError(container.SandboxID, err)
+	}
+	logDir := sandbox.Config.GetLogDirectory()
+	if logDir == "" {
+		return nil, fmt.Errorf("sandbox %q has no log directory", sandbox.ID)
+	}
+	dir := filepath.Join(logDir, coreDumpDir)
+	if err := c.os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create core dump directory %q: %v", dir, err)
+	}
+	name := fmt.Sprintf("%s_%d_%s_%d_%s.core", container.Config.GetMetadata().GetName(),
+		container.Config.GetMetadata().GetAttempt(), exe, pid, time.Now().Format("20060102T150405"))
+	path := filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(name, "_"))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create core dump file %q: %v", path, err)
+	}
+	defer f.Close()
+	size, err := io.Copy(f, io.LimitReader(core, limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write core dump file %q: %v", path, err)
+	}
+	// Drain the rest, so that the kernel finishes dumping.
+	rest, _ := io.Copy(ioutil.Discard, core)
+	result := &coreDumpResult{ContainerID: container.ID, Path: path, Size: size, Truncated: rest > 0}
+	glog.Warningf("Process %d (%s) of container %q crashed with signal %s, core dumped to %q: %+v",
+		pid, exe, container.ID, signal, path, result)
+	return result, nil
+}
+
+// handleCoreDumps handles the core-dumps debug endpoint. The core dump of
+// process "pid" crashed with "signal" is posted as the body by the core-dump
+// command, which the kernel core pattern pipes core dumps into.
+func (c *criContainerdService) handleCoreDumps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pid, err := strconv.ParseUint(query.Get("pid"), 10, 32)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid pid %q: %v", query.Get("pid"), err), http.StatusBadRequest)
+		return
+	}
+	result, err := c.captureCoreDump(uint32(pid), query.Get("signal"), query.Get("exe"), r.Body)
+	if err != nil {
+		glog.V(2).Infof("Core dump of process %d is not captured: %v", pid, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestGetCorePattern(t *testing.T) {
+	assert.Equal(t, "|/usr/local/bin/cri-containerd core-dump --debug-socket-path=/var/run/debug.sock %P %s %e",
+		getCorePattern("/usr/local/bin/cri-containerd", "/var/run/debug.sock"))
+}
+
+func TestSetAndRestoreCorePattern(t *testing.T) {
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	pattern := getCorePattern(exe, "/var/run/debug.sock")
+	for desc, test := range map[string]struct {
+		current         string
+		saved           string
+		expectedRestore string
+	}{
+		"core pattern is saved and restored": {
+			current:         "core\n",
+			expectedRestore: "core\n",
+		},
+		"core pattern left by a crashed cri-containerd is not saved": {
+			current:         pattern,
+			saved:           "|/usr/lib/systemd/systemd-coredump %P\n",
+			expectedRestore: "|/usr/lib/systemd/systemd-coredump %P\n",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.DebugSocketPath = "/var/run/debug.sock"
+		files := map[string]string{corePatternPath: test.current}
+		savedPath := filepath.Join(c.rootDir, savedCorePatternFile)
+		if test.saved != "" {
+			files[savedPath] = test.saved
+		}
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+			data, ok := files[path]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return []byte(data), nil
+		}
+		write := func(path string, data []byte, _ os.FileMode) error {
+			files[path] = string(data)
+			return nil
+		}
+		fakeOS.WriteFileFn = write
+		fakeOS.AtomicWriteFileFn = write
+		fakeOS.RemoveAllFn = func(path string) error {
+			delete(files, path)
+			return nil
+		}
+
+		require.NoError(t, c.setCorePattern())
+		assert.Equal(t, pattern, files[corePatternPath])
+		require.NoError(t, c.restoreCorePattern())
+		assert.Equal(t, test.expectedRestore, files[corePatternPath])
+		assert.NotContains(t, files, savedPath)
+		t.Logf("restore should do nothing without saved core pattern")
+		require.NoError(t, c.restoreCorePattern())
+		assert.Equal(t, test.expectedRestore, files[corePatternPath])
+	}
+}
+
+func TestCaptureCoreDump(t *testing.T) {
+	logDir, err := ioutil.TempDir("", "core-dump")
+	require.NoError(t, err)
+	defer os.RemoveAll(logDir)
+	coreDir := filepath.Join(logDir, coreDumpDir)
+	require.NoError(t, os.MkdirAll(coreDir, 0755))
+
+	c := newTestCRIContainerdService()
+	c.config.CoreDumpSizeLimit = 10
+	c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+		switch path {
+		case "/proc/100/cgroup":
+			return []byte("4:memory:/kubepods/pod-1/test-id\n"), nil
+		case "/proc/200/cgroup":
+			return []byte("4:memory:/system.slice/sshd.service\n"), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID:     "test-sandbox-id",
+		Config: &runtime.PodSandboxConfig{LogDirectory: logDir},
+	}}))
+	container, err := containerstore.NewContainer(containerstore.Metadata{
+		ID:        "test-id",
+		SandboxID: "test-sandbox-id",
+		Config:    &runtime.ContainerConfig{Metadata: &runtime.ContainerMetadata{Name: "test-name", Attempt: 1}},
+	}, containerstore.Status{})
+	require.NoError(t, err)
+	require.NoError(t, c.containerStore.Add(container))
+
+	for desc, test := range map[string]struct {
+		pid        uint32
+		core       string
+		totalLimit int64
+		existing   int
+		expected   string
+		truncated  bool
+		expectErr  bool
+	}{
+		"core dump within size limit": {
+			pid:      100,
+			core:     "core",
+			expected: "core",
+		},
+		"core dump exceeding size limit": {
+			pid:       100,
+			core:      "core dump exceeding size limit",
+			expected:  "core dump ",
+			truncated: true,
+		},
+		"core dump exceeding total size limit": {
+			pid:        100,
+			core:       "core dump",
+			totalLimit: 8,
+			existing:   4,
+			expected:   "core",
+			truncated:  true,
+		},
+		"total size limit reached": {
+			pid:        100,
+			core:       "core",
+			totalLimit: 8,
+			existing:   8,
+			expectErr:  true,
+		},
+		"process not in any container": {
+			pid:       200,
+			core:      "core",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c.config.CoreDumpTotalLimit = test.totalLimit
+		existing := filepath.Join(coreDir, "existing.core")
+		require.NoError(t, ioutil.WriteFile(existing, make([]byte, test.existing), 0640))
+		result, err := c.captureCoreDump(test.pid, "11", "my app", strings.NewReader(test.core))
+		require.NoError(t, os.Remove(existing))
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, "test-id", result.ContainerID)
+		assert.Equal(t, coreDir, filepath.Dir(result.Path))
+		assert.True(t, strings.HasPrefix(filepath.Base(result.Path), "test-name_1_my_app_100_"), result.Path)
+		assert.Equal(t, test.truncated, result.Truncated)
+		assert.EqualValues(t, len(test.expected), result.Size)
+		data, err := ioutil.ReadFile(result.Path)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, string(data))
+		require.NoError(t, os.Remove(result.Path))
+	}
+
+	w := httptest.NewRecorder()
+	c.handleCoreDumps(w, httptest.NewRequest(http.MethodPost, "/core-dumps?pid=abc", strings.NewReader("core")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestReserveCoreDump(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.config.CoreDumpSizeLimit = 10
+	c.config.CoreDumpTotalLimit = 25
+
+	t.Logf("concurrent core dumps should share the total limit")
+	first, err := c.reserveCoreDump()
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, first)
+	second, err := c.reserveCoreDump()
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, second)
+	third, err := c.reserveCoreDump()
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, third)
+	_, err = c.reserveCoreDump()
+	assert.Error(t, err)
+
+	t.Logf("released size should be available again")
+	c.releaseCoreDump(first)
+	fourth, err := c.reserveCoreDump()
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, fourth)
+	for _, limit := range []int64{second, third, fourth} {
+		c.releaseCoreDump(limit)
+	}
+	assert.Zero(t, c.coreDumpReserved)
+}
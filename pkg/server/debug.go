@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/golang/glog"
+)
+
+// DebugHandler returns the http handler serving debug and administrative endpoints.
+// All endpoints except metrics and container export return json encoded results.
+func (c *criContainerdService) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/shutdown-pods", postOnly(c.handleShutdownPods))
+	mux.HandleFunc("/log-level", c.handleLogLevel)
+	mux.HandleFunc("/rpc-log", postOnly(c.handleRPCLog))
+	mux.HandleFunc("/sandbox-network", c.handleSandboxNetwork)
+	mux.HandleFunc("/sandbox-network-stats", c.handleSandboxNetworkStats)
+	mux.HandleFunc("/image-pulls", c.handleImagePulls)
+	mux.HandleFunc("/image-pull-events", c.handleImagePullEvents)
+	mux.HandleFunc("/image-usage", c.handleImageUsage)
+	mux.HandleFunc("/snapshotter", c.handleSnapshotter)
+	mux.HandleFunc("/container-rootfs-views", c.handleContainerRootfsViews)
+	mux.HandleFunc("/container-export", c.handleContainerExport)
+	mux.HandleFunc("/container-commit", postOnly(c.handleContainerCommit))
+	mux.HandleFunc("/container-snapshots", c.handleContainerSnapshots)
+	mux.HandleFunc("/container-statuses", c.handleContainerStatuses)
+	mux.HandleFunc("/container-processes", c.handleContainerProcesses)
+	mux.HandleFunc("/container-cgroups", c.handleContainerCgroups)
+	mux.HandleFunc("/container-recent-logs", c.handleContainerRecentLogs)
+	mux.HandleFunc("/core-dumps", postOnly(c.handleCoreDumps))
+	mux.HandleFunc("/namespace-usage", c.handleNamespaceUsage)
+	mux.HandleFunc("/image-prepull", c.handleImagePrepull)
+	mux.HandleFunc("/image-build", postOnly(c.handleImageBuild))
+	mux.HandleFunc("/failpoints", c.handleFailpoints)
+	mux.HandleFunc("/state", c.handleState)
+	mux.HandleFunc("/runtime-timeouts", c.handleRuntimeTimeouts)
+	mux.HandleFunc("/runtime-handlers", c.handleRuntimeHandlers)
+	mux.HandleFunc("/dry-run", postOnly(c.handleDryRun))
+	mux.Handle("/metrics", c.metrics.registry)
+	if c.config.ReadOnly {
+		return readOnlyDebugHandler(mux)
+	}
+	return mux
+}
+
+// readOnlyDebugPaths are the debug endpoints which are allowed with any method
+// in read-only mode. They only change the logging of cri-containerd, or don't
+// change anything.
+var readOnlyDebugPaths = map[string]bool{
+	"/log-level": true,
+	"/rpc-log":   true,
+	"/dry-run":   true,
+}
+
+// readOnlyDebugHandler wraps the debug handler in read-only mode, so that only
+// GET requests are served, except endpoints in readOnlyDebugPaths. Container
+// export is also rejected, because it writes the layer into the content store.
+func readOnlyDebugHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := readOnlyDebugPaths[r.URL.Path] ||
+			(r.Method == http.MethodGet || r.Method == http.MethodHead) && r.URL.Path != "/container-export"
+		if !allowed {
+			http.Error(w, fmt.Sprintf("%s %s is not allowed in read-only mode", r.Method, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// postOnly wraps a handler which mutates state, so that it only accepts POST requests.
+func postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writeJSON writes the json encoded result into the response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		glog.Errorf("Failed to encode debug response %+v: %v", v, err)
+	}
+}
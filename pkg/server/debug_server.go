@@ -0,0 +1,64 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/golang/glog"
+)
+
+// CRIContainerdDebugServer is the http server serving cri-containerd debug and
+// administrative endpoints over a unix socket.
+type CRIContainerdDebugServer struct {
+	// addr is the address to serve on.
+	addr string
+	// handler is the http handler of all debug endpoints.
+	handler http.Handler
+}
+
+// NewCRIContainerdDebugServer creates the cri-containerd debug server.
+func NewCRIContainerdDebugServer(addr string, h http.Handler) *CRIContainerdDebugServer {
+	return &CRIContainerdDebugServer{
+		addr:    addr,
+		handler: h,
+	}
+}
+
+// Run runs the cri-containerd debug server.
+func (s *CRIContainerdDebugServer) Run() error {
+	glog.V(2).Infof("Start cri-containerd debug server")
+	// Unlink to cleanup the previous socket file.
+	err := syscall.Unlink(s.addr)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to unlink socket file %q: %v", s.addr, err)
+	}
+	l, err := net.Listen(unixProtocol, s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %v", s.addr, err)
+	}
+	// Only root should be able to access debug and administrative endpoints.
+	if err := os.Chmod(s.addr, 0600); err != nil {
+		l.Close()
+		return fmt.Errorf("failed to chmod socket file %q: %v", s.addr, err)
+	}
+	return http.Serve(l, s.handler)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// dryRunID is the id of the sandbox and container in dry run specs.
+const dryRunID = "dry-run"
+
+// dryRunRequest is the request of a sandbox and container creation dry run.
+type dryRunRequest struct {
+	// SandboxConfig is the config of the sandbox.
+	SandboxConfig *runtime.PodSandboxConfig `json:"sandboxConfig"`
+	// ContainerConfig is the optional config of a container in the sandbox.
+	ContainerConfig *runtime.ContainerConfig `json:"containerConfig,omitempty"`
+}
+
+// dryRunResult is the result of a sandbox and container creation dry run.
+type dryRunResult struct {
+	// RuntimeHandler is the runtime handler selected by the sandbox, nil
+	// means containerd runtime defaults.
+	RuntimeHandler *runtimeHandler `json:"runtimeHandler,omitempty"`
+	// SandboxSpec is the generated sandbox container spec.
+	SandboxSpec *runtimespec.Spec `json:"sandboxSpec"`
+	// ContainerSpec is the generated container spec.
+	ContainerSpec *runtimespec.Spec `json:"containerSpec,omitempty"`
+}
+
+// dryRun runs the checks and spec generation of RunPodSandbox and CreateContainer
+// without creating anything. Images are not pulled, and the user of the container
+// is not resolved because that needs the container rootfs. The container spec
+// refers to namespaces of a sandbox with pid 0. Admission webhook requests are
+// flagged as dry run.
+func (c *criContainerdService) dryRun(ctx context.Context, req *dryRunRequest) (*dryRunResult, error) {
+	config := req.SandboxConfig
+	if config == nil {
+		return nil, fmt.Errorf("sandbox config is required")
+	}
+	_, handler, err := c.validateSandboxConfig(ctx, config, true)
+	if err != nil {
+		return nil, err
+	}
+	if !config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork() {
+		if err := c.netPlugin.Status(); err != nil {
+			return nil, newPhaseError(phaseNetwork, err, "network plugin is not ready")
+		}
+	}
+	sandboxImage, err := c.localResolve(ctx, c.sandboxImage)
+	if err != nil {
+		return nil, newPhaseError(phaseSandboxImage, err, "failed to resolve sandbox image %q", c.sandboxImage)
+	}
+	if sandboxImage == nil {
+		return nil, newPhaseError(phaseSandboxImage, newCRIError(codes.NotFound, ReasonImageNotFound,
+			"image %q not found", c.sandboxImage), "sandbox image is not pulled")
+	}
+	result := &dryRunResult{RuntimeHandler: handler}
+	result.SandboxSpec, err = c.generateSandboxContainerSpec(dryRunID, config, sandboxImage.Config, "")
+	if err != nil {
+		return nil, newPhaseError(phaseSpec, err, "failed to generate sandbox container spec")
+	}
+	if req.ContainerConfig == nil {
+		return result, nil
+	}
+
+	containerConfig := req.ContainerConfig
+	image, err := c.validateContainerConfig(ctx, containerConfig, config, true)
+	if err != nil {
+		return nil, err
+	}
+	extraMounts := append(c.generateContainerMounts(getSandboxRootDir(c.rootDir, dryRunID), containerConfig),
+		handler.containerMounts()...)
+	result.ContainerSpec, err = c.generateContainerSpec(dryRunID, 0, containerConfig, config, image.Config, extraMounts)
+	if err != nil {
+		return nil, newPhaseError(phaseSpec, err, "failed to generate container spec")
+	}
+	return result, nil
+}
+
+// handleDryRun handles the dry-run debug endpoint. The request body is a json
+// encoded dryRunRequest.
+func (c *criContainerdService) handleDryRun(w http.ResponseWriter, r *http.Request) {
+	var req dryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid dry run request: %v", err), http.StatusBadRequest)
+		return
+	}
+	result, err := c.dryRun(r.Context(), &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	servertesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/testing"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+func TestDryRun(t *testing.T) {
+	for desc, test := range map[string]struct {
+		noSandboxConfig  bool
+		withContainer    bool
+		hostNetwork      bool
+		noSandboxImage   bool
+		noContainerImage bool
+		networkNotReady  bool
+		expectErr        bool
+	}{
+		"should generate sandbox spec": {},
+		"should generate sandbox and container spec": {
+			withContainer: true,
+		},
+		"should fail without sandbox config": {
+			noSandboxConfig: true,
+			expectErr:       true,
+		},
+		"should fail if sandbox image is not pulled": {
+			noSandboxImage: true,
+			expectErr:      true,
+		},
+		"should fail if container image is not pulled": {
+			withContainer:    true,
+			noContainerImage: true,
+			expectErr:        true,
+		},
+		"should fail if network plugin is not ready": {
+			networkNotReady: true,
+			expectErr:       true,
+		},
+		"should not check network plugin for host network sandbox": {
+			networkNotReady: true,
+			hostNetwork:     true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		sandboxConfig, sandboxImageConfig, sandboxSpecCheck := getRunPodSandboxTestData()
+		containerConfig, _, containerImageConfig, containerSpecCheck := getCreateContainerTestData()
+		if test.hostNetwork {
+			sandboxConfig.Linux.SecurityContext = &runtime.LinuxSandboxSecurityContext{
+				NamespaceOptions: &runtime.NamespaceOption{HostNetwork: true},
+			}
+		}
+		if !test.noSandboxImage {
+			c.imageStore.Add(imagestore.Image{ID: testSandboxImage, Config: sandboxImageConfig})
+		}
+		if !test.noContainerImage {
+			c.imageStore.Add(imagestore.Image{ID: containerConfig.GetImage().GetImage(), Config: containerImageConfig})
+		}
+		if test.networkNotReady {
+			c.netPlugin.(*servertesting.FakeCNIPlugin).InjectError("Status", errors.New("not ready"))
+		}
+		req := &dryRunRequest{SandboxConfig: sandboxConfig}
+		if test.noSandboxConfig {
+			req.SandboxConfig = nil
+		}
+		if test.withContainer {
+			req.ContainerConfig = containerConfig
+		}
+		result, err := c.dryRun(context.Background(), req)
+		assert.Empty(t, c.sandboxStore.List(), "sandbox should not be created")
+		assert.Empty(t, c.containerStore.List(), "container should not be created")
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		sandboxSpecCheck(t, dryRunID, result.SandboxSpec)
+		if !test.withContainer {
+			assert.Nil(t, result.ContainerSpec)
+			continue
+		}
+		containerSpecCheck(t, dryRunID, 0, result.ContainerSpec)
+	}
+}
+
+func TestDryRunAdmissionWebhook(t *testing.T) {
+	var got []admissionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req admissionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		got = append(got, req)
+		json.NewEncoder(w).Encode(admissionResponse{Allowed: true}) // nolint: errcheck
+	}))
+	defer server.Close()
+
+	c := newTestCRIContainerdService()
+	c.admission = &admissionController{webhook: server.URL, client: &http.Client{Timeout: time.Second}}
+	sandboxConfig, sandboxImageConfig, _ := getRunPodSandboxTestData()
+	containerConfig, _, containerImageConfig, _ := getCreateContainerTestData()
+	c.imageStore.Add(imagestore.Image{ID: testSandboxImage, Config: sandboxImageConfig})
+	c.imageStore.Add(imagestore.Image{ID: containerConfig.GetImage().GetImage(), Config: containerImageConfig})
+	_, err := c.dryRun(context.Background(), &dryRunRequest{SandboxConfig: sandboxConfig, ContainerConfig: containerConfig})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	for _, req := range got {
+		assert.True(t, req.DryRun, "admission request %q should be flagged as dry run", req.Operation)
+	}
+}
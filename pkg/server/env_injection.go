@@ -0,0 +1,147 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// envInjectionRule injects environment variables into matching containers.
+type envInjectionRule struct {
+	// Annotations are annotations, in the form of `key` or `key=value`, a
+	// container or its sandbox must all have for the rule to apply. Empty
+	// means the rule applies to every container.
+	Annotations []string `json:"annotations,omitempty"`
+	// Env are environment variables in the form of `key=value`.
+	Env []string `json:"env,omitempty"`
+	// EnvFiles are node-local files with a `key=value` environment variable
+	// per line. Empty lines and lines starting with `#` are ignored. The files
+	// are read every time a container spec is generated.
+	EnvFiles []string `json:"envFiles,omitempty"`
+}
+
+// envInjectionConfig is the config of environment variables injected into
+// containers.
+type envInjectionConfig struct {
+	// Rules are applied in order, so later rules override variables injected
+	// by earlier ones.
+	Rules []envInjectionRule `json:"rules"`
+}
+
+// loadEnvInjectionConfig loads the env injection config from the json file.
+// It returns nil if the file is not specified.
+func loadEnvInjectionConfig(path string) (*envInjectionConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var config envInjectionConfig
+	if err := readJSONFile(path, &config); err != nil {
+		return nil, fmt.Errorf("failed to load env injection config %q: %v", path, err)
+	}
+	for _, rule := range config.Rules {
+		for _, e := range rule.Env {
+			if _, _, err := parseEnv(e); err != nil {
+				return nil, fmt.Errorf("invalid env injection config %q: %v", path, err)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// parseEnv parses an environment variable in the form of `key=value`.
+func parseEnv(e string) (string, string, error) {
+	kv := strings.SplitN(e, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return "", "", fmt.Errorf("invalid environment variable %q", e)
+	}
+	return kv[0], kv[1], nil
+}
+
+// hasAnnotation returns whether the annotations match the selector in the
+// form of `key` or `key=value`.
+func hasAnnotation(annotations map[string]string, selector string) bool {
+	kv := strings.SplitN(selector, "=", 2)
+	v, ok := annotations[kv[0]]
+	if !ok {
+		return false
+	}
+	return len(kv) == 1 || kv[1] == v
+}
+
+// matches returns whether the rule applies to the container.
+func (r *envInjectionRule) matches(config *runtime.ContainerConfig, sandboxConfig *runtime.PodSandboxConfig) bool {
+	for _, selector := range r.Annotations {
+		if !hasAnnotation(config.GetAnnotations(), selector) &&
+			!hasAnnotation(sandboxConfig.GetAnnotations(), selector) {
+			return false
+		}
+	}
+	return true
+}
+
+// getInjectedEnvs returns environment variables injected into the container
+// by the env injection config, in the form of `key=value`.
+func (c *criContainerdService) getInjectedEnvs(config *runtime.ContainerConfig,
+	sandboxConfig *runtime.PodSandboxConfig) ([]string, error) {
+	if c.envInjection == nil {
+		return nil, nil
+	}
+	var envs []string
+	for _, rule := range c.envInjection.Rules {
+		if !rule.matches(config, sandboxConfig) {
+			continue
+		}
+		envs = append(envs, rule.Env...)
+		for _, file := range rule.EnvFiles {
+			fileEnvs, err := c.readEnvFile(file)
+			if err != nil {
+				return nil, err
+			}
+			envs = append(envs, fileEnvs...)
+		}
+	}
+	return envs, nil
+}
+
+// readEnvFile reads environment variables from the env file.
+func (c *criContainerdService) readEnvFile(path string) ([]string, error) {
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file %q: %v", path, err)
+	}
+	var envs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, err := parseEnv(line); err != nil {
+			return nil, fmt.Errorf("invalid env file %q: %v", path, err)
+		}
+		envs = append(envs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file %q: %v", path, err)
+	}
+	return envs, nil
+}
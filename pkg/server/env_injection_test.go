@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+func TestLoadEnvInjectionConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "env-injection")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for desc, test := range map[string]struct {
+		content   string
+		expected  *envInjectionConfig
+		expectErr bool
+	}{
+		"valid config": {
+			content: `{"rules": [{"annotations": ["proxy"], "env": ["HTTP_PROXY=http://proxy:3128"], "envFiles": ["/etc/node-env"]}]}`,
+			expected: &envInjectionConfig{Rules: []envInjectionRule{{
+				Annotations: []string{"proxy"},
+				Env:         []string{"HTTP_PROXY=http://proxy:3128"},
+				EnvFiles:    []string{"/etc/node-env"},
+			}}},
+		},
+		"invalid env": {
+			content:   `{"rules": [{"env": ["HTTP_PROXY"]}]}`,
+			expectErr: true,
+		},
+		"invalid json": {
+			content:   `{"rules": `,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		path := filepath.Join(dir, "config.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(test.content), 0644))
+		config, err := loadEnvInjectionConfig(path)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, config)
+	}
+	config, err := loadEnvInjectionConfig("")
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+func TestGetInjectedEnvs(t *testing.T) {
+	injection := &envInjectionConfig{Rules: []envInjectionRule{
+		{
+			Env:      []string{"NODE=node-1"},
+			EnvFiles: []string{"/etc/node-env"},
+		},
+		{
+			Annotations: []string{"proxy=true"},
+			Env:         []string{"HTTP_PROXY=http://proxy:3128"},
+		},
+		{
+			Annotations: []string{"proxy=true", "region"},
+			Env:         []string{"REGION=us"},
+		},
+	}}
+	for desc, test := range map[string]struct {
+		containerAnnotations map[string]string
+		sandboxAnnotations   map[string]string
+		envFile              string
+		envFileErr           error
+		expected             []string
+		expectErr            bool
+	}{
+		"should inject envs of rules without annotations": {
+			envFile:  "# node identity\n\nZONE=a\nID=1=2\n",
+			expected: []string{"NODE=node-1", "ZONE=a", "ID=1=2"},
+		},
+		"should inject envs of rules matching container annotations": {
+			containerAnnotations: map[string]string{"proxy": "true"},
+			expected:             []string{"NODE=node-1", "HTTP_PROXY=http://proxy:3128"},
+		},
+		"should inject envs of rules matching sandbox annotations": {
+			containerAnnotations: map[string]string{"region": "us"},
+			sandboxAnnotations:   map[string]string{"proxy": "true"},
+			expected:             []string{"NODE=node-1", "HTTP_PROXY=http://proxy:3128", "REGION=us"},
+		},
+		"should not inject envs of rules with mismatched annotation value": {
+			containerAnnotations: map[string]string{"proxy": "false"},
+			expected:             []string{"NODE=node-1"},
+		},
+		"should return error for missing env file": {
+			envFileErr: os.ErrNotExist,
+			expectErr:  true,
+		},
+		"should return error for invalid env file": {
+			envFile:   "ZONE\n",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.envInjection = injection
+		c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+			assert.Equal(t, "/etc/node-env", path)
+			return []byte(test.envFile), test.envFileErr
+		}
+		envs, err := c.getInjectedEnvs(
+			&runtime.ContainerConfig{Annotations: test.containerAnnotations},
+			&runtime.PodSandboxConfig{Annotations: test.sandboxAnnotations},
+		)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, envs)
+	}
+}
+
+func TestGenerateContainerSpecWithInjectedEnvs(t *testing.T) {
+	testID := "test-id"
+	testPid := uint32(1234)
+	config, sandboxConfig, imageConfig, _ := getCreateContainerTestData()
+	imageConfig.Env = []string{"PROXY=image", "IMAGE=image"}
+	config.Envs = []*runtime.KeyValue{{Key: "CONTAINER", Value: "container"}, {Key: "NODE", Value: "container"}}
+	c := newTestCRIContainerdService()
+	c.envInjection = &envInjectionConfig{Rules: []envInjectionRule{
+		{Env: []string{"PROXY=injected", "NODE=injected"}},
+	}}
+	spec, err := c.generateContainerSpec(testID, testPid, config, sandboxConfig, imageConfig, nil)
+	require.NoError(t, err)
+	assert.Contains(t, spec.Process.Env, "IMAGE=image")
+	assert.Contains(t, spec.Process.Env, "PROXY=injected", "injected envs should override image envs")
+	assert.Contains(t, spec.Process.Env, "NODE=container", "container envs should override injected envs")
+	assert.NotContains(t, spec.Process.Env, "PROXY=image")
+	assert.NotContains(t, spec.Process.Env, "NODE=injected")
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/gogo/protobuf/proto"
+	prototypes "github.com/gogo/protobuf/types"
+	"github.com/golang/protobuf/ptypes/any"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
+)
+
+// Reasons of CRI failures. The reason is returned as the prefix of the grpc
+// error message, e.g. "ContainerNotFound: container "abc" not found", so that
+// clients could react on the failure without parsing the whole message.
+const (
+	// ReasonContainerNotFound means the container doesn't exist.
+	ReasonContainerNotFound = "ContainerNotFound"
+	// ReasonSandboxNotFound means the sandbox doesn't exist.
+	ReasonSandboxNotFound = "SandboxNotFound"
+	// ReasonImageNotFound means the image doesn't exist locally or in the registry.
+	ReasonImageNotFound = "ImageNotFound"
+	// ReasonNameConflict means the container or sandbox name is in use.
+	ReasonNameConflict = "NameConflict"
+	// ReasonInvalidContainerState means the container is not in a state
+	// the operation could be applied to.
+	ReasonInvalidContainerState = "InvalidContainerState"
+	// ReasonSandboxNotReady means the sandbox container is not running.
+	ReasonSandboxNotReady = "SandboxNotReady"
+	// ReasonImagePullAuthFailed means the registry rejected the credentials.
+	ReasonImagePullAuthFailed = "ImagePullAuthFailed"
+	// ReasonImagePullTimeout means the image pull didn't finish in time.
+	ReasonImagePullTimeout = "ImagePullTimeout"
+	// ReasonNoSpace means there is no disk space left.
+	ReasonNoSpace = "NoSpace"
+	// ReasonAdmissionDenied means the request is rejected by the admission
+	// policy or webhook.
+	ReasonAdmissionDenied = "AdmissionDenied"
+	// ReasonNamespaceQuotaExceeded means the request exceeds the quota of the
+	// kubernetes namespace on the node.
+	ReasonNamespaceQuotaExceeded = "NamespaceQuotaExceeded"
+	// ReasonHookError means an oci hook of the container failed. It is also
+	// the reason in the status of the container.
+	ReasonHookError = "HookError"
+	// ReasonImageDigestRequired means the image is not referenced by or
+	// resolved to a digest, which is required by the image digest policy.
+	ReasonImageDigestRequired = "ImageDigestRequired"
+	// ReasonMountDenied means a mount of the container is denied by the mount
+	// policy.
+	ReasonMountDenied = "MountDenied"
+	// ReasonReadOnly means the request changes the node, which is not allowed
+	// in read-only mode.
+	ReasonReadOnly = "ReadOnly"
+	// ReasonExecTimeout means the exec process didn't exit before the timeout
+	// of the request, and is killed.
+	ReasonExecTimeout = "ExecTimeout"
+	// ReasonStreamingUnsupported means the streaming endpoint of exec, attach
+	// or port forwarding can't be served, because the kubelet streaming server
+	// is not built in.
+	ReasonStreamingUnsupported = "StreamingUnsupported"
+)
+
+// Phases of sandbox and container creation reported in error detail.
+const (
+	phaseSandboxImage = "SandboxImage"
+	phaseImage        = "Image"
+	phaseSpec         = "Spec"
+	phaseRootfs       = "Rootfs"
+	phaseContainer    = "Container"
+	phaseFiles        = "Files"
+	phaseTask         = "Task"
+	phaseNetwork      = "Network"
+)
+
+// phaseHints are suggested actions for failures in each phase.
+var phaseHints = map[string]string{
+	phaseSandboxImage: "make sure the sandbox image can be pulled on the node",
+	phaseImage:        "make sure the image is pulled before creating the container",
+	phaseSpec:         "check the pod and container config, e.g. security context, user and seccomp profile",
+	phaseRootfs:       "check disk space and the snapshotter in containerd logs",
+	phaseContainer:    "check containerd logs",
+	phaseFiles:        "check the cri-containerd root directory and the pod dns config",
+	phaseTask:         "check containerd and runtime logs",
+	phaseNetwork:      "check the cni config and cni plugin logs",
+}
+
+// noSpaceHint is the suggested action for running out of disk space.
+const noSpaceHint = "free up disk space on the node"
+
+// errorDetailTypeURL is the type url of the error detail attached to grpc
+// error status. The detail is a google.protobuf.Struct with string fields
+// "phase", "cause" and "hint".
+const errorDetailTypeURL = "type.googleapis.com/google.protobuf.Struct"
+
+// ErrorDetail is the structured detail of a sandbox or container creation failure.
+type ErrorDetail struct {
+	// Phase is the phase of the creation which failed.
+	Phase string
+	// Cause is the underlying containerd or cni error.
+	Cause string
+	// Hint is the suggested action.
+	Hint string
+}
+
+// criError is an error with a grpc code and a machine-readable reason. It is
+// converted into a grpc error by the grpc interceptor.
+type criError struct {
+	code    codes.Code
+	reason  string
+	message string
+	detail  *ErrorDetail
+}
+
+// Error returns the error message.
+func (e *criError) Error() string {
+	return e.message
+}
+
+// newCRIError creates a criError with the code, reason and formatted message.
+func newCRIError(code codes.Code, reason string, format string, args ...interface{}) error {
+	return &criError{
+		code:    code,
+		reason:  reason,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+// wrapCRIError prefixes the error message with the formatted message like
+// fmt.Errorf("<message>: %v", err), but keeps the code and reason of err.
+func wrapCRIError(err error, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...) + ": " + err.Error()
+	if e, ok := err.(*criError); ok {
+		return &criError{code: e.code, reason: e.reason, message: message, detail: e.detail}
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// newPhaseError wraps the cause like wrapCRIError, and attaches error detail
+// with the failed phase, the cause and the suggested action.
+func newPhaseError(phase string, cause error, format string, args ...interface{}) error {
+	e := &criError{
+		code:    codes.Unknown,
+		message: fmt.Sprintf(format, args...) + ": " + cause.Error(),
+		detail: &ErrorDetail{
+			Phase: phase,
+			Cause: cause.Error(),
+			Hint:  phaseHints[phase],
+		},
+	}
+	if c, ok := cause.(*criError); ok {
+		e.code, e.reason = c.code, c.reason
+	} else if isNoSpaceError(cause) {
+		e.code, e.reason = codes.ResourceExhausted, ReasonNoSpace
+		e.detail.Hint = noSpaceHint
+	}
+	return e
+}
+
+// toGRPCError converts a criError into grpc error. Other errors are returned
+// as is. The error detail is both attached to the status and appended to the
+// message, because only the message is shown in kubernetes events.
+func toGRPCError(err error) error {
+	e, ok := err.(*criError)
+	if !ok {
+		return err
+	}
+	message := e.message
+	if e.reason != "" {
+		message = e.reason + ": " + message
+	}
+	if e.detail == nil {
+		return grpc.Errorf(e.code, "%s", message)
+	}
+	message = fmt.Sprintf("%s (phase: %s, hint: %s)", message, e.detail.Phase, e.detail.Hint)
+	st := &spb.Status{Code: int32(e.code), Message: message}
+	if d, err := marshalErrorDetail(e.detail); err == nil {
+		st.Details = []*any.Any{d}
+	}
+	return status.ErrorProto(st)
+}
+
+// marshalErrorDetail marshals error detail into a google.protobuf.Struct.
+func marshalErrorDetail(detail *ErrorDetail) (*any.Any, error) {
+	value := func(s string) *prototypes.Value {
+		return &prototypes.Value{Kind: &prototypes.Value_StringValue{StringValue: s}}
+	}
+	data, err := proto.Marshal(&prototypes.Struct{Fields: map[string]*prototypes.Value{
+		"phase": value(detail.Phase),
+		"cause": value(detail.Cause),
+		"hint":  value(detail.Hint),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return &any.Any{TypeUrl: errorDetailTypeURL, Value: data}, nil
+}
+
+// GetErrorDetail returns the error detail of an error returned by cri-containerd.
+func GetErrorDetail(err error) (*ErrorDetail, bool) {
+	if e, ok := err.(*criError); ok {
+		return e.detail, e.detail != nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+	for _, d := range st.Proto().GetDetails() {
+		if d.GetTypeUrl() != errorDetailTypeURL {
+			continue
+		}
+		var s prototypes.Struct
+		if err := proto.Unmarshal(d.GetValue(), &s); err != nil {
+			continue
+		}
+		return &ErrorDetail{
+			Phase: s.Fields["phase"].GetStringValue(),
+			Cause: s.Fields["cause"].GetStringValue(),
+			Hint:  s.Fields["hint"].GetStringValue(),
+		}, true
+	}
+	return nil, false
+}
+
+// ErrorReason returns the reason of an error returned by cri-containerd, or
+// empty string if the error doesn't have a reason.
+func ErrorReason(err error) string {
+	if e, ok := err.(*criError); ok {
+		return e.reason
+	}
+	if grpc.Code(err) == codes.Unknown {
+		return ""
+	}
+	desc := grpc.ErrorDesc(err)
+	i := strings.Index(desc, ": ")
+	if i <= 0 || strings.ContainsAny(desc[:i], " \t") {
+		return ""
+	}
+	return desc[:i]
+}
+
+// containerLookupError converts an error of container store lookup into
+// cri error.
+func containerLookupError(id string, err error) error {
+	if err == store.ErrNotExist {
+		return newCRIError(codes.NotFound, ReasonContainerNotFound, "container %q not found", id)
+	}
+	return fmt.Errorf("an error occurred when try to find container %q: %v", id, err)
+}
+
+// sandboxLookupError converts an error of sandbox store lookup into cri error.
+func sandboxLookupError(id string, err error) error {
+	if err == store.ErrNotExist {
+		return newCRIError(codes.NotFound, ReasonSandboxNotFound, "sandbox %q not found", id)
+	}
+	return fmt.Errorf("an error occurred when try to find sandbox %q: %v", id, err)
+}
+
+// isNoSpaceError checks whether an error is caused by running out of disk
+// space. The error may come from containerd over grpc, so the message is checked.
+func isNoSpaceError(err error) bool {
+	return strings.Contains(err.Error(), syscall.ENOSPC.Error())
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestToGRPCError(t *testing.T) {
+	for desc, test := range map[string]struct {
+		err          error
+		expectCode   codes.Code
+		expectReason string
+		expectDesc   string
+	}{
+		"non cri error should be returned as is": {
+			err:        errors.New("test error"),
+			expectCode: codes.Unknown,
+			expectDesc: "test error",
+		},
+		"cri error should be converted with code and reason": {
+			err:          newCRIError(codes.NotFound, ReasonContainerNotFound, "container %q not found", "abc"),
+			expectCode:   codes.NotFound,
+			expectReason: ReasonContainerNotFound,
+			expectDesc:   `ContainerNotFound: container "abc" not found`,
+		},
+		"wrapped cri error should keep code and reason": {
+			err: wrapCRIError(newCRIError(codes.FailedPrecondition, ReasonInvalidContainerState,
+				"container is still running"), "failed to remove container %q", "abc"),
+			expectCode:   codes.FailedPrecondition,
+			expectReason: ReasonInvalidContainerState,
+			expectDesc:   `InvalidContainerState: failed to remove container "abc": container is still running`,
+		},
+		"wrapped non cri error should not have code": {
+			err:        wrapCRIError(errors.New("test error"), "failed to do %s", "something"),
+			expectCode: codes.Unknown,
+			expectDesc: "failed to do something: test error",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		err := toGRPCError(test.err)
+		assert.Equal(t, test.expectCode, grpc.Code(err))
+		assert.Equal(t, test.expectDesc, grpc.ErrorDesc(err))
+		assert.Equal(t, test.expectReason, ErrorReason(err))
+		assert.Equal(t, test.expectReason, ErrorReason(test.err))
+	}
+}
+
+func TestErrorReasonOfGRPCError(t *testing.T) {
+	for desc, test := range map[string]struct {
+		err          error
+		expectReason string
+	}{
+		"grpc error with reason": {
+			err:          grpc.Errorf(codes.NotFound, "SandboxNotFound: sandbox %q not found", "abc"),
+			expectReason: ReasonSandboxNotFound,
+		},
+		"grpc error without reason": {
+			err: grpc.Errorf(codes.NotFound, "sandbox %q not found: not exist", "abc"),
+		},
+		"unknown grpc error": {
+			err: grpc.Errorf(codes.Unknown, "Reason: message"),
+		},
+		"non grpc error": {
+			err: errors.New("Reason: message"),
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expectReason, ErrorReason(test.err))
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	err := containerLookupError("abc", store.ErrNotExist)
+	assert.Equal(t, ReasonContainerNotFound, ErrorReason(err))
+	assert.Equal(t, codes.NotFound, grpc.Code(toGRPCError(err)))
+	err = sandboxLookupError("abc", store.ErrNotExist)
+	assert.Equal(t, ReasonSandboxNotFound, ErrorReason(err))
+	assert.Equal(t, codes.NotFound, grpc.Code(toGRPCError(err)))
+	err = containerLookupError("abc", errors.New("random error"))
+	assert.Empty(t, ErrorReason(err))
+	assert.Equal(t, codes.Unknown, grpc.Code(toGRPCError(err)))
+}
+
+func TestResolveError(t *testing.T) {
+	for desc, test := range map[string]struct {
+		err          error
+		expectReason string
+	}{
+		"auth failure": {
+			err:          fmt.Errorf("server message: %v", docker.ErrInvalidAuthorization),
+			expectReason: ReasonImagePullAuthFailed,
+		},
+		"image not found": {
+			err:          errors.New("docker.io/library/busybox:latest not found"),
+			expectReason: ReasonImageNotFound,
+		},
+		"other errors": {
+			err: errors.New("failed to do request"),
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expectReason, ErrorReason(resolveError("busybox", test.err)))
+	}
+}
+
+func TestContainerRPCNotFoundError(t *testing.T) {
+	c := newTestCRIContainerdService()
+	_, err := c.ContainerStatus(context.Background(), &runtime.ContainerStatusRequest{ContainerId: "abc"})
+	assert.Equal(t, ReasonContainerNotFound, ErrorReason(err))
+	assert.Equal(t, codes.NotFound, grpc.Code(toGRPCError(err)))
+	_, err = c.PodSandboxStatus(context.Background(), &runtime.PodSandboxStatusRequest{PodSandboxId: "abc"})
+	assert.Equal(t, ReasonSandboxNotFound, ErrorReason(err))
+}
+
+func TestPhaseError(t *testing.T) {
+	for desc, test := range map[string]struct {
+		cause        error
+		expectCode   codes.Code
+		expectReason string
+		expectDetail ErrorDetail
+		expectDesc   string
+	}{
+		"plain cause": {
+			cause:      errors.New("cni plugin not initialized"),
+			expectCode: codes.Unknown,
+			expectDetail: ErrorDetail{
+				Phase: phaseNetwork,
+				Cause: "cni plugin not initialized",
+				Hint:  phaseHints[phaseNetwork],
+			},
+			expectDesc: "failed to setup network: cni plugin not initialized (phase: Network, hint: " +
+				phaseHints[phaseNetwork] + ")",
+		},
+		"cri error cause should keep code and reason": {
+			cause:        newCRIError(codes.NotFound, ReasonImageNotFound, "image not found"),
+			expectCode:   codes.NotFound,
+			expectReason: ReasonImageNotFound,
+			expectDetail: ErrorDetail{
+				Phase: phaseNetwork,
+				Cause: "image not found",
+				Hint:  phaseHints[phaseNetwork],
+			},
+			expectDesc: "ImageNotFound: failed to setup network: image not found (phase: Network, hint: " +
+				phaseHints[phaseNetwork] + ")",
+		},
+		"no space cause should be resource exhausted": {
+			cause:        errors.New("write /var/lib/containerd/x: no space left on device"),
+			expectCode:   codes.ResourceExhausted,
+			expectReason: ReasonNoSpace,
+			expectDetail: ErrorDetail{
+				Phase: phaseNetwork,
+				Cause: "write /var/lib/containerd/x: no space left on device",
+				Hint:  noSpaceHint,
+			},
+			expectDesc: "NoSpace: failed to setup network: write /var/lib/containerd/x: no space left on device " +
+				"(phase: Network, hint: " + noSpaceHint + ")",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		err := newPhaseError(phaseNetwork, test.cause, "failed to setup network")
+		detail, ok := GetErrorDetail(err)
+		assert.True(t, ok)
+		assert.Equal(t, test.expectDetail, *detail)
+
+		grpcErr := toGRPCError(err)
+		assert.Equal(t, test.expectCode, grpc.Code(grpcErr))
+		assert.Equal(t, test.expectDesc, grpc.ErrorDesc(grpcErr))
+		assert.Equal(t, test.expectReason, ErrorReason(grpcErr))
+		detail, ok = GetErrorDetail(grpcErr)
+		assert.True(t, ok)
+		assert.Equal(t, test.expectDetail, *detail)
+	}
+}
+
+func TestErrorDetailNotAttached(t *testing.T) {
+	_, ok := GetErrorDetail(errors.New("test error"))
+	assert.False(t, ok)
+	_, ok = GetErrorDetail(toGRPCError(newCRIError(codes.NotFound, ReasonContainerNotFound, "not found")))
+	assert.False(t, ok)
+}
+
+func TestCreateContainerImageNotFoundError(t *testing.T) {
+	c := newTestCRIContainerdService()
+	assert.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{ID: "s-1"}}))
+	_, err := c.CreateContainer(context.Background(), &runtime.CreateContainerRequest{
+		PodSandboxId: "s-1",
+		Config: &runtime.ContainerConfig{
+			Metadata: &runtime.ContainerMetadata{Name: "test-name"},
+			Image:    &runtime.ImageSpec{Image: "sha256:c75bebcdd211f41b3a460c7bf82970ed6c75acaab9cd4c9a4e125b03ca113799"},
+		},
+		SandboxConfig: &runtime.PodSandboxConfig{
+			Metadata: &runtime.PodSandboxMetadata{Name: "test-sandbox", Uid: "test-uid", Namespace: "test-ns"},
+		},
+	})
+	assert.Equal(t, ReasonImageNotFound, ErrorReason(err))
+	detail, ok := GetErrorDetail(err)
+	assert.True(t, ok)
+	assert.Equal(t, phaseImage, detail.Phase)
+}
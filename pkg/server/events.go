@@ -17,15 +17,17 @@ limitations under the License.
 package server
 
 import (
+	"hash/fnv"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/api/services/events/v1"
-	"github.com/containerd/containerd/api/services/tasks/v1"
 	"github.com/containerd/containerd/typeurl"
 	"github.com/golang/glog"
 	"github.com/jpillora/backoff"
 	"golang.org/x/net/context"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
 	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
 )
 
@@ -36,32 +38,148 @@ const (
 	maxRetryInterval = 30 * time.Second
 	// exponentialFactor is the exponential backoff factor.
 	exponentialFactor = 2.0
+	// eventQueueSize is the number of events queued for each event worker. The
+	// event stream is not received when the queue is full.
+	eventQueueSize = 256
 )
 
+// eventMonitorStatus keeps whether the containerd event stream is alive.
+type eventMonitorStatus struct {
+	sync.Mutex
+	// connected indicates whether the event stream is subscribed.
+	connected bool
+	// since is the time connected changed.
+	since time.Time
+	// err is the last error of the event stream.
+	err error
+}
+
+func newEventMonitorStatus() *eventMonitorStatus {
+	return &eventMonitorStatus{since: time.Now()}
+}
+
+// setConnected marks the event stream subscribed.
+func (s *eventMonitorStatus) setConnected() {
+	s.Lock()
+	defer s.Unlock()
+	s.connected = true
+	s.since = time.Now()
+	s.err = nil
+}
+
+// setDisconnected marks the event stream lost with the error.
+func (s *eventMonitorStatus) setDisconnected(err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.connected {
+		s.since = time.Now()
+	}
+	s.connected = false
+	s.err = err
+}
+
+// get returns whether the event stream is subscribed, since when, and the
+// last error.
+func (s *eventMonitorStatus) get() (bool, time.Time, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.connected, s.since, s.err
+}
+
+// eventDispatcher dispatches events to a bounded pool of workers. Events with the
+// same key are always handled by the same worker in order, and a burst of events
+// on one worker doesn't delay events handled by other workers.
+type eventDispatcher struct {
+	queues  []chan interface{}
+	handle  func(interface{})
+	backlog *metrics.Gauge
+}
+
+// newEventDispatcher creates an event dispatcher with the number of workers. The
+// backlog gauge counts events dispatched but not handled yet.
+func newEventDispatcher(workers int, handle func(interface{}), backlog *metrics.Gauge) *eventDispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &eventDispatcher{handle: handle, backlog: backlog}
+	for i := 0; i < workers; i++ {
+		d.queues = append(d.queues, make(chan interface{}, eventQueueSize))
+	}
+	return d
+}
+
+// start starts the workers.
+func (d *eventDispatcher) start() {
+	for _, q := range d.queues {
+		go func(q chan interface{}) {
+			for e := range q {
+				d.handle(e)
+				d.backlog.Dec()
+			}
+		}(q)
+	}
+}
+
+// dispatch queues the event to the worker of the key, it blocks if the queue
+// of the worker is full.
+func (d *eventDispatcher) dispatch(key string, e interface{}) {
+	d.backlog.Inc()
+	d.queues[d.worker(key)] <- e
+}
+
+// worker returns the index of the worker handling events of the key.
+func (d *eventDispatcher) worker(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key)) // nolint: errcheck
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+// eventContainerID returns the id of the container an event is about, or empty
+// if the event is not about a container.
+func eventContainerID(e interface{}) string {
+	switch e := e.(type) {
+	case *events.TaskExit:
+		return e.ContainerID
+	case *events.TaskOOM:
+		return e.ContainerID
+	}
+	return ""
+}
+
 // startEventMonitor starts an event monitor which monitors and handles all
-// container events.
+// container events. The monitor starts with the event stream subscribed
+// before, if it is not nil.
 // TODO(random-liu): [P1] Is it possible to drop event during containerd is running?
-func (c *criContainerdService) startEventMonitor() {
+func (c *criContainerdService) startEventMonitor(eventstream events.Events_SubscribeClient) {
 	b := backoff.Backoff{
 		Min:    minRetryInterval,
 		Max:    maxRetryInterval,
 		Factor: exponentialFactor,
 	}
+	dispatcher := newEventDispatcher(c.config.EventWorkers, c.handleEvent, c.metrics.eventBacklog)
+	dispatcher.start()
 	go func() {
 		for {
-			eventstream, err := c.eventService.Subscribe(context.Background(), &events.SubscribeRequest{})
-			if err != nil {
-				glog.Errorf("Failed to connect to containerd event stream: %v", err)
-				time.Sleep(b.Duration())
-				continue
+			if eventstream == nil {
+				var err error
+				eventstream, err = c.eventService.Subscribe(context.Background(), &events.SubscribeRequest{})
+				if err != nil {
+					glog.Errorf("Failed to connect to containerd event stream: %v", err)
+					c.eventMonitorStatus.setDisconnected(err)
+					time.Sleep(b.Duration())
+					continue
+				}
 			}
 			// Successfully connect with containerd, reset backoff.
 			b.Reset()
+			c.eventMonitorStatus.setConnected()
 			// TODO(random-liu): Relist to recover state, should prevent other operations
 			// until state is fully recovered.
 			for {
-				if err := c.handleEventStream(eventstream); err != nil {
+				if err := c.handleEventStream(eventstream, dispatcher); err != nil {
 					glog.Errorf("Failed to handle event stream: %v", err)
+					c.eventMonitorStatus.setDisconnected(err)
+					eventstream = nil
 					break
 				}
 			}
@@ -69,24 +187,25 @@ func (c *criContainerdService) startEventMonitor() {
 	}()
 }
 
-// handleEventStream receives an event from containerd and handles the event.
-func (c *criContainerdService) handleEventStream(eventstream events.Events_SubscribeClient) error {
+// handleEventStream receives an event from containerd and dispatches the event
+// to be handled by the worker of the container.
+func (c *criContainerdService) handleEventStream(eventstream events.Events_SubscribeClient, dispatcher *eventDispatcher) error {
 	e, err := eventstream.Recv()
 	if err != nil {
 		return err
 	}
 	glog.V(4).Infof("Received container event timestamp - %v, namespace - %q, topic - %q", e.Timestamp, e.Namespace, e.Topic)
-	c.handleEvent(e)
+	any, err := typeurl.UnmarshalAny(e.Event)
+	if err != nil {
+		glog.Errorf("Failed to convert event envelope %+v: %v", e, err)
+		return nil
+	}
+	dispatcher.dispatch(eventContainerID(any), any)
 	return nil
 }
 
-// handleEvent handles a containerd event.
-func (c *criContainerdService) handleEvent(evt *events.Envelope) {
-	any, err := typeurl.UnmarshalAny(evt.Event)
-	if err != nil {
-		glog.Errorf("Failed to convert event envelope %+v: %v", evt, err)
-		return
-	}
+// handleEvent handles a decoded containerd event.
+func (c *criContainerdService) handleEvent(any interface{}) {
 	switch any.(type) {
 	// If containerd-shim exits unexpectedly, there will be no corresponding event.
 	// However, containerd could not retrieve container state in that case, so it's
@@ -95,6 +214,8 @@ func (c *criContainerdService) handleEvent(evt *events.Envelope) {
 	case *events.TaskExit:
 		e := any.(*events.TaskExit)
 		glog.V(2).Infof("TaskExit event %+v", e)
+		// The exited task may be a sandbox container.
+		c.bumpSandboxStateGeneration()
 		cntr, err := c.containerStore.Get(e.ContainerID)
 		if err != nil {
 			glog.Errorf("Failed to get container %q: %v", e.ContainerID, err)
@@ -104,29 +225,26 @@ func (c *criContainerdService) handleEvent(evt *events.Envelope) {
 			// Non-init process died, ignore the event.
 			return
 		}
-		// Delete the container from containerd.
-		_, err = c.taskService.Delete(context.Background(), &tasks.DeleteTaskRequest{ContainerID: e.ContainerID})
-		// TODO(random-liu): Change isContainerdGRPCNotFoundError to use errdefs.
-		if err != nil && !isContainerdGRPCNotFoundError(err) {
-			// TODO(random-liu): [P0] Enqueue the event and retry.
-			glog.Errorf("Failed to delete container %q: %v", e.ContainerID, err)
-			return
-		}
-		err = cntr.Status.Update(func(status containerstore.Status) (containerstore.Status, error) {
-			// If FinishedAt has been set (e.g. with start failure), keep as
-			// it is.
-			if status.FinishedAt != 0 {
+		// Delete the container from containerd, and update the container
+		// state after the task is deleted. The deletion is retried by the
+		// task reaper if it fails.
+		if err := c.deleteTask(context.Background(), e.ContainerID, func() {
+			err := cntr.Status.Update(func(status containerstore.Status) (containerstore.Status, error) {
+				// If FinishedAt has been set (e.g. with start failure), keep as
+				// it is.
+				if status.FinishedAt != 0 {
+					return status, nil
+				}
+				status.Pid = 0
+				status.FinishedAt = e.ExitedAt.UnixNano()
+				status.ExitCode = int32(e.ExitStatus)
 				return status, nil
+			})
+			if err != nil {
+				glog.Errorf("Failed to update container %q state: %v", e.ContainerID, err)
 			}
-			status.Pid = 0
-			status.FinishedAt = e.ExitedAt.UnixNano()
-			status.ExitCode = int32(e.ExitStatus)
-			return status, nil
-		})
-		if err != nil {
-			glog.Errorf("Failed to update container %q state: %v", e.ContainerID, err)
-			// TODO(random-liu): [P0] Enqueue the event and retry.
-			return
+		}); err != nil {
+			glog.Errorf("Failed to delete container %q, retry in background: %v", e.ContainerID, err)
 		}
 	case *events.TaskOOM:
 		e := any.(*events.TaskOOM)
@@ -143,5 +261,10 @@ func (c *criContainerdService) handleEvent(evt *events.Envelope) {
 			glog.Errorf("Failed to update container %q oom: %v", e.ContainerID, err)
 			return
 		}
+		c.eventPublisher.publish(containerOOMKilledTopic, &containerOOMKilledEvent{
+			ContainerID: e.ContainerID,
+			SandboxID:   cntr.SandboxID,
+			Name:        cntr.Name,
+		})
 	}
 }
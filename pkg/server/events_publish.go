@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"time"
+
+	"github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/typeurl"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+const (
+	// Topics of cri events published into the containerd event service.
+	sandboxCreatedTopic      = "/cri/sandbox/create"
+	containerOOMKilledTopic  = "/cri/container/oom"
+	imageGarbageCollectTopic = "/cri/image/gc"
+	// publishQueueSize is the number of events queued for publishing. Events are
+	// dropped when the queue is full, so that cri requests never block on the
+	// containerd event service.
+	publishQueueSize = 256
+	// publishTimeout is the timeout of publishing one event.
+	publishTimeout = 10 * time.Second
+)
+
+// sandboxCreatedEvent is published after a sandbox is created and started.
+type sandboxCreatedEvent struct {
+	SandboxID string `json:"sandbox_id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	UID       string `json:"uid"`
+}
+
+// containerOOMKilledEvent is published when a container is killed by the oom killer.
+type containerOOMKilledEvent struct {
+	ContainerID string `json:"container_id"`
+	SandboxID   string `json:"sandbox_id"`
+	Name        string `json:"name"`
+}
+
+// imageGarbageCollectedEvent is published after an image is removed by image
+// garbage collection.
+type imageGarbageCollectedEvent struct {
+	ImageID  string   `json:"image_id"`
+	RepoTags []string `json:"repo_tags"`
+	Size     int64    `json:"size"`
+}
+
+func init() {
+	// The events are not protobuf messages, they are encoded as json with
+	// these type urls.
+	typeurl.Register(&sandboxCreatedEvent{}, "cri-containerd", "SandboxCreated")
+	typeurl.Register(&containerOOMKilledEvent{}, "cri-containerd", "ContainerOOMKilled")
+	typeurl.Register(&imageGarbageCollectedEvent{}, "cri-containerd", "ImageGarbageCollected")
+}
+
+// pendingEvent is an event waiting to be published.
+type pendingEvent struct {
+	timestamp time.Time
+	topic     string
+	event     interface{}
+}
+
+// eventPublisher queues cri events to be published into the containerd event
+// service in the background.
+type eventPublisher struct {
+	queue   chan pendingEvent
+	dropped *metrics.Counter
+}
+
+func newEventPublisher(dropped *metrics.Counter) *eventPublisher {
+	return &eventPublisher{
+		queue:   make(chan pendingEvent, publishQueueSize),
+		dropped: dropped,
+	}
+}
+
+// publish queues the event, it never blocks.
+func (p *eventPublisher) publish(topic string, event interface{}) {
+	select {
+	case p.queue <- pendingEvent{timestamp: time.Now(), topic: topic, event: event}:
+	default:
+		glog.Warningf("Event publish queue is full, drop event %q %+v", topic, event)
+		p.dropped.Inc()
+	}
+}
+
+// runEventPublisher publishes queued events into the containerd event service,
+// it never returns.
+func (c *criContainerdService) runEventPublisher() {
+	for e := range c.eventPublisher.queue {
+		if err := c.publishEvent(e); err != nil {
+			glog.Errorf("Failed to publish event %q %+v: %v", e.topic, e.event, err)
+			c.eventPublisher.dropped.Inc()
+		}
+	}
+}
+
+// publishEvent publishes the event under the k8s.io namespace.
+func (c *criContainerdService) publishEvent(e pendingEvent) error {
+	any, err := typeurl.MarshalAny(e.event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	_, err = c.eventService.Publish(ctx, &events.PublishRequest{
+		Envelope: &events.Envelope{
+			Timestamp: e.timestamp,
+			Namespace: k8sContainerdNamespace,
+			Topic:     e.topic,
+			Event:     any,
+		},
+	})
+	return err
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/typeurl"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// fakePublishEventService records published events.
+type fakePublishEventService struct {
+	events.EventsClient
+	published []*events.Envelope
+	err       error
+}
+
+func (f *fakePublishEventService) Publish(ctx context.Context, in *events.PublishRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.published = append(f.published, in.Envelope)
+	return &empty.Empty{}, nil
+}
+
+func TestEventPublisherQueueFull(t *testing.T) {
+	c := newTestCRIContainerdService()
+	for i := 0; i < publishQueueSize; i++ {
+		c.eventPublisher.publish(sandboxCreatedTopic, &sandboxCreatedEvent{SandboxID: "test-id"})
+	}
+	assert.EqualValues(t, 0, c.eventPublisher.dropped.Value())
+	c.eventPublisher.publish(sandboxCreatedTopic, &sandboxCreatedEvent{SandboxID: "test-id"})
+	assert.EqualValues(t, 1, c.eventPublisher.dropped.Value())
+	assert.Len(t, c.eventPublisher.queue, publishQueueSize)
+}
+
+func TestPublishEvent(t *testing.T) {
+	for desc, test := range map[string]struct {
+		topic      string
+		event      interface{}
+		publishErr error
+		expectErr  bool
+	}{
+		"sandbox created event should be published": {
+			topic: sandboxCreatedTopic,
+			event: &sandboxCreatedEvent{SandboxID: "test-id", Name: "test-name", Namespace: "test-ns", UID: "test-uid"},
+		},
+		"container oom killed event should be published": {
+			topic: containerOOMKilledTopic,
+			event: &containerOOMKilledEvent{ContainerID: "test-id", SandboxID: "test-sandbox-id", Name: "test-name"},
+		},
+		"image garbage collected event should be published": {
+			topic: imageGarbageCollectTopic,
+			event: &imageGarbageCollectedEvent{ImageID: "sha256:test", RepoTags: []string{"busybox:latest"}, Size: 10},
+		},
+		"publish error should be returned": {
+			topic:      sandboxCreatedTopic,
+			event:      &sandboxCreatedEvent{SandboxID: "test-id"},
+			publishErr: errors.New("publish error"),
+			expectErr:  true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		fakeEvents := &fakePublishEventService{err: test.publishErr}
+		c.eventService = fakeEvents
+		c.eventPublisher.publish(test.topic, test.event)
+		require.Len(t, c.eventPublisher.queue, 1)
+		err := c.publishEvent(<-c.eventPublisher.queue)
+		if test.expectErr {
+			assert.Error(t, err)
+			assert.Empty(t, fakeEvents.published)
+			continue
+		}
+		require.NoError(t, err)
+		require.Len(t, fakeEvents.published, 1)
+		envelope := fakeEvents.published[0]
+		assert.Equal(t, k8sContainerdNamespace, envelope.Namespace)
+		assert.Equal(t, test.topic, envelope.Topic)
+		assert.False(t, envelope.Timestamp.IsZero())
+		event, err := typeurl.UnmarshalAny(envelope.Event)
+		require.NoError(t, err)
+		assert.Equal(t, test.event, event)
+	}
+}
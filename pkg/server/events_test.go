@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/services/events/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+func TestEventContainerID(t *testing.T) {
+	assert.Equal(t, "c-1", eventContainerID(&events.TaskExit{ContainerID: "c-1"}))
+	assert.Equal(t, "c-2", eventContainerID(&events.TaskOOM{ContainerID: "c-2"}))
+	assert.Equal(t, "", eventContainerID(&events.TaskCreate{ContainerID: "c-3"}))
+}
+
+func TestEventDispatcherOrdering(t *testing.T) {
+	const containers, eventsPerContainer = 5, 50
+	var (
+		mu      sync.Mutex
+		handled = make(map[string][]int)
+		wg      sync.WaitGroup
+	)
+	backlog := metrics.NewGauge("test_backlog", "test")
+	d := newEventDispatcher(3, func(e interface{}) {
+		defer wg.Done()
+		exit := e.(*events.TaskExit)
+		mu.Lock()
+		defer mu.Unlock()
+		handled[exit.ContainerID] = append(handled[exit.ContainerID], int(exit.Pid))
+	}, backlog)
+	d.start()
+	for i := 0; i < eventsPerContainer; i++ {
+		for j := 0; j < containers; j++ {
+			id := fmt.Sprintf("container-%d", j)
+			wg.Add(1)
+			d.dispatch(id, &events.TaskExit{ContainerID: id, Pid: uint32(i)})
+		}
+	}
+	wg.Wait()
+	require.Len(t, handled, containers)
+	for id, pids := range handled {
+		require.Len(t, pids, eventsPerContainer, id)
+		for i, pid := range pids {
+			assert.Equal(t, i, pid, "events of %q should be handled in order", id)
+		}
+	}
+	// The backlog is decreased after the handler returns.
+	for i := 0; i < 100 && backlog.Value() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, float64(0), backlog.Value())
+}
+
+func TestEventDispatcherIsolation(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	handled := make(chan string, 1)
+	d := newEventDispatcher(2, func(e interface{}) {
+		id := eventContainerID(e)
+		if id == "slow" {
+			<-block
+			return
+		}
+		handled <- id
+	}, metrics.NewGauge("test_backlog", "test"))
+	d.start()
+	// Find a container handled by a different worker from the slow one.
+	fast := ""
+	for i := 0; fast == ""; i++ {
+		if id := fmt.Sprintf("fast-%d", i); d.worker(id) != d.worker("slow") {
+			fast = id
+		}
+	}
+	d.dispatch("slow", &events.TaskExit{ContainerID: "slow"})
+	d.dispatch(fast, &events.TaskExit{ContainerID: fast})
+	select {
+	case id := <-handled:
+		assert.Equal(t, fast, id)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("event of %q should not be delayed by a slow event on another worker", fast)
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Failpoints at key phases of sandbox and container creation. They are only
+// evaluated when cri-containerd is built with the `failpoint` build tag, so
+// that tests could verify cleanup and recovery paths deterministically.
+const (
+	// failpointSnapshotPrepared is evaluated after the rootfs snapshot is prepared.
+	failpointSnapshotPrepared = "snapshot-prepared"
+	// failpointCNIAdded is evaluated after the sandbox network is set up.
+	failpointCNIAdded = "cni-added"
+	// failpointBeforeTaskStart is evaluated before the containerd task is started.
+	failpointBeforeTaskStart = "before-task-start"
+)
+
+// failpointNames are names of all failpoints.
+var failpointNames = map[string]bool{
+	failpointSnapshotPrepared: true,
+	failpointCNIAdded:         true,
+	failpointBeforeTaskStart:  true,
+}
+
+// Actions of failpoints.
+const (
+	// failpointActionError makes the failpoint return an error.
+	failpointActionError = "error"
+	// failpointActionDelay makes the failpoint sleep for a while.
+	failpointActionDelay = "delay"
+)
+
+// failpoint is an armed failpoint.
+type failpoint struct {
+	// Name is the name of the failpoint.
+	Name string `json:"name"`
+	// Action is the action when the failpoint is evaluated, either error or delay.
+	Action string `json:"action"`
+	// Delay is the sleep time of delay action.
+	Delay time.Duration `json:"delay,omitempty"`
+	// Count is the remaining times the failpoint fires. 0 means unlimited.
+	Count int `json:"count,omitempty"`
+}
+
+// failpointRegistry keeps armed failpoints.
+type failpointRegistry struct {
+	sync.Mutex
+	armed map[string]*failpoint
+}
+
+// newFailpointRegistry creates a failpoint registry without armed failpoint.
+func newFailpointRegistry() *failpointRegistry {
+	return &failpointRegistry{armed: make(map[string]*failpoint)}
+}
+
+// arm validates and arms the failpoint.
+func (r *failpointRegistry) arm(fp failpoint) error {
+	if !failpointNames[fp.Name] {
+		return fmt.Errorf("unknown failpoint %q", fp.Name)
+	}
+	switch fp.Action {
+	case failpointActionError:
+	case failpointActionDelay:
+		if fp.Delay <= 0 {
+			return fmt.Errorf("delay of failpoint %q must be positive", fp.Name)
+		}
+	default:
+		return fmt.Errorf("unknown failpoint action %q", fp.Action)
+	}
+	if fp.Count < 0 {
+		return fmt.Errorf("count of failpoint %q must not be negative", fp.Name)
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.armed[fp.Name] = &fp
+	return nil
+}
+
+// disarm disarms the failpoint. All failpoints are disarmed if name is empty.
+func (r *failpointRegistry) disarm(name string) {
+	r.Lock()
+	defer r.Unlock()
+	if name == "" {
+		r.armed = make(map[string]*failpoint)
+		return
+	}
+	delete(r.armed, name)
+}
+
+// list returns armed failpoints sorted by name.
+func (r *failpointRegistry) list() []failpoint {
+	r.Lock()
+	defer r.Unlock()
+	fps := []failpoint{}
+	for _, fp := range r.armed {
+		fps = append(fps, *fp)
+	}
+	sort.Slice(fps, func(i, j int) bool { return fps[i].Name < fps[j].Name })
+	return fps
+}
+
+// eval evaluates the failpoint. It is a no-op unless cri-containerd is
+// built with the `failpoint` build tag.
+func (r *failpointRegistry) eval(name string) error {
+	if !failpointsEnabled {
+		return nil
+	}
+	return r.fire(name)
+}
+
+// fire runs the action of the failpoint if it is armed.
+func (r *failpointRegistry) fire(name string) error {
+	r.Lock()
+	armed, ok := r.armed[name]
+	if !ok {
+		r.Unlock()
+		return nil
+	}
+	fp := *armed
+	if armed.Count > 0 {
+		armed.Count--
+		if armed.Count == 0 {
+			delete(r.armed, name)
+		}
+	}
+	r.Unlock()
+
+	glog.Warningf("Failpoint %q fires with action %q", name, fp.Action)
+	if fp.Action == failpointActionDelay {
+		time.Sleep(fp.Delay)
+		return nil
+	}
+	return fmt.Errorf("failpoint %q injected error", name)
+}
+
+// handleFailpoints handles the failpoints debug endpoint. GET lists armed
+// failpoints, POST arms the failpoint with query parameters `name`, `action`,
+// `delay` and `count`, and DELETE disarms the failpoint `name`, or all
+// failpoints if name is not specified.
+func (c *criContainerdService) handleFailpoints(w http.ResponseWriter, r *http.Request) {
+	if !failpointsEnabled {
+		http.Error(w, "failpoints are not enabled, build cri-containerd with the failpoint build tag",
+			http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		fp := failpoint{
+			Name:   query.Get("name"),
+			Action: query.Get("action"),
+		}
+		if fp.Action == "" {
+			fp.Action = failpointActionError
+		}
+		if delay := query.Get("delay"); delay != "" {
+			d, err := time.ParseDuration(delay)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid delay %q: %v", delay, err), http.StatusBadRequest)
+				return
+			}
+			fp.Delay = d
+		}
+		if count := query.Get("count"); count != "" {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid count %q: %v", count, err), http.StatusBadRequest)
+				return
+			}
+			fp.Count = n
+		}
+		if err := c.failpoints.arm(fp); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		c.failpoints.disarm(query.Get("name"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, c.failpoints.list())
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailpointArm(t *testing.T) {
+	for desc, test := range map[string]struct {
+		fp        failpoint
+		expectErr bool
+	}{
+		"error failpoint": {
+			fp: failpoint{Name: failpointSnapshotPrepared, Action: failpointActionError},
+		},
+		"delay failpoint": {
+			fp: failpoint{Name: failpointCNIAdded, Action: failpointActionDelay, Delay: time.Second, Count: 1},
+		},
+		"unknown failpoint": {
+			fp:        failpoint{Name: "unknown", Action: failpointActionError},
+			expectErr: true,
+		},
+		"unknown action": {
+			fp:        failpoint{Name: failpointBeforeTaskStart, Action: "panic"},
+			expectErr: true,
+		},
+		"delay without duration": {
+			fp:        failpoint{Name: failpointBeforeTaskStart, Action: failpointActionDelay},
+			expectErr: true,
+		},
+		"negative count": {
+			fp:        failpoint{Name: failpointBeforeTaskStart, Action: failpointActionError, Count: -1},
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		r := newFailpointRegistry()
+		err := r.arm(test.fp)
+		if test.expectErr {
+			assert.Error(t, err)
+			assert.Empty(t, r.list())
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, []failpoint{test.fp}, r.list())
+	}
+}
+
+func TestFailpointFire(t *testing.T) {
+	r := newFailpointRegistry()
+	require.NoError(t, r.arm(failpoint{Name: failpointSnapshotPrepared, Action: failpointActionError, Count: 2}))
+	require.NoError(t, r.arm(failpoint{Name: failpointCNIAdded, Action: failpointActionDelay, Delay: time.Millisecond}))
+
+	t.Logf("failpoint should fire until count runs out")
+	assert.Error(t, r.fire(failpointSnapshotPrepared))
+	assert.Error(t, r.fire(failpointSnapshotPrepared))
+	assert.NoError(t, r.fire(failpointSnapshotPrepared))
+
+	t.Logf("delay failpoint should not return error")
+	assert.NoError(t, r.fire(failpointCNIAdded))
+	assert.Equal(t, []failpoint{
+		{Name: failpointCNIAdded, Action: failpointActionDelay, Delay: time.Millisecond},
+	}, r.list())
+
+	t.Logf("disarmed failpoint should not fire")
+	require.NoError(t, r.arm(failpoint{Name: failpointBeforeTaskStart, Action: failpointActionError}))
+	r.disarm(failpointBeforeTaskStart)
+	assert.NoError(t, r.fire(failpointBeforeTaskStart))
+	r.disarm("")
+	assert.Empty(t, r.list())
+
+	t.Logf("failpoint should only be evaluated when enabled")
+	require.NoError(t, r.arm(failpoint{Name: failpointBeforeTaskStart, Action: failpointActionError}))
+	assert.Equal(t, failpointsEnabled, r.eval(failpointBeforeTaskStart) != nil)
+}
+
+func TestHandleFailpoints(t *testing.T) {
+	for desc, test := range map[string]struct {
+		method       string
+		query        string
+		expectStatus int
+		expectArmed  []failpoint
+	}{
+		"list failpoints": {
+			method:       http.MethodGet,
+			expectStatus: http.StatusOK,
+			expectArmed: []failpoint{
+				{Name: failpointCNIAdded, Action: failpointActionError},
+			},
+		},
+		"arm failpoint": {
+			method:       http.MethodPost,
+			query:        "name=before-task-start&action=delay&delay=2s&count=3",
+			expectStatus: http.StatusOK,
+			expectArmed: []failpoint{
+				{Name: failpointBeforeTaskStart, Action: failpointActionDelay, Delay: 2 * time.Second, Count: 3},
+				{Name: failpointCNIAdded, Action: failpointActionError},
+			},
+		},
+		"arm failpoint with default action": {
+			method:       http.MethodPost,
+			query:        "name=snapshot-prepared",
+			expectStatus: http.StatusOK,
+			expectArmed: []failpoint{
+				{Name: failpointCNIAdded, Action: failpointActionError},
+				{Name: failpointSnapshotPrepared, Action: failpointActionError},
+			},
+		},
+		"arm failpoint with invalid delay": {
+			method:       http.MethodPost,
+			query:        "name=snapshot-prepared&action=delay&delay=soon",
+			expectStatus: http.StatusBadRequest,
+			expectArmed: []failpoint{
+				{Name: failpointCNIAdded, Action: failpointActionError},
+			},
+		},
+		"arm unknown failpoint": {
+			method:       http.MethodPost,
+			query:        "name=unknown",
+			expectStatus: http.StatusBadRequest,
+			expectArmed: []failpoint{
+				{Name: failpointCNIAdded, Action: failpointActionError},
+			},
+		},
+		"disarm failpoint": {
+			method:       http.MethodDelete,
+			query:        "name=cni-added",
+			expectStatus: http.StatusOK,
+			expectArmed:  []failpoint{},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		require.NoError(t, c.failpoints.arm(failpoint{Name: failpointCNIAdded, Action: failpointActionError}))
+		w := httptest.NewRecorder()
+		c.handleFailpoints(w, httptest.NewRequest(test.method, "/failpoints?"+test.query, nil))
+		if !failpointsEnabled {
+			assert.Equal(t, http.StatusNotImplemented, w.Code)
+			continue
+		}
+		assert.Equal(t, test.expectStatus, w.Code)
+		assert.Equal(t, test.expectArmed, c.failpoints.list())
+	}
+}
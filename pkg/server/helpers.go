@@ -36,6 +36,8 @@ import (
 	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/spec"
 	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
 	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
 )
@@ -57,16 +59,8 @@ const (
 )
 
 const (
-	// defaultSandboxImage is the image used by sandbox container.
-	defaultSandboxImage = "gcr.io/google_containers/pause:3.0"
-	// defaultSandboxOOMAdj is default omm adj for sandbox container. (kubernetes#47938).
-	defaultSandboxOOMAdj = -998
-	// defaultSandboxCPUshares is default cpu shares for sandbox container.
-	defaultSandboxCPUshares = 2
 	// defaultShmSize is the default size of the sandbox shm.
 	defaultShmSize = int64(1024 * 1024 * 64)
-	// relativeRootfsPath is the rootfs path relative to bundle path.
-	relativeRootfsPath = "rootfs"
 	// defaultRuntime is the runtime to use in containerd. We may support
 	// other runtime in the future.
 	defaultRuntime = "io.containerd.runtime.v1.linux"
@@ -76,6 +70,9 @@ const (
 	sandboxesDir = "sandboxes"
 	// containersDir contains all container root.
 	containersDir = "containers"
+	// containerStatusFile is the file in the container root directory which
+	// the container status is checkpointed into.
+	containerStatusFile = "status"
 	// According to http://man7.org/linux/man-pages/man5/resolv.conf.5.html:
 	// "The search list is currently limited to six domains with a total of 256 characters."
 	maxDNSSearches = 6
@@ -89,18 +86,18 @@ const (
 	nameDelimiter = "_"
 	// netNSFormat is the format of network namespace of a process.
 	netNSFormat = "/proc/%v/ns/net"
-	// ipcNSFormat is the format of ipc namespace of a process.
-	ipcNSFormat = "/proc/%v/ns/ipc"
-	// utsNSFormat is the format of uts namespace of a process.
-	utsNSFormat = "/proc/%v/ns/uts"
-	// pidNSFormat is the format of pid namespace of a process.
-	pidNSFormat = "/proc/%v/ns/pid"
 	// devShm is the default path of /dev/shm.
 	devShm = "/dev/shm"
 	// etcHosts is the default path of /etc/hosts file.
 	etcHosts = "/etc/hosts"
 	// resolvConfPath is the abs path of resolv.conf on host or container.
 	resolvConfPath = "/etc/resolv.conf"
+	// networkDSCPAnnotationKey is the sandbox annotation key to set the dscp
+	// value (0-63) of the pod egress traffic.
+	networkDSCPAnnotationKey = "io.kubernetes.cri-containerd.network-dscp"
+	// networkPriorityAnnotationKey is the sandbox annotation key to classify the
+	// pod egress traffic into a tc class, e.g. "1:10".
+	networkPriorityAnnotationKey = "io.kubernetes.cri-containerd.network-priority"
 )
 
 // generateID generates a random unique id.
@@ -134,8 +131,7 @@ func makeContainerName(c *runtime.ContainerMetadata, s *runtime.PodSandboxMetada
 
 // getCgroupsPath generates container cgroups path.
 func getCgroupsPath(cgroupsParent string, id string) string {
-	// TODO(random-liu): [P0] Handle systemd.
-	return filepath.Join(cgroupsParent, id)
+	return spec.CgroupsPath(cgroupsParent, id)
 }
 
 // getSandboxRootDir returns the root directory for managing sandbox files,
@@ -149,6 +145,11 @@ func getContainerRootDir(rootDir, id string) string {
 	return filepath.Join(rootDir, containersDir, id)
 }
 
+// getContainerStatusPath returns the path of the container status checkpoint.
+func getContainerStatusPath(rootDir, id string) string {
+	return filepath.Join(getContainerRootDir(rootDir, id), containerStatusFile)
+}
+
 // getStreamingPipes returns the stdin/stdout/stderr pipes path in the
 // container/sandbox root.
 func getStreamingPipes(rootDir string) (string, string, string) {
@@ -194,12 +195,13 @@ func (c *criContainerdService) prepareStreamingPipes(ctx context.Context, stdin,
 			return nil, nil, nil, fmt.Errorf("failed to open named pipe %q: %v",
 				stream.path, err)
 		}
+		f := newTrackedFifo(s, c.metrics.openFifos)
 		defer func(cl io.Closer) {
 			if retErr != nil {
 				cl.Close()
 			}
-		}(s)
-		pipes[t] = s
+		}(f)
+		pipes[t] = f
 	}
 	return pipes["stdin"], pipes["stdout"], pipes["stderr"], nil
 }
@@ -209,19 +211,15 @@ func getNetworkNamespace(pid uint32) string {
 	return fmt.Sprintf(netNSFormat, pid)
 }
 
-// getIPCNamespace returns the ipc namespace of a process.
-func getIPCNamespace(pid uint32) string {
-	return fmt.Sprintf(ipcNSFormat, pid)
-}
-
-// getUTSNamespace returns the uts namespace of a process.
-func getUTSNamespace(pid uint32) string {
-	return fmt.Sprintf(utsNSFormat, pid)
-}
-
-// getPIDNamespace returns the pid namespace of a process.
-func getPIDNamespace(pid uint32) string {
-	return fmt.Sprintf(pidNSFormat, pid)
+// getPodNetwork returns the pod network of a sandbox.
+func getPodNetwork(id, netNS string, config *runtime.PodSandboxConfig) netplugin.PodNetwork {
+	return netplugin.PodNetwork{
+		Name:        config.GetMetadata().GetName(),
+		Namespace:   config.GetMetadata().GetNamespace(),
+		ID:          id,
+		NetNS:       netNS,
+		Annotations: config.GetAnnotations(),
+	}
 }
 
 // isContainerdGRPCNotFoundError checks whether a grpc error is not found error.
@@ -270,46 +268,46 @@ func normalizeImageRef(ref string) (reference.Named, error) {
 	return reference.TagNameOnly(named), nil
 }
 
-// getImageInfo returns image chainID, compressed size and oci config. Note that getImageInfo
-// assumes that the image has been pulled or it will return an error.
+// getImageInfo returns image chainID, compressed size, target digest and oci config. Note that
+// getImageInfo assumes that the image has been pulled or it will return an error.
 func (c *criContainerdService) getImageInfo(ctx context.Context, ref string) (
-	imagedigest.Digest, int64, *imagespec.ImageConfig, error) {
+	imagedigest.Digest, int64, imagedigest.Digest, *imagespec.ImageConfig, error) {
 	normalized, err := normalizeImageRef(ref)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("failed to normalize image reference %q: %v", ref, err)
+		return "", 0, "", nil, fmt.Errorf("failed to normalize image reference %q: %v", ref, err)
 	}
 	normalizedRef := normalized.String()
 	image, err := c.imageStoreService.Get(ctx, normalizedRef)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("failed to get image %q from containerd image store: %v",
+		return "", 0, "", nil, fmt.Errorf("failed to get image %q from containerd image store: %v",
 			normalizedRef, err)
 	}
 	// Get image config
 	desc, err := image.Config(ctx, c.contentStoreService)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("failed to get image config descriptor: %v", err)
+		return "", 0, "", nil, fmt.Errorf("failed to get image config descriptor: %v", err)
 	}
 	rc, err := c.contentStoreService.Reader(ctx, desc.Digest)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("failed to get image config reader: %v", err)
+		return "", 0, "", nil, fmt.Errorf("failed to get image config reader: %v", err)
 	}
 	defer rc.Close()
 	var imageConfig imagespec.Image
 	if err = json.NewDecoder(rc).Decode(&imageConfig); err != nil {
-		return "", 0, nil, fmt.Errorf("failed to decode image config: %v", err)
+		return "", 0, "", nil, fmt.Errorf("failed to decode image config: %v", err)
 	}
 	// Get image chainID
 	diffIDs, err := image.RootFS(ctx, c.contentStoreService)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("failed to get image diff ids: %v", err)
+		return "", 0, "", nil, fmt.Errorf("failed to get image diff ids: %v", err)
 	}
 	chainID := identity.ChainID(diffIDs)
 	// Get image size
 	size, err := image.Size(ctx, c.contentStoreService)
 	if err != nil {
-		return "", 0, nil, fmt.Errorf("failed to get image size: %v", err)
+		return "", 0, "", nil, fmt.Errorf("failed to get image size: %v", err)
 	}
-	return chainID, size, &imageConfig.Config, nil
+	return chainID, size, image.Target.Digest, &imageConfig.Config, nil
 }
 
 // getRepoDigestAngTag returns image repoDigest and repoTag of the named image reference.
@@ -345,11 +343,20 @@ func (c *criContainerdService) localResolve(ctx context.Context, ref string) (*i
 			return nil, fmt.Errorf("an error occurred when getting image %q from containerd image store: %v",
 				normalized.String(), err)
 		}
+		// The target is content addressed, so the image resolved from it is
+		// cached at pull time to avoid reading the manifest from the content
+		// store on every container creation.
+		target := imageInContainerd.Target.Digest.String()
+		if image, err := c.imageStore.GetByTarget(target); err == nil {
+			return &image, nil
+		}
 		desc, err := imageInContainerd.Config(ctx, c.contentStoreService)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get image config descriptor: %v", err)
 		}
 		ref = desc.Digest.String()
+		// Ignore the error if the image is not in the image store.
+		c.imageStore.AddTarget(ref, target) // nolint: errcheck
 	}
 	imageID := ref
 	image, err := c.imageStore.Get(imageID)
@@ -404,3 +411,13 @@ func (c *criContainerdService) ensureImageExists(ctx context.Context, ref string
 	}
 	return &newImage, nil
 }
+
+// inStringSlice checks whether a string is inside a string slice.
+func inStringSlice(ss []string, str string) bool {
+	for _, s := range ss {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
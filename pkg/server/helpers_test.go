@@ -26,6 +26,7 @@ import (
 	"github.com/containerd/containerd/reference"
 	imagedigest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 
 	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
@@ -255,3 +256,32 @@ func TestGetRepoDigestAndTag(t *testing.T) {
 		assert.Equal(t, test.expectedRepoTag, repoTag)
 	}
 }
+
+func TestLocalResolveByTarget(t *testing.T) {
+	c, blobs, config, _ := newTestVerifyImageService(t)
+	ctx := context.Background()
+	imageID := config.Digest.String()
+
+	t.Logf("should resolve reference from content store and cache the target")
+	image, err := c.localResolve(ctx, "busybox")
+	require.NoError(t, err)
+	require.NotNil(t, image)
+	assert.Equal(t, imageID, image.ID)
+	stored, err := c.imageStore.Get(imageID)
+	require.NoError(t, err)
+	assert.Len(t, stored.Targets, 1)
+
+	t.Logf("should resolve reference from cached target without reading content store")
+	for dgst := range blobs.blobs {
+		delete(blobs.blobs, dgst)
+	}
+	resolved, err := c.localResolve(ctx, "busybox")
+	require.NoError(t, err)
+	require.NotNil(t, resolved)
+	assert.Equal(t, imageID, resolved.ID)
+
+	t.Logf("should fall back to content store after image is removed from image store")
+	c.imageStore.Delete(imageID)
+	_, err = c.localResolve(ctx, "busybox")
+	assert.Error(t, err)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/containerd/containerd/containers"
+	prototypes "github.com/gogo/protobuf/types"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// Stages of oci hooks.
+const (
+	hookStagePrestart  = "prestart"
+	hookStagePoststart = "poststart"
+	hookStagePoststop  = "poststop"
+)
+
+var (
+	// hookFailurePattern matches the runtime error of a failed oci hook, e.g.
+	// `running prestart hook 0 caused \"error running hook: exit status 1, stdout: , stderr: boom\"`.
+	hookFailurePattern = regexp.MustCompile(`running (prestart|poststart|poststop) hook (\d+)`)
+	// hookOutputPattern matches the exit status and output of the failed oci hook.
+	hookOutputPattern = regexp.MustCompile(`error running hook: (.*?)(\\*"|$)`)
+)
+
+// hookError is the failure of an oci hook of a container.
+type hookError struct {
+	// Stage is the stage of the hook, e.g. prestart.
+	Stage string
+	// Index is the index of the hook in the stage.
+	Index int
+	// Name is the name of the hook binary.
+	Name string
+	// Output is the exit status and output of the hook.
+	Output string
+}
+
+// Error returns the error message.
+func (e *hookError) Error() string {
+	return fmt.Sprintf("%s hook %q failed: %s", e.Stage, e.Name, e.Output)
+}
+
+// parseHookError checks whether the runtime error is caused by an oci hook,
+// and returns the hook failure. The hook name is looked up in hooks, which
+// could be nil.
+func parseHookError(err error, hooks *runtimespec.Hooks) (*hookError, bool) {
+	match := hookFailurePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil, false
+	}
+	index, _ := strconv.Atoi(match[2])
+	e := &hookError{
+		Stage:  match[1],
+		Index:  index,
+		Name:   "hook " + match[2],
+		Output: err.Error(),
+	}
+	if output := hookOutputPattern.FindStringSubmatch(err.Error()); output != nil {
+		e.Output = output[1]
+	}
+	if stageHooks := getStageHooks(hooks, e.Stage); index < len(stageHooks) {
+		e.Name = filepath.Base(stageHooks[index].Path)
+	}
+	return e, true
+}
+
+// getStageHooks returns the hooks of the stage.
+func getStageHooks(hooks *runtimespec.Hooks, stage string) []runtimespec.Hook {
+	if hooks == nil {
+		return nil
+	}
+	switch stage {
+	case hookStagePrestart:
+		return hooks.Prestart
+	case hookStagePoststart:
+		return hooks.Poststart
+	case hookStagePoststop:
+		return hooks.Poststop
+	}
+	return nil
+}
+
+// toHookError converts a runtime error of the container into a cri error
+// with HookError reason if it is caused by an oci hook. Other errors are
+// returned as is, with nil hook failure.
+func (c *criContainerdService) toHookError(ctx context.Context, id string, err error) (*hookError, error) {
+	spec, specErr := c.getContainerSpec(ctx, id)
+	var hooks *runtimespec.Hooks
+	if specErr == nil {
+		hooks = spec.Hooks
+	}
+	hookErr, ok := parseHookError(err, hooks)
+	if !ok {
+		return nil, err
+	}
+	return hookErr, newCRIError(codes.Unknown, ReasonHookError, "%v", hookErr)
+}
+
+// removeContainerHooks removes hooks of the stage from the containerd
+// container spec.
+func (c *criContainerdService) removeContainerHooks(ctx context.Context, id string, stage string) error {
+	spec, err := c.getContainerSpec(ctx, id)
+	if err != nil {
+		return err
+	}
+	if spec.Hooks == nil {
+		return nil
+	}
+	switch stage {
+	case hookStagePrestart:
+		spec.Hooks.Prestart = nil
+	case hookStagePoststart:
+		spec.Hooks.Poststart = nil
+	case hookStagePoststop:
+		spec.Hooks.Poststop = nil
+	}
+	rawSpec, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal oci spec %+v: %v", spec, err)
+	}
+	if _, err := c.containerService.Update(ctx, containers.Container{
+		ID:   id,
+		Spec: &prototypes.Any{TypeUrl: runtimespec.Version, Value: rawSpec},
+	}, "spec"); err != nil {
+		return fmt.Errorf("failed to update containerd container %q spec: %v", id, err)
+	}
+	return nil
+}
+
+// getContainerSpec returns the oci spec of the containerd container.
+func (c *criContainerdService) getContainerSpec(ctx context.Context, id string) (*runtimespec.Spec, error) {
+	container, err := c.containerService.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get containerd container %q: %v", id, err)
+	}
+	if container.Spec == nil {
+		return nil, fmt.Errorf("containerd container %q has no spec", id)
+	}
+	var spec runtimespec.Spec
+	if err := json.Unmarshal(container.Spec.Value, &spec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal containerd container %q spec: %v", id, err)
+	}
+	return &spec, nil
+}
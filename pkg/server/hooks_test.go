@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+	prototypes "github.com/gogo/protobuf/types"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseHookError(t *testing.T) {
+	hooks := &runtimespec.Hooks{
+		Prestart: []runtimespec.Hook{
+			{Path: "/usr/bin/nvidia-container-runtime-hook"},
+			{Path: "/usr/local/bin/netns-hook"},
+		},
+	}
+	for desc, test := range map[string]struct {
+		err       error
+		hooks     *runtimespec.Hooks
+		expectErr *hookError
+	}{
+		"non hook error": {
+			err:   errors.New("oci runtime error: container_linux.go:265: starting container process caused \"exec: \\\"sh\\\": executable file not found in $PATH\""),
+			hooks: hooks,
+		},
+		"prestart hook error": {
+			err:   errors.New("oci runtime error: container_linux.go:265: starting container process caused \"process_linux.go:348: running prestart hook 1 caused \\\"error running hook: exit status 1, stdout: , stderr: no netns\\\"\""),
+			hooks: hooks,
+			expectErr: &hookError{
+				Stage:  hookStagePrestart,
+				Index:  1,
+				Name:   "netns-hook",
+				Output: "exit status 1, stdout: , stderr: no netns",
+			},
+		},
+		"poststart hook error without hooks": {
+			err: errors.New("running poststart hook 0 caused \"error running hook: signal: killed, stdout: , stderr: \""),
+			expectErr: &hookError{
+				Stage:  hookStagePoststart,
+				Index:  0,
+				Name:   "hook 0",
+				Output: "signal: killed, stdout: , stderr: ",
+			},
+		},
+		"hook error without output": {
+			err:   errors.New("running prestart hook 0 caused \"timeout\""),
+			hooks: hooks,
+			expectErr: &hookError{
+				Stage:  hookStagePrestart,
+				Index:  0,
+				Name:   "nvidia-container-runtime-hook",
+				Output: "running prestart hook 0 caused \"timeout\"",
+			},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		hookErr, ok := parseHookError(test.err, test.hooks)
+		assert.Equal(t, test.expectErr != nil, ok)
+		assert.Equal(t, test.expectErr, hookErr)
+	}
+}
+
+func TestToHookError(t *testing.T) {
+	const testID = "test-id"
+	c, _, containerStore := newTestSandboxImageService()
+	spec := &runtimespec.Spec{Hooks: &runtimespec.Hooks{
+		Prestart:  []runtimespec.Hook{{Path: "/bin/prestart-hook"}},
+		Poststart: []runtimespec.Hook{{Path: "/bin/poststart-hook"}},
+	}}
+	rawSpec, err := json.Marshal(spec)
+	require.NoError(t, err)
+	_, err = containerStore.Create(context.Background(), containers.Container{
+		ID:   testID,
+		Spec: &prototypes.Any{TypeUrl: runtimespec.Version, Value: rawSpec},
+	})
+	require.NoError(t, err)
+
+	t.Logf("hook failure should be converted into HookError")
+	hookErr, err := c.toHookError(context.Background(), testID,
+		errors.New("running prestart hook 0 caused \"error running hook: exit status 2, stdout: , stderr: boom\""))
+	require.NotNil(t, hookErr)
+	assert.Equal(t, "prestart-hook", hookErr.Name)
+	assert.Equal(t, ReasonHookError, ErrorReason(err))
+	assert.Equal(t, `prestart hook "prestart-hook" failed: exit status 2, stdout: , stderr: boom`, err.Error())
+
+	t.Logf("other failure should be returned as is")
+	runtimeErr := errors.New("runtime failure")
+	hookErr, err = c.toHookError(context.Background(), testID, runtimeErr)
+	assert.Nil(t, hookErr)
+	assert.Equal(t, runtimeErr, err)
+
+	t.Logf("prestart hooks should be removed")
+	require.NoError(t, c.removeContainerHooks(context.Background(), testID, hookStagePrestart))
+	newSpec, err := c.getContainerSpec(context.Background(), testID)
+	require.NoError(t, err)
+	assert.Empty(t, newSpec.Hooks.Prestart)
+	assert.Equal(t, spec.Hooks.Poststart, newSpec.Hooks.Poststart)
+}
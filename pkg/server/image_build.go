@@ -0,0 +1,379 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/content"
+	containerdimages "github.com/containerd/containerd/images"
+	"github.com/docker/distribution/reference"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+const (
+	// buildsDir contains the working directories of image builds.
+	buildsDir = "builds"
+	// buildContextDir is the directory the build context is extracted into.
+	buildContextDir = "context"
+	// buildOutputFile is the oci image archive written by the builder.
+	buildOutputFile = "image.tar"
+	// ociIndexFile is the index of an oci image archive.
+	ociIndexFile = "index.json"
+)
+
+// imageBuildOptions are options to build an image.
+type imageBuildOptions struct {
+	// Tag is the repo tag of the new image. The image is only referenced by
+	// its id if it is empty.
+	Tag string
+	// Dockerfile is the path of the Dockerfile in the build context.
+	Dockerfile string
+	// BuildArgs are the build time variables.
+	BuildArgs map[string]string
+}
+
+// imageBuildResult is the result of an image build.
+type imageBuildResult struct {
+	ID      string `json:"id"`
+	RepoTag string `json:"repoTag,omitempty"`
+}
+
+// buildImage builds an image from the build context tar with the configured
+// image builder, and registers it into the image stores.
+func (c *criContainerdService) buildImage(ctx context.Context, buildContext io.Reader, opts imageBuildOptions) (*imageBuildResult, error) {
+	if c.config.ImageBuilderAddress == "" {
+		return nil, fmt.Errorf("image build is not enabled")
+	}
+	var repoTag string
+	if opts.Tag != "" {
+		named, err := normalizeImageRef(opts.Tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tag %q: %v", opts.Tag, err)
+		}
+		if _, ok := named.(reference.NamedTagged); !ok {
+			return nil, fmt.Errorf("%q is not a tag", opts.Tag)
+		}
+		repoTag = named.String()
+	}
+	if d := filepath.Clean(opts.Dockerfile); filepath.IsAbs(d) || d == ".." || strings.HasPrefix(d, "../") {
+		return nil, fmt.Errorf("dockerfile %q is not in the build context", opts.Dockerfile)
+	}
+	buildDir := filepath.Join(c.rootDir, buildsDir, generateID())
+	contextDir := filepath.Join(buildDir, buildContextDir)
+	if err := c.os.MkdirAll(contextDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create build directory %q: %v", buildDir, err)
+	}
+	defer func() {
+		if err := c.os.RemoveAll(buildDir); err != nil {
+			glog.Errorf("Failed to remove build directory %q: %v", buildDir, err)
+		}
+	}()
+	if err := extractBuildContext(buildContext, contextDir); err != nil {
+		return nil, fmt.Errorf("failed to extract build context: %v", err)
+	}
+	output := filepath.Join(buildDir, buildOutputFile)
+	args := buildctlArgs(c.config.ImageBuilderAddress, contextDir, output, opts)
+	glog.V(4).Infof("Build image %q with %q %q", repoTag, c.config.ImageBuilderBinary, args)
+	if out, err := exec.CommandContext(ctx, c.config.ImageBuilderBinary, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to build image: %v, output: %s", err, out)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open built image: %v", err)
+	}
+	defer f.Close()
+	manifestDesc, err := importOCIArchive(ctx, c.contentStoreService, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import built image: %v", err)
+	}
+	imageID, err := c.registerImage(ctx, manifestDesc, repoTag)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Built image %q %q", imageID, repoTag)
+	return &imageBuildResult{ID: imageID, RepoTag: repoTag}, nil
+}
+
+// buildctlArgs returns the arguments of buildctl to build the dockerfile in the
+// context directory, and to write the image as an oci archive into output.
+func buildctlArgs(address, contextDir, output string, opts imageBuildOptions) []string {
+	args := []string{
+		"--addr", address,
+		"build",
+		"--frontend", "dockerfile.v0",
+		"--local", "context=" + contextDir,
+		"--local", "dockerfile=" + filepath.Join(contextDir, filepath.Dir(opts.Dockerfile)),
+	}
+	if opts.Dockerfile != "" {
+		args = append(args, "--opt", "filename="+filepath.Base(opts.Dockerfile))
+	}
+	// Sort build args, so that the command is deterministic.
+	var keys []string
+	for k := range opts.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--opt", fmt.Sprintf("build-arg:%s=%s", k, opts.BuildArgs[k]))
+	}
+	return append(args, "--output", "type=oci,dest="+output)
+}
+
+// extractBuildContext extracts the build context tar into dir. Only regular
+// files, directories and symlinks are extracted, and entries escaping dir are
+// rejected. Symlinks must point inside dir, and entries are never written
+// through a symlink, so that the context can't write files on the host.
+func extractBuildContext(r io.Reader, dir string) error {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, hdr.Name)
+		if !isInDir(path, dir) {
+			return fmt.Errorf("entry %q escapes the build context", hdr.Name)
+		}
+		if path == dir {
+			continue
+		}
+		if err := mkdirAllNoFollow(dir, filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("entry %q: %v", hdr.Name, err)
+		}
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("entry %q overwrites a symlink", hdr.Name)
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirAllNoFollow(dir, path, mode); err != nil {
+				return fmt.Errorf("entry %q: %v", hdr.Name, err)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !isInDir(filepath.Join(filepath.Dir(path), hdr.Linkname), dir) {
+				return fmt.Errorf("symlink %q to %q escapes the build context", hdr.Name, hdr.Linkname)
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			glog.V(4).Infof("Skip build context entry %q of type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// isInDir returns whether the clean path is dir or under dir.
+func isInDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// mkdirAllNoFollow creates path and its parents under dir like os.MkdirAll,
+// but returns an error instead of following an existing symlink.
+func mkdirAllNoFollow(dir, path string, mode os.FileMode) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	current := dir
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, name)
+		fi, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(current, mode); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%q is a symlink", current)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%q is not a directory", current)
+		}
+	}
+	return nil
+}
+
+// importOCIArchive writes blobs of the oci image archive into the content
+// store, and returns the descriptor of the image manifest.
+func importOCIArchive(ctx context.Context, cs content.Ingester, r io.Reader) (imagespec.Descriptor, error) {
+	var index *imagespec.Index
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imagespec.Descriptor{}, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := filepath.Clean(hdr.Name)
+		if name == ociIndexFile {
+			p, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return imagespec.Descriptor{}, err
+			}
+			index = &imagespec.Index{}
+			if err := json.Unmarshal(p, index); err != nil {
+				return imagespec.Descriptor{}, fmt.Errorf("failed to unmarshal %q: %v", ociIndexFile, err)
+			}
+			continue
+		}
+		parts := strings.Split(name, string(filepath.Separator))
+		if len(parts) != 3 || parts[0] != "blobs" {
+			continue
+		}
+		dgst := digest.NewDigestFromHex(parts[1], parts[2])
+		if err := dgst.Validate(); err != nil {
+			return imagespec.Descriptor{}, fmt.Errorf("invalid blob %q: %v", hdr.Name, err)
+		}
+		if err := content.WriteBlob(ctx, cs, dgst.String(), tr, hdr.Size, dgst); err != nil {
+			return imagespec.Descriptor{}, fmt.Errorf("failed to write blob %q: %v", dgst, err)
+		}
+	}
+	if index == nil {
+		return imagespec.Descriptor{}, fmt.Errorf("%q not found", ociIndexFile)
+	}
+	return selectOCIManifest(*index)
+}
+
+// selectOCIManifest returns the only image manifest in the oci index.
+func selectOCIManifest(index imagespec.Index) (imagespec.Descriptor, error) {
+	var manifests []imagespec.Descriptor
+	for _, m := range index.Manifests {
+		if m.MediaType == imagespec.MediaTypeImageManifest || m.MediaType == containerdimages.MediaTypeDockerSchema2Manifest {
+			manifests = append(manifests, m)
+		}
+	}
+	if len(manifests) != 1 {
+		return imagespec.Descriptor{}, fmt.Errorf("expected 1 image manifest, got %d", len(manifests))
+	}
+	return manifests[0], nil
+}
+
+// registerImage unpacks the image of the manifest in the content store, creates
+// its references, and adds it into the image store. It returns the image id.
+func (c *criContainerdService) registerImage(ctx context.Context, manifestDesc imagespec.Descriptor, repoTag string) (string, error) {
+	var manifest imagespec.Manifest
+	if err := readJSONBlob(ctx, c.contentStoreService, manifestDesc.Digest, &manifest); err != nil {
+		return "", fmt.Errorf("failed to read manifest %q: %v", manifestDesc.Digest, err)
+	}
+	var config imagespec.Image
+	if err := readJSONBlob(ctx, c.contentStoreService, manifest.Config.Digest, &config); err != nil {
+		return "", fmt.Errorf("failed to read config %q: %v", manifest.Config.Digest, err)
+	}
+	imageID := manifest.Config.Digest.String()
+	image := containerdimages.Image{Name: imageID, Target: manifestDesc}
+	if err := c.unpackImage(ctx, image); err != nil {
+		return "", fmt.Errorf("failed to unpack image %q: %v", imageID, err)
+	}
+	for _, ref := range []string{repoTag, imageID} {
+		if ref == "" {
+			continue
+		}
+		if err := c.createImageReference(ctx, ref, manifestDesc); err != nil {
+			return "", fmt.Errorf("failed to create image reference %q: %v", ref, err)
+		}
+	}
+	size := manifestDesc.Size + manifest.Config.Size
+	for _, l := range manifest.Layers {
+		size += l.Size
+	}
+	newImage := imagestore.Image{
+		ID:      imageID,
+		ChainID: identity.ChainID(config.RootFS.DiffIDs).String(),
+		Size:    size,
+		Targets: []string{manifestDesc.Digest.String()},
+		Config:  &config.Config,
+	}
+	if repoTag != "" {
+		newImage.RepoTags = []string{repoTag}
+	}
+	c.imageStore.Add(newImage)
+	c.imageLastUsed.markUsed(imageID)
+	return imageID, nil
+}
+
+// handleImageBuild handles the image-build debug endpoint. It builds the build
+// context tar in the request body into an image tagged with "tag", with the
+// Dockerfile at "dockerfile" and "build-arg"s in KEY=VALUE format.
+func (c *criContainerdService) handleImageBuild(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	buildArgs := make(map[string]string)
+	for _, a := range query["build-arg"] {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			http.Error(w, fmt.Sprintf("invalid build arg %q", a), http.StatusBadRequest)
+			return
+		}
+		buildArgs[kv[0]] = kv[1]
+	}
+	result, err := c.buildImage(r.Context(), r.Body, imageBuildOptions{
+		Tag:        query.Get("tag"),
+		Dockerfile: query.Get("dockerfile"),
+		BuildArgs:  buildArgs,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	containerdimages "github.com/containerd/containerd/images"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestBuildctlArgs(t *testing.T) {
+	for desc, test := range map[string]struct {
+		opts     imageBuildOptions
+		expected []string
+	}{
+		"default dockerfile": {
+			expected: []string{"--addr", "unix:///buildkitd.sock", "build", "--frontend", "dockerfile.v0",
+				"--local", "context=/build/context", "--local", "dockerfile=/build/context",
+				"--output", "type=oci,dest=/build/image.tar"},
+		},
+		"dockerfile in sub directory with build args": {
+			opts: imageBuildOptions{
+				Dockerfile: "docker/Dockerfile.test",
+				BuildArgs:  map[string]string{"B": "2", "A": "1"},
+			},
+			expected: []string{"--addr", "unix:///buildkitd.sock", "build", "--frontend", "dockerfile.v0",
+				"--local", "context=/build/context", "--local", "dockerfile=/build/context/docker",
+				"--opt", "filename=Dockerfile.test", "--opt", "build-arg:A=1", "--opt", "build-arg:B=2",
+				"--output", "type=oci,dest=/build/image.tar"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		args := buildctlArgs("unix:///buildkitd.sock", "/build/context", "/build/image.tar", test.opts)
+		assert.Equal(t, test.expected, args)
+	}
+}
+
+// buildTestTar returns a tar of the headers, with the content of regular files.
+func buildTestTar(t *testing.T, hdrs []tar.Header, data map[string]string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range hdrs {
+		hdr.Size = int64(len(data[hdr.Name]))
+		require.NoError(t, tw.WriteHeader(&hdr))
+		_, err := tw.Write([]byte(data[hdr.Name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf
+}
+
+func TestExtractBuildContext(t *testing.T) {
+	for desc, test := range map[string]struct {
+		hdrs      []tar.Header
+		expectErr bool
+	}{
+		"files, directories and symlinks": {
+			hdrs: []tar.Header{
+				{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "src/main.go", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "main.go", Typeflag: tar.TypeSymlink, Linkname: "src/main.go"},
+			},
+		},
+		"entry escaping the context": {
+			hdrs:      []tar.Header{{Name: "../escape", Typeflag: tar.TypeReg, Mode: 0644}},
+			expectErr: true,
+		},
+		"absolute symlink": {
+			hdrs:      []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+			expectErr: true,
+		},
+		"symlink escaping the context": {
+			hdrs:      []tar.Header{{Name: "src/link", Typeflag: tar.TypeSymlink, Linkname: "../../"}},
+			expectErr: true,
+		},
+		"entry under a symlinked directory": {
+			hdrs: []tar.Header{
+				{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "src"},
+				{Name: "link/escape", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+			expectErr: true,
+		},
+		"entry overwriting a symlink": {
+			hdrs: []tar.Header{
+				{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644},
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "Dockerfile"},
+				{Name: "link", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		dir, err := ioutil.TempDir("", "build-context")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		contextDir := filepath.Join(dir, "context")
+		require.NoError(t, os.Mkdir(contextDir, 0755))
+		data := map[string]string{"Dockerfile": "FROM busybox", "src/main.go": "package main", "../escape": "escape"}
+
+		err = extractBuildContext(buildTestTar(t, test.hdrs, data), contextDir)
+		if test.expectErr {
+			assert.Error(t, err)
+			_, err := os.Stat(filepath.Join(dir, "escape"))
+			assert.True(t, os.IsNotExist(err))
+			continue
+		}
+		require.NoError(t, err)
+		for name, expected := range map[string]string{
+			"Dockerfile":  "FROM busybox",
+			"src/main.go": "package main",
+			"main.go":     "package main",
+		} {
+			content, err := ioutil.ReadFile(filepath.Join(contextDir, name))
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(content), name)
+		}
+	}
+}
+
+func TestSelectOCIManifest(t *testing.T) {
+	manifest := imagespec.Descriptor{MediaType: imagespec.MediaTypeImageManifest, Digest: "sha256:manifest"}
+	dockerManifest := imagespec.Descriptor{MediaType: containerdimages.MediaTypeDockerSchema2Manifest, Digest: "sha256:docker"}
+	other := imagespec.Descriptor{MediaType: imagespec.MediaTypeImageIndex, Digest: "sha256:index"}
+	for desc, test := range map[string]struct {
+		manifests []imagespec.Descriptor
+		expected  *imagespec.Descriptor
+	}{
+		"single oci manifest": {
+			manifests: []imagespec.Descriptor{manifest, other},
+			expected:  &manifest,
+		},
+		"single docker manifest": {
+			manifests: []imagespec.Descriptor{dockerManifest},
+			expected:  &dockerManifest,
+		},
+		"no manifest": {
+			manifests: []imagespec.Descriptor{other},
+		},
+		"multiple manifests": {
+			manifests: []imagespec.Descriptor{manifest, dockerManifest},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		got, err := selectOCIManifest(imagespec.Index{Manifests: test.manifests})
+		if test.expected == nil {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, *test.expected, got)
+	}
+}
+
+func TestBuildImageValidation(t *testing.T) {
+	for desc, test := range map[string]struct {
+		address string
+		opts    imageBuildOptions
+	}{
+		"image build disabled": {},
+		"invalid tag": {
+			address: "unix:///buildkitd.sock",
+			opts:    imageBuildOptions{Tag: "INVALID"},
+		},
+		"dockerfile outside the build context": {
+			address: "unix:///buildkitd.sock",
+			opts:    imageBuildOptions{Dockerfile: "../Dockerfile"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.ImageBuilderAddress = test.address
+		_, err := c.buildImage(context.Background(), &bytes.Buffer{}, test.opts)
+		assert.Error(t, err)
+	}
+}
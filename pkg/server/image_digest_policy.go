@@ -0,0 +1,87 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/reference"
+	imagedigest "github.com/opencontainers/go-digest"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// imageDigestPolicyNone doesn't require image digests.
+	imageDigestPolicyNone = ""
+	// imageDigestPolicyEnforce rejects image references without digest.
+	imageDigestPolicyEnforce = "enforce"
+	// imageDigestPolicyResolve accepts tagged image references, but requires
+	// the tag to be resolved to a digest recorded in the repo digests of the
+	// image.
+	imageDigestPolicyResolve = "resolve"
+)
+
+// validateImageDigestPolicy validates the image digest policy.
+func validateImageDigestPolicy(policy string) error {
+	switch policy {
+	case imageDigestPolicyNone, imageDigestPolicyEnforce, imageDigestPolicyResolve:
+		return nil
+	}
+	return fmt.Errorf("invalid image digest policy %q, should be one of %q and %q",
+		policy, imageDigestPolicyEnforce, imageDigestPolicyResolve)
+}
+
+// isDigestedImageRef returns whether the image reference is an image id or a
+// reference with digest, which always refer to the same image content.
+func isDigestedImageRef(ref string) bool {
+	if _, err := imagedigest.Parse(ref); err == nil {
+		return true
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return false
+	}
+	_, ok := named.(reference.Canonical)
+	return ok
+}
+
+// checkImageDigestRef checks the image reference requested against the image
+// digest policy. The sandbox image is configured by the node instead of pods,
+// so it is not checked.
+func (c *criContainerdService) checkImageDigestRef(ref string) error {
+	if c.config.ImageDigestPolicy != imageDigestPolicyEnforce || ref == c.sandboxImage {
+		return nil
+	}
+	if !isDigestedImageRef(ref) {
+		return newCRIError(codes.InvalidArgument, ReasonImageDigestRequired,
+			"image %q is not referenced by digest, which is required by image digest policy %q", ref, imageDigestPolicyEnforce)
+	}
+	return nil
+}
+
+// checkImageRepoDigest checks that the image referenced is pinned to a repo
+// digest when the image digest policy is resolve, and returns the repo digest.
+func (c *criContainerdService) checkImageRepoDigest(ref string, repoDigests []string) (string, error) {
+	if c.config.ImageDigestPolicy != imageDigestPolicyResolve || ref == c.sandboxImage {
+		return "", nil
+	}
+	if len(repoDigests) == 0 {
+		return "", newCRIError(codes.FailedPrecondition, ReasonImageDigestRequired,
+			"image %q is not resolved to any repo digest, which is required by image digest policy %q", ref, imageDigestPolicyResolve)
+	}
+	return repoDigests[0], nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	testImageDigest = "sha256:c75bebcdd211f41b3a460c7bf82970ed6c75acaab9cd4c9a4e125b03ca113799"
+	testPauseImage  = "gcr.io/google_containers/pause:3.0"
+)
+
+func TestValidateImageDigestPolicy(t *testing.T) {
+	assert.NoError(t, validateImageDigestPolicy(imageDigestPolicyNone))
+	assert.NoError(t, validateImageDigestPolicy(imageDigestPolicyEnforce))
+	assert.NoError(t, validateImageDigestPolicy(imageDigestPolicyResolve))
+	assert.Error(t, validateImageDigestPolicy("strict"))
+}
+
+func TestCheckImageDigestRef(t *testing.T) {
+	for desc, test := range map[string]struct {
+		policy    string
+		ref       string
+		expectErr bool
+	}{
+		"no policy accepts tag": {
+			ref: "busybox:latest",
+		},
+		"resolve policy accepts tag": {
+			policy: imageDigestPolicyResolve,
+			ref:    "busybox:latest",
+		},
+		"enforce policy rejects tag": {
+			policy:    imageDigestPolicyEnforce,
+			ref:       "busybox:latest",
+			expectErr: true,
+		},
+		"enforce policy rejects reference without tag": {
+			policy:    imageDigestPolicyEnforce,
+			ref:       "busybox",
+			expectErr: true,
+		},
+		"enforce policy accepts digest": {
+			policy: imageDigestPolicyEnforce,
+			ref:    "busybox@" + testImageDigest,
+		},
+		"enforce policy accepts tag and digest": {
+			policy: imageDigestPolicyEnforce,
+			ref:    "busybox:latest@" + testImageDigest,
+		},
+		"enforce policy accepts image id": {
+			policy: imageDigestPolicyEnforce,
+			ref:    testImageDigest,
+		},
+		"enforce policy accepts sandbox image": {
+			policy: imageDigestPolicyEnforce,
+			ref:    testPauseImage,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.sandboxImage = testPauseImage
+		c.config.ImageDigestPolicy = test.policy
+		err := c.checkImageDigestRef(test.ref)
+		if test.expectErr {
+			assert.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, grpc.Code(toGRPCError(err)))
+			assert.Equal(t, ReasonImageDigestRequired, ErrorReason(toGRPCError(err)))
+			continue
+		}
+		assert.NoError(t, err)
+	}
+}
+
+func TestCheckImageRepoDigest(t *testing.T) {
+	repoDigest := "docker.io/library/busybox@" + testImageDigest
+	for desc, test := range map[string]struct {
+		policy      string
+		repoDigests []string
+		expected    string
+		expectErr   bool
+	}{
+		"no policy doesn't require repo digest": {},
+		"enforce policy doesn't require repo digest": {
+			policy: imageDigestPolicyEnforce,
+		},
+		"resolve policy requires repo digest": {
+			policy:    imageDigestPolicyResolve,
+			expectErr: true,
+		},
+		"resolve policy returns repo digest": {
+			policy:      imageDigestPolicyResolve,
+			repoDigests: []string{repoDigest},
+			expected:    repoDigest,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.ImageDigestPolicy = test.policy
+		pinned, err := c.checkImageRepoDigest("busybox:latest", test.repoDigests)
+		if test.expectErr {
+			assert.Error(t, err)
+			assert.Equal(t, ReasonImageDigestRequired, ErrorReason(toGRPCError(err)))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, pinned)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+// imageLastUsed keeps the last time each image is pulled or used by a container.
+// It is in-memory only, images not used since cri-containerd starts are
+// considered least recently used.
+type imageLastUsed struct {
+	sync.Mutex
+	times map[string]time.Time
+}
+
+func newImageLastUsed() *imageLastUsed {
+	return &imageLastUsed{times: make(map[string]time.Time)}
+}
+
+// markUsed marks the image used now.
+func (i *imageLastUsed) markUsed(id string) {
+	i.Lock()
+	defer i.Unlock()
+	i.times[id] = time.Now()
+}
+
+// get returns the last time the image is used, or zero time if unknown.
+func (i *imageLastUsed) get(id string) time.Time {
+	i.Lock()
+	defer i.Unlock()
+	return i.times[id]
+}
+
+// remove removes the record of the image.
+func (i *imageLastUsed) remove(id string) {
+	i.Lock()
+	defer i.Unlock()
+	delete(i.times, id)
+}
+
+// validateImageGCThresholds validates the image garbage collection thresholds.
+func validateImageGCThresholds(high, low int) error {
+	if high == 0 {
+		return nil
+	}
+	if high < 0 || high > 100 {
+		return fmt.Errorf("invalid image gc high threshold %d, should be in [0, 100]", high)
+	}
+	if low < 0 || low >= high {
+		return fmt.Errorf("invalid image gc low threshold %d, should be in [0, %d)", low, high)
+	}
+	return nil
+}
+
+// runImageGC checks image filesystem usage every period and removes images when
+// it is above the high threshold, it never returns.
+func (c *criContainerdService) runImageGC(period time.Duration) {
+	for range time.Tick(period) {
+		if err := c.garbageCollectImages(context.Background()); err != nil {
+			glog.Errorf("Failed to garbage collect images: %v", err)
+		}
+	}
+}
+
+// garbageCollectImages removes least recently used images which are not used by
+// any container and not pinned, until image filesystem usage is expected to drop
+// below the low threshold.
+// Note that the space is only reclaimed after containerd garbage collects the
+// contents and snapshots, so image size is used to estimate the space freed.
+func (c *criContainerdService) garbageCollectImages(ctx context.Context) error {
+	used, capacity, err := c.os.FsUsage(c.config.ImageFsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get usage of image filesystem %q: %v", c.config.ImageFsPath, err)
+	}
+	if capacity == 0 || used*100 < capacity*uint64(c.config.ImageGCHighThresholdPercent) {
+		return nil
+	}
+	bytesToFree := int64(used - capacity*uint64(c.config.ImageGCLowThresholdPercent)/100)
+	glog.Infof("Image filesystem usage %d/%d is above the high threshold %d%%, try to free %d bytes",
+		used, capacity, c.config.ImageGCHighThresholdPercent, bytesToFree)
+
+	inUse := make(map[string]bool)
+	for _, container := range c.containerStore.List() {
+		inUse[container.ImageRef] = true
+	}
+	pinned := append([]string{c.sandboxImage}, c.config.PinnedImages...)
+	for ref := range c.imagePrepuller.refs() {
+		pinned = append(pinned, ref)
+	}
+	for _, ref := range pinned {
+		image, err := c.localResolve(ctx, ref)
+		if err != nil {
+			// An unresolvable reference, e.g. an invalid one in the pre-pull
+			// manifest, shouldn't block garbage collection.
+			glog.Warningf("Failed to resolve pinned image %q: %v", ref, err)
+			continue
+		}
+		if image != nil {
+			inUse[image.ID] = true
+		}
+	}
+
+	var freed int64
+	for _, image := range selectImagesToRemove(c.imageStore.List(), inUse, c.imageLastUsed.get, bytesToFree) {
+		// Check again in case a container is created with the image after it is selected.
+		if c.isImageUsedByContainer(image.ID) {
+			continue
+		}
+		if _, err := c.RemoveImage(ctx, &runtime.RemoveImageRequest{
+			Image: &runtime.ImageSpec{Image: image.ID},
+		}); err != nil {
+			glog.Errorf("Failed to remove image %q for garbage collection: %v", image.ID, err)
+			continue
+		}
+		freed += image.Size
+		glog.Infof("Removed image %q %v for garbage collection", image.ID, image.RepoTags)
+		c.eventPublisher.publish(imageGarbageCollectTopic, &imageGarbageCollectedEvent{
+			ImageID:  image.ID,
+			RepoTags: image.RepoTags,
+			Size:     image.Size,
+		})
+	}
+	if freed < bytesToFree {
+		return fmt.Errorf("only freed %d bytes out of %d bytes expected", freed, bytesToFree)
+	}
+	return nil
+}
+
+// isImageUsedByContainer returns whether any container uses the image.
+func (c *criContainerdService) isImageUsedByContainer(id string) bool {
+	for _, container := range c.containerStore.List() {
+		if container.ImageRef == id {
+			return true
+		}
+	}
+	return false
+}
+
+// selectImagesToRemove returns least recently used images not in use, whose total
+// size reaches bytesToFree. All images not in use are returned if their total size
+// is not enough.
+func selectImagesToRemove(images []imagestore.Image, inUse map[string]bool, lastUsed func(string) time.Time,
+	bytesToFree int64) []imagestore.Image {
+	var candidates []imagestore.Image
+	for _, image := range images {
+		if !inUse[image.ID] {
+			candidates = append(candidates, image)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ti, tj := lastUsed(candidates[i].ID), lastUsed(candidates[j].ID)
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+	var freed int64
+	for i, image := range candidates {
+		if freed >= bytesToFree {
+			return candidates[:i]
+		}
+		freed += image.Size
+	}
+	return candidates
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+func TestValidateImageGCThresholds(t *testing.T) {
+	for desc, test := range map[string]struct {
+		high      int
+		low       int
+		expectErr bool
+	}{
+		"disabled": {high: 0, low: 80},
+		"valid":    {high: 90, low: 80},
+		"high threshold above 100": {
+			high:      101,
+			low:       80,
+			expectErr: true,
+		},
+		"low threshold not below high threshold": {
+			high:      80,
+			low:       80,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		err := validateImageGCThresholds(test.high, test.low)
+		assert.Equal(t, test.expectErr, err != nil)
+	}
+}
+
+func TestSelectImagesToRemove(t *testing.T) {
+	now := time.Now()
+	images := []imagestore.Image{
+		{ID: "recent", Size: 100},
+		{ID: "old", Size: 100},
+		{ID: "unknown", Size: 100},
+		{ID: "in-use", Size: 100},
+	}
+	lastUsed := map[string]time.Time{
+		"recent": now,
+		"old":    now.Add(-time.Hour),
+		"in-use": now.Add(-2 * time.Hour),
+	}
+	inUse := map[string]bool{"in-use": true}
+	for desc, test := range map[string]struct {
+		bytesToFree int64
+		expected    []string
+	}{
+		"least recently used image first": {
+			bytesToFree: 50,
+			expected:    []string{"unknown"},
+		},
+		"stop once enough space is freed": {
+			bytesToFree: 200,
+			expected:    []string{"unknown", "old"},
+		},
+		"all images not in use if not enough": {
+			bytesToFree: 1000,
+			expected:    []string{"unknown", "old", "recent"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		selected := selectImagesToRemove(images, inUse, func(id string) time.Time { return lastUsed[id] }, test.bytesToFree)
+		var ids []string
+		for _, image := range selected {
+			ids = append(ids, image.ID)
+		}
+		assert.Equal(t, test.expected, ids)
+	}
+}
+
+func TestGarbageCollectImagesBelowThreshold(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.config.ImageGCHighThresholdPercent = 90
+	c.config.ImageGCLowThresholdPercent = 80
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.FsUsageFn = func(string) (uint64, uint64, error) { return 85, 100, nil }
+	c.imageStore.Add(imagestore.Image{ID: "test-image", Size: 10})
+	require.NoError(t, c.garbageCollectImages(context.Background()))
+	_, err := c.imageStore.Get("test-image")
+	assert.NoError(t, err, "image should not be removed below the high threshold")
+}
+
+func TestGarbageCollectImagesSkipsUnresolvablePinnedImage(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.config.ImageGCHighThresholdPercent = 90
+	c.config.ImageGCLowThresholdPercent = 80
+	c.config.PinnedImages = []string{"Invalid:Ref:"}
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.FsUsageFn = func(string) (uint64, uint64, error) { return 95, 100, nil }
+	// There is no image to remove, so garbage collection fails after the
+	// pinned images are resolved.
+	err := c.garbageCollectImages(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "only freed 0 bytes")
+}
+
+func TestIsImageUsedByContainer(t *testing.T) {
+	c := newTestCRIContainerdService()
+	container, err := containerstore.NewContainer(containerstore.Metadata{ID: "test-id", ImageRef: "test-image"}, containerstore.Status{})
+	require.NoError(t, err)
+	require.NoError(t, c.containerStore.Add(container))
+	assert.True(t, c.isImageUsedByContainer("test-image"))
+	assert.False(t, c.isImageUsedByContainer("other-image"))
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// prepullSourceManifest means the image is listed in the pre-pull manifest.
+	prepullSourceManifest = "manifest"
+	// prepullSourceAPI means the image is added through the debug socket.
+	prepullSourceAPI = "api"
+	// prepullSourceSandbox means the image is the sandbox image.
+	prepullSourceSandbox = "sandbox"
+)
+
+// imagePrepullCheckpointFile is the file under the root directory checkpointing
+// images added through the debug socket, so that they stay pinned after restart.
+const imagePrepullCheckpointFile = "prepull-images.json"
+
+// prepullImage is the state of an image pre-pulled by the daemon.
+type prepullImage struct {
+	// Image is the image reference.
+	Image string `json:"image"`
+	// Source is where the image is requested, either manifest or api.
+	Source string `json:"source"`
+	// ImageID is the id of the image once it is present.
+	ImageID string `json:"imageID,omitempty"`
+	// CheckedAt is the last time the image is checked or pulled.
+	CheckedAt time.Time `json:"checkedAt"`
+	// Error is the error of the last pull.
+	Error string `json:"error,omitempty"`
+}
+
+// imagePrepuller keeps images listed in the node-local manifest and added
+// through the debug socket. The images are pulled if they are missing, and are
+// pinned, so that image garbage collection never removes them.
+type imagePrepuller struct {
+	sync.Mutex
+	// manifestModTime is the modification time of the manifest last loaded.
+	manifestModTime time.Time
+	// manifestImages are images listed in the manifest.
+	manifestImages map[string]bool
+	// apiImages are images added through the debug socket.
+	apiImages map[string]bool
+	// sandboxImage is the sandbox image if it is pre-pulled.
+	sandboxImage string
+	// status are states of images, keyed by image reference.
+	status map[string]prepullImage
+	// trigger is notified to pre-pull images immediately.
+	trigger chan struct{}
+}
+
+// newImagePrepuller creates an image prepuller.
+func newImagePrepuller() *imagePrepuller {
+	return &imagePrepuller{
+		manifestImages: make(map[string]bool),
+		apiImages:      make(map[string]bool),
+		status:         make(map[string]prepullImage),
+		trigger:        make(chan struct{}, 1),
+	}
+}
+
+// parsePrepullManifest parses the pre-pull manifest, which lists an image
+// reference per line. Empty lines and lines starting with "#" are ignored.
+func parsePrepullManifest(data []byte) map[string]bool {
+	images := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		images[line] = true
+	}
+	return images
+}
+
+// setManifestImages replaces images listed in the manifest.
+func (p *imagePrepuller) setManifestImages(images map[string]bool, modTime time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	p.manifestImages = images
+	p.manifestModTime = modTime
+}
+
+// manifestLoadedAt returns the modification time of the manifest last loaded.
+func (p *imagePrepuller) manifestLoadedAt() time.Time {
+	p.Lock()
+	defer p.Unlock()
+	return p.manifestModTime
+}
+
+// add adds images through the debug socket, and triggers a pre-pull.
+func (p *imagePrepuller) add(images []string) {
+	p.Lock()
+	defer p.Unlock()
+	for _, image := range images {
+		p.apiImages[image] = true
+	}
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// getAPIImages returns images added through the debug socket sorted by
+// reference.
+func (p *imagePrepuller) getAPIImages() []string {
+	p.Lock()
+	defer p.Unlock()
+	images := []string{}
+	for image := range p.apiImages {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// setSandboxImage pre-pulls the sandbox image. It can't be removed through the
+// debug socket.
+func (p *imagePrepuller) setSandboxImage(image string) {
+	p.Lock()
+	defer p.Unlock()
+	p.sandboxImage = image
+}
+
+// remove removes images added through the debug socket. The images are not
+// removed from the node, but they are no longer pinned.
+func (p *imagePrepuller) remove(images []string) {
+	p.Lock()
+	defer p.Unlock()
+	for _, image := range images {
+		delete(p.apiImages, image)
+	}
+}
+
+// refs returns references of all pre-pulled images mapped to their sources. An
+// image both in the manifest and added through api is from the manifest, and
+// the sandbox image is from the sandbox source regardless.
+func (p *imagePrepuller) refs() map[string]string {
+	p.Lock()
+	defer p.Unlock()
+	refs := make(map[string]string)
+	for image := range p.apiImages {
+		refs[image] = prepullSourceAPI
+	}
+	for image := range p.manifestImages {
+		refs[image] = prepullSourceManifest
+	}
+	if p.sandboxImage != "" {
+		refs[p.sandboxImage] = prepullSourceSandbox
+	}
+	return refs
+}
+
+// setStatus records the state of the image.
+func (p *imagePrepuller) setStatus(status prepullImage) {
+	p.Lock()
+	defer p.Unlock()
+	p.status[status.Image] = status
+}
+
+// list returns states of all pre-pulled images sorted by reference. States of
+// images no longer requested are dropped.
+func (p *imagePrepuller) list() []prepullImage {
+	refs := p.refs()
+	p.Lock()
+	defer p.Unlock()
+	var images []prepullImage
+	for ref, source := range refs {
+		status, ok := p.status[ref]
+		if !ok {
+			status = prepullImage{Image: ref}
+		}
+		status.Source = source
+		images = append(images, status)
+	}
+	for ref := range p.status {
+		if _, ok := refs[ref]; !ok {
+			delete(p.status, ref)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Image < images[j].Image })
+	return images
+}
+
+// loadPrepullManifest reloads the pre-pull manifest if it is changed. A missing
+// manifest means no image to pre-pull.
+func (c *criContainerdService) loadPrepullManifest() error {
+	path := c.config.ImagePrepullManifest
+	if path == "" {
+		return nil
+	}
+	info, err := c.os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.imagePrepuller.setManifestImages(make(map[string]bool), time.Time{})
+			return nil
+		}
+		return fmt.Errorf("failed to stat image pre-pull manifest %q: %v", path, err)
+	}
+	if info.ModTime().Equal(c.imagePrepuller.manifestLoadedAt()) {
+		return nil
+	}
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read image pre-pull manifest %q: %v", path, err)
+	}
+	images := parsePrepullManifest(data)
+	glog.V(2).Infof("Loaded %d images from image pre-pull manifest %q", len(images), path)
+	c.imagePrepuller.setManifestImages(images, info.ModTime())
+	return nil
+}
+
+// checkpointPrepullImages checkpoints images added through the debug socket.
+func (c *criContainerdService) checkpointPrepullImages() error {
+	data, err := json.Marshal(c.imagePrepuller.getAPIImages())
+	if err != nil {
+		return fmt.Errorf("failed to marshal pre-pulled images: %v", err)
+	}
+	path := filepath.Join(c.rootDir, imagePrepullCheckpointFile)
+	if err := c.os.AtomicWriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to checkpoint pre-pulled images to %q: %v", path, err)
+	}
+	return nil
+}
+
+// loadPrepullCheckpoint adds images checkpointed by checkpointPrepullImages.
+// A missing checkpoint means no image was added.
+func (c *criContainerdService) loadPrepullCheckpoint() error {
+	path := filepath.Join(c.rootDir, imagePrepullCheckpointFile)
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read pre-pulled images checkpoint %q: %v", path, err)
+	}
+	var images []string
+	if err := json.Unmarshal(data, &images); err != nil {
+		return fmt.Errorf("failed to unmarshal pre-pulled images checkpoint %q: %v", path, err)
+	}
+	c.imagePrepuller.add(images)
+	return nil
+}
+
+// prepullImages pulls pre-pulled images which are missing on the node.
+func (c *criContainerdService) prepullImages(ctx context.Context) error {
+	if err := c.loadPrepullManifest(); err != nil {
+		return err
+	}
+	for ref, source := range c.imagePrepuller.refs() {
+		status := prepullImage{Image: ref, Source: source, CheckedAt: time.Now()}
+		image, err := c.localResolve(ctx, ref)
+		if err != nil {
+			status.Error = err.Error()
+		} else if image != nil {
+			status.ImageID = image.ID
+		} else {
+			glog.V(2).Infof("Pre-pull image %q", ref)
+			resp, err := c.PullImage(ctx, &runtime.PullImageRequest{Image: &runtime.ImageSpec{Image: ref}})
+			if err != nil {
+				glog.Errorf("Failed to pre-pull image %q: %v", ref, err)
+				status.Error = err.Error()
+			} else {
+				status.ImageID = resp.GetImageRef()
+			}
+		}
+		c.imagePrepuller.setStatus(status)
+	}
+	return nil
+}
+
+// runImagePrepull pre-pulls images periodically, and whenever images are added.
+func (c *criContainerdService) runImagePrepull(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if err := c.prepullImages(context.Background()); err != nil {
+			glog.Errorf("Failed to pre-pull images: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-c.imagePrepuller.trigger:
+		}
+	}
+}
+
+// handleImagePrepull lists pre-pulled images on GET, adds images on POST and
+// removes images on DELETE. Images are specified with the repeated "image"
+// query parameter. Only images added through the endpoint could be removed.
+// Images added through the endpoint are checkpointed.
+func (c *criContainerdService) handleImagePrepull(w http.ResponseWriter, r *http.Request) {
+	images := r.URL.Query()["image"]
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if len(images) == 0 {
+			http.Error(w, "image is required", http.StatusBadRequest)
+			return
+		}
+		for _, image := range images {
+			if _, err := normalizeImageRef(image); err != nil {
+				http.Error(w, fmt.Sprintf("invalid image reference %q: %v", image, err), http.StatusBadRequest)
+				return
+			}
+		}
+		c.imagePrepuller.add(images)
+	case http.MethodDelete:
+		c.imagePrepuller.remove(images)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method != http.MethodGet {
+		if err := c.checkpointPrepullImages(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, c.imagePrepuller.list())
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+
+	osinterface "github.com/kubernetes-incubator/cri-containerd/pkg/os"
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+func TestParsePrepullManifest(t *testing.T) {
+	manifest := `
+# system images
+gcr.io/google_containers/kube-proxy:v1.8.0
+
+  busybox:latest  
+# busybox:1.0
+`
+	assert.Equal(t, map[string]bool{
+		"gcr.io/google_containers/kube-proxy:v1.8.0": true,
+		"busybox:latest": true,
+	}, parsePrepullManifest([]byte(manifest)))
+}
+
+func TestImagePrepullerRefs(t *testing.T) {
+	p := newImagePrepuller()
+	p.setManifestImages(map[string]bool{"image-1": true, "image-2": true}, time.Now())
+	p.add([]string{"image-2", "image-3"})
+	assert.Equal(t, map[string]string{
+		"image-1": prepullSourceManifest,
+		"image-2": prepullSourceManifest,
+		"image-3": prepullSourceAPI,
+	}, p.refs())
+
+	t.Logf("should trigger pre-pull when images are added")
+	select {
+	case <-p.trigger:
+	default:
+		t.Errorf("pre-pull should be triggered")
+	}
+
+	t.Logf("should drop status of images no longer requested")
+	p.setStatus(prepullImage{Image: "image-3", ImageID: "id-3"})
+	assert.Equal(t, []prepullImage{
+		{Image: "image-1", Source: prepullSourceManifest},
+		{Image: "image-2", Source: prepullSourceManifest},
+		{Image: "image-3", Source: prepullSourceAPI, ImageID: "id-3"},
+	}, p.list())
+	p.remove([]string{"image-3"})
+	assert.Len(t, p.list(), 2)
+	assert.NotContains(t, p.status, "image-3")
+
+	t.Logf("sandbox image should not be removed through api")
+	p.setSandboxImage("pause")
+	p.add([]string{"pause"})
+	p.remove([]string{"pause"})
+	assert.Equal(t, map[string]string{
+		"image-1": prepullSourceManifest,
+		"image-2": prepullSourceManifest,
+		"pause":   prepullSourceSandbox,
+	}, p.refs())
+}
+
+func TestLoadPrepullManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-prepull-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	manifest := filepath.Join(dir, "manifest")
+
+	c := newTestCRIContainerdService()
+	c.os = osinterface.RealOS{}
+	c.config.ImagePrepullManifest = manifest
+
+	t.Logf("missing manifest should mean no image")
+	require.NoError(t, c.loadPrepullManifest())
+	assert.Empty(t, c.imagePrepuller.refs())
+
+	t.Logf("should load manifest")
+	require.NoError(t, ioutil.WriteFile(manifest, []byte("image-1\n"), 0644))
+	modTime := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(manifest, modTime, modTime))
+	require.NoError(t, c.loadPrepullManifest())
+	assert.Equal(t, map[string]string{"image-1": prepullSourceManifest}, c.imagePrepuller.refs())
+
+	t.Logf("should not reload unchanged manifest")
+	c.imagePrepuller.setManifestImages(map[string]bool{}, c.imagePrepuller.manifestLoadedAt())
+	require.NoError(t, c.loadPrepullManifest())
+	assert.Empty(t, c.imagePrepuller.refs())
+
+	t.Logf("should reload changed manifest")
+	require.NoError(t, ioutil.WriteFile(manifest, []byte("image-2\n"), 0644))
+	modTime = modTime.Add(time.Minute)
+	require.NoError(t, os.Chtimes(manifest, modTime, modTime))
+	require.NoError(t, c.loadPrepullManifest())
+	assert.Equal(t, map[string]string{"image-2": prepullSourceManifest}, c.imagePrepuller.refs())
+
+	t.Logf("removed manifest should mean no image")
+	require.NoError(t, os.Remove(manifest))
+	require.NoError(t, c.loadPrepullManifest())
+	assert.Empty(t, c.imagePrepuller.refs())
+}
+
+func TestPrepullImagesPresent(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.imageStore.Add(imagestore.Image{ID: testSandboxImage})
+	c.imagePrepuller.add([]string{testSandboxImage})
+	require.NoError(t, c.prepullImages(context.Background()))
+	images := c.imagePrepuller.list()
+	require.Len(t, images, 1)
+	assert.Equal(t, testSandboxImage, images[0].ImageID)
+	assert.Empty(t, images[0].Error)
+	assert.False(t, images[0].CheckedAt.IsZero())
+}
+
+func TestHandleImagePrepull(t *testing.T) {
+	for desc, test := range map[string]struct {
+		method       string
+		query        string
+		expectStatus int
+		expectRefs   map[string]string
+	}{
+		"add images": {
+			method:       http.MethodPost,
+			query:        "image=busybox&image=gcr.io/test/image:v1",
+			expectStatus: http.StatusOK,
+			expectRefs: map[string]string{
+				"image-1":              prepullSourceAPI,
+				"busybox":              prepullSourceAPI,
+				"gcr.io/test/image:v1": prepullSourceAPI,
+			},
+		},
+		"add without image": {
+			method:       http.MethodPost,
+			expectStatus: http.StatusBadRequest,
+			expectRefs:   map[string]string{"image-1": prepullSourceAPI},
+		},
+		"add invalid image": {
+			method:       http.MethodPost,
+			query:        "image=Invalid:Ref:",
+			expectStatus: http.StatusBadRequest,
+			expectRefs:   map[string]string{"image-1": prepullSourceAPI},
+		},
+		"remove image": {
+			method:       http.MethodDelete,
+			query:        "image=image-1",
+			expectStatus: http.StatusOK,
+			expectRefs:   map[string]string{},
+		},
+		"list images": {
+			method:       http.MethodGet,
+			expectStatus: http.StatusOK,
+			expectRefs:   map[string]string{"image-1": prepullSourceAPI},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.imagePrepuller.add([]string{"image-1"})
+		var checkpoint []byte
+		c.os.(*ostesting.FakeOS).AtomicWriteFileFn = func(path string, data []byte, _ os.FileMode) error {
+			assert.Equal(t, filepath.Join(testRootDir, imagePrepullCheckpointFile), path)
+			checkpoint = data
+			return nil
+		}
+		w := httptest.NewRecorder()
+		c.handleImagePrepull(w, httptest.NewRequest(test.method, "/image-prepull?"+test.query, nil))
+		assert.Equal(t, test.expectStatus, w.Code)
+		assert.Equal(t, test.expectRefs, c.imagePrepuller.refs())
+		if test.expectStatus != http.StatusOK || test.method == http.MethodGet {
+			assert.Nil(t, checkpoint, "images should not be checkpointed")
+			continue
+		}
+		var images []string
+		require.NoError(t, json.Unmarshal(checkpoint, &images))
+		assert.Equal(t, c.imagePrepuller.getAPIImages(), images)
+	}
+}
+
+func TestLoadPrepullCheckpoint(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+		return nil, os.ErrNotExist
+	}
+	require.NoError(t, c.loadPrepullCheckpoint())
+	assert.Empty(t, c.imagePrepuller.refs())
+
+	c.os.(*ostesting.FakeOS).ReadFileFn = func(path string) ([]byte, error) {
+		assert.Equal(t, filepath.Join(testRootDir, imagePrepullCheckpointFile), path)
+		return []byte(`["busybox","gcr.io/test/image:v1"]`), nil
+	}
+	require.NoError(t, c.loadPrepullCheckpoint())
+	assert.Equal(t, map[string]string{
+		"busybox":              prepullSourceAPI,
+		"gcr.io/test/image:v1": prepullSourceAPI,
+	}, c.imagePrepuller.refs())
+}
@@ -24,6 +24,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/containerd/content"
@@ -36,6 +37,7 @@ import (
 	"github.com/golang/glog"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
 	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
@@ -89,17 +91,73 @@ func (c *criContainerdService) PullImage(ctx context.Context, r *runtime.PullIma
 		}
 	}()
 	imageRef := r.GetImage().GetImage()
+	if err := c.checkImageDigestRef(imageRef); err != nil {
+		return nil, err
+	}
+
+	var pod string
+	if meta := r.GetSandboxConfig().GetMetadata(); meta != nil {
+		pod = meta.GetNamespace() + "/" + meta.GetName()
+	}
+	// Images pulled without sandbox config are not accounted to any namespace.
+	namespace := r.GetSandboxConfig().GetMetadata().GetNamespace()
+	if err := c.namespaceQuotas.checkImageBytes(namespace); err != nil {
+		return nil, wrapCRIError(err, "failed to pull image %q", imageRef)
+	}
+	pullID := c.pullProgress.start(imageRef, pod)
+	defer func() {
+		c.pullProgress.finish(pullID, retErr)
+	}()
+
+	// Wait for the pull queue with the request context, so that the pull timeouts
+	// only apply to the pull itself.
+	queued := false
+	release, err := c.pullQueue.acquire(ctx, c.getPullPriority(imageRef, namespace), func() {
+		queued = true
+		c.pullProgress.update(pullID, func(p *pullProgress) { p.Stage = pullStageQueued })
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for pulling image %q: %v", imageRef, err)
+	}
+	defer release()
+	if queued {
+		c.pullProgress.update(pullID, func(p *pullProgress) { p.Stage = pullStageResolving })
+	}
 
+	if err := c.deleteCorruptImageBlobs(ctx, imageRef); err != nil {
+		return nil, err
+	}
+	pullCtx, cancel, stalled := c.newPullContext(ctx, pullID)
+	defer cancel()
 	// TODO(mikebrow): add truncIndex for image id
-	imageID, repoTag, repoDigest, err := c.pullImage(ctx, imageRef, r.GetAuth())
+	imageID, repoTag, repoDigest, err := c.pullImage(pullCtx, imageRef, r.GetAuth(), namespace, pullID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to pull image %q: %v", imageRef, err)
+		if pullCtx.Err() != nil && ctx.Err() == nil {
+			progress, _ := c.pullProgress.get(pullID)
+			reason := fmt.Sprintf("exceeded image pull timeout %v", c.config.ImagePullTimeout)
+			if stalled() {
+				reason = fmt.Sprintf("made no progress in %v", c.config.ImagePullProgressTimeout)
+			}
+			return nil, newCRIError(codes.DeadlineExceeded, ReasonImagePullTimeout, "failed to pull image %q: %s (%s): %v",
+				imageRef, reason, progress, err)
+		}
+		if isNoSpaceError(err) {
+			return nil, newCRIError(codes.ResourceExhausted, ReasonNoSpace, "failed to pull image %q: %v", imageRef, err)
+		}
+		return nil, wrapCRIError(err, "failed to pull image %q", imageRef)
 	}
 	glog.V(4).Infof("Pulled image %q with image id %q, repo tag %q, repo digest %q", imageRef, imageID,
 		repoTag, repoDigest)
+	var repoDigests []string
+	if repoDigest != "" {
+		repoDigests = []string{repoDigest}
+	}
+	if _, err := c.checkImageRepoDigest(imageRef, repoDigests); err != nil {
+		return nil, err
+	}
 
 	// Get image information.
-	chainID, size, config, err := c.getImageInfo(ctx, imageRef)
+	chainID, size, target, config, err := c.getImageInfo(ctx, imageRef)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image %q information: %v", imageRef, err)
 	}
@@ -107,6 +165,7 @@ func (c *criContainerdService) PullImage(ctx context.Context, r *runtime.PullIma
 		ID:      imageID,
 		ChainID: chainID.String(),
 		Size:    size,
+		Targets: []string{target.String()},
 		Config:  config,
 	}
 
@@ -116,7 +175,29 @@ func (c *criContainerdService) PullImage(ctx context.Context, r *runtime.PullIma
 	if repoTag != "" {
 		image.RepoTags = []string{repoTag}
 	}
+	// Only an image new to the node is accounted to the namespace, and it is
+	// removed again if it exceeds the quota. An image already on the node is
+	// owned by the namespace which pulled it first, or by no namespace.
+	_, err = c.imageStore.Get(imageID)
+	existed := err == nil
 	c.imageStore.Add(image)
+	c.imageLastUsed.markUsed(imageID)
+	c.imagePullAuths.set(imageID, r.GetAuth())
+	if !existed {
+		if err := c.namespaceQuotas.addImage(namespace, imageID, size); err != nil {
+			if _, rmErr := c.RemoveImage(ctx, &runtime.RemoveImageRequest{
+				Image: &runtime.ImageSpec{Image: imageID},
+			}); rmErr != nil {
+				glog.Errorf("Failed to remove image %q exceeding namespace quota: %v", imageID, rmErr)
+			}
+			return nil, wrapCRIError(err, "failed to pull image %q", imageRef)
+		}
+		if namespace != "" {
+			if err := c.checkpointImageCharges(); err != nil {
+				glog.Errorf("Failed to checkpoint image usage of namespaces: %v", err)
+			}
+		}
+	}
 
 	// NOTE(random-liu): the actual state in containerd is the source of truth, even we maintain
 	// in-memory image store, it's only for in-memory indexing. The image could be removed
@@ -126,6 +207,57 @@ func (c *criContainerdService) PullImage(ctx context.Context, r *runtime.PullIma
 	return &runtime.PullImageResponse{ImageRef: imageID}, err
 }
 
+// newPullContext returns the context to pull an image with. When the image pull
+// timeout is set, the pull is decoupled from the deadline of the request, so that
+// pulls of huge images are bounded by the daemon side timeout instead. The pull is
+// also cancelled if it makes no progress for the image pull progress timeout. The
+// returned function tells whether the pull is cancelled because of no progress.
+func (c *criContainerdService) newPullContext(ctx context.Context, pullID string) (context.Context, context.CancelFunc, func() bool) {
+	var cancel context.CancelFunc
+	if c.config.ImagePullTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.config.ImagePullTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	var stalled int32
+	if c.config.ImagePullProgressTimeout > 0 {
+		go func() {
+			ticker := time.NewTicker(waitDownloadingPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					progress, ok := c.pullProgress.get(pullID)
+					if !ok {
+						return
+					}
+					if isPullStalled(progress, time.Now(), c.config.ImagePullProgressTimeout) {
+						glog.Warningf("Image pull %q made no progress in %v (%s), cancel it",
+							progress.Image, c.config.ImagePullProgressTimeout, progress)
+						atomic.StoreInt32(&stalled, 1)
+						c.metrics.stalledPulls.Inc()
+						cancel()
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return ctx, cancel, func() bool { return atomic.LoadInt32(&stalled) == 1 }
+}
+
+// isPullStalled returns whether the pull made no progress in the timeout. Layer
+// unpacking doesn't report progress, so it is never considered stalled.
+func isPullStalled(p pullProgress, now time.Time, timeout time.Duration) bool {
+	switch p.Stage {
+	case pullStageResolving, pullStageResolved, pullStageDownloading:
+		return now.Sub(time.Unix(0, p.ProgressedAt)) > timeout
+	}
+	return false
+}
+
 // resourceSet is the helper struct to help tracking all resources associated
 // with an image.
 type resourceSet struct {
@@ -154,7 +286,21 @@ func (r *resourceSet) all() map[string]struct{} {
 	return resources
 }
 
+// resolveError converts an error of image reference resolution into cri error.
+// Resolvers don't return typed errors, so the message is checked.
+func resolveError(ref string, err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, docker.ErrInvalidAuthorization.Error()) || strings.Contains(msg, docker.ErrNoToken.Error()):
+		return newCRIError(codes.Unauthenticated, ReasonImagePullAuthFailed, "failed to resolve ref %q: %v", ref, err)
+	case strings.Contains(msg, "not found"):
+		return newCRIError(codes.NotFound, ReasonImageNotFound, "failed to resolve ref %q: %v", ref, err)
+	}
+	return fmt.Errorf("failed to resolve ref %q: %v", ref, err)
+}
+
 // ParseAuth parses AuthConfig and returns username and password/secret required by containerd.
+// The registry token is not returned, it is sent by registryTokenTransport.
 func ParseAuth(auth *runtime.AuthConfig) (string, string, error) {
 	if auth == nil {
 		return "", "", nil
@@ -179,12 +325,88 @@ func ParseAuth(auth *runtime.AuthConfig) (string, string, error) {
 		user, passwd := fields[0], fields[1]
 		return user, strings.Trim(passwd, "\x00"), nil
 	}
-	// TODO(random-liu): Support RegistryToken.
+	if auth.RegistryToken != "" {
+		return "", "", nil
+	}
 	return "", "", fmt.Errorf("invalid auth config")
 }
 
+// registryHost returns the host the docker resolver sends requests to for
+// a registry domain or server address, e.g. "https://index.docker.io/v1/".
+func registryHost(address string) string {
+	host := address
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+len("://"):]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "docker.io", "index.docker.io":
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+// registryCredentials returns the credentials function of the docker resolver.
+// If the server address of the auth config is set, the credentials are only
+// sent to that registry, so that they are not leaked to other registries,
+// e.g. when the image reference is rewritten.
+func registryCredentials(auth *runtime.AuthConfig) func(string) (string, string, error) {
+	return func(host string) (string, string, error) {
+		if !authMatchesHost(auth, host) {
+			glog.V(4).Infof("Skip credentials of registry %q for host %q", auth.ServerAddress, host)
+			return "", "", nil
+		}
+		return ParseAuth(auth)
+	}
+}
+
+// authMatchesHost returns whether the auth config should be used for the host.
+func authMatchesHost(auth *runtime.AuthConfig, host string) bool {
+	return auth.GetServerAddress() == "" || registryHost(auth.ServerAddress) == host
+}
+
+// registryTokenTransport sends the registry token as the bearer token to the
+// registry host, unless the request is already authorized by the resolver.
+type registryTokenTransport struct {
+	host  string
+	token string
+	base  http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *registryTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTripper should not modify the request.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(r)
+}
+
+// registryClient returns the http client used to pull from the registry host
+// with the transport.
+func registryClient(auth *runtime.AuthConfig, host string, transport http.RoundTripper) *http.Client {
+	if auth.GetRegistryToken() == "" || !authMatchesHost(auth, host) {
+		return &http.Client{Transport: transport}
+	}
+	return &http.Client{Transport: &registryTokenTransport{
+		host:  host,
+		token: auth.RegistryToken,
+		base:  transport,
+	}}
+}
+
 // pullImage pulls image and returns image id (config digest), repoTag and repoDigest.
-func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, auth *runtime.AuthConfig) (
+// The pull progress is reported to the progress tracker with pullID.
+func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, auth *runtime.AuthConfig, namespace, pullID string) (
 	// TODO(random-liu): Replace with client.Pull.
 	string, string, string, error) {
 	namedRef, err := normalizeImageRef(rawRef)
@@ -197,18 +419,43 @@ func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, aut
 		glog.V(4).Infof("PullImage using normalized image ref: %q", ref)
 	}
 
-	// Resolve the image reference to get descriptor and fetcher.
-	resolver := docker.NewResolver(docker.ResolverOptions{
-		Credentials: func(string) (string, string, error) { return ParseAuth(auth) },
-		Client:      http.DefaultClient,
-	})
-	_, desc, err := resolver.Resolve(ctx, ref)
+	// The image is pulled from the rewritten reference if any rewrite rule matches,
+	// but it is still stored with the requested reference, which pods refer to.
+	pullNamed, rewritten, err := rewriteImageRef(c.imageRewriteRules, namedRef)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to resolve ref %q: %v", ref, err)
+		return "", "", "", err
+	}
+	pullRef := pullNamed.String()
+	if rewritten {
+		glog.V(2).Infof("Pull image %q from rewritten image ref %q", ref, pullRef)
+	}
+
+	// Resolve the image reference to get descriptor and fetcher. Images of hosts
+	// mapped to local oci layout directories are resolved from the directories,
+	// others from the registry endpoints, i.e. mirrors and the registry.
+	var (
+		resolver remotes.Resolver
+		desc     imagespec.Descriptor
+	)
+	if r, ok := newOCILayoutResolver(c.ociLayoutDirs, pullNamed); ok {
+		glog.V(4).Infof("Resolve image %q from oci layout %q", pullRef, r.dir)
+		resolver = r
+		if _, desc, err = resolver.Resolve(ctx, pullRef); err != nil {
+			return "", "", "", resolveError(pullRef, err)
+		}
+	} else {
+		resolver, pullRef, desc, err = c.resolveImage(ctx, pullNamed, auth)
+		if err != nil {
+			return "", "", "", err
+		}
 	}
-	fetcher, err := resolver.Fetcher(ctx, ref)
+	c.pullProgress.update(pullID, func(p *pullProgress) {
+		p.Stage = pullStageResolved
+		p.Digest = desc.Digest.String()
+	})
+	fetcher, err := resolver.Fetcher(ctx, pullRef)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get fetcher for ref %q: %v", ref, err)
+		return "", "", "", fmt.Errorf("failed to get fetcher for ref %q: %v", pullRef, err)
 	}
 	// Currently, the resolved image name is the same with ref in docker resolver,
 	// but they may be different in the future.
@@ -220,31 +467,67 @@ func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, aut
 	resourceTrackHandler := containerdimages.HandlerFunc(func(ctx gocontext.Context, desc imagespec.Descriptor) (
 		[]imagespec.Descriptor, error) {
 		resources.add(remotes.MakeRefKey(ctx, desc))
+		if isLayer(desc) {
+			c.pullProgress.update(pullID, func(p *pullProgress) {
+				p.LayersTotal++
+				p.BytesTotal += desc.Size
+			})
+		}
+		return nil, nil
+	})
+	// progressHandler reports a layer as downloaded once it is fetched.
+	progressHandler := containerdimages.HandlerFunc(func(ctx gocontext.Context, desc imagespec.Descriptor) (
+		[]imagespec.Descriptor, error) {
+		if isLayer(desc) {
+			c.pullProgress.update(pullID, func(p *pullProgress) {
+				p.LayersDone++
+				p.BytesDone += desc.Size
+			})
+		}
 		return nil, nil
 	})
 	// Fetch all image resources into content store.
 	// Dispatch a handler which will run a sequence of handlers to:
 	// 1) track all resources associated using a customized handler;
 	// 2) fetch the object using a FetchHandler;
-	// 3) recurse through any sub-layers via a ChildrenHandler.
+	// 3) report the download progress using a customized handler;
+	// 4) recurse through any sub-layers via a ChildrenHandler.
 	// Support schema1 image.
 	var (
 		schema1Converter *schema1.Converter
 		handler          containerdimages.Handler
+		fetched          = newFetchedSet()
 	)
+	quotaHandler, quotaErr := c.imageQuotaHandler(namespace)
 	if desc.MediaType == containerdimages.MediaTypeDockerSchema1Manifest {
 		schema1Converter = schema1.NewConverter(c.contentStoreService, fetcher)
 		handler = containerdimages.Handlers(
 			resourceTrackHandler,
 			schema1Converter,
+			progressHandler,
 		)
 	} else {
 		handler = containerdimages.Handlers(
 			resourceTrackHandler,
-			remotes.FetchHandler(c.contentStoreService, fetcher),
+			c.limitDownloads(remotes.FetchHandler(c.contentStoreService, fetcher)),
+			fetched.handler(),
+			quotaHandler,
+			progressHandler,
 			containerdimages.ChildrenHandler(c.contentStoreService),
 		)
 	}
+	// Layers are unpacked in order as soon as they are downloaded. The unpack
+	// is stopped before returning, so that it never races with other snapshot
+	// operations.
+	unpack := c.startPipelinedUnpack(ctx, desc, fetched)
+	defer unpack.stop()
+	c.pullProgress.update(pullID, func(p *pullProgress) { p.Stage = pullStageDownloading })
+	reportCtx, stopReporting := context.WithCancel(ctx)
+	reportDone := make(chan struct{})
+	go func() {
+		defer close(reportDone)
+		c.reportDownloadProgress(reportCtx, pullID, resources)
+	}()
 	if err := containerdimages.Dispatch(ctx, handler, desc); err != nil {
 		// Dispatch returns error when requested resources are locked.
 		// In that case, we should start waiting and checking the pulling
@@ -253,10 +536,18 @@ func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, aut
 		glog.V(5).Infof("Dispatch for %q returns error: %v", ref, err)
 	}
 	// Wait for the image pulling to finish
-	if err := c.waitForResourcesDownloading(ctx, resources.all()); err != nil {
+	err = c.waitForResourcesDownloading(ctx, resources.all())
+	stopReporting()
+	<-reportDone
+	if err := quotaErr(); err != nil {
+		return "", "", "", err
+	}
+	if err != nil {
 		return "", "", "", fmt.Errorf("failed to wait for image %q downloading: %v", ref, err)
 	}
+	fetched.doneAll()
 	glog.V(4).Infof("Finished downloading resources for image %q", ref)
+	c.setBytesInFlight(pullID, 0)
 	if schema1Converter != nil {
 		desc, err = schema1Converter.Convert(ctx)
 		if err != nil {
@@ -291,23 +582,116 @@ func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, aut
 	if err != nil {
 		return "", "", "", fmt.Errorf("failed to get image %q from containerd image store: %v", ref, err)
 	}
+	c.pullProgress.update(pullID, func(p *pullProgress) { p.Stage = pullStageUnpacking })
+	if err := unpack.wait(); err != nil {
+		glog.V(4).Infof("Failed to unpack image %q while downloading, unpack it again: %v", ref, err)
+	}
+	if err := c.unpackImage(ctx, image); err != nil {
+		return "", "", "", fmt.Errorf("failed to unpack image %q: %v", ref, err)
+	}
+
+	// TODO(random-liu): Considering how to deal with the disk usage of content.
+
+	configDesc, err := image.Config(ctx, c.contentStoreService)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get config descriptor for image %q: %v", ref, err)
+	}
+	// Use config digest as imageID to conform to oci image spec, and also add image id as
+	// image reference.
+	imageID := configDesc.Digest.String()
+	if err := c.createImageReference(ctx, imageID, desc); err != nil {
+		return "", "", "", fmt.Errorf("failed to update image id %q: %v", imageID, err)
+	}
+	return imageID, repoTag, repoDigest, nil
+}
+
+// imageQuotaHandler returns a handler checking the size of a new image against
+// the image bytes quota of the namespace once its manifest is fetched, so that
+// layers of an image exceeding the quota are never fetched. The returned
+// function returns the quota error, because errors of dispatch are ignored.
+// Schema 1 images are only checked after the pull.
+func (c *criContainerdService) imageQuotaHandler(namespace string) (containerdimages.Handler, func() error) {
+	var (
+		mu       sync.Mutex
+		quotaErr error
+	)
+	handler := containerdimages.HandlerFunc(func(ctx gocontext.Context, desc imagespec.Descriptor) (
+		[]imagespec.Descriptor, error) {
+		if namespace == "" {
+			return nil, nil
+		}
+		switch desc.MediaType {
+		case containerdimages.MediaTypeDockerSchema2Manifest, imagespec.MediaTypeImageManifest:
+		default:
+			return nil, nil
+		}
+		data, err := content.ReadBlob(ctx, c.contentStoreService, desc.Digest)
+		if err != nil {
+			glog.Warningf("Failed to read manifest %q to check image quota: %v", desc.Digest, err)
+			return nil, nil
+		}
+		var manifest imagespec.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			glog.Warningf("Failed to unmarshal manifest %q to check image quota: %v", desc.Digest, err)
+			return nil, nil
+		}
+		// An image already on the node is never accounted again.
+		id := manifest.Config.Digest.String()
+		if _, err := c.imageStore.Get(id); err == nil {
+			return nil, nil
+		}
+		size := desc.Size + manifest.Config.Size
+		for _, layer := range manifest.Layers {
+			size += layer.Size
+		}
+		if err := c.namespaceQuotas.checkImageSize(namespace, id, size); err != nil {
+			mu.Lock()
+			quotaErr = err
+			mu.Unlock()
+			return nil, err
+		}
+		return nil, nil
+	})
+	return handler, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return quotaErr
+	}
+}
+
+// unpackImage unpacks the image layers into snapshots. Layers already unpacked
+// are skipped.
+func (c *criContainerdService) unpackImage(ctx context.Context, image containerdimages.Image) error {
+	layers, err := c.imageLayers(ctx, image)
+	if err != nil {
+		return err
+	}
+	if _, err := containerdrootfs.ApplyLayers(ctx, layers, c.snapshotService, c.diffService); err != nil {
+		return fmt.Errorf("failed to apply layers %+v: %v", layers, err)
+	}
+	return nil
+}
+
+// imageLayers returns the layers of the image manifest, which should be in the
+// content store along with the image config.
+func (c *criContainerdService) imageLayers(ctx context.Context, image containerdimages.Image) ([]containerdrootfs.Layer, error) {
 	// Read the image manifest from content store.
 	manifestDigest := image.Target.Digest
 	p, err := content.ReadBlob(ctx, c.contentStoreService, manifestDigest)
 	if err != nil {
-		return "", "", "", fmt.Errorf("readblob failed for manifest digest %q: %v", manifestDigest, err)
+		return nil, fmt.Errorf("readblob failed for manifest digest %q: %v", manifestDigest, err)
 	}
 	var manifest imagespec.Manifest
 	if err := json.Unmarshal(p, &manifest); err != nil {
-		return "", "", "", fmt.Errorf("unmarshal blob to manifest failed for manifest digest %q: %v",
+		return nil, fmt.Errorf("unmarshal blob to manifest failed for manifest digest %q: %v",
 			manifestDigest, err)
 	}
 	diffIDs, err := image.RootFS(ctx, c.contentStoreService)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get image rootfs: %v", err)
+		return nil, fmt.Errorf("failed to get image rootfs: %v", err)
 	}
 	if len(diffIDs) != len(manifest.Layers) {
-		return "", "", "", fmt.Errorf("mismatched image rootfs and manifest layers")
+		return nil, fmt.Errorf("mismatched image rootfs and manifest layers")
 	}
 	layers := make([]containerdrootfs.Layer, len(diffIDs))
 	for i := range diffIDs {
@@ -318,23 +702,7 @@ func (c *criContainerdService) pullImage(ctx context.Context, rawRef string, aut
 		}
 		layers[i].Blob = manifest.Layers[i]
 	}
-	if _, err := containerdrootfs.ApplyLayers(ctx, layers, c.snapshotService, c.diffService); err != nil {
-		return "", "", "", fmt.Errorf("failed to apply layers %+v: %v", layers, err)
-	}
-
-	// TODO(random-liu): Considering how to deal with the disk usage of content.
-
-	configDesc, err := image.Config(ctx, c.contentStoreService)
-	if err != nil {
-		return "", "", "", fmt.Errorf("failed to get config descriptor for image %q: %v", ref, err)
-	}
-	// Use config digest as imageID to conform to oci image spec, and also add image id as
-	// image reference.
-	imageID := configDesc.Digest.String()
-	if err := c.createImageReference(ctx, imageID, desc); err != nil {
-		return "", "", "", fmt.Errorf("failed to update image id %q: %v", imageID, err)
-	}
-	return imageID, repoTag, repoDigest, nil
+	return layers, nil
 }
 
 // createImageReference creates image reference inside containerd image store.
@@ -362,6 +730,70 @@ func (c *criContainerdService) createImageReference(ctx context.Context, name st
 // waitDownloadingPollInterval is the interval to check resource downloading progress.
 const waitDownloadingPollInterval = 200 * time.Millisecond
 
+// pullProgressLogInterval is the interval to log the progress of each resource
+// being downloaded.
+const pullProgressLogInterval = 10 * time.Second
+
+// reportDownloadProgress periodically reports bytes downloaded for resources
+// still being downloaded, until the context is cancelled. Progress of each
+// resource is also logged at verbosity 4.
+func (c *criContainerdService) reportDownloadProgress(ctx context.Context, pullID string, resources *resourceSet) {
+	ticker := time.NewTicker(waitDownloadingPollInterval)
+	defer ticker.Stop()
+	var loggedAt time.Time
+	for {
+		select {
+		case <-ticker.C:
+			statuses, err := c.contentStoreService.ListStatuses(ctx, "")
+			if err != nil {
+				glog.V(4).Infof("Failed to get content status: %v", err)
+				continue
+			}
+			all := resources.all()
+			c.setBytesInFlight(pullID, inFlightBytes(statuses, all))
+			if glog.V(4) && time.Since(loggedAt) >= pullProgressLogInterval {
+				loggedAt = time.Now()
+				c.logDownloadProgress(pullID, statuses, all)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// logDownloadProgress logs the progress of each resource of the pull being downloaded.
+func (c *criContainerdService) logDownloadProgress(pullID string, statuses []content.Status, resources map[string]struct{}) {
+	p, ok := c.pullProgress.get(pullID)
+	if !ok {
+		return
+	}
+	for _, status := range statuses {
+		if _, ok := resources[status.Ref]; ok {
+			glog.Infof("Image pull %q downloading %q: %d/%d bytes, updated %v ago", p.Image, status.Ref,
+				status.Offset, status.Total, time.Since(status.UpdatedAt).Round(time.Second))
+		}
+	}
+}
+
+// setBytesInFlight updates bytes in flight of the pull if it changes.
+func (c *criContainerdService) setBytesInFlight(pullID string, bytes int64) {
+	if p, ok := c.pullProgress.get(pullID); !ok || p.BytesInFlight == bytes {
+		return
+	}
+	c.pullProgress.update(pullID, func(p *pullProgress) { p.BytesInFlight = bytes })
+}
+
+// inFlightBytes returns the number of bytes downloaded for resources being downloaded.
+func inFlightBytes(statuses []content.Status, resources map[string]struct{}) int64 {
+	var bytes int64
+	for _, status := range statuses {
+		if _, ok := resources[status.Ref]; ok {
+			bytes += status.Offset
+		}
+	}
+	return bytes
+}
+
 // waitForResourcesDownloading waits for all resource downloading to finish.
 func (c *criContainerdService) waitForResourcesDownloading(ctx context.Context, resources map[string]struct{}) error {
 	ticker := time.NewTicker(waitDownloadingPollInterval)
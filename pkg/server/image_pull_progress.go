@@ -0,0 +1,290 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	containerdimages "github.com/containerd/containerd/images"
+	"github.com/golang/glog"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+const (
+	// pullStageQueued is the stage when the pull is waiting for other pulls
+	// because of the limit of concurrent pulls.
+	pullStageQueued = "Queued"
+	// pullStageResolving is the stage when the image reference is being resolved.
+	pullStageResolving = "Resolving"
+	// pullStageResolved is the stage when the image manifest digest is resolved.
+	pullStageResolved = "Resolved"
+	// pullStageDownloading is the stage when image contents are being downloaded.
+	pullStageDownloading = "Downloading"
+	// pullStageUnpacking is the stage when image layers are being unpacked.
+	pullStageUnpacking = "Unpacking"
+	// pullStageDone is the stage when the image is pulled successfully.
+	pullStageDone = "Done"
+	// pullStageFailed is the stage when the image pull fails.
+	pullStageFailed = "Failed"
+)
+
+// pullEventBufferSize is the number of pull progress events buffered for each
+// subscriber. Events are dropped for subscribers which are too slow.
+const pullEventBufferSize = 128
+
+// pullProgress is the progress of an image pull. It is emitted as an event every
+// time it changes.
+type pullProgress struct {
+	// ID is the unique id of the pull.
+	ID string `json:"id"`
+	// Image is the image reference requested.
+	Image string `json:"image"`
+	// Pod is the "namespace/name" of the pod requesting the image, if known.
+	Pod         string `json:"pod,omitempty"`
+	Stage       string `json:"stage"`
+	Digest      string `json:"digest,omitempty"`
+	LayersTotal int    `json:"layersTotal"`
+	LayersDone  int    `json:"layersDone"`
+	BytesTotal  int64  `json:"bytesTotal"`
+	BytesDone   int64  `json:"bytesDone"`
+	// BytesInFlight is the number of bytes downloaded for layers which are
+	// still being downloaded.
+	BytesInFlight int64 `json:"bytesInFlight"`
+	// StartedAt, UpdatedAt and ProgressedAt are in nanoseconds. ProgressedAt
+	// is the last time the stage changed or more bytes were downloaded.
+	StartedAt    int64  `json:"startedAt"`
+	UpdatedAt    int64  `json:"updatedAt"`
+	ProgressedAt int64  `json:"progressedAt"`
+	Error        string `json:"error,omitempty"`
+}
+
+// String returns a human readable summary of the progress.
+func (p pullProgress) String() string {
+	return fmt.Sprintf("stage %s, %d/%d layers, %d/%d bytes", p.Stage, p.LayersDone, p.LayersTotal,
+		p.BytesDone+p.BytesInFlight, p.BytesTotal)
+}
+
+// pullProgressTracker tracks progress of ongoing image pulls, and broadcasts
+// progress events to subscribers.
+type pullProgressTracker struct {
+	sync.Mutex
+	pulls       map[string]*pullProgress
+	subscribers map[chan pullProgress]struct{}
+}
+
+func newPullProgressTracker() *pullProgressTracker {
+	return &pullProgressTracker{
+		pulls:       make(map[string]*pullProgress),
+		subscribers: make(map[chan pullProgress]struct{}),
+	}
+}
+
+// start starts tracking a pull of the image requested by the pod, and returns the pull id.
+func (t *pullProgressTracker) start(image, pod string) string {
+	now := time.Now().UnixNano()
+	p := &pullProgress{
+		ID:           generateID(),
+		Image:        image,
+		Pod:          pod,
+		Stage:        pullStageResolving,
+		StartedAt:    now,
+		UpdatedAt:    now,
+		ProgressedAt: now,
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.pulls[p.ID] = p
+	t.broadcast(*p)
+	return p.ID
+}
+
+// update updates the progress of the pull, and broadcasts the new progress. The
+// pull is not tracked anymore once it is done or failed.
+func (t *pullProgressTracker) update(id string, fn func(*pullProgress)) {
+	t.Lock()
+	defer t.Unlock()
+	p, ok := t.pulls[id]
+	if !ok {
+		return
+	}
+	old := *p
+	fn(p)
+	p.UpdatedAt = time.Now().UnixNano()
+	if p.Stage != old.Stage || p.BytesDone+p.BytesInFlight > old.BytesDone+old.BytesInFlight {
+		p.ProgressedAt = p.UpdatedAt
+	}
+	if p.Stage == pullStageDone || p.Stage == pullStageFailed {
+		delete(t.pulls, id)
+	}
+	t.broadcast(*p)
+}
+
+// finish marks the pull done, or failed with the error.
+func (t *pullProgressTracker) finish(id string, err error) {
+	t.update(id, func(p *pullProgress) {
+		if err != nil {
+			p.Stage = pullStageFailed
+			p.Error = err.Error()
+			return
+		}
+		p.Stage = pullStageDone
+	})
+}
+
+// get returns the progress of an ongoing pull.
+func (t *pullProgressTracker) get(id string) (pullProgress, bool) {
+	t.Lock()
+	defer t.Unlock()
+	p, ok := t.pulls[id]
+	if !ok {
+		return pullProgress{}, false
+	}
+	return *p, true
+}
+
+// list returns progress of all ongoing pulls.
+func (t *pullProgressTracker) list() []pullProgress {
+	t.Lock()
+	defer t.Unlock()
+	var pulls []pullProgress
+	for _, p := range t.pulls {
+		pulls = append(pulls, *p)
+	}
+	return pulls
+}
+
+// pullProgressSamples returns a sample of each ongoing pull with the value
+// computed by fn.
+func pullProgressSamples(pulls []pullProgress, fn func(pullProgress) float64) []metrics.Sample {
+	var samples []metrics.Sample
+	for _, p := range pulls {
+		samples = append(samples, metrics.Sample{
+			Labels: map[string]string{"id": p.ID, "image": p.Image, "pod": p.Pod},
+			Value:  fn(p),
+		})
+	}
+	return samples
+}
+
+// registerPullProgressMetrics registers per pull progress gauges, so that stuck
+// pulls could be spotted without the debug socket.
+func (c *criContainerdService) registerPullProgressMetrics() {
+	c.metrics.registry.MustRegister(
+		metrics.NewLabeledGaugeFunc("cri_containerd_image_pull_downloaded_bytes",
+			"Bytes downloaded by each ongoing image pull.",
+			func() []metrics.Sample {
+				return pullProgressSamples(c.pullProgress.list(), func(p pullProgress) float64 {
+					return float64(p.BytesDone + p.BytesInFlight)
+				})
+			}),
+		metrics.NewLabeledGaugeFunc("cri_containerd_image_pull_total_bytes",
+			"Total bytes of layers of each ongoing image pull, known once layers are discovered.",
+			func() []metrics.Sample {
+				return pullProgressSamples(c.pullProgress.list(), func(p pullProgress) float64 {
+					return float64(p.BytesTotal)
+				})
+			}),
+		metrics.NewLabeledGaugeFunc("cri_containerd_image_pull_seconds_since_progress",
+			"Seconds since each ongoing image pull last made progress.",
+			func() []metrics.Sample {
+				now := time.Now()
+				return pullProgressSamples(c.pullProgress.list(), func(p pullProgress) float64 {
+					return now.Sub(time.Unix(0, p.ProgressedAt)).Seconds()
+				})
+			}),
+	)
+}
+
+// subscribe returns a channel of progress events, and a function to unsubscribe.
+func (t *pullProgressTracker) subscribe() (<-chan pullProgress, func()) {
+	ch := make(chan pullProgress, pullEventBufferSize)
+	t.Lock()
+	t.subscribers[ch] = struct{}{}
+	t.Unlock()
+	return ch, func() {
+		t.Lock()
+		defer t.Unlock()
+		delete(t.subscribers, ch)
+	}
+}
+
+// broadcast sends the event to all subscribers without blocking. It must be
+// called with the lock held.
+func (t *pullProgressTracker) broadcast(p pullProgress) {
+	for ch := range t.subscribers {
+		select {
+		case ch <- p:
+		default:
+			glog.V(4).Infof("Drop image pull progress event %+v for slow subscriber", p)
+		}
+	}
+}
+
+// isLayer returns whether the descriptor is an image layer.
+func isLayer(desc imagespec.Descriptor) bool {
+	switch desc.MediaType {
+	case containerdimages.MediaTypeDockerSchema2Layer, containerdimages.MediaTypeDockerSchema2LayerGzip,
+		imagespec.MediaTypeImageLayer, imagespec.MediaTypeImageLayerGzip:
+		return true
+	}
+	return false
+}
+
+// handleImagePulls handles the image-pulls debug endpoint. It returns progress
+// of all ongoing image pulls.
+func (c *criContainerdService) handleImagePulls(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.pullProgress.list())
+}
+
+// handleImagePullEvents handles the image-pull-events debug endpoint. It streams
+// image pull progress events as json lines until the client disconnects. Events
+// could be filtered by the "pod" ("namespace/name") and "image" query parameters.
+func (c *criContainerdService) handleImagePullEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	pod, image := r.URL.Query().Get("pod"), r.URL.Query().Get("image")
+	events, unsubscribe := c.pullProgress.subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case e := <-events:
+			if (pod != "" && e.Pod != pod) || (image != "" && e.Image != image) {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				glog.V(4).Infof("Failed to write image pull event: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
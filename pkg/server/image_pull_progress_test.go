@@ -0,0 +1,152 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+func TestPullProgressTracker(t *testing.T) {
+	for desc, test := range map[string]struct {
+		err           error
+		expectedStage string
+	}{
+		"successful pull": {
+			expectedStage: pullStageDone,
+		},
+		"failed pull": {
+			err:           errors.New("test error"),
+			expectedStage: pullStageFailed,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		tracker := newPullProgressTracker()
+		events, unsubscribe := tracker.subscribe()
+		id := tracker.start("busybox", "test-ns/test-name")
+		tracker.update(id, func(p *pullProgress) {
+			p.Stage = pullStageDownloading
+			p.LayersTotal = 2
+			p.BytesTotal = 100
+		})
+		progress, ok := tracker.get(id)
+		require.True(t, ok)
+		assert.Equal(t, pullStageDownloading, progress.Stage)
+		assert.Len(t, tracker.list(), 1)
+
+		tracker.finish(id, test.err)
+		_, ok = tracker.get(id)
+		assert.False(t, ok, "finished pull should not be tracked")
+		assert.Empty(t, tracker.list())
+		// Updates after the pull finished should be ignored.
+		tracker.update(id, func(p *pullProgress) { p.Stage = pullStageUnpacking })
+		unsubscribe()
+
+		var stages []string
+		for len(events) > 0 {
+			e := <-events
+			assert.Equal(t, id, e.ID)
+			assert.Equal(t, "busybox", e.Image)
+			assert.Equal(t, "test-ns/test-name", e.Pod)
+			stages = append(stages, e.Stage)
+		}
+		assert.Equal(t, []string{pullStageResolving, pullStageDownloading, test.expectedStage}, stages)
+		assert.Equal(t, "stage Downloading, 0/2 layers, 0/100 bytes", progress.String())
+	}
+}
+
+func TestIsLayer(t *testing.T) {
+	for mediaType, expected := range map[string]bool{
+		"application/vnd.docker.image.rootfs.diff.tar.gzip":    true,
+		imagespec.MediaTypeImageLayerGzip:                      true,
+		imagespec.MediaTypeImageManifest:                       false,
+		"application/vnd.docker.container.image.v1+json":       false,
+		"application/vnd.docker.distribution.manifest.v2+json": false,
+	} {
+		assert.Equal(t, expected, isLayer(imagespec.Descriptor{MediaType: mediaType}), mediaType)
+	}
+}
+
+func TestHandleImagePullEvents(t *testing.T) {
+	c := newTestCRIContainerdService()
+	server := httptest.NewServer(c.DebugHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/image-pull-events?pod=test-ns/test-name")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Events of other pods should be filtered out.
+	otherID := c.pullProgress.start("busybox", "test-ns/other-name")
+	c.pullProgress.finish(otherID, nil)
+	id := c.pullProgress.start("busybox", "test-ns/test-name")
+	c.pullProgress.finish(id, nil)
+
+	scanner := bufio.NewScanner(resp.Body)
+	var stages []string
+	for len(stages) < 2 && scanner.Scan() {
+		var e pullProgress
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		assert.Equal(t, id, e.ID)
+		stages = append(stages, e.Stage)
+	}
+	assert.Equal(t, []string{pullStageResolving, pullStageDone}, stages)
+}
+
+func TestPullProgressMetrics(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.registerPullProgressMetrics()
+	id := c.pullProgress.start("busybox", "test-ns/test-pod")
+	c.pullProgress.update(id, func(p *pullProgress) {
+		p.Stage = pullStageDownloading
+		p.BytesTotal = 100
+		p.BytesDone = 30
+		p.BytesInFlight = 20
+	})
+	labels := map[string]string{"id": id, "image": "busybox", "pod": "test-ns/test-pod"}
+	for name, expect := range map[string]float64{
+		"cri_containerd_image_pull_downloaded_bytes": 50,
+		"cri_containerd_image_pull_total_bytes":      100,
+	} {
+		m := c.metrics.registry.Get(name).(metrics.LabeledMetric)
+		assert.Equal(t, []metrics.Sample{{Labels: labels, Value: expect}}, m.Samples(), name)
+	}
+	since := c.metrics.registry.Get("cri_containerd_image_pull_seconds_since_progress").(metrics.LabeledMetric).Samples()
+	require.Len(t, since, 1)
+	assert.True(t, since[0].Value >= 0 && since[0].Value < 60)
+
+	t.Logf("finished pulls should not be reported")
+	c.pullProgress.finish(id, nil)
+	for _, name := range []string{
+		"cri_containerd_image_pull_downloaded_bytes",
+		"cri_containerd_image_pull_total_bytes",
+		"cri_containerd_image_pull_seconds_since_progress",
+	} {
+		assert.Empty(t, c.metrics.registry.Get(name).(metrics.LabeledMetric).Samples(), name)
+	}
+}
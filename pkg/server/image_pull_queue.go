@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// pullPriority is the priority of an image pull. Pulls with higher priority
+// are started first when the number of concurrent pulls is limited.
+type pullPriority int
+
+const (
+	// pullPriorityNormal is the priority of application images.
+	pullPriorityNormal pullPriority = iota
+	// pullPrioritySystem is the priority of images of pods in system namespaces.
+	pullPrioritySystem
+	// pullPrioritySandbox is the priority of the sandbox image, which every pod
+	// needs before anything else.
+	pullPrioritySandbox
+)
+
+// pullWaiter is an image pull waiting to start.
+type pullWaiter struct {
+	priority pullPriority
+	ready    chan struct{}
+}
+
+// pullQueue limits the number of concurrent image pulls. Waiting pulls are
+// started in the order of priority, and then in the order they arrive, so
+// that the sandbox image and system images are not stuck behind large
+// application images during node startup.
+type pullQueue struct {
+	sync.Mutex
+	// limit is the maximum number of concurrent pulls, 0 means no limit.
+	limit   int
+	running int
+	// waiting are in the order they arrive.
+	waiting []*pullWaiter
+}
+
+func newPullQueue(limit int) *pullQueue {
+	return &pullQueue{limit: limit}
+}
+
+// acquire waits until the pull could start, and returns the function to call
+// once the pull is done. onWait is called if the pull has to wait.
+func (q *pullQueue) acquire(ctx context.Context, priority pullPriority, onWait func()) (func(), error) {
+	if q.limit <= 0 {
+		return func() {}, nil
+	}
+	q.Lock()
+	if q.running < q.limit {
+		q.running++
+		q.Unlock()
+		return q.release, nil
+	}
+	w := &pullWaiter{priority: priority, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	q.Unlock()
+
+	onWait()
+	select {
+	case <-w.ready:
+		return q.release, nil
+	case <-ctx.Done():
+	}
+	q.Lock()
+	defer q.Unlock()
+	for i, waiting := range q.waiting {
+		if waiting == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return nil, ctx.Err()
+		}
+	}
+	// The pull has been started concurrently, hand the slot over.
+	q.releaseLocked()
+	return nil, ctx.Err()
+}
+
+// release hands the slot of a finished pull over to the waiting pull with the
+// highest priority.
+func (q *pullQueue) release() {
+	q.Lock()
+	defer q.Unlock()
+	q.releaseLocked()
+}
+
+func (q *pullQueue) releaseLocked() {
+	if len(q.waiting) == 0 {
+		q.running--
+		return
+	}
+	next := 0
+	for i, w := range q.waiting {
+		if w.priority > q.waiting[next].priority {
+			next = i
+		}
+	}
+	w := q.waiting[next]
+	q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+	close(w.ready)
+}
+
+// getPullPriority returns the priority of pulling the image for a pod in the
+// namespace. The namespace is empty if the pod is unknown.
+func (c *criContainerdService) getPullPriority(ref, namespace string) pullPriority {
+	if ref == c.sandboxImage {
+		return pullPrioritySandbox
+	}
+	for _, ns := range c.config.ImagePullSystemNamespaces {
+		if namespace != "" && namespace == ns {
+			return pullPrioritySystem
+		}
+	}
+	return pullPriorityNormal
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestPullQueueUnlimited(t *testing.T) {
+	q := newPullQueue(0)
+	for i := 0; i < 10; i++ {
+		_, err := q.acquire(context.Background(), pullPriorityNormal, func() {
+			t.Fatal("pull should not wait without limit")
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestPullQueuePriority(t *testing.T) {
+	q := newPullQueue(1)
+	release, err := q.acquire(context.Background(), pullPriorityNormal, func() {})
+	require.NoError(t, err)
+
+	started := make(chan pullPriority, 3)
+	for _, priority := range []pullPriority{pullPriorityNormal, pullPrioritySystem, pullPrioritySandbox} {
+		waiting := make(chan struct{})
+		go func(priority pullPriority) {
+			r, err := q.acquire(context.Background(), priority, func() { close(waiting) })
+			assert.NoError(t, err)
+			started <- priority
+			r()
+		}(priority)
+		// Wait for the pull to be queued, so that pulls arrive in order.
+		<-waiting
+	}
+	release()
+	assert.Equal(t, pullPrioritySandbox, <-started)
+	assert.Equal(t, pullPrioritySystem, <-started)
+	assert.Equal(t, pullPriorityNormal, <-started)
+}
+
+func TestPullQueueCancel(t *testing.T) {
+	q := newPullQueue(1)
+	release, err := q.acquire(context.Background(), pullPriorityNormal, func() {})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = q.acquire(ctx, pullPrioritySandbox, cancel)
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, q.waiting, "cancelled pull should not be waiting")
+
+	release()
+	assert.Equal(t, 0, q.running)
+	release, err = q.acquire(context.Background(), pullPriorityNormal, func() {
+		t.Fatal("pull should not wait after release")
+	})
+	require.NoError(t, err)
+	release()
+}
+
+func TestGetPullPriority(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.config.ImagePullSystemNamespaces = []string{"kube-system"}
+	for desc, test := range map[string]struct {
+		ref       string
+		namespace string
+		expected  pullPriority
+	}{
+		"sandbox image": {
+			ref:      testSandboxImage,
+			expected: pullPrioritySandbox,
+		},
+		"image of system pod": {
+			ref:       "busybox",
+			namespace: "kube-system",
+			expected:  pullPrioritySystem,
+		},
+		"image of application pod": {
+			ref:       "busybox",
+			namespace: "default",
+			expected:  pullPriorityNormal,
+		},
+		"image without pod": {
+			ref:      "busybox",
+			expected: pullPriorityNormal,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, c.getPullPriority(test.ref, test.namespace))
+	}
+}
@@ -19,10 +19,17 @@ package server
 import (
 	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/containerd/containerd/content"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
@@ -85,6 +92,9 @@ func TestParseAuth(t *testing.T) {
 			auth:      &runtime.AuthConfig{Auth: string(invalidAuth)},
 			expectErr: true,
 		},
+		"should not return error for registry token": {
+			auth: &runtime.AuthConfig{RegistryToken: "abcd"},
+		},
 	} {
 		t.Logf("TestCase %q", desc)
 		u, s, err := ParseAuth(test.auth)
@@ -93,3 +103,173 @@ func TestParseAuth(t *testing.T) {
 		assert.Equal(t, test.expectedSecret, s)
 	}
 }
+
+func TestRegistryCredentials(t *testing.T) {
+	for desc, test := range map[string]struct {
+		serverAddress  string
+		host           string
+		expectedSecret string
+	}{
+		"should send credentials without server address": {
+			host:           "gcr.io",
+			expectedSecret: "secret",
+		},
+		"should send credentials to the server address": {
+			serverAddress:  "gcr.io",
+			host:           "gcr.io",
+			expectedSecret: "secret",
+		},
+		"should send credentials to docker hub with index server address": {
+			serverAddress:  "https://index.docker.io/v1/",
+			host:           "registry-1.docker.io",
+			expectedSecret: "secret",
+		},
+		"should not send credentials to other registries": {
+			serverAddress: "gcr.io",
+			host:          "mirror.example.com",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		auth := &runtime.AuthConfig{
+			Username:      "user",
+			Password:      "secret",
+			ServerAddress: test.serverAddress,
+		}
+		_, secret, err := registryCredentials(auth)(test.host)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedSecret, secret)
+	}
+}
+
+func TestRegistryTokenTransport(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	for desc, test := range map[string]struct {
+		auth          *runtime.AuthConfig
+		header        string
+		expectedValue string
+	}{
+		"should send registry token as bearer token": {
+			auth:          &runtime.AuthConfig{RegistryToken: "abcd"},
+			expectedValue: "Bearer abcd",
+		},
+		"should not override authorization of the resolver": {
+			auth:          &runtime.AuthConfig{RegistryToken: "abcd"},
+			header:        "Bearer efgh",
+			expectedValue: "Bearer efgh",
+		},
+		"should not send registry token to other registries": {
+			auth: &runtime.AuthConfig{RegistryToken: "abcd", ServerAddress: "gcr.io"},
+		},
+		"should not send anything without registry token": {
+			auth: &runtime.AuthConfig{Username: "user", Password: "secret"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		resp, err := registryClient(test.auth, host, http.DefaultTransport).Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, test.expectedValue, authorization)
+		assert.Equal(t, test.header, req.Header.Get("Authorization"), "request should not be modified")
+	}
+}
+
+func TestIsPullStalled(t *testing.T) {
+	now := time.Now()
+	timeout := time.Minute
+	for desc, test := range map[string]struct {
+		stage        string
+		progressedAt time.Time
+		expected     bool
+	}{
+		"downloading with recent progress": {
+			stage:        pullStageDownloading,
+			progressedAt: now.Add(-time.Second),
+			expected:     false,
+		},
+		"downloading without progress": {
+			stage:        pullStageDownloading,
+			progressedAt: now.Add(-2 * time.Minute),
+			expected:     true,
+		},
+		"resolving without progress": {
+			stage:        pullStageResolving,
+			progressedAt: now.Add(-2 * time.Minute),
+			expected:     true,
+		},
+		"unpacking should never be stalled": {
+			stage:        pullStageUnpacking,
+			progressedAt: now.Add(-2 * time.Minute),
+			expected:     false,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		p := pullProgress{Stage: test.stage, ProgressedAt: test.progressedAt.UnixNano()}
+		assert.Equal(t, test.expected, isPullStalled(p, now, timeout))
+	}
+}
+
+func TestInFlightBytes(t *testing.T) {
+	statuses := []content.Status{
+		{Ref: "layer-1", Offset: 100},
+		{Ref: "layer-2", Offset: 200},
+		{Ref: "other-layer", Offset: 400},
+	}
+	resources := map[string]struct{}{"layer-1": {}, "layer-2": {}}
+	assert.EqualValues(t, 300, inFlightBytes(statuses, resources))
+}
+
+func TestImageQuotaHandler(t *testing.T) {
+	for desc, test := range map[string]struct {
+		namespace string
+		quota     int64
+		existing  bool
+		expectErr bool
+	}{
+		"new image within quota": {
+			namespace: "test-ns",
+			quota:     1 << 20,
+		},
+		"new image exceeding quota": {
+			namespace: "test-ns",
+			quota:     10,
+			expectErr: true,
+		},
+		"image already on the node": {
+			namespace: "test-ns",
+			quota:     10,
+			existing:  true,
+		},
+		"image without namespace": {
+			quota: 10,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c, _, config, _ := newTestVerifyImageService(t)
+		c.namespaceQuotas = newNamespaceQuotaTracker(map[string]namespaceQuota{
+			defaultNamespaceQuotaKey: {ImageBytes: test.quota},
+		})
+		if !test.existing {
+			c.imageStore.Delete(config.Digest.String())
+		}
+		manifest := c.imageStoreService.(*fakeImageStore).images["docker.io/library/busybox:latest"].Target
+		handler, quotaErr := c.imageQuotaHandler(test.namespace)
+		children, err := handler.Handle(context.Background(), manifest)
+		assert.Empty(t, children)
+		assert.Equal(t, test.expectErr, err != nil)
+		if test.expectErr {
+			assert.Equal(t, ReasonNamespaceQuotaExceeded, ErrorReason(quotaErr()))
+		} else {
+			assert.NoError(t, quotaErr())
+		}
+	}
+}
@@ -60,5 +60,12 @@ func (c *criContainerdService) RemoveImage(ctx context.Context, r *runtime.Remov
 		return nil, fmt.Errorf("failed to delete image reference %q for image %q: %v", ref, image.ID, err)
 	}
 	c.imageStore.Delete(image.ID)
+	c.imageLastUsed.remove(image.ID)
+	c.verifiedImages.remove(image.ID)
+	c.imagePullAuths.remove(image.ID)
+	c.namespaceQuotas.removeImage(image.ID)
+	if err := c.checkpointImageCharges(); err != nil {
+		glog.Errorf("Failed to checkpoint image usage of namespaces: %v", err)
+	}
 	return &runtime.RemoveImageResponse{}, nil
 }
@@ -0,0 +1,185 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+const (
+	// imageRepairPolicyNone doesn't repair images.
+	imageRepairPolicyNone = ""
+	// imageRepairPolicyUnpack unpacks image layers from the content store
+	// again, but never pulls from the registry. Images with missing or corrupt
+	// blobs are not repaired.
+	imageRepairPolicyUnpack = "unpack"
+	// imageRepairPolicyPull pulls missing and corrupt blobs from the registry
+	// again before unpacking.
+	imageRepairPolicyPull = "pull"
+)
+
+// validateImageRepairPolicy validates the image repair policy.
+func validateImageRepairPolicy(policy string) error {
+	switch policy {
+	case imageRepairPolicyNone, imageRepairPolicyUnpack, imageRepairPolicyPull:
+		return nil
+	}
+	return fmt.Errorf("invalid image repair policy %q, should be one of %q and %q",
+		policy, imageRepairPolicyUnpack, imageRepairPolicyPull)
+}
+
+// imageRepairLocks are locks of images being repaired, keyed by image id.
+type imageRepairLocks struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newImageRepairLocks() *imageRepairLocks {
+	return &imageRepairLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// get returns the lock of the image. Locks are kept once created, which is
+// fine because only broken images are repaired.
+func (l *imageRepairLocks) get(id string) *sync.Mutex {
+	l.Lock()
+	defer l.Unlock()
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[id] = lock
+	}
+	return lock
+}
+
+// imagePullAuths keeps the credentials images were pulled with, keyed by image
+// id, so that images are pulled again with the same credentials on repair.
+// They are only kept in memory, and lost on restart.
+type imagePullAuths struct {
+	sync.Mutex
+	auths map[string]*runtime.AuthConfig
+}
+
+func newImagePullAuths() *imagePullAuths {
+	return &imagePullAuths{auths: make(map[string]*runtime.AuthConfig)}
+}
+
+func (a *imagePullAuths) set(id string, auth *runtime.AuthConfig) {
+	a.Lock()
+	defer a.Unlock()
+	if auth == nil {
+		delete(a.auths, id)
+		return
+	}
+	a.auths[id] = auth
+}
+
+func (a *imagePullAuths) get(id string) *runtime.AuthConfig {
+	a.Lock()
+	defer a.Unlock()
+	return a.auths[id]
+}
+
+func (a *imagePullAuths) remove(id string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.auths, id)
+}
+
+// isImageContentError returns whether the error of preparing a snapshot from
+// the image means the image snapshots are missing, e.g. layers removed by
+// containerd garbage collection or lost on disk. Other errors, e.g. no space
+// or snapshotter failures, are not fixed by repairing the image.
+func isImageContentError(err error) bool {
+	return errdefs.IsNotFound(err)
+}
+
+// prepareContainerRootfsWithRepair prepares the container rootfs, and if that
+// fails because the image snapshots are missing, repairs the image according
+// to the image repair policy and retries once. The original error is returned
+// if the image can't be repaired.
+func (c *criContainerdService) prepareContainerRootfsWithRepair(ctx context.Context, id string,
+	config *runtime.ContainerConfig, image imagestore.Image) error {
+	err := c.prepareContainerSnapshot(ctx, id, config, image.ChainID)
+	if err == nil {
+		return nil
+	}
+	if c.config.ImageRepairPolicy == imageRepairPolicyNone || !isImageContentError(err) {
+		return newPhaseError(phaseRootfs, err, "failed to prepare container rootfs %q", image.ChainID)
+	}
+	glog.Warningf("Failed to prepare container %q rootfs, repair image %q: %v", id, image.ID, err)
+	if repairErr := c.repairImage(ctx, image); repairErr != nil {
+		glog.Errorf("Failed to repair image %q: %v", image.ID, repairErr)
+		return newPhaseError(phaseRootfs, err, "failed to prepare container rootfs %q", image.ChainID)
+	}
+	return c.prepareContainerRootfs(ctx, id, config, image.ChainID)
+}
+
+// repairImage rebuilds the snapshots of the image. Corrupt blobs are deleted
+// from the content store, and missing blobs are pulled again with the
+// credentials the image was pulled with, if the image repair policy allows.
+func (c *criContainerdService) repairImage(ctx context.Context, image imagestore.Image) error {
+	// Serialize repairs of the image, so that containers created concurrently
+	// from a broken image don't pull it multiple times.
+	lock := c.imageRepairLocks.get(image.ID)
+	lock.Lock()
+	defer lock.Unlock()
+	// The image may have been repaired while waiting for the lock.
+	if _, err := c.snapshotService.Stat(ctx, image.ChainID); err == nil {
+		return nil
+	}
+	// The image content has to be verified again after the repair.
+	c.verifiedImages.remove(image.ID)
+	result, err := c.verifyImageContent(ctx, image)
+	if err != nil {
+		return err
+	}
+	if err := c.deleteCorruptBlobs(ctx, result); err != nil {
+		return err
+	}
+	refs := append(append([]string{}, image.RepoDigests...), image.RepoTags...)
+	if !result.ok() {
+		if c.config.ImageRepairPolicy != imageRepairPolicyPull {
+			return fmt.Errorf("image has missing or corrupt blobs %+v, which are not pulled with image repair policy %q",
+				result.Blobs, c.config.ImageRepairPolicy)
+		}
+		ref := refs[0]
+		glog.Warningf("Pull image %q again for missing or corrupt blobs %+v", ref, result.Blobs)
+		pullID := c.pullProgress.start(ref, "")
+		_, _, _, err := c.pullImage(ctx, ref, c.imagePullAuths.get(image.ID), "", pullID)
+		c.pullProgress.finish(pullID, err)
+		if err != nil {
+			return fmt.Errorf("failed to pull image %q: %v", ref, err)
+		}
+		return nil
+	}
+	containerdImage, err := c.imageStoreService.Get(ctx, refs[0])
+	if err != nil {
+		return fmt.Errorf("failed to get image %q from containerd image store: %v", refs[0], err)
+	}
+	if err := c.unpackImage(ctx, containerdImage); err != nil {
+		return fmt.Errorf("failed to unpack image %q: %v", refs[0], err)
+	}
+	return nil
+}
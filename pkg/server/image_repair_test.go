@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	containerdmount "github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshot"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// repairSnapshotter is a fake snapshotter which fails to prepare snapshots
+// from parents not committed.
+type repairSnapshotter struct {
+	snapshot.Snapshotter
+	committed map[string]bool
+	prepared  map[string]string
+	// prepareErr is returned by Prepare if set.
+	prepareErr error
+}
+
+func newRepairSnapshotter() *repairSnapshotter {
+	return &repairSnapshotter{committed: make(map[string]bool), prepared: make(map[string]string)}
+}
+
+func (r *repairSnapshotter) Stat(_ gocontext.Context, key string) (snapshot.Info, error) {
+	if !r.committed[key] {
+		return snapshot.Info{}, errdefs.ErrNotFound
+	}
+	return snapshot.Info{Name: key}, nil
+}
+
+func (r *repairSnapshotter) Prepare(_ gocontext.Context, key, parent string) ([]containerdmount.Mount, error) {
+	if r.prepareErr != nil {
+		return nil, r.prepareErr
+	}
+	if parent != "" && !r.committed[parent] {
+		return nil, errdefs.ErrNotFound
+	}
+	r.prepared[key] = parent
+	return []containerdmount.Mount{{Type: "bind", Source: parent}}, nil
+}
+
+func (r *repairSnapshotter) Commit(_ gocontext.Context, name, key string) error {
+	delete(r.prepared, key)
+	r.committed[name] = true
+	return nil
+}
+
+func TestValidateImageRepairPolicy(t *testing.T) {
+	for _, policy := range []string{imageRepairPolicyNone, imageRepairPolicyUnpack, imageRepairPolicyPull} {
+		assert.NoError(t, validateImageRepairPolicy(policy))
+	}
+	assert.Error(t, validateImageRepairPolicy("always"))
+}
+
+func TestPrepareContainerRootfsWithRepair(t *testing.T) {
+	for desc, test := range map[string]struct {
+		policy        string
+		corruptLayer  bool
+		prepareErr    error
+		expectErr     bool
+		expectApplied int
+	}{
+		"should not repair without repair policy": {
+			expectErr: true,
+		},
+		"should unpack image again with unpack policy": {
+			policy:        imageRepairPolicyUnpack,
+			expectApplied: 1,
+		},
+		"should not repair on errors other than missing snapshots": {
+			policy:     imageRepairPolicyUnpack,
+			prepareErr: errdefs.ErrUnavailable,
+			expectErr:  true,
+		},
+		"should not pull corrupt layer with unpack policy": {
+			policy:       imageRepairPolicyUnpack,
+			corruptLayer: true,
+			expectErr:    true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c, blobs, config, layer := newTestVerifyImageService(t)
+		c.config.ImageRepairPolicy = test.policy
+		snapshotter := newRepairSnapshotter()
+		snapshotter.prepareErr = test.prepareErr
+		c.snapshotService = snapshotter
+		diff := &fakeDiffService{desc: imagespec.Descriptor{MediaType: imagespec.MediaTypeImageLayer, Digest: testDiffID}}
+		c.diffService = diff
+		if test.corruptLayer {
+			blobs.blobs[layer.Digest] = []byte("corrupt")
+		}
+		image, err := c.imageStore.Get(config.Digest.String())
+		require.NoError(t, err)
+
+		err = c.prepareContainerRootfsWithRepair(context.Background(), "test-id", &runtime.ContainerConfig{}, image)
+		assert.Equal(t, test.expectApplied, diff.applied)
+		if test.expectErr {
+			require.Error(t, err)
+			detail, ok := GetErrorDetail(err)
+			require.True(t, ok)
+			assert.Equal(t, phaseRootfs, detail.Phase)
+			assert.NotContains(t, snapshotter.prepared, "test-id")
+		} else {
+			require.NoError(t, err)
+			assert.Equal(t, image.ChainID, snapshotter.prepared["test-id"])
+		}
+		if test.corruptLayer {
+			assert.NotContains(t, blobs.blobs, layer.Digest, "corrupt layer should be deleted")
+		}
+	}
+}
+
+func TestImagePullAuths(t *testing.T) {
+	auths := newImagePullAuths()
+	auth := &runtime.AuthConfig{Username: "user", Password: "password"}
+	auths.set("image-id", auth)
+	assert.Equal(t, auth, auths.get("image-id"))
+	// Pulling again without credentials forgets the old ones.
+	auths.set("image-id", nil)
+	assert.Nil(t, auths.get("image-id"))
+	auths.set("image-id", auth)
+	auths.remove("image-id")
+	assert.Nil(t, auths.get("image-id"))
+}
+
+func TestImageRepairLocks(t *testing.T) {
+	locks := newImageRepairLocks()
+	assert.True(t, locks.get("image-1") == locks.get("image-1"), "lock of an image should be reused")
+	assert.False(t, locks.get("image-1") == locks.get("image-2"), "images should have different locks")
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// imageRewriteRule rewrites image references matching from into to. A from
+// ending with "*" matches all references with the prefix, and the rest of the
+// reference replaces the "*" at the end of to. Otherwise from only matches the
+// reference exactly.
+type imageRewriteRule struct {
+	from string
+	to   string
+}
+
+// parseImageRewriteRules parses "from=to" rules.
+func parseImageRewriteRules(rules []string) ([]imageRewriteRule, error) {
+	var parsed []imageRewriteRule
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid image rewrite rule %q, should be from=to", rule)
+		}
+		from, to := parts[0], parts[1]
+		if strings.HasSuffix(from, "*") != strings.HasSuffix(to, "*") {
+			return nil, fmt.Errorf("invalid image rewrite rule %q, both or neither of from and to should end with *", rule)
+		}
+		if strings.Count(from, "*") > 1 || strings.Count(to, "*") > 1 ||
+			(strings.Contains(from, "*") && !strings.HasSuffix(from, "*")) {
+			return nil, fmt.Errorf("invalid image rewrite rule %q, * is only allowed at the end", rule)
+		}
+		parsed = append(parsed, imageRewriteRule{from: from, to: to})
+	}
+	return parsed, nil
+}
+
+// rewriteImageRef rewrites the normalized image reference with the first
+// matching rule. Rules match against the normalized reference, e.g.
+// "docker.io/library/busybox:latest". It returns false if no rule matches.
+func rewriteImageRef(rules []imageRewriteRule, named reference.Named) (reference.Named, bool, error) {
+	ref := named.String()
+	for _, rule := range rules {
+		var rewritten string
+		if prefix := strings.TrimSuffix(rule.from, "*"); prefix != rule.from {
+			if !strings.HasPrefix(ref, prefix) {
+				continue
+			}
+			rewritten = strings.TrimSuffix(rule.to, "*") + strings.TrimPrefix(ref, prefix)
+		} else {
+			if ref != rule.from {
+				continue
+			}
+			rewritten = rule.to
+		}
+		newNamed, err := normalizeImageRef(rewritten)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid image reference %q rewritten from %q by rule %q=%q: %v",
+				rewritten, ref, rule.from, rule.to, err)
+		}
+		return newNamed, true, nil
+	}
+	return named, false, nil
+}
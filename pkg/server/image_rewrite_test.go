@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageRewriteRules(t *testing.T) {
+	for desc, test := range map[string]struct {
+		rules     []string
+		expected  []imageRewriteRule
+		expectErr bool
+	}{
+		"no rules": {},
+		"prefix and exact rules": {
+			rules: []string{"k8s.gcr.io/*=registry.internal/k8s/*", "docker.io/library/busybox:latest=registry.internal/busybox:1.27"},
+			expected: []imageRewriteRule{
+				{from: "k8s.gcr.io/*", to: "registry.internal/k8s/*"},
+				{from: "docker.io/library/busybox:latest", to: "registry.internal/busybox:1.27"},
+			},
+		},
+		"missing to": {
+			rules:     []string{"k8s.gcr.io/*="},
+			expectErr: true,
+		},
+		"missing separator": {
+			rules:     []string{"k8s.gcr.io/*"},
+			expectErr: true,
+		},
+		"wildcard only in from": {
+			rules:     []string{"k8s.gcr.io/*=registry.internal/pause:3.0"},
+			expectErr: true,
+		},
+		"wildcard not at the end": {
+			rules:     []string{"k8s.gcr.io/*/pause*=registry.internal/*"},
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		rules, err := parseImageRewriteRules(test.rules)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, rules)
+	}
+}
+
+func TestRewriteImageRef(t *testing.T) {
+	rules, err := parseImageRewriteRules([]string{
+		"docker.io/library/busybox:latest=registry.internal/busybox:1.27",
+		"k8s.gcr.io/*=registry.internal/k8s/*",
+		"gcr.io/*=registry.internal/invalid:*",
+	})
+	require.NoError(t, err)
+	for desc, test := range map[string]struct {
+		ref       string
+		expected  string
+		rewritten bool
+		expectErr bool
+	}{
+		"prefix rule should keep the rest of the reference": {
+			ref:       "k8s.gcr.io/pause:3.0",
+			expected:  "registry.internal/k8s/pause:3.0",
+			rewritten: true,
+		},
+		"prefix rule should keep the digest": {
+			ref:       "k8s.gcr.io/pause@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			expected:  "registry.internal/k8s/pause@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			rewritten: true,
+		},
+		"exact rule should match normalized reference": {
+			ref:       "busybox",
+			expected:  "registry.internal/busybox:1.27",
+			rewritten: true,
+		},
+		"exact rule should not match other tags": {
+			ref:      "busybox:1.26",
+			expected: "docker.io/library/busybox:1.26",
+		},
+		"no matching rule": {
+			ref:      "quay.io/coreos/etcd:v3.2",
+			expected: "quay.io/coreos/etcd:v3.2",
+		},
+		"invalid rewritten reference": {
+			ref:       "gcr.io/google_containers/pause:3.0",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		named, err := normalizeImageRef(test.ref)
+		require.NoError(t, err)
+		rewrittenNamed, rewritten, err := rewriteImageRef(rules, named)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.rewritten, rewritten)
+		assert.Equal(t, test.expected, rewrittenNamed.String())
+	}
+}
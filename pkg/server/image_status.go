@@ -43,6 +43,16 @@ func (c *criContainerdService) ImageStatus(ctx context.Context, r *runtime.Image
 		// return empty without error when image not found.
 		return &runtime.ImageStatusResponse{}, nil
 	}
+	if c.config.ImageStatusVerifyContent {
+		intact, err := c.checkImageContent(ctx, *image)
+		if err != nil {
+			return nil, err
+		}
+		if !intact {
+			// Report the corrupt image as absent, so that it is pulled again.
+			return &runtime.ImageStatusResponse{}, nil
+		}
+	}
 	// TODO(random-liu): [P0] Make sure corresponding snapshot exists. What if snapshot
 	// doesn't exist?
 	runtimeImage := &runtime.Image{
@@ -0,0 +1,197 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"fmt"
+	"sync"
+
+	containerdimages "github.com/containerd/containerd/images"
+	containerdrootfs "github.com/containerd/containerd/rootfs"
+	"github.com/golang/glog"
+	imagedigest "github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+)
+
+// limitDownloads wraps the fetch handler, so that layers are only downloaded
+// when there is a free download slot. Layers of all pulls share the slots, and
+// manifests and configs are small, so they are not limited.
+func (c *criContainerdService) limitDownloads(fetch containerdimages.Handler) containerdimages.HandlerFunc {
+	return func(ctx gocontext.Context, desc imagespec.Descriptor) ([]imagespec.Descriptor, error) {
+		if c.downloadSlots == nil || !isLayer(desc) {
+			return fetch.Handle(ctx, desc)
+		}
+		select {
+		case c.downloadSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-c.downloadSlots }()
+		return fetch.Handle(ctx, desc)
+	}
+}
+
+// fetchedSet tracks contents fetched into the content store, so that layers
+// could be unpacked as soon as they are downloaded.
+type fetchedSet struct {
+	sync.Mutex
+	fetched map[imagedigest.Digest]chan struct{}
+	// all means all contents are fetched.
+	all bool
+}
+
+func newFetchedSet() *fetchedSet {
+	return &fetchedSet{fetched: make(map[imagedigest.Digest]chan struct{})}
+}
+
+// ch returns the channel closed once the content is fetched. It should be
+// called with the lock held.
+func (f *fetchedSet) ch(dgst imagedigest.Digest) chan struct{} {
+	ch, ok := f.fetched[dgst]
+	if !ok {
+		ch = make(chan struct{})
+		if f.all {
+			close(ch)
+		}
+		f.fetched[dgst] = ch
+	}
+	return ch
+}
+
+// done marks the content as fetched.
+func (f *fetchedSet) done(dgst imagedigest.Digest) {
+	f.Lock()
+	defer f.Unlock()
+	ch := f.ch(dgst)
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// doneAll marks all contents as fetched. It is called once the download
+// finishes, because contents fetched by other pulls are not marked.
+func (f *fetchedSet) doneAll() {
+	f.Lock()
+	defer f.Unlock()
+	f.all = true
+	for _, ch := range f.fetched {
+		select {
+		case <-ch:
+		default:
+			close(ch)
+		}
+	}
+}
+
+// handler returns the image handler marking contents as fetched, it should
+// follow the fetch handler.
+func (f *fetchedSet) handler() containerdimages.HandlerFunc {
+	return func(ctx gocontext.Context, desc imagespec.Descriptor) ([]imagespec.Descriptor, error) {
+		f.done(desc.Digest)
+		return nil, nil
+	}
+}
+
+// wait waits until the content is fetched.
+func (f *fetchedSet) wait(ctx context.Context, dgst imagedigest.Digest) error {
+	f.Lock()
+	ch := f.ch(dgst)
+	f.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pipelinedUnpack unpacks image layers in order while they are being downloaded.
+type pipelinedUnpack struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// startPipelinedUnpack starts unpacking layers of the image manifest as soon as
+// each layer is fetched, so that unpacking overlaps downloading of later layers.
+// Only image manifests are unpacked this way, other images, e.g. schema 1 images
+// which are converted after downloading, are unpacked after downloading.
+func (c *criContainerdService) startPipelinedUnpack(ctx context.Context, desc imagespec.Descriptor,
+	fetched *fetchedSet) *pipelinedUnpack {
+	ctx, cancel := context.WithCancel(ctx)
+	u := &pipelinedUnpack{cancel: cancel, done: make(chan struct{})}
+	switch desc.MediaType {
+	case containerdimages.MediaTypeDockerSchema2Manifest, imagespec.MediaTypeImageManifest:
+	default:
+		close(u.done)
+		return u
+	}
+	go func() {
+		defer close(u.done)
+		u.err = c.unpackFetchedLayers(ctx, containerdimages.Image{Target: desc}, fetched)
+	}()
+	return u
+}
+
+// wait waits for the unpack to finish and returns its error.
+func (u *pipelinedUnpack) wait() error {
+	<-u.done
+	return u.err
+}
+
+// stop cancels the unpack and waits for it to finish, so that it doesn't
+// operate on snapshots concurrently with others.
+func (u *pipelinedUnpack) stop() {
+	u.cancel()
+	<-u.done
+}
+
+// unpackFetchedLayers unpacks layers of the image in order, waiting for the
+// manifest, config and each layer to be fetched.
+func (c *criContainerdService) unpackFetchedLayers(ctx context.Context, image containerdimages.Image,
+	fetched *fetchedSet) error {
+	if err := fetched.wait(ctx, image.Target.Digest); err != nil {
+		return err
+	}
+	config, err := image.Config(ctx, c.contentStoreService)
+	if err != nil {
+		return fmt.Errorf("failed to get image config descriptor: %v", err)
+	}
+	if err := fetched.wait(ctx, config.Digest); err != nil {
+		return err
+	}
+	layers, err := c.imageLayers(ctx, image)
+	if err != nil {
+		return err
+	}
+	var chain []imagedigest.Digest
+	for _, layer := range layers {
+		if err := fetched.wait(ctx, layer.Blob.Digest); err != nil {
+			return err
+		}
+		if _, err := containerdrootfs.ApplyLayer(ctx, layer, chain, c.snapshotService, c.diffService); err != nil {
+			return fmt.Errorf("failed to apply layer %q: %v", layer.Blob.Digest, err)
+		}
+		glog.V(4).Infof("Unpacked layer %q of image manifest %q", layer.Blob.Digest, image.Target.Digest)
+		chain = append(chain, layer.Diff.Digest)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"testing"
+	"time"
+
+	containerdimages "github.com/containerd/containerd/images"
+	imagedigest "github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestLimitDownloads(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.downloadSlots = make(chan struct{}, 1)
+	started := make(chan string, 3)
+	unblock := make(chan struct{})
+	handler := c.limitDownloads(containerdimages.HandlerFunc(
+		func(ctx gocontext.Context, desc imagespec.Descriptor) ([]imagespec.Descriptor, error) {
+			started <- desc.Digest.String()
+			<-unblock
+			return nil, nil
+		}))
+	layer := func(d string) imagespec.Descriptor {
+		return imagespec.Descriptor{MediaType: imagespec.MediaTypeImageLayerGzip, Digest: imagedigest.Digest("sha256:" + d)}
+	}
+	for _, desc := range []imagespec.Descriptor{
+		layer("layer-1"),
+		layer("layer-2"),
+		{MediaType: imagespec.MediaTypeImageConfig, Digest: "sha256:config"},
+	} {
+		go handler(context.Background(), desc) // nolint: errcheck
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-started:
+			got[d] = true
+		case <-time.After(10 * time.Second):
+			t.Fatal("downloads should start")
+		}
+	}
+	assert.True(t, got["sha256:config"], "config should not be limited")
+	select {
+	case d := <-started:
+		t.Fatalf("download of %q should wait for a free slot", d)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(unblock)
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("download should start once a slot is free")
+	}
+
+	t.Logf("should return error if cancelled while waiting for a slot")
+	c.downloadSlots <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := handler(ctx, layer("layer-3"))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestFetchedSet(t *testing.T) {
+	f := newFetchedSet()
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- f.wait(context.Background(), "sha256:layer-1") }()
+	_, err := f.handler()(context.Background(), imagespec.Descriptor{Digest: "sha256:layer-1"})
+	require.NoError(t, err)
+	assert.NoError(t, <-waitErr)
+	t.Logf("marking content fetched twice should not panic")
+	f.done("sha256:layer-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, f.wait(ctx, "sha256:layer-2"), "should not return before content is fetched")
+
+	go func() { waitErr <- f.wait(context.Background(), "sha256:layer-2") }()
+	f.doneAll()
+	assert.NoError(t, <-waitErr)
+	assert.NoError(t, f.wait(context.Background(), "sha256:layer-3"), "all contents should be fetched after doneAll")
+}
+
+func TestStartPipelinedUnpackSkipsNonManifest(t *testing.T) {
+	c := newTestCRIContainerdService()
+	for _, mediaType := range []string{
+		containerdimages.MediaTypeDockerSchema1Manifest,
+		imagespec.MediaTypeImageIndex,
+		containerdimages.MediaTypeDockerSchema2ManifestList,
+	} {
+		u := c.startPipelinedUnpack(context.Background(), imagespec.Descriptor{MediaType: mediaType}, newFetchedSet())
+		assert.NoError(t, u.wait(), mediaType)
+		u.stop()
+	}
+}
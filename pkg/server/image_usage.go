@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/containerd/containerd/content"
+	containerdimages "github.com/containerd/containerd/images"
+	"github.com/golang/glog"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+)
+
+// imageUsage is the disk usage report of the content store.
+type imageUsage struct {
+	// TotalSize is the total size of all contents in the content store.
+	TotalSize int64 `json:"totalSize"`
+	// ContentCount is the number of contents in the content store.
+	ContentCount int `json:"contentCount"`
+	// SharedSize is the size of contents referenced by more than one image.
+	SharedSize int64 `json:"sharedSize"`
+	// ReclaimableSize is the size of contents not referenced by any image,
+	// which could be reclaimed without removing any image.
+	ReclaimableSize int64 `json:"reclaimableSize"`
+	// Images is the usage of each image.
+	Images []imageUsageEntry `json:"images"`
+}
+
+// imageUsageEntry is the disk usage of an image in the content store.
+type imageUsageEntry struct {
+	ID       string   `json:"id"`
+	RepoTags []string `json:"repoTags,omitempty"`
+	// Size is the size of all contents referenced by the image.
+	Size int64 `json:"size"`
+	// UniqueSize is the size of contents only referenced by the image, which
+	// is reclaimed when the image is removed.
+	UniqueSize int64 `json:"uniqueSize"`
+	// SharedSize is the size of contents also referenced by other images.
+	SharedSize int64 `json:"sharedSize"`
+	// Error is set when contents of the image can't be walked, e.g. the
+	// manifest is missing.
+	Error string `json:"error,omitempty"`
+}
+
+// getImageUsage walks the content store and all images, and returns the disk
+// usage report.
+func (c *criContainerdService) getImageUsage(ctx context.Context) (*imageUsage, error) {
+	contents := make(map[digest.Digest]int64)
+	if err := c.contentStoreService.Walk(ctx, func(info content.Info) error {
+		contents[info.Digest] = info.Size
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to walk content store: %v", err)
+	}
+	var entries []imageUsageEntry
+	refs := make(map[string][]digest.Digest)
+	for _, image := range c.imageStore.List() {
+		entry := imageUsageEntry{ID: image.ID, RepoTags: image.RepoTags}
+		digests, err := c.getImageContents(ctx, image.ID)
+		if err != nil {
+			glog.Warningf("Failed to get contents of image %q: %v", image.ID, err)
+			entry.Error = err.Error()
+		}
+		refs[image.ID] = digests
+		entries = append(entries, entry)
+	}
+	return computeImageUsage(contents, entries, refs), nil
+}
+
+// getImageContents returns digests of all contents referenced by the image.
+func (c *criContainerdService) getImageContents(ctx context.Context, id string) ([]digest.Digest, error) {
+	image, err := c.imageStoreService.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %q from containerd image store: %v", id, err)
+	}
+	var digests []digest.Digest
+	collect := containerdimages.HandlerFunc(func(ctx gocontext.Context, desc imagespec.Descriptor) (
+		[]imagespec.Descriptor, error) {
+		digests = append(digests, desc.Digest)
+		return nil, nil
+	})
+	handler := containerdimages.Handlers(collect, containerdimages.ChildrenHandler(c.contentStoreService))
+	if err := containerdimages.Walk(ctx, handler, image.Target); err != nil {
+		return digests, fmt.Errorf("failed to walk image contents: %v", err)
+	}
+	return digests, nil
+}
+
+// computeImageUsage computes the disk usage report from sizes of all contents and
+// contents referenced by each image.
+func computeImageUsage(contents map[digest.Digest]int64, entries []imageUsageEntry,
+	refs map[string][]digest.Digest) *imageUsage {
+	usage := &imageUsage{ContentCount: len(contents)}
+	for _, size := range contents {
+		usage.TotalSize += size
+	}
+	// Count images referencing each content. Contents referenced multiple times
+	// by the same image are only counted once.
+	refCount := make(map[digest.Digest]int)
+	for id := range refs {
+		for d := range uniqueDigests(refs[id]) {
+			refCount[d]++
+		}
+	}
+	for d, size := range contents {
+		switch count := refCount[d]; {
+		case count == 0:
+			usage.ReclaimableSize += size
+		case count > 1:
+			usage.SharedSize += size
+		}
+	}
+	for _, entry := range entries {
+		for d := range uniqueDigests(refs[entry.ID]) {
+			// Contents not in the content store don't use any disk.
+			size, ok := contents[d]
+			if !ok {
+				continue
+			}
+			entry.Size += size
+			if refCount[d] > 1 {
+				entry.SharedSize += size
+			} else {
+				entry.UniqueSize += size
+			}
+		}
+		usage.Images = append(usage.Images, entry)
+	}
+	// Images reclaiming most space are listed first.
+	sort.Slice(usage.Images, func(i, j int) bool {
+		if usage.Images[i].UniqueSize != usage.Images[j].UniqueSize {
+			return usage.Images[i].UniqueSize > usage.Images[j].UniqueSize
+		}
+		return usage.Images[i].ID < usage.Images[j].ID
+	})
+	return usage
+}
+
+// uniqueDigests returns the set of digests.
+func uniqueDigests(digests []digest.Digest) map[digest.Digest]struct{} {
+	set := make(map[digest.Digest]struct{})
+	for _, d := range digests {
+		set[d] = struct{}{}
+	}
+	return set
+}
+
+// handleImageUsage handles the image-usage debug endpoint. It returns the disk
+// usage report of the content store.
+func (c *criContainerdService) handleImageUsage(w http.ResponseWriter, r *http.Request) {
+	usage, err := c.getImageUsage(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, usage)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeImageUsage(t *testing.T) {
+	contents := map[digest.Digest]int64{
+		"sha256:manifest-1": 1,
+		"sha256:manifest-2": 2,
+		"sha256:config-1":   10,
+		"sha256:config-2":   20,
+		"sha256:base":       1000,
+		"sha256:layer-1":    100,
+		"sha256:layer-2":    200,
+		"sha256:orphan":     5000,
+	}
+	entries := []imageUsageEntry{
+		{ID: "image-1", RepoTags: []string{"image-1:latest"}},
+		{ID: "image-2"},
+		{ID: "image-3", Error: "missing manifest"},
+	}
+	refs := map[string][]digest.Digest{
+		"image-1": {"sha256:manifest-1", "sha256:config-1", "sha256:base", "sha256:layer-1"},
+		// Duplicated layers and missing contents should be handled.
+		"image-2": {"sha256:manifest-2", "sha256:config-2", "sha256:base", "sha256:layer-2", "sha256:layer-2", "sha256:missing"},
+	}
+	usage := computeImageUsage(contents, entries, refs)
+	assert.Equal(t, &imageUsage{
+		TotalSize:       6333,
+		ContentCount:    8,
+		SharedSize:      1000,
+		ReclaimableSize: 5000,
+		Images: []imageUsageEntry{
+			{ID: "image-2", Size: 1222, UniqueSize: 222, SharedSize: 1000},
+			{ID: "image-1", RepoTags: []string{"image-1:latest"}, Size: 1111, UniqueSize: 111, SharedSize: 1000},
+			{ID: "image-3", Error: "missing manifest"},
+		},
+	}, usage)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	containerdimages "github.com/containerd/containerd/images"
+	"github.com/golang/glog"
+	imagedigest "github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+const (
+	// blobMissing means the blob doesn't exist in the content store.
+	blobMissing = "missing"
+	// blobCorrupt means the blob content doesn't match its digest or size.
+	blobCorrupt = "corrupt"
+)
+
+// imageVerifyResult is the result of verifying content of an image.
+type imageVerifyResult struct {
+	// Blobs maps digests of missing or corrupt blobs to blobMissing or
+	// blobCorrupt.
+	Blobs map[string]string
+}
+
+// ok returns whether all blobs of the image are intact.
+func (r *imageVerifyResult) ok() bool {
+	return len(r.Blobs) == 0
+}
+
+// verifiedImages is the set of ids of images whose content is verified, so
+// that content of an image is only hashed once. It also keeps the results of
+// images found with missing or corrupt content, until they are pulled again.
+type verifiedImages struct {
+	sync.Mutex
+	ids     map[string]bool
+	corrupt map[string]*imageVerifyResult
+}
+
+func newVerifiedImages() *verifiedImages {
+	return &verifiedImages{ids: make(map[string]bool), corrupt: make(map[string]*imageVerifyResult)}
+}
+
+func (v *verifiedImages) has(id string) bool {
+	v.Lock()
+	defer v.Unlock()
+	return v.ids[id]
+}
+
+func (v *verifiedImages) add(id string) {
+	v.Lock()
+	defer v.Unlock()
+	v.ids[id] = true
+}
+
+func (v *verifiedImages) remove(id string) {
+	v.Lock()
+	defer v.Unlock()
+	delete(v.ids, id)
+	delete(v.corrupt, id)
+}
+
+func (v *verifiedImages) addCorrupt(id string, result *imageVerifyResult) {
+	v.Lock()
+	defer v.Unlock()
+	v.corrupt[id] = result
+}
+
+func (v *verifiedImages) getCorrupt(id string) *imageVerifyResult {
+	v.Lock()
+	defer v.Unlock()
+	return v.corrupt[id]
+}
+
+// verifyImageContent verifies that all blobs referenced by the image exist in
+// the content store, and hash to their digests. Children of missing or corrupt
+// manifests are not verified, because they can't be read.
+func (c *criContainerdService) verifyImageContent(ctx context.Context, image imagestore.Image) (*imageVerifyResult, error) {
+	refs := append(append([]string{}, image.RepoDigests...), image.RepoTags...)
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("image %q has no reference", image.ID)
+	}
+	containerdImage, err := c.imageStoreService.Get(ctx, refs[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image %q from containerd image store: %v", refs[0], err)
+	}
+	result := &imageVerifyResult{Blobs: make(map[string]string)}
+	children := containerdimages.ChildrenHandler(c.contentStoreService)
+	handler := containerdimages.HandlerFunc(func(ctx gocontext.Context, desc imagespec.Descriptor) ([]imagespec.Descriptor, error) {
+		state, err := verifyBlob(ctx, c.contentStoreService, desc)
+		if err != nil {
+			return nil, err
+		}
+		if state != "" {
+			result.Blobs[desc.Digest.String()] = state
+			return nil, nil
+		}
+		return children(ctx, desc)
+	})
+	if err := containerdimages.Walk(ctx, handler, containerdImage.Target); err != nil {
+		return nil, fmt.Errorf("failed to walk image %q: %v", image.ID, err)
+	}
+	return result, nil
+}
+
+// verifyBlob returns blobMissing or blobCorrupt if the blob of the descriptor
+// is missing or corrupt, and empty if it is intact.
+func verifyBlob(ctx gocontext.Context, provider content.Provider, desc imagespec.Descriptor) (string, error) {
+	rc, err := provider.Reader(ctx, desc.Digest)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return blobMissing, nil
+		}
+		return "", fmt.Errorf("failed to read blob %q: %v", desc.Digest, err)
+	}
+	defer rc.Close()
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(verifier, rc)
+	if err != nil {
+		return "", fmt.Errorf("failed to read blob %q: %v", desc.Digest, err)
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return blobCorrupt, nil
+	}
+	return "", nil
+}
+
+// checkImageContent verifies content of the image if it is not verified yet,
+// and returns whether it is intact. It never changes the image or its content,
+// an image with missing or corrupt blobs is only logged, counted and recorded,
+// so that the corrupt blobs are deleted by the next pull of the image.
+func (c *criContainerdService) checkImageContent(ctx context.Context, image imagestore.Image) (bool, error) {
+	if c.verifiedImages.has(image.ID) {
+		return true, nil
+	}
+	result, err := c.verifyImageContent(ctx, image)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify image %q content: %v", image.ID, err)
+	}
+	if result.ok() {
+		c.verifiedImages.add(image.ID)
+		return true, nil
+	}
+	glog.Warningf("Image %q content is not intact, report it as absent to be pulled again: %+v", image.ID, result.Blobs)
+	if c.verifiedImages.getCorrupt(image.ID) == nil {
+		c.metrics.corruptImages.Inc()
+	}
+	c.verifiedImages.addCorrupt(image.ID, result)
+	return false, nil
+}
+
+// deleteCorruptImageBlobs deletes the corrupt blobs of the image found by
+// checkImageContent before the image is pulled again, because blobs already in
+// the content store are not fetched again. Missing blobs are fetched anyway.
+func (c *criContainerdService) deleteCorruptImageBlobs(ctx context.Context, ref string) error {
+	image, err := c.localResolve(ctx, ref)
+	if err != nil || image == nil {
+		// The image is pulled as a new image.
+		return nil
+	}
+	result := c.verifiedImages.getCorrupt(image.ID)
+	if result == nil {
+		return nil
+	}
+	if err := c.deleteCorruptBlobs(ctx, result); err != nil {
+		return fmt.Errorf("failed to clean up image %q content: %v", image.ID, err)
+	}
+	c.verifiedImages.remove(image.ID)
+	return nil
+}
+
+// deleteCorruptBlobs deletes corrupt blobs found by verification from the
+// content store, so that they are fetched again by the next pull.
+func (c *criContainerdService) deleteCorruptBlobs(ctx context.Context, result *imageVerifyResult) error {
+	for dgst, state := range result.Blobs {
+		if state != blobCorrupt {
+			continue
+		}
+		if err := c.contentStoreService.Delete(ctx, imagedigest.Digest(dgst)); err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to delete corrupt blob %q: %v", dgst, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	gocontext "context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	containerdimages "github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+)
+
+// fakeBlobStore is an in-memory content store keyed by digest.
+type fakeBlobStore struct {
+	content.Store
+	blobs map[digest.Digest][]byte
+}
+
+func (f *fakeBlobStore) Reader(_ gocontext.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	b, ok := f.blobs[dgst]
+	if !ok {
+		return nil, errdefs.ErrNotFound
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (f *fakeBlobStore) Info(_ gocontext.Context, dgst digest.Digest) (content.Info, error) {
+	b, ok := f.blobs[dgst]
+	if !ok {
+		return content.Info{}, errdefs.ErrNotFound
+	}
+	return content.Info{Digest: dgst, Size: int64(len(b))}, nil
+}
+
+func (f *fakeBlobStore) Delete(_ gocontext.Context, dgst digest.Digest) error {
+	if _, ok := f.blobs[dgst]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(f.blobs, dgst)
+	return nil
+}
+
+// fakeImageStore is an in-memory containerd image store.
+type fakeImageStore struct {
+	containerdimages.Store
+	images map[string]containerdimages.Image
+}
+
+func (f *fakeImageStore) Get(_ gocontext.Context, name string) (containerdimages.Image, error) {
+	image, ok := f.images[name]
+	if !ok {
+		return containerdimages.Image{}, errdefs.ErrNotFound
+	}
+	return image, nil
+}
+
+func (f *fakeImageStore) Create(_ gocontext.Context, image containerdimages.Image) (containerdimages.Image, error) {
+	if _, ok := f.images[image.Name]; ok {
+		return containerdimages.Image{}, errdefs.ErrAlreadyExists
+	}
+	f.images[image.Name] = image
+	return image, nil
+}
+
+func (f *fakeImageStore) Update(_ gocontext.Context, image containerdimages.Image, _ ...string) (containerdimages.Image, error) {
+	if _, ok := f.images[image.Name]; !ok {
+		return containerdimages.Image{}, errdefs.ErrNotFound
+	}
+	f.images[image.Name] = image
+	return image, nil
+}
+
+func (f *fakeImageStore) Delete(_ gocontext.Context, name string) error {
+	if _, ok := f.images[name]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(f.images, name)
+	return nil
+}
+
+// testDiffID is the diff id of the layer of the test image.
+var testDiffID = digest.FromBytes([]byte("test-diff"))
+
+// addTestBlob adds data into the blob store and returns its descriptor.
+func addTestBlob(store *fakeBlobStore, mediaType string, data []byte) imagespec.Descriptor {
+	desc := imagespec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	store.blobs[desc.Digest] = data
+	return desc
+}
+
+// newTestVerifyImageService returns a service with a test image made of a
+// manifest, a config and a layer, and descriptors of the config and layer.
+func newTestVerifyImageService(t *testing.T) (*criContainerdService, *fakeBlobStore, imagespec.Descriptor, imagespec.Descriptor) {
+	c := newTestCRIContainerdService()
+	blobs := &fakeBlobStore{blobs: make(map[digest.Digest][]byte)}
+	imageConfig, err := json.Marshal(imagespec.Image{
+		Architecture: "amd64",
+		RootFS:       imagespec.RootFS{Type: "layers", DiffIDs: []digest.Digest{testDiffID}},
+	})
+	require.NoError(t, err)
+	config := addTestBlob(blobs, imagespec.MediaTypeImageConfig, imageConfig)
+	layer := addTestBlob(blobs, imagespec.MediaTypeImageLayer, []byte("test-layer"))
+	manifest, err := json.Marshal(imagespec.Manifest{Config: config, Layers: []imagespec.Descriptor{layer}})
+	require.NoError(t, err)
+	target := addTestBlob(blobs, imagespec.MediaTypeImageManifest, manifest)
+	c.contentStoreService = blobs
+	c.imageStoreService = &fakeImageStore{images: map[string]containerdimages.Image{
+		"docker.io/library/busybox:latest": {Name: "docker.io/library/busybox:latest", Target: target},
+	}}
+	c.imageStore.Add(imagestore.Image{
+		ID:       config.Digest.String(),
+		ChainID:  identity.ChainID([]digest.Digest{testDiffID}).String(),
+		RepoTags: []string{"docker.io/library/busybox:latest"},
+		Config:   &imagespec.ImageConfig{},
+	})
+	return c, blobs, config, layer
+}
+
+func TestVerifyImageContent(t *testing.T) {
+	for desc, test := range map[string]struct {
+		corrupt  func(*fakeBlobStore, imagespec.Descriptor)
+		expected string
+	}{
+		"intact image": {
+			corrupt: func(*fakeBlobStore, imagespec.Descriptor) {},
+		},
+		"missing layer": {
+			corrupt:  func(s *fakeBlobStore, d imagespec.Descriptor) { delete(s.blobs, d.Digest) },
+			expected: blobMissing,
+		},
+		"corrupt layer": {
+			corrupt:  func(s *fakeBlobStore, d imagespec.Descriptor) { s.blobs[d.Digest] = []byte("test-lay3r") },
+			expected: blobCorrupt,
+		},
+		"truncated layer": {
+			corrupt:  func(s *fakeBlobStore, d imagespec.Descriptor) { s.blobs[d.Digest] = []byte("test") },
+			expected: blobCorrupt,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c, blobs, config, layer := newTestVerifyImageService(t)
+		test.corrupt(blobs, layer)
+		image, err := c.imageStore.Get(config.Digest.String())
+		require.NoError(t, err)
+		result, err := c.verifyImageContent(context.Background(), image)
+		require.NoError(t, err)
+		if test.expected == "" {
+			assert.True(t, result.ok())
+			continue
+		}
+		assert.Equal(t, map[string]string{layer.Digest.String(): test.expected}, result.Blobs)
+	}
+}
+
+func TestImageStatusVerifyContent(t *testing.T) {
+	for desc, test := range map[string]struct {
+		verify   bool
+		corrupt  bool
+		expected bool
+	}{
+		"verification disabled": {
+			corrupt:  true,
+			expected: true,
+		},
+		"intact image": {
+			verify:   true,
+			expected: true,
+		},
+		"corrupt image": {
+			verify:  true,
+			corrupt: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c, blobs, config, _ := newTestVerifyImageService(t)
+		c.config.ImageStatusVerifyContent = test.verify
+		if test.corrupt {
+			blobs.blobs[config.Digest] = []byte("corrupt")
+		}
+		resp, err := c.ImageStatus(context.Background(), &runtime.ImageStatusRequest{
+			Image: &runtime.ImageSpec{Image: "busybox"},
+		})
+		require.NoError(t, err)
+		if !test.expected {
+			assert.Nil(t, resp.GetImage())
+			_, err = c.imageStore.Get(config.Digest.String())
+			assert.NoError(t, err, "corrupt image should not be removed")
+			assert.Contains(t, blobs.blobs, config.Digest, "corrupt blob should not be deleted")
+			assert.NotNil(t, c.verifiedImages.getCorrupt(config.Digest.String()))
+			assert.Equal(t, float64(1), c.metrics.corruptImages.Value())
+			continue
+		}
+		require.NotNil(t, resp.GetImage())
+		assert.Equal(t, config.Digest.String(), resp.GetImage().GetId())
+		assert.Equal(t, test.verify, c.verifiedImages.has(config.Digest.String()))
+	}
+}
+
+func TestDeleteCorruptImageBlobs(t *testing.T) {
+	c, blobs, config, layer := newTestVerifyImageService(t)
+	c.config.ImageStatusVerifyContent = true
+	blobs.blobs[config.Digest] = []byte("corrupt")
+	delete(blobs.blobs, layer.Digest)
+	_, err := c.ImageStatus(context.Background(), &runtime.ImageStatusRequest{
+		Image: &runtime.ImageSpec{Image: "busybox"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, c.deleteCorruptImageBlobs(context.Background(), "busybox"))
+	assert.NotContains(t, blobs.blobs, config.Digest, "corrupt blob should be deleted")
+	assert.Nil(t, c.verifiedImages.getCorrupt(config.Digest.String()))
+	_, err = c.imageStore.Get(config.Digest.String())
+	assert.NoError(t, err, "image should be kept to be updated by the pull")
+}
+
+func TestImageStatusVerifyContentOnce(t *testing.T) {
+	c, blobs, config, _ := newTestVerifyImageService(t)
+	c.config.ImageStatusVerifyContent = true
+	req := &runtime.ImageStatusRequest{Image: &runtime.ImageSpec{Image: "busybox"}}
+	_, err := c.ImageStatus(context.Background(), req)
+	require.NoError(t, err)
+	// Content of a verified image is not hashed again.
+	blobs.blobs[config.Digest] = []byte("corrupt")
+	resp, err := c.ImageStatus(context.Background(), req)
+	require.NoError(t, err)
+	assert.NotNil(t, resp.GetImage())
+}
@@ -17,14 +17,108 @@ limitations under the License.
 package server
 
 import (
-	"errors"
+	gocontext "context"
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/containerd/containerd/snapshot"
+	"github.com/golang/glog"
 	"golang.org/x/net/context"
 
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
+// diskByUUIDDir is the directory of symlinks from filesystem uuids to devices.
+const diskByUUIDDir = "/dev/disk/by-uuid"
+
 // ImageFsInfo returns information of the filesystem that is used to store images.
-func (c *criContainerdService) ImageFsInfo(ctx context.Context, r *runtime.ImageFsInfoRequest) (*runtime.ImageFsInfoResponse, error) {
-	return nil, errors.New("not implemented")
+func (c *criContainerdService) ImageFsInfo(ctx context.Context, r *runtime.ImageFsInfoRequest) (retRes *runtime.ImageFsInfoResponse, retErr error) {
+	glog.V(4).Infof("ImageFsInfo")
+	defer func() {
+		if retErr == nil {
+			glog.V(4).Infof("ImageFsInfo returns filesystem info %+v", retRes.GetImageFilesystems())
+		}
+	}()
+
+	timestamp := time.Now().UnixNano()
+	usage := &runtime.FilesystemUsage{
+		Timestamp: timestamp,
+		StorageId: &runtime.StorageIdentifier{Uuid: c.getDeviceUUID(c.config.ImageFsPath)},
+	}
+	if c.snapshotterCaps.Usage {
+		size, inodes, err := c.getSnapshotsUsage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get snapshots usage: %v", err)
+		}
+		usage.UsedBytes = &runtime.UInt64Value{Value: size}
+		usage.InodesUsed = &runtime.UInt64Value{Value: inodes}
+	} else {
+		// The snapshotter doesn't report usage, fall back to the usage of the
+		// whole image filesystem.
+		used, _, err := c.os.FsUsage(c.config.ImageFsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get usage of image filesystem %q: %v", c.config.ImageFsPath, err)
+		}
+		usage.UsedBytes = &runtime.UInt64Value{Value: used}
+	}
+	return &runtime.ImageFsInfoResponse{ImageFilesystems: []*runtime.FilesystemUsage{usage}}, nil
+}
+
+// getSnapshotsUsage returns the total size and inodes of all committed
+// snapshots, which are the unpacked image layers. Active snapshots are the
+// writable layers of containers, and are reported in container stats.
+func (c *criContainerdService) getSnapshotsUsage(ctx context.Context) (uint64, uint64, error) {
+	var keys []string
+	if err := c.snapshotService.Walk(ctx, func(_ gocontext.Context, info snapshot.Info) error {
+		if info.Kind == snapshot.KindCommitted {
+			keys = append(keys, info.Name)
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, fmt.Errorf("failed to walk snapshots: %v", err)
+	}
+	var size, inodes uint64
+	for _, key := range keys {
+		usage, err := c.snapshotService.Usage(ctx, key)
+		if err != nil {
+			// The snapshot may be removed after walked.
+			glog.V(4).Infof("Failed to get usage of snapshot %q: %v", key, err)
+			continue
+		}
+		size += uint64(usage.Size)
+		inodes += uint64(usage.Inodes)
+	}
+	return size, inodes, nil
+}
+
+// getDeviceUUID returns the uuid of the filesystem the path is on, or empty
+// if it is unknown, e.g. the filesystem is not on a block device.
+func (c *criContainerdService) getDeviceUUID(path string) string {
+	info, err := c.os.Stat(path)
+	if err != nil {
+		glog.Warningf("Failed to stat %q: %v", path, err)
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	entries, err := c.os.ReadDir(diskByUUIDDir)
+	if err != nil {
+		glog.V(4).Infof("Failed to read %q: %v", diskByUUIDDir, err)
+		return ""
+	}
+	for _, entry := range entries {
+		// Stat follows the symlink to the device.
+		device, err := c.os.Stat(filepath.Join(diskByUUIDDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if deviceStat, ok := device.Sys().(*syscall.Stat_t); ok && deviceStat.Rdev == stat.Dev {
+			return entry.Name()
+		}
+	}
+	return ""
 }
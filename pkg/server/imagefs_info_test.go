@@ -0,0 +1,144 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+// usageSnapshotter is a fake snapshotter with snapshots and their usage.
+type usageSnapshotter struct {
+	snapshot.Snapshotter
+	infos  []snapshot.Info
+	usages map[string]snapshot.Usage
+}
+
+func (s *usageSnapshotter) Walk(ctx gocontext.Context, fn func(gocontext.Context, snapshot.Info) error) error {
+	for _, info := range s.infos {
+		if err := fn(ctx, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *usageSnapshotter) Usage(ctx gocontext.Context, key string) (snapshot.Usage, error) {
+	usage, ok := s.usages[key]
+	if !ok {
+		return snapshot.Usage{}, errdefs.ErrNotFound
+	}
+	return usage, nil
+}
+
+func TestImageFsInfo(t *testing.T) {
+	snapshotter := &usageSnapshotter{
+		infos: []snapshot.Info{
+			{Name: "layer-1", Kind: snapshot.KindCommitted},
+			{Name: "layer-2", Kind: snapshot.KindCommitted},
+			{Name: "layer-removed", Kind: snapshot.KindCommitted},
+			{Name: "container-rootfs", Kind: snapshot.KindActive},
+		},
+		usages: map[string]snapshot.Usage{
+			"layer-1":          {Size: 100, Inodes: 10},
+			"layer-2":          {Size: 200, Inodes: 20},
+			"container-rootfs": {Size: 1000, Inodes: 100},
+		},
+	}
+	for desc, test := range map[string]struct {
+		usageCap     bool
+		fsUsageErr   error
+		expectUsed   uint64
+		expectInodes *runtime.UInt64Value
+		expectErr    bool
+	}{
+		"usage of committed snapshots should be reported": {
+			usageCap:     true,
+			expectUsed:   300,
+			expectInodes: &runtime.UInt64Value{Value: 30},
+		},
+		"filesystem usage should be reported if snapshotter doesn't report usage": {
+			expectUsed: 5000,
+		},
+		"filesystem usage error should be returned": {
+			fsUsageErr: errors.New("statfs error"),
+			expectErr:  true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.ImageFsPath = "/var/lib/containerd"
+		c.snapshotService = snapshotter
+		c.snapshotterCaps.Usage = test.usageCap
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.FsUsageFn = func(path string) (uint64, uint64, error) {
+			assert.Equal(t, "/var/lib/containerd", path)
+			return 5000, 10000, test.fsUsageErr
+		}
+		fakeOS.StatFn = func(path string) (os.FileInfo, error) {
+			switch path {
+			case "/var/lib/containerd":
+				return fakeFileInfo{name: "containerd", sys: &syscall.Stat_t{Dev: 2049}}, nil
+			case "/dev/disk/by-uuid/uuid-1":
+				return fakeFileInfo{name: "sda1", sys: &syscall.Stat_t{Rdev: 2049}}, nil
+			case "/dev/disk/by-uuid/uuid-2":
+				return fakeFileInfo{name: "sda2", sys: &syscall.Stat_t{Rdev: 2050}}, nil
+			}
+			return nil, os.ErrNotExist
+		}
+		fakeOS.ReadDirFn = func(path string) ([]os.FileInfo, error) {
+			require.Equal(t, "/dev/disk/by-uuid", path)
+			return []os.FileInfo{fakeFileInfo{name: "uuid-2"}, fakeFileInfo{name: "uuid-1"}}, nil
+		}
+		resp, err := c.ImageFsInfo(context.Background(), &runtime.ImageFsInfoRequest{})
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Len(t, resp.GetImageFilesystems(), 1)
+		fs := resp.GetImageFilesystems()[0]
+		assert.Equal(t, "uuid-1", fs.GetStorageId().GetUuid())
+		assert.Equal(t, test.expectUsed, fs.GetUsedBytes().GetValue())
+		assert.Equal(t, test.expectInodes, fs.GetInodesUsed())
+		assert.NotZero(t, fs.GetTimestamp())
+	}
+}
+
+func TestGetDeviceUUIDUnknown(t *testing.T) {
+	c := newTestCRIContainerdService()
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.StatFn = func(path string) (os.FileInfo, error) {
+		return fakeFileInfo{name: "tmpfs", sys: &syscall.Stat_t{Dev: 42}}, nil
+	}
+	fakeOS.ReadDirFn = func(path string) ([]os.FileInfo, error) {
+		return nil, os.ErrNotExist
+	}
+	assert.Empty(t, c.getDeviceUUID("/var/lib/containerd"))
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// runIngestGC aborts stale content ingests at start and then every period.
+func (c *criContainerdService) runIngestGC(period, maxAge time.Duration) {
+	for {
+		if _, err := c.abortStaleIngests(context.Background(), maxAge); err != nil {
+			glog.Errorf("Failed to abort stale content ingests: %v", err)
+		}
+		time.Sleep(period)
+	}
+}
+
+// abortStaleIngests aborts content ingests which haven't been updated in the max
+// age, and returns the number of ingests aborted. They are left by pulls
+// interrupted by a daemon crash or restart, and hold partial blobs and the ingest
+// lock, which blocks pulling the content again. Ingests of ongoing pulls are
+// updated as contents are downloaded, and pulls making no progress are cancelled
+// by the image pull progress timeout.
+func (c *criContainerdService) abortStaleIngests(ctx context.Context, maxAge time.Duration) (int, error) {
+	statuses, err := c.contentStoreService.ListStatuses(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list content ingests: %v", err)
+	}
+	aborted := 0
+	now := time.Now()
+	for _, status := range statuses {
+		if now.Sub(status.UpdatedAt) < maxAge {
+			continue
+		}
+		if err := c.contentStoreService.Abort(ctx, status.Ref); err != nil {
+			glog.Errorf("Failed to abort stale content ingest %q: %v", status.Ref, err)
+			continue
+		}
+		glog.Infof("Aborted stale content ingest %q with %d/%d bytes, last updated at %v",
+			status.Ref, status.Offset, status.Total, status.UpdatedAt)
+		c.metrics.abortedIngests.Inc()
+		aborted++
+	}
+	return aborted, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// fakeIngestStore is a content store with in-memory ingests.
+type fakeIngestStore struct {
+	content.Store
+	ingests  map[string]content.Status
+	abortErr map[string]error
+}
+
+func (f *fakeIngestStore) ListStatuses(_ gocontext.Context, _ ...string) ([]content.Status, error) {
+	var statuses []content.Status
+	for _, s := range f.ingests {
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+func (f *fakeIngestStore) Abort(_ gocontext.Context, ref string) error {
+	if err := f.abortErr[ref]; err != nil {
+		return err
+	}
+	delete(f.ingests, ref)
+	return nil
+}
+
+func TestAbortStaleIngests(t *testing.T) {
+	now := time.Now()
+	store := &fakeIngestStore{
+		ingests: map[string]content.Status{
+			"active": {Ref: "active", UpdatedAt: now.Add(-time.Minute)},
+			"stale":  {Ref: "stale", UpdatedAt: now.Add(-2 * time.Hour)},
+			"locked": {Ref: "locked", UpdatedAt: now.Add(-2 * time.Hour)},
+		},
+		abortErr: map[string]error{"locked": errors.New("abort failed")},
+	}
+	c := newTestCRIContainerdService()
+	c.contentStoreService = store
+
+	aborted, err := c.abortStaleIngests(context.Background(), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, aborted)
+	assert.Contains(t, store.ingests, "active", "active ingest should be kept")
+	assert.NotContains(t, store.ingests, "stale", "stale ingest should be aborted")
+	assert.Contains(t, store.ingests, "locked", "ingest failed to abort should be kept for the next run")
+	assert.Equal(t, float64(1), c.metrics.abortedIngests.Value())
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// readOnlyMethods are the cri methods which don't change the node. Only they are
+// served in read-only mode.
+var readOnlyMethods = map[string]bool{
+	"/runtime.RuntimeService/Version":            true,
+	"/runtime.RuntimeService/PodSandboxStatus":   true,
+	"/runtime.RuntimeService/ListPodSandbox":     true,
+	"/runtime.RuntimeService/ListContainers":     true,
+	"/runtime.RuntimeService/ContainerStatus":    true,
+	"/runtime.RuntimeService/ContainerStats":     true,
+	"/runtime.RuntimeService/ListContainerStats": true,
+	"/runtime.RuntimeService/Status":             true,
+	"/runtime.ImageService/ListImages":           true,
+	"/runtime.ImageService/ImageStatus":          true,
+	"/runtime.ImageService/ImageFsInfo":          true,
+}
+
+// UnaryInterceptor intercepts all cri grpc requests served by cri-containerd.
+func (c *criContainerdService) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	var resp interface{}
+	var err error
+	if c.config.ReadOnly && !readOnlyMethods[info.FullMethod] {
+		err = newCRIError(codes.PermissionDenied, ReasonReadOnly, "%s is not allowed in read-only mode", info.FullMethod)
+	} else {
+		resp, err = c.rpcLogger.intercept(ctx, req, info, handler)
+	}
+	err = toGRPCError(err)
+	if recordErr := c.rpcRecorder.Record(start, info.FullMethod, req, resp, err); recordErr != nil {
+		glog.Errorf("Failed to record rpc %s: %v", info.FullMethod, recordErr)
+	}
+	return resp, err
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestUnaryInterceptorReadOnly(t *testing.T) {
+	for desc, test := range map[string]struct {
+		readOnly     bool
+		method       string
+		expectCalled bool
+	}{
+		"mutation should be served when not read-only": {
+			method:       "/runtime.RuntimeService/RunPodSandbox",
+			expectCalled: true,
+		},
+		"read should be served in read-only mode": {
+			readOnly:     true,
+			method:       "/runtime.RuntimeService/ListContainers",
+			expectCalled: true,
+		},
+		"mutation should be rejected in read-only mode": {
+			readOnly: true,
+			method:   "/runtime.RuntimeService/RunPodSandbox",
+		},
+		"exec should be rejected in read-only mode": {
+			readOnly: true,
+			method:   "/runtime.RuntimeService/ExecSync",
+		},
+		"image pull should be rejected in read-only mode": {
+			readOnly: true,
+			method:   "/runtime.ImageService/PullImage",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.ReadOnly = test.readOnly
+		called := false
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			called = true
+			return "resp", nil
+		}
+		resp, err := c.UnaryInterceptor(context.Background(), "req",
+			&grpc.UnaryServerInfo{FullMethod: test.method}, handler)
+		assert.Equal(t, test.expectCalled, called)
+		if test.expectCalled {
+			assert.NoError(t, err)
+			assert.Equal(t, "resp", resp)
+			continue
+		}
+		assert.Equal(t, codes.PermissionDenied, grpc.Code(err))
+		assert.Equal(t, ReasonReadOnly, ErrorReason(err))
+		assert.Nil(t, resp)
+	}
+}
+
+func TestReadOnlyDebugHandler(t *testing.T) {
+	for desc, test := range map[string]struct {
+		method       string
+		path         string
+		expectCalled bool
+	}{
+		"get should be served": {
+			method:       http.MethodGet,
+			path:         "/state",
+			expectCalled: true,
+		},
+		"post should be rejected": {
+			method: http.MethodPost,
+			path:   "/state",
+		},
+		"shutdown pods should be rejected": {
+			method: http.MethodPost,
+			path:   "/shutdown-pods",
+		},
+		"container export should be rejected": {
+			method: http.MethodGet,
+			path:   "/container-export",
+		},
+		"log level change should be served": {
+			method:       http.MethodPost,
+			path:         "/log-level",
+			expectCalled: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		called := false
+		h := readOnlyDebugHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		assert.Equal(t, test.expectCalled, called)
+		if !test.expectCalled {
+			assert.Equal(t, http.StatusForbidden, w.Code)
+		}
+	}
+}
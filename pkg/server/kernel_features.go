@@ -0,0 +1,220 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	osinterface "github.com/kubernetes-incubator/cri-containerd/pkg/os"
+)
+
+// Kernel features probed at startup.
+const (
+	kernelFeatureOverlay        = "overlayfs"
+	kernelFeatureSeccomp        = "seccomp"
+	kernelFeatureApparmor       = "apparmor"
+	kernelFeatureSwapAccounting = "memory-swap-accounting"
+	kernelFeatureUserNamespaces = "user-namespaces"
+)
+
+const (
+	// kernelFeaturesReady is the condition type of kernel features. Kubelet
+	// ignores it, it is for operators to notice missing kernel features.
+	kernelFeaturesReady = "KernelFeaturesReady"
+	// kernelFeatureMissingReason is the reason reported when a kernel feature
+	// in use is missing.
+	kernelFeatureMissingReason = "KernelFeatureMissing"
+	// overlaySnapshotter is the name of the overlayfs snapshotter.
+	overlaySnapshotter = "overlayfs"
+)
+
+const (
+	// procFilesystemsFile lists filesystems supported by the kernel.
+	procFilesystemsFile = "/proc/filesystems"
+	// procSelfStatusFile has the seccomp mode of the process if the kernel
+	// supports seccomp.
+	procSelfStatusFile = "/proc/self/status"
+	// memorySwapLimitFile only exists if memory swap accounting is enabled.
+	memorySwapLimitFile = "/sys/fs/cgroup/memory/memory.memsw.limit_in_bytes"
+	// maxUserNamespacesFile is the maximum number of user namespaces, 0 means
+	// user namespaces are disabled.
+	maxUserNamespacesFile = "/proc/sys/user/max_user_namespaces"
+)
+
+// kernelFeatureImpacts are what is disabled when the kernel feature is missing.
+// Swap accounting is only informational, cri-containerd doesn't rely on it.
+var kernelFeatureImpacts = map[string]string{
+	kernelFeatureOverlay:        "the overlayfs snapshotter can't be used",
+	kernelFeatureSeccomp:        "the runtime/default seccomp profile is not applied",
+	kernelFeatureApparmor:       "the runtime/default apparmor profile is not applied",
+	kernelFeatureSwapAccounting: "swap used by containers is not accounted in their memory cgroups",
+	kernelFeatureUserNamespaces: "processes in containers can't create user namespaces, which runtime handler capabilities report",
+}
+
+// kernelFeatures are kernel features probed at startup, mapped to whether they
+// are available.
+type kernelFeatures map[string]bool
+
+// has returns whether the kernel feature is available. Features not probed are
+// assumed available.
+func (k kernelFeatures) has(feature string) bool {
+	available, probed := k[feature]
+	return !probed || available
+}
+
+// missing returns the sorted missing kernel features.
+func (k kernelFeatures) missing() []string {
+	var missing []string
+	for feature, available := range k {
+		if !available {
+			missing = append(missing, feature)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// probeKernelFeatures probes kernel features on the node. A feature is only
+// reported missing if the probe tells it definitely is. A feature which can't
+// be probed is left out, so that it is assumed available, and nothing is
+// disabled because of a probe failure.
+func probeKernelFeatures(o osinterface.OS) kernelFeatures {
+	features := kernelFeatures{}
+	for feature, probe := range map[string]func(osinterface.OS) (bool, error){
+		kernelFeatureOverlay:        probeOverlay,
+		kernelFeatureSeccomp:        probeSeccomp,
+		kernelFeatureApparmor:       probeApparmor,
+		kernelFeatureSwapAccounting: probeSwapAccounting,
+		kernelFeatureUserNamespaces: probeUserNamespaces,
+	} {
+		available, err := probe(o)
+		if err != nil {
+			glog.Warningf("Failed to probe kernel feature %q, assume it is available: %v", feature, err)
+			continue
+		}
+		features[feature] = available
+	}
+	return features
+}
+
+// probeOverlay checks whether overlay is listed in supported filesystems.
+func probeOverlay(o osinterface.OS) (bool, error) {
+	data, err := o.ReadFile(procFilesystemsFile)
+	if err != nil {
+		return false, err
+	}
+	// Each line is in the format of "[nodev]\t<filesystem>".
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == "overlay" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// probeSeccomp checks whether the process status has the seccomp mode.
+func probeSeccomp(o osinterface.OS) (bool, error) {
+	data, err := o.ReadFile(procSelfStatusFile)
+	if err != nil {
+		return false, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "Seccomp:") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// probeApparmor checks whether apparmor is enabled.
+func probeApparmor(o osinterface.OS) (bool, error) {
+	data, err := o.ReadFile(apparmorEnabledFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.HasPrefix(data, []byte("Y")), nil
+}
+
+// probeSwapAccounting checks whether the memory cgroup accounts swap.
+func probeSwapAccounting(o osinterface.OS) (bool, error) {
+	if _, err := o.Stat(memorySwapLimitFile); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// probeUserNamespaces checks whether user namespaces are enabled.
+func probeUserNamespaces(o osinterface.OS) (bool, error) {
+	data, err := o.ReadFile(maxUserNamespacesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) != "0", nil
+}
+
+// logMissingKernelFeatures logs missing kernel features and what is disabled
+// because of them.
+func (c *criContainerdService) logMissingKernelFeatures() {
+	for _, feature := range c.kernelFeatures.missing() {
+		glog.Warningf("Kernel feature %q is not available, %s", feature, kernelFeatureImpacts[feature])
+	}
+}
+
+// getKernelFeaturesCondition returns the condition of kernel features, which
+// is not ready if a missing kernel feature is in use.
+func (c *criContainerdService) getKernelFeaturesCondition() *runtime.RuntimeCondition {
+	condition := &runtime.RuntimeCondition{
+		Type:   kernelFeaturesReady,
+		Status: true,
+	}
+	missing := c.kernelFeatures.missing()
+	if len(missing) == 0 {
+		return condition
+	}
+	var impacts []string
+	for _, feature := range missing {
+		impacts = append(impacts, fmt.Sprintf("%s (%s)", feature, kernelFeatureImpacts[feature]))
+	}
+	condition.Message = fmt.Sprintf("Missing kernel features: %s", strings.Join(impacts, ", "))
+	// Empty snapshotter name means the containerd default, which is overlayfs.
+	snapshotter := c.snapshotterCaps.Name
+	if (snapshotter == "" || snapshotter == overlaySnapshotter) && !c.kernelFeatures.has(kernelFeatureOverlay) {
+		condition.Status = false
+		condition.Reason = kernelFeatureMissingReason
+	}
+	return condition
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+func TestProbeKernelFeatures(t *testing.T) {
+	for desc, test := range map[string]struct {
+		files    map[string]string
+		err      error
+		expected kernelFeatures
+	}{
+		"all features available": {
+			files: map[string]string{
+				procFilesystemsFile:   "nodev\tsysfs\n\text4\nnodev\toverlay\n",
+				procSelfStatusFile:    "Name:\tcri-containerd\nSeccomp:\t0\n",
+				apparmorEnabledFile:   "Y\n",
+				memorySwapLimitFile:   "9223372036854771712\n",
+				maxUserNamespacesFile: "63413\n",
+			},
+			expected: kernelFeatures{
+				kernelFeatureOverlay:        true,
+				kernelFeatureSeccomp:        true,
+				kernelFeatureApparmor:       true,
+				kernelFeatureSwapAccounting: true,
+				kernelFeatureUserNamespaces: true,
+			},
+		},
+		"all features missing": {
+			files: map[string]string{
+				procFilesystemsFile:   "nodev\tsysfs\n\text4\n",
+				procSelfStatusFile:    "Name:\tcri-containerd\n",
+				apparmorEnabledFile:   "N\n",
+				maxUserNamespacesFile: "0\n",
+			},
+			expected: kernelFeatures{
+				kernelFeatureOverlay:        false,
+				kernelFeatureSeccomp:        false,
+				kernelFeatureApparmor:       false,
+				kernelFeatureSwapAccounting: false,
+				kernelFeatureUserNamespaces: false,
+			},
+		},
+		"features which can't be probed should not be missing": {
+			err:      os.ErrPermission,
+			expected: kernelFeatures{},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		fakeOS := ostesting.NewFakeOS()
+		fakeOS.ReadFileFn = func(filename string) ([]byte, error) {
+			if data, ok := test.files[filename]; ok {
+				return []byte(data), nil
+			}
+			if test.err != nil {
+				return nil, test.err
+			}
+			return nil, os.ErrNotExist
+		}
+		fakeOS.StatFn = func(name string) (os.FileInfo, error) {
+			if _, ok := test.files[name]; ok {
+				return nil, nil
+			}
+			if test.err != nil {
+				return nil, test.err
+			}
+			return nil, os.ErrNotExist
+		}
+		assert.Equal(t, test.expected, probeKernelFeatures(fakeOS))
+	}
+}
+
+func TestGetKernelFeaturesCondition(t *testing.T) {
+	for desc, test := range map[string]struct {
+		features      kernelFeatures
+		snapshotter   string
+		expectStatus  bool
+		expectMessage string
+	}{
+		"should be ready if all features are available": {
+			features:     kernelFeatures{kernelFeatureOverlay: true, kernelFeatureApparmor: true},
+			expectStatus: true,
+		},
+		"should be ready if missing features are not in use": {
+			features:      kernelFeatures{kernelFeatureOverlay: false, kernelFeatureApparmor: false},
+			snapshotter:   "btrfs",
+			expectStatus:  true,
+			expectMessage: "Missing kernel features: apparmor (the runtime/default apparmor profile is not applied), overlayfs (the overlayfs snapshotter can't be used)",
+		},
+		"should not be ready if overlayfs snapshotter is used without overlay": {
+			features:      kernelFeatures{kernelFeatureOverlay: false},
+			snapshotter:   overlaySnapshotter,
+			expectMessage: "Missing kernel features: overlayfs (the overlayfs snapshotter can't be used)",
+		},
+		"should not be ready if default snapshotter is used without overlay": {
+			features:      kernelFeatures{kernelFeatureOverlay: false},
+			expectMessage: "Missing kernel features: overlayfs (the overlayfs snapshotter can't be used)",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.kernelFeatures = test.features
+		c.snapshotterCaps.Name = test.snapshotter
+		condition := c.getKernelFeaturesCondition()
+		assert.Equal(t, kernelFeaturesReady, condition.Type)
+		assert.Equal(t, test.expectStatus, condition.Status)
+		assert.Equal(t, test.expectMessage, condition.Message)
+		if !test.expectStatus {
+			assert.Equal(t, kernelFeatureMissingReason, condition.Reason)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// listCache caches the unfiltered result of a List RPC until the generation
+// of the underlying state changes. Filters are applied on the cached result
+// per request, so all requests share the same cache entry.
+type listCache struct {
+	sync.Mutex
+	valid      bool
+	generation uint64
+	items      interface{}
+}
+
+// get returns the cached items if they were cached at the passed in generation.
+func (l *listCache) get(generation uint64) (interface{}, bool) {
+	l.Lock()
+	defer l.Unlock()
+	if !l.valid || l.generation != generation {
+		return nil, false
+	}
+	return l.items, true
+}
+
+// set caches items listed at the passed in generation. The generation must
+// be read before listing, so that a change happening during the listing
+// invalidates the cache entry.
+func (l *listCache) set(generation uint64, items interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.valid = true
+	l.generation = generation
+	l.items = items
+}
+
+// containerListGeneration returns the generation of the state ListContainers
+// depends on.
+func (c *criContainerdService) containerListGeneration() uint64 {
+	return c.containerStore.Generation()
+}
+
+// sandboxListGeneration returns the generation of the state ListPodSandbox
+// depends on. Sandbox state comes from containerd, so besides the sandbox
+// store, it also depends on sandbox container exits observed by the service.
+func (c *criContainerdService) sandboxListGeneration() uint64 {
+	return c.sandboxStore.Generation() + atomic.LoadUint64(&c.sandboxStateGeneration)
+}
+
+// bumpSandboxStateGeneration invalidates cached sandbox list because the
+// state of a sandbox container may have changed.
+func (c *criContainerdService) bumpSandboxStateGeneration() {
+	atomic.AddUint64(&c.sandboxStateGeneration, 1)
+}
+
+// copyCRIContainers returns a new slice of the cached containers, so that
+// callers could not modify the cache.
+func copyCRIContainers(containers []*runtime.Container) []*runtime.Container {
+	if containers == nil {
+		return nil
+	}
+	return append([]*runtime.Container{}, containers...)
+}
+
+// copyCRISandboxes returns a new slice of the cached sandboxes, so that
+// callers could not modify the cache.
+func copyCRISandboxes(sandboxes []*runtime.PodSandbox) []*runtime.PodSandbox {
+	if sandboxes == nil {
+		return nil
+	}
+	return append([]*runtime.PodSandbox{}, sandboxes...)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestListContainersCache(t *testing.T) {
+	c := newTestCRIContainerdService()
+	list := func() []*runtime.Container {
+		resp, err := c.ListContainers(context.Background(), &runtime.ListContainersRequest{})
+		require.NoError(t, err)
+		return resp.GetContainers()
+	}
+	cntr, err := containerstore.NewContainer(
+		containerstore.Metadata{
+			ID:        "1",
+			SandboxID: "s-1",
+			Config:    &runtime.ContainerConfig{},
+		},
+		containerstore.Status{CreatedAt: time.Now().UnixNano()},
+	)
+	require.NoError(t, err)
+	assert.Empty(t, list())
+
+	t.Logf("should invalidate cache when container is added")
+	require.NoError(t, c.containerStore.Add(cntr))
+	containers := list()
+	require.Len(t, containers, 1)
+	assert.Equal(t, runtime.ContainerState_CONTAINER_CREATED, containers[0].State)
+
+	t.Logf("should return cached containers when nothing changes")
+	cached := list()
+	require.Len(t, cached, 1)
+	assert.True(t, containers[0] == cached[0], "cached container should be returned")
+
+	t.Logf("should not be able to modify cache through returned slice")
+	cached[0] = nil
+	assert.NotNil(t, list()[0])
+
+	t.Logf("should invalidate cache when container status is updated")
+	require.NoError(t, cntr.Status.Update(func(status containerstore.Status) (containerstore.Status, error) {
+		status.StartedAt = time.Now().UnixNano()
+		return status, nil
+	}))
+	containers = list()
+	require.Len(t, containers, 1)
+	assert.Equal(t, runtime.ContainerState_CONTAINER_RUNNING, containers[0].State)
+
+	t.Logf("should invalidate cache when container is deleted")
+	c.containerStore.Delete("1")
+	assert.Empty(t, list())
+}
+
+func TestSandboxListGeneration(t *testing.T) {
+	c := newTestCRIContainerdService()
+	sandboxes := []*runtime.PodSandbox{{Id: "1"}}
+
+	generation := c.sandboxListGeneration()
+	c.sandboxListCache.set(generation, sandboxes)
+	items, ok := c.sandboxListCache.get(c.sandboxListGeneration())
+	assert.True(t, ok)
+	assert.Equal(t, sandboxes, items)
+
+	t.Logf("should invalidate cache when sandbox is added")
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{ID: "1"}}))
+	_, ok = c.sandboxListCache.get(c.sandboxListGeneration())
+	assert.False(t, ok)
+
+	t.Logf("should invalidate cache when sandbox state may change")
+	generation = c.sandboxListGeneration()
+	c.sandboxListCache.set(generation, sandboxes)
+	c.bumpSandboxStateGeneration()
+	_, ok = c.sandboxListCache.get(c.sandboxListGeneration())
+	assert.False(t, ok)
+
+	t.Logf("should invalidate cache when sandbox is deleted")
+	generation = c.sandboxListGeneration()
+	c.sandboxListCache.set(generation, sandboxes)
+	c.sandboxStore.Delete("1")
+	_, ok = c.sandboxListCache.get(c.sandboxListGeneration())
+	assert.False(t, ok)
+}
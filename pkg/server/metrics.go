@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io"
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+// serviceMetrics contains metrics of the cri-containerd service.
+type serviceMetrics struct {
+	// registry contains all registered metrics.
+	registry *metrics.Registry
+	// execSessions is the number of active exec sessions.
+	execSessions *metrics.Gauge
+	// openFifos is the number of fifos opened by cri-containerd.
+	openFifos *metrics.Gauge
+	// eventBacklog is the number of containerd events received but not handled yet.
+	eventBacklog *metrics.Gauge
+	// leakedResources is the number of resources not released after their sandbox
+	// or container is removed.
+	leakedResources *metrics.Counter
+	// removalRetries is the number of resources found after their sandbox or
+	// container is removed, which are cleaned up again.
+	removalRetries *metrics.Counter
+	// droppedEvents is the number of cri events not published into the
+	// containerd event service.
+	droppedEvents *metrics.Counter
+	// stalledPulls is the number of image pulls cancelled because they made no
+	// progress.
+	stalledPulls *metrics.Counter
+	// abortedIngests is the number of stale content ingests aborted.
+	abortedIngests *metrics.Counter
+	// corruptImages is the number of images found with missing or corrupt
+	// content by ImageStatus.
+	corruptImages *metrics.Counter
+}
+
+// newServiceMetrics creates service metrics, metrics which need the service state
+// are registered with registerStateMetrics.
+func newServiceMetrics() *serviceMetrics {
+	m := &serviceMetrics{
+		registry:     metrics.NewRegistry(),
+		execSessions: metrics.NewGauge("cri_containerd_exec_sessions", "Number of active exec sessions."),
+		openFifos:    metrics.NewGauge("cri_containerd_open_fifos", "Number of fifos opened by cri-containerd."),
+		eventBacklog: metrics.NewGauge("cri_containerd_event_backlog", "Number of containerd events received but not handled yet."),
+		leakedResources: metrics.NewCounter("cri_containerd_leaked_resources_total",
+			"Number of resources not released after their sandbox or container is removed."),
+		removalRetries: metrics.NewCounter("cri_containerd_removal_cleanup_retries_total",
+			"Number of resources found after their sandbox or container is removed, which are cleaned up again."),
+		droppedEvents: metrics.NewCounter("cri_containerd_dropped_published_events_total",
+			"Number of cri events not published into the containerd event service."),
+		stalledPulls: metrics.NewCounter("cri_containerd_image_pulls_stalled_total",
+			"Number of image pulls cancelled because they made no progress in the image pull progress timeout."),
+		abortedIngests: metrics.NewCounter("cri_containerd_aborted_content_ingests_total",
+			"Number of stale content ingests left by interrupted image pulls, which are aborted."),
+		corruptImages: metrics.NewCounter("cri_containerd_corrupt_images_total",
+			"Number of images found with missing or corrupt content by ImageStatus, which are reported as absent."),
+	}
+	m.registry.MustRegister(m.execSessions, m.openFifos, m.eventBacklog, m.leakedResources, m.removalRetries,
+		m.droppedEvents, m.stalledPulls, m.abortedIngests, m.corruptImages)
+	return m
+}
+
+// registerStateMetrics registers gauges of internal store and registrar sizes, so that
+// leaks, e.g. names never released, could be detected.
+func (c *criContainerdService) registerStateMetrics() {
+	c.metrics.registry.MustRegister(
+		metrics.NewGaugeFunc("cri_containerd_sandboxes", "Number of sandboxes in the sandbox store.",
+			func() float64 { return float64(len(c.sandboxStore.List())) }),
+		metrics.NewGaugeFunc("cri_containerd_containers", "Number of containers in the container store.",
+			func() float64 { return float64(len(c.containerStore.List())) }),
+		metrics.NewGaugeFunc("cri_containerd_images", "Number of images in the image store.",
+			func() float64 { return float64(len(c.imageStore.List())) }),
+		metrics.NewGaugeFunc("cri_containerd_reserved_sandbox_names", "Number of reserved sandbox names.",
+			func() float64 { return float64(c.sandboxNameIndex.Len()) }),
+		metrics.NewGaugeFunc("cri_containerd_reserved_container_names", "Number of reserved container names.",
+			func() float64 { return float64(c.containerNameIndex.Len()) }),
+	)
+}
+
+// checkLeaks checks whether resources owned by agents of a removed sandbox or container
+// are all released, and logs and exports leaked resources.
+func (c *criContainerdService) checkLeaks(id string) {
+	leaks := c.agentFactory.CheckLeaks(id)
+	if len(leaks) == 0 {
+		return
+	}
+	glog.Warningf("Detected leaked resources of removed sandbox or container %q: %v", id, leaks)
+	for _, n := range leaks {
+		if n > 0 {
+			c.metrics.leakedResources.Add(uint64(n))
+		}
+	}
+}
+
+// trackedFifo is a fifo tracked by the open fifo gauge.
+type trackedFifo struct {
+	io.ReadWriteCloser
+	once  sync.Once
+	gauge *metrics.Gauge
+}
+
+// newTrackedFifo increments the gauge, which is decremented when the fifo is closed.
+func newTrackedFifo(f io.ReadWriteCloser, gauge *metrics.Gauge) *trackedFifo {
+	gauge.Inc()
+	return &trackedFifo{ReadWriteCloser: f, gauge: gauge}
+}
+
+// Close closes the fifo and decrements the gauge only once.
+func (f *trackedFifo) Close() error {
+	f.once.Do(f.gauge.Dec)
+	return f.ReadWriteCloser.Close()
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestStateMetrics(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.registerStateMetrics()
+	assert.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{ID: "test-id"}}))
+	assert.NoError(t, c.sandboxNameIndex.Reserve("test-name", "test-id"))
+	assert.NoError(t, c.containerNameIndex.Reserve("test-name", "test-id"))
+	c.containerNameIndex.ReleaseByName("test-name")
+
+	for name, expect := range map[string]float64{
+		"cri_containerd_sandboxes":                1,
+		"cri_containerd_containers":               0,
+		"cri_containerd_images":                   0,
+		"cri_containerd_reserved_sandbox_names":   1,
+		"cri_containerd_reserved_container_names": 0,
+	} {
+		assert.Equal(t, expect, c.metrics.registry.Get(name).Value(), name)
+	}
+
+	w := httptest.NewRecorder()
+	c.DebugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "cri_containerd_sandboxes 1\n")
+}
+
+func TestTrackedFifo(t *testing.T) {
+	m := newServiceMetrics()
+	f := newTrackedFifo(nopReadWriteCloser{}, m.openFifos)
+	assert.Equal(t, float64(1), m.openFifos.Value())
+	assert.NoError(t, f.Close())
+	assert.Equal(t, float64(0), m.openFifos.Value())
+	t.Logf("should only decrement the gauge once")
+	assert.NoError(t, f.Close())
+	assert.Equal(t, float64(0), m.openFifos.Value())
+}
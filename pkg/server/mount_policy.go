@@ -0,0 +1,199 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// Actions of mount policy rules.
+const (
+	// mountActionDeny rejects the container.
+	mountActionDeny = "deny"
+	// mountActionReadonly mounts the host path read only.
+	mountActionReadonly = "readonly"
+	// mountActionRewrite mounts another host path instead.
+	mountActionRewrite = "rewrite"
+)
+
+// mountPolicyRule blocks or rewrites mounts of a dangerous host path.
+type mountPolicyRule struct {
+	// HostPath is the host path the rule protects. A mount matches if its host
+	// path is the path, a parent of the path, or a path under it. Kubelet pod
+	// volumes under the path, i.e. `pods/<uid>/volumes/...` and
+	// `pods/<uid>/volume-subpaths/...`, don't match, so that a rule for the
+	// kubelet root directory doesn't deny every pod volume.
+	HostPath string `json:"hostPath"`
+	// Exact only matches mounts of the host path itself, e.g. for `/`.
+	Exact bool `json:"exact,omitempty"`
+	// Action is one of deny, readonly and rewrite.
+	Action string `json:"action"`
+	// RewriteTo is the host path mounted instead with the rewrite action.
+	RewriteTo string `json:"rewriteTo,omitempty"`
+	// Privileged only applies the rule to privileged containers.
+	Privileged bool `json:"privileged,omitempty"`
+	// HostNetwork only applies the rule to pods using the host network.
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+}
+
+// mountPolicy is a deny-list of host path mounts evaluated at CreateContainer.
+type mountPolicy struct {
+	// Rules are evaluated in order, and the first matching rule applies.
+	Rules []mountPolicyRule `json:"rules"`
+	// AllowAnnotation is the pod annotation, in the form of `key` or
+	// `key=value`, exempting the pod from the policy. Empty means no pod is
+	// exempt.
+	AllowAnnotation string `json:"allowAnnotation,omitempty"`
+}
+
+// loadMountPolicy loads the mount policy from the json file. It returns nil if
+// the file is not specified.
+func loadMountPolicy(path string) (*mountPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var policy mountPolicy
+	if err := readJSONFile(path, &policy); err != nil {
+		return nil, fmt.Errorf("failed to load mount policy %q: %v", path, err)
+	}
+	for i, rule := range policy.Rules {
+		if !filepath.IsAbs(rule.HostPath) {
+			return nil, fmt.Errorf("invalid mount policy %q: host path %q of rule %d is not absolute", path, rule.HostPath, i)
+		}
+		switch rule.Action {
+		case mountActionDeny, mountActionReadonly:
+		case mountActionRewrite:
+			if !filepath.IsAbs(rule.RewriteTo) {
+				return nil, fmt.Errorf("invalid mount policy %q: rewrite path %q of rule %d is not absolute", path, rule.RewriteTo, i)
+			}
+		default:
+			return nil, fmt.Errorf("invalid mount policy %q: unknown action %q of rule %d", path, rule.Action, i)
+		}
+		policy.Rules[i].HostPath = filepath.Clean(rule.HostPath)
+	}
+	return &policy, nil
+}
+
+// isPathUnder returns whether the path is the parent path or under it. Both
+// paths should be clean.
+func isPathUnder(path, parent string) bool {
+	if path == parent || parent == "/" {
+		return true
+	}
+	return strings.HasPrefix(path, parent+"/")
+}
+
+// matches returns whether the rule applies to a mount of the clean host path.
+func (r *mountPolicyRule) matches(hostPath string, privileged, hostNetwork bool) bool {
+	if (r.Privileged && !privileged) || (r.HostNetwork && !hostNetwork) {
+		return false
+	}
+	if r.Exact {
+		return hostPath == r.HostPath
+	}
+	if isPathUnder(r.HostPath, hostPath) {
+		return true
+	}
+	if !isPathUnder(hostPath, r.HostPath) {
+		return false
+	}
+	return !isKubeletPodVolume(hostPath, r.HostPath)
+}
+
+// isKubeletPodVolume returns whether the clean path is in a volume of a kubelet
+// pod directory, e.g. `/var/lib/kubelet/pods/<uid>/volumes/<plugin>/<volume>`,
+// and the volume is under the parent path. The volumes directory itself is not
+// a pod volume.
+func isKubeletPodVolume(path, parent string) bool {
+	parts := splitPath(path)
+	parentLen := len(splitPath(parent))
+	for i := 0; i+3 < len(parts); i++ {
+		if parts[i] == "pods" && (parts[i+2] == "volumes" || parts[i+2] == "volume-subpaths") && i+3 >= parentLen {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath splits the clean absolute path into its elements.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// apply evaluates the policy against mounts of the container. It returns the
+// mounts with rewritten ones replaced, or a cri error with PermissionDenied code
+// if any mount is denied. The mounts passed in are not changed.
+func (p *mountPolicy) apply(config *runtime.ContainerConfig, sandboxConfig *runtime.PodSandboxConfig) ([]*runtime.Mount, error) {
+	mounts := config.GetMounts()
+	if p == nil || len(mounts) == 0 {
+		return mounts, nil
+	}
+	if p.AllowAnnotation != "" && hasAnnotation(sandboxConfig.GetAnnotations(), p.AllowAnnotation) {
+		return mounts, nil
+	}
+	privileged := config.GetLinux().GetSecurityContext().GetPrivileged()
+	hostNetwork := sandboxConfig.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork()
+	result := make([]*runtime.Mount, 0, len(mounts))
+	for _, mount := range mounts {
+		hostPath := resolveMountHostPath(mount.GetHostPath())
+		var rule *mountPolicyRule
+		for i := range p.Rules {
+			if p.Rules[i].matches(hostPath, privileged, hostNetwork) {
+				rule = &p.Rules[i]
+				break
+			}
+		}
+		if rule == nil {
+			result = append(result, mount)
+			continue
+		}
+		m := *mount
+		switch rule.Action {
+		case mountActionDeny:
+			return nil, newCRIError(codes.PermissionDenied, ReasonMountDenied,
+				"mount of host path %q is denied by mount policy rule for %q", mount.GetHostPath(), rule.HostPath)
+		case mountActionReadonly:
+			m.Readonly = true
+		case mountActionRewrite:
+			m.HostPath = rule.RewriteTo
+		}
+		glog.V(2).Infof("Mount of host path %q to %q is changed to %+v by mount policy rule for %q",
+			mount.GetHostPath(), mount.GetContainerPath(), m, rule.HostPath)
+		result = append(result, &m)
+	}
+	return result, nil
+}
+
+// resolveMountHostPath returns the clean host path with symlinks evaluated, so
+// that symlinks can't be used to bypass the mount policy. The clean path is
+// returned if the path can't be evaluated, e.g. it doesn't exist.
+func resolveMountHostPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func TestLoadMountPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mount-policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for desc, test := range map[string]struct {
+		content   string
+		expectErr bool
+	}{
+		"valid policy": {
+			content: `{"allowAnnotation": "mount-policy/exempt", "rules": [
+				{"hostPath": "/", "exact": true, "action": "deny"},
+				{"hostPath": "/var/lib/kubelet/", "action": "readonly"},
+				{"hostPath": "/etc", "action": "rewrite", "rewriteTo": "/var/empty"}]}`,
+		},
+		"relative host path": {
+			content:   `{"rules": [{"hostPath": "var/lib/kubelet", "action": "deny"}]}`,
+			expectErr: true,
+		},
+		"unknown action": {
+			content:   `{"rules": [{"hostPath": "/var/lib/kubelet", "action": "mask"}]}`,
+			expectErr: true,
+		},
+		"rewrite without path": {
+			content:   `{"rules": [{"hostPath": "/etc", "action": "rewrite"}]}`,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		path := filepath.Join(dir, "policy.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(test.content), 0644))
+		policy, err := loadMountPolicy(path)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Len(t, policy.Rules, 3)
+		assert.Equal(t, "/var/lib/kubelet", policy.Rules[1].HostPath, "host path should be clean")
+	}
+	policy, err := loadMountPolicy("")
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+}
+
+func TestMountPolicyApply(t *testing.T) {
+	policy := &mountPolicy{
+		AllowAnnotation: "mount-policy/exempt=true",
+		Rules: []mountPolicyRule{
+			{HostPath: "/", Exact: true, Action: mountActionDeny},
+			{HostPath: "/var/lib/kubelet/pods", Action: mountActionReadonly, Privileged: true, HostNetwork: true},
+			{HostPath: "/var/lib/kubelet", Action: mountActionDeny},
+			{HostPath: "/etc/kubernetes", Action: mountActionRewrite, RewriteTo: "/var/empty"},
+		},
+	}
+	for desc, test := range map[string]struct {
+		hostPath           string
+		privileged         bool
+		hostNetwork        bool
+		sandboxAnnotations map[string]string
+		expectDenied       bool
+		expected           *runtime.Mount
+	}{
+		"should allow mount not matching any rule": {
+			hostPath: "/data",
+			expected: &runtime.Mount{ContainerPath: "/mnt", HostPath: "/data"},
+		},
+		"should deny mount of exact host path": {
+			hostPath:     "/",
+			expectDenied: true,
+		},
+		"should deny mount under host path": {
+			hostPath:     "/var/lib/kubelet/pods/pod-1/volumes",
+			expectDenied: true,
+		},
+		"should allow mount of kubelet pod volume under host path": {
+			hostPath: "/var/lib/kubelet/pods/pod-1/volumes/kubernetes.io~empty-dir/cache",
+			expected: &runtime.Mount{ContainerPath: "/mnt", HostPath: "/var/lib/kubelet/pods/pod-1/volumes/kubernetes.io~empty-dir/cache"},
+		},
+		"should allow mount of kubelet pod volume subpath under host path": {
+			hostPath: "/var/lib/kubelet/pods/pod-1/volume-subpaths/config/app/0",
+			expected: &runtime.Mount{ContainerPath: "/mnt", HostPath: "/var/lib/kubelet/pods/pod-1/volume-subpaths/config/app/0"},
+		},
+		"should not apply rule for pods directory to kubelet pod volume": {
+			hostPath:    "/var/lib/kubelet/pods/pod-1/volumes/kubernetes.io~secret/token",
+			privileged:  true,
+			hostNetwork: true,
+			expected:    &runtime.Mount{ContainerPath: "/mnt", HostPath: "/var/lib/kubelet/pods/pod-1/volumes/kubernetes.io~secret/token"},
+		},
+		"should deny mount of kubelet pod directory": {
+			hostPath:     "/var/lib/kubelet/pods/pod-1/containers",
+			expectDenied: true,
+		},
+		"should deny mount of parent of host path": {
+			hostPath:     "/var/lib",
+			expectDenied: true,
+		},
+		"should deny mount of unclean host path": {
+			hostPath:     "/var/lib/../lib/kubelet/",
+			expectDenied: true,
+		},
+		"should make mount read only for privileged host network container": {
+			hostPath:    "/var/lib/kubelet/pods/pod-1",
+			privileged:  true,
+			hostNetwork: true,
+			expected:    &runtime.Mount{ContainerPath: "/mnt", HostPath: "/var/lib/kubelet/pods/pod-1", Readonly: true},
+		},
+		"should not apply privileged rule to unprivileged container": {
+			hostPath:     "/var/lib/kubelet/pods/pod-1",
+			hostNetwork:  true,
+			expectDenied: true,
+		},
+		"should rewrite mount": {
+			hostPath: "/etc/kubernetes/pki",
+			expected: &runtime.Mount{ContainerPath: "/mnt", HostPath: "/var/empty"},
+		},
+		"should allow mount of pod with allow annotation": {
+			hostPath:           "/var/lib/kubelet",
+			sandboxAnnotations: map[string]string{"mount-policy/exempt": "true"},
+			expected:           &runtime.Mount{ContainerPath: "/mnt", HostPath: "/var/lib/kubelet"},
+		},
+		"should deny mount of pod with mismatched allow annotation": {
+			hostPath:           "/var/lib/kubelet",
+			sandboxAnnotations: map[string]string{"mount-policy/exempt": "false"},
+			expectDenied:       true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		mount := &runtime.Mount{ContainerPath: "/mnt", HostPath: test.hostPath}
+		config := &runtime.ContainerConfig{
+			Mounts: []*runtime.Mount{mount},
+			Linux: &runtime.LinuxContainerConfig{
+				SecurityContext: &runtime.LinuxContainerSecurityContext{Privileged: test.privileged},
+			},
+		}
+		sandboxConfig := &runtime.PodSandboxConfig{
+			Annotations: test.sandboxAnnotations,
+			Linux: &runtime.LinuxPodSandboxConfig{
+				SecurityContext: &runtime.LinuxSandboxSecurityContext{
+					NamespaceOptions: &runtime.NamespaceOption{HostNetwork: test.hostNetwork},
+				},
+			},
+		}
+		mounts, err := policy.apply(config, sandboxConfig)
+		if test.expectDenied {
+			assert.Equal(t, ReasonMountDenied, ErrorReason(err))
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, []*runtime.Mount{test.expected}, mounts)
+		assert.Equal(t, test.hostPath, mount.HostPath, "original mount should not be changed")
+		assert.False(t, mount.Readonly, "original mount should not be changed")
+	}
+}
+
+func TestCreateContainerMountDenied(t *testing.T) {
+	c, snapshotter, req := newTestCreateContainerService(t)
+	c.mountPolicy = &mountPolicy{Rules: []mountPolicyRule{{HostPath: "/", Exact: true, Action: mountActionDeny}}}
+	req.Config.Mounts = []*runtime.Mount{{ContainerPath: "/host", HostPath: "/"}}
+	_, err := c.CreateContainer(context.Background(), req)
+	assert.Equal(t, ReasonMountDenied, ErrorReason(err))
+	assert.Empty(t, snapshotter.prepared)
+	assert.Empty(t, c.containerStore.List())
+}
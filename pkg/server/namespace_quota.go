@@ -0,0 +1,373 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/golang/glog"
+	imagedigest "github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// defaultNamespaceQuotaKey is the key of the quota applied to namespaces
+	// without their own quota.
+	defaultNamespaceQuotaKey = "*"
+	// imageChargesCheckpointFile is the file under the root directory
+	// checkpointing the owning namespaces of images, which are recovered after
+	// restart.
+	imageChargesCheckpointFile = "image-namespaces.json"
+)
+
+// namespaceQuota is the resource cap of a kubernetes namespace on the node.
+// 0 means unlimited.
+type namespaceQuota struct {
+	// Sandboxes is the maximum number of sandboxes.
+	Sandboxes int `json:"sandboxes,omitempty"`
+	// Containers is the maximum number of containers.
+	Containers int `json:"containers,omitempty"`
+	// ImageBytes is the maximum total size of images pulled for the namespace.
+	ImageBytes int64 `json:"imageBytes,omitempty"`
+}
+
+// namespaceUsage is the resource usage of a kubernetes namespace on the node.
+type namespaceUsage struct {
+	Sandboxes  int   `json:"sandboxes"`
+	Containers int   `json:"containers"`
+	ImageBytes int64 `json:"imageBytes"`
+}
+
+// namespaceQuotaTracker tracks sandboxes, containers and image bytes of each
+// kubernetes namespace, and enforces the namespace quotas. An image is only
+// accounted to the namespace owning it, which is the namespace it was first
+// pulled for, so that the node image usage is never counted twice. Sandboxes
+// and containers are accounted until they are removed, so stopped sandboxes and
+// exited containers which are not removed yet still count against the quota.
+type namespaceQuotaTracker struct {
+	sync.Mutex
+	// quotas are quotas of namespaces, the "*" quota applies to namespaces
+	// not in the map.
+	quotas map[string]namespaceQuota
+	// sandboxes maps sandbox id to namespace.
+	sandboxes map[string]string
+	// containers maps container id to namespace.
+	containers map[string]string
+	// images maps image id to the owning namespace and size.
+	images map[string]imageCharge
+}
+
+// imageCharge is an image accounted to its owning namespace.
+type imageCharge struct {
+	Namespace string `json:"namespace"`
+	Size      int64  `json:"size"`
+}
+
+// newNamespaceQuotaTracker creates a namespace quota tracker.
+func newNamespaceQuotaTracker(quotas map[string]namespaceQuota) *namespaceQuotaTracker {
+	return &namespaceQuotaTracker{
+		quotas:     quotas,
+		sandboxes:  make(map[string]string),
+		containers: make(map[string]string),
+		images:     make(map[string]imageCharge),
+	}
+}
+
+// loadNamespaceQuotas loads namespace quotas from the json file, which maps
+// namespace to quota. Empty path means no quota.
+func loadNamespaceQuotas(path string) (map[string]namespaceQuota, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var quotas map[string]namespaceQuota
+	if err := readJSONFile(path, &quotas); err != nil {
+		return nil, fmt.Errorf("failed to load namespace quotas %q: %v", path, err)
+	}
+	for ns, q := range quotas {
+		if q.Sandboxes < 0 || q.Containers < 0 || q.ImageBytes < 0 {
+			return nil, fmt.Errorf("invalid negative quota %+v for namespace %q", q, ns)
+		}
+	}
+	return quotas, nil
+}
+
+// quota returns the quota of the namespace.
+func (t *namespaceQuotaTracker) quota(ns string) namespaceQuota {
+	if q, ok := t.quotas[ns]; ok {
+		return q
+	}
+	return t.quotas[defaultNamespaceQuotaKey]
+}
+
+// usageLocked returns the usage of the namespace. The lock must be held.
+func (t *namespaceQuotaTracker) usageLocked(ns string) namespaceUsage {
+	var u namespaceUsage
+	for _, n := range t.sandboxes {
+		if n == ns {
+			u.Sandboxes++
+		}
+	}
+	for _, n := range t.containers {
+		if n == ns {
+			u.Containers++
+		}
+	}
+	for _, i := range t.images {
+		if i.Namespace == ns {
+			u.ImageBytes += i.Size
+		}
+	}
+	return u
+}
+
+// reserveSandbox accounts the sandbox to the namespace. It returns a cri error
+// with ResourceExhausted code if the sandbox quota is exceeded.
+func (t *namespaceQuotaTracker) reserveSandbox(id, ns string) error {
+	t.Lock()
+	defer t.Unlock()
+	if max := t.quota(ns).Sandboxes; max > 0 {
+		if used := t.usageLocked(ns).Sandboxes; used >= max {
+			return newCRIError(codes.ResourceExhausted, ReasonNamespaceQuotaExceeded,
+				"namespace %q exceeds sandbox quota %d", ns, max)
+		}
+	}
+	t.sandboxes[id] = ns
+	return nil
+}
+
+// accountSandbox accounts the sandbox to the namespace regardless of the quota,
+// e.g. for a sandbox which is already running.
+func (t *namespaceQuotaTracker) accountSandbox(id, ns string) {
+	t.Lock()
+	defer t.Unlock()
+	t.sandboxes[id] = ns
+}
+
+// releaseSandbox releases the sandbox from its namespace.
+func (t *namespaceQuotaTracker) releaseSandbox(id string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.sandboxes, id)
+}
+
+// reserveContainer accounts the container to the namespace. It returns a cri
+// error with ResourceExhausted code if the container quota is exceeded.
+func (t *namespaceQuotaTracker) reserveContainer(id, ns string) error {
+	t.Lock()
+	defer t.Unlock()
+	if max := t.quota(ns).Containers; max > 0 {
+		if used := t.usageLocked(ns).Containers; used >= max {
+			return newCRIError(codes.ResourceExhausted, ReasonNamespaceQuotaExceeded,
+				"namespace %q exceeds container quota %d", ns, max)
+		}
+	}
+	t.containers[id] = ns
+	return nil
+}
+
+// accountContainer accounts the container to the namespace regardless of the
+// quota, e.g. for a container which is already running.
+func (t *namespaceQuotaTracker) accountContainer(id, ns string) {
+	t.Lock()
+	defer t.Unlock()
+	t.containers[id] = ns
+}
+
+// releaseContainer releases the container from its namespace.
+func (t *namespaceQuotaTracker) releaseContainer(id string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.containers, id)
+}
+
+// checkImageBytes returns a cri error with ResourceExhausted code if the
+// namespace has used up its image bytes quota.
+func (t *namespaceQuotaTracker) checkImageBytes(ns string) error {
+	if ns == "" {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	if max := t.quota(ns).ImageBytes; max > 0 {
+		if used := t.usageLocked(ns).ImageBytes; used >= max {
+			return newCRIError(codes.ResourceExhausted, ReasonNamespaceQuotaExceeded,
+				"namespace %q exceeds image bytes quota %d", ns, max)
+		}
+	}
+	return nil
+}
+
+// checkImageSize returns a cri error with ResourceExhausted code if accounting
+// the image of the size to the namespace would exceed its image bytes quota,
+// e.g. before the image layers are fetched. Images already owned by a namespace
+// and images without namespace are never rejected.
+func (t *namespaceQuotaTracker) checkImageSize(ns, id string, size int64) error {
+	if ns == "" {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.images[id]; ok {
+		return nil
+	}
+	return t.checkImageSizeLocked(ns, id, size)
+}
+
+// checkImageSizeLocked checks the image size against the image bytes quota of
+// the namespace. The lock must be held.
+func (t *namespaceQuotaTracker) checkImageSizeLocked(ns, id string, size int64) error {
+	if max := t.quota(ns).ImageBytes; max > 0 {
+		if used := t.usageLocked(ns).ImageBytes; used+size > max {
+			return newCRIError(codes.ResourceExhausted, ReasonNamespaceQuotaExceeded,
+				"namespace %q exceeds image bytes quota %d with image %q of %d bytes", ns, max, id, size)
+		}
+	}
+	return nil
+}
+
+// addImage accounts the image to the namespace, which owns it from then on.
+// Images already owned by a namespace are not accounted again, and images
+// without namespace are not accounted. It returns a cri error with
+// ResourceExhausted code if the image bytes quota would be exceeded.
+func (t *namespaceQuotaTracker) addImage(ns, id string, size int64) error {
+	if ns == "" {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	if _, ok := t.images[id]; ok {
+		return nil
+	}
+	if err := t.checkImageSizeLocked(ns, id, size); err != nil {
+		return err
+	}
+	t.images[id] = imageCharge{Namespace: ns, Size: size}
+	return nil
+}
+
+// accountImage accounts the image to the namespace regardless of the quota,
+// e.g. for an image recovered after restart.
+func (t *namespaceQuotaTracker) accountImage(ns, id string, size int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.images[id] = imageCharge{Namespace: ns, Size: size}
+}
+
+// imageCharges returns a copy of all accounted images keyed by image id.
+func (t *namespaceQuotaTracker) imageCharges() map[string]imageCharge {
+	t.Lock()
+	defer t.Unlock()
+	charges := make(map[string]imageCharge)
+	for id, i := range t.images {
+		charges[id] = i
+	}
+	return charges
+}
+
+// removeImage releases the image from its owning namespace.
+func (t *namespaceQuotaTracker) removeImage(id string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.images, id)
+}
+
+// usage returns usage of all namespaces with any resource.
+func (t *namespaceQuotaTracker) usage() map[string]namespaceUsage {
+	t.Lock()
+	defer t.Unlock()
+	namespaces := make(map[string]bool)
+	for _, ns := range t.sandboxes {
+		namespaces[ns] = true
+	}
+	for _, ns := range t.containers {
+		namespaces[ns] = true
+	}
+	for _, i := range t.images {
+		namespaces[i.Namespace] = true
+	}
+	usage := make(map[string]namespaceUsage)
+	for ns := range namespaces {
+		usage[ns] = t.usageLocked(ns)
+	}
+	return usage
+}
+
+// checkpointImageCharges checkpoints the owning namespaces of images into the
+// root directory, so that the image usage of namespaces survives restart.
+func (c *criContainerdService) checkpointImageCharges() error {
+	data, err := json.Marshal(c.namespaceQuotas.imageCharges())
+	if err != nil {
+		return fmt.Errorf("failed to marshal image charges: %v", err)
+	}
+	path := filepath.Join(c.rootDir, imageChargesCheckpointFile)
+	if err := c.os.AtomicWriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to checkpoint image charges to %q: %v", path, err)
+	}
+	return nil
+}
+
+// recoverImageCharges accounts images checkpointed by checkpointImageCharges to
+// their owning namespaces again. Images whose config content no longer exists
+// were removed while cri-containerd was down, and are not accounted. A missing
+// checkpoint means no image was accounted.
+func (c *criContainerdService) recoverImageCharges(ctx context.Context) error {
+	path := filepath.Join(c.rootDir, imageChargesCheckpointFile)
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read image charges checkpoint %q: %v", path, err)
+	}
+	var charges map[string]imageCharge
+	if err := json.Unmarshal(data, &charges); err != nil {
+		return fmt.Errorf("failed to unmarshal image charges checkpoint %q: %v", path, err)
+	}
+	for id, i := range charges {
+		if _, err := c.contentStoreService.Info(ctx, imagedigest.Digest(id)); err != nil {
+			if !errdefs.IsNotFound(err) {
+				return fmt.Errorf("failed to get image %q config content: %v", id, err)
+			}
+			glog.V(4).Infof("Skip image %q charged to namespace %q, which no longer exists", id, i.Namespace)
+			continue
+		}
+		c.namespaceQuotas.accountImage(i.Namespace, id, i.Size)
+	}
+	return nil
+}
+
+// namespaceQuotaStatus is the usage and quota of a namespace.
+type namespaceQuotaStatus struct {
+	Usage namespaceUsage `json:"usage"`
+	Quota namespaceQuota `json:"quota"`
+}
+
+// handleNamespaceUsage serves usage and quota of all namespaces.
+func (c *criContainerdService) handleNamespaceUsage(w http.ResponseWriter, r *http.Request) {
+	status := make(map[string]namespaceQuotaStatus)
+	for ns, u := range c.namespaceQuotas.usage() {
+		status[ns] = namespaceQuotaStatus{Usage: u, Quota: c.namespaceQuotas.quota(ns)}
+	}
+	writeJSON(w, status)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+func TestNamespaceQuotaTracker(t *testing.T) {
+	tracker := newNamespaceQuotaTracker(map[string]namespaceQuota{
+		"ns-1":                   {Sandboxes: 1, Containers: 2, ImageBytes: 100},
+		defaultNamespaceQuotaKey: {Sandboxes: 2},
+	})
+
+	t.Logf("should enforce sandbox quota of namespace")
+	assert.NoError(t, tracker.reserveSandbox("s-1", "ns-1"))
+	err := tracker.reserveSandbox("s-2", "ns-1")
+	assert.Equal(t, ReasonNamespaceQuotaExceeded, ErrorReason(err))
+	assert.Equal(t, codes.ResourceExhausted, grpc.Code(toGRPCError(err)))
+	tracker.releaseSandbox("s-1")
+	assert.NoError(t, tracker.reserveSandbox("s-2", "ns-1"))
+
+	t.Logf("should enforce default quota for other namespaces")
+	assert.NoError(t, tracker.reserveSandbox("s-3", "ns-2"))
+	assert.NoError(t, tracker.reserveSandbox("s-4", "ns-2"))
+	assert.Error(t, tracker.reserveSandbox("s-5", "ns-2"))
+
+	t.Logf("should enforce container quota")
+	assert.NoError(t, tracker.reserveContainer("c-1", "ns-1"))
+	assert.NoError(t, tracker.reserveContainer("c-2", "ns-1"))
+	assert.Error(t, tracker.reserveContainer("c-3", "ns-1"))
+	tracker.releaseContainer("c-1")
+	assert.NoError(t, tracker.reserveContainer("c-3", "ns-1"))
+	t.Logf("default quota without container cap should be unlimited")
+	for _, id := range []string{"c-4", "c-5", "c-6"} {
+		assert.NoError(t, tracker.reserveContainer(id, "ns-2"))
+	}
+
+	t.Logf("should enforce image bytes quota")
+	assert.NoError(t, tracker.checkImageBytes("ns-1"))
+	assert.NoError(t, tracker.addImage("ns-1", "image-1", 60))
+	assert.NoError(t, tracker.addImage("ns-1", "image-1", 60), "image should not be accounted twice")
+	assert.Error(t, tracker.addImage("ns-1", "image-2", 60))
+	assert.NoError(t, tracker.addImage("ns-1", "image-3", 40))
+	assert.Error(t, tracker.checkImageBytes("ns-1"))
+	t.Logf("image size should be checked before the image is added")
+	assert.Error(t, tracker.checkImageSize("ns-1", "image-6", 60))
+	assert.NoError(t, tracker.checkImageSize("ns-1", "image-6", 0))
+	assert.NoError(t, tracker.checkImageSize("ns-1", "image-1", 60), "owned image should not be checked")
+	t.Logf("shared image should only be accounted to the owning namespace")
+	assert.NoError(t, tracker.addImage("ns-2", "image-1", 60))
+	assert.NoError(t, tracker.addImage("ns-2", "image-5", 30))
+	t.Logf("image without namespace should not be accounted")
+	assert.NoError(t, tracker.addImage("", "image-4", 1000))
+
+	assert.Equal(t, map[string]namespaceUsage{
+		"ns-1": {Sandboxes: 1, Containers: 2, ImageBytes: 100},
+		"ns-2": {Sandboxes: 2, Containers: 3, ImageBytes: 30},
+	}, tracker.usage())
+
+	t.Logf("removed image should be released from the owning namespace")
+	tracker.removeImage("image-1")
+	assert.NoError(t, tracker.checkImageBytes("ns-1"))
+	assert.Equal(t, map[string]namespaceUsage{
+		"ns-1": {Sandboxes: 1, Containers: 2, ImageBytes: 40},
+		"ns-2": {Sandboxes: 2, Containers: 3, ImageBytes: 30},
+	}, tracker.usage())
+}
+
+func TestNamespaceQuotaTrackerWithoutQuota(t *testing.T) {
+	tracker := newNamespaceQuotaTracker(nil)
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, tracker.reserveSandbox(id, "ns"))
+		assert.NoError(t, tracker.reserveContainer(id, "ns"))
+		assert.NoError(t, tracker.addImage("ns", id, 1<<30))
+	}
+	assert.NoError(t, tracker.checkImageBytes("ns"))
+}
+
+func TestLoadNamespaceQuotas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "namespace-quota-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for desc, test := range map[string]struct {
+		content     string
+		expectErr   bool
+		expectQuota map[string]namespaceQuota
+	}{
+		"valid quotas": {
+			content: `{"ns": {"sandboxes": 1, "containers": 2, "imageBytes": 3}, "*": {"sandboxes": 4}}`,
+			expectQuota: map[string]namespaceQuota{
+				"ns": {Sandboxes: 1, Containers: 2, ImageBytes: 3},
+				"*":  {Sandboxes: 4},
+			},
+		},
+		"negative quota": {
+			content:   `{"ns": {"sandboxes": -1}}`,
+			expectErr: true,
+		},
+		"invalid json": {
+			content:   `{"ns":`,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		path := filepath.Join(dir, "quota.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(test.content), 0644))
+		quotas, err := loadNamespaceQuotas(path)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectQuota, quotas)
+	}
+
+	quotas, err := loadNamespaceQuotas("")
+	assert.NoError(t, err)
+	assert.Nil(t, quotas)
+}
+
+func TestRecoverImageCharges(t *testing.T) {
+	c, _, config, _ := newTestVerifyImageService(t)
+	c.namespaceQuotas = newNamespaceQuotaTracker(nil)
+	c.namespaceQuotas.accountImage("test-ns", config.Digest.String(), 100)
+	c.namespaceQuotas.accountImage("test-ns", "sha256:removed", 50)
+	fakeOS := c.os.(*ostesting.FakeOS)
+	var checkpoint []byte
+	fakeOS.AtomicWriteFileFn = func(_ string, data []byte, _ os.FileMode) error {
+		checkpoint = data
+		return nil
+	}
+	require.NoError(t, c.checkpointImageCharges())
+
+	t.Logf("image usage should be recovered from the checkpoint")
+	c.namespaceQuotas = newNamespaceQuotaTracker(nil)
+	fakeOS.ReadFileFn = func(filename string) ([]byte, error) {
+		assert.Equal(t, filepath.Join(c.rootDir, imageChargesCheckpointFile), filename)
+		return checkpoint, nil
+	}
+	require.NoError(t, c.recoverImageCharges(context.Background()))
+	assert.Equal(t, map[string]imageCharge{
+		config.Digest.String(): {Namespace: "test-ns", Size: 100},
+	}, c.namespaceQuotas.imageCharges(), "removed image should not be recovered")
+
+	t.Logf("missing checkpoint should not be an error")
+	fakeOS.ReadFileFn = func(string) ([]byte, error) { return nil, os.ErrNotExist }
+	assert.NoError(t, c.recoverImageCharges(context.Background()))
+}
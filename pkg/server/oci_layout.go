@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/docker/distribution/reference"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// parseOCILayoutHostDirs parses "host=dir" pairs into a map from host to the
+// directory containing oci image layouts of the host.
+func parseOCILayoutHostDirs(pairs []string) (map[string]string, error) {
+	dirs := make(map[string]string)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !filepath.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("invalid oci layout host dir %q, should be host=/absolute/dir", pair)
+		}
+		dirs[parts[0]] = parts[1]
+	}
+	return dirs, nil
+}
+
+// ociLayoutResolver resolves image references from a local oci image layout
+// directory. Image "<host>/<path>:<tag>" is resolved from layout "<dir>/<path>",
+// where dir is configured for host, and tag is matched against the ref name
+// annotation of manifests in the layout index.
+type ociLayoutResolver struct {
+	// dir is the oci image layout directory.
+	dir string
+}
+
+var _ remotes.Resolver = &ociLayoutResolver{}
+
+// newOCILayoutResolver returns an oci layout resolver if the host of the image
+// reference is mapped to a local directory.
+func newOCILayoutResolver(hostDirs map[string]string, named reference.Named) (*ociLayoutResolver, bool) {
+	dir, ok := hostDirs[reference.Domain(named)]
+	if !ok {
+		return nil, false
+	}
+	return &ociLayoutResolver{dir: filepath.Join(dir, reference.Path(named))}, true
+}
+
+// Resolve resolves the reference into the manifest descriptor in the layout index.
+func (r *ociLayoutResolver) Resolve(ctx gocontext.Context, ref string) (string, imagespec.Descriptor, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", imagespec.Descriptor{}, fmt.Errorf("failed to parse reference %q: %v", ref, err)
+	}
+	var layout imagespec.ImageLayout
+	if err := readJSONFile(filepath.Join(r.dir, imagespec.ImageLayoutFile), &layout); err != nil {
+		return "", imagespec.Descriptor{}, fmt.Errorf("failed to read oci layout of %q: %v", r.dir, err)
+	}
+	if layout.Version != imagespec.ImageLayoutVersion {
+		return "", imagespec.Descriptor{}, fmt.Errorf("unsupported oci layout version %q", layout.Version)
+	}
+	var index imagespec.Index
+	if err := readJSONFile(filepath.Join(r.dir, "index.json"), &index); err != nil {
+		return "", imagespec.Descriptor{}, fmt.Errorf("failed to read oci layout index of %q: %v", r.dir, err)
+	}
+	var candidates []imagespec.Descriptor
+	for _, desc := range index.Manifests {
+		switch n := named.(type) {
+		case reference.Canonical:
+			if desc.Digest == n.Digest() {
+				candidates = append(candidates, desc)
+			}
+		case reference.NamedTagged:
+			if desc.Annotations[imagespec.AnnotationRefName] == n.Tag() {
+				candidates = append(candidates, desc)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return "", imagespec.Descriptor{}, fmt.Errorf("%q not found in oci layout %q", ref, r.dir)
+	}
+	// Prefer the manifest of the current platform.
+	for _, desc := range candidates {
+		if p := desc.Platform; p != nil && p.OS == goruntime.GOOS && p.Architecture == goruntime.GOARCH {
+			return ref, desc, nil
+		}
+	}
+	return ref, candidates[0], nil
+}
+
+// Fetcher returns a fetcher reading blobs from the layout.
+func (r *ociLayoutResolver) Fetcher(ctx gocontext.Context, ref string) (remotes.Fetcher, error) {
+	return remotes.FetcherFunc(func(ctx gocontext.Context, desc imagespec.Descriptor) (io.ReadCloser, error) {
+		// Validate the digest, so that it can't be used to access files outside the layout.
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid digest %q: %v", desc.Digest, err)
+		}
+		return os.Open(filepath.Join(r.dir, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Hex()))
+	}), nil
+}
+
+// Pusher is not supported for oci layouts.
+func (r *ociLayoutResolver) Pusher(ctx gocontext.Context, ref string) (remotes.Pusher, error) {
+	return nil, fmt.Errorf("push to oci layout is not supported")
+}
+
+// readJSONFile reads the json file into v.
+func readJSONFile(path string, v interface{}) error {
+	p, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseOCILayoutHostDirs(t *testing.T) {
+	dirs, err := parseOCILayoutHostDirs([]string{"preload.local=/var/lib/images", "other.local=/opt/images"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"preload.local": "/var/lib/images", "other.local": "/opt/images"}, dirs)
+	for _, invalid := range []string{"preload.local", "=/var/lib/images", "preload.local=relative/dir"} {
+		_, err := parseOCILayoutHostDirs([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestOCILayoutResolver(t *testing.T) {
+	root, err := ioutil.TempDir("", "oci-layout-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "library/busybox")
+	blob := []byte("test-manifest")
+	blobDigest := digest.FromBytes(blob)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "blobs", "sha256", blobDigest.Hex()), blob, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, imagespec.ImageLayoutFile),
+		[]byte(`{"imageLayoutVersion": "1.0.0"}`), 0644))
+	otherPlatform := &imagespec.Platform{OS: goruntime.GOOS, Architecture: "other"}
+	currentPlatform := &imagespec.Platform{OS: goruntime.GOOS, Architecture: goruntime.GOARCH}
+	index := imagespec.Index{Manifests: []imagespec.Descriptor{
+		{Digest: "sha256:other", Platform: otherPlatform, Annotations: map[string]string{imagespec.AnnotationRefName: "1.0"}},
+		{Digest: blobDigest, Platform: currentPlatform, Annotations: map[string]string{imagespec.AnnotationRefName: "1.0"}},
+		{Digest: "sha256:old", Annotations: map[string]string{imagespec.AnnotationRefName: "0.9"}},
+	}}
+	require.NoError(t, writeTestJSON(filepath.Join(dir, "index.json"), index))
+
+	named, err := reference.ParseNormalizedNamed("preload.local/library/busybox:1.0")
+	require.NoError(t, err)
+	_, ok := newOCILayoutResolver(map[string]string{"other.local": root}, named)
+	assert.False(t, ok, "unmapped host should not be resolved from oci layout")
+	resolver, ok := newOCILayoutResolver(map[string]string{"preload.local": root}, named)
+	require.True(t, ok)
+	assert.Equal(t, dir, resolver.dir)
+
+	for ref, expected := range map[string]digest.Digest{
+		"preload.local/library/busybox:1.0":                    blobDigest,
+		"preload.local/library/busybox:0.9":                    "sha256:old",
+		"preload.local/library/busybox@" + blobDigest.String(): blobDigest,
+		"preload.local/library/busybox:not-exist":              "",
+	} {
+		_, desc, err := resolver.Resolve(context.Background(), ref)
+		if expected == "" {
+			assert.Error(t, err, ref)
+			continue
+		}
+		require.NoError(t, err, ref)
+		assert.Equal(t, expected, desc.Digest, ref)
+	}
+
+	fetcher, err := resolver.Fetcher(context.Background(), "preload.local/library/busybox:1.0")
+	require.NoError(t, err)
+	rc, err := fetcher.Fetch(context.Background(), imagespec.Descriptor{Digest: blobDigest})
+	require.NoError(t, err)
+	defer rc.Close()
+	content, err := ioutil.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, blob, content)
+	_, err = fetcher.Fetch(context.Background(), imagespec.Descriptor{Digest: "sha256:../../../etc/passwd"})
+	assert.Error(t, err, "invalid digest should be rejected")
+}
+
+// writeTestJSON writes v as json into the file.
+func writeTestJSON(path string, v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, p, 0644)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+)
+
+// containerUsage is the resource usage samples of running containers.
+type containerUsage struct {
+	cpu        []metrics.Sample
+	memory     []metrics.Sample
+	workingSet []metrics.Sample
+}
+
+// sanitizeMetricLabelName replaces characters not allowed in prometheus label
+// names with `_`.
+func sanitizeMetricLabelName(name string) string {
+	b := []byte(name)
+	for i, ch := range b {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' ||
+			ch >= '0' && ch <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// getPodMetricLabels returns metric labels of the pod, and of the container
+// if config is not nil. Only pod and container labels in the allowlist are
+// exported, as `label_<name>`, so that the cardinality of the metrics is
+// bounded by the number of pods and containers. Container labels override pod
+// labels with the same name.
+func getPodMetricLabels(sandboxConfig *runtime.PodSandboxConfig, config *runtime.ContainerConfig,
+	allowlist []string) map[string]string {
+	labels := map[string]string{
+		"namespace": sandboxConfig.GetMetadata().GetNamespace(),
+		"pod":       sandboxConfig.GetMetadata().GetName(),
+	}
+	if config != nil {
+		labels["container"] = config.GetMetadata().GetName()
+	}
+	for _, key := range allowlist {
+		v, ok := config.GetLabels()[key]
+		if !ok {
+			v, ok = sandboxConfig.GetLabels()[key]
+		}
+		if ok {
+			labels["label_"+sanitizeMetricLabelName(key)] = v
+		}
+	}
+	return labels
+}
+
+// collectContainerUsage returns cpu and memory usage samples of all running
+// containers with cgroup parent, labeled with their namespace, pod and
+// container names.
+func (c *criContainerdService) collectContainerUsage() containerUsage {
+	var usage containerUsage
+	for _, container := range c.containerStore.List() {
+		cgroupsPath, err := c.getRunningContainerCgroupsPath(container)
+		if err != nil || cgroupsPath == "" {
+			continue
+		}
+		sandbox, err := c.sandboxStore.Get(container.SandboxID)
+		if err != nil {
+			continue
+		}
+		cpu, err := c.getCPUUsage(cgroupsPath)
+		if err != nil {
+			// The container may exit during collection.
+			glog.V(4).Infof("Failed to get cpu usage of container %q: %v", container.ID, err)
+			continue
+		}
+		memory, err := c.getMemoryStats(cgroupsPath)
+		if err != nil {
+			glog.V(4).Infof("Failed to get memory stats of container %q: %v", container.ID, err)
+			continue
+		}
+		labels := getPodMetricLabels(sandbox.Config, container.Config, c.config.PodMetricsLabelAllowlist)
+		usage.cpu = append(usage.cpu, metrics.Sample{Labels: labels, Value: float64(cpu) / float64(time.Second)})
+		usage.memory = append(usage.memory, metrics.Sample{Labels: labels, Value: float64(memory.Usage)})
+		usage.workingSet = append(usage.workingSet, metrics.Sample{Labels: labels, Value: float64(memory.WorkingSet)})
+	}
+	return usage
+}
+
+// collectPodNetworkUsage returns received and transmitted bytes samples of all
+// sandboxes with network stats, labeled with their namespace and pod names.
+func (c *criContainerdService) collectPodNetworkUsage() (rx, tx []metrics.Sample) {
+	for _, stats := range c.networkStats.list() {
+		sandbox, err := c.sandboxStore.Get(stats.ID)
+		if err != nil {
+			continue
+		}
+		labels := getPodMetricLabels(sandbox.Config, nil, c.config.PodMetricsLabelAllowlist)
+		rx = append(rx, metrics.Sample{Labels: labels, Value: float64(stats.RxBytes)})
+		tx = append(tx, metrics.Sample{Labels: labels, Value: float64(stats.TxBytes)})
+	}
+	return rx, tx
+}
+
+// Names of pod metrics.
+const (
+	containerCPUUsageMetric         = "cri_containerd_container_cpu_usage_seconds_total"
+	containerMemoryUsageMetric      = "cri_containerd_container_memory_usage_bytes"
+	containerMemoryWorkingSetMetric = "cri_containerd_container_memory_working_set_bytes"
+	podNetworkReceiveMetric         = "cri_containerd_pod_network_receive_bytes_total"
+	podNetworkTransmitMetric        = "cri_containerd_pod_network_transmit_bytes_total"
+)
+
+// registerPodMetrics registers per pod and container usage metrics, so that
+// runtime sourced usage is available without cadvisor. All metrics are
+// collected with one walk of all containers and sandboxes per scrape.
+func (c *criContainerdService) registerPodMetrics() {
+	snapshot := metrics.NewSnapshot(func() map[string][]metrics.Sample {
+		usage := c.collectContainerUsage()
+		rx, tx := c.collectPodNetworkUsage()
+		return map[string][]metrics.Sample{
+			containerCPUUsageMetric:         usage.cpu,
+			containerMemoryUsageMetric:      usage.memory,
+			containerMemoryWorkingSetMetric: usage.workingSet,
+			podNetworkReceiveMetric:         rx,
+			podNetworkTransmitMetric:        tx,
+		}
+	})
+	c.metrics.registry.MustRegister(
+		metrics.NewLabeledCounterFunc(containerCPUUsageMetric,
+			"Cumulative cpu time consumed by each running container in seconds.",
+			snapshot.Samples(containerCPUUsageMetric)),
+		metrics.NewLabeledGaugeFunc(containerMemoryUsageMetric,
+			"Memory usage of each running container in bytes.",
+			snapshot.Samples(containerMemoryUsageMetric)),
+		metrics.NewLabeledGaugeFunc(containerMemoryWorkingSetMetric,
+			"Memory working set of each running container in bytes.",
+			snapshot.Samples(containerMemoryWorkingSetMetric)),
+		metrics.NewLabeledCounterFunc(podNetworkReceiveMetric,
+			"Cumulative bytes received by each pod, collected every pod network stats period.",
+			snapshot.Samples(podNetworkReceiveMetric)),
+		metrics.NewLabeledCounterFunc(podNetworkTransmitMetric,
+			"Cumulative bytes transmitted by each pod, collected every pod network stats period.",
+			snapshot.Samples(podNetworkTransmitMetric)),
+	)
+	c.metrics.registry.RegisterCollector(snapshot)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestSanitizeMetricLabelName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"app":                    "app",
+		"app.kubernetes.io/name": "app_kubernetes_io_name",
+		"0tier":                  "_tier",
+		"tier-2":                 "tier_2",
+	} {
+		assert.Equal(t, expected, sanitizeMetricLabelName(name), name)
+	}
+}
+
+func TestGetPodMetricLabels(t *testing.T) {
+	sandboxConfig := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{Name: "pod-1", Namespace: "ns-1"},
+		Labels:   map[string]string{"app": "web", "tier": "frontend", "pod-template-hash": "abc"},
+	}
+	config := &runtime.ContainerConfig{
+		Metadata: &runtime.ContainerMetadata{Name: "container-1"},
+		Labels:   map[string]string{"tier": "backend"},
+	}
+	for desc, test := range map[string]struct {
+		config    *runtime.ContainerConfig
+		allowlist []string
+		expected  map[string]string
+	}{
+		"pod labels": {
+			expected: map[string]string{"namespace": "ns-1", "pod": "pod-1"},
+		},
+		"container labels": {
+			config:   config,
+			expected: map[string]string{"namespace": "ns-1", "pod": "pod-1", "container": "container-1"},
+		},
+		"allowlisted pod labels": {
+			allowlist: []string{"app", "missing"},
+			expected:  map[string]string{"namespace": "ns-1", "pod": "pod-1", "label_app": "web"},
+		},
+		"container labels override pod labels": {
+			config:    config,
+			allowlist: []string{"app", "tier"},
+			expected: map[string]string{"namespace": "ns-1", "pod": "pod-1", "container": "container-1",
+				"label_app": "web", "label_tier": "backend"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, getPodMetricLabels(sandboxConfig, test.config, test.allowlist))
+	}
+}
+
+func TestCollectContainerUsage(t *testing.T) {
+	c := newTestCRIContainerdService()
+	c.config.PodMetricsLabelAllowlist = []string{"app"}
+	fakeOS := ostesting.NewFakeOS()
+	files := map[string]string{
+		"/sys/fs/cgroup/cpuacct/kubepods/pod-1/container-1/cpuacct.usage":        "1500000000\n",
+		"/sys/fs/cgroup/memory/kubepods/pod-1/container-1/memory.usage_in_bytes": "4096\n",
+		"/sys/fs/cgroup/memory/kubepods/pod-1/container-1/memory.stat":           "total_rss 1024\ntotal_inactive_file 1000\n",
+	}
+	fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, fmt.Errorf("%q not found", path)
+		}
+		return []byte(content), nil
+	}
+	c.os = fakeOS
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID: "sandbox-1",
+		Config: &runtime.PodSandboxConfig{
+			Metadata: &runtime.PodSandboxMetadata{Name: "pod-1", Namespace: "ns-1"},
+			Labels:   map[string]string{"app": "web"},
+			Linux:    &runtime.LinuxPodSandboxConfig{CgroupParent: "/kubepods/pod-1"},
+		},
+	}}))
+	for id, status := range map[string]containerstore.Status{
+		"container-1": {CreatedAt: 1, StartedAt: 2},
+		"container-2": {CreatedAt: 1, StartedAt: 2, FinishedAt: 3},
+	} {
+		container, err := containerstore.NewContainer(containerstore.Metadata{
+			ID:        id,
+			SandboxID: "sandbox-1",
+			Config: &runtime.ContainerConfig{
+				Metadata: &runtime.ContainerMetadata{Name: "name-" + id},
+			},
+		}, status)
+		require.NoError(t, err)
+		require.NoError(t, c.containerStore.Add(container))
+	}
+
+	labels := map[string]string{
+		"namespace": "ns-1",
+		"pod":       "pod-1",
+		"container": "name-container-1",
+		"label_app": "web",
+	}
+	usage := c.collectContainerUsage()
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 1.5}}, usage.cpu)
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 4096}}, usage.memory)
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 3096}}, usage.workingSet)
+}
+
+func TestCollectPodNetworkUsage(t *testing.T) {
+	c := newTestCRIContainerdService()
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID: "sandbox-1",
+		Config: &runtime.PodSandboxConfig{
+			Metadata: &runtime.PodSandboxMetadata{Name: "pod-1", Namespace: "ns-1"},
+		},
+	}}))
+	c.networkStats.stats = map[string]*sandboxNetworkStats{
+		"sandbox-1": {ID: "sandbox-1", InterfaceStats: netplugin.InterfaceStats{RxBytes: 100, TxBytes: 200}},
+		// Stats of a removed sandbox are not exported.
+		"sandbox-2": {ID: "sandbox-2", InterfaceStats: netplugin.InterfaceStats{RxBytes: 300, TxBytes: 400}},
+	}
+	labels := map[string]string{"namespace": "ns-1", "pod": "pod-1"}
+	rx, tx := c.collectPodNetworkUsage()
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 100}}, rx)
+	assert.Equal(t, []metrics.Sample{{Labels: labels, Value: 200}}, tx)
+}
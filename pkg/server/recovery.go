@@ -0,0 +1,302 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/containers"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/server/agents"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+const (
+	// sandboxMetadataLabel is the containerd container label of the
+	// checkpointed sandbox metadata.
+	sandboxMetadataLabel = "io.kubernetes.cri-containerd.sandbox-metadata"
+	// containerMetadataLabel is the containerd container label of the
+	// checkpointed container metadata.
+	containerMetadataLabel = "io.kubernetes.cri-containerd.container-metadata"
+	// unknownExitReason is the exit reason of containers whose exit is not
+	// observed by cri-containerd.
+	unknownExitReason = "Unknown"
+)
+
+// sandboxMetadataLabels returns the containerd container labels checkpointing
+// the sandbox metadata.
+func sandboxMetadataLabels(meta sandboxstore.Metadata) (map[string]string, error) {
+	data, err := meta.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sandbox metadata: %v", err)
+	}
+	return map[string]string{sandboxMetadataLabel: string(data)}, nil
+}
+
+// containerMetadataLabels returns the containerd container labels
+// checkpointing the container metadata.
+func containerMetadataLabels(meta containerstore.Metadata) (map[string]string, error) {
+	data, err := meta.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode container metadata: %v", err)
+	}
+	return map[string]string{containerMetadataLabel: string(data)}, nil
+}
+
+// recoverState rebuilds sandbox and container state from containerd containers and
+// tasks after restart. Tasks of sandboxes and containers with checkpointed metadata
+// which can't be recovered are killed and deleted, so that nothing keeps running
+// untracked. Tasks conflicting with sandboxes and containers already in the stores
+// are left running. Containerd containers without checkpointed metadata may not be
+// created by cri-containerd, so they are only logged and skipped. The event monitor
+// must be subscribed before, so that exits of tasks listed as running are not lost.
+func (c *criContainerdService) recoverState(ctx context.Context) error {
+	cntrs, err := c.containerService.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list containerd containers: %v", err)
+	}
+	resp, err := c.taskService.List(ctx, &tasks.ListTasksRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to list containerd tasks: %v", err)
+	}
+	taskByID := make(map[string]*task.Task)
+	for _, t := range resp.Tasks {
+		taskByID[t.ID] = t
+	}
+
+	// Recover sandboxes first, because containers are only recovered if their
+	// sandbox is.
+	var appContainers []containers.Container
+	for _, cntr := range cntrs {
+		switch {
+		case cntr.Labels[sandboxMetadataLabel] != "":
+			if err := c.recoverSandbox(cntr, taskByID[cntr.ID]); err != nil {
+				glog.Errorf("Failed to recover sandbox %q: %v", cntr.ID, err)
+				if !isImportConflictError(err) {
+					c.cleanupOrphanTask(ctx, cntr.ID, taskByID[cntr.ID])
+				}
+				continue
+			}
+			glog.V(2).Infof("Recovered sandbox %q", cntr.ID)
+		case cntr.Labels[containerMetadataLabel] != "":
+			appContainers = append(appContainers, cntr)
+		default:
+			glog.Warningf("Skip containerd container %q without checkpointed metadata", cntr.ID)
+		}
+	}
+	for _, cntr := range appContainers {
+		if err := c.recoverContainer(ctx, cntr, taskByID[cntr.ID]); err != nil {
+			glog.Errorf("Failed to recover container %q: %v", cntr.ID, err)
+			// A task is only killed if it can never be tracked, a conflict
+			// with another sandbox or container leaves it running.
+			if !isImportConflictError(err) {
+				c.cleanupOrphanTask(ctx, cntr.ID, taskByID[cntr.ID])
+			}
+			continue
+		}
+		glog.V(2).Infof("Recovered container %q", cntr.ID)
+	}
+	if err := c.recoverImageCharges(ctx); err != nil {
+		glog.Errorf("Failed to recover image usage of namespaces: %v", err)
+	}
+	return nil
+}
+
+// recoverSandbox adds the sandbox checkpointed in the containerd container into
+// the sandbox store.
+func (c *criContainerdService) recoverSandbox(cntr containers.Container, t *task.Task) error {
+	var meta sandboxstore.Metadata
+	if err := meta.Decode([]byte(cntr.Labels[sandboxMetadataLabel])); err != nil {
+		return fmt.Errorf("failed to decode sandbox metadata: %v", err)
+	}
+	meta.CreatedAt = cntr.CreatedAt.UnixNano()
+	// The persistent network namespace is checkpointed. Otherwise the network
+	// namespace is gone with the sandbox container process.
+	if t != nil && t.Status != task.StatusStopped {
+		meta.Pid = t.Pid
+		if !isPersistentNetNS(meta.NetNS) {
+			meta.NetNS = getNetworkNamespace(t.Pid)
+		}
+	}
+	return c.importSandbox(meta, true)
+}
+
+// recoverContainer adds the container checkpointed in the containerd container
+// into the container store, with status recovered from the task.
+func (c *criContainerdService) recoverContainer(ctx context.Context, cntr containers.Container, t *task.Task) error {
+	var meta containerstore.Metadata
+	if err := meta.Decode([]byte(cntr.Labels[containerMetadataLabel])); err != nil {
+		return fmt.Errorf("failed to decode container metadata: %v", err)
+	}
+	statusPath := getContainerStatusPath(c.rootDir, meta.ID)
+	var checkpoint *containerstore.Status
+	if data, err := c.os.ReadFile(statusPath); err == nil {
+		s, err := containerstore.LoadStatus(data)
+		if err != nil {
+			glog.Errorf("Failed to load status checkpoint of container %q: %v", meta.ID, err)
+		} else {
+			checkpoint = &s
+		}
+	} else if !os.IsNotExist(err) {
+		glog.Errorf("Failed to read status checkpoint of container %q: %v", meta.ID, err)
+	}
+	status := c.recoverContainerStatus(ctx, cntr, t, checkpoint)
+	container, err := containerstore.NewContainer(meta, status, containerstore.WithStatusCheckpoint(c.os, statusPath))
+	if err != nil {
+		return fmt.Errorf("failed to create container: %v", err)
+	}
+	if err := c.importContainer(container, true); err != nil {
+		return err
+	}
+	if status.State() == runtime.ContainerState_CONTAINER_RUNNING {
+		if err := c.reopenContainerLoggers(ctx, meta); err != nil {
+			glog.Errorf("Failed to reopen loggers of container %q: %v", meta.ID, err)
+		}
+	}
+	return nil
+}
+
+// recoverContainerStatus recovers the container status from the task and the
+// status checkpoint, which is nil if it is missing. The task is deleted after
+// the container exits, so a container without task either exited or was never
+// started. Without checkpoint telling it was never started, it is treated as
+// exited, so that a container which has run is never started again.
+func (c *criContainerdService) recoverContainerStatus(ctx context.Context, cntr containers.Container, t *task.Task,
+	checkpoint *containerstore.Status) containerstore.Status {
+	status := containerstore.Status{CreatedAt: cntr.CreatedAt.UnixNano()}
+	if checkpoint != nil {
+		status = *checkpoint
+	}
+	if t != nil {
+		switch t.Status {
+		case task.StatusRunning, task.StatusPaused:
+			status.Pid = t.Pid
+			setUnknownStartedAt(&status)
+			return status
+		case task.StatusStopped:
+			if c.config.ReadOnly {
+				// The exit status is only returned by the deletion.
+				break
+			}
+			resp, err := c.taskService.Delete(ctx, &tasks.DeleteTaskRequest{ContainerID: cntr.ID})
+			if err == nil {
+				setUnknownStartedAt(&status)
+				status.FinishedAt = resp.ExitedAt.UnixNano()
+				status.ExitCode = int32(resp.ExitStatus)
+				return status
+			}
+			if !isContainerdGRPCNotFoundError(err) {
+				glog.Errorf("Failed to delete task of exited container %q, retry in background: %v", cntr.ID, err)
+				c.taskReaper.enqueue(cntr.ID, nil)
+			}
+		default:
+			// The task was created, but the start was interrupted.
+			c.cleanupOrphanTask(ctx, cntr.ID, t)
+		}
+	}
+	if checkpoint != nil && (status.StartedAt == 0 || status.FinishedAt != 0) {
+		// The container was never started, or its exit was checkpointed.
+		return status
+	}
+	setUnknownStartedAt(&status)
+	status.FinishedAt = time.Now().UnixNano()
+	status.ExitCode = unknownExitCode
+	status.Reason = unknownExitReason
+	status.Message = "container exit is not observed before cri-containerd restart"
+	return status
+}
+
+// setUnknownStartedAt sets the start time of a container which has run to the
+// creation time, if the start time is not checkpointed.
+func setUnknownStartedAt(status *containerstore.Status) {
+	if status.StartedAt == 0 {
+		status.StartedAt = status.CreatedAt
+	}
+}
+
+// reopenContainerLoggers reopens the stdout and stderr pipes of a running
+// container, and restarts the loggers redirecting them into the container log.
+func (c *criContainerdService) reopenContainerLoggers(ctx context.Context, meta containerstore.Metadata) error {
+	if meta.Config.GetLogPath() == "" {
+		return nil
+	}
+	sandbox, err := c.sandboxStore.Get(meta.SandboxID)
+	if err != nil {
+		return fmt.Errorf("failed to get sandbox %q: %v", meta.SandboxID, err)
+	}
+	_, stdout, stderr := getStreamingPipes(getContainerRootDir(c.rootDir, meta.ID))
+	// Stderr is not redirected when there is tty.
+	if meta.Config.GetTty() {
+		stderr = ""
+	}
+	_, stdoutPipe, stderrPipe, err := c.prepareStreamingPipes(ctx, "", stdout, stderr)
+	if err != nil {
+		return fmt.Errorf("failed to prepare streaming pipes: %v", err)
+	}
+	logPath := filepath.Join(sandbox.Config.GetLogDirectory(), meta.Config.GetLogPath())
+	if err := c.agentFactory.NewContainerLogger(meta.ID, logPath, agents.Stdout, stdoutPipe).Start(); err != nil {
+		stdoutPipe.Close()
+		if stderrPipe != nil {
+			stderrPipe.Close()
+		}
+		return fmt.Errorf("failed to start container stdout logger: %v", err)
+	}
+	if stderrPipe == nil {
+		return nil
+	}
+	if err := c.agentFactory.NewContainerLogger(meta.ID, logPath, agents.Stderr, stderrPipe).Start(); err != nil {
+		stderrPipe.Close()
+		return fmt.Errorf("failed to start container stderr logger: %v", err)
+	}
+	return nil
+}
+
+// cleanupOrphanTask kills and deletes the task of a containerd container which
+// is not tracked by cri-containerd. The deletion is retried by the task reaper
+// if it fails, e.g. because the task hasn't exited yet. Orphan tasks are kept
+// in read-only mode.
+func (c *criContainerdService) cleanupOrphanTask(ctx context.Context, id string, t *task.Task) {
+	if t == nil {
+		return
+	}
+	if c.config.ReadOnly {
+		glog.Warningf("Keep orphan task %q in read-only mode", id)
+		return
+	}
+	if t.Status != task.StatusStopped {
+		if _, err := c.taskService.Kill(ctx, &tasks.KillRequest{
+			ContainerID: id,
+			Signal:      uint32(unix.SIGKILL),
+			All:         true,
+		}); err != nil && !isContainerdGRPCNotFoundError(err) && !isRuncProcessAlreadyFinishedError(err) {
+			glog.Errorf("Failed to kill orphan task %q: %v", id, err)
+		}
+	}
+	if err := c.deleteTask(ctx, id, nil); err != nil {
+		glog.Errorf("Failed to delete orphan task %q, retry in background: %v", id, err)
+	}
+}
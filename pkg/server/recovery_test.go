@@ -0,0 +1,281 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/containers"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// fakeRecoveryTaskService is a fake task service which lists tasks, and
+// records killed and deleted tasks.
+type fakeRecoveryTaskService struct {
+	tasks.TasksClient
+	tasks    map[string]*task.Task
+	exitedAt time.Time
+	killed   []string
+	deleted  []string
+}
+
+func (f *fakeRecoveryTaskService) List(context.Context, *tasks.ListTasksRequest, ...grpc.CallOption) (*tasks.ListTasksResponse, error) {
+	resp := &tasks.ListTasksResponse{}
+	for _, t := range f.tasks {
+		resp.Tasks = append(resp.Tasks, t)
+	}
+	return resp, nil
+}
+
+func (f *fakeRecoveryTaskService) Kill(_ context.Context, r *tasks.KillRequest, _ ...grpc.CallOption) (*empty.Empty, error) {
+	f.killed = append(f.killed, r.ContainerID)
+	return &empty.Empty{}, nil
+}
+
+func (f *fakeRecoveryTaskService) Delete(_ context.Context, r *tasks.DeleteTaskRequest, _ ...grpc.CallOption) (*tasks.DeleteResponse, error) {
+	t, ok := f.tasks[r.ContainerID]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "task %q not found", r.ContainerID)
+	}
+	delete(f.tasks, r.ContainerID)
+	f.deleted = append(f.deleted, r.ContainerID)
+	return &tasks.DeleteResponse{ID: t.ID, Pid: t.Pid, ExitStatus: 1, ExitedAt: f.exitedAt}, nil
+}
+
+func TestRecoverState(t *testing.T) {
+	createdAt := time.Unix(100, 0)
+	exitedAt := time.Unix(200, 0)
+	c, _, containerStore := newTestSandboxImageService()
+	taskService := &fakeRecoveryTaskService{tasks: make(map[string]*task.Task), exitedAt: exitedAt}
+	c.taskService = taskService
+	// Quotas lowered before restart should not affect recovery.
+	c.namespaceQuotas = newNamespaceQuotaTracker(map[string]namespaceQuota{defaultNamespaceQuotaKey: {Sandboxes: 1, Containers: 1}})
+
+	addSandbox := func(id string, tk *task.Task) {
+		labels, err := sandboxMetadataLabels(sandboxstore.Metadata{
+			ID:     id,
+			Name:   id + "-name",
+			Config: &runtime.PodSandboxConfig{Metadata: &runtime.PodSandboxMetadata{Name: id}},
+		})
+		require.NoError(t, err)
+		containerStore.containers[id] = containers.Container{ID: id, Labels: labels, CreatedAt: createdAt}
+		if tk != nil {
+			taskService.tasks[id] = tk
+		}
+	}
+	addContainer := func(id, sandboxID string, tk *task.Task) {
+		labels, err := containerMetadataLabels(containerstore.Metadata{
+			ID:        id,
+			Name:      id + "-name",
+			SandboxID: sandboxID,
+			Config:    &runtime.ContainerConfig{Metadata: &runtime.ContainerMetadata{Name: id}},
+		})
+		require.NoError(t, err)
+		containerStore.containers[id] = containers.Container{ID: id, Labels: labels, CreatedAt: createdAt}
+		if tk != nil {
+			taskService.tasks[id] = tk
+		}
+	}
+	addSandbox("running-sandbox", &task.Task{ID: "running-sandbox", Pid: 1234, Status: task.StatusRunning})
+	addSandbox("stopped-sandbox", nil)
+	addContainer("running-container", "running-sandbox", &task.Task{ID: "running-container", Pid: 5678, Status: task.StatusRunning})
+	addContainer("exited-container", "running-sandbox", &task.Task{ID: "exited-container", Status: task.StatusStopped})
+	addContainer("unknown-container", "stopped-sandbox", nil)
+	addContainer("interrupted-container", "running-sandbox", &task.Task{ID: "interrupted-container", Status: task.StatusCreated})
+	addContainer("orphan-container", "missing-sandbox", &task.Task{ID: "orphan-container", Status: task.StatusRunning})
+	addContainer("conflict-container", "running-sandbox", &task.Task{ID: "conflict-container", Status: task.StatusRunning})
+	require.NoError(t, c.containerNameIndex.Reserve("conflict-container-name", "other"))
+	containerStore.containers["unlabeled"] = containers.Container{ID: "unlabeled"}
+	taskService.tasks["unlabeled"] = &task.Task{ID: "unlabeled", Status: task.StatusRunning}
+
+	require.NoError(t, c.recoverState(context.Background()))
+
+	t.Logf("sandboxes should be recovered with pid of running task")
+	sandbox, err := c.sandboxStore.Get("running-sandbox")
+	require.NoError(t, err)
+	assert.Equal(t, "running-sandbox-name", sandbox.Name)
+	assert.EqualValues(t, 1234, sandbox.Pid)
+	assert.Equal(t, getNetworkNamespace(1234), sandbox.NetNS)
+	assert.Equal(t, createdAt.UnixNano(), sandbox.CreatedAt)
+	sandbox, err = c.sandboxStore.Get("stopped-sandbox")
+	require.NoError(t, err)
+	assert.Zero(t, sandbox.Pid)
+	assert.Empty(t, sandbox.NetNS)
+	assert.Error(t, c.sandboxNameIndex.Reserve("running-sandbox-name", "other"), "sandbox name should be reserved")
+
+	t.Logf("containers should be recovered with status of task")
+	cntr, err := c.containerStore.Get("running-container")
+	require.NoError(t, err)
+	status := cntr.Status.Get()
+	assert.Equal(t, runtime.ContainerState_CONTAINER_RUNNING, status.State())
+	assert.EqualValues(t, 5678, status.Pid)
+	assert.Error(t, c.containerNameIndex.Reserve("running-container-name", "other"), "container name should be reserved")
+
+	cntr, err = c.containerStore.Get("exited-container")
+	require.NoError(t, err)
+	status = cntr.Status.Get()
+	assert.Equal(t, runtime.ContainerState_CONTAINER_EXITED, status.State())
+	assert.EqualValues(t, 1, status.ExitCode)
+	assert.Equal(t, exitedAt.UnixNano(), status.FinishedAt)
+
+	for _, id := range []string{"unknown-container", "interrupted-container"} {
+		cntr, err = c.containerStore.Get(id)
+		require.NoError(t, err)
+		status = cntr.Status.Get()
+		assert.Equal(t, runtime.ContainerState_CONTAINER_EXITED, status.State())
+		assert.EqualValues(t, unknownExitCode, status.ExitCode)
+		assert.Equal(t, unknownExitReason, status.Reason)
+	}
+
+	t.Logf("tasks of containers not recovered should be killed and deleted")
+	_, err = c.containerStore.Get("orphan-container")
+	assert.Error(t, err)
+	assert.Contains(t, taskService.killed, "orphan-container")
+	assert.NotContains(t, taskService.killed, "running-container")
+	assert.NotContains(t, taskService.killed, "conflict-container", "task should not be killed on name conflict")
+	assert.NotContains(t, taskService.deleted, "conflict-container")
+	assert.Contains(t, taskService.deleted, "exited-container")
+	assert.Contains(t, taskService.deleted, "orphan-container")
+	assert.NotContains(t, taskService.killed, "unlabeled", "task of unlabeled container should be left running")
+	assert.NotContains(t, taskService.deleted, "unlabeled")
+	assert.Contains(t, taskService.tasks, "running-sandbox")
+	assert.Contains(t, taskService.tasks, "running-container")
+}
+
+func TestRecoverContainerStatus(t *testing.T) {
+	createdAt := time.Unix(100, 0).UnixNano()
+	startedAt := time.Unix(150, 0).UnixNano()
+	exitedAt := time.Unix(200, 0)
+	for desc, test := range map[string]struct {
+		task       *task.Task
+		checkpoint *containerstore.Status
+		expected   containerstore.Status
+	}{
+		"running container should keep checkpointed start time": {
+			task:       &task.Task{ID: "test-id", Pid: 1234, Status: task.StatusRunning},
+			checkpoint: &containerstore.Status{Pid: 1234, CreatedAt: createdAt, StartedAt: startedAt},
+			expected:   containerstore.Status{Pid: 1234, CreatedAt: createdAt, StartedAt: startedAt},
+		},
+		"running container without checkpoint should use creation time as start time": {
+			task:     &task.Task{ID: "test-id", Pid: 1234, Status: task.StatusRunning},
+			expected: containerstore.Status{Pid: 1234, CreatedAt: createdAt, StartedAt: createdAt},
+		},
+		"exit observed before restart should be kept": {
+			checkpoint: &containerstore.Status{CreatedAt: createdAt, StartedAt: startedAt,
+				FinishedAt: exitedAt.UnixNano(), ExitCode: 137, Reason: "OOMKilled"},
+			expected: containerstore.Status{CreatedAt: createdAt, StartedAt: startedAt,
+				FinishedAt: exitedAt.UnixNano(), ExitCode: 137, Reason: "OOMKilled"},
+		},
+		"exit of stopped task should be recovered with checkpointed start time": {
+			task:       &task.Task{ID: "test-id", Status: task.StatusStopped},
+			checkpoint: &containerstore.Status{Pid: 1234, CreatedAt: createdAt, StartedAt: startedAt},
+			expected: containerstore.Status{Pid: 1234, CreatedAt: createdAt, StartedAt: startedAt,
+				FinishedAt: exitedAt.UnixNano(), ExitCode: 1},
+		},
+		"container never started should stay created": {
+			checkpoint: &containerstore.Status{CreatedAt: createdAt},
+			expected:   containerstore.Status{CreatedAt: createdAt},
+		},
+		"container with interrupted start should stay created": {
+			task:       &task.Task{ID: "test-id", Status: task.StatusCreated},
+			checkpoint: &containerstore.Status{CreatedAt: createdAt},
+			expected:   containerstore.Status{CreatedAt: createdAt},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		taskService := &fakeRecoveryTaskService{tasks: make(map[string]*task.Task), exitedAt: exitedAt}
+		if test.task != nil {
+			taskService.tasks["test-id"] = test.task
+		}
+		c.taskService = taskService
+		cntr := containers.Container{ID: "test-id", CreatedAt: time.Unix(0, createdAt)}
+		status := c.recoverContainerStatus(context.Background(), cntr, test.task, test.checkpoint)
+		assert.Equal(t, test.expected, status)
+	}
+
+	t.Logf("container started without checkpointed exit should be exited with unknown reason")
+	c := newTestCRIContainerdService()
+	c.taskService = &fakeRecoveryTaskService{tasks: make(map[string]*task.Task)}
+	cntr := containers.Container{ID: "test-id", CreatedAt: time.Unix(0, createdAt)}
+	status := c.recoverContainerStatus(context.Background(), cntr, nil,
+		&containerstore.Status{Pid: 1234, CreatedAt: createdAt, StartedAt: startedAt})
+	assert.Equal(t, startedAt, status.StartedAt)
+	assert.Equal(t, runtime.ContainerState_CONTAINER_EXITED, status.State())
+	assert.Equal(t, unknownExitReason, status.Reason)
+}
+
+func TestRecoverContainerStatusCheckpoint(t *testing.T) {
+	c, _, containerStore := newTestSandboxImageService()
+	c.taskService = &fakeRecoveryTaskService{tasks: make(map[string]*task.Task)}
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID:     "test-sandbox-id",
+		Config: &runtime.PodSandboxConfig{},
+	}}))
+	labels, err := containerMetadataLabels(containerstore.Metadata{
+		ID:        "test-id",
+		Name:      "test-name",
+		SandboxID: "test-sandbox-id",
+		Config:    &runtime.ContainerConfig{},
+	})
+	require.NoError(t, err)
+	containerStore.containers["test-id"] = containers.Container{ID: "test-id", Labels: labels, CreatedAt: time.Unix(100, 0)}
+	checkpoint := containerstore.Status{CreatedAt: time.Unix(100, 0).UnixNano()}
+	data, err := checkpoint.Encode()
+	require.NoError(t, err)
+	statusPath := getContainerStatusPath(c.rootDir, "test-id")
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+		if path != statusPath {
+			return nil, os.ErrNotExist
+		}
+		return data, nil
+	}
+
+	require.NoError(t, c.recoverState(context.Background()))
+	cntr, err := c.containerStore.Get("test-id")
+	require.NoError(t, err)
+	assert.Equal(t, runtime.ContainerState_CONTAINER_CREATED, cntr.Status.Get().State())
+
+	t.Logf("status updates after recovery should be checkpointed")
+	require.NoError(t, cntr.Status.Update(func(s containerstore.Status) (containerstore.Status, error) {
+		s.Pid = 1234
+		s.StartedAt = time.Now().UnixNano()
+		return s, nil
+	}))
+	writes := getCallArgs(fakeOS, "AtomicWriteFile")
+	require.NotEmpty(t, writes)
+	last := writes[len(writes)-1]
+	assert.Equal(t, statusPath, last[0])
+	status, err := containerstore.LoadStatus(last[1].([]byte))
+	require.NoError(t, err)
+	assert.Equal(t, cntr.Status.Get(), status)
+}
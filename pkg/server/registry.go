@@ -0,0 +1,277 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/golang/glog"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// registryEndpoint is an endpoint images of a registry are pulled from.
+type registryEndpoint struct {
+	// host is the host of the endpoint, with optional port.
+	host string
+	// plainHTTP means the endpoint is served over plain http.
+	plainHTTP bool
+}
+
+// registryConfig is the configuration of image registries. Registries are
+// identified by the domain of image references, e.g. "docker.io".
+type registryConfig struct {
+	// mirrors are endpoints tried in order before the registry itself.
+	mirrors map[string][]registryEndpoint
+	// endpoints replace the registry, without falling back to it.
+	endpoints map[string]registryEndpoint
+	// insecure are hosts whose tls certificates are not verified.
+	insecure map[string]bool
+	// certsDir contains certificates of hosts in the docker certs.d layout,
+	// i.e. "<certsDir>/<host>/*.crt" are ca certificates, and
+	// "<certsDir>/<host>/<name>.cert" and "<name>.key" are client certificates.
+	certsDir string
+
+	// transportsMu protects transports.
+	transportsMu sync.Mutex
+	// transports are the http transports keyed by host, so that connections
+	// to the host are reused across pulls.
+	transports map[string]http.RoundTripper
+}
+
+// registryIdleConnTimeout is how long an idle connection to a registry is kept
+// for reuse.
+const registryIdleConnTimeout = 90 * time.Second
+
+// parseRegistryEndpoint parses an endpoint in the form of "[scheme://]host[:port]".
+// Endpoints are served over https unless the scheme is http.
+func parseRegistryEndpoint(endpoint string) (registryEndpoint, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return registryEndpoint{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return registryEndpoint{}, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return registryEndpoint{}, fmt.Errorf("should be [scheme://]host[:port]")
+	}
+	return registryEndpoint{host: u.Host, plainHTTP: u.Scheme == "http"}, nil
+}
+
+// parseRegistryHostEndpoints parses "host=endpoint" pairs.
+func parseRegistryHostEndpoints(pairs []string) (map[string][]registryEndpoint, error) {
+	endpoints := make(map[string][]registryEndpoint)
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid registry endpoint %q, should be host=endpoint", pair)
+		}
+		e, err := parseRegistryEndpoint(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid registry endpoint %q: %v", pair, err)
+		}
+		endpoints[parts[0]] = append(endpoints[parts[0]], e)
+	}
+	return endpoints, nil
+}
+
+// newRegistryConfig creates the registry config from "host=endpoint" mirrors and
+// endpoint overrides, insecure hosts and the certificate directory.
+func newRegistryConfig(mirrors, endpoints, insecure []string, certsDir string) (*registryConfig, error) {
+	r := &registryConfig{
+		endpoints: make(map[string]registryEndpoint),
+		insecure:  make(map[string]bool),
+		certsDir:  certsDir,
+	}
+	var err error
+	if r.mirrors, err = parseRegistryHostEndpoints(mirrors); err != nil {
+		return nil, err
+	}
+	overrides, err := parseRegistryHostEndpoints(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	for host, e := range overrides {
+		if len(e) > 1 {
+			return nil, fmt.Errorf("multiple registry endpoints for %q", host)
+		}
+		r.endpoints[host] = e[0]
+	}
+	for _, host := range insecure {
+		r.insecure[host] = true
+	}
+	return r, nil
+}
+
+// hostEndpoints returns the endpoints to pull images of the registry from, in
+// the order they should be tried.
+func (r *registryConfig) hostEndpoints(host string) []registryEndpoint {
+	if e, ok := r.endpoints[host]; ok {
+		return []registryEndpoint{e}
+	}
+	return append(append([]registryEndpoint{}, r.mirrors[host]...), registryEndpoint{host: host})
+}
+
+// transport returns the http transport to the endpoint host. The default
+// transport is returned if the host has no tls customization. The transport
+// is created once per host, so certificates added to the certs dir later are
+// only loaded after restart.
+func (r *registryConfig) transport(host string) (http.RoundTripper, error) {
+	r.transportsMu.Lock()
+	defer r.transportsMu.Unlock()
+	if t, ok := r.transports[host]; ok {
+		return t, nil
+	}
+	t, err := r.newTransport(host)
+	if err != nil {
+		return nil, err
+	}
+	if r.transports == nil {
+		r.transports = make(map[string]http.RoundTripper)
+	}
+	r.transports[host] = t
+	return t, nil
+}
+
+// newTransport creates the http transport to the endpoint host.
+func (r *registryConfig) newTransport(host string) (http.RoundTripper, error) {
+	var config *tls.Config
+	if r.insecure[host] {
+		config = &tls.Config{InsecureSkipVerify: true}
+	}
+	if r.certsDir != "" {
+		dir := filepath.Join(r.certsDir, host)
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read certs dir %q: %v", dir, err)
+		}
+		for _, fi := range fis {
+			if config == nil {
+				config = &tls.Config{}
+			}
+			if err := loadRegistryCert(config, dir, fi.Name()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if config == nil {
+		return http.DefaultTransport, nil
+	}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		TLSClientConfig:     config,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     registryIdleConnTimeout,
+	}, nil
+}
+
+// loadRegistryCert loads the certificate file in the certs dir into the tls
+// config. Files other than certificates are ignored.
+func loadRegistryCert(config *tls.Config, dir, name string) error {
+	path := filepath.Join(dir, name)
+	switch filepath.Ext(name) {
+	case ".crt":
+		if config.RootCAs == nil {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				return fmt.Errorf("failed to load system cert pool: %v", err)
+			}
+			config.RootCAs = pool
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read ca cert %q: %v", path, err)
+		}
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no valid ca cert in %q", path)
+		}
+	case ".cert":
+		keyPath := strings.TrimSuffix(path, ".cert") + ".key"
+		cert, err := tls.LoadX509KeyPair(path, keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to load client cert %q with key %q: %v", path, keyPath, err)
+		}
+		config.Certificates = append(config.Certificates, cert)
+	case ".key":
+		if _, err := os.Stat(strings.TrimSuffix(path, ".key") + ".cert"); err != nil {
+			return fmt.Errorf("missing client cert for key %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// replaceImageDomain returns the image reference with the domain replaced by host.
+func replaceImageDomain(named reference.Named, host string) string {
+	if reference.Domain(named) == host {
+		return named.String()
+	}
+	ref := host + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref += "@" + digested.Digest().String()
+	}
+	return ref
+}
+
+// resolveImage resolves the image reference from the registry endpoints in order,
+// and returns the resolver and the reference of the first endpoint which succeeds.
+func (c *criContainerdService) resolveImage(ctx context.Context, named reference.Named, auth *runtime.AuthConfig) (
+	remotes.Resolver, string, imagespec.Descriptor, error) {
+	var lastErr error
+	for _, e := range c.registryConfig.hostEndpoints(reference.Domain(named)) {
+		ref := replaceImageDomain(named, e.host)
+		transport, err := c.registryConfig.transport(e.host)
+		if err != nil {
+			return nil, "", imagespec.Descriptor{}, err
+		}
+		resolver := docker.NewResolver(docker.ResolverOptions{
+			Credentials: registryCredentials(auth),
+			PlainHTTP:   e.plainHTTP,
+			Client:      registryClient(auth, registryHost(e.host), transport),
+		})
+		_, desc, err := resolver.Resolve(ctx, ref)
+		if err == nil {
+			return resolver, ref, desc, nil
+		}
+		lastErr = resolveError(ref, err)
+		if ctx.Err() != nil {
+			break
+		}
+		glog.Warningf("Failed to resolve image %q from endpoint %q: %v", named, e.host, err)
+	}
+	return nil, "", imagespec.Descriptor{}, lastErr
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseRegistryEndpoint(t *testing.T) {
+	for desc, test := range map[string]struct {
+		endpoint  string
+		expected  registryEndpoint
+		expectErr bool
+	}{
+		"host without scheme should use https": {
+			endpoint: "mirror.example.com:5000",
+			expected: registryEndpoint{host: "mirror.example.com:5000"},
+		},
+		"https endpoint": {
+			endpoint: "https://mirror.example.com/",
+			expected: registryEndpoint{host: "mirror.example.com"},
+		},
+		"http endpoint should use plain http": {
+			endpoint: "http://mirror.example.com",
+			expected: registryEndpoint{host: "mirror.example.com", plainHTTP: true},
+		},
+		"unsupported scheme": {
+			endpoint:  "ftp://mirror.example.com",
+			expectErr: true,
+		},
+		"endpoint with path": {
+			endpoint:  "https://mirror.example.com/v2",
+			expectErr: true,
+		},
+		"empty endpoint": {
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		e, err := parseRegistryEndpoint(test.endpoint)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, e)
+	}
+}
+
+func TestRegistryHostEndpoints(t *testing.T) {
+	r, err := newRegistryConfig(
+		[]string{"docker.io=mirror-1.example.com", "docker.io=http://mirror-2.example.com"},
+		[]string{"gcr.io=gcr.example.com"},
+		nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, []registryEndpoint{
+		{host: "mirror-1.example.com"},
+		{host: "mirror-2.example.com", plainHTTP: true},
+		{host: "docker.io"},
+	}, r.hostEndpoints("docker.io"))
+	assert.Equal(t, []registryEndpoint{{host: "gcr.example.com"}}, r.hostEndpoints("gcr.io"))
+	assert.Equal(t, []registryEndpoint{{host: "quay.io"}}, r.hostEndpoints("quay.io"))
+
+	_, err = newRegistryConfig(nil, []string{"gcr.io=a.example.com", "gcr.io=b.example.com"}, nil, "")
+	assert.Error(t, err, "multiple endpoints of a host should be rejected")
+	_, err = newRegistryConfig([]string{"docker.io"}, nil, nil, "")
+	assert.Error(t, err, "mirror without endpoint should be rejected")
+}
+
+func TestReplaceImageDomain(t *testing.T) {
+	for desc, test := range map[string]struct {
+		ref      string
+		host     string
+		expected string
+	}{
+		"same host": {
+			ref:      "gcr.io/library/busybox:latest",
+			host:     "gcr.io",
+			expected: "gcr.io/library/busybox:latest",
+		},
+		"tagged reference": {
+			ref:      "busybox",
+			host:     "mirror.example.com:5000",
+			expected: "mirror.example.com:5000/library/busybox:latest",
+		},
+		"digested reference": {
+			ref:      "gcr.io/library/busybox@sha256:e6693c20186f837fc393390135d8a598a96a833917917789d63766cab6c59582",
+			host:     "mirror.example.com",
+			expected: "mirror.example.com/library/busybox@sha256:e6693c20186f837fc393390135d8a598a96a833917917789d63766cab6c59582",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		named, err := normalizeImageRef(test.ref)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, replaceImageDomain(named, test.host))
+	}
+}
+
+func TestRegistryTransport(t *testing.T) {
+	certsDir, err := ioutil.TempDir("", "registry-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(certsDir)
+	require.NoError(t, os.MkdirAll(filepath.Join(certsDir, "invalid.example.com"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(certsDir, "invalid.example.com", "ca.crt"), []byte("invalid"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(certsDir, "nocert.example.com"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(certsDir, "nocert.example.com", "client.key"), []byte("key"), 0600))
+
+	r, err := newRegistryConfig(nil, nil, []string{"insecure.example.com"}, certsDir)
+	require.NoError(t, err)
+
+	transport, err := r.transport("gcr.io")
+	assert.NoError(t, err)
+	assert.Equal(t, http.DefaultTransport, transport, "host without tls customization should use default transport")
+
+	transport, err = r.transport("insecure.example.com")
+	assert.NoError(t, err)
+	require.IsType(t, &http.Transport{}, transport)
+	assert.True(t, transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, registryIdleConnTimeout, transport.(*http.Transport).IdleConnTimeout)
+	cached, err := r.transport("insecure.example.com")
+	assert.NoError(t, err)
+	assert.True(t, transport == cached, "transport of the host should be reused")
+
+	_, err = r.transport("invalid.example.com")
+	assert.Error(t, err, "invalid ca cert should fail")
+	_, err = r.transport("nocert.example.com")
+	assert.Error(t, err, "client key without cert should fail")
+}
+
+func TestResolveImageFromMirrors(t *testing.T) {
+	const digest = "sha256:e6693c20186f837fc393390135d8a598a96a833917917789d63766cab6c59582"
+	mirror := httptest.NewServer(http.NotFoundHandler())
+	defer mirror.Close()
+	var requested string
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Header().Set("Content-Length", "100")
+	}))
+	defer registry.Close()
+	registryHost := strings.TrimPrefix(registry.URL, "https://")
+
+	c := newTestCRIContainerdService()
+	var err error
+	c.registryConfig, err = newRegistryConfig([]string{registryHost + "=" + mirror.URL}, nil,
+		[]string{registryHost}, "")
+	require.NoError(t, err)
+	named, err := normalizeImageRef(registryHost + "/foo/bar:v1")
+	require.NoError(t, err)
+
+	_, ref, desc, err := c.resolveImage(context.Background(), named, nil)
+	require.NoError(t, err)
+	assert.Equal(t, registryHost+"/foo/bar:v1", ref, "should fall back to the registry")
+	assert.Equal(t, "/v2/foo/bar/manifests/v1", requested)
+	assert.Equal(t, digest, desc.Digest.String())
+	assert.EqualValues(t, 100, desc.Size)
+
+	// Without the registry being insecure, its certificate is not trusted.
+	c.registryConfig, err = newRegistryConfig([]string{registryHost + "=" + mirror.URL}, nil, nil, "")
+	require.NoError(t, err)
+	_, _, _, err = c.resolveImage(context.Background(), named, nil)
+	assert.Error(t, err)
+}
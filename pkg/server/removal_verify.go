@@ -0,0 +1,135 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// leftoverCgroup is a cgroup directory of a removed sandbox or container.
+	leftoverCgroup = "cgroup"
+	// leftoverNetNS is a network namespace mount of a removed sandbox.
+	leftoverNetNS = "netns"
+	// leftoverShimDir is a shim state or work directory of a removed sandbox
+	// or container, which the shim socket and pid file live in.
+	leftoverShimDir = "shim-dir"
+)
+
+// leftover is a resource on the node which should be gone after its sandbox
+// or container is removed.
+type leftover struct {
+	Type string
+	Path string
+}
+
+// removedResources are the node resources of a removed sandbox or container.
+type removedResources struct {
+	// ID is the id of the sandbox or container.
+	ID string
+	// CgroupsPath is the cgroups path relative to the root of each hierarchy,
+	// empty if the cgroups are managed by the runtime.
+	CgroupsPath string
+	// NetNS is the network namespace path of a sandbox.
+	NetNS string
+}
+
+// verifyRemoval checks that the node resources of a removed sandbox or container
+// are actually gone. Leftovers are cleaned up again, and those still there are
+// logged and exported as leaked resources.
+func (c *criContainerdService) verifyRemoval(r removedResources) {
+	leftovers := c.findLeftovers(r)
+	if len(leftovers) == 0 {
+		return
+	}
+	glog.Warningf("Found leftover resources of removed sandbox or container %q, clean them up again: %+v", r.ID, leftovers)
+	c.metrics.removalRetries.Add(uint64(len(leftovers)))
+	for _, l := range leftovers {
+		if err := c.cleanupLeftover(l); err != nil {
+			glog.Errorf("Failed to clean up leftover %s %q of %q: %v", l.Type, l.Path, r.ID, err)
+		}
+	}
+	leftovers = c.findLeftovers(r)
+	if len(leftovers) == 0 {
+		return
+	}
+	glog.Errorf("Leaked resources of removed sandbox or container %q: %+v", r.ID, leftovers)
+	c.metrics.leakedResources.Add(uint64(len(leftovers)))
+}
+
+// findLeftovers returns the node resources of the removed sandbox or container
+// which still exist.
+func (c *criContainerdService) findLeftovers(r removedResources) []leftover {
+	var leftovers []leftover
+	if r.CgroupsPath != "" {
+		// Each cgroup v1 hierarchy is a directory under the cgroup root, and
+		// symlinks of co-mounted controllers are skipped.
+		hierarchies, err := c.os.ReadDir(cgroupRoot)
+		if err != nil {
+			glog.V(4).Infof("Failed to read cgroup hierarchies under %q: %v", cgroupRoot, err)
+		}
+		for _, h := range hierarchies {
+			if !h.IsDir() {
+				continue
+			}
+			path := filepath.Join(cgroupRoot, h.Name(), r.CgroupsPath)
+			if c.exists(path) {
+				leftovers = append(leftovers, leftover{Type: leftoverCgroup, Path: path})
+			}
+		}
+	}
+	// Network namespaces under /proc are released with the sandbox process,
+	// only pinned namespace mounts could be left.
+	if isPersistentNetNS(r.NetNS) && c.exists(r.NetNS) {
+		leftovers = append(leftovers, leftover{Type: leftoverNetNS, Path: r.NetNS})
+	}
+	// Shim directories of tasks pending deletion are cleaned up by the task
+	// reaper.
+	if !c.taskReaper.has(r.ID) {
+		for _, dir := range c.getShimDirs(r.ID) {
+			if c.exists(dir) {
+				leftovers = append(leftovers, leftover{Type: leftoverShimDir, Path: dir})
+			}
+		}
+	}
+	return leftovers
+}
+
+// exists returns whether the path exists. Paths which can't be checked are
+// considered gone, so that they are not cleaned up blindly.
+func (c *criContainerdService) exists(path string) bool {
+	_, err := c.os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		glog.V(4).Infof("Failed to stat %q: %v", path, err)
+	}
+	return err == nil
+}
+
+// cleanupLeftover cleans up a leftover resource. A cgroup directory is only
+// removed if it has no process and child cgroup left.
+func (c *criContainerdService) cleanupLeftover(l leftover) error {
+	switch l.Type {
+	case leftoverNetNS:
+		return c.removeSandboxNetNS(l.Path)
+	case leftoverShimDir:
+		return c.removeShimDir(l.Path)
+	}
+	return c.os.RemoveAll(l.Path)
+}
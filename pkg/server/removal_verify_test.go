@@ -0,0 +1,134 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+// fakeFileInfo is a fake directory entry.
+type fakeFileInfo struct {
+	name string
+	mode os.FileMode
+	sys  interface{}
+}
+
+func (f fakeFileInfo) Name() string       { return f.name }
+func (f fakeFileInfo) Size() int64        { return 0 }
+func (f fakeFileInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (f fakeFileInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeFileInfo) Sys() interface{}   { return f.sys }
+
+func TestVerifyRemoval(t *testing.T) {
+	const (
+		memoryCgroup = "/sys/fs/cgroup/memory/kubepods/test-id"
+		stateDir     = "/run/containerd/runtime/k8s.io/test-id"
+		workDir      = "/var/lib/containerd/runtime/k8s.io/test-id"
+	)
+	for desc, test := range map[string]struct {
+		netNS           string
+		existing        []string
+		undeletable     []string
+		pendingTask     bool
+		expectedRetries float64
+		expectedLeaks   float64
+		expectedUnmount []string
+	}{
+		"no leftovers": {},
+		"leftovers are cleaned up": {
+			existing:        []string{memoryCgroup, stateDir, workDir},
+			expectedRetries: 3,
+			expectedUnmount: []string{stateDir + "/rootfs", workDir + "/rootfs"},
+		},
+		"cgroup still in use is leaked": {
+			existing:        []string{memoryCgroup},
+			undeletable:     []string{memoryCgroup},
+			expectedRetries: 1,
+			expectedLeaks:   1,
+		},
+		"netns under /proc is not checked": {
+			netNS:    "/proc/1234/ns/net",
+			existing: []string{"/proc/1234/ns/net"},
+		},
+		"pinned netns is unmounted": {
+			netNS:           "/var/run/netns/test-netns",
+			existing:        []string{"/var/run/netns/test-netns"},
+			expectedRetries: 1,
+			expectedUnmount: []string{"/var/run/netns/test-netns"},
+		},
+		"shim directories of task pending deletion are left to the task reaper": {
+			existing:    []string{stateDir, workDir},
+			pendingTask: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.ContainerdRuntimeStateDir = "/run/containerd/runtime"
+		c.config.ContainerdRuntimeRootDir = "/var/lib/containerd/runtime"
+		if test.pendingTask {
+			c.taskReaper.enqueue("test-id", nil)
+		}
+		existing := make(map[string]bool)
+		for _, p := range test.existing {
+			existing[p] = true
+		}
+		undeletable := make(map[string]bool)
+		for _, p := range test.undeletable {
+			undeletable[p] = true
+		}
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.ReadDirFn = func(dir string) ([]os.FileInfo, error) {
+			if dir != cgroupRoot {
+				return nil, os.ErrNotExist
+			}
+			return []os.FileInfo{
+				fakeFileInfo{name: "memory", mode: os.ModeDir},
+				fakeFileInfo{name: "cpu", mode: os.ModeSymlink},
+			}, nil
+		}
+		fakeOS.StatFn = func(path string) (os.FileInfo, error) {
+			if !existing[path] {
+				return nil, os.ErrNotExist
+			}
+			return fakeFileInfo{name: path, mode: os.ModeDir}, nil
+		}
+		fakeOS.RemoveAllFn = func(path string) error {
+			if undeletable[path] {
+				return errors.New("device or resource busy")
+			}
+			delete(existing, path)
+			return nil
+		}
+
+		c.verifyRemoval(removedResources{ID: "test-id", CgroupsPath: "kubepods/test-id", NetNS: test.netNS})
+		assert.Equal(t, test.expectedRetries, c.metrics.removalRetries.Value())
+		assert.Equal(t, test.expectedLeaks, c.metrics.leakedResources.Value())
+		var unmounted []string
+		for _, args := range getCallArgs(fakeOS, "Unmount") {
+			unmounted = append(unmounted, args[0].(string))
+		}
+		assert.Equal(t, test.expectedUnmount, unmounted)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/rpcrecord"
+)
+
+// rpcLogger logs grpc request and response bodies. It is disabled by default, and
+// could be enabled for a limited duration with a sample rate, so that production
+// issues could be diagnosed without restarting cri-containerd or flooding the log.
+type rpcLogger struct {
+	sync.Mutex
+	// until is the time until which rpc logging is enabled.
+	until time.Time
+	// sampleRate is the fraction of requests to log, in [0, 1].
+	sampleRate float64
+}
+
+// rpcLogStatus is the status of rpc logging.
+type rpcLogStatus struct {
+	// Until is the time until which rpc logging is enabled.
+	Until time.Time `json:"until"`
+	// SampleRate is the fraction of requests to log.
+	SampleRate float64 `json:"sampleRate"`
+}
+
+// enable enables rpc logging for the duration with the sample rate.
+func (l *rpcLogger) enable(duration time.Duration, sampleRate float64) rpcLogStatus {
+	l.Lock()
+	defer l.Unlock()
+	l.until = time.Now().Add(duration)
+	l.sampleRate = sampleRate
+	return rpcLogStatus{Until: l.until, SampleRate: l.sampleRate}
+}
+
+// sample returns whether the current request should be logged.
+func (l *rpcLogger) sample() bool {
+	l.Lock()
+	defer l.Unlock()
+	if time.Now().After(l.until) {
+		return false
+	}
+	return rand.Float64() < l.sampleRate
+}
+
+// intercept is a grpc unary interceptor which logs sampled requests and responses.
+// Credentials and environment variable values are redacted.
+func (l *rpcLogger) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if !l.sample() {
+		return handler(ctx, req)
+	}
+	start := time.Now()
+	glog.Infof("RPC %s request: %+v", info.FullMethod, rpcrecord.Sanitize(req))
+	resp, err := handler(ctx, req)
+	if err != nil {
+		glog.Infof("RPC %s failed after %v: %v", info.FullMethod, time.Since(start), err)
+	} else {
+		glog.Infof("RPC %s returned after %v: %+v", info.FullMethod, time.Since(start), rpcrecord.Sanitize(resp))
+	}
+	return resp, err
+}
+
+// handleRPCLog handles the rpc-log debug endpoint. Rpc logging is enabled for the
+// "duration" query parameter with the "sample-rate" query parameter. A zero duration
+// disables rpc logging.
+func (c *criContainerdService) handleRPCLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	duration, err := time.ParseDuration(query.Get("duration"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid duration %q: %v", query.Get("duration"), err), http.StatusBadRequest)
+		return
+	}
+	sampleRate := 1.0
+	if s := query.Get("sample-rate"); s != "" {
+		sampleRate, err = strconv.ParseFloat(s, 64)
+		if err != nil || sampleRate < 0 || sampleRate > 1 {
+			http.Error(w, fmt.Sprintf("invalid sample rate %q, should be in [0, 1]", s), http.StatusBadRequest)
+			return
+		}
+	}
+	glog.Infof("Enable rpc logging for %v with sample rate %v", duration, sampleRate)
+	writeJSON(w, c.rpcLogger.enable(duration, sampleRate))
+}
+
+// logLevel is the glog verbosity level.
+type logLevel struct {
+	// Verbosity is the current glog verbosity level.
+	Verbosity string `json:"verbosity"`
+}
+
+// handleLogLevel handles the log-level debug endpoint. It returns the current glog
+// verbosity level, and changes it to the "v" query parameter on POST.
+func (c *criContainerdService) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	v := flag.Lookup("v")
+	if v == nil {
+		http.Error(w, "glog verbosity flag is not registered", http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		level := r.URL.Query().Get("v")
+		if _, err := strconv.ParseInt(level, 10, 32); err != nil {
+			http.Error(w, fmt.Sprintf("invalid verbosity %q: %v", level, err), http.StatusBadRequest)
+			return
+		}
+		if err := v.Value.Set(level); err != nil {
+			http.Error(w, fmt.Sprintf("failed to set verbosity to %q: %v", level, err), http.StatusInternalServerError)
+			return
+		}
+		glog.Infof("Set log verbosity to %s", level)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, logLevel{Verbosity: v.Value.String()})
+}
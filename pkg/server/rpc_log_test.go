@@ -0,0 +1,136 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func TestRPCLoggerIntercept(t *testing.T) {
+	for desc, test := range map[string]struct {
+		duration     time.Duration
+		sampleRate   float64
+		expectSample bool
+	}{
+		"should not log when disabled": {},
+		"should log when enabled": {
+			duration:     time.Minute,
+			sampleRate:   1,
+			expectSample: true,
+		},
+		"should not log when sample rate is 0": {
+			duration: time.Minute,
+		},
+		"should not log when expired": {
+			duration:   -time.Minute,
+			sampleRate: 1,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		l := &rpcLogger{}
+		l.enable(test.duration, test.sampleRate)
+		assert.Equal(t, test.expectSample, l.sample())
+		called := false
+		resp, err := l.intercept(context.Background(), "request", &grpc.UnaryServerInfo{FullMethod: "test"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return "response", nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, "response", resp)
+		assert.True(t, called)
+	}
+}
+
+func TestRPCLoggerInterceptKeepsCredentials(t *testing.T) {
+	l := &rpcLogger{}
+	l.enable(time.Minute, 1)
+	req := &runtime.PullImageRequest{Auth: &runtime.AuthConfig{Username: "user", Password: "password"}}
+	_, err := l.intercept(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "test"},
+		func(ctx context.Context, r interface{}) (interface{}, error) {
+			assert.Equal(t, "password", r.(*runtime.PullImageRequest).Auth.Password,
+				"credentials should only be redacted in the log")
+			return &runtime.PullImageResponse{}, nil
+		})
+	assert.NoError(t, err)
+}
+
+func TestHandleRPCLog(t *testing.T) {
+	for desc, test := range map[string]struct {
+		query      string
+		expectCode int
+	}{
+		"should enable rpc logging": {
+			query:      "?duration=1m&sample-rate=0.5",
+			expectCode: http.StatusOK,
+		},
+		"should reject missing duration": {
+			expectCode: http.StatusBadRequest,
+		},
+		"should reject invalid sample rate": {
+			query:      "?duration=1m&sample-rate=2",
+			expectCode: http.StatusBadRequest,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		w := httptest.NewRecorder()
+		c.DebugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc-log"+test.query, nil))
+		assert.Equal(t, test.expectCode, w.Code)
+	}
+}
+
+func TestHandleLogLevel(t *testing.T) {
+	c := newTestCRIContainerdService()
+	for desc, test := range map[string]struct {
+		method     string
+		query      string
+		expectCode int
+	}{
+		"should return current verbosity": {
+			method:     http.MethodGet,
+			expectCode: http.StatusOK,
+		},
+		"should set verbosity": {
+			method:     http.MethodPost,
+			query:      "?v=0",
+			expectCode: http.StatusOK,
+		},
+		"should reject invalid verbosity": {
+			method:     http.MethodPost,
+			query:      "?v=invalid",
+			expectCode: http.StatusBadRequest,
+		},
+		"should reject other methods": {
+			method:     http.MethodDelete,
+			expectCode: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		w := httptest.NewRecorder()
+		c.DebugHandler().ServeHTTP(w, httptest.NewRequest(test.method, "/log-level"+test.query, nil))
+		assert.Equal(t, test.expectCode, w.Code)
+	}
+}
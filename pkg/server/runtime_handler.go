@@ -0,0 +1,283 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/linux/runcopts"
+	"github.com/containerd/containerd/typeurl"
+	prototypes "github.com/gogo/protobuf/types"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// runtimeHandlerAnnotationKey is the sandbox annotation key to select the
+// runtime handler of the pod. The default handler is used if it is not set.
+const runtimeHandlerAnnotationKey = "io.kubernetes.cri-containerd.runtime-handler"
+
+// runtimeHandler is a set of low level runc options passed through containerd
+// runtime options.
+type runtimeHandler struct {
+	// NoPivotRoot uses MS_MOVE instead of pivot_root, which doesn't work on
+	// ramdisk rooted systems.
+	NoPivotRoot bool `json:"noPivotRoot,omitempty"`
+	// NoNewKeyring doesn't create a new session keyring for the container.
+	NoNewKeyring bool `json:"noNewKeyring,omitempty"`
+	// ShimCgroup is the cgroup the containerd shim is placed in.
+	ShimCgroup string `json:"shimCgroup,omitempty"`
+	// CriuPath is the path of the criu binary used by runc.
+	CriuPath string `json:"criuPath,omitempty"`
+	// Mounts are bind mounts added to every container of the handler, e.g.
+	// host certificates. Mounts in the container config override them.
+	Mounts []runtimeHandlerMount `json:"mounts,omitempty"`
+	// PrivilegedWithoutHostDevices doesn't expose host devices to privileged
+	// containers of the handler. The global option is used if it is not set.
+	PrivilegedWithoutHostDevices *bool `json:"privilegedWithoutHostDevices,omitempty"`
+}
+
+// runtimeHandlerMount is a bind mount from the host into containers.
+type runtimeHandlerMount struct {
+	// HostPath is the absolute path of the mount source on the host.
+	HostPath string `json:"hostPath"`
+	// ContainerPath is the absolute path of the mount destination in the
+	// container.
+	ContainerPath string `json:"containerPath"`
+	// Readonly mounts the path read-only.
+	Readonly bool `json:"readonly,omitempty"`
+}
+
+// runtimeHandlersConfig is the config of runtime handlers.
+type runtimeHandlersConfig struct {
+	// Default is the handler used by pods without the runtime handler
+	// annotation. Empty means containerd runtime defaults.
+	Default string `json:"default,omitempty"`
+	// Handlers are runtime handlers keyed by name.
+	Handlers map[string]*runtimeHandler `json:"handlers"`
+}
+
+// loadRuntimeHandlers loads the runtime handlers from the json file. It returns
+// nil if the file is not specified.
+func loadRuntimeHandlers(path string) (*runtimeHandlersConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var config runtimeHandlersConfig
+	if err := readJSONFile(path, &config); err != nil {
+		return nil, fmt.Errorf("failed to load runtime handlers %q: %v", path, err)
+	}
+	for name, h := range config.Handlers {
+		if h == nil {
+			return nil, fmt.Errorf("invalid runtime handlers %q: handler %q is empty", path, name)
+		}
+		if h.CriuPath != "" && !filepath.IsAbs(h.CriuPath) {
+			return nil, fmt.Errorf("invalid runtime handlers %q: criu path %q of handler %q is not absolute",
+				path, h.CriuPath, name)
+		}
+		for _, m := range h.Mounts {
+			if !filepath.IsAbs(m.HostPath) || !filepath.IsAbs(m.ContainerPath) {
+				return nil, fmt.Errorf("invalid runtime handlers %q: mount %q:%q of handler %q is not absolute",
+					path, m.HostPath, m.ContainerPath, name)
+			}
+		}
+	}
+	if _, ok := config.Handlers[config.Default]; config.Default != "" && !ok {
+		return nil, fmt.Errorf("invalid runtime handlers %q: default handler %q not found", path, config.Default)
+	}
+	return &config, nil
+}
+
+// get returns the runtime handler with the name, or the default handler if the
+// name is empty. nil means containerd runtime defaults.
+func (r *runtimeHandlersConfig) get(name string) (*runtimeHandler, error) {
+	if name == "" {
+		if r == nil || r.Default == "" {
+			return nil, nil
+		}
+		name = r.Default
+	}
+	if r == nil {
+		return nil, fmt.Errorf("runtime handler %q not found: no runtime handlers configured", name)
+	}
+	h, ok := r.Handlers[name]
+	if !ok {
+		return nil, fmt.Errorf("runtime handler %q not found", name)
+	}
+	return h, nil
+}
+
+// getRuntimeHandler returns the runtime handler selected by the sandbox
+// annotations.
+func (c *criContainerdService) getRuntimeHandler(annotations map[string]string) (*runtimeHandler, error) {
+	return c.runtimeHandlers.get(annotations[runtimeHandlerAnnotationKey])
+}
+
+// runtimeInfo returns the containerd runtime info of containers using the
+// handler.
+func (h *runtimeHandler) runtimeInfo() (containers.RuntimeInfo, error) {
+	info := containers.RuntimeInfo{Name: defaultRuntime}
+	if h == nil || h.CriuPath == "" {
+		return info, nil
+	}
+	options, err := typeurl.MarshalAny(&runcopts.RuncOptions{CriuPath: h.CriuPath})
+	if err != nil {
+		return containers.RuntimeInfo{}, fmt.Errorf("failed to marshal runc options: %v", err)
+	}
+	info.Options = options
+	return info, nil
+}
+
+// taskOptions returns the containerd task create options of containers using
+// the handler.
+func (h *runtimeHandler) taskOptions() (*prototypes.Any, error) {
+	if h == nil || (!h.NoPivotRoot && !h.NoNewKeyring && h.ShimCgroup == "") {
+		return nil, nil
+	}
+	options, err := typeurl.MarshalAny(&runcopts.CreateOptions{
+		NoPivotRoot:  h.NoPivotRoot,
+		NoNewKeyring: h.NoNewKeyring,
+		ShimCgroup:   h.ShimCgroup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal runc create options: %v", err)
+	}
+	return options, nil
+}
+
+// containerMounts returns the extra mounts of containers using the handler.
+func (h *runtimeHandler) containerMounts() []*runtime.Mount {
+	if h == nil {
+		return nil
+	}
+	var mounts []*runtime.Mount
+	for _, m := range h.Mounts {
+		mounts = append(mounts, &runtime.Mount{
+			ContainerPath: m.ContainerPath,
+			HostPath:      m.HostPath,
+			Readonly:      m.Readonly,
+		})
+	}
+	return mounts
+}
+
+// privilegedWithoutHostDevices returns whether privileged containers using the
+// handler get host devices, or the default value if the handler doesn't set it.
+func (h *runtimeHandler) privilegedWithoutHostDevices(defaultValue bool) bool {
+	if h == nil || h.PrivilegedWithoutHostDevices == nil {
+		return defaultValue
+	}
+	return *h.PrivilegedWithoutHostDevices
+}
+
+// runtimeHandlerCapabilities are what pods using a runtime handler can do on
+// this node, for schedulers and admission controllers to validate runtime
+// class usage against. They are derived from the handler config.
+type runtimeHandlerCapabilities struct {
+	// Name is the name of the handler, empty means containerd runtime
+	// defaults.
+	Name string `json:"name"`
+	// Default is whether the handler is used by pods without the runtime
+	// handler annotation.
+	Default bool `json:"default"`
+	// Runtime is the containerd runtime of containers using the handler.
+	Runtime string `json:"runtime"`
+	// PrivilegedHostDevices is whether privileged containers get all host
+	// devices.
+	PrivilegedHostDevices bool `json:"privilegedHostDevices"`
+	// PivotRoot is whether container rootfs is set up with pivot_root, which
+	// doesn't work on ramdisk rooted systems.
+	PivotRoot bool `json:"pivotRoot"`
+	// SessionKeyring is whether containers get a new session keyring.
+	SessionKeyring bool `json:"sessionKeyring"`
+	// Checkpoint is whether containers can be checkpointed. It is always
+	// false, because container checkpoint is not supported yet.
+	Checkpoint bool `json:"checkpoint"`
+}
+
+// nodeRuntimeCapabilities are what pods can do on this node regardless of
+// their runtime handler.
+type nodeRuntimeCapabilities struct {
+	// HostNetwork is whether pods can use the host network namespace.
+	HostNetwork bool `json:"hostNetwork"`
+	// Privileged is whether sandboxes and containers can be privileged.
+	Privileged bool `json:"privileged"`
+	// UserNamespaces is whether containers can create user namespaces.
+	UserNamespaces bool `json:"userNamespaces"`
+}
+
+// runtimeCapabilities are the node and runtime handler capabilities.
+type runtimeCapabilities struct {
+	// Node is the capabilities shared by all runtime handlers.
+	Node nodeRuntimeCapabilities `json:"node"`
+	// Handlers is the capabilities of each runtime handler.
+	Handlers []runtimeHandlerCapabilities `json:"handlers"`
+}
+
+// getRuntimeCapabilities returns the node capabilities and the capabilities
+// of all runtime handlers sorted by name. Containerd runtime defaults are
+// listed with empty name if there is no default handler. Capabilities denied
+// by the admission policy are reported as unsupported, even though exempt
+// namespaces may still use them.
+func (c *criContainerdService) getRuntimeCapabilities() runtimeCapabilities {
+	names := []string{}
+	if c.runtimeHandlers != nil {
+		for name := range c.runtimeHandlers.Handlers {
+			names = append(names, name)
+		}
+	}
+	if defaultHandler, _ := c.runtimeHandlers.get(""); defaultHandler == nil {
+		names = append(names, "")
+	}
+	sort.Strings(names)
+	var policy admissionPolicy
+	if c.admission != nil && c.admission.policy != nil {
+		policy = *c.admission.policy
+	}
+	result := runtimeCapabilities{
+		Node: nodeRuntimeCapabilities{
+			HostNetwork:    !policy.DenyHostNetwork,
+			Privileged:     !policy.DenyPrivileged,
+			UserNamespaces: c.kernelFeatures.has(kernelFeatureUserNamespaces),
+		},
+	}
+	for _, name := range names {
+		h, _ := c.runtimeHandlers.get(name)
+		capabilities := runtimeHandlerCapabilities{
+			Name:                  name,
+			Default:               name == "" || name == c.runtimeHandlers.Default,
+			Runtime:               defaultRuntime,
+			PrivilegedHostDevices: !h.privilegedWithoutHostDevices(c.config.PrivilegedWithoutHostDevices),
+			PivotRoot:             true,
+			SessionKeyring:        true,
+		}
+		if h != nil {
+			capabilities.PivotRoot = !h.NoPivotRoot
+			capabilities.SessionKeyring = !h.NoNewKeyring
+		}
+		result.Handlers = append(result.Handlers, capabilities)
+	}
+	return result
+}
+
+// handleRuntimeHandlers handles the runtime-handlers debug endpoint. It
+// returns the node capabilities and the capabilities of runtime handlers.
+func (c *criContainerdService) handleRuntimeHandlers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.getRuntimeCapabilities())
+}
@@ -0,0 +1,283 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/linux/runcopts"
+	"github.com/containerd/containerd/typeurl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func TestLoadRuntimeHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtime-handlers")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for desc, test := range map[string]struct {
+		content   string
+		expected  *runtimeHandlersConfig
+		expectErr bool
+	}{
+		"valid config": {
+			content: `{"default": "ramdisk", "handlers": {"ramdisk": {"noPivotRoot": true}, "criu": {"criuPath": "/opt/criu/bin/criu"}}}`,
+			expected: &runtimeHandlersConfig{
+				Default: "ramdisk",
+				Handlers: map[string]*runtimeHandler{
+					"ramdisk": {NoPivotRoot: true},
+					"criu":    {CriuPath: "/opt/criu/bin/criu"},
+				},
+			},
+		},
+		"handler with mounts": {
+			content: `{"handlers": {"certs": {"mounts": [{"hostPath": "/etc/pki", "containerPath": "/etc/pki", "readonly": true}]}}}`,
+			expected: &runtimeHandlersConfig{
+				Handlers: map[string]*runtimeHandler{
+					"certs": {Mounts: []runtimeHandlerMount{
+						{HostPath: "/etc/pki", ContainerPath: "/etc/pki", Readonly: true},
+					}},
+				},
+			},
+		},
+		"relative mount host path": {
+			content:   `{"handlers": {"certs": {"mounts": [{"hostPath": "pki", "containerPath": "/etc/pki"}]}}}`,
+			expectErr: true,
+		},
+		"relative mount container path": {
+			content:   `{"handlers": {"certs": {"mounts": [{"hostPath": "/etc/pki", "containerPath": "pki"}]}}}`,
+			expectErr: true,
+		},
+		"default handler not found": {
+			content:   `{"default": "ramdisk", "handlers": {"criu": {"criuPath": "/opt/criu/bin/criu"}}}`,
+			expectErr: true,
+		},
+		"relative criu path": {
+			content:   `{"handlers": {"criu": {"criuPath": "criu"}}}`,
+			expectErr: true,
+		},
+		"empty handler": {
+			content:   `{"handlers": {"ramdisk": null}}`,
+			expectErr: true,
+		},
+		"invalid json": {
+			content:   `{"handlers": `,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		path := filepath.Join(dir, "config.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(test.content), 0644))
+		config, err := loadRuntimeHandlers(path)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, config)
+	}
+	config, err := loadRuntimeHandlers("")
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+}
+
+func TestGetRuntimeHandler(t *testing.T) {
+	ramdisk := &runtimeHandler{NoPivotRoot: true}
+	criu := &runtimeHandler{CriuPath: "/opt/criu/bin/criu"}
+	for desc, test := range map[string]struct {
+		config      *runtimeHandlersConfig
+		annotations map[string]string
+		expected    *runtimeHandler
+		expectErr   bool
+	}{
+		"no config": {},
+		"no config with annotation": {
+			annotations: map[string]string{runtimeHandlerAnnotationKey: "ramdisk"},
+			expectErr:   true,
+		},
+		"no default handler": {
+			config: &runtimeHandlersConfig{Handlers: map[string]*runtimeHandler{"criu": criu}},
+		},
+		"default handler": {
+			config: &runtimeHandlersConfig{
+				Default:  "ramdisk",
+				Handlers: map[string]*runtimeHandler{"ramdisk": ramdisk, "criu": criu},
+			},
+			expected: ramdisk,
+		},
+		"handler selected by annotation": {
+			config: &runtimeHandlersConfig{
+				Default:  "ramdisk",
+				Handlers: map[string]*runtimeHandler{"ramdisk": ramdisk, "criu": criu},
+			},
+			annotations: map[string]string{runtimeHandlerAnnotationKey: "criu"},
+			expected:    criu,
+		},
+		"handler not found": {
+			config: &runtimeHandlersConfig{
+				Default:  "ramdisk",
+				Handlers: map[string]*runtimeHandler{"ramdisk": ramdisk},
+			},
+			annotations: map[string]string{runtimeHandlerAnnotationKey: "criu"},
+			expectErr:   true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.runtimeHandlers = test.config
+		handler, err := c.getRuntimeHandler(test.annotations)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, handler)
+	}
+}
+
+func TestRuntimeHandlerOptions(t *testing.T) {
+	for desc, test := range map[string]struct {
+		handler        *runtimeHandler
+		runtimeOptions *runcopts.RuncOptions
+		taskOptions    *runcopts.CreateOptions
+	}{
+		"nil handler": {},
+		"empty handler": {
+			handler: &runtimeHandler{},
+		},
+		"runc options": {
+			handler:        &runtimeHandler{CriuPath: "/opt/criu/bin/criu"},
+			runtimeOptions: &runcopts.RuncOptions{CriuPath: "/opt/criu/bin/criu"},
+		},
+		"create options": {
+			handler: &runtimeHandler{NoPivotRoot: true, NoNewKeyring: true, ShimCgroup: "/system.slice/shim"},
+			taskOptions: &runcopts.CreateOptions{
+				NoPivotRoot:  true,
+				NoNewKeyring: true,
+				ShimCgroup:   "/system.slice/shim",
+			},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		info, err := test.handler.runtimeInfo()
+		require.NoError(t, err)
+		assert.Equal(t, defaultRuntime, info.Name)
+		if test.runtimeOptions == nil {
+			assert.Nil(t, info.Options)
+		} else {
+			options, err := typeurl.UnmarshalAny(info.Options)
+			require.NoError(t, err)
+			assert.Equal(t, test.runtimeOptions, options)
+		}
+		taskOpts, err := test.handler.taskOptions()
+		require.NoError(t, err)
+		if test.taskOptions == nil {
+			assert.Nil(t, taskOpts)
+		} else {
+			options, err := typeurl.UnmarshalAny(taskOpts)
+			require.NoError(t, err)
+			assert.Equal(t, test.taskOptions, options)
+		}
+	}
+}
+
+func TestRuntimeHandlerContainerMounts(t *testing.T) {
+	for desc, test := range map[string]struct {
+		handler  *runtimeHandler
+		expected []*runtime.Mount
+	}{
+		"nil handler": {},
+		"handler without mounts": {
+			handler: &runtimeHandler{NoPivotRoot: true},
+		},
+		"handler with mounts": {
+			handler: &runtimeHandler{Mounts: []runtimeHandlerMount{
+				{HostPath: "/etc/pki", ContainerPath: "/etc/pki", Readonly: true},
+				{HostPath: "/opt/license", ContainerPath: "/license"},
+			}},
+			expected: []*runtime.Mount{
+				{HostPath: "/etc/pki", ContainerPath: "/etc/pki", Readonly: true},
+				{HostPath: "/opt/license", ContainerPath: "/license"},
+			},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, test.handler.containerMounts())
+	}
+}
+
+func TestGetRuntimeCapabilities(t *testing.T) {
+	enabled := true
+	handlers := map[string]*runtimeHandler{
+		"ramdisk":   {NoPivotRoot: true, NoNewKeyring: true},
+		"no-device": {PrivilegedWithoutHostDevices: &enabled},
+	}
+	allNode := nodeRuntimeCapabilities{HostNetwork: true, Privileged: true, UserNamespaces: true}
+	for desc, test := range map[string]struct {
+		config             *runtimeHandlersConfig
+		withoutHostDevices bool
+		policy             *admissionPolicy
+		features           kernelFeatures
+		expected           runtimeCapabilities
+	}{
+		"no config": {
+			expected: runtimeCapabilities{
+				Node: allNode,
+				Handlers: []runtimeHandlerCapabilities{
+					{Name: "", Default: true, Runtime: defaultRuntime, PrivilegedHostDevices: true, PivotRoot: true, SessionKeyring: true},
+				},
+			},
+		},
+		"no default handler": {
+			config: &runtimeHandlersConfig{Handlers: handlers},
+			expected: runtimeCapabilities{
+				Node: allNode,
+				Handlers: []runtimeHandlerCapabilities{
+					{Name: "", Default: true, Runtime: defaultRuntime, PrivilegedHostDevices: true, PivotRoot: true, SessionKeyring: true},
+					{Name: "no-device", Runtime: defaultRuntime, PivotRoot: true, SessionKeyring: true},
+					{Name: "ramdisk", Runtime: defaultRuntime, PrivilegedHostDevices: true},
+				},
+			},
+		},
+		"default handler with global option, admission policy and missing kernel features": {
+			config:             &runtimeHandlersConfig{Default: "ramdisk", Handlers: handlers},
+			withoutHostDevices: true,
+			policy:             &admissionPolicy{DenyPrivileged: true, DenyHostNetwork: true},
+			features:           kernelFeatures{kernelFeatureUserNamespaces: false},
+			expected: runtimeCapabilities{
+				Handlers: []runtimeHandlerCapabilities{
+					{Name: "no-device", Runtime: defaultRuntime, PivotRoot: true, SessionKeyring: true},
+					{Name: "ramdisk", Default: true, Runtime: defaultRuntime},
+				},
+			},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.runtimeHandlers = test.config
+		c.config.PrivilegedWithoutHostDevices = test.withoutHostDevices
+		if test.policy != nil {
+			c.admission = &admissionController{policy: test.policy}
+		}
+		c.kernelFeatures = test.features
+		assert.Equal(t, test.expected, c.getRuntimeCapabilities())
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// runtimeTimeouts are the effective timeouts of runtime operations.
+type runtimeTimeouts struct {
+	// TaskCreate is the timeout of creating a task, 0 means no timeout.
+	TaskCreate time.Duration `json:"taskCreate"`
+	// TaskStart is the timeout of starting a task, 0 means no timeout.
+	TaskStart time.Duration `json:"taskStart"`
+	// TaskStop is the timeout of waiting for a killed container to exit.
+	TaskStop time.Duration `json:"taskStop"`
+	// ExecSetup is the timeout of creating an exec process, 0 means no timeout.
+	ExecSetup time.Duration `json:"execSetup"`
+}
+
+// validateTaskStopTimeout validates the task stop timeout. Unlike other
+// timeouts, it can't be disabled, because a container which never exits after
+// SIGKILL would block StopContainer forever.
+func validateTaskStopTimeout(timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("invalid task stop timeout %v, should be positive", timeout)
+	}
+	return nil
+}
+
+// getRuntimeTimeouts returns the effective timeouts of runtime operations.
+func (c *criContainerdService) getRuntimeTimeouts() runtimeTimeouts {
+	return runtimeTimeouts{
+		TaskCreate: c.config.TaskCreateTimeout,
+		TaskStart:  c.config.TaskStartTimeout,
+		TaskStop:   c.config.TaskStopTimeout,
+		ExecSetup:  c.config.ExecSetupTimeout,
+	}
+}
+
+// withRuntimeTimeout returns a context bounded by the timeout, 0 means the
+// context is only bounded by the parent.
+func withRuntimeTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// handleRuntimeTimeouts handles the runtime-timeouts debug endpoint. It
+// returns the effective timeouts of runtime operations.
+func (c *criContainerdService) handleRuntimeTimeouts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.getRuntimeTimeouts())
+}
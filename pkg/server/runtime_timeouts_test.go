@@ -0,0 +1,45 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestWithRuntimeTimeout(t *testing.T) {
+	ctx, cancel := withRuntimeTimeout(context.Background(), 0)
+	_, ok := ctx.Deadline()
+	assert.False(t, ok, "zero timeout should not set deadline")
+	cancel()
+	assert.Error(t, ctx.Err())
+
+	ctx, cancel = withRuntimeTimeout(context.Background(), time.Minute)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+}
+
+func TestValidateTaskStopTimeout(t *testing.T) {
+	assert.NoError(t, validateTaskStopTimeout(time.Minute))
+	assert.Error(t, validateTaskStopTimeout(0))
+	assert.Error(t, validateTaskStopTimeout(-time.Second))
+}
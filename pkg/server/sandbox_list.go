@@ -39,6 +39,22 @@ func (c *criContainerdService) ListPodSandbox(ctx context.Context, r *runtime.Li
 		}
 	}()
 
+	sandboxes, err := c.listCRISandboxes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sandboxes = c.filterCRISandboxes(sandboxes, r.GetFilter())
+	return &runtime.ListPodSandboxResponse{Items: sandboxes}, nil
+}
+
+// listCRISandboxes lists all sandboxes in CRI format, the result is cached
+// until the sandbox store or any sandbox container state changes.
+func (c *criContainerdService) listCRISandboxes(ctx context.Context) ([]*runtime.PodSandbox, error) {
+	generation := c.sandboxListGeneration()
+	if items, ok := c.sandboxListCache.get(generation); ok {
+		return copyCRISandboxes(items.([]*runtime.PodSandbox)), nil
+	}
+
 	// List all sandboxes from store.
 	sandboxesInStore := c.sandboxStore.List()
 
@@ -67,9 +83,8 @@ func (c *criContainerdService) ListPodSandbox(ctx context.Context, r *runtime.Li
 
 		sandboxes = append(sandboxes, toCRISandbox(sandboxInStore.Metadata, state))
 	}
-
-	sandboxes = c.filterCRISandboxes(sandboxes, r.GetFilter())
-	return &runtime.ListPodSandboxResponse{Items: sandboxes}, nil
+	c.sandboxListCache.set(generation, sandboxes)
+	return copyCRISandboxes(sandboxes), nil
 }
 
 // toCRISandbox converts sandbox metadata into CRI pod sandbox.
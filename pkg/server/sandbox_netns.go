@@ -0,0 +1,58 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxNetNSDir is the directory of persistent sandbox network namespaces.
+// It is the same directory `ip netns` uses, so that they can be inspected with
+// it.
+const sandboxNetNSDir = "/var/run/netns"
+
+// sandboxNetNSPrefix is the name prefix of sandbox network namespaces, followed
+// by the sandbox id.
+const sandboxNetNSPrefix = "cri-containerd-"
+
+// getSandboxNetNSPath returns the persistent network namespace path of a
+// sandbox.
+func getSandboxNetNSPath(id string) string {
+	return filepath.Join(sandboxNetNSDir, sandboxNetNSPrefix+id)
+}
+
+// isPersistentNetNS returns whether the network namespace path is a persistent
+// network namespace mount. Network namespaces under /proc are released with the
+// sandbox process, and are used by host network sandboxes and sandboxes created
+// by earlier versions.
+func isPersistentNetNS(path string) bool {
+	return path != "" && !strings.HasPrefix(path, "/proc/")
+}
+
+// removeSandboxNetNS unmounts and removes a persistent network namespace. It
+// doesn't return error if the network namespace is already removed.
+func (c *criContainerdService) removeSandboxNetNS(path string) error {
+	// EINVAL means the namespace is not mounted.
+	if err := c.os.Unmount(path, unix.MNT_DETACH); err != nil && err != unix.EINVAL && !os.IsNotExist(err) {
+		return err
+	}
+	return c.os.RemoveAll(path)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
+)
+
+// sandboxNetworkStats are the network stats of a sandbox, read from its host
+// side veth.
+type sandboxNetworkStats struct {
+	ID string `json:"id"`
+	// Timestamp is the time the stats are collected in nanoseconds.
+	Timestamp int64 `json:"timestamp"`
+	netplugin.InterfaceStats
+	// Rates are computed between the last two collections.
+	RxBytesPerSecond   float64 `json:"rxBytesPerSecond"`
+	TxBytesPerSecond   float64 `json:"txBytesPerSecond"`
+	RxDroppedPerSecond float64 `json:"rxDroppedPerSecond"`
+	TxDroppedPerSecond float64 `json:"txDroppedPerSecond"`
+}
+
+// networkStatsCollector keeps the latest network stats of all sandboxes.
+type networkStatsCollector struct {
+	sync.RWMutex
+	// enabled indicates whether stats are collected periodically.
+	enabled bool
+	stats   map[string]*sandboxNetworkStats
+}
+
+func newNetworkStatsCollector() *networkStatsCollector {
+	return &networkStatsCollector{stats: make(map[string]*sandboxNetworkStats)}
+}
+
+// get returns the latest stats of the sandbox, or nil if there is none.
+func (n *networkStatsCollector) get(id string) *sandboxNetworkStats {
+	n.RLock()
+	defer n.RUnlock()
+	return n.stats[id]
+}
+
+// list returns the latest stats of all sandboxes.
+func (n *networkStatsCollector) list() []*sandboxNetworkStats {
+	n.RLock()
+	defer n.RUnlock()
+	var stats []*sandboxNetworkStats
+	for _, s := range n.stats {
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// runNetworkStatsCollector collects sandbox network stats every period, it never returns.
+func (c *criContainerdService) runNetworkStatsCollector(period time.Duration) {
+	c.networkStats.Lock()
+	c.networkStats.enabled = true
+	c.networkStats.Unlock()
+	for range time.Tick(period) {
+		c.collectNetworkStats(time.Now())
+	}
+}
+
+// collectNetworkStats collects network stats of all sandboxes not in the host
+// network, and computes rates against the previous collection. Stats of removed
+// sandboxes are dropped.
+func (c *criContainerdService) collectNetworkStats(now time.Time) {
+	current := make(map[string]*sandboxNetworkStats)
+	for _, sandbox := range c.sandboxStore.List() {
+		if sandbox.Config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork() {
+			continue
+		}
+		stats, err := c.netPlugin.GetPodNetworkStats(getPodNetwork(sandbox.ID, sandbox.NetNS, sandbox.Config))
+		if err != nil {
+			glog.V(4).Infof("Failed to get network stats of sandbox %q: %v", sandbox.ID, err)
+			continue
+		}
+		s := &sandboxNetworkStats{
+			ID:             sandbox.ID,
+			Timestamp:      now.UnixNano(),
+			InterfaceStats: *stats,
+		}
+		if prev := c.networkStats.get(sandbox.ID); prev != nil {
+			setNetworkRates(prev, s)
+		}
+		current[sandbox.ID] = s
+	}
+	c.networkStats.Lock()
+	defer c.networkStats.Unlock()
+	c.networkStats.stats = current
+}
+
+// setNetworkRates computes the rates of cur against prev. Rates are left zero
+// if counters are reset, e.g. the veth is recreated.
+func setNetworkRates(prev, cur *sandboxNetworkStats) {
+	seconds := float64(cur.Timestamp-prev.Timestamp) / float64(time.Second)
+	if seconds <= 0 {
+		return
+	}
+	rate := func(p, c uint64) float64 {
+		if c < p {
+			return 0
+		}
+		return float64(c-p) / seconds
+	}
+	cur.RxBytesPerSecond = rate(prev.RxBytes, cur.RxBytes)
+	cur.TxBytesPerSecond = rate(prev.TxBytes, cur.TxBytes)
+	cur.RxDroppedPerSecond = rate(prev.RxDropped, cur.RxDropped)
+	cur.TxDroppedPerSecond = rate(prev.TxDropped, cur.TxDropped)
+}
+
+// handleSandboxNetworkStats handles the sandbox-network-stats debug endpoint. It
+// returns the latest network stats of the sandbox specified by the "id" query
+// parameter, or of all sandboxes if no id is specified.
+func (c *criContainerdService) handleSandboxNetworkStats(w http.ResponseWriter, r *http.Request) {
+	c.networkStats.RLock()
+	enabled := c.networkStats.enabled
+	c.networkStats.RUnlock()
+	if !enabled {
+		http.Error(w, "sandbox network stats collector is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSON(w, c.networkStats.list())
+		return
+	}
+	sandbox, err := c.sandboxStore.Get(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to find sandbox %q: %v", id, err), http.StatusNotFound)
+		return
+	}
+	stats := c.networkStats.get(sandbox.ID)
+	if stats == nil {
+		http.Error(w, fmt.Sprintf("no network stats for sandbox %q", sandbox.ID), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, stats)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
+	servertesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/testing"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestCollectNetworkStats(t *testing.T) {
+	c := newTestCRIContainerdService()
+	fakeCNIPlugin := c.netPlugin.(*servertesting.FakeCNIPlugin)
+	config := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{Name: "test-name", Namespace: "test-ns"},
+	}
+	hostConfig := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{Name: "test-host-name", Namespace: "test-ns"},
+		Linux: &runtime.LinuxPodSandboxConfig{
+			SecurityContext: &runtime.LinuxSandboxSecurityContext{
+				NamespaceOptions: &runtime.NamespaceOption{HostNetwork: true},
+			},
+		},
+	}
+	sandbox := sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{ID: "test-id", Config: config, NetNS: "/test/netns"}}
+	hostSandbox := sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{ID: "test-host-id", Config: hostConfig}}
+	require.NoError(t, c.sandboxStore.Add(sandbox))
+	require.NoError(t, c.sandboxStore.Add(hostSandbox))
+	podNetwork := getPodNetwork(sandbox.ID, sandbox.NetNS, config)
+
+	now := time.Now()
+	fakeCNIPlugin.SetFakePodNetworkStats(podNetwork, &netplugin.InterfaceStats{RxBytes: 1000, TxBytes: 2000, RxDropped: 1})
+	c.collectNetworkStats(now)
+	stats := c.networkStats.get(sandbox.ID)
+	require.NotNil(t, stats)
+	assert.EqualValues(t, 1000, stats.RxBytes)
+	assert.Zero(t, stats.RxBytesPerSecond, "rates should be zero for the first collection")
+	assert.Nil(t, c.networkStats.get(hostSandbox.ID), "host network sandbox should not be collected")
+
+	fakeCNIPlugin.SetFakePodNetworkStats(podNetwork, &netplugin.InterfaceStats{RxBytes: 3000, TxBytes: 2500, RxDropped: 11})
+	c.collectNetworkStats(now.Add(10 * time.Second))
+	stats = c.networkStats.get(sandbox.ID)
+	require.NotNil(t, stats)
+	assert.Equal(t, float64(200), stats.RxBytesPerSecond)
+	assert.Equal(t, float64(50), stats.TxBytesPerSecond)
+	assert.Equal(t, float64(1), stats.RxDroppedPerSecond)
+
+	// Counters reset should not result in negative rates.
+	fakeCNIPlugin.SetFakePodNetworkStats(podNetwork, &netplugin.InterfaceStats{RxBytes: 100})
+	c.collectNetworkStats(now.Add(20 * time.Second))
+	stats = c.networkStats.get(sandbox.ID)
+	require.NotNil(t, stats)
+	assert.Zero(t, stats.RxBytesPerSecond)
+
+	// Stats of removed sandbox should be dropped.
+	c.sandboxStore.Delete(sandbox.ID)
+	c.collectNetworkStats(now.Add(30 * time.Second))
+	assert.Empty(t, c.networkStats.list())
+}
+
+func TestHandleSandboxNetworkStats(t *testing.T) {
+	c := newTestCRIContainerdService()
+	w := httptest.NewRecorder()
+	c.handleSandboxNetworkStats(w, httptest.NewRequest(http.MethodGet, "/sandbox-network-stats", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "should fail when collector is disabled")
+
+	c.networkStats.enabled = true
+	c.networkStats.stats["test-id"] = &sandboxNetworkStats{ID: "test-id", RxBytesPerSecond: 10}
+	w = httptest.NewRecorder()
+	c.handleSandboxNetworkStats(w, httptest.NewRequest(http.MethodGet, "/sandbox-network-stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats []*sandboxNetworkStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	require.Len(t, stats, 1)
+	assert.Equal(t, float64(10), stats[0].RxBytesPerSecond)
+
+	w = httptest.NewRecorder()
+	c.handleSandboxNetworkStats(w, httptest.NewRequest(http.MethodGet, "/sandbox-network-stats?id=unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -17,14 +17,14 @@ limitations under the License.
 package server
 
 import (
-	"errors"
-
 	"golang.org/x/net/context"
-
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
 // PortForward prepares a streaming endpoint to forward ports from a PodSandbox, and returns the address.
+// There is no streaming server to serve the endpoint, so it always fails with codes.Unimplemented.
 func (c *criContainerdService) PortForward(ctx context.Context, r *runtime.PortForwardRequest) (*runtime.PortForwardResponse, error) {
-	return nil, errors.New("not implemented")
+	return nil, newCRIError(codes.Unimplemented, ReasonStreamingUnsupported,
+		"failed to forward ports of sandbox %q: streaming server is not available", r.GetPodSandboxId())
 }
@@ -0,0 +1,36 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func TestPortForwardStreamingUnsupported(t *testing.T) {
+	c := newTestCRIContainerdService()
+	_, err := c.PortForward(context.Background(), &runtime.PortForwardRequest{PodSandboxId: "test-id"})
+	require.Error(t, err)
+	assert.Equal(t, codes.Unimplemented, grpc.Code(toGRPCError(err)))
+	assert.Equal(t, ReasonStreamingUnsupported, ErrorReason(err))
+}
@@ -100,7 +100,7 @@ func (c *criContainerdService) RemovePodSandbox(ctx context.Context, r *runtime.
 		if !isContainerdGRPCNotFoundError(err) {
 			return nil, fmt.Errorf("failed to delete sandbox container %q: %v", id, err)
 		}
-		glog.V(5).Infof("Remove called for sandbox container %q that does not exist", id, err)
+		glog.V(5).Infof("Remove called for sandbox container %q that does not exist", id)
 	}
 
 	// Remove sandbox from sandbox store. Note that once the sandbox is successfully
@@ -113,5 +113,14 @@ func (c *criContainerdService) RemovePodSandbox(ctx context.Context, r *runtime.
 	// Release the sandbox name reserved for the sandbox.
 	c.sandboxNameIndex.ReleaseByKey(id)
 
+	c.namespaceQuotas.releaseSandbox(id)
+
+	var cgroupsPath string
+	if parent := sandbox.Config.GetLinux().GetCgroupParent(); parent != "" {
+		cgroupsPath = getCgroupsPath(parent, id)
+	}
+	c.verifyRemoval(removedResources{ID: id, CgroupsPath: cgroupsPath, NetNS: sandbox.NetNS})
+	c.checkLeaks(id)
+
 	return &runtime.RemovePodSandboxResponse{}, nil
 }
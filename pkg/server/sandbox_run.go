@@ -19,22 +19,29 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/api/services/tasks/v1"
 	"github.com/containerd/containerd/api/types"
 	"github.com/containerd/containerd/containers"
+	containerdmount "github.com/containerd/containerd/mount"
 	prototypes "github.com/gogo/protobuf/types"
 	"github.com/golang/glog"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
-	"github.com/opencontainers/runtime-tools/generate"
 	"golang.org/x/net/context"
 	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/spec"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
 	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
 )
 
@@ -49,6 +56,10 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 	}()
 
 	config := r.GetConfig()
+	qos, handler, err := c.validateSandboxConfig(ctx, config, false)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate unique id and name for the sandbox and reserve the name.
 	id := generateID()
@@ -56,7 +67,7 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 	// Reserve the sandbox name to avoid concurrent `RunPodSandbox` request starting the
 	// same sandbox.
 	if err := c.sandboxNameIndex.Reserve(name, id); err != nil {
-		return nil, fmt.Errorf("failed to reserve sandbox name %q: %v", name, err)
+		return nil, newCRIError(codes.AlreadyExists, ReasonNameConflict, "failed to reserve sandbox name %q: %v", name, err)
 	}
 	defer func() {
 		// Release the name if the function returns with an error.
@@ -64,6 +75,14 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 			c.sandboxNameIndex.ReleaseByName(name)
 		}
 	}()
+	if err := c.namespaceQuotas.reserveSandbox(id, config.GetMetadata().GetNamespace()); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			c.namespaceQuotas.releaseSandbox(id)
+		}
+	}()
 
 	// Create initial internal sandbox object.
 	sandbox := sandboxstore.Sandbox{
@@ -74,53 +93,132 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 		},
 	}
 
-	// Ensure sandbox container image snapshot.
-	image, err := c.ensureImageExists(ctx, c.sandboxImage)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get sandbox image %q: %v", defaultSandboxImage, err)
-	}
-	rootfsMounts, err := c.snapshotService.View(ctx, id, image.ChainID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare sandbox rootfs %q: %v", image.ChainID, err)
+	// Create a persistent network namespace for the sandbox, so that the network
+	// could be setup before the sandbox container is created, and torn down after
+	// the sandbox container dies.
+	hostNetwork := config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork()
+	if !hostNetwork {
+		sandbox.NetNS = getSandboxNetNSPath(id)
+		if err := c.os.NewNetNS(sandbox.NetNS); err != nil {
+			return nil, newPhaseError(phaseNetwork, err, "failed to create network namespace for sandbox %q", id)
+		}
+		defer func() {
+			if retErr != nil {
+				if err := c.removeSandboxNetNS(sandbox.NetNS); err != nil {
+					glog.Errorf("Failed to remove network namespace %q for sandbox %q: %v", sandbox.NetNS, id, err)
+				}
+			}
+		}()
 	}
-	defer func() {
-		if retErr != nil {
-			if err := c.snapshotService.Remove(ctx, id); err != nil {
-				glog.Errorf("Failed to remove sandbox container snapshot %q: %v", id, err)
+
+	// Setup network for the sandbox, prepare the sandbox container rootfs and
+	// spec, and setup the sandbox root directory concurrently. They are
+	// independent, and all on the critical path of pod start, so a slow CNI
+	// plugin doesn't delay the sandbox image check and spec generation.
+	var (
+		wg                          sync.WaitGroup
+		rootfs                      *sandboxRootfs
+		files                       *sandboxFiles
+		rootfsErr, filesErr, netErr error
+	)
+	if !hostNetwork {
+		podNetwork := getPodNetwork(id, sandbox.NetNS, config)
+		podNetwork.QoS = qos
+		// Teardown is registered before setup, so that partially setup network
+		// is cleaned up as well. It runs before the network namespace is removed.
+		defer func() {
+			if retErr != nil {
+				// Teardown network if an error is returned.
+				if err := c.netPlugin.TearDownPod(podNetwork); err != nil {
+					glog.Errorf("failed to destroy network for sandbox %q: %v", id, err)
+				}
 			}
-		}
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.netPlugin.SetUpPod(podNetwork); err != nil {
+				netErr = newPhaseError(phaseNetwork, err, "failed to setup network for sandbox %q", id)
+				return
+			}
+			if err := c.failpoints.eval(failpointCNIAdded); err != nil {
+				netErr = newPhaseError(phaseNetwork, err, "failed after setting up network for sandbox %q", id)
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		rootfs, rootfsErr = c.prepareSandboxRootfs(ctx, id, config, sandbox.NetNS)
 	}()
-	var rootfs []*types.Mount
-	for _, m := range rootfsMounts {
-		rootfs = append(rootfs, &types.Mount{
-			Type:    m.Type,
-			Source:  m.Source,
-			Options: m.Options,
-		})
+	go func() {
+		defer wg.Done()
+		files, filesErr = c.setupSandboxRootDir(ctx, id, config)
+	}()
+	wg.Wait()
+	// Register cleanup of the steps which succeeded before checking errors,
+	// so that they are rolled back if the other step failed.
+	if rootfsErr == nil {
+		defer func() {
+			if retErr != nil {
+				if err := c.snapshotService.Remove(ctx, id); err != nil {
+					glog.Errorf("Failed to remove sandbox container snapshot %q: %v", id, err)
+				}
+			}
+		}()
+	}
+	if filesErr == nil {
+		defer func() {
+			if retErr != nil {
+				c.cleanupSandboxRootDir(files, config)
+			}
+		}()
+	}
+	if rootfsErr != nil {
+		return nil, rootfsErr
+	}
+	if filesErr != nil {
+		return nil, filesErr
+	}
+	if netErr != nil {
+		return nil, netErr
 	}
+	if err := c.failpoints.eval(failpointSnapshotPrepared); err != nil {
+		return nil, newPhaseError(phaseRootfs, err, "failed after preparing sandbox rootfs")
+	}
+	image, spec := rootfs.image, rootfs.spec
 
 	// Create sandbox container.
-	spec, err := c.generateSandboxContainerSpec(id, config, image.Config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate sandbox container spec: %v", err)
-	}
 	rawSpec, err := json.Marshal(spec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal oci spec %+v: %v", spec, err)
 	}
 	glog.V(4).Infof("Sandbox container spec: %+v", spec)
+	specAny := &prototypes.Any{
+		TypeUrl: runtimespec.Version,
+		Value:   rawSpec,
+	}
+	runtimeInfo, err := handler.runtimeInfo()
+	if err != nil {
+		return nil, newPhaseError(phaseContainer, err, "failed to get runtime info")
+	}
+	// Checkpoint the metadata, so that the sandbox is recovered after restart.
+	// Pid is recovered from the sandbox container task, and so is the network
+	// namespace of host network sandboxes.
+	labels, err := sandboxMetadataLabels(sandbox.Metadata)
+	if err != nil {
+		return nil, newPhaseError(phaseContainer, err, "failed to checkpoint sandbox metadata")
+	}
 	if _, err = c.containerService.Create(ctx, containers.Container{
-		ID: id,
-		// TODO(random-liu): Checkpoint metadata into container labels.
-		Image:   image.ID,
-		Runtime: containers.RuntimeInfo{Name: defaultRuntime},
-		Spec: &prototypes.Any{
-			TypeUrl: runtimespec.Version,
-			Value:   rawSpec,
-		},
-		RootFS: id,
+		ID:          id,
+		Labels:      labels,
+		Image:       image.ID,
+		Runtime:     runtimeInfo,
+		Spec:        specAny,
+		RootFS:      id,
+		Snapshotter: c.snapshotterCaps.Name,
 	}); err != nil {
-		return nil, fmt.Errorf("failed to create containerd container: %v", err)
+		return nil, newPhaseError(phaseContainer, err, "failed to create containerd container")
 	}
 	defer func() {
 		if retErr != nil {
@@ -130,69 +228,26 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 		}
 	}()
 
-	// Create sandbox container root directory.
-	// Prepare streaming named pipe.
-	sandboxRootDir := getSandboxRootDir(c.rootDir, id)
-	if err := c.os.MkdirAll(sandboxRootDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create sandbox root directory %q: %v",
-			sandboxRootDir, err)
-	}
-	defer func() {
-		if retErr != nil {
-			// Cleanup the sandbox root directory.
-			if err := c.os.RemoveAll(sandboxRootDir); err != nil {
-				glog.Errorf("Failed to remove sandbox root directory %q: %v",
-					sandboxRootDir, err)
-			}
-		}
-	}()
-
-	// Discard sandbox container output because we don't care about it.
-	_, stdout, stderr := getStreamingPipes(sandboxRootDir)
-	_, stdoutPipe, stderrPipe, err := c.prepareStreamingPipes(ctx, "", stdout, stderr)
+	taskOpts, err := handler.taskOptions()
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare streaming pipes: %v", err)
+		return nil, newPhaseError(phaseTask, err, "failed to get task options")
 	}
-	defer func() {
-		if retErr != nil {
-			stdoutPipe.Close()
-			stderrPipe.Close()
-		}
-	}()
-	if err := c.agentFactory.NewSandboxLogger(stdoutPipe).Start(); err != nil {
-		return nil, fmt.Errorf("failed to start sandbox stdout logger: %v", err)
-	}
-	if err := c.agentFactory.NewSandboxLogger(stderrPipe).Start(); err != nil {
-		return nil, fmt.Errorf("failed to start sandbox stderr logger: %v", err)
-	}
-
-	// Setup sandbox /dev/shm, /etc/hosts and /etc/resolv.conf.
-	if err = c.setupSandboxFiles(sandboxRootDir, config); err != nil {
-		return nil, fmt.Errorf("failed to setup sandbox files: %v", err)
-	}
-	defer func() {
-		if retErr != nil {
-			if err = c.unmountSandboxFiles(sandboxRootDir, config); err != nil {
-				glog.Errorf("Failed to unmount sandbox files in %q: %v",
-					sandboxRootDir, err)
-			}
-		}
-	}()
-
 	createOpts := &tasks.CreateTaskRequest{
 		ContainerID: id,
-		Rootfs:      rootfs,
+		Rootfs:      rootfs.taskMounts(),
 		// No stdin for sandbox container.
-		Stdout: stdout,
-		Stderr: stderr,
+		Stdout:  files.stdout,
+		Stderr:  files.stderr,
+		Options: taskOpts,
 	}
 	// Create sandbox task in containerd.
 	glog.V(5).Infof("Create sandbox container (id=%q, name=%q) with options %+v.",
 		id, name, createOpts)
-	createResp, err := c.taskService.Create(ctx, createOpts)
+	createCtx, cancelCreate := withRuntimeTimeout(ctx, c.config.TaskCreateTimeout)
+	defer cancelCreate()
+	createResp, err := c.taskService.Create(createCtx, createOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create sandbox container %q: %v",
-			id, err)
+		return nil, newPhaseError(phaseTask, err, "failed to create sandbox container %q", id)
 	}
 	defer func() {
 		if retErr != nil {
@@ -204,28 +259,18 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 	}()
 
 	sandbox.Pid = createResp.Pid
-	sandbox.NetNS = getNetworkNamespace(createResp.Pid)
-	if !config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork() {
-		// Setup network for sandbox.
-		// TODO(random-liu): [P2] Replace with permanent network namespace.
-		podName := config.GetMetadata().GetName()
-		if err = c.netPlugin.SetUpPod(sandbox.NetNS, config.GetMetadata().GetNamespace(), podName, id); err != nil {
-			return nil, fmt.Errorf("failed to setup network for sandbox %q: %v", id, err)
-		}
-		defer func() {
-			if retErr != nil {
-				// Teardown network if an error is returned.
-				if err := c.netPlugin.TearDownPod(sandbox.NetNS, config.GetMetadata().GetNamespace(), podName, id); err != nil {
-					glog.Errorf("failed to destroy network for sandbox %q: %v", id, err)
-				}
-			}
-		}()
+	if hostNetwork {
+		sandbox.NetNS = getNetworkNamespace(createResp.Pid)
 	}
 
 	// Start sandbox container in containerd.
-	if _, err := c.taskService.Start(ctx, &tasks.StartTaskRequest{ContainerID: id}); err != nil {
-		return nil, fmt.Errorf("failed to start sandbox container %q: %v",
-			id, err)
+	startCtx, cancelStart := withRuntimeTimeout(ctx, c.config.TaskStartTimeout)
+	defer cancelStart()
+	if err := c.failpoints.eval(failpointBeforeTaskStart); err != nil {
+		return nil, newPhaseError(phaseTask, err, "failed before starting sandbox container %q", id)
+	}
+	if _, err := c.taskService.Start(startCtx, &tasks.StartTaskRequest{ContainerID: id}); err != nil {
+		return nil, newPhaseError(phaseTask, err, "failed to start sandbox container %q", id)
 	}
 
 	// Add sandbox into sandbox store.
@@ -233,87 +278,157 @@ func (c *criContainerdService) RunPodSandbox(ctx context.Context, r *runtime.Run
 	if err := c.sandboxStore.Add(sandbox); err != nil {
 		return nil, fmt.Errorf("failed to add sandbox %+v into store: %v", sandbox, err)
 	}
+	c.eventPublisher.publish(sandboxCreatedTopic, &sandboxCreatedEvent{
+		SandboxID: id,
+		Name:      config.GetMetadata().GetName(),
+		Namespace: config.GetMetadata().GetNamespace(),
+		UID:       config.GetMetadata().GetUid(),
+	})
 
 	return &runtime.RunPodSandboxResponse{PodSandboxId: id}, nil
 }
 
-func (c *criContainerdService) generateSandboxContainerSpec(id string, config *runtime.PodSandboxConfig,
-	imageConfig *imagespec.ImageConfig) (*runtimespec.Spec, error) {
-	// Creates a spec Generator with the default spec.
-	// TODO(random-liu): [P1] Compare the default settings with docker and containerd default.
-	g := generate.New()
+// sandboxRootfs is the prepared rootfs and spec of a sandbox container.
+type sandboxRootfs struct {
+	image  *imagestore.Image
+	mounts []containerdmount.Mount
+	spec   *runtimespec.Spec
+}
 
-	// Apply default config from image config.
-	if err := addImageEnvs(&g, imageConfig.Env); err != nil {
-		return nil, err
+// taskMounts returns the rootfs mounts of the sandbox container task.
+func (r *sandboxRootfs) taskMounts() []*types.Mount {
+	var mounts []*types.Mount
+	for _, m := range r.mounts {
+		mounts = append(mounts, &types.Mount{
+			Type:    m.Type,
+			Source:  m.Source,
+			Options: m.Options,
+		})
 	}
+	return mounts
+}
 
-	if imageConfig.WorkingDir != "" {
-		g.SetProcessCwd(imageConfig.WorkingDir)
+// prepareSandboxRootfs ensures the sandbox image, prepares the sandbox container
+// rootfs and generates the sandbox container spec joining the network namespace.
+// The rootfs snapshot is removed if an error is returned.
+func (c *criContainerdService) prepareSandboxRootfs(ctx context.Context, id string,
+	config *runtime.PodSandboxConfig, netNSPath string) (_ *sandboxRootfs, retErr error) {
+	image, err := c.ensureImageExists(ctx, c.sandboxImage)
+	if err != nil {
+		return nil, newPhaseError(phaseSandboxImage, err, "failed to get sandbox image %q", c.sandboxImage)
 	}
-
-	if len(imageConfig.Entrypoint) == 0 {
-		// Pause image must have entrypoint.
-		return nil, fmt.Errorf("invalid empty entrypoint in image config %+v", imageConfig)
+	rootfsMounts, err := c.snapshotService.View(ctx, id, image.ChainID)
+	if err != nil {
+		return nil, newPhaseError(phaseRootfs, err, "failed to prepare sandbox rootfs %q", image.ChainID)
 	}
-	// Set process commands.
-	g.SetProcessArgs(append(imageConfig.Entrypoint, imageConfig.Cmd...))
-
-	// Set relative root path.
-	g.SetRootPath(relativeRootfsPath)
-
-	// Make root of sandbox container read-only.
-	g.SetRootReadonly(true)
-
-	// Set hostname.
-	g.SetHostname(config.GetHostname())
+	defer func() {
+		if retErr != nil {
+			if err := c.snapshotService.Remove(ctx, id); err != nil {
+				glog.Errorf("Failed to remove sandbox container snapshot %q: %v", id, err)
+			}
+		}
+	}()
+	spec, err := c.generateSandboxContainerSpec(id, config, image.Config, netNSPath)
+	if err != nil {
+		return nil, newPhaseError(phaseSpec, err, "failed to generate sandbox container spec")
+	}
+	return &sandboxRootfs{image: image, mounts: rootfsMounts, spec: spec}, nil
+}
 
-	// TODO(random-liu): [P2] Consider whether to add labels and annotations to the container.
+// sandboxFiles are the files set up in the sandbox root directory.
+type sandboxFiles struct {
+	rootDir string
+	// stdout and stderr are the named pipes of the sandbox container output.
+	stdout     string
+	stderr     string
+	stdoutPipe io.ReadCloser
+	stderrPipe io.ReadCloser
+}
 
-	// Set cgroups parent.
-	if config.GetLinux().GetCgroupParent() != "" {
-		cgroupsPath := getCgroupsPath(config.GetLinux().GetCgroupParent(), id)
-		g.SetLinuxCgroupsPath(cgroupsPath)
+// setupSandboxRootDir creates the sandbox root directory, starts loggers discarding
+// the sandbox container output, and sets up the sandbox files. Everything set up is
+// cleaned up if an error is returned.
+func (c *criContainerdService) setupSandboxRootDir(ctx context.Context, id string,
+	config *runtime.PodSandboxConfig) (_ *sandboxFiles, retErr error) {
+	// Create sandbox container root directory.
+	// Prepare streaming named pipe.
+	sandboxRootDir := getSandboxRootDir(c.rootDir, id)
+	if err := c.os.MkdirAll(sandboxRootDir, 0755); err != nil {
+		return nil, newPhaseError(phaseFiles, err, "failed to create sandbox root directory %q",
+			sandboxRootDir)
 	}
-	// When cgroup parent is not set, containerd-shim will create container in a child cgroup
-	// of the cgroup itself is in.
-	// TODO(random-liu): [P2] Set default cgroup path if cgroup parent is not specified.
+	defer func() {
+		if retErr != nil {
+			// Cleanup the sandbox root directory.
+			if err := c.os.RemoveAll(sandboxRootDir); err != nil {
+				glog.Errorf("Failed to remove sandbox root directory %q: %v",
+					sandboxRootDir, err)
+			}
+		}
+	}()
 
-	// Set namespace options.
-	nsOptions := config.GetLinux().GetSecurityContext().GetNamespaceOptions()
-	// TODO(random-liu): [P1] Create permanent network namespace, so that we could still cleanup
-	// network namespace after sandbox container dies unexpectedly.
-	// By default, all namespaces are enabled for the container, runc will create a new namespace
-	// for it. By removing the namespace, the container will inherit the namespace of the runtime.
-	if nsOptions.GetHostNetwork() {
-		g.RemoveLinuxNamespace(string(runtimespec.NetworkNamespace)) // nolint: errcheck
-		// TODO(random-liu): [P1] Figure out how to handle UTS namespace.
+	// Discard sandbox container output because we don't care about it.
+	_, stdout, stderr := getStreamingPipes(sandboxRootDir)
+	_, stdoutPipe, stderrPipe, err := c.prepareStreamingPipes(ctx, "", stdout, stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare streaming pipes: %v", err)
 	}
-
-	if nsOptions.GetHostPid() {
-		g.RemoveLinuxNamespace(string(runtimespec.PIDNamespace)) // nolint: errcheck
+	defer func() {
+		if retErr != nil {
+			stdoutPipe.Close()
+			stderrPipe.Close()
+		}
+	}()
+	if err := c.agentFactory.NewSandboxLogger(id, stdoutPipe).Start(); err != nil {
+		return nil, fmt.Errorf("failed to start sandbox stdout logger: %v", err)
 	}
-
-	if nsOptions.GetHostIpc() {
-		g.RemoveLinuxNamespace(string(runtimespec.IPCNamespace)) // nolint: errcheck
+	if err := c.agentFactory.NewSandboxLogger(id, stderrPipe).Start(); err != nil {
+		return nil, fmt.Errorf("failed to start sandbox stderr logger: %v", err)
 	}
 
-	// TODO(random-liu): [P1] Apply SeLinux options.
-
-	// TODO(random-liu): [P1] Set user.
-
-	// TODO(random-liu): [P1] Set supplemental group.
-
-	// TODO(random-liu): [P1] Set privileged.
-
-	// TODO(random-liu): [P2] Set sysctl from annotations.
-
-	// TODO(random-liu): [P2] Set apparmor and seccomp from annotations.
+	// Setup sandbox /dev/shm, /etc/hosts and /etc/resolv.conf.
+	if err := c.setupSandboxFiles(sandboxRootDir, config); err != nil {
+		return nil, newPhaseError(phaseFiles, err, "failed to setup sandbox files")
+	}
+	return &sandboxFiles{
+		rootDir:    sandboxRootDir,
+		stdout:     stdout,
+		stderr:     stderr,
+		stdoutPipe: stdoutPipe,
+		stderrPipe: stderrPipe,
+	}, nil
+}
 
-	g.SetLinuxResourcesCPUShares(uint64(defaultSandboxCPUshares))
-	g.SetProcessOOMScoreAdj(int(defaultSandboxOOMAdj))
+// cleanupSandboxRootDir cleans up everything set up by setupSandboxRootDir. Errors
+// are only logged.
+func (c *criContainerdService) cleanupSandboxRootDir(files *sandboxFiles, config *runtime.PodSandboxConfig) {
+	if err := c.unmountSandboxFiles(files.rootDir, config); err != nil {
+		glog.Errorf("Failed to unmount sandbox files in %q: %v", files.rootDir, err)
+	}
+	files.stdoutPipe.Close()
+	files.stderrPipe.Close()
+	if err := c.os.RemoveAll(files.rootDir); err != nil {
+		glog.Errorf("Failed to remove sandbox root directory %q: %v", files.rootDir, err)
+	}
+}
 
-	return g.Spec(), nil
+// generateSandboxContainerSpec resolves the seccomp profile of the pod on the
+// node, and generates the sandbox container spec. Empty netNSPath means a new
+// network namespace is created for the sandbox container.
+func (c *criContainerdService) generateSandboxContainerSpec(id string, config *runtime.PodSandboxConfig,
+	imageConfig *imagespec.ImageConfig, netNSPath string) (*runtimespec.Spec, error) {
+	profile := getSeccompProfile(config.GetAnnotations(), "")
+	seccomp, err := c.loadSeccompProfile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set seccomp profile %q: %v", profile, err)
+	}
+	return spec.GenerateSandboxSpec(spec.SandboxOptions{
+		ID:          id,
+		Config:      config,
+		ImageConfig: imageConfig,
+		Seccomp:     seccomp,
+		NetNSPath:   netNSPath,
+	})
 }
 
 // setupSandboxFiles sets up necessary sandbox files including /dev/shm, /etc/hosts
@@ -403,3 +518,67 @@ func (c *criContainerdService) unmountSandboxFiles(rootDir string, config *runti
 	}
 	return nil
 }
+
+// getNetworkQoS returns the network qos marking requested by sandbox annotations,
+// or nil if there is none.
+func getNetworkQoS(annotations map[string]string) (*netplugin.NetworkQoS, error) {
+	var qos netplugin.NetworkQoS
+	if v, ok := annotations[networkDSCPAnnotationKey]; ok {
+		dscp, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || dscp > 63 {
+			return nil, fmt.Errorf("dscp %q is not an integer between 0 and 63", v)
+		}
+		d := uint(dscp)
+		qos.DSCP = &d
+	}
+	if v, ok := annotations[networkPriorityAnnotationKey]; ok {
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 || !isHex(parts[0]) || !isHex(parts[1]) {
+			return nil, fmt.Errorf("priority %q is not a tc class in the form of major:minor", v)
+		}
+		qos.Priority = v
+	}
+	if qos.DSCP == nil && qos.Priority == "" {
+		return nil, nil
+	}
+	return &qos, nil
+}
+
+// isHex returns whether the string is a non-empty hex number.
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 16, 16)
+	return err == nil
+}
+
+// validateSandboxConfig validates and admits the sandbox config before
+// anything is created for the sandbox, and returns the network qos and the
+// runtime handler of the sandbox. It is shared by RunPodSandbox and dry run,
+// and dry run admission requests are flagged for the admission webhook.
+func (c *criContainerdService) validateSandboxConfig(ctx context.Context, config *runtime.PodSandboxConfig,
+	dryRun bool) (*netplugin.NetworkQoS, *runtimeHandler, error) {
+	qos, err := getNetworkQoS(config.GetAnnotations())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid network qos: %v", err)
+	}
+	handler, err := c.getRuntimeHandler(config.GetAnnotations())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime handler: %v", err)
+	}
+	if _, err := handler.runtimeInfo(); err != nil {
+		return nil, nil, fmt.Errorf("invalid runtime handler: %v", err)
+	}
+	if dnsConfig := config.GetDnsConfig(); dnsConfig != nil {
+		if _, err := parseDNSOptions(dnsConfig.Servers, dnsConfig.Searches, dnsConfig.Options); err != nil {
+			return nil, nil, newPhaseError(phaseFiles, err, "invalid sandbox dns config %+v", dnsConfig)
+		}
+	}
+	req := newSandboxAdmissionRequest(config)
+	req.DryRun = dryRun
+	if err := c.admission.admit(ctx, req); err != nil {
+		return nil, nil, err
+	}
+	return qos, handler, nil
+}
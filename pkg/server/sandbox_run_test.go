@@ -17,18 +17,108 @@ limitations under the License.
 package server
 
 import (
+	gocontext "context"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/golang/protobuf/ptypes/empty"
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
 	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	servertesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/testing"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/spec"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
 )
 
+// fakeContainerStore is an in-memory containerd container store.
+type fakeContainerStore struct {
+	containers map[string]containers.Container
+}
+
+func newFakeContainerStore() *fakeContainerStore {
+	return &fakeContainerStore{containers: make(map[string]containers.Container)}
+}
+
+func (f *fakeContainerStore) Get(_ gocontext.Context, id string) (containers.Container, error) {
+	c, ok := f.containers[id]
+	if !ok {
+		return containers.Container{}, errdefs.ErrNotFound
+	}
+	return c, nil
+}
+
+func (f *fakeContainerStore) List(gocontext.Context, ...string) ([]containers.Container, error) {
+	var cs []containers.Container
+	for _, c := range f.containers {
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+func (f *fakeContainerStore) Create(_ gocontext.Context, c containers.Container) (containers.Container, error) {
+	if _, ok := f.containers[c.ID]; ok {
+		return containers.Container{}, errdefs.ErrAlreadyExists
+	}
+	f.containers[c.ID] = c
+	return c, nil
+}
+
+func (f *fakeContainerStore) Update(_ gocontext.Context, c containers.Container, fieldpaths ...string) (containers.Container, error) {
+	old, ok := f.containers[c.ID]
+	if !ok {
+		return containers.Container{}, errdefs.ErrNotFound
+	}
+	for _, path := range fieldpaths {
+		switch path {
+		case "spec":
+			old.Spec = c.Spec
+		case "labels":
+			old.Labels = c.Labels
+		}
+	}
+	f.containers[c.ID] = old
+	return old, nil
+}
+
+func (f *fakeContainerStore) Delete(_ gocontext.Context, id string) error {
+	if _, ok := f.containers[id]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(f.containers, id)
+	return nil
+}
+
+// newTestSandboxImageService creates a service with the sandbox image, a fake
+// snapshotter and a fake containerd container store.
+func newTestSandboxImageService() (*criContainerdService, *fakeSnapshotter, *fakeContainerStore) {
+	c := newTestCRIContainerdService()
+	snapshotter := &fakeSnapshotter{}
+	containerStore := newFakeContainerStore()
+	c.snapshotService = snapshotter
+	c.containerService = containerStore
+	c.imageStore.Add(imagestore.Image{
+		ID:      testSandboxImage,
+		ChainID: "test-chain-id",
+		Config:  &imagespec.ImageConfig{Entrypoint: []string{"/pause"}},
+	})
+	return c, snapshotter, containerStore
+}
+
 func getRunPodSandboxTestData() (*runtime.PodSandboxConfig, *imagespec.ImageConfig, func(*testing.T, string, *runtimespec.Spec)) {
 	config := &runtime.PodSandboxConfig{
 		Metadata: &runtime.PodSandboxMetadata{
@@ -51,16 +141,13 @@ func getRunPodSandboxTestData() (*runtime.PodSandboxConfig, *imagespec.ImageConf
 		Cmd:        []string{"forever"},
 		WorkingDir: "/workspace",
 	}
-	specCheck := func(t *testing.T, id string, spec *runtimespec.Spec) {
-		assert.Equal(t, "test-hostname", spec.Hostname)
-		assert.Equal(t, getCgroupsPath("/test/cgroup/parent", id), spec.Linux.CgroupsPath)
-		assert.Equal(t, relativeRootfsPath, spec.Root.Path)
-		assert.Equal(t, true, spec.Root.Readonly)
-		assert.Contains(t, spec.Process.Env, "a=b", "c=d")
-		assert.Equal(t, []string{"/pause", "forever"}, spec.Process.Args)
-		assert.Equal(t, "/workspace", spec.Process.Cwd)
-		assert.EqualValues(t, *spec.Linux.Resources.CPU.Shares, defaultSandboxCPUshares)
-		assert.EqualValues(t, *spec.Process.OOMScoreAdj, defaultSandboxOOMAdj)
+	specCheck := func(t *testing.T, id string, s *runtimespec.Spec) {
+		assert.Equal(t, getCgroupsPath("/test/cgroup/parent", id), s.Linux.CgroupsPath)
+		assert.Equal(t, spec.RelativeRootfsPath, s.Root.Path)
+		assert.Equal(t, true, s.Root.Readonly)
+		assert.Contains(t, s.Process.Env, "a=b", "c=d")
+		assert.Equal(t, []string{"/pause", "forever"}, s.Process.Args)
+		assert.Equal(t, "/workspace", s.Process.Cwd)
 	}
 	return config, imageConfig, specCheck
 }
@@ -86,6 +173,10 @@ func TestGenerateSandboxContainerSpec(t *testing.T) {
 				assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
 					Type: runtimespec.IPCNamespace,
 				})
+				assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+					Type: runtimespec.UTSNamespace,
+				})
+				assert.Equal(t, "test-hostname", spec.Hostname)
 			},
 		},
 		"host namespace": {
@@ -110,6 +201,11 @@ func TestGenerateSandboxContainerSpec(t *testing.T) {
 				assert.NotContains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
 					Type: runtimespec.IPCNamespace,
 				})
+				assert.NotContains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+					Type: runtimespec.UTSNamespace,
+				})
+				assert.Empty(t, spec.Hostname)
+				checkMount(t, spec.Mounts, "/dev/mqueue", "/dev/mqueue", "bind", []string{"rw"}, nil)
 			},
 		},
 		"should return error when entrypoint is empty": {
@@ -135,7 +231,7 @@ func TestGenerateSandboxContainerSpec(t *testing.T) {
 		if test.imageConfigChange != nil {
 			test.imageConfigChange(imageConfig)
 		}
-		spec, err := c.generateSandboxContainerSpec(testID, config, imageConfig)
+		spec, err := c.generateSandboxContainerSpec(testID, config, imageConfig, "")
 		if test.expectErr {
 			assert.Error(t, err)
 			assert.Nil(t, spec)
@@ -305,3 +401,166 @@ options timeout:1
 
 // TODO(random-liu): [P1] Add unit test for different error cases to make sure
 // the function cleans up on error properly.
+
+func TestGetNetworkQoS(t *testing.T) {
+	dscp := uint(46)
+	for desc, test := range map[string]struct {
+		annotations map[string]string
+		expected    *netplugin.NetworkQoS
+		expectErr   bool
+	}{
+		"no qos annotations": {
+			annotations: map[string]string{"a": "b"},
+		},
+		"dscp and priority": {
+			annotations: map[string]string{
+				networkDSCPAnnotationKey:     "46",
+				networkPriorityAnnotationKey: "1:a",
+			},
+			expected: &netplugin.NetworkQoS{DSCP: &dscp, Priority: "1:a"},
+		},
+		"dscp out of range": {
+			annotations: map[string]string{networkDSCPAnnotationKey: "64"},
+			expectErr:   true,
+		},
+		"dscp not integer": {
+			annotations: map[string]string{networkDSCPAnnotationKey: "ef"},
+			expectErr:   true,
+		},
+		"invalid priority": {
+			annotations: map[string]string{networkPriorityAnnotationKey: "10"},
+			expectErr:   true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		qos, err := getNetworkQoS(test.annotations)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, qos)
+	}
+}
+
+// fakeTaskService is a containerd task service only implementing methods
+// used in tests.
+type fakeTaskService struct {
+	tasks.TasksClient
+}
+
+func (f *fakeTaskService) Create(_ context.Context, r *tasks.CreateTaskRequest, _ ...grpc.CallOption) (*tasks.CreateTaskResponse, error) {
+	return &tasks.CreateTaskResponse{ContainerID: r.ContainerID, Pid: 1234}, nil
+}
+
+func (f *fakeTaskService) Start(_ context.Context, r *tasks.StartTaskRequest, _ ...grpc.CallOption) (*empty.Empty, error) {
+	return &empty.Empty{}, nil
+}
+
+// filesBlockingCNIPlugin is a fake cni plugin whose SetUpPod only succeeds if
+// the sandbox files are setup while the network is being setup, and the
+// sandbox container is not created yet.
+type filesBlockingCNIPlugin struct {
+	*servertesting.FakeCNIPlugin
+	filesSetup     chan struct{}
+	containerStore *fakeContainerStore
+	netNS          string
+}
+
+func (f *filesBlockingCNIPlugin) SetUpPod(network netplugin.PodNetwork) error {
+	select {
+	case <-f.filesSetup:
+	case <-time.After(5 * time.Second):
+		return errors.New("sandbox files are not setup concurrently")
+	}
+	if _, ok := f.containerStore.containers[network.ID]; ok {
+		return errors.New("network is setup after the sandbox container is created")
+	}
+	f.netNS = network.NetNS
+	return f.FakeCNIPlugin.SetUpPod(network)
+}
+
+func TestRunPodSandboxConcurrentNetworkSetup(t *testing.T) {
+	c, _, containerStore := newTestSandboxImageService()
+	c.taskService = &fakeTaskService{}
+	netPlugin := &filesBlockingCNIPlugin{
+		FakeCNIPlugin:  servertesting.NewFakeCNIPlugin().(*servertesting.FakeCNIPlugin),
+		filesSetup:     make(chan struct{}),
+		containerStore: containerStore,
+	}
+	c.netPlugin = netPlugin
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.OpenFifoFn = func(context.Context, string, int, os.FileMode) (io.ReadWriteCloser, error) {
+		return nopReadWriteCloser{}, nil
+	}
+	var once sync.Once
+	fakeOS.CopyFileFn = func(string, string, os.FileMode) error {
+		once.Do(func() { close(netPlugin.filesSetup) })
+		return nil
+	}
+	config, _, _ := getRunPodSandboxTestData()
+	resp, err := c.RunPodSandbox(context.Background(), &runtime.RunPodSandboxRequest{Config: config})
+	require.NoError(t, err)
+	id := resp.GetPodSandboxId()
+	sandbox, err := c.sandboxStore.Get(id)
+	require.NoError(t, err)
+	assert.Equal(t, getSandboxNetNSPath(id), sandbox.NetNS)
+	assert.Equal(t, sandbox.NetNS, netPlugin.netNS)
+	assert.Contains(t, fakeOS.GetCalls(), ostesting.CalledDetail{Name: "NewNetNS", Arguments: []interface{}{sandbox.NetNS}})
+	require.Contains(t, containerStore.containers, id)
+	var spec runtimespec.Spec
+	require.NoError(t, json.Unmarshal(containerStore.containers[id].Spec.Value, &spec))
+	assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{Type: runtimespec.NetworkNamespace, Path: sandbox.NetNS})
+	assert.Equal(t, float64(2), c.metrics.openFifos.Value())
+}
+
+func TestRunPodSandboxSetupRollback(t *testing.T) {
+	for desc, test := range map[string]struct {
+		imageConfig   *imagespec.ImageConfig
+		copyFileErr   error
+		expectedPhase string
+	}{
+		"spec generation failure should cleanup sandbox root directory": {
+			imageConfig:   &imagespec.ImageConfig{},
+			expectedPhase: phaseSpec,
+		},
+		"sandbox files setup failure should remove sandbox snapshot": {
+			copyFileErr:   errors.New("copy error"),
+			expectedPhase: phaseFiles,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c, snapshotter, containerStore := newTestSandboxImageService()
+		if test.imageConfig != nil {
+			image, err := c.imageStore.Get(testSandboxImage)
+			require.NoError(t, err)
+			image.Config = test.imageConfig
+			c.imageStore.Delete(testSandboxImage)
+			c.imageStore.Add(image)
+		}
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.OpenFifoFn = func(context.Context, string, int, os.FileMode) (io.ReadWriteCloser, error) {
+			return nopReadWriteCloser{}, nil
+		}
+		if test.copyFileErr != nil {
+			fakeOS.InjectError("CopyFile", test.copyFileErr)
+		}
+		var removed []string
+		fakeOS.RemoveAllFn = func(path string) error {
+			removed = append(removed, path)
+			return nil
+		}
+		config, _, _ := getRunPodSandboxTestData()
+		_, err := c.RunPodSandbox(context.Background(), &runtime.RunPodSandboxRequest{Config: config})
+		require.Error(t, err)
+		detail, ok := GetErrorDetail(err)
+		require.True(t, ok)
+		assert.Equal(t, test.expectedPhase, detail.Phase)
+		assert.Empty(t, snapshotter.views)
+		assert.Empty(t, containerStore.containers)
+		// The sandbox root directory and the network namespace are removed.
+		assert.Len(t, removed, 2)
+		assert.Equal(t, float64(0), c.metrics.openFifos.Value())
+		assert.Zero(t, c.sandboxNameIndex.Len())
+	}
+}
@@ -18,6 +18,7 @@ package server
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
@@ -27,6 +28,7 @@ import (
 
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
 	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
 )
 
@@ -41,8 +43,7 @@ func (c *criContainerdService) PodSandboxStatus(ctx context.Context, r *runtime.
 
 	sandbox, err := c.sandboxStore.Get(r.GetPodSandboxId())
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when try to find sandbox %q: %v",
-			r.GetPodSandboxId(), err)
+		return nil, sandboxLookupError(r.GetPodSandboxId(), err)
 	}
 	// Use the full sandbox id.
 	id := sandbox.ID
@@ -59,11 +60,22 @@ func (c *criContainerdService) PodSandboxStatus(ctx context.Context, r *runtime.
 		state = runtime.PodSandboxState_SANDBOX_READY
 	}
 
-	ip, err := c.netPlugin.GetContainerNetworkStatus(sandbox.NetNS, sandbox.Config.GetMetadata().GetNamespace(), sandbox.Config.GetMetadata().GetName(), id)
+	podNetwork := getPodNetwork(id, sandbox.NetNS, sandbox.Config)
+	// Validate the network of a ready sandbox. The sandbox is not ready if its
+	// network is broken, so that kubelet will recreate it.
+	if state == runtime.PodSandboxState_SANDBOX_READY &&
+		!sandbox.Config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork() {
+		if err := c.netPlugin.CheckPod(podNetwork); err != nil {
+			glog.Warningf("Network check for sandbox %q failed: %v", id, err)
+			state = runtime.PodSandboxState_SANDBOX_NOTREADY
+		}
+	}
+
+	ip, err := c.netPlugin.GetPodNetworkStatus(podNetwork)
 	if err != nil {
 		// Ignore the error on network status
 		ip = ""
-		glog.V(4).Infof("GetContainerNetworkStatus returns error: %v", err)
+		glog.V(4).Infof("GetPodNetworkStatus returns error: %v", err)
 	}
 
 	return &runtime.PodSandboxStatusResponse{Status: toCRISandboxStatus(sandbox.Metadata, state, ip)}, nil
@@ -91,3 +103,41 @@ func toCRISandboxStatus(meta sandboxstore.Metadata, state runtime.PodSandboxStat
 		Annotations: meta.Config.GetAnnotations(),
 	}
 }
+
+// sandboxNetworkInfo is the network information of a sandbox for debugging.
+type sandboxNetworkInfo struct {
+	ID          string            `json:"id"`
+	NetNS       string            `json:"netNamespace"`
+	HostNetwork bool              `json:"hostNetwork"`
+	IP          string            `json:"ip,omitempty"`
+	Result      *netplugin.Result `json:"cniResult,omitempty"`
+}
+
+// handleSandboxNetwork handles the sandbox-network debug endpoint. It returns the
+// full cni result of the sandbox specified by the "id" query parameter, which
+// includes all interfaces, ips and routes.
+func (c *criContainerdService) handleSandboxNetwork(w http.ResponseWriter, r *http.Request) {
+	sandbox, err := c.sandboxStore.Get(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to find sandbox %q: %v", r.URL.Query().Get("id"), err), http.StatusNotFound)
+		return
+	}
+	info := sandboxNetworkInfo{
+		ID:          sandbox.ID,
+		NetNS:       sandbox.NetNS,
+		HostNetwork: sandbox.Config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork(),
+	}
+	if !info.HostNetwork {
+		podNetwork := getPodNetwork(sandbox.ID, sandbox.NetNS, sandbox.Config)
+		info.Result, err = c.netPlugin.GetPodNetworkResult(podNetwork)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to get network result of sandbox %q: %v", sandbox.ID, err),
+				http.StatusInternalServerError)
+			return
+		}
+		if info.Result != nil {
+			info.IP = info.Result.IP()
+		}
+	}
+	writeJSON(w, info)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	servertesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/testing"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+func TestHandleSandboxNetwork(t *testing.T) {
+	config := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{Name: "test-name", Namespace: "test-ns"},
+	}
+	sandbox := sandboxstore.Sandbox{
+		Metadata: sandboxstore.Metadata{ID: "test-id", Config: config, NetNS: "/test/netns"},
+	}
+	for desc, test := range map[string]struct {
+		id         string
+		fakeIP     string
+		expectCode int
+		expectIP   string
+	}{
+		"should return cni result of the sandbox": {
+			id:         "test-id",
+			fakeIP:     "10.1.2.3",
+			expectCode: http.StatusOK,
+			expectIP:   "10.1.2.3",
+		},
+		"should return no result if there is none": {
+			id:         "test-id",
+			expectCode: http.StatusOK,
+		},
+		"should return not found for unknown sandbox": {
+			id:         "unknown",
+			expectCode: http.StatusNotFound,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		require.NoError(t, c.sandboxStore.Add(sandbox))
+		if test.fakeIP != "" {
+			c.netPlugin.(*servertesting.FakeCNIPlugin).SetFakePodNetwork(
+				getPodNetwork(sandbox.ID, sandbox.NetNS, config), test.fakeIP)
+		}
+		w := httptest.NewRecorder()
+		c.handleSandboxNetwork(w, httptest.NewRequest(http.MethodGet, "/sandbox-network?id="+test.id, nil))
+		assert.Equal(t, test.expectCode, w.Code)
+		if test.expectCode != http.StatusOK {
+			continue
+		}
+		var info sandboxNetworkInfo
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		assert.Equal(t, sandbox.ID, info.ID)
+		assert.Equal(t, sandbox.NetNS, info.NetNS)
+		assert.Equal(t, test.expectIP, info.IP)
+		if test.expectIP == "" {
+			assert.Nil(t, info.Result)
+		} else {
+			require.NotNil(t, info.Result)
+			assert.Len(t, info.Result.IPs, 1)
+		}
+	}
+}
@@ -19,6 +19,8 @@ package server
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/containerd/containerd/api/services/events/v1"
 	"github.com/containerd/containerd/api/services/tasks/v1"
@@ -26,10 +28,17 @@ import (
 	"github.com/containerd/containerd/typeurl"
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
 )
 
+// terminationGracePeriodAnnotationKey is the container annotation kubelet sets
+// to the pod termination grace period in seconds.
+const terminationGracePeriodAnnotationKey = "io.kubernetes.pod.terminationGracePeriod"
+
 // StopPodSandbox stops the sandbox. If there are any running containers in the
 // sandbox, they should be forcibly terminated.
 func (c *criContainerdService) StopPodSandbox(ctx context.Context, r *runtime.StopPodSandboxRequest) (retRes *runtime.StopPodSandboxResponse, retErr error) {
@@ -42,34 +51,25 @@ func (c *criContainerdService) StopPodSandbox(ctx context.Context, r *runtime.St
 
 	sandbox, err := c.sandboxStore.Get(r.GetPodSandboxId())
 	if err != nil {
-		return nil, fmt.Errorf("an error occurred when try to find sandbox %q: %v",
-			r.GetPodSandboxId(), err)
+		return nil, sandboxLookupError(r.GetPodSandboxId(), err)
 	}
 	// Use the full sandbox id.
 	id := sandbox.ID
 
-	// Stop all containers inside the sandbox. This terminates the container forcibly,
-	// and container may still be so production should not rely on this behavior.
+	// Stop all containers inside the sandbox. StopPodSandboxRequest has no timeout,
+	// so each container is given its own termination grace period, and containers
+	// without one are terminated forcibly. Production should not rely on this behavior.
 	// TODO(random-liu): Delete the sandbox container before this after permanent network namespace
 	// is introduced, so that no container will be started after that.
-	containers := c.containerStore.List()
-	for _, container := range containers {
-		if container.SandboxID != id {
-			continue
-		}
-		// Forcibly stop the container. Do not use `StopContainer`, because it introduces a race
-		// if a container is removed after list.
-		if err = c.stopContainer(ctx, container, 0); err != nil {
-			return nil, fmt.Errorf("failed to stop container %q: %v", container.ID, err)
-		}
+	if err := c.stopSandboxContainers(ctx, id, containerStopTimeout); err != nil {
+		return nil, err
 	}
 
 	// Teardown network for sandbox.
 	_, err = c.os.Stat(sandbox.NetNS)
 	if err == nil {
 		if !sandbox.Config.GetLinux().GetSecurityContext().GetNamespaceOptions().GetHostNetwork() {
-			if teardownErr := c.netPlugin.TearDownPod(sandbox.NetNS, sandbox.Config.GetMetadata().GetNamespace(),
-				sandbox.Config.GetMetadata().GetName(), id); teardownErr != nil {
+			if teardownErr := c.netPlugin.TearDownPod(getPodNetwork(id, sandbox.NetNS, sandbox.Config)); teardownErr != nil {
 				return nil, fmt.Errorf("failed to destroy network for sandbox %q: %v", id, teardownErr)
 			}
 		}
@@ -77,6 +77,11 @@ func (c *criContainerdService) StopPodSandbox(ctx context.Context, r *runtime.St
 		return nil, fmt.Errorf("failed to stat netns path for sandbox %q before tearing down the network: %v", id, err)
 	}
 	glog.V(2).Infof("TearDown network for sandbox %q successfully", id)
+	if isPersistentNetNS(sandbox.NetNS) {
+		if err := c.removeSandboxNetNS(sandbox.NetNS); err != nil {
+			return nil, fmt.Errorf("failed to remove network namespace %q for sandbox %q: %v", sandbox.NetNS, id, err)
+		}
+	}
 
 	sandboxRoot := getSandboxRootDir(c.rootDir, id)
 	if err := c.unmountSandboxFiles(sandboxRoot, sandbox.Config); err != nil {
@@ -89,6 +94,43 @@ func (c *criContainerdService) StopPodSandbox(ctx context.Context, r *runtime.St
 	return &runtime.StopPodSandboxResponse{}, nil
 }
 
+// stopSandboxContainers stops all containers inside the sandbox in parallel, each
+// with the grace period returned by timeout. All containers are stopped even if some
+// of them fail, and the first error is returned.
+func (c *criContainerdService) stopSandboxContainers(ctx context.Context, id string, timeout func(containerstore.Container) time.Duration) error {
+	var g errgroup.Group
+	for _, container := range c.containerStore.List() {
+		if container.SandboxID != id {
+			continue
+		}
+		container := container
+		g.Go(func() error {
+			// Do not use `StopContainer`, because it introduces a race if a container
+			// is removed after list.
+			if err := c.stopContainer(ctx, container, timeout(container)); err != nil {
+				return fmt.Errorf("failed to stop container %q: %v", container.ID, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// containerStopTimeout returns the termination grace period of the container
+// from its annotation, or 0 if the annotation is not set or invalid.
+func containerStopTimeout(container containerstore.Container) time.Duration {
+	value, ok := container.Config.GetAnnotations()[terminationGracePeriodAnnotationKey]
+	if !ok {
+		return 0
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		glog.Warningf("Invalid termination grace period %q for container %q", value, container.ID)
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 // stopSandboxContainer kills and deletes sandbox container.
 func (c *criContainerdService) stopSandboxContainer(ctx context.Context, id string) error {
 	cancellable, cancel := context.WithCancel(ctx)
@@ -121,8 +163,10 @@ func (c *criContainerdService) stopSandboxContainer(ctx context.Context, id stri
 	}
 
 	// Delete the sandbox container from containerd.
-	_, err = c.taskService.Delete(ctx, &tasks.DeleteTaskRequest{ContainerID: id})
-	if err != nil && !isContainerdGRPCNotFoundError(err) {
+	// The sandbox state generation is bumped again after the deletion is
+	// retried successfully.
+	if err := c.deleteTask(ctx, id, c.bumpSandboxStateGeneration); err != nil {
+		c.bumpSandboxStateGeneration()
 		return fmt.Errorf("failed to delete sandbox container: %v", err)
 	}
 	return nil
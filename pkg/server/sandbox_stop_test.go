@@ -0,0 +1,101 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+)
+
+func TestStopSandboxContainers(t *testing.T) {
+	sandboxID := "test-sandbox-id"
+	c := newTestCRIContainerdService()
+	for id, test := range map[string]struct {
+		sandboxID string
+		status    containerstore.Status
+	}{
+		"created": {
+			sandboxID: sandboxID,
+			status:    containerstore.Status{CreatedAt: time.Now().UnixNano()},
+		},
+		"exited": {
+			sandboxID: sandboxID,
+			status: containerstore.Status{
+				CreatedAt:  time.Now().UnixNano(),
+				StartedAt:  time.Now().UnixNano(),
+				FinishedAt: time.Now().UnixNano(),
+			},
+		},
+		"other-sandbox": {
+			sandboxID: "other-sandbox-id",
+			status: containerstore.Status{
+				CreatedAt: time.Now().UnixNano(),
+				StartedAt: time.Now().UnixNano(),
+			},
+		},
+	} {
+		container, err := containerstore.NewContainer(
+			containerstore.Metadata{ID: id, SandboxID: test.sandboxID},
+			test.status,
+		)
+		assert.NoError(t, err)
+		assert.NoError(t, c.containerStore.Add(container))
+	}
+	// None of the containers in the sandbox is running, and the running container
+	// belongs to another sandbox, so no containerd call should be made.
+	assert.NoError(t, c.stopSandboxContainers(context.Background(), sandboxID, containerStopTimeout))
+}
+
+func TestContainerStopTimeout(t *testing.T) {
+	for desc, test := range map[string]struct {
+		annotations map[string]string
+		expected    time.Duration
+	}{
+		"no annotation": {
+			expected: 0,
+		},
+		"termination grace period annotation": {
+			annotations: map[string]string{terminationGracePeriodAnnotationKey: "30"},
+			expected:    30 * time.Second,
+		},
+		"invalid termination grace period annotation": {
+			annotations: map[string]string{terminationGracePeriodAnnotationKey: "invalid"},
+			expected:    0,
+		},
+		"negative termination grace period annotation": {
+			annotations: map[string]string{terminationGracePeriodAnnotationKey: "-1"},
+			expected:    0,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		container, err := containerstore.NewContainer(
+			containerstore.Metadata{
+				ID:     "test-id",
+				Config: &runtime.ContainerConfig{Annotations: test.annotations},
+			},
+			containerstore.Status{CreatedAt: time.Now().UnixNano()},
+		)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, containerStopTimeout(container))
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/spec"
+)
+
+const (
+	// seccompPodAnnotationKey is the sandbox annotation key of the seccomp profile
+	// for all containers of the pod.
+	seccompPodAnnotationKey = "security.alpha.kubernetes.io/seccomp/pod"
+	// seccompContainerAnnotationKeyPrefix is the prefix of the sandbox annotation key
+	// of the seccomp profile for a container, which overrides the pod profile.
+	seccompContainerAnnotationKeyPrefix = "security.alpha.kubernetes.io/seccomp/container/"
+	// seccompUnconfined means no seccomp sandboxing.
+	seccompUnconfined = "unconfined"
+	// seccompRuntimeDefault means the default seccomp profile of cri-containerd.
+	seccompRuntimeDefault = "runtime/default"
+	// seccompLocalhostPrefix is the prefix of a seccomp profile on the node.
+	seccompLocalhostPrefix = "localhost/"
+)
+
+// getSeccompProfile returns the seccomp profile of a container from sandbox annotations.
+// The container profile overrides the pod profile. The pod profile is returned if the
+// container name is empty, e.g. for the sandbox container.
+func getSeccompProfile(annotations map[string]string, containerName string) string {
+	if containerName != "" {
+		if profile, ok := annotations[seccompContainerAnnotationKeyPrefix+containerName]; ok {
+			return profile
+		}
+	}
+	return annotations[seccompPodAnnotationKey]
+}
+
+// loadSeccompProfile resolves the seccomp profile on the node. It returns nil
+// for unconfined.
+func (c *criContainerdService) loadSeccompProfile(profile string) (*spec.SeccompProfile, error) {
+	switch {
+	case profile == "" || profile == seccompUnconfined:
+		return nil, nil
+	case profile == seccompRuntimeDefault:
+		// The default profile is skipped without seccomp in the kernel.
+		if !c.kernelFeatures.has(kernelFeatureSeccomp) {
+			return nil, nil
+		}
+		s, err := c.loadDefaultSeccompProfile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load default seccomp profile: %v", err)
+		}
+		return s, nil
+	case strings.HasPrefix(profile, seccompLocalhostPrefix):
+		if !c.kernelFeatures.has(kernelFeatureSeccomp) {
+			return nil, fmt.Errorf("seccomp is not supported by the kernel, seccomp profile %q can not be applied", profile)
+		}
+		path := strings.TrimPrefix(profile, seccompLocalhostPrefix)
+		data, err := c.os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("seccomp profile %q not found on the node", path)
+			}
+			return nil, fmt.Errorf("failed to read seccomp profile %q: %v", path, err)
+		}
+		return &spec.SeccompProfile{Data: data}, nil
+	default:
+		return nil, fmt.Errorf("unsupported seccomp profile %q", profile)
+	}
+}
+
+// loadDefaultSeccompProfile loads the configured default seccomp profile, and falls
+// back to the built-in default profile if it doesn't exist.
+func (c *criContainerdService) loadDefaultSeccompProfile() (*spec.SeccompProfile, error) {
+	path := c.config.SeccompDefaultProfile
+	if path == "" {
+		return &spec.SeccompProfile{}, nil
+	}
+	data, err := c.os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			glog.V(4).Infof("Default seccomp profile %q doesn't exist, use built-in profile", path)
+			return &spec.SeccompProfile{}, nil
+		}
+		return nil, fmt.Errorf("failed to read %q: %v", path, err)
+	}
+	return &spec.SeccompProfile{Data: data}, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+func TestGetSeccompProfile(t *testing.T) {
+	annotations := map[string]string{
+		seccompPodAnnotationKey:                          seccompRuntimeDefault,
+		seccompContainerAnnotationKeyPrefix + "override": "localhost/test",
+	}
+	for desc, test := range map[string]struct {
+		containerName string
+		expected      string
+	}{
+		"sandbox should use pod profile": {
+			expected: seccompRuntimeDefault,
+		},
+		"container should use pod profile by default": {
+			containerName: "test",
+			expected:      seccompRuntimeDefault,
+		},
+		"container profile should override pod profile": {
+			containerName: "override",
+			expected:      "localhost/test",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, getSeccompProfile(annotations, test.containerName))
+	}
+}
+
+func TestLoadSeccompProfile(t *testing.T) {
+	for desc, test := range map[string]struct {
+		profile       string
+		readFileErr   error
+		noKernel      bool
+		expectErr     bool
+		expectSeccomp bool
+	}{
+		"should not set seccomp for empty profile": {},
+		"should not set seccomp for unconfined profile": {
+			profile: seccompUnconfined,
+		},
+		"should set configured default profile": {
+			profile:       seccompRuntimeDefault,
+			expectSeccomp: true,
+		},
+		"should fall back to built-in default profile": {
+			profile:       seccompRuntimeDefault,
+			readFileErr:   os.ErrNotExist,
+			expectSeccomp: true,
+		},
+		"should set localhost profile": {
+			profile:       "localhost/test/profile.json",
+			expectSeccomp: true,
+		},
+		"should return error if localhost profile doesn't exist": {
+			profile:     "localhost/test/profile.json",
+			readFileErr: os.ErrNotExist,
+			expectErr:   true,
+		},
+		"should not set default profile without seccomp in the kernel": {
+			profile:  seccompRuntimeDefault,
+			noKernel: true,
+		},
+		"should return error for localhost profile without seccomp in the kernel": {
+			profile:   "localhost/test/profile.json",
+			noKernel:  true,
+			expectErr: true,
+		},
+		"should return error for unknown profile": {
+			profile:   "unknown",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.kernelFeatures = kernelFeatures{kernelFeatureSeccomp: !test.noKernel}
+		c.config.SeccompDefaultProfile = "/etc/test/default.json"
+		fakeOS := c.os.(*ostesting.FakeOS)
+		fakeOS.ReadFileFn = func(filename string) ([]byte, error) {
+			if test.readFileErr != nil {
+				return nil, test.readFileErr
+			}
+			return []byte(`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW"}]}`), nil
+		}
+		s, err := c.loadSeccompProfile(test.profile)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectSeccomp, s != nil)
+	}
+}
@@ -39,21 +39,31 @@ type CRIContainerdServer struct {
 	runtimeService runtime.RuntimeServiceServer
 	// imageService is the cri-containerd image service.
 	imageService runtime.ImageServiceServer
+	// interceptor intercepts all grpc requests.
+	interceptor grpc.UnaryServerInterceptor
 	// server is the grpc server.
 	server *grpc.Server
 }
 
 // NewCRIContainerdServer creates the cri-containerd grpc server.
-func NewCRIContainerdServer(addr string, r runtime.RuntimeServiceServer, i runtime.ImageServiceServer) *CRIContainerdServer {
+func NewCRIContainerdServer(addr string, r runtime.RuntimeServiceServer, i runtime.ImageServiceServer,
+	interceptor grpc.UnaryServerInterceptor) *CRIContainerdServer {
+	// Create the grpc server and register runtime and image services.
+	server := grpc.NewServer(grpc.UnaryInterceptor(interceptor))
+	runtime.RegisterRuntimeServiceServer(server, r)
+	runtime.RegisterImageServiceServer(server, i)
 	return &CRIContainerdServer{
 		addr:           addr,
 		runtimeService: r,
 		imageService:   i,
+		interceptor:    interceptor,
+		server:         server,
 	}
 }
 
-// Run runs the cri-containerd grpc server.
-func (s *CRIContainerdServer) Run() error {
+// Run runs the cri-containerd grpc server. The cleanup functions are called
+// after the server stops, also when the process is terminated by a signal.
+func (s *CRIContainerdServer) Run(cleanups ...func()) error {
 	glog.V(2).Infof("Start cri-containerd grpc server")
 	// Unlink to cleanup the previous socket file.
 	err := syscall.Unlink(s.addr)
@@ -64,11 +74,12 @@ func (s *CRIContainerdServer) Run() error {
 	if err != nil {
 		return fmt.Errorf("failed to listen on %q: %v", s.addr, err)
 	}
-	// Create the grpc server and register runtime and image services.
-	s.server = grpc.NewServer()
-	runtime.RegisterRuntimeServiceServer(s.server, s.runtimeService)
-	runtime.RegisterImageServiceServer(s.server, s.imageService)
 	// Use interrupt handler to make sure the server to be stopped properly.
-	h := interrupt.New(nil, s.server.Stop)
+	h := interrupt.New(nil, append([]func(){s.server.Stop}, cleanups...)...)
 	return h.Run(func() error { return s.server.Serve(l) })
 }
+
+// Stop stops the cri-containerd grpc server.
+func (s *CRIContainerdServer) Stop() {
+	s.server.Stop()
+}
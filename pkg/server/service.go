@@ -18,6 +18,8 @@ package server
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/api/services/events/v1"
@@ -28,12 +30,17 @@ import (
 	"github.com/containerd/containerd/images"
 	diffservice "github.com/containerd/containerd/services/diff"
 	"github.com/containerd/containerd/snapshot"
-	"github.com/kubernetes-incubator/cri-o/pkg/ocicni"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	healthapi "google.golang.org/grpc/health/grpc_health_v1"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/cmd/cri-containerd/options"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
 	osinterface "github.com/kubernetes-incubator/cri-containerd/pkg/os"
 	"github.com/kubernetes-incubator/cri-containerd/pkg/registrar"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/rpcrecord"
 	"github.com/kubernetes-incubator/cri-containerd/pkg/server/agents"
 	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
 	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
@@ -46,12 +53,23 @@ const k8sContainerdNamespace = "k8s.io"
 // CRIContainerdService is the interface implement CRI remote service server.
 type CRIContainerdService interface {
 	Start()
+	// Stop restores node-level state changed by the service, e.g. the kernel
+	// core pattern.
+	Stop()
+	// DebugHandler returns the http handler serving debug and administrative
+	// endpoints.
+	DebugHandler() http.Handler
+	// UnaryInterceptor intercepts all cri grpc requests.
+	UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error)
+	StateChecker
 	runtime.RuntimeServiceServer
 	runtime.ImageServiceServer
 }
 
 // criContainerdService implements CRIContainerdService.
 type criContainerdService struct {
+	// config contains all configurations.
+	config options.Config
 	// os is an interface for all required os operations.
 	os osinterface.OS
 	// rootDir is the directory for managing cri-containerd files.
@@ -79,6 +97,12 @@ type criContainerdService struct {
 	contentStoreService content.Store
 	// snapshotService is the containerd snapshot service client.
 	snapshotService snapshot.Snapshotter
+	// snapshotterCaps are capabilities of the snapshotter in use. Features
+	// depending on a snapshotter capability should check it.
+	snapshotterCaps snapshotterCapabilities
+	// kernelFeatures are kernel features probed at startup. Features depending
+	// on a missing kernel feature are disabled.
+	kernelFeatures kernelFeatures
 	// diffService is the containerd diff service client.
 	diffService diffservice.DiffService
 	// imageStoreService is the containerd service to store and track
@@ -89,56 +113,295 @@ type criContainerdService struct {
 	// healthService is the healthcheck service of containerd grpc server.
 	healthService healthapi.HealthClient
 	// netPlugin is used to setup and teardown network when run/stop pod sandbox.
-	netPlugin ocicni.CNIPlugin
+	netPlugin netplugin.CNIPlugin
 	// agentFactory is the factory to create agent used in the cri containerd service.
 	agentFactory agents.AgentFactory
 	// client is an instance of the containerd client
 	client *containerd.Client
 	// eventsService is the containerd task service client
 	eventService events.EventsClient
+	// eventPublisher queues cri events published into the containerd event service.
+	eventPublisher *eventPublisher
+	// eventMonitorStatus keeps whether the containerd event stream is alive.
+	eventMonitorStatus *eventMonitorStatus
+	// rpcLogger logs sampled grpc requests and responses.
+	rpcLogger *rpcLogger
+	// metrics contains metrics of the service.
+	metrics *serviceMetrics
+	// networkStats keeps the latest network stats of sandboxes.
+	networkStats *networkStatsCollector
+	// pullProgress tracks progress of ongoing image pulls.
+	pullProgress *pullProgressTracker
+	// pullQueue limits concurrent image pulls and orders them by priority.
+	pullQueue *pullQueue
+	// downloadSlots limits concurrent layer downloads of all pulls, nil means
+	// no limit.
+	downloadSlots chan struct{}
+	// imageLastUsed keeps the last time each image is used.
+	imageLastUsed *imageLastUsed
+	// verifiedImages keeps ids of images whose content is verified.
+	verifiedImages *verifiedImages
+	// taskReaper keeps tasks whose deletion is retried.
+	taskReaper *taskReaper
+	// imageRepairLocks serializes repairs of each image.
+	imageRepairLocks *imageRepairLocks
+	// imagePullAuths keeps credentials images were pulled with.
+	imagePullAuths *imagePullAuths
+	// rootfsViews keeps read-only mounts of container rootfs at host paths.
+	rootfsViews *rootfsViewStore
+	// ociLayoutDirs maps image hosts to local oci image layout directories.
+	ociLayoutDirs map[string]string
+	// imageRewriteRules rewrite image references to pull images from.
+	imageRewriteRules []imageRewriteRule
+	// registryConfig is the configuration of mirrors, endpoints and tls of
+	// image registries.
+	registryConfig *registryConfig
+	// admission admits sandbox and container creation requests.
+	admission *admissionController
+	// envInjection is the config of environment variables injected into
+	// containers, nil means no injection.
+	envInjection *envInjectionConfig
+	// mountPolicy blocks or rewrites dangerous host path mounts, nil means no
+	// policy.
+	mountPolicy *mountPolicy
+	// runtimeHandlers are runtime handlers with low level runc options, nil
+	// means containerd runtime defaults.
+	runtimeHandlers *runtimeHandlersConfig
+	// namespaceQuotas tracks and enforces per namespace resource quotas.
+	namespaceQuotas *namespaceQuotaTracker
+	// imagePrepuller keeps pre-pulled images.
+	imagePrepuller *imagePrepuller
+	// failpoints keeps failpoints armed through the debug socket.
+	failpoints *failpointRegistry
+	// rpcRecorder records cri requests for replay, nil means no recording.
+	rpcRecorder *rpcrecord.Recorder
+	// containerListCache caches the result of ListContainers.
+	containerListCache listCache
+	// sandboxListCache caches the result of ListPodSandbox.
+	sandboxListCache listCache
+	// sandboxStateGeneration is bumped whenever a sandbox container may
+	// change state. It must be accessed atomically.
+	sandboxStateGeneration uint64
+	// coreDumpLock protects coreDumpReserved.
+	coreDumpLock sync.Mutex
+	// coreDumpReserved is the total size limit reserved by core dumps being
+	// captured, so that concurrent captures don't exceed the total limit.
+	coreDumpReserved int64
 }
 
 // NewCRIContainerdService returns a new instance of CRIContainerdService
-func NewCRIContainerdService(containerdEndpoint, rootDir, networkPluginBinDir, networkPluginConfDir string) (CRIContainerdService, error) {
-	// TODO(random-liu): [P2] Recover from runtime state and checkpoint.
+func NewCRIContainerdService(config options.Config) (CRIContainerdService, error) {
+	if err := validateImageGCThresholds(config.ImageGCHighThresholdPercent, config.ImageGCLowThresholdPercent); err != nil {
+		return nil, err
+	}
+	if err := validateSnapshotterCapabilities(config.SnapshotterRequiredCapabilities); err != nil {
+		return nil, err
+	}
+	if err := validateMemoryMetric(config.ContainerMemoryMetric); err != nil {
+		return nil, err
+	}
+	if config.CoreDumpCapture && config.DebugSocketPath == "" {
+		return nil, fmt.Errorf("core dump capture requires debug socket path")
+	}
+	if err := validateImageDigestPolicy(config.ImageDigestPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateTaskStopTimeout(config.TaskStopTimeout); err != nil {
+		return nil, err
+	}
+	if config.ContentIngestGCPeriod > 0 && config.ContentIngestMaxAge <= 0 {
+		return nil, fmt.Errorf("content ingest max age %v should be positive", config.ContentIngestMaxAge)
+	}
+	if _, err := normalizeImageRef(config.SandboxImage); err != nil {
+		return nil, fmt.Errorf("invalid sandbox image %q: %v", config.SandboxImage, err)
+	}
+	if err := validateImageRepairPolicy(config.ImageRepairPolicy); err != nil {
+		return nil, err
+	}
+	ociLayoutDirs, err := parseOCILayoutHostDirs(config.OCILayoutHostDirs)
+	if err != nil {
+		return nil, err
+	}
+	imageRewriteRules, err := parseImageRewriteRules(config.ImageRewriteRules)
+	if err != nil {
+		return nil, err
+	}
+	registryConfig, err := newRegistryConfig(config.RegistryMirrors, config.RegistryEndpoints,
+		config.InsecureRegistries, config.RegistryCertsDir)
+	if err != nil {
+		return nil, err
+	}
+	admission, err := newAdmissionController(config.AdmissionPolicyFile, config.AdmissionWebhook,
+		config.AdmissionWebhookTimeout, config.AdmissionWebhookFailOpen)
+	if err != nil {
+		return nil, err
+	}
+	namespaceQuotas, err := loadNamespaceQuotas(config.NamespaceQuotaFile)
+	if err != nil {
+		return nil, err
+	}
+	envInjection, err := loadEnvInjectionConfig(config.EnvInjectionFile)
+	if err != nil {
+		return nil, err
+	}
+	mountPolicy, err := loadMountPolicy(config.MountPolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	runtimeHandlers, err := loadRuntimeHandlers(config.RuntimeHandlersFile)
+	if err != nil {
+		return nil, err
+	}
 
-	client, err := containerd.New(containerdEndpoint, containerd.WithDefaultNamespace(k8sContainerdNamespace))
+	var rpcRecorder *rpcrecord.Recorder
+	if config.RPCRecordFile != "" {
+		rpcRecorder, err = rpcrecord.NewRecorder(config.RPCRecordFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Task and content requests are distributed across the pool of clients, other
+	// services use the first client.
+	clients, err := newContainerdClients(config.ContainerdEndpoint, config.ContainerdConnectionPoolSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize containerd client with endpoint %q: %v", containerdEndpoint, err)
+		return nil, err
 	}
+	client := clients[0]
 
 	c := &criContainerdService{
+		config:              config,
 		os:                  osinterface.RealOS{},
-		rootDir:             rootDir,
-		sandboxImage:        defaultSandboxImage,
+		rootDir:             config.RootDir,
+		sandboxImage:        config.SandboxImage,
 		sandboxStore:        sandboxstore.NewStore(),
 		containerStore:      containerstore.NewStore(),
 		imageStore:          imagestore.NewStore(),
 		sandboxNameIndex:    registrar.NewRegistrar(),
 		containerNameIndex:  registrar.NewRegistrar(),
 		containerService:    client.ContainerService(),
-		taskService:         client.TaskService(),
+		taskService:         newTaskServicePool(clients),
 		imageStoreService:   client.ImageService(),
-		contentStoreService: client.ContentStore(),
-		// Use daemon default snapshotter.
-		snapshotService: client.SnapshotService(""),
-		diffService:     client.DiffService(),
-		versionService:  client.VersionService(),
-		healthService:   client.HealthService(),
-		agentFactory:    agents.NewAgentFactory(),
-		client:          client,
-		eventService:    client.EventService(),
-	}
-
-	netPlugin, err := ocicni.InitCNI(networkPluginBinDir, networkPluginConfDir)
+		contentStoreService: newContentStorePool(clients),
+		diffService:         client.DiffService(),
+		versionService:      client.VersionService(),
+		healthService:       client.HealthService(),
+		agentFactory:        agents.NewAgentFactory(config.ContainerLogIndexInterval, config.ContainerLogRingSize),
+		rpcLogger:           &rpcLogger{},
+		metrics:             newServiceMetrics(),
+		networkStats:        newNetworkStatsCollector(),
+		pullProgress:        newPullProgressTracker(),
+		pullQueue:           newPullQueue(config.MaxConcurrentImagePulls),
+		imageLastUsed:       newImageLastUsed(),
+		verifiedImages:      newVerifiedImages(),
+		imageRepairLocks:    newImageRepairLocks(),
+		imagePullAuths:      newImagePullAuths(),
+		taskReaper:          newTaskReaper(),
+		rootfsViews:         newRootfsViewStore(),
+		ociLayoutDirs:       ociLayoutDirs,
+		imageRewriteRules:   imageRewriteRules,
+		registryConfig:      registryConfig,
+		admission:           admission,
+		envInjection:        envInjection,
+		mountPolicy:         mountPolicy,
+		runtimeHandlers:     runtimeHandlers,
+		namespaceQuotas:     newNamespaceQuotaTracker(namespaceQuotas),
+		imagePrepuller:      newImagePrepuller(),
+		failpoints:          newFailpointRegistry(),
+		rpcRecorder:         rpcRecorder,
+		client:              client,
+		eventService:        client.EventService(),
+	}
+	c.eventPublisher = newEventPublisher(c.metrics.droppedEvents)
+	c.eventMonitorStatus = newEventMonitorStatus()
+	if config.SandboxImagePrepull {
+		c.imagePrepuller.setSandboxImage(config.SandboxImage)
+	}
+	if err := c.loadPrepullCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	c.snapshotService, c.snapshotterCaps, err = selectSnapshotter(context.Background(), config.Snapshotter,
+		config.SnapshotterFallback, config.SnapshotterRequiredCapabilities, client.SnapshotService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select snapshotter: %v", err)
+	}
+	glog.V(2).Infof("Use snapshotter %+v", c.snapshotterCaps)
+
+	c.kernelFeatures = probeKernelFeatures(c.os)
+	c.logMissingKernelFeatures()
+
+	netPlugin, err := netplugin.InitCNI(netplugin.Config{
+		ConfDir:      config.NetworkPluginConfDir,
+		BinDirs:      config.NetworkPluginBinDirs,
+		CacheDir:     config.NetworkPluginCacheDir,
+		MaxConfWait:  config.NetworkPluginMaxConfWait,
+		HairpinMode:  config.HairpinMode,
+		SetUpRetries: config.NetworkSetupRetries,
+		SetUpBackoff: config.NetworkSetupBackoff,
+		ConfTemplate: config.NetworkPluginConfTemplate,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize cni plugin: %v", err)
 	}
 	c.netPlugin = netPlugin
+	if config.MaxConcurrentDownloads > 0 {
+		c.downloadSlots = make(chan struct{}, config.MaxConcurrentDownloads)
+	}
+	c.registerStateMetrics()
+	c.registerProcessStatsMetrics()
+	c.registerPullProgressMetrics()
+	if c.config.PodMetrics {
+		c.registerPodMetrics()
+	}
 
 	return c, nil
 }
 
 func (c *criContainerdService) Start() {
-	c.startEventMonitor()
+	// Subscribe to containerd events before recovering state, so that exits
+	// of tasks listed during recovery are handled after it.
+	eventstream, err := c.eventService.Subscribe(context.Background(), &events.SubscribeRequest{})
+	if err != nil {
+		glog.Errorf("Failed to connect to containerd event stream before recovery: %v", err)
+		eventstream = nil
+	}
+	if err := c.recoverState(context.Background()); err != nil {
+		glog.Errorf("Failed to recover state: %v", err)
+	}
+	c.startEventMonitor(eventstream)
+	go c.runEventPublisher()
+	if c.config.PodNetworkStatsPeriod > 0 {
+		go c.runNetworkStatsCollector(c.config.PodNetworkStatsPeriod)
+	}
+	if c.config.ReadOnly {
+		glog.Info("Read-only mode, skip task reaper, image garbage collection and image pre-pull")
+		return
+	}
+	if err := c.loadDefaultApparmorProfile(); err != nil {
+		glog.Errorf("Failed to load default apparmor profile, containers with %q apparmor profile can't be created: %v",
+			apparmorRuntimeDefault, err)
+	}
+	go c.runTaskReaper(c.config.TaskDeleteRetryPeriod)
+	if c.config.ImageGCHighThresholdPercent > 0 {
+		go c.runImageGC(c.config.ImageGCPeriod)
+	}
+	// Images could be added through the debug socket even without manifest.
+	go c.runImagePrepull(c.config.ImagePrepullPeriod)
+	if c.config.ContentIngestGCPeriod > 0 {
+		go c.runIngestGC(c.config.ContentIngestGCPeriod, c.config.ContentIngestMaxAge)
+	}
+	if c.config.CoreDumpCapture {
+		if err := c.setCorePattern(); err != nil {
+			glog.Errorf("Failed to set core pattern to capture core dumps: %v", err)
+		}
+	}
+}
+
+func (c *criContainerdService) Stop() {
+	if c.config.CoreDumpCapture && !c.config.ReadOnly {
+		if err := c.restoreCorePattern(); err != nil {
+			glog.Errorf("Failed to restore core pattern: %v", err)
+		}
+	}
 }
@@ -19,6 +19,7 @@ package server
 import (
 	"io"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/metrics"
 	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
 	"github.com/kubernetes-incubator/cri-containerd/pkg/registrar"
 	agentstesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/agents/testing"
@@ -56,5 +57,22 @@ func newTestCRIContainerdService() *criContainerdService {
 		containerNameIndex: registrar.NewRegistrar(),
 		netPlugin:          servertesting.NewFakeCNIPlugin(),
 		agentFactory:       agentstesting.NewFakeAgentFactory(),
+		rpcLogger:          &rpcLogger{},
+		metrics:            newServiceMetrics(),
+		eventPublisher:     newEventPublisher(metrics.NewCounter("test_dropped_events", "")),
+		eventMonitorStatus: newEventMonitorStatus(),
+		networkStats:       newNetworkStatsCollector(),
+		pullProgress:       newPullProgressTracker(),
+		pullQueue:          newPullQueue(0),
+		registryConfig:     &registryConfig{},
+		imageLastUsed:      newImageLastUsed(),
+		verifiedImages:     newVerifiedImages(),
+		imageRepairLocks:   newImageRepairLocks(),
+		imagePullAuths:     newImagePullAuths(),
+		taskReaper:         newTaskReaper(),
+		rootfsViews:        newRootfsViewStore(),
+		namespaceQuotas:    newNamespaceQuotaTracker(nil),
+		imagePrepuller:     newImagePrepuller(),
+		failpoints:         newFailpointRegistry(),
 	}
 }
@@ -0,0 +1,88 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// sandboxShutdownResult is the result of stopping a sandbox during node shutdown.
+type sandboxShutdownResult struct {
+	// ID is the id of the sandbox.
+	ID string `json:"id"`
+	// Name is the name of the sandbox.
+	Name string `json:"name"`
+	// Error is the error message if the sandbox fails to stop.
+	Error string `json:"error,omitempty"`
+}
+
+// shutdownPods gracefully stops all sandboxes and their containers in parallel.
+// Containers are given the grace period to exit before they are killed, after
+// which the sandbox is stopped. It is used for node shutdown, so that all pods
+// are stopped cleanly before containerd is killed.
+func (c *criContainerdService) shutdownPods(ctx context.Context, gracePeriod time.Duration) []sandboxShutdownResult {
+	sandboxes := c.sandboxStore.List()
+	results := make([]sandboxShutdownResult, len(sandboxes))
+	var wg sync.WaitGroup
+	for i, sandbox := range sandboxes {
+		wg.Add(1)
+		go func(i int, sandbox sandboxstore.Sandbox) {
+			defer wg.Done()
+			results[i] = sandboxShutdownResult{ID: sandbox.ID, Name: sandbox.Name}
+			stopTimeout := func(containerstore.Container) time.Duration { return gracePeriod }
+			if err := c.stopSandboxContainers(ctx, sandbox.ID, stopTimeout); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			// Containers have been stopped gracefully, StopPodSandbox only
+			// needs to stop the sandbox container and teardown the network.
+			if _, err := c.StopPodSandbox(ctx, &runtime.StopPodSandboxRequest{PodSandboxId: sandbox.ID}); err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, sandbox)
+	}
+	wg.Wait()
+	return results
+}
+
+// handleShutdownPods handles the shutdown-pods debug endpoint. The grace period
+// could be overridden with the "grace-period" query parameter.
+func (c *criContainerdService) handleShutdownPods(w http.ResponseWriter, r *http.Request) {
+	gracePeriod := c.config.ShutdownGracePeriod
+	if p := r.URL.Query().Get("grace-period"); p != "" {
+		var err error
+		gracePeriod, err = time.ParseDuration(p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid grace period %q: %v", p, err), http.StatusBadRequest)
+			return
+		}
+	}
+	glog.Infof("Shutdown all pods with grace period %v", gracePeriod)
+	// Do not use the request context, all pods should be stopped even if
+	// the client goes away.
+	writeJSON(w, c.shutdownPods(context.Background(), gracePeriod))
+}
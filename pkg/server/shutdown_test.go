@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleShutdownPods(t *testing.T) {
+	for desc, test := range map[string]struct {
+		query      string
+		expectCode int
+	}{
+		"should use configured grace period by default": {
+			expectCode: http.StatusOK,
+		},
+		"should accept grace period override": {
+			query:      "?grace-period=10s",
+			expectCode: http.StatusOK,
+		},
+		"should reject invalid grace period": {
+			query:      "?grace-period=invalid",
+			expectCode: http.StatusBadRequest,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		w := httptest.NewRecorder()
+		c.DebugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/shutdown-pods"+test.query, nil))
+		assert.Equal(t, test.expectCode, w.Code)
+		if test.expectCode != http.StatusOK {
+			continue
+		}
+		var results []sandboxShutdownResult
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		assert.Empty(t, results)
+	}
+	t.Logf("should reject non-POST request")
+	c := newTestCRIContainerdService()
+	w := httptest.NewRecorder()
+	c.DebugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/shutdown-pods", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"fmt"
+	"net/http"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/snapshot"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+const (
+	// snapshotterCapabilityUsage is the capability to report disk usage of snapshots.
+	snapshotterCapabilityUsage = "usage"
+	// snapshotterCapabilityRemoteLayers is the capability to mount layers from
+	// remote storage without pulling them.
+	snapshotterCapabilityRemoteLayers = "remote-layers"
+)
+
+// snapshotterProbeKey is the snapshot key used to probe snapshotter capabilities.
+// The snapshot is never created.
+const snapshotterProbeKey = "cri-containerd-capability-probe"
+
+// snapshotterCapabilities are capabilities of the snapshotter in use.
+type snapshotterCapabilities struct {
+	// Name is the name of the snapshotter, empty means the containerd default.
+	Name string `json:"name"`
+	// FallbackFrom is the name of the configured snapshotter if it is replaced
+	// by the fallback snapshotter.
+	FallbackFrom string `json:"fallbackFrom,omitempty"`
+	// Usage indicates whether the snapshotter reports disk usage of snapshots.
+	Usage bool `json:"usage"`
+	// RemoteLayers indicates whether the snapshotter mounts layers from remote
+	// storage. The containerd snapshot api doesn't support it yet, so it is
+	// always false.
+	RemoteLayers bool `json:"remoteLayers"`
+}
+
+// has returns whether the snapshotter has the capability.
+func (s snapshotterCapabilities) has(capability string) bool {
+	switch capability {
+	case snapshotterCapabilityUsage:
+		return s.Usage
+	case snapshotterCapabilityRemoteLayers:
+		return s.RemoteLayers
+	}
+	return false
+}
+
+// validateSnapshotterCapabilities validates capability names.
+func validateSnapshotterCapabilities(capabilities []string) error {
+	for _, capability := range capabilities {
+		switch capability {
+		case snapshotterCapabilityUsage, snapshotterCapabilityRemoteLayers:
+		default:
+			return fmt.Errorf("unknown snapshotter capability %q", capability)
+		}
+	}
+	return nil
+}
+
+// probeSnapshotter probes capabilities of the snapshotter. An error is returned
+// if the snapshotter is not available.
+func probeSnapshotter(ctx context.Context, name string, s snapshot.Snapshotter) (snapshotterCapabilities, error) {
+	caps := snapshotterCapabilities{Name: name}
+	if err := s.Walk(ctx, func(gocontext.Context, snapshot.Info) error { return nil }); err != nil {
+		return caps, fmt.Errorf("snapshotter %q is not available: %v", name, err)
+	}
+	// A snapshotter supporting usage returns not found for the probe key.
+	if _, err := s.Usage(ctx, snapshotterProbeKey); err == nil || errdefs.IsNotFound(err) {
+		caps.Usage = true
+	} else {
+		glog.V(2).Infof("Snapshotter %q doesn't support usage: %v", name, err)
+	}
+	return caps, nil
+}
+
+// selectSnapshotter probes the configured snapshotter, and falls back to the
+// fallback snapshotter if the configured one is not available or misses any
+// required capability. An error is returned if no snapshotter could be used.
+func selectSnapshotter(ctx context.Context, name, fallback string, required []string,
+	newSnapshotter func(string) snapshot.Snapshotter) (snapshot.Snapshotter, snapshotterCapabilities, error) {
+	s := newSnapshotter(name)
+	caps, err := probeSnapshotter(ctx, name, s)
+	if err == nil {
+		err = checkSnapshotterCapabilities(caps, required)
+	}
+	if err == nil {
+		return s, caps, nil
+	}
+	if fallback == "" || fallback == name {
+		return nil, caps, err
+	}
+	glog.Warningf("Fall back to snapshotter %q: %v", fallback, err)
+	fs := newSnapshotter(fallback)
+	fallbackCaps, fallbackErr := probeSnapshotter(ctx, fallback, fs)
+	if fallbackErr == nil {
+		fallbackErr = checkSnapshotterCapabilities(fallbackCaps, required)
+	}
+	if fallbackErr != nil {
+		return nil, caps, fmt.Errorf("%v, and fallback failed: %v", err, fallbackErr)
+	}
+	fallbackCaps.FallbackFrom = name
+	return fs, fallbackCaps, nil
+}
+
+// checkSnapshotterCapabilities checks whether the snapshotter has all required
+// capabilities.
+func checkSnapshotterCapabilities(caps snapshotterCapabilities, required []string) error {
+	for _, capability := range required {
+		if !caps.has(capability) {
+			return fmt.Errorf("snapshotter %q doesn't support required capability %q", caps.Name, capability)
+		}
+	}
+	return nil
+}
+
+// handleSnapshotter handles the snapshotter debug endpoint. It returns the
+// snapshotter in use and its capabilities.
+func (c *criContainerdService) handleSnapshotter(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, c.snapshotterCaps)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	gocontext "context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	containerdmount "github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/snapshot"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// fakeSnapshotter is a snapshotter only implementing methods used in tests.
+type fakeSnapshotter struct {
+	snapshot.Snapshotter
+	walkErr  error
+	usageErr error
+	mounts   []containerdmount.Mount
+	// views are parents of views created, keyed by view key.
+	views map[string]string
+	// parents are parents of snapshots returned by Stat, keyed by snapshot key.
+	parents map[string]string
+}
+
+func (f *fakeSnapshotter) Stat(_ gocontext.Context, key string) (snapshot.Info, error) {
+	parent, ok := f.parents[key]
+	if !ok {
+		return snapshot.Info{}, errdefs.ErrNotFound
+	}
+	return snapshot.Info{Name: key, Parent: parent, Kind: snapshot.KindActive}, nil
+}
+
+func (f *fakeSnapshotter) View(_ gocontext.Context, key, parent string) ([]containerdmount.Mount, error) {
+	if f.views == nil {
+		f.views = make(map[string]string)
+	}
+	f.views[key] = parent
+	return []containerdmount.Mount{{Type: "bind", Source: parent}}, nil
+}
+
+func (f *fakeSnapshotter) Remove(_ gocontext.Context, key string) error {
+	delete(f.views, key)
+	return nil
+}
+
+func (f *fakeSnapshotter) Mounts(gocontext.Context, string) ([]containerdmount.Mount, error) {
+	return f.mounts, nil
+}
+
+func (f *fakeSnapshotter) Walk(gocontext.Context, func(gocontext.Context, snapshot.Info) error) error {
+	return f.walkErr
+}
+
+func (f *fakeSnapshotter) Usage(gocontext.Context, string) (snapshot.Usage, error) {
+	return snapshot.Usage{}, f.usageErr
+}
+
+func TestSelectSnapshotter(t *testing.T) {
+	unavailable := &fakeSnapshotter{walkErr: errdefs.ErrInvalidArgument}
+	noUsage := &fakeSnapshotter{usageErr: errdefs.ErrUnknown}
+	withUsage := &fakeSnapshotter{usageErr: errdefs.ErrNotFound}
+	for desc, test := range map[string]struct {
+		snapshotters map[string]snapshot.Snapshotter
+		name         string
+		fallback     string
+		required     []string
+		expectErr    bool
+		expectedCaps snapshotterCapabilities
+	}{
+		"configured snapshotter available": {
+			snapshotters: map[string]snapshot.Snapshotter{"btrfs": withUsage},
+			name:         "btrfs",
+			fallback:     "overlayfs",
+			required:     []string{snapshotterCapabilityUsage},
+			expectedCaps: snapshotterCapabilities{Name: "btrfs", Usage: true},
+		},
+		"fall back when configured snapshotter is unavailable": {
+			snapshotters: map[string]snapshot.Snapshotter{"btrfs": unavailable, "overlayfs": noUsage},
+			name:         "btrfs",
+			fallback:     "overlayfs",
+			expectedCaps: snapshotterCapabilities{Name: "overlayfs", FallbackFrom: "btrfs"},
+		},
+		"fall back when required capability is missing": {
+			snapshotters: map[string]snapshot.Snapshotter{"btrfs": noUsage, "overlayfs": withUsage},
+			name:         "btrfs",
+			fallback:     "overlayfs",
+			required:     []string{snapshotterCapabilityUsage},
+			expectedCaps: snapshotterCapabilities{Name: "overlayfs", FallbackFrom: "btrfs", Usage: true},
+		},
+		"fail without fallback": {
+			snapshotters: map[string]snapshot.Snapshotter{"btrfs": unavailable},
+			name:         "btrfs",
+			expectErr:    true,
+		},
+		"fail when fallback misses required capability": {
+			snapshotters: map[string]snapshot.Snapshotter{"btrfs": withUsage, "overlayfs": withUsage},
+			name:         "btrfs",
+			fallback:     "overlayfs",
+			required:     []string{snapshotterCapabilityRemoteLayers},
+			expectErr:    true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		s, caps, err := selectSnapshotter(context.Background(), test.name, test.fallback, test.required,
+			func(name string) snapshot.Snapshotter { return test.snapshotters[name] })
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectedCaps, caps)
+		expectedName := test.expectedCaps.Name
+		assert.Equal(t, test.snapshotters[expectedName], s)
+	}
+}
+
+func TestValidateSnapshotterCapabilities(t *testing.T) {
+	assert.NoError(t, validateSnapshotterCapabilities([]string{snapshotterCapabilityUsage, snapshotterCapabilityRemoteLayers}))
+	assert.Error(t, validateSnapshotterCapabilities([]string{"quota"}))
+	assert.NoError(t, checkSnapshotterCapabilities(snapshotterCapabilities{Usage: true}, []string{snapshotterCapabilityUsage}))
+	assert.Error(t, checkSnapshotterCapabilities(snapshotterCapabilities{}, []string{snapshotterCapabilityUsage}))
+}
@@ -0,0 +1,396 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/containers"
+	"github.com/golang/glog"
+	imagedigest "github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// stateVersion is the current version of exported state.
+const stateVersion = "v1"
+
+// exportedState is the CRI level view of sandboxes, containers and images on
+// the node. Sandboxes and containers are encoded with their own metadata
+// versions, so that they are migrated individually when imported by another
+// version of cri-containerd. Unknown fields are ignored on import, so state
+// exported by a newer version could be imported after downgrade as long as
+// the versions are supported.
+type exportedState struct {
+	// Version is the version of the exported state.
+	Version string
+	// Sandboxes are encoded sandbox metadata.
+	Sandboxes []json.RawMessage
+	// Containers are encoded container metadata and status.
+	Containers []exportedContainer
+	// Images are metadata of images.
+	Images []imagestore.Image
+}
+
+// exportedContainer is an exported container.
+type exportedContainer struct {
+	// Metadata is the encoded container metadata.
+	Metadata json.RawMessage
+	// Status is the encoded container status.
+	Status json.RawMessage
+}
+
+// importResult is the result of a state import.
+type importResult struct {
+	// Sandboxes are ids of sandboxes imported.
+	Sandboxes []string `json:"sandboxes"`
+	// Containers are ids of containers imported.
+	Containers []string `json:"containers"`
+	// Images are ids of images imported.
+	Images []string `json:"images"`
+	// Skipped maps ids of sandboxes and containers not imported to the reason.
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// exportState exports the CRI level view of the node state.
+func (c *criContainerdService) exportState() (*exportedState, error) {
+	state := &exportedState{Version: stateVersion}
+	sandboxes := c.sandboxStore.List()
+	sort.Slice(sandboxes, func(i, j int) bool { return sandboxes[i].ID < sandboxes[j].ID })
+	for _, s := range sandboxes {
+		data, err := s.Metadata.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode sandbox %q metadata: %v", s.ID, err)
+		}
+		state.Sandboxes = append(state.Sandboxes, data)
+	}
+	containers := c.containerStore.List()
+	sort.Slice(containers, func(i, j int) bool { return containers[i].ID < containers[j].ID })
+	for _, cntr := range containers {
+		meta, err := cntr.Metadata.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode container %q metadata: %v", cntr.ID, err)
+		}
+		status := cntr.Status.Get()
+		encodedStatus, err := status.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode container %q status: %v", cntr.ID, err)
+		}
+		state.Containers = append(state.Containers, exportedContainer{Metadata: meta, Status: encodedStatus})
+	}
+	state.Images = c.imageStore.List()
+	sort.Slice(state.Images, func(i, j int) bool { return state.Images[i].ID < state.Images[j].ID })
+	return state, nil
+}
+
+// importState restores exported state. Everything is decoded before anything
+// is imported, so that nothing is imported if the state is not supported.
+// Sandboxes and containers which already exist, conflict with existing names
+// or exceed namespace quotas are skipped. Containers whose sandbox doesn't
+// exist after import are skipped as well. Only state still backed by containerd
+// is imported: sandboxes and containers need their containerd container, and
+// images need their containerd image and config content. Pid and status are
+// taken from containerd tasks, and imported metadata is checkpointed, so that
+// it is recovered after restart.
+func (c *criContainerdService) importState(ctx context.Context, state *exportedState) (*importResult, error) {
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state version %q", state.Version)
+	}
+	var sandboxes []sandboxstore.Metadata
+	for i, data := range state.Sandboxes {
+		var meta sandboxstore.Metadata
+		if err := meta.Decode(data); err != nil {
+			return nil, fmt.Errorf("failed to decode sandbox %d: %v", i, err)
+		}
+		sandboxes = append(sandboxes, meta)
+	}
+	var containers []containerstore.Container
+	for i, e := range state.Containers {
+		var meta containerstore.Metadata
+		if err := meta.Decode(e.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode container %d metadata: %v", i, err)
+		}
+		var status containerstore.Status
+		if err := status.Decode(e.Status); err != nil {
+			return nil, fmt.Errorf("failed to decode container %q status: %v", meta.ID, err)
+		}
+		cntr, err := containerstore.NewContainer(meta, status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create container %q: %v", meta.ID, err)
+		}
+		containers = append(containers, cntr)
+	}
+
+	resp, err := c.taskService.List(ctx, &tasks.ListTasksRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containerd tasks: %v", err)
+	}
+	taskByID := make(map[string]*task.Task)
+	for _, t := range resp.Tasks {
+		taskByID[t.ID] = t
+	}
+
+	result := &importResult{Skipped: make(map[string]string)}
+	for _, meta := range sandboxes {
+		if err := c.importExportedSandbox(ctx, meta, taskByID[meta.ID]); err != nil {
+			result.Skipped[meta.ID] = err.Error()
+			continue
+		}
+		result.Sandboxes = append(result.Sandboxes, meta.ID)
+	}
+	for _, cntr := range containers {
+		if err := c.importExportedContainer(ctx, cntr, taskByID[cntr.ID]); err != nil {
+			result.Skipped[cntr.ID] = err.Error()
+			continue
+		}
+		result.Containers = append(result.Containers, cntr.ID)
+	}
+	for _, image := range state.Images {
+		if err := c.importExportedImage(ctx, image); err != nil {
+			result.Skipped[image.ID] = err.Error()
+			continue
+		}
+		result.Images = append(result.Images, image.ID)
+	}
+	return result, nil
+}
+
+// importExportedSandbox imports an exported sandbox backed by a containerd
+// container. The pid and network namespace are taken from its task, and the
+// metadata is checkpointed into the containerd container labels.
+func (c *criContainerdService) importExportedSandbox(ctx context.Context, meta sandboxstore.Metadata, t *task.Task) error {
+	if _, err := c.sandboxStore.Get(meta.ID); err == nil {
+		return &importConflictError{fmt.Errorf("sandbox already exists")}
+	}
+	cntr, err := c.containerService.Get(ctx, meta.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get containerd container: %v", err)
+	}
+	// Pid and non-persistent network namespace are recovered from the task,
+	// they are not checkpointed.
+	meta.Pid = 0
+	if !isPersistentNetNS(meta.NetNS) {
+		meta.NetNS = ""
+	}
+	labels, err := sandboxMetadataLabels(meta)
+	if err != nil {
+		return err
+	}
+	if err := c.updateContainerLabels(ctx, cntr, labels); err != nil {
+		return err
+	}
+	if t != nil && t.Status != task.StatusStopped {
+		meta.Pid = t.Pid
+		if meta.NetNS == "" {
+			meta.NetNS = getNetworkNamespace(t.Pid)
+		}
+	}
+	return c.importSandbox(meta, false)
+}
+
+// importExportedContainer imports an exported container backed by a
+// containerd container. The status is recovered from its task like after
+// restart, and the metadata and status are checkpointed.
+func (c *criContainerdService) importExportedContainer(ctx context.Context, container containerstore.Container, t *task.Task) (retErr error) {
+	// Check before the status is checkpointed, so that the checkpoint of an
+	// existing container is not overwritten.
+	if _, err := c.containerStore.Get(container.ID); err == nil {
+		return &importConflictError{fmt.Errorf("container already exists")}
+	}
+	cntr, err := c.containerService.Get(ctx, container.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get containerd container: %v", err)
+	}
+	labels, err := containerMetadataLabels(container.Metadata)
+	if err != nil {
+		return err
+	}
+	if err := c.updateContainerLabels(ctx, cntr, labels); err != nil {
+		return err
+	}
+	exported := container.Status.Get()
+	status := c.recoverContainerStatus(ctx, cntr, t, &exported)
+	imported, err := containerstore.NewContainer(container.Metadata, status,
+		containerstore.WithStatusCheckpoint(c.os, getContainerStatusPath(c.rootDir, container.ID)))
+	if err != nil {
+		return fmt.Errorf("failed to create container: %v", err)
+	}
+	defer func() {
+		if retErr != nil {
+			if err := imported.Delete(); err != nil {
+				glog.Errorf("Failed to cleanup container checkpoint for %q: %v", container.ID, err)
+			}
+		}
+	}()
+	if err := c.importContainer(imported, false); err != nil {
+		return err
+	}
+	if status.State() == runtime.ContainerState_CONTAINER_RUNNING {
+		if err := c.reopenContainerLoggers(ctx, container.Metadata); err != nil {
+			glog.Errorf("Failed to reopen loggers of container %q: %v", container.ID, err)
+		}
+	}
+	return nil
+}
+
+// updateContainerLabels adds labels into the containerd container.
+func (c *criContainerdService) updateContainerLabels(ctx context.Context, cntr containers.Container, labels map[string]string) error {
+	merged := make(map[string]string)
+	for k, v := range cntr.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	if _, err := c.containerService.Update(ctx, containers.Container{ID: cntr.ID, Labels: merged}, "labels"); err != nil {
+		return fmt.Errorf("failed to update containerd container labels: %v", err)
+	}
+	return nil
+}
+
+// importExportedImage imports an exported image whose containerd image and
+// config content exist. References of the image are checkpointed into the
+// containerd image store.
+func (c *criContainerdService) importExportedImage(ctx context.Context, image imagestore.Image) error {
+	containerdImage, err := c.imageStoreService.Get(ctx, image.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get containerd image: %v", err)
+	}
+	if _, err := c.contentStoreService.Info(ctx, imagedigest.Digest(image.ID)); err != nil {
+		return fmt.Errorf("failed to get image config content: %v", err)
+	}
+	for _, ref := range append(append([]string{}, image.RepoTags...), image.RepoDigests...) {
+		if err := c.createImageReference(ctx, ref, containerdImage.Target); err != nil {
+			return fmt.Errorf("failed to create image reference %q: %v", ref, err)
+		}
+	}
+	c.imageStore.Add(image)
+	return nil
+}
+
+// importConflictError means a sandbox or container can't be imported because
+// it conflicts with one in the stores, e.g. its name is taken.
+type importConflictError struct {
+	err error
+}
+
+func (e *importConflictError) Error() string {
+	return e.err.Error()
+}
+
+// isImportConflictError returns whether the error is an importConflictError.
+func isImportConflictError(err error) bool {
+	_, ok := err.(*importConflictError)
+	return ok
+}
+
+// importSandbox adds a sandbox into the sandbox store. The namespace quota is
+// not enforced for a recovered sandbox, which is already running.
+func (c *criContainerdService) importSandbox(meta sandboxstore.Metadata, recovered bool) (retErr error) {
+	if _, err := c.sandboxStore.Get(meta.ID); err == nil {
+		return &importConflictError{fmt.Errorf("sandbox already exists")}
+	}
+	if err := c.sandboxNameIndex.Reserve(meta.Name, meta.ID); err != nil {
+		return &importConflictError{fmt.Errorf("failed to reserve sandbox name %q: %v", meta.Name, err)}
+	}
+	defer func() {
+		if retErr != nil {
+			c.sandboxNameIndex.ReleaseByName(meta.Name)
+		}
+	}()
+	if recovered {
+		c.namespaceQuotas.accountSandbox(meta.ID, meta.Config.GetMetadata().GetNamespace())
+	} else if err := c.namespaceQuotas.reserveSandbox(meta.ID, meta.Config.GetMetadata().GetNamespace()); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			c.namespaceQuotas.releaseSandbox(meta.ID)
+		}
+	}()
+	return c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: meta})
+}
+
+// importContainer adds a container into the container store. The namespace
+// quota is not enforced for a recovered container, which may be running.
+func (c *criContainerdService) importContainer(cntr containerstore.Container, recovered bool) (retErr error) {
+	if _, err := c.containerStore.Get(cntr.ID); err == nil {
+		return &importConflictError{fmt.Errorf("container already exists")}
+	}
+	sandbox, err := c.sandboxStore.Get(cntr.SandboxID)
+	if err != nil {
+		return fmt.Errorf("failed to get sandbox %q: %v", cntr.SandboxID, err)
+	}
+	if err := c.containerNameIndex.Reserve(cntr.Name, cntr.ID); err != nil {
+		return &importConflictError{fmt.Errorf("failed to reserve container name %q: %v", cntr.Name, err)}
+	}
+	defer func() {
+		if retErr != nil {
+			c.containerNameIndex.ReleaseByName(cntr.Name)
+		}
+	}()
+	if recovered {
+		c.namespaceQuotas.accountContainer(cntr.ID, sandbox.Config.GetMetadata().GetNamespace())
+	} else if err := c.namespaceQuotas.reserveContainer(cntr.ID, sandbox.Config.GetMetadata().GetNamespace()); err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			c.namespaceQuotas.releaseContainer(cntr.ID)
+		}
+	}()
+	return c.containerStore.Add(cntr)
+}
+
+// handleState handles the state debug endpoint. GET exports the CRI level view
+// of the node state, and POST imports state exported before from the request
+// body.
+func (c *criContainerdService) handleState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		state, err := c.exportState()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, state)
+	case http.MethodPost:
+		var state exportedState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, fmt.Sprintf("invalid state: %v", err), http.StatusBadRequest)
+			return
+		}
+		result, err := c.importState(r.Context(), &state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		glog.Infof("Imported state: %+v", result)
+		writeJSON(w, result)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
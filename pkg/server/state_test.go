@@ -0,0 +1,234 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/containers"
+	containerdimages "github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+	containerstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/container"
+	imagestore "github.com/kubernetes-incubator/cri-containerd/pkg/store/image"
+	sandboxstore "github.com/kubernetes-incubator/cri-containerd/pkg/store/sandbox"
+)
+
+// newTestStateService creates a service with a sandbox, a container in the
+// sandbox and an image.
+func newTestStateService(t *testing.T) *criContainerdService {
+	c := newTestCRIContainerdService()
+	require.NoError(t, c.sandboxStore.Add(sandboxstore.Sandbox{Metadata: sandboxstore.Metadata{
+		ID:   "sandbox-id",
+		Name: "sandbox-name",
+		Config: &runtime.PodSandboxConfig{
+			Metadata: &runtime.PodSandboxMetadata{Name: "pod", Namespace: "ns"},
+		},
+		Pid:   1234,
+		NetNS: "/proc/1234/ns/net",
+	}}))
+	cntr, err := containerstore.NewContainer(containerstore.Metadata{
+		ID:        "container-id",
+		Name:      "container-name",
+		SandboxID: "sandbox-id",
+		Config:    &runtime.ContainerConfig{Metadata: &runtime.ContainerMetadata{Name: "container"}},
+		ImageRef:  "image-id",
+	}, containerstore.Status{CreatedAt: 1, StartedAt: 2, Pid: 4321})
+	require.NoError(t, err)
+	require.NoError(t, c.containerStore.Add(cntr))
+	c.imageStore.Add(imagestore.Image{ID: "image-id", ChainID: "chain-id", RepoTags: []string{"busybox:latest"}})
+	return c
+}
+
+// newTestImportService creates a service whose containerd still has the
+// sandbox, the container, their tasks and the image of newTestStateService.
+func newTestImportService() (*criContainerdService, *fakeContainerStore, *fakeImageStore) {
+	c := newTestCRIContainerdService()
+	containerStore := newFakeContainerStore()
+	for _, id := range []string{"sandbox-id", "container-id"} {
+		containerStore.containers[id] = containers.Container{ID: id, Labels: map[string]string{"other": "label"}}
+	}
+	c.containerService = containerStore
+	c.taskService = &fakeRecoveryTaskService{tasks: map[string]*task.Task{
+		"sandbox-id":   {ID: "sandbox-id", Pid: 1234, Status: task.StatusRunning},
+		"container-id": {ID: "container-id", Pid: 4321, Status: task.StatusRunning},
+	}}
+	imageStore := &fakeImageStore{images: map[string]containerdimages.Image{
+		"image-id": {Name: "image-id", Target: imagespec.Descriptor{Digest: "sha256:manifest"}},
+	}}
+	c.imageStoreService = imageStore
+	c.contentStoreService = &fakeBlobStore{blobs: map[digest.Digest][]byte{"image-id": []byte("config")}}
+	c.os.(*ostesting.FakeOS).OpenFifoFn = (&fakeFifos{process: make(map[string]*pipeEnd)}).open
+	return c, containerStore, imageStore
+}
+
+func TestExportImportState(t *testing.T) {
+	src := newTestStateService(t)
+	state, err := src.exportState()
+	require.NoError(t, err)
+	assert.Equal(t, stateVersion, state.Version)
+	assert.Len(t, state.Sandboxes, 1)
+	assert.Len(t, state.Containers, 1)
+	assert.Len(t, state.Images, 1)
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+
+	dst, containerStore, imageStore := newTestImportService()
+	var imported exportedState
+	require.NoError(t, json.Unmarshal(data, &imported))
+	result, err := dst.importState(context.Background(), &imported)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sandbox-id"}, result.Sandboxes)
+	assert.Equal(t, []string{"container-id"}, result.Containers)
+	assert.Equal(t, []string{"image-id"}, result.Images)
+	assert.Empty(t, result.Skipped)
+
+	sandbox, err := dst.sandboxStore.Get("sandbox-id")
+	require.NoError(t, err)
+	expectedSandbox, err := src.sandboxStore.Get("sandbox-id")
+	require.NoError(t, err)
+	assert.Equal(t, expectedSandbox.Metadata, sandbox.Metadata)
+	cntr, err := dst.containerStore.Get("container-id")
+	require.NoError(t, err)
+	expectedCntr, err := src.containerStore.Get("container-id")
+	require.NoError(t, err)
+	assert.Equal(t, expectedCntr.Metadata, cntr.Metadata)
+	assert.Equal(t, expectedCntr.Status.Get(), cntr.Status.Get())
+	assert.Equal(t, runtime.ContainerState_CONTAINER_RUNNING, cntr.Status.Get().State())
+	image, err := dst.imageStore.Get("image-id")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"busybox:latest"}, image.RepoTags)
+	assert.Equal(t, 1, dst.namespaceQuotas.usage()["ns"].Sandboxes)
+	assert.Equal(t, 1, dst.namespaceQuotas.usage()["ns"].Containers)
+
+	t.Logf("imported metadata should be checkpointed")
+	assert.Equal(t, "label", containerStore.containers["sandbox-id"].Labels["other"])
+	assert.NotEmpty(t, containerStore.containers["sandbox-id"].Labels[sandboxMetadataLabel])
+	assert.NotEmpty(t, containerStore.containers["container-id"].Labels[containerMetadataLabel])
+	assert.Equal(t, digest.Digest("sha256:manifest"), imageStore.images["busybox:latest"].Target.Digest)
+
+	t.Logf("importing the same state again should skip existing sandboxes and containers")
+	result, err = dst.importState(context.Background(), &imported)
+	require.NoError(t, err)
+	assert.Empty(t, result.Sandboxes)
+	assert.Empty(t, result.Containers)
+	assert.Len(t, result.Skipped, 2)
+}
+
+func TestImportStateValidation(t *testing.T) {
+	state, err := newTestStateService(t).exportState()
+	require.NoError(t, err)
+	for desc, test := range map[string]struct {
+		mutate    func(*exportedState)
+		prepare   func(*criContainerdService)
+		expectErr bool
+		skipped   []string
+	}{
+		"unsupported state version should fail": {
+			mutate:    func(s *exportedState) { s.Version = "v0" },
+			expectErr: true,
+		},
+		"unsupported sandbox version should fail": {
+			mutate: func(s *exportedState) {
+				s.Sandboxes = []json.RawMessage{json.RawMessage(`{"Version":"v0","ID":"sandbox-id"}`)}
+			},
+			expectErr: true,
+		},
+		"container without sandbox should be skipped": {
+			mutate:  func(s *exportedState) { s.Sandboxes = nil },
+			skipped: []string{"container-id"},
+		},
+		"sandbox and container without containerd container should be skipped": {
+			mutate:  func(*exportedState) {},
+			prepare: func(c *criContainerdService) { c.containerService = newFakeContainerStore() },
+			skipped: []string{"container-id", "sandbox-id"},
+		},
+		"image without content should be skipped": {
+			mutate:  func(s *exportedState) { s.Sandboxes, s.Containers = nil, nil },
+			prepare: func(c *criContainerdService) { c.contentStoreService = &fakeBlobStore{} },
+			skipped: []string{"image-id"},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		s := *state
+		test.mutate(&s)
+		c, _, _ := newTestImportService()
+		if test.prepare != nil {
+			test.prepare(c)
+		}
+		result, err := c.importState(context.Background(), &s)
+		if test.expectErr {
+			assert.Error(t, err)
+			assert.Empty(t, c.sandboxStore.List())
+			assert.Empty(t, c.containerStore.List())
+			assert.Empty(t, c.imageStore.List())
+			continue
+		}
+		require.NoError(t, err)
+		var skipped []string
+		for id := range result.Skipped {
+			skipped = append(skipped, id)
+		}
+		sort.Strings(skipped)
+		assert.Equal(t, test.skipped, skipped)
+		for _, id := range test.skipped {
+			_, err := c.containerStore.Get(id)
+			assert.Error(t, err)
+			_, err = c.sandboxStore.Get(id)
+			assert.Error(t, err)
+			_, err = c.imageStore.Get(id)
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestHandleState(t *testing.T) {
+	src := newTestStateService(t)
+	w := httptest.NewRecorder()
+	src.DebugHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	dst, _, _ := newTestImportService()
+	handler := dst.DebugHandler()
+	w2 := httptest.NewRecorder()
+	handler.ServeHTTP(w2, httptest.NewRequest(http.MethodPost, "/state", bytes.NewReader(w.Body.Bytes())))
+	require.Equal(t, http.StatusOK, w2.Code)
+	var result importResult
+	require.NoError(t, json.Unmarshal(w2.Body.Bytes(), &result))
+	assert.Equal(t, []string{"sandbox-id"}, result.Sandboxes)
+	assert.Equal(t, []string{"container-id"}, result.Containers)
+
+	w3 := httptest.NewRecorder()
+	handler.ServeHTTP(w3, httptest.NewRequest(http.MethodPost, "/state", bytes.NewReader([]byte("invalid"))))
+	assert.Equal(t, http.StatusBadRequest, w3.Code)
+
+	w4 := httptest.NewRecorder()
+	handler.ServeHTTP(w4, httptest.NewRequest(http.MethodDelete, "/state", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w4.Code)
+}
@@ -18,11 +18,14 @@ package server
 
 import (
 	"fmt"
+	"time"
 
 	"golang.org/x/net/context"
 	healthapi "google.golang.org/grpc/health/grpc_health_v1"
 
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
 )
 
 const (
@@ -30,6 +33,23 @@ const (
 	runtimeNotReadyReason = "ContainerdNotReady"
 	// networkNotReadyReason is the reason reported when network is not ready.
 	networkNotReadyReason = "NetworkPluginNotReady"
+	// networkWaitingForConfigReason is the reason reported when network plugin
+	// is waiting for config during startup.
+	networkWaitingForConfigReason = "NetworkPluginWaitingForConfig"
+	// eventMonitorReady is the condition type of the containerd event monitor.
+	// Kubelet ignores it, it is for operators to notice a dead event loop, which
+	// leaves container states stale.
+	eventMonitorReady = "EventMonitorReady"
+	// eventStreamDisconnectedReason is the reason reported when the containerd
+	// event stream is not subscribed.
+	eventStreamDisconnectedReason = "EventStreamDisconnected"
+	// eventBacklogTooLargeReason is the reason reported when too many events
+	// are received but not handled.
+	eventBacklogTooLargeReason = "EventBacklogTooLarge"
+	// maxEventBacklog is the event backlog above which the event monitor is
+	// not ready. It is the capacity of one event worker queue, a full queue
+	// blocks receiving the event stream.
+	maxEventBacklog = eventQueueSize
 )
 
 // Status returns the status of the runtime.
@@ -56,13 +76,49 @@ func (c *criContainerdService) Status(ctx context.Context, r *runtime.StatusRequ
 	}
 	if err := c.netPlugin.Status(); err != nil {
 		networkCondition.Status = false
-		networkCondition.Reason = networkNotReadyReason
-		networkCondition.Message = fmt.Sprintf("Network plugin returns error: %v", err)
+		if err == netplugin.ErrWaitingForConfig {
+			networkCondition.Reason = networkWaitingForConfigReason
+			networkCondition.Message = "Network plugin is waiting for cni config"
+		} else {
+			networkCondition.Reason = networkNotReadyReason
+			networkCondition.Message = fmt.Sprintf("Network plugin returns error: %v", err)
+		}
 	}
 	return &runtime.StatusResponse{
 		Status: &runtime.RuntimeStatus{Conditions: []*runtime.RuntimeCondition{
 			runtimeCondition,
 			networkCondition,
+			c.getEventMonitorCondition(),
+			c.getKernelFeaturesCondition(),
 		}},
 	}, nil
 }
+
+// getEventMonitorCondition returns the condition of the containerd event
+// monitor, which is not ready if the event stream is lost or events are not
+// handled in time.
+func (c *criContainerdService) getEventMonitorCondition() *runtime.RuntimeCondition {
+	condition := &runtime.RuntimeCondition{
+		Type:   eventMonitorReady,
+		Status: true,
+	}
+	backlog := int64(c.metrics.eventBacklog.Value())
+	connected, since, err := c.eventMonitorStatus.get()
+	switch {
+	case !connected:
+		condition.Status = false
+		condition.Reason = eventStreamDisconnectedReason
+		condition.Message = fmt.Sprintf("Containerd event stream is disconnected since %v", since.Format(time.RFC3339))
+		if err != nil {
+			condition.Message += fmt.Sprintf(": %v", err)
+		}
+	case backlog > maxEventBacklog:
+		condition.Status = false
+		condition.Reason = eventBacklogTooLargeReason
+		condition.Message = fmt.Sprintf("%d containerd events are received but not handled", backlog)
+	default:
+		condition.Message = fmt.Sprintf("Containerd event stream is connected since %v, %d events are not handled",
+			since.Format(time.RFC3339), backlog)
+	}
+	return condition
+}
@@ -27,6 +27,7 @@ import (
 	healthapi "google.golang.org/grpc/health/grpc_health_v1"
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
 	servertesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/testing"
 )
 
@@ -40,6 +41,7 @@ func TestStatus(t *testing.T) {
 
 		expectRuntimeNotReady bool
 		expectNetworkNotReady bool
+		expectNetworkReason   string
 	}{
 		"runtime should not be ready when containerd is not serving": {
 			containerdCheckRes: &healthapi.HealthCheckResponse{
@@ -57,6 +59,15 @@ func TestStatus(t *testing.T) {
 			},
 			networkStatusErr:      errors.New("status error"),
 			expectNetworkNotReady: true,
+			expectNetworkReason:   networkNotReadyReason,
+		},
+		"network should be waiting when network plugin is waiting for config": {
+			containerdCheckRes: &healthapi.HealthCheckResponse{
+				Status: healthapi.HealthCheckResponse_SERVING,
+			},
+			networkStatusErr:      netplugin.ErrWaitingForConfig,
+			expectNetworkNotReady: true,
+			expectNetworkReason:   networkWaitingForConfigReason,
 		},
 		"runtime should be ready when containerd is serving": {
 			containerdCheckRes: &healthapi.HealthCheckResponse{
@@ -79,8 +90,11 @@ func TestStatus(t *testing.T) {
 		resp, err := c.Status(ctx, &runtime.StatusRequest{})
 		assert.NoError(t, err)
 		require.NotNil(t, resp)
+		require.Len(t, resp.Status.Conditions, 4)
 		runtimeCondition := resp.Status.Conditions[0]
 		networkCondition := resp.Status.Conditions[1]
+		assert.Equal(t, eventMonitorReady, resp.Status.Conditions[2].Type)
+		assert.Equal(t, kernelFeaturesReady, resp.Status.Conditions[3].Type)
 		assert.Equal(t, runtime.RuntimeReady, runtimeCondition.Type)
 		assert.Equal(t, test.expectRuntimeNotReady, !runtimeCondition.Status)
 		if test.expectRuntimeNotReady {
@@ -90,8 +104,55 @@ func TestStatus(t *testing.T) {
 		assert.Equal(t, runtime.NetworkReady, networkCondition.Type)
 		assert.Equal(t, test.expectNetworkNotReady, !networkCondition.Status)
 		if test.expectNetworkNotReady {
-			assert.Equal(t, networkNotReadyReason, networkCondition.Reason)
+			assert.Equal(t, test.expectNetworkReason, networkCondition.Reason)
 			assert.NotEmpty(t, networkCondition.Message)
 		}
 	}
 }
+
+func TestEventMonitorCondition(t *testing.T) {
+	for desc, test := range map[string]struct {
+		connected    bool
+		err          error
+		backlog      int64
+		expectReady  bool
+		expectReason string
+	}{
+		"event monitor should not be ready before connected": {
+			expectReason: eventStreamDisconnectedReason,
+		},
+		"event monitor should not be ready when event stream is lost": {
+			connected:    true,
+			err:          errors.New("stream error"),
+			expectReason: eventStreamDisconnectedReason,
+		},
+		"event monitor should not be ready when backlog is too large": {
+			connected:    true,
+			backlog:      maxEventBacklog + 1,
+			expectReason: eventBacklogTooLargeReason,
+		},
+		"event monitor should be ready when connected with small backlog": {
+			connected:   true,
+			backlog:     maxEventBacklog,
+			expectReady: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		if test.connected {
+			c.eventMonitorStatus.setConnected()
+		}
+		if test.err != nil {
+			c.eventMonitorStatus.setDisconnected(test.err)
+		}
+		c.metrics.eventBacklog.Set(test.backlog)
+		condition := c.getEventMonitorCondition()
+		assert.Equal(t, eventMonitorReady, condition.Type)
+		assert.Equal(t, test.expectReady, condition.Status)
+		assert.Equal(t, test.expectReason, condition.Reason)
+		assert.NotEmpty(t, condition.Message)
+		if test.err != nil {
+			assert.Contains(t, condition.Message, test.err.Error())
+		}
+	}
+}
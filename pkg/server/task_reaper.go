@@ -0,0 +1,223 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// shimPidFile is the file in the task state directory containing the pid
+	// of the containerd shim.
+	shimPidFile = "shim.pid"
+	// shimProcessName is the name of the containerd shim binary.
+	shimProcessName = "containerd-shim"
+)
+
+// pendingTaskDelete is a task whose deletion failed and is being retried.
+type pendingTaskDelete struct {
+	// attempts is the number of failed deletion attempts.
+	attempts int
+	// shimKilled is whether the shim of the task has been killed.
+	shimKilled bool
+	// onDeleted are called after the task is deleted.
+	onDeleted []func()
+}
+
+// taskReaper keeps tasks whose deletion failed, e.g. because the shim is
+// wedged, so that their deletion is retried in the background.
+type taskReaper struct {
+	sync.Mutex
+	pending map[string]*pendingTaskDelete
+}
+
+func newTaskReaper() *taskReaper {
+	return &taskReaper{pending: make(map[string]*pendingTaskDelete)}
+}
+
+// enqueue adds the task to be deleted. onDeleted, if not nil, is called after
+// the task is deleted.
+func (r *taskReaper) enqueue(id string, onDeleted func()) {
+	r.Lock()
+	defer r.Unlock()
+	p, ok := r.pending[id]
+	if !ok {
+		p = &pendingTaskDelete{}
+		r.pending[id] = p
+	}
+	if onDeleted != nil {
+		p.onDeleted = append(p.onDeleted, onDeleted)
+	}
+}
+
+// list returns ids of pending tasks.
+func (r *taskReaper) list() []string {
+	r.Lock()
+	defer r.Unlock()
+	var ids []string
+	for id := range r.pending {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// has returns whether the task is pending deletion.
+func (r *taskReaper) has(id string) bool {
+	r.Lock()
+	defer r.Unlock()
+	_, ok := r.pending[id]
+	return ok
+}
+
+// deleteTask deletes the task, and retries the deletion in the background if
+// it fails. onDeleted, if not nil, is called after the task is deleted, either
+// immediately or by the retry. In read-only mode the task is kept, and
+// onDeleted is called immediately.
+func (c *criContainerdService) deleteTask(ctx context.Context, id string, onDeleted func()) error {
+	if c.config.ReadOnly {
+		glog.V(2).Infof("Keep task %q in read-only mode", id)
+		if onDeleted != nil {
+			onDeleted()
+		}
+		return nil
+	}
+	_, err := c.taskService.Delete(ctx, &tasks.DeleteTaskRequest{ContainerID: id})
+	if err != nil && !isContainerdGRPCNotFoundError(err) {
+		c.taskReaper.enqueue(id, onDeleted)
+		return err
+	}
+	if onDeleted != nil {
+		onDeleted()
+	}
+	return nil
+}
+
+// runTaskReaper retries deletion of pending tasks periodically.
+func (c *criContainerdService) runTaskReaper(period time.Duration) {
+	for range time.Tick(period) {
+		c.reapTasks(context.Background())
+	}
+}
+
+// reapTasks retries deletion of all pending tasks. After the deletion of a
+// task fails TaskDeleteRetries times, its shim is killed and the leftover
+// shim directories are removed.
+func (c *criContainerdService) reapTasks(ctx context.Context) {
+	for _, id := range c.taskReaper.list() {
+		_, err := c.taskService.Delete(ctx, &tasks.DeleteTaskRequest{ContainerID: id})
+		c.taskReaper.Lock()
+		p := c.taskReaper.pending[id]
+		if err == nil || isContainerdGRPCNotFoundError(err) {
+			delete(c.taskReaper.pending, id)
+			c.taskReaper.Unlock()
+			glog.V(2).Infof("Deleted task %q after %d failed attempts", id, p.attempts)
+			for _, f := range p.onDeleted {
+				f()
+			}
+			continue
+		}
+		p.attempts++
+		killShim := p.attempts >= c.config.TaskDeleteRetries && !p.shimKilled
+		if killShim {
+			p.shimKilled = true
+		}
+		c.taskReaper.Unlock()
+		glog.Errorf("Failed to delete task %q, attempt %d: %v", id, p.attempts, err)
+		if !killShim {
+			continue
+		}
+		glog.Warningf("Kill shim of task %q as a last resort after %d failed deletions", id, p.attempts)
+		if err := c.killShim(id); err != nil {
+			glog.Errorf("Failed to kill shim of task %q: %v", id, err)
+		}
+		c.cleanupShimDirs(id)
+	}
+}
+
+// getShimStateDir returns the state directory of the shim of the task.
+func (c *criContainerdService) getShimStateDir(id string) string {
+	return filepath.Join(c.config.ContainerdRuntimeStateDir, k8sContainerdNamespace, id)
+}
+
+// killShim kills the shim process of the task with SIGKILL. The process is
+// only killed if it is still a containerd shim, in case the pid is reused.
+func (c *criContainerdService) killShim(id string) error {
+	pidPath := filepath.Join(c.getShimStateDir(id), shimPidFile)
+	data, err := c.os.ReadFile(pidPath)
+	if err != nil {
+		return fmt.Errorf("failed to read shim pid file %q: %v", pidPath, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid shim pid %q in %q: %v", data, pidPath, err)
+	}
+	cmdline, err := c.os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		// The shim has exited.
+		return nil
+	}
+	exe := strings.SplitN(string(cmdline), "\x00", 2)[0]
+	if filepath.Base(exe) != shimProcessName {
+		return fmt.Errorf("process %d is %q instead of shim", pid, exe)
+	}
+	if err := c.os.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to kill shim process %d: %v", pid, err)
+	}
+	return nil
+}
+
+// getShimDirs returns the state and work directories of the shim of the task.
+func (c *criContainerdService) getShimDirs(id string) []string {
+	return []string{
+		c.getShimStateDir(id),
+		filepath.Join(c.config.ContainerdRuntimeRootDir, k8sContainerdNamespace, id),
+	}
+}
+
+// cleanupShimDirs removes the leftover state and work directories of the shim
+// of the task.
+func (c *criContainerdService) cleanupShimDirs(id string) {
+	for _, dir := range c.getShimDirs(id) {
+		if err := c.removeShimDir(dir); err != nil {
+			glog.Errorf("Failed to remove shim directory %q of task %q: %v", dir, id, err)
+		}
+	}
+}
+
+// removeShimDir removes a shim directory. The container rootfs mounted in the
+// directory is unmounted first, and the directory is kept if the rootfs can't
+// be unmounted, so that the container snapshot is not removed through the mount.
+func (c *criContainerdService) removeShimDir(dir string) error {
+	rootfs := filepath.Join(dir, "rootfs")
+	// EINVAL means the rootfs is not mounted.
+	if err := c.os.Unmount(rootfs, unix.MNT_DETACH); err != nil && err != unix.EINVAL && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to unmount rootfs %q, keep the directory: %v", rootfs, err)
+	}
+	return c.os.RemoveAll(dir)
+}
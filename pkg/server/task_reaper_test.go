@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	ostesting "github.com/kubernetes-incubator/cri-containerd/pkg/os/testing"
+)
+
+// deleteTaskService is a fake task service whose Delete fails until it is
+// made to succeed.
+type deleteTaskService struct {
+	tasks.TasksClient
+	err     error
+	deletes int
+}
+
+func (f *deleteTaskService) Delete(_ context.Context, r *tasks.DeleteTaskRequest, _ ...grpc.CallOption) (*tasks.DeleteResponse, error) {
+	f.deletes++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &tasks.DeleteResponse{ID: r.ContainerID}, nil
+}
+
+// newTestTaskReaperService returns a service whose task deletion fails, and
+// whose fake os lists the shim of task "test-id" with pid 100 running the
+// command.
+func newTestTaskReaperService(shimCmdline string) (*criContainerdService, *deleteTaskService, *ostesting.FakeOS) {
+	c := newTestCRIContainerdService()
+	taskService := &deleteTaskService{err: errors.New("shim is wedged")}
+	c.taskService = taskService
+	c.config.TaskDeleteRetries = 2
+	c.config.ContainerdRuntimeStateDir = "/run/containerd/runtime"
+	c.config.ContainerdRuntimeRootDir = "/var/lib/containerd/runtime"
+	fakeOS := c.os.(*ostesting.FakeOS)
+	fakeOS.ReadFileFn = func(path string) ([]byte, error) {
+		switch path {
+		case "/run/containerd/runtime/k8s.io/test-id/shim.pid":
+			return []byte("100"), nil
+		case "/proc/100/cmdline":
+			return []byte(shimCmdline), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return c, taskService, fakeOS
+}
+
+// getCallArgs returns arguments of calls of the fake os function.
+func getCallArgs(fakeOS *ostesting.FakeOS, name string) [][]interface{} {
+	var args [][]interface{}
+	for _, call := range fakeOS.GetCalls() {
+		if call.Name == name {
+			args = append(args, call.Arguments)
+		}
+	}
+	return args
+}
+
+func TestDeleteTask(t *testing.T) {
+	c, taskService, _ := newTestTaskReaperService("")
+	taskService.err = nil
+	deleted := false
+	require.NoError(t, c.deleteTask(context.Background(), "test-id", func() { deleted = true }))
+	assert.True(t, deleted)
+	assert.Empty(t, c.taskReaper.list())
+
+	taskService.err = errors.New("shim is wedged")
+	deleted = false
+	assert.Error(t, c.deleteTask(context.Background(), "test-id", func() { deleted = true }))
+	assert.False(t, deleted)
+	assert.Equal(t, []string{"test-id"}, c.taskReaper.list())
+}
+
+func TestDeleteTaskReadOnly(t *testing.T) {
+	c, taskService, _ := newTestTaskReaperService("")
+	c.config.ReadOnly = true
+	taskService.err = errors.New("should not be called")
+	deleted := false
+	require.NoError(t, c.deleteTask(context.Background(), "test-id", func() { deleted = true }))
+	assert.True(t, deleted)
+	assert.Empty(t, c.taskReaper.list())
+}
+
+func TestReapTasks(t *testing.T) {
+	c, taskService, fakeOS := newTestTaskReaperService("/usr/bin/containerd-shim\x00test-id\x00")
+	deleted := 0
+	c.taskReaper.enqueue("test-id", func() { deleted++ })
+	c.taskReaper.enqueue("test-id", func() { deleted++ })
+
+	t.Logf("should not kill shim before retries are exhausted")
+	c.reapTasks(context.Background())
+	assert.Empty(t, getCallArgs(fakeOS, "Kill"))
+
+	t.Logf("should kill shim and cleanup shim directories after retries are exhausted")
+	c.reapTasks(context.Background())
+	assert.Equal(t, [][]interface{}{{100, syscall.SIGKILL}}, getCallArgs(fakeOS, "Kill"))
+	assert.Equal(t, [][]interface{}{
+		{"/run/containerd/runtime/k8s.io/test-id"},
+		{"/var/lib/containerd/runtime/k8s.io/test-id"},
+	}, getCallArgs(fakeOS, "RemoveAll"))
+
+	t.Logf("should only kill shim once")
+	c.reapTasks(context.Background())
+	assert.Len(t, getCallArgs(fakeOS, "Kill"), 1)
+	assert.Equal(t, 0, deleted)
+
+	t.Logf("should call all callbacks after the task is deleted")
+	taskService.err = nil
+	c.reapTasks(context.Background())
+	assert.Equal(t, 2, deleted)
+	assert.Empty(t, c.taskReaper.list())
+	assert.Equal(t, 4, taskService.deletes)
+}
+
+func TestReapTasksShimPidReused(t *testing.T) {
+	c, _, fakeOS := newTestTaskReaperService("/bin/sh\x00")
+	c.config.TaskDeleteRetries = 1
+	c.taskReaper.enqueue("test-id", nil)
+	c.reapTasks(context.Background())
+	assert.Empty(t, getCallArgs(fakeOS, "Kill"), "should not kill process not being shim")
+}
+
+func TestCleanupShimDirsUnmountFailure(t *testing.T) {
+	c, _, fakeOS := newTestTaskReaperService("")
+	fakeOS.UnmountFn = func(target string, _ int) error {
+		if target == "/run/containerd/runtime/k8s.io/test-id/rootfs" {
+			return syscall.EBUSY
+		}
+		return syscall.EINVAL
+	}
+	c.cleanupShimDirs("test-id")
+	assert.Equal(t, [][]interface{}{
+		{"/var/lib/containerd/runtime/k8s.io/test-id"},
+	}, getCallArgs(fakeOS, "RemoveAll"), "directory with rootfs still mounted should be kept")
+}
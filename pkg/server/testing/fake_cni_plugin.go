@@ -23,7 +23,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/kubernetes-incubator/cri-o/pkg/ocicni"
+	"github.com/kubernetes-incubator/cri-containerd/pkg/netplugin"
 )
 
 // CalledDetail is the struct contains called function name and arguments.
@@ -34,20 +34,14 @@ type CalledDetail struct {
 	Argument interface{}
 }
 
-// CNIPluginArgument is arguments used to call CNI related functions.
-type CNIPluginArgument struct {
-	NetnsPath   string
-	Namespace   string
-	Name        string
-	ContainerID string
-}
-
 // FakeCNIPlugin is a fake plugin used for test.
 type FakeCNIPlugin struct {
 	sync.Mutex
 	called []CalledDetail
 	errors map[string]error
-	IPMap  map[CNIPluginArgument]string
+	IPMap  map[string]string
+	// StatsMap is the network stats of pods, keyed by sandbox id.
+	StatsMap map[string]*netplugin.InterfaceStats
 }
 
 // getError get error for call
@@ -107,19 +101,19 @@ func (f *FakeCNIPlugin) GetCalledDetails() []CalledDetail {
 	return append([]CalledDetail{}, f.called...)
 }
 
-// SetFakePodNetwork sets the given IP for given arguments.
-func (f *FakeCNIPlugin) SetFakePodNetwork(netnsPath string, namespace string, name string, containerID string, ip string) {
+// SetFakePodNetwork sets the given IP for the pod network.
+func (f *FakeCNIPlugin) SetFakePodNetwork(network netplugin.PodNetwork, ip string) {
 	f.Lock()
 	defer f.Unlock()
-	arg := CNIPluginArgument{netnsPath, namespace, name, containerID}
-	f.IPMap[arg] = ip
+	f.IPMap[network.ID] = ip
 }
 
 // NewFakeCNIPlugin create a FakeCNIPlugin.
-func NewFakeCNIPlugin() ocicni.CNIPlugin {
+func NewFakeCNIPlugin() netplugin.CNIPlugin {
 	return &FakeCNIPlugin{
-		errors: make(map[string]error),
-		IPMap:  make(map[CNIPluginArgument]string),
+		errors:   make(map[string]error),
+		IPMap:    make(map[string]string),
+		StatsMap: make(map[string]*netplugin.InterfaceStats),
 	}
 }
 
@@ -129,51 +123,103 @@ func (f *FakeCNIPlugin) Name() string {
 }
 
 // SetUpPod setup the network of PodSandbox.
-func (f *FakeCNIPlugin) SetUpPod(netnsPath string, namespace string, name string, containerID string) error {
+func (f *FakeCNIPlugin) SetUpPod(network netplugin.PodNetwork) error {
 	f.Lock()
 	defer f.Unlock()
-	arg := CNIPluginArgument{netnsPath, namespace, name, containerID}
-	f.appendCalled("SetUpPod", arg)
+	f.appendCalled("SetUpPod", network)
 	if err := f.getError("SetUpPod"); err != nil {
 		return err
 	}
-	f.IPMap[arg] = generateIP()
+	f.IPMap[network.ID] = generateIP()
 	return nil
 }
 
 // TearDownPod teardown the network of PodSandbox.
-func (f *FakeCNIPlugin) TearDownPod(netnsPath string, namespace string, name string, containerID string) error {
+func (f *FakeCNIPlugin) TearDownPod(network netplugin.PodNetwork) error {
 	f.Lock()
 	defer f.Unlock()
-	arg := CNIPluginArgument{netnsPath, namespace, name, containerID}
-	f.appendCalled("TearDownPod", arg)
+	f.appendCalled("TearDownPod", network)
 	if err := f.getError("TearDownPod"); err != nil {
 		return err
 	}
-	_, ok := f.IPMap[arg]
+	_, ok := f.IPMap[network.ID]
 	if !ok {
 		return fmt.Errorf("failed to find the IP")
 	}
-	delete(f.IPMap, arg)
+	delete(f.IPMap, network.ID)
 	return nil
 }
 
-// GetContainerNetworkStatus get the status of network.
-func (f *FakeCNIPlugin) GetContainerNetworkStatus(netnsPath string, namespace string, name string, containerID string) (string, error) {
+// GetPodNetworkStatus get the status of network.
+func (f *FakeCNIPlugin) GetPodNetworkStatus(network netplugin.PodNetwork) (string, error) {
 	f.Lock()
 	defer f.Unlock()
-	arg := CNIPluginArgument{netnsPath, namespace, name, containerID}
-	f.appendCalled("GetContainerNetworkStatus", arg)
-	if err := f.getError("GetContainerNetworkStatus"); err != nil {
+	f.appendCalled("GetPodNetworkStatus", network)
+	if err := f.getError("GetPodNetworkStatus"); err != nil {
 		return "", err
 	}
-	ip, ok := f.IPMap[arg]
+	ip, ok := f.IPMap[network.ID]
 	if !ok {
 		return "", fmt.Errorf("failed to find the IP")
 	}
 	return ip, nil
 }
 
+// GetPodNetworkResult get the network result of PodSandbox.
+func (f *FakeCNIPlugin) GetPodNetworkResult(network netplugin.PodNetwork) (*netplugin.Result, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetPodNetworkResult", network)
+	if err := f.getError("GetPodNetworkResult"); err != nil {
+		return nil, err
+	}
+	ip, ok := f.IPMap[network.ID]
+	if !ok {
+		return nil, nil
+	}
+	return &netplugin.Result{
+		CNIVersion: "0.3.1",
+		Interfaces: []*netplugin.Interface{{Name: netplugin.DefaultInterfaceName, Sandbox: network.NetNS}},
+		IPs:        []*netplugin.IPConfig{{Version: "4", Address: ip + "/32"}},
+	}, nil
+}
+
+// SetFakePodNetworkStats sets the given stats for the pod network.
+func (f *FakeCNIPlugin) SetFakePodNetworkStats(network netplugin.PodNetwork, stats *netplugin.InterfaceStats) {
+	f.Lock()
+	defer f.Unlock()
+	f.StatsMap[network.ID] = stats
+}
+
+// GetPodNetworkStats get the network stats of PodSandbox.
+func (f *FakeCNIPlugin) GetPodNetworkStats(network netplugin.PodNetwork) (*netplugin.InterfaceStats, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("GetPodNetworkStats", network)
+	if err := f.getError("GetPodNetworkStats"); err != nil {
+		return nil, err
+	}
+	stats, ok := f.StatsMap[network.ID]
+	if !ok {
+		return nil, fmt.Errorf("failed to find the stats")
+	}
+	return stats, nil
+}
+
+// CheckPod checks the network of PodSandbox.
+func (f *FakeCNIPlugin) CheckPod(network netplugin.PodNetwork) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("CheckPod", network)
+	if err := f.getError("CheckPod"); err != nil {
+		return err
+	}
+	if _, ok := f.IPMap[network.ID]; !ok {
+		return fmt.Errorf("failed to find the IP")
+	}
+	return nil
+}
+
 // Status get the status of the plugin.
 func (f *FakeCNIPlugin) Status() error {
 	f.Lock()
@@ -182,6 +228,14 @@ func (f *FakeCNIPlugin) Status() error {
 	return f.getError("Status")
 }
 
+// UpdatePodCIDR updates the pod cidr of the plugin.
+func (f *FakeCNIPlugin) UpdatePodCIDR(cidr string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.appendCalled("UpdatePodCIDR", cidr)
+	return f.getError("UpdatePodCIDR")
+}
+
 func generateIP() string {
 	rand.Seed(time.Now().Unix())
 	p1 := strconv.Itoa(rand.Intn(266))
@@ -17,13 +17,24 @@ limitations under the License.
 package server
 
 import (
+	"fmt"
+
+	"github.com/golang/glog"
 	"golang.org/x/net/context"
 
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
 )
 
-// UpdateRuntimeConfig updates the runtime config. Currently only handles podCIDR updates.
-// TODO(random-liu): Figure out how to handle pod cidr in cri-containerd.
+// UpdateRuntimeConfig updates the runtime config. Currently only handles podCIDR updates,
+// which are forwarded to the network plugin.
 func (c *criContainerdService) UpdateRuntimeConfig(ctx context.Context, r *runtime.UpdateRuntimeConfigRequest) (*runtime.UpdateRuntimeConfigResponse, error) {
+	cidr := r.GetRuntimeConfig().GetNetworkConfig().GetPodCidr()
+	if cidr == "" {
+		return &runtime.UpdateRuntimeConfigResponse{}, nil
+	}
+	glog.V(4).Infof("UpdateRuntimeConfig with pod cidr %q", cidr)
+	if err := c.netPlugin.UpdatePodCIDR(cidr); err != nil {
+		return nil, fmt.Errorf("failed to update pod cidr %q: %v", cidr, err)
+	}
 	return &runtime.UpdateRuntimeConfigResponse{}, nil
 }
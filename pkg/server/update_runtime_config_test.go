@@ -0,0 +1,65 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	servertesting "github.com/kubernetes-incubator/cri-containerd/pkg/server/testing"
+)
+
+func TestUpdateRuntimeConfig(t *testing.T) {
+	for desc, test := range map[string]struct {
+		podCIDR     string
+		injectErr   error
+		expectCalls []servertesting.CalledDetail
+		expectErr   bool
+	}{
+		"empty pod cidr should not be forwarded": {
+			expectCalls: []servertesting.CalledDetail{},
+		},
+		"pod cidr should be forwarded to the network plugin": {
+			podCIDR:     "10.0.0.0/24",
+			expectCalls: []servertesting.CalledDetail{{Name: "UpdatePodCIDR", Argument: "10.0.0.0/24"}},
+		},
+		"network plugin error should be returned": {
+			podCIDR:     "10.0.0.0/24",
+			injectErr:   errors.New("update error"),
+			expectCalls: []servertesting.CalledDetail{{Name: "UpdatePodCIDR", Argument: "10.0.0.0/24"}},
+			expectErr:   true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		fakeCNIPlugin := c.netPlugin.(*servertesting.FakeCNIPlugin)
+		if test.injectErr != nil {
+			fakeCNIPlugin.InjectError("UpdatePodCIDR", test.injectErr)
+		}
+		_, err := c.UpdateRuntimeConfig(context.Background(), &runtime.UpdateRuntimeConfigRequest{
+			RuntimeConfig: &runtime.RuntimeConfig{
+				NetworkConfig: &runtime.NetworkConfig{PodCidr: test.podCIDR},
+			},
+		})
+		assert.Equal(t, test.expectErr, err != nil)
+		assert.Equal(t, test.expectCalls, fakeCNIPlugin.GetCalledDetails())
+	}
+}
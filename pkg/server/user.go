@@ -0,0 +1,262 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// passwdFile is the path of the passwd file in the image.
+	passwdFile = "/etc/passwd"
+	// groupFile is the path of the group file in the image.
+	groupFile = "/etc/group"
+	// userLookupDir is the directory in the container root directory where the
+	// container rootfs is mounted temporarily to look up users and groups.
+	userLookupDir = "user-lookup"
+)
+
+// passwdEntry is an entry in the passwd file.
+type passwdEntry struct {
+	name string
+	uid  uint32
+	gid  uint32
+}
+
+// groupEntry is an entry in the group file.
+type groupEntry struct {
+	name    string
+	gid     uint32
+	members []string
+}
+
+// parsePasswd parses the passwd file. Malformed lines are skipped.
+func parsePasswd(data []byte) []passwdEntry {
+	var entries []passwdEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		gid, err := strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uint32(uid), gid: uint32(gid)})
+	}
+	return entries
+}
+
+// parseGroup parses the group file. Malformed lines are skipped.
+func parseGroup(data []byte) []groupEntry {
+	var entries []groupEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// name:password:gid:member1,member2
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		gid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		entry := groupEntry{name: fields[0], gid: uint32(gid)}
+		if len(fields) > 3 && fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// getUserSpec returns the user and group requested for the container. Security
+// context overrides the image config. The image config user is in the format of
+// "user", "uid", "user:group" or "uid:gid".
+func getUserSpec(securityContext *runtime.LinuxContainerSecurityContext, imageConfig *imagespec.ImageConfig) (string, string) {
+	if securityContext.GetRunAsUser() != nil {
+		return strconv.FormatInt(securityContext.GetRunAsUser().GetValue(), 10), ""
+	}
+	if securityContext.GetRunAsUsername() != "" {
+		return securityContext.GetRunAsUsername(), ""
+	}
+	parts := strings.SplitN(imageConfig.User, ":", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// resolveUser resolves the user and group spec into uid, gid and additional gids
+// with the passwd and group file content of the image. Numeric user and group don't
+// need to exist in the image.
+func resolveUser(userSpec, groupSpec string, passwd, group []byte) (uint32, uint32, []uint32, error) {
+	users := parsePasswd(passwd)
+	groups := parseGroup(group)
+
+	var uid, gid uint32
+	var name string
+	if id, err := strconv.ParseUint(userSpec, 10, 32); err == nil {
+		uid = uint32(id)
+		for _, u := range users {
+			if u.uid == uid {
+				name, gid = u.name, u.gid
+				break
+			}
+		}
+	} else {
+		found := false
+		for _, u := range users {
+			if u.name == userSpec {
+				name, uid, gid, found = u.name, u.uid, u.gid, true
+				break
+			}
+		}
+		if !found {
+			return 0, 0, nil, fmt.Errorf("user %q not found in %s of the image", userSpec, passwdFile)
+		}
+	}
+
+	if groupSpec != "" {
+		if id, err := strconv.ParseUint(groupSpec, 10, 32); err == nil {
+			gid = uint32(id)
+		} else {
+			found := false
+			for _, g := range groups {
+				if g.name == groupSpec {
+					gid, found = g.gid, true
+					break
+				}
+			}
+			if !found {
+				return 0, 0, nil, fmt.Errorf("group %q not found in %s of the image", groupSpec, groupFile)
+			}
+		}
+	}
+
+	var additionalGids []uint32
+	if name != "" {
+		for _, g := range groups {
+			if g.gid == gid {
+				continue
+			}
+			if inStringSlice(g.members, name) {
+				additionalGids = append(additionalGids, g.gid)
+			}
+		}
+	}
+	return uid, gid, additionalGids, nil
+}
+
+// setOCIUser sets the user, group and additional groups of the container process.
+// Users and groups are looked up in the container rootfs, so it should be called
+// after the container snapshot is prepared. Configured device access gids are added
+// if the container has devices, so that non-root users could access the devices.
+func (c *criContainerdService) setOCIUser(ctx context.Context, spec *runtimespec.Spec, id string,
+	config *runtime.ContainerConfig, imageConfig *imagespec.ImageConfig) error {
+	securityContext := config.GetLinux().GetSecurityContext()
+	userSpec, groupSpec := getUserSpec(securityContext, imageConfig)
+	if userSpec != "" {
+		passwd, group, err := c.readUserFiles(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to read user files from rootfs: %v", err)
+		}
+		uid, gid, additionalGids, err := resolveUser(userSpec, groupSpec, passwd, group)
+		if err != nil {
+			return err
+		}
+		spec.Process.User.UID = uid
+		spec.Process.User.GID = gid
+		for _, g := range additionalGids {
+			addAdditionalGid(spec, g)
+		}
+	}
+	if len(config.GetDevices()) > 0 {
+		for _, g := range c.config.DeviceAccessGids {
+			addAdditionalGid(spec, uint32(g))
+		}
+	}
+	return nil
+}
+
+// addAdditionalGid adds an additional gid to the process if it doesn't exist.
+func addAdditionalGid(spec *runtimespec.Spec, gid uint32) {
+	for _, g := range spec.Process.User.AdditionalGids {
+		if g == gid {
+			return
+		}
+	}
+	spec.Process.User.AdditionalGids = append(spec.Process.User.AdditionalGids, gid)
+}
+
+// readUserFiles mounts the container rootfs readonly temporarily, and reads the passwd
+// and group files. Missing files are returned as empty.
+func (c *criContainerdService) readUserFiles(ctx context.Context, id string) ([]byte, []byte, error) {
+	mounts, err := c.snapshotService.Mounts(ctx, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get rootfs mounts: %v", err)
+	}
+	for i := range mounts {
+		mounts[i].Options = append(mounts[i].Options, "ro")
+	}
+	root := filepath.Join(getContainerRootDir(c.rootDir, id), userLookupDir)
+	if err := c.os.MkdirAll(root, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create directory %q: %v", root, err)
+	}
+	if err := c.os.MountAll(mounts, root); err != nil {
+		if err := c.os.RemoveAll(root); err != nil {
+			glog.Errorf("Failed to remove directory %q: %v", root, err)
+		}
+		return nil, nil, fmt.Errorf("failed to mount rootfs to %q: %v", root, err)
+	}
+	defer func() {
+		if err := c.os.Unmount(root, 0); err != nil {
+			// Do not remove the directory, the rootfs is still mounted.
+			glog.Errorf("Failed to unmount %q: %v", root, err)
+			return
+		}
+		if err := c.os.RemoveAll(root); err != nil {
+			glog.Errorf("Failed to remove directory %q: %v", root, err)
+		}
+	}()
+	var data [2][]byte
+	for i, f := range []string{passwdFile, groupFile} {
+		data[i], err = c.os.ReadFileInRoot(root, f)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, fmt.Errorf("failed to read %q: %v", f, err)
+		}
+	}
+	return data[0], data[1], nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"testing"
+
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func TestGetUserSpec(t *testing.T) {
+	for desc, test := range map[string]struct {
+		securityContext *runtime.LinuxContainerSecurityContext
+		imageUser       string
+		expectUser      string
+		expectGroup     string
+	}{
+		"should use image user by default": {
+			imageUser:   "test-user:test-group",
+			expectUser:  "test-user",
+			expectGroup: "test-group",
+		},
+		"should use image user without group": {
+			imageUser:  "1000",
+			expectUser: "1000",
+		},
+		"run as user should override image user": {
+			securityContext: &runtime.LinuxContainerSecurityContext{RunAsUser: &runtime.Int64Value{Value: 2000}},
+			imageUser:       "test-user:test-group",
+			expectUser:      "2000",
+		},
+		"run as username should override image user": {
+			securityContext: &runtime.LinuxContainerSecurityContext{RunAsUsername: "other-user"},
+			imageUser:       "test-user:test-group",
+			expectUser:      "other-user",
+		},
+		"should return empty user if not specified": {},
+	} {
+		t.Logf("TestCase %q", desc)
+		user, group := getUserSpec(test.securityContext, &imagespec.ImageConfig{User: test.imageUser})
+		assert.Equal(t, test.expectUser, user)
+		assert.Equal(t, test.expectGroup, group)
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	passwd := []byte(`root:x:0:0:root:/root:/bin/sh
+test-user:x:1000:1000::/home/test-user:/bin/sh
+malformed-line
+`)
+	group := []byte(`root:x:0:
+test-user:x:1000:
+audio:x:29:test-user
+video:x:44:other-user,test-user
+other:x:50:other-user
+`)
+	for desc, test := range map[string]struct {
+		user                 string
+		group                string
+		expectErr            bool
+		expectUID            uint32
+		expectGID            uint32
+		expectAdditionalGids []uint32
+	}{
+		"should resolve user name": {
+			user:                 "test-user",
+			expectUID:            1000,
+			expectGID:            1000,
+			expectAdditionalGids: []uint32{29, 44},
+		},
+		"should resolve uid": {
+			user:                 "1000",
+			expectUID:            1000,
+			expectGID:            1000,
+			expectAdditionalGids: []uint32{29, 44},
+		},
+		"should resolve group name": {
+			user:                 "test-user",
+			group:                "audio",
+			expectUID:            1000,
+			expectGID:            29,
+			expectAdditionalGids: []uint32{44},
+		},
+		"should allow uid and gid not in the image": {
+			user:      "2000",
+			group:     "3000",
+			expectUID: 2000,
+			expectGID: 3000,
+		},
+		"should return error for unknown user name": {
+			user:      "unknown",
+			expectErr: true,
+		},
+		"should return error for unknown group name": {
+			user:      "test-user",
+			group:     "unknown",
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		uid, gid, additionalGids, err := resolveUser(test.user, test.group, passwd, group)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expectUID, uid)
+		assert.Equal(t, test.expectGID, gid)
+		assert.Equal(t, test.expectAdditionalGids, additionalGids)
+	}
+}
+
+func TestSetOCIUserDeviceAccessGids(t *testing.T) {
+	for desc, test := range map[string]struct {
+		devices              []*runtime.Device
+		expectAdditionalGids []uint32
+	}{
+		"should add device access gids for container with devices": {
+			devices:              []*runtime.Device{{HostPath: "/dev/snd"}},
+			expectAdditionalGids: []uint32{1111, 29, 44},
+		},
+		"should not add device access gids for container without devices": {
+			expectAdditionalGids: []uint32{1111},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		c := newTestCRIContainerdService()
+		c.config.DeviceAccessGids = []uint{29, 44, 1111}
+		g := generate.New()
+		g.AddProcessAdditionalGid(1111)
+		spec := g.Spec()
+		config := &runtime.ContainerConfig{Devices: test.devices}
+		assert.NoError(t, c.setOCIUser(context.Background(), spec, "test-id", config, &imagespec.ImageConfig{}))
+		assert.Equal(t, test.expectAdditionalGids, spec.Process.User.AdditionalGids)
+	}
+}
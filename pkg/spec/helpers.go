@@ -0,0 +1,280 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/runc/libcontainer/devices"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+// setOCIProcessArgs sets process args. It returns error if the final arg list
+// is empty.
+func setOCIProcessArgs(g *generate.Generator, config *runtime.ContainerConfig, imageConfig *imagespec.ImageConfig) error {
+	command, args := config.GetCommand(), config.GetArgs()
+	// The following logic is migrated from https://github.com/moby/moby/blob/master/daemon/commit.go
+	// TODO(random-liu): Clearly define the commands overwrite behavior. (moved from pkg/server/container_create.go)
+	if len(command) == 0 {
+		if len(args) == 0 {
+			args = imageConfig.Cmd
+		}
+		if command == nil {
+			command = imageConfig.Entrypoint
+		}
+	}
+	if len(command) == 0 && len(args) == 0 {
+		return fmt.Errorf("no command specified")
+	}
+	g.SetProcessArgs(append(command, args...))
+	return nil
+}
+
+// addImageEnvs adds environment variables from image config. It returns error if
+// an invalid environment variable is encountered.
+func addImageEnvs(g *generate.Generator, imageEnvs []string) error {
+	for _, e := range imageEnvs {
+		kv := strings.Split(e, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid environment variable %q", e)
+		}
+		g.AddProcessEnv(kv[0], kv[1])
+	}
+	return nil
+}
+
+func clearReadOnly(m *runtimespec.Mount) {
+	var opt []string
+	for _, o := range m.Options {
+		if o != "ro" {
+			opt = append(opt, o)
+		}
+	}
+	m.Options = opt
+}
+
+// addDevices set device mapping. All host devices are added if hostDevices is true.
+func addOCIDevices(g *generate.Generator, devs []*runtime.Device, hostDevices bool) error {
+	spec := g.Spec()
+	if hostDevices {
+		hostDevices, err := devices.HostDevices()
+		if err != nil {
+			return err
+		}
+		for _, hostDevice := range hostDevices {
+			rd := runtimespec.LinuxDevice{
+				Path:  hostDevice.Path,
+				Type:  string(hostDevice.Type),
+				Major: hostDevice.Major,
+				Minor: hostDevice.Minor,
+				UID:   &hostDevice.Uid,
+				GID:   &hostDevice.Gid,
+			}
+			g.AddDevice(rd)
+		}
+		spec.Linux.Resources.Devices = []runtimespec.LinuxDeviceCgroup{
+			{
+				Allow:  true,
+				Access: "rwm",
+			},
+		}
+		return nil
+	}
+	for _, device := range devs {
+		dev, err := devices.DeviceFromPath(device.HostPath, device.Permissions)
+		if err != nil {
+			return err
+		}
+		rd := runtimespec.LinuxDevice{
+			Path:  device.ContainerPath,
+			Type:  string(dev.Type),
+			Major: dev.Major,
+			Minor: dev.Minor,
+			UID:   &dev.Uid,
+			GID:   &dev.Gid,
+		}
+		g.AddDevice(rd)
+		spec.Linux.Resources.Devices = append(spec.Linux.Resources.Devices, runtimespec.LinuxDeviceCgroup{
+			Allow:  true,
+			Type:   string(dev.Type),
+			Major:  &dev.Major,
+			Minor:  &dev.Minor,
+			Access: dev.Permissions,
+		})
+	}
+	return nil
+}
+
+// addOCIBindMounts adds bind mounts.
+// TODO(random-liu): Figure out whether we need to change all CRI mounts to readonly when
+// rootfs is readonly. (https://github.com/moby/moby/blob/master/daemon/oci_linux.go) (moved from pkg/server/container_create.go)
+func addOCIBindMounts(g *generate.Generator, mounts []*runtime.Mount, privileged bool) {
+	// Mount cgroup into the container as readonly, which inherits docker's behavior.
+	g.AddCgroupsMount("ro") // nolint: errcheck
+	for _, mount := range mounts {
+		dst := mount.GetContainerPath()
+		src := mount.GetHostPath()
+		options := []string{"rw"}
+		if mount.GetReadonly() {
+			options = []string{"ro"}
+		}
+		// TODO(random-liu): [P1] Apply selinux label (moved from pkg/server/container_create.go)
+		g.AddBindMount(src, dst, options)
+	}
+	if !privileged {
+		return
+	}
+	spec := g.Spec()
+	// clear readonly for /sys and cgroup
+	for i, m := range spec.Mounts {
+		if spec.Mounts[i].Destination == "/sys" && !spec.Root.Readonly {
+			clearReadOnly(&spec.Mounts[i])
+		}
+		if m.Type == "cgroup" {
+			clearReadOnly(&spec.Mounts[i])
+		}
+	}
+	spec.Linux.ReadonlyPaths = nil
+	spec.Linux.MaskedPaths = nil
+}
+
+// setOCILinuxResource set container resource limit. Cpu shares and oom score
+// adj omitted by the kubelet, e.g. for best-effort pods, are set to the
+// defaults if they are not 0, so that they don't depend on the runtime.
+func setOCILinuxResource(g *generate.Generator, resources *runtime.LinuxContainerResources, defaultCPUShares, defaultOOMScoreAdj int64) {
+	if resources != nil {
+		g.SetLinuxResourcesCPUPeriod(uint64(resources.GetCpuPeriod()))
+		g.SetLinuxResourcesCPUQuota(resources.GetCpuQuota())
+		g.SetLinuxResourcesMemoryLimit(resources.GetMemoryLimitInBytes())
+	}
+	cpuShares := resources.GetCpuShares()
+	if cpuShares == 0 {
+		cpuShares = defaultCPUShares
+	}
+	if resources != nil || cpuShares != 0 {
+		g.SetLinuxResourcesCPUShares(uint64(cpuShares))
+	}
+	oomScoreAdj := resources.GetOomScoreAdj()
+	if oomScoreAdj == 0 {
+		oomScoreAdj = defaultOOMScoreAdj
+	}
+	if resources != nil || oomScoreAdj != 0 {
+		g.SetProcessOOMScoreAdj(int(oomScoreAdj))
+	}
+}
+
+// setOCIProcMount masks sensitive paths in /proc and /sys, which inherits docker's
+// behavior. The masking is skipped if unmasked proc mount is requested and allowed for
+// the namespace of the pod, e.g. for nested container builders.
+func setOCIProcMount(g *generate.Generator, procMount, namespace string, allowedNamespaces []string) error {
+	switch procMount {
+	case "", DefaultProcMount:
+	case UnmaskedProcMount:
+		if !inStringSlice(allowedNamespaces, namespace) {
+			return fmt.Errorf("unmasked proc mount is not allowed in namespace %q", namespace)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported proc mount type %q", procMount)
+	}
+	for _, p := range defaultMaskedPaths {
+		g.AddLinuxMaskedPaths(p)
+	}
+	for _, p := range defaultReadonlyPaths {
+		g.AddLinuxReadonlyPaths(p)
+	}
+	return nil
+}
+
+// setOCICapabilities adds/drops process capabilities.
+func setOCICapabilities(g *generate.Generator, capabilities *runtime.Capability, privileged bool) error {
+	if privileged {
+		// Add all capabilities in privileged mode.
+		g.SetupPrivileged(true)
+		return nil
+	}
+	if capabilities == nil {
+		return nil
+	}
+
+	// Capabilities in CRI doesn't have `CAP_` prefix, so add it.
+	for _, c := range capabilities.GetAddCapabilities() {
+		if err := g.AddProcessCapability("CAP_" + c); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range capabilities.GetDropCapabilities() {
+		if err := g.DropProcessCapability("CAP_" + c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setOCINamespaces sets namespaces.
+func setOCINamespaces(g *generate.Generator, namespaces *runtime.NamespaceOption, sandboxPid uint32) {
+	g.AddOrReplaceLinuxNamespace(string(runtimespec.NetworkNamespace), NamespacePath(sandboxPid, runtimespec.NetworkNamespace)) // nolint: errcheck
+	// Join the host ipc namespace directly instead of through the sandbox, so that
+	// host /dev/mqueue could be mounted.
+	if namespaces.GetHostIpc() {
+		setOCIHostIPC(g)
+	} else {
+		g.AddOrReplaceLinuxNamespace(string(runtimespec.IPCNamespace), NamespacePath(sandboxPid, runtimespec.IPCNamespace)) // nolint: errcheck
+	}
+	// Host network pods use the host uts namespace, so that the hostname is the node name.
+	if namespaces.GetHostNetwork() {
+		g.RemoveLinuxNamespace(string(runtimespec.UTSNamespace)) // nolint: errcheck
+	} else {
+		g.AddOrReplaceLinuxNamespace(string(runtimespec.UTSNamespace), NamespacePath(sandboxPid, runtimespec.UTSNamespace)) // nolint: errcheck
+	}
+	// The hostname is set by the sandbox container in the shared uts namespace, containers
+	// must not override it.
+	g.SetHostname("")
+	g.AddOrReplaceLinuxNamespace(string(runtimespec.PIDNamespace), NamespacePath(sandboxPid, runtimespec.PIDNamespace)) // nolint: errcheck
+}
+
+// setOCIHostIPC makes the container use the host ipc namespace. The mqueue mount is
+// replaced with a bind mount of host /dev/mqueue, so that posix message queues of the
+// host are visible even if mqueue can't be mounted in the container.
+func setOCIHostIPC(g *generate.Generator) {
+	g.RemoveLinuxNamespace(string(runtimespec.IPCNamespace)) // nolint: errcheck
+	spec := g.Spec()
+	var mounts []runtimespec.Mount
+	for _, m := range spec.Mounts {
+		if m.Destination == devMqueue && m.Type == "mqueue" {
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	spec.Mounts = mounts
+	g.AddBindMount(devMqueue, devMqueue, []string{"rw"})
+}
+
+// inStringSlice checks whether a string is inside a string slice.
+func inStringSlice(ss []string, str string) bool {
+	for _, s := range ss {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
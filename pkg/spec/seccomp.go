@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	goruntime "runtime"
+
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/opencontainers/runtime-tools/generate/seccomp"
+)
+
+// SeccompProfile is a seccomp profile resolved on the node.
+type SeccompProfile struct {
+	// Data is a docker compatible seccomp profile. Empty means the built-in
+	// default profile.
+	Data []byte
+}
+
+// seccompNativeArches maps go architectures to seccomp architectures.
+var seccompNativeArches = map[string]runtimespec.Arch{
+	"386":         runtimespec.ArchX86,
+	"amd64":       runtimespec.ArchX86_64,
+	"arm":         runtimespec.ArchARM,
+	"arm64":       runtimespec.ArchAARCH64,
+	"mips64":      runtimespec.ArchMIPS64,
+	"mips64n32":   runtimespec.ArchMIPS64N32,
+	"mipsel64":    runtimespec.ArchMIPSEL64,
+	"mipsel64n32": runtimespec.ArchMIPSEL64N32,
+	"ppc64":       runtimespec.ArchPPC64,
+	"ppc64le":     runtimespec.ArchPPC64LE,
+	"s390x":       runtimespec.ArchS390X,
+}
+
+// seccompProfile is a docker compatible seccomp profile.
+type seccompProfile struct {
+	DefaultAction runtimespec.LinuxSeccompAction `json:"defaultAction"`
+	// Architectures is mutually exclusive with ArchMap.
+	Architectures []runtimespec.Arch `json:"architectures"`
+	ArchMap       []seccompArchMap   `json:"archMap"`
+	Syscalls      []seccompSyscall   `json:"syscalls"`
+}
+
+// seccompArchMap maps an architecture to its sub architectures.
+type seccompArchMap struct {
+	Arch      runtimespec.Arch   `json:"architecture"`
+	SubArches []runtimespec.Arch `json:"subArchitectures"`
+}
+
+// seccompSyscall is a seccomp rule which only applies when its filters match.
+type seccompSyscall struct {
+	Name     string                         `json:"name"`
+	Names    []string                       `json:"names"`
+	Action   runtimespec.LinuxSeccompAction `json:"action"`
+	Args     []runtimespec.LinuxSeccompArg  `json:"args"`
+	Includes seccompFilter                  `json:"includes"`
+	Excludes seccompFilter                  `json:"excludes"`
+}
+
+// seccompFilter filters seccomp rules with architectures and capabilities.
+type seccompFilter struct {
+	Arches []string `json:"arches"`
+	Caps   []string `json:"caps"`
+}
+
+// setOCISeccomp sets the seccomp profile of the spec. It should be called after
+// capabilities are set, because the profile depends on capabilities.
+func setOCISeccomp(g *generate.Generator, profile *SeccompProfile) error {
+	spec := g.Spec()
+	if profile == nil {
+		spec.Linux.Seccomp = nil
+		return nil
+	}
+	if len(profile.Data) == 0 {
+		spec.Linux.Seccomp = seccomp.DefaultProfile(spec)
+		return nil
+	}
+	s, err := parseSeccompProfile(profile.Data, spec)
+	if err != nil {
+		return err
+	}
+	spec.Linux.Seccomp = s
+	return nil
+}
+
+// parseSeccompProfile parses a docker compatible seccomp profile, and generates the
+// oci seccomp config for the native architecture and the capabilities of the spec.
+func parseSeccompProfile(data []byte, spec *runtimespec.Spec) (*runtimespec.LinuxSeccomp, error) {
+	var profile seccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal seccomp profile: %v", err)
+	}
+	if len(profile.Architectures) != 0 && len(profile.ArchMap) != 0 {
+		return nil, fmt.Errorf("architectures and archMap are mutually exclusive")
+	}
+	s := &runtimespec.LinuxSeccomp{
+		DefaultAction: profile.DefaultAction,
+		Architectures: profile.Architectures,
+	}
+	nativeArch := seccompNativeArches[goruntime.GOARCH]
+	for _, a := range profile.ArchMap {
+		if a.Arch == nativeArch {
+			s.Architectures = append(s.Architectures, a.Arch)
+			s.Architectures = append(s.Architectures, a.SubArches...)
+		}
+	}
+
+	var caps []string
+	if spec.Process != nil && spec.Process.Capabilities != nil {
+		caps = spec.Process.Capabilities.Bounding
+	}
+	for _, call := range profile.Syscalls {
+		if !call.Excludes.match(goruntime.GOARCH, caps, false) ||
+			!call.Includes.match(goruntime.GOARCH, caps, true) {
+			continue
+		}
+		if call.Name != "" && len(call.Names) != 0 {
+			return nil, fmt.Errorf("name and names are mutually exclusive in syscall %q", call.Name)
+		}
+		names := call.Names
+		if call.Name != "" {
+			names = []string{call.Name}
+		}
+		s.Syscalls = append(s.Syscalls, runtimespec.LinuxSyscall{
+			Names:  names,
+			Action: call.Action,
+			Args:   call.Args,
+		})
+	}
+	return s, nil
+}
+
+// match returns whether the architecture and capabilities pass the filter. An include
+// filter requires the architecture to be listed and all capabilities to be present; an
+// exclude filter requires the architecture not to be listed and no capability present.
+func (f seccompFilter) match(arch string, caps []string, include bool) bool {
+	if len(f.Arches) != 0 && inStringSlice(f.Arches, arch) != include {
+		return false
+	}
+	for _, c := range f.Caps {
+		if inStringSlice(caps, c) != include {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spec
+
+import (
+	"io/ioutil"
+	goruntime "runtime"
+	"testing"
+
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSeccompProfile(t *testing.T) {
+	profile := `{
+	"defaultAction": "SCMP_ACT_ERRNO",
+	"archMap": [
+		{"architecture": "SCMP_ARCH_X86_64", "subArchitectures": ["SCMP_ARCH_X86"]},
+		{"architecture": "SCMP_ARCH_AARCH64", "subArchitectures": ["SCMP_ARCH_ARM"]}
+	],
+	"syscalls": [
+		{"names": ["read"], "action": "SCMP_ACT_ALLOW"},
+		{"name": "write", "action": "SCMP_ACT_ALLOW"},
+		{"names": ["mount"], "action": "SCMP_ACT_ALLOW", "includes": {"caps": ["CAP_SYS_ADMIN"]}},
+		{"names": ["clone"], "action": "SCMP_ACT_ALLOW", "excludes": {"caps": ["CAP_SYS_ADMIN"]}},
+		{"names": ["arch_prctl"], "action": "SCMP_ACT_ALLOW", "includes": {"arches": ["amd64"]}},
+		{"names": ["set_tls"], "action": "SCMP_ACT_ALLOW", "excludes": {"arches": ["amd64"]}}
+	]
+}`
+	names := func(s *runtimespec.LinuxSeccomp) []string {
+		var names []string
+		for _, call := range s.Syscalls {
+			names = append(names, call.Names...)
+		}
+		return names
+	}
+	archSyscall := "set_tls"
+	if goruntime.GOARCH == "amd64" {
+		archSyscall = "arch_prctl"
+	}
+	for desc, test := range map[string]struct {
+		caps     []string
+		expected []string
+	}{
+		"should exclude syscalls requiring missing capabilities": {
+			expected: []string{"read", "write", "clone", archSyscall},
+		},
+		"should include syscalls requiring present capabilities": {
+			caps:     []string{"CAP_SYS_ADMIN"},
+			expected: []string{"read", "write", "mount", archSyscall},
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		spec := &runtimespec.Spec{Process: &runtimespec.Process{
+			Capabilities: &runtimespec.LinuxCapabilities{Bounding: test.caps},
+		}}
+		s, err := parseSeccompProfile([]byte(profile), spec)
+		require.NoError(t, err)
+		assert.Equal(t, runtimespec.ActErrno, s.DefaultAction)
+		assert.Equal(t, test.expected, names(s))
+		if goruntime.GOARCH == "amd64" {
+			assert.Equal(t, []runtimespec.Arch{runtimespec.ArchX86_64, runtimespec.ArchX86}, s.Architectures)
+		}
+	}
+
+	t.Logf("should reject both architectures and archMap")
+	_, err := parseSeccompProfile([]byte(`{"architectures": ["SCMP_ARCH_X86"], "archMap": [{"architecture": "SCMP_ARCH_X86_64"}]}`), &runtimespec.Spec{})
+	assert.Error(t, err)
+
+	t.Logf("should be able to parse shipped default profile")
+	data, err := ioutil.ReadFile("../../contrib/seccomp/seccomp_default.json")
+	require.NoError(t, err)
+	g := generate.New()
+	s, err := parseSeccompProfile(data, g.Spec())
+	require.NoError(t, err)
+	assert.Contains(t, names(s), "read")
+	assert.NotContains(t, names(s), "mount")
+}
+
+func TestSetOCISeccomp(t *testing.T) {
+	for desc, test := range map[string]struct {
+		profile       *SeccompProfile
+		expectErr     bool
+		expectSeccomp bool
+	}{
+		"should not set seccomp for nil profile": {},
+		"should set built-in default profile for empty profile": {
+			profile:       &SeccompProfile{},
+			expectSeccomp: true,
+		},
+		"should set profile data": {
+			profile:       &SeccompProfile{Data: []byte(`{"defaultAction": "SCMP_ACT_ERRNO"}`)},
+			expectSeccomp: true,
+		},
+		"should return error for invalid profile data": {
+			profile:   &SeccompProfile{Data: []byte("invalid")},
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		g := generate.New()
+		err := setOCISeccomp(&g, test.profile)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, test.expectSeccomp, g.Spec().Linux.Seccomp != nil)
+	}
+}
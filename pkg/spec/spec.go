@@ -0,0 +1,374 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package spec generates oci runtime specs of sandbox and application
+// containers from CRI configs. Generation doesn't depend on the state of the
+// runtime, so that admission tooling and tests could validate the spec that
+// would run on the node. Node level settings and profiles resolved on the node
+// are passed in explicitly, and users and groups, which are looked up in the
+// container rootfs, are set by the runtime afterwards.
+package spec
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+const (
+	// RelativeRootfsPath is the rootfs path relative to bundle path.
+	RelativeRootfsPath = "rootfs"
+	// ProcMountAnnotationKey is the container annotation key to request the proc
+	// mount type.
+	ProcMountAnnotationKey = "io.kubernetes.cri-containerd.proc-mount"
+	// DefaultProcMount is the proc mount type which masks sensitive paths.
+	DefaultProcMount = "Default"
+	// UnmaskedProcMount is the proc mount type which doesn't mask any path.
+	UnmaskedProcMount = "Unmasked"
+	// DefaultPathAnnotationKey is the pod annotation key to override the PATH
+	// of containers in the pod which neither the image nor the container config
+	// sets.
+	DefaultPathAnnotationKey = "io.kubernetes.cri-containerd.default-path"
+
+	// defaultSandboxOOMAdj is default omm adj for sandbox container. (kubernetes#47938).
+	defaultSandboxOOMAdj = -998
+	// defaultSandboxCPUshares is default cpu shares for sandbox container.
+	defaultSandboxCPUshares = 2
+	// devMqueue is the default path of /dev/mqueue.
+	devMqueue = "/dev/mqueue"
+	// etcLocaltime is the default path of /etc/localtime file.
+	etcLocaltime = "/etc/localtime"
+)
+
+var (
+	// defaultMaskedPaths are paths masked in containers by default.
+	defaultMaskedPaths = []string{
+		"/proc/kcore",
+		"/proc/latency_stats",
+		"/proc/timer_list",
+		"/proc/timer_stats",
+		"/proc/sched_debug",
+		"/sys/firmware",
+	}
+	// defaultReadonlyPaths are paths readonly in containers by default.
+	defaultReadonlyPaths = []string{
+		"/proc/asound",
+		"/proc/bus",
+		"/proc/fs",
+		"/proc/irq",
+		"/proc/sys",
+		"/proc/sysrq-trigger",
+	}
+)
+
+// SandboxOptions are the inputs of the sandbox container spec.
+type SandboxOptions struct {
+	// ID is the id of the sandbox.
+	ID string
+	// Config is the sandbox config.
+	Config *runtime.PodSandboxConfig
+	// ImageConfig is the config of the sandbox image.
+	ImageConfig *imagespec.ImageConfig
+	// Seccomp is the seccomp profile of the pod. nil means unconfined.
+	Seccomp *SeccompProfile
+	// NetNSPath is the path of the network namespace joined by the sandbox
+	// container. Empty means runc creates a new network namespace.
+	NetNSPath string
+}
+
+// ContainerOptions are the inputs of the application container spec.
+type ContainerOptions struct {
+	// ID is the id of the container.
+	ID string
+	// SandboxPid is the pid of the sandbox container, whose namespaces are
+	// joined by the container.
+	SandboxPid uint32
+	// Config is the container config.
+	Config *runtime.ContainerConfig
+	// SandboxConfig is the config of the sandbox of the container.
+	SandboxConfig *runtime.PodSandboxConfig
+	// ImageConfig is the config of the container image.
+	ImageConfig *imagespec.ImageConfig
+	// ExtraMounts are mounted before mounts in the container config, so that
+	// the container config could override them, e.g. sandbox /etc/hosts.
+	ExtraMounts []*runtime.Mount
+	// InjectedEnvs are environment variables in the form of `key=value`. They
+	// override image envs and are overridden by container config envs.
+	InjectedEnvs []string
+	// DefaultPath is the PATH of the container if neither the image nor the
+	// container config sets it. It is overridden by the pod annotation. Empty
+	// means the runtime-tools default. There is no default umask option, because
+	// the runtime spec has no process umask, so container processes inherit the
+	// umask of the runtime.
+	DefaultPath string
+	// LocaltimeFile is mounted to /etc/localtime if the container doesn't set
+	// TZ. Empty means no mount.
+	LocaltimeFile string
+	// PrivilegedWithoutHostDevices doesn't expose host devices to privileged
+	// containers.
+	PrivilegedWithoutHostDevices bool
+	// UnmaskedProcMountNamespaces are namespaces where unmasked proc mount is
+	// allowed.
+	UnmaskedProcMountNamespaces []string
+	// DefaultCPUShares is the cpu shares of the container if its resources
+	// don't set cpu shares. 0 means the runtime default.
+	DefaultCPUShares int64
+	// DefaultOOMScoreAdj is the oom score adj of the container if its
+	// resources don't set oom score adj. 0 means the runtime default.
+	DefaultOOMScoreAdj int64
+	// Seccomp is the seccomp profile of an unprivileged container. nil means
+	// unconfined.
+	Seccomp *SeccompProfile
+	// ApparmorProfile is the name of the apparmor profile of an unprivileged
+	// container, which must be loaded on the node. Empty means unconfined.
+	ApparmorProfile string
+}
+
+// CgroupsPath generates the cgroups path of a container under the cgroups
+// parent.
+func CgroupsPath(cgroupsParent string, id string) string {
+	// TODO(random-liu): [P0] Handle systemd. (moved from pkg/server/helpers.go)
+	return filepath.Join(cgroupsParent, id)
+}
+
+// NamespacePath returns the path of a namespace of a process.
+func NamespacePath(pid uint32, ns runtimespec.LinuxNamespaceType) string {
+	// Namespace types are named after their /proc entries, except the network
+	// and mount namespaces.
+	name := string(ns)
+	switch ns {
+	case runtimespec.NetworkNamespace:
+		name = "net"
+	case runtimespec.MountNamespace:
+		name = "mnt"
+	}
+	return fmt.Sprintf("/proc/%v/ns/%s", pid, name)
+}
+
+// GenerateSandboxSpec generates the spec of the sandbox container.
+func GenerateSandboxSpec(opts SandboxOptions) (*runtimespec.Spec, error) {
+	config, imageConfig := opts.Config, opts.ImageConfig
+	// Creates a spec Generator with the default spec.
+	// TODO(random-liu): [P1] Compare the default settings with docker and containerd default. (moved from pkg/server/sandbox_run.go)
+	g := generate.New()
+
+	// Apply default config from image config.
+	if err := addImageEnvs(&g, imageConfig.Env); err != nil {
+		return nil, err
+	}
+
+	if imageConfig.WorkingDir != "" {
+		g.SetProcessCwd(imageConfig.WorkingDir)
+	}
+
+	if len(imageConfig.Entrypoint) == 0 {
+		// Pause image must have entrypoint.
+		return nil, fmt.Errorf("invalid empty entrypoint in image config %+v", imageConfig)
+	}
+	// Set process commands.
+	g.SetProcessArgs(append(imageConfig.Entrypoint, imageConfig.Cmd...))
+
+	// Set relative root path.
+	g.SetRootPath(RelativeRootfsPath)
+
+	// Make root of sandbox container read-only.
+	g.SetRootReadonly(true)
+
+	// Set hostname in the pod-shared uts namespace.
+	g.SetHostname(config.GetHostname())
+
+	// TODO(random-liu): [P2] Consider whether to add labels and annotations to the container. (moved from pkg/server/sandbox_run.go)
+
+	// Set cgroups parent.
+	if config.GetLinux().GetCgroupParent() != "" {
+		cgroupsPath := CgroupsPath(config.GetLinux().GetCgroupParent(), opts.ID)
+		g.SetLinuxCgroupsPath(cgroupsPath)
+	}
+	// When cgroup parent is not set, containerd-shim will create container in a child cgroup
+	// of the cgroup itself is in.
+	// TODO(random-liu): [P2] Set default cgroup path if cgroup parent is not specified. (moved from pkg/server/sandbox_run.go)
+
+	// Set namespace options.
+	nsOptions := config.GetLinux().GetSecurityContext().GetNamespaceOptions()
+	// By default, all namespaces are enabled for the container, runc will create a new namespace
+	// for it. By removing the namespace, the container will inherit the namespace of the runtime.
+	if nsOptions.GetHostNetwork() {
+		g.RemoveLinuxNamespace(string(runtimespec.NetworkNamespace)) // nolint: errcheck
+		// Host network pods use the host uts namespace, the hostname is the node name
+		// and can't be changed.
+		g.RemoveLinuxNamespace(string(runtimespec.UTSNamespace)) // nolint: errcheck
+		g.SetHostname("")
+	} else if opts.NetNSPath != "" {
+		g.AddOrReplaceLinuxNamespace(string(runtimespec.NetworkNamespace), opts.NetNSPath) // nolint: errcheck
+	}
+
+	if nsOptions.GetHostPid() {
+		g.RemoveLinuxNamespace(string(runtimespec.PIDNamespace)) // nolint: errcheck
+	}
+
+	if nsOptions.GetHostIpc() {
+		setOCIHostIPC(&g)
+	}
+
+	// TODO(random-liu): [P1] Apply SeLinux options. (moved from pkg/server/sandbox_run.go)
+
+	// TODO(random-liu): [P1] Set user. (moved from pkg/server/sandbox_run.go)
+
+	// TODO(random-liu): [P1] Set supplemental group. (moved from pkg/server/sandbox_run.go)
+
+	// TODO(random-liu): [P1] Set privileged. (moved from pkg/server/sandbox_run.go)
+
+	// TODO(random-liu): [P2] Set sysctl from annotations. (moved from pkg/server/sandbox_run.go)
+
+	// TODO(random-liu): [P2] Set apparmor from annotations. (moved from pkg/server/sandbox_run.go)
+
+	if err := setOCISeccomp(&g, opts.Seccomp); err != nil {
+		return nil, fmt.Errorf("failed to set seccomp profile: %v", err)
+	}
+
+	g.SetLinuxResourcesCPUShares(uint64(defaultSandboxCPUshares))
+	g.SetProcessOOMScoreAdj(int(defaultSandboxOOMAdj))
+
+	return g.Spec(), nil
+}
+
+// GenerateContainerSpec generates the spec of an application container.
+func GenerateContainerSpec(opts ContainerOptions) (*runtimespec.Spec, error) {
+	config, sandboxConfig, imageConfig := opts.Config, opts.SandboxConfig, opts.ImageConfig
+	// Creates a spec Generator with the default spec.
+	g := generate.New()
+
+	// Set the relative path to the rootfs of the container from containerd's
+	// pre-defined directory.
+	g.SetRootPath(RelativeRootfsPath)
+
+	if err := setOCIProcessArgs(&g, config, imageConfig); err != nil {
+		return nil, err
+	}
+
+	if config.GetWorkingDir() != "" {
+		g.SetProcessCwd(config.GetWorkingDir())
+	} else if imageConfig.WorkingDir != "" {
+		g.SetProcessCwd(imageConfig.WorkingDir)
+	}
+
+	// Apply the default PATH first, then envs from image config and injected
+	// envs, so that envs from container config can override them.
+	defaultPath := opts.DefaultPath
+	if path, ok := sandboxConfig.GetAnnotations()[DefaultPathAnnotationKey]; ok {
+		defaultPath = path
+	}
+	if strings.ContainsAny(defaultPath, "=\n") {
+		return nil, fmt.Errorf("invalid default PATH %q", defaultPath)
+	}
+	if defaultPath != "" {
+		g.AddProcessEnv("PATH", defaultPath)
+	}
+	if err := addImageEnvs(&g, imageConfig.Env); err != nil {
+		return nil, err
+	}
+	for _, e := range opts.InjectedEnvs {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid injected environment variable %q", e)
+		}
+		g.AddProcessEnv(kv[0], kv[1])
+	}
+	for _, e := range config.GetEnvs() {
+		g.AddProcessEnv(e.GetKey(), e.GetValue())
+	}
+
+	// TODO: add setOCIPrivileged group all privileged logic together (moved from pkg/server/container_create.go)
+	securityContext := config.GetLinux().GetSecurityContext()
+
+	// Add extra mounts first so that CRI specified mounts can override.
+	extraMounts := append(opts.ExtraMounts, generateLocaltimeMounts(opts.LocaltimeFile, g.Spec().Process.Env)...)
+	addOCIBindMounts(&g, append(extraMounts, config.GetMounts()...), securityContext.GetPrivileged())
+
+	g.SetRootReadonly(securityContext.GetReadonlyRootfs())
+
+	// Host devices are not exposed to privileged containers if configured, e.g. for
+	// vm based runtimes or locked-down nodes.
+	hostDevices := securityContext.GetPrivileged() && !opts.PrivilegedWithoutHostDevices
+	if err := addOCIDevices(&g, config.GetDevices(), hostDevices); err != nil {
+		return nil, fmt.Errorf("failed to set devices mapping %+v: %v", config.GetDevices(), err)
+	}
+
+	setOCILinuxResource(&g, config.GetLinux().GetResources(), opts.DefaultCPUShares, opts.DefaultOOMScoreAdj)
+
+	if sandboxConfig.GetLinux().GetCgroupParent() != "" {
+		cgroupsPath := CgroupsPath(sandboxConfig.GetLinux().GetCgroupParent(), opts.ID)
+		g.SetLinuxCgroupsPath(cgroupsPath)
+	}
+
+	g.SetProcessTerminal(config.GetTty())
+
+	if err := setOCICapabilities(&g, securityContext.GetCapabilities(), securityContext.GetPrivileged()); err != nil {
+		return nil, fmt.Errorf("failed to set capabilities %+v: %v",
+			securityContext.GetCapabilities(), err)
+	}
+
+	// Set namespaces, share namespace with sandbox container.
+	setOCINamespaces(&g, securityContext.GetNamespaceOptions(), opts.SandboxPid)
+
+	// TODO(random-liu): [P1] Set selinux options. (moved from pkg/server/container_create.go)
+
+	supplementalGroups := securityContext.GetSupplementalGroups()
+	for _, group := range supplementalGroups {
+		g.AddProcessAdditionalGid(uint32(group))
+	}
+
+	// Privileged containers are not confined by apparmor and seccomp, and have no
+	// masked paths.
+	if !securityContext.GetPrivileged() {
+		procMount := config.GetAnnotations()[ProcMountAnnotationKey]
+		namespace := sandboxConfig.GetMetadata().GetNamespace()
+		if err := setOCIProcMount(&g, procMount, namespace, opts.UnmaskedProcMountNamespaces); err != nil {
+			return nil, fmt.Errorf("failed to set proc mount %q: %v", procMount, err)
+		}
+		if opts.ApparmorProfile != "" {
+			g.SetProcessApparmorProfile(opts.ApparmorProfile)
+		}
+		if err := setOCISeccomp(&g, opts.Seccomp); err != nil {
+			return nil, fmt.Errorf("failed to set seccomp profile: %v", err)
+		}
+	}
+
+	return g.Spec(), nil
+}
+
+// generateLocaltimeMounts returns the mount of the zone file to /etc/localtime,
+// if the container doesn't set TZ in its environment.
+func generateLocaltimeMounts(localtimeFile string, envs []string) []*runtime.Mount {
+	if localtimeFile == "" {
+		return nil
+	}
+	for _, e := range envs {
+		if strings.HasPrefix(e, "TZ=") {
+			return nil
+		}
+	}
+	return []*runtime.Mount{{
+		ContainerPath: etcLocaltime,
+		HostPath:      localtimeFile,
+		Readonly:      true,
+	}}
+}
@@ -0,0 +1,757 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spec
+
+import (
+	"strings"
+	"testing"
+
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+	runtimespec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+)
+
+func checkMount(t *testing.T, mounts []runtimespec.Mount, src, dest, typ string,
+	contains, notcontains []string) {
+	found := false
+	for _, m := range mounts {
+		if m.Source == src && m.Destination == dest {
+			assert.Equal(t, m.Type, typ)
+			for _, c := range contains {
+				assert.Contains(t, m.Options, c)
+			}
+			for _, n := range notcontains {
+				assert.NotContains(t, m.Options, n)
+			}
+			found = true
+			break
+		}
+	}
+	assert.True(t, found, "mount from %q to %q not found", src, dest)
+}
+
+func getContainerTestOptions() (ContainerOptions, func(*testing.T, *runtimespec.Spec)) {
+	config := &runtime.ContainerConfig{
+		Metadata: &runtime.ContainerMetadata{
+			Name:    "test-name",
+			Attempt: 1,
+		},
+		Image: &runtime.ImageSpec{
+			Image: "sha256:c75bebcdd211f41b3a460c7bf82970ed6c75acaab9cd4c9a4e125b03ca113799",
+		},
+		Command:    []string{"test", "command"},
+		Args:       []string{"test", "args"},
+		WorkingDir: "test-cwd",
+		Envs: []*runtime.KeyValue{
+			{Key: "k1", Value: "v1"},
+			{Key: "k2", Value: "v2"},
+		},
+		Mounts: []*runtime.Mount{
+			{
+				ContainerPath: "container-path-1",
+				HostPath:      "host-path-1",
+			},
+			{
+				ContainerPath: "container-path-2",
+				HostPath:      "host-path-2",
+				Readonly:      true,
+			},
+		},
+		Labels:      map[string]string{"a": "b"},
+		Annotations: map[string]string{"c": "d"},
+		Linux: &runtime.LinuxContainerConfig{
+			Resources: &runtime.LinuxContainerResources{
+				CpuPeriod:          100,
+				CpuQuota:           200,
+				CpuShares:          300,
+				MemoryLimitInBytes: 400,
+				OomScoreAdj:        500,
+			},
+			SecurityContext: &runtime.LinuxContainerSecurityContext{
+				Capabilities: &runtime.Capability{
+					AddCapabilities:  []string{"SYS_ADMIN"},
+					DropCapabilities: []string{"CHOWN"},
+				},
+				SupplementalGroups: []int64{1111, 2222},
+			},
+		},
+	}
+	sandboxConfig := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{
+			Name:      "test-sandbox-name",
+			Uid:       "test-sandbox-uid",
+			Namespace: "test-sandbox-ns",
+			Attempt:   2,
+		},
+		Linux: &runtime.LinuxPodSandboxConfig{
+			CgroupParent: "/test/cgroup/parent",
+		},
+	}
+	imageConfig := &imagespec.ImageConfig{
+		Env:        []string{"ik1=iv1", "ik2=iv2"},
+		Entrypoint: []string{"/entrypoint"},
+		Cmd:        []string{"cmd"},
+		WorkingDir: "/workspace",
+	}
+	specCheck := func(t *testing.T, spec *runtimespec.Spec) {
+		assert.Equal(t, RelativeRootfsPath, spec.Root.Path)
+		assert.Equal(t, []string{"test", "command", "test", "args"}, spec.Process.Args)
+		assert.Equal(t, "test-cwd", spec.Process.Cwd)
+		assert.Contains(t, spec.Process.Env, "k1=v1", "k2=v2", "ik1=iv1", "ik2=iv2")
+
+		t.Logf("Check cgroups bind mount")
+		checkMount(t, spec.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", []string{"ro"}, nil)
+
+		t.Logf("Check bind mount")
+		checkMount(t, spec.Mounts, "host-path-1", "container-path-1", "bind", []string{"rw"}, nil)
+		checkMount(t, spec.Mounts, "host-path-2", "container-path-2", "bind", []string{"ro"}, nil)
+
+		t.Logf("Check resource limits")
+		assert.EqualValues(t, *spec.Linux.Resources.CPU.Period, 100)
+		assert.EqualValues(t, *spec.Linux.Resources.CPU.Quota, 200)
+		assert.EqualValues(t, *spec.Linux.Resources.CPU.Shares, 300)
+		assert.EqualValues(t, *spec.Linux.Resources.Memory.Limit, 400)
+		assert.EqualValues(t, *spec.Process.OOMScoreAdj, 500)
+
+		t.Logf("Check capabilities")
+		assert.Contains(t, spec.Process.Capabilities.Bounding, "CAP_SYS_ADMIN")
+		assert.Contains(t, spec.Process.Capabilities.Effective, "CAP_SYS_ADMIN")
+		assert.Contains(t, spec.Process.Capabilities.Inheritable, "CAP_SYS_ADMIN")
+		assert.Contains(t, spec.Process.Capabilities.Permitted, "CAP_SYS_ADMIN")
+		assert.Contains(t, spec.Process.Capabilities.Ambient, "CAP_SYS_ADMIN")
+		assert.NotContains(t, spec.Process.Capabilities.Bounding, "CAP_CHOWN")
+		assert.NotContains(t, spec.Process.Capabilities.Effective, "CAP_CHOWN")
+		assert.NotContains(t, spec.Process.Capabilities.Inheritable, "CAP_CHOWN")
+		assert.NotContains(t, spec.Process.Capabilities.Permitted, "CAP_CHOWN")
+		assert.NotContains(t, spec.Process.Capabilities.Ambient, "CAP_CHOWN")
+
+		t.Logf("Check supplemental groups")
+		assert.Contains(t, spec.Process.User.AdditionalGids, uint32(1111))
+		assert.Contains(t, spec.Process.User.AdditionalGids, uint32(2222))
+
+		t.Logf("Check cgroup path")
+		assert.Equal(t, CgroupsPath("/test/cgroup/parent", "test-id"), spec.Linux.CgroupsPath)
+
+		t.Logf("Check namespaces")
+		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+			Type: runtimespec.NetworkNamespace,
+			Path: NamespacePath(1234, runtimespec.NetworkNamespace),
+		})
+		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+			Type: runtimespec.IPCNamespace,
+			Path: NamespacePath(1234, runtimespec.IPCNamespace),
+		})
+		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+			Type: runtimespec.UTSNamespace,
+			Path: NamespacePath(1234, runtimespec.UTSNamespace),
+		})
+		assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{
+			Type: runtimespec.PIDNamespace,
+			Path: NamespacePath(1234, runtimespec.PIDNamespace),
+		})
+	}
+	return ContainerOptions{
+		ID:            "test-id",
+		SandboxPid:    1234,
+		Config:        config,
+		SandboxConfig: sandboxConfig,
+		ImageConfig:   imageConfig,
+	}, specCheck
+}
+
+func TestGenerateContainerSpec(t *testing.T) {
+	opts, specCheck := getContainerTestOptions()
+	spec, err := GenerateContainerSpec(opts)
+	require.NoError(t, err)
+	specCheck(t, spec)
+	assert.Equal(t, defaultMaskedPaths, spec.Linux.MaskedPaths)
+	assert.Equal(t, defaultReadonlyPaths, spec.Linux.ReadonlyPaths)
+	assert.Nil(t, spec.Linux.Seccomp, "seccomp should be unconfined without profile")
+}
+
+func TestContainerSpecInjectedEnvs(t *testing.T) {
+	for desc, test := range map[string]struct {
+		injectedEnvs []string
+		expected     []string
+		expectErr    bool
+	}{
+		"injected envs should override image envs": {
+			injectedEnvs: []string{"ik1=injected"},
+			expected:     []string{"ik1=injected", "ik2=iv2", "k1=v1", "k2=v2"},
+		},
+		"container envs should override injected envs": {
+			injectedEnvs: []string{"k1=injected", "INJECTED=a=b"},
+			expected:     []string{"ik1=iv1", "ik2=iv2", "k1=v1", "INJECTED=a=b", "k2=v2"},
+		},
+		"should return error for invalid injected env": {
+			injectedEnvs: []string{"invalid"},
+			expectErr:    true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, _ := getContainerTestOptions()
+		opts.InjectedEnvs = test.injectedEnvs
+		spec, err := GenerateContainerSpec(opts)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		for _, e := range test.expected {
+			assert.Contains(t, spec.Process.Env, e)
+		}
+	}
+}
+
+func TestContainerSpecDefaultPath(t *testing.T) {
+	for desc, test := range map[string]struct {
+		defaultPath  string
+		annotation   *string
+		imageEnvs    []string
+		configEnvs   []*runtime.KeyValue
+		expectedPath string
+		expectErr    bool
+	}{
+		"runtime-tools default should be used without default path": {
+			expectedPath: "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		},
+		"default path should be used": {
+			defaultPath:  "/usr/bin:/bin",
+			expectedPath: "PATH=/usr/bin:/bin",
+		},
+		"pod annotation should override default path": {
+			defaultPath:  "/usr/bin:/bin",
+			annotation:   stringPtr("/opt/bin:/usr/bin"),
+			expectedPath: "PATH=/opt/bin:/usr/bin",
+		},
+		"image env should override default path": {
+			defaultPath:  "/usr/bin:/bin",
+			annotation:   stringPtr("/opt/bin:/usr/bin"),
+			imageEnvs:    []string{"PATH=/image/bin"},
+			expectedPath: "PATH=/image/bin",
+		},
+		"container env should override default path": {
+			defaultPath:  "/usr/bin:/bin",
+			configEnvs:   []*runtime.KeyValue{{Key: "PATH", Value: "/config/bin"}},
+			expectedPath: "PATH=/config/bin",
+		},
+		"invalid default path should return error": {
+			annotation: stringPtr("/bin\nFOO=bar"),
+			expectErr:  true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, _ := getContainerTestOptions()
+		opts.DefaultPath = test.defaultPath
+		if test.annotation != nil {
+			opts.SandboxConfig.Annotations = map[string]string{DefaultPathAnnotationKey: *test.annotation}
+		}
+		opts.ImageConfig.Env = test.imageEnvs
+		opts.Config.Envs = test.configEnvs
+		spec, err := GenerateContainerSpec(opts)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		var paths []string
+		for _, e := range spec.Process.Env {
+			if strings.HasPrefix(e, "PATH=") {
+				paths = append(paths, e)
+			}
+		}
+		assert.Equal(t, []string{test.expectedPath}, paths)
+	}
+}
+
+func stringPtr(s string) *string { return &s }
+
+func TestContainerSpecCommand(t *testing.T) {
+	for desc, test := range map[string]struct {
+		criEntrypoint   []string
+		criArgs         []string
+		imageEntrypoint []string
+		imageArgs       []string
+		expected        []string
+		expectErr       bool
+	}{
+		"should use cri entrypoint if it's specified": {
+			criEntrypoint:   []string{"a", "b"},
+			imageEntrypoint: []string{"c", "d"},
+			imageArgs:       []string{"e", "f"},
+			expected:        []string{"a", "b"},
+		},
+		"should use cri entrypoint if it's specified even if it's empty": {
+			criEntrypoint:   []string{},
+			criArgs:         []string{"a", "b"},
+			imageEntrypoint: []string{"c", "d"},
+			imageArgs:       []string{"e", "f"},
+			expected:        []string{"a", "b"},
+		},
+		"should use cri entrypoint and args if they are specified": {
+			criEntrypoint:   []string{"a", "b"},
+			criArgs:         []string{"c", "d"},
+			imageEntrypoint: []string{"e", "f"},
+			imageArgs:       []string{"g", "h"},
+			expected:        []string{"a", "b", "c", "d"},
+		},
+		"should use image entrypoint if cri entrypoint is not specified": {
+			criArgs:         []string{"a", "b"},
+			imageEntrypoint: []string{"c", "d"},
+			imageArgs:       []string{"e", "f"},
+			expected:        []string{"c", "d", "a", "b"},
+		},
+		"should use image args if both cri entrypoint and args are not specified": {
+			imageEntrypoint: []string{"c", "d"},
+			imageArgs:       []string{"e", "f"},
+			expected:        []string{"c", "d", "e", "f"},
+		},
+		"should return error if both entrypoint and args are empty": {
+			expectErr: true,
+		},
+	} {
+
+		opts, _ := getContainerTestOptions()
+		config, imageConfig := opts.Config, opts.ImageConfig
+		g := generate.New()
+		config.Command = test.criEntrypoint
+		config.Args = test.criArgs
+		imageConfig.Entrypoint = test.imageEntrypoint
+		imageConfig.Cmd = test.imageArgs
+		err := setOCIProcessArgs(&g, config, imageConfig)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, g.Spec().Process.Args, desc)
+	}
+}
+
+func TestPrivilegedBindMount(t *testing.T) {
+	for desc, test := range map[string]struct {
+		privileged         bool
+		readonlyRootFS     bool
+		expectedSysFSRO    bool
+		expectedCgroupFSRO bool
+	}{
+		"sysfs and cgroupfs should mount as 'ro' by default": {
+			expectedSysFSRO:    true,
+			expectedCgroupFSRO: true,
+		},
+		"sysfs and cgroupfs should not mount as 'ro' if privileged": {
+			privileged:         true,
+			expectedSysFSRO:    false,
+			expectedCgroupFSRO: false,
+		},
+		"sysfs should mount as 'ro' if root filrsystem is readonly": {
+			privileged:         true,
+			readonlyRootFS:     true,
+			expectedSysFSRO:    true,
+			expectedCgroupFSRO: false,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		g := generate.New()
+		g.SetRootReadonly(test.readonlyRootFS)
+		addOCIBindMounts(&g, nil, test.privileged)
+		spec := g.Spec()
+		if test.expectedSysFSRO {
+			checkMount(t, spec.Mounts, "sysfs", "/sys", "sysfs", []string{"ro"}, nil)
+		} else {
+			checkMount(t, spec.Mounts, "sysfs", "/sys", "sysfs", nil, []string{"ro"})
+		}
+		if test.expectedCgroupFSRO {
+			checkMount(t, spec.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", []string{"ro"}, nil)
+		} else {
+			checkMount(t, spec.Mounts, "cgroup", "/sys/fs/cgroup", "cgroup", nil, []string{"ro"})
+		}
+	}
+}
+
+func TestContainerSpecProcMount(t *testing.T) {
+	for desc, test := range map[string]struct {
+		procMount         string
+		privileged        bool
+		allowedNamespaces []string
+		expectErr         bool
+		expectMasked      bool
+	}{
+		"should mask paths by default": {
+			expectMasked: true,
+		},
+		"should mask paths with default proc mount": {
+			procMount:    DefaultProcMount,
+			expectMasked: true,
+		},
+		"should not mask paths with unmasked proc mount in allowed namespace": {
+			procMount:         UnmaskedProcMount,
+			allowedNamespaces: []string{"test-sandbox-ns"},
+		},
+		"should reject unmasked proc mount in namespace not allowed": {
+			procMount:         UnmaskedProcMount,
+			allowedNamespaces: []string{"other-ns"},
+			expectErr:         true,
+		},
+		"should reject unknown proc mount": {
+			procMount: "unknown",
+			expectErr: true,
+		},
+		"should not mask paths for privileged container": {
+			privileged: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, specCheck := getContainerTestOptions()
+		config := opts.Config
+		config.Annotations[ProcMountAnnotationKey] = test.procMount
+		config.Linux.SecurityContext.Privileged = test.privileged
+		opts.UnmaskedProcMountNamespaces = test.allowedNamespaces
+		spec, err := GenerateContainerSpec(opts)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		if !test.privileged {
+			specCheck(t, spec)
+		}
+		if test.expectMasked {
+			assert.Equal(t, defaultMaskedPaths, spec.Linux.MaskedPaths)
+			assert.Equal(t, defaultReadonlyPaths, spec.Linux.ReadonlyPaths)
+		} else {
+			assert.Empty(t, spec.Linux.MaskedPaths)
+			assert.Empty(t, spec.Linux.ReadonlyPaths)
+		}
+	}
+}
+
+func TestPrivilegedContainerDevices(t *testing.T) {
+	for desc, test := range map[string]struct {
+		withoutHostDevices bool
+		expectHostDevices  bool
+	}{
+		"privileged container should have all host devices by default": {
+			expectHostDevices: true,
+		},
+		"privileged container should not have host devices if configured": {
+			withoutHostDevices: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, _ := getContainerTestOptions()
+		config := opts.Config
+		config.Linux.SecurityContext.Privileged = true
+		opts.PrivilegedWithoutHostDevices = test.withoutHostDevices
+		spec, err := GenerateContainerSpec(opts)
+		require.NoError(t, err)
+		// Privileged container should always have all capabilities.
+		assert.Contains(t, spec.Process.Capabilities.Bounding, "CAP_SYS_ADMIN")
+		assert.Contains(t, spec.Process.Capabilities.Bounding, "CAP_CHOWN")
+		allowAll := false
+		for _, d := range spec.Linux.Resources.Devices {
+			if d.Allow && d.Type == "" && d.Major == nil && d.Minor == nil && d.Access == "rwm" {
+				allowAll = true
+			}
+		}
+		assert.Equal(t, test.expectHostDevices, allowAll)
+		if !test.expectHostDevices {
+			assert.Empty(t, spec.Linux.Devices)
+		}
+	}
+}
+
+func TestContainerSpecLocaltime(t *testing.T) {
+	for desc, test := range map[string]struct {
+		localtimeFile string
+		tz            string
+		expectMount   bool
+	}{
+		"should not mount localtime by default": {},
+		"should mount localtime if configured": {
+			localtimeFile: "/usr/share/zoneinfo/UTC",
+			expectMount:   true,
+		},
+		"should not mount localtime if container sets TZ": {
+			localtimeFile: "/usr/share/zoneinfo/UTC",
+			tz:            "Europe/London",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, specCheck := getContainerTestOptions()
+		config := opts.Config
+		if test.tz != "" {
+			config.Envs = append(config.Envs, &runtime.KeyValue{Key: "TZ", Value: test.tz})
+		}
+		opts.LocaltimeFile = test.localtimeFile
+		spec, err := GenerateContainerSpec(opts)
+		require.NoError(t, err)
+		specCheck(t, spec)
+		found := false
+		for _, m := range spec.Mounts {
+			if m.Destination == etcLocaltime {
+				found = true
+			}
+		}
+		assert.Equal(t, test.expectMount, found)
+		if test.expectMount {
+			checkMount(t, spec.Mounts, test.localtimeFile, etcLocaltime, "bind", []string{"ro"}, nil)
+		}
+	}
+}
+
+func TestContainerSpecDefaultResources(t *testing.T) {
+	for desc, test := range map[string]struct {
+		resources           *runtime.LinuxContainerResources
+		defaultCPUShares    int64
+		defaultOOMScoreAdj  int64
+		expectedCPUShares   *uint64
+		expectedOOMScoreAdj *int
+	}{
+		"should not set defaults if not configured": {},
+		"should set defaults if resources are omitted": {
+			defaultCPUShares:    2,
+			defaultOOMScoreAdj:  1000,
+			expectedCPUShares:   uint64Ptr(2),
+			expectedOOMScoreAdj: intPtr(1000),
+		},
+		"should set defaults if resource fields are omitted": {
+			resources:           &runtime.LinuxContainerResources{MemoryLimitInBytes: 400},
+			defaultCPUShares:    2,
+			defaultOOMScoreAdj:  1000,
+			expectedCPUShares:   uint64Ptr(2),
+			expectedOOMScoreAdj: intPtr(1000),
+		},
+		"should not override resources in container config": {
+			resources:           &runtime.LinuxContainerResources{CpuShares: 300, OomScoreAdj: -998},
+			defaultCPUShares:    2,
+			defaultOOMScoreAdj:  1000,
+			expectedCPUShares:   uint64Ptr(300),
+			expectedOOMScoreAdj: intPtr(-998),
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, _ := getContainerTestOptions()
+		opts.Config.Linux.Resources = test.resources
+		opts.DefaultCPUShares = test.defaultCPUShares
+		opts.DefaultOOMScoreAdj = test.defaultOOMScoreAdj
+		spec, err := GenerateContainerSpec(opts)
+		require.NoError(t, err)
+		var cpuShares *uint64
+		if spec.Linux.Resources != nil && spec.Linux.Resources.CPU != nil {
+			cpuShares = spec.Linux.Resources.CPU.Shares
+		}
+		assert.Equal(t, test.expectedCPUShares, cpuShares)
+		assert.Equal(t, test.expectedOOMScoreAdj, spec.Process.OOMScoreAdj)
+	}
+}
+
+func uint64Ptr(v uint64) *uint64 { return &v }
+
+func intPtr(v int) *int { return &v }
+
+func TestContainerSpecHostIPC(t *testing.T) {
+	for desc, test := range map[string]struct {
+		hostIpc bool
+	}{
+		"container should join sandbox ipc namespace by default": {},
+		"container should join host ipc namespace if host ipc is set": {
+			hostIpc: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, _ := getContainerTestOptions()
+		config := opts.Config
+		config.Linux.SecurityContext.NamespaceOptions = &runtime.NamespaceOption{HostIpc: test.hostIpc}
+		spec, err := GenerateContainerSpec(opts)
+		require.NoError(t, err)
+		var ipcNS *runtimespec.LinuxNamespace
+		for i, ns := range spec.Linux.Namespaces {
+			if ns.Type == runtimespec.IPCNamespace {
+				ipcNS = &spec.Linux.Namespaces[i]
+			}
+		}
+		var mqueue *runtimespec.Mount
+		for i, m := range spec.Mounts {
+			if m.Destination == devMqueue {
+				mqueue = &spec.Mounts[i]
+			}
+		}
+		require.NotNil(t, mqueue)
+		if test.hostIpc {
+			assert.Nil(t, ipcNS)
+			checkMount(t, spec.Mounts, devMqueue, devMqueue, "bind", []string{"rw"}, nil)
+		} else {
+			require.NotNil(t, ipcNS)
+			assert.Equal(t, NamespacePath(opts.SandboxPid, runtimespec.IPCNamespace), ipcNS.Path)
+			assert.Equal(t, "mqueue", mqueue.Type)
+		}
+	}
+}
+
+func TestContainerSpecUTSNamespace(t *testing.T) {
+	for desc, test := range map[string]struct {
+		hostNetwork bool
+	}{
+		"container should join sandbox uts namespace by default": {},
+		"container should join host uts namespace if host network is set": {
+			hostNetwork: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, _ := getContainerTestOptions()
+		config := opts.Config
+		config.Linux.SecurityContext.NamespaceOptions = &runtime.NamespaceOption{HostNetwork: test.hostNetwork}
+		spec, err := GenerateContainerSpec(opts)
+		require.NoError(t, err)
+		assert.Empty(t, spec.Hostname, "container should not override sandbox hostname")
+		var utsNS *runtimespec.LinuxNamespace
+		for i, ns := range spec.Linux.Namespaces {
+			if ns.Type == runtimespec.UTSNamespace {
+				utsNS = &spec.Linux.Namespaces[i]
+			}
+		}
+		if test.hostNetwork {
+			assert.Nil(t, utsNS)
+		} else {
+			require.NotNil(t, utsNS)
+			assert.Equal(t, NamespacePath(opts.SandboxPid, runtimespec.UTSNamespace), utsNS.Path)
+		}
+	}
+}
+
+func getSandboxTestOptions() (SandboxOptions, func(*testing.T, *runtimespec.Spec)) {
+	config := &runtime.PodSandboxConfig{
+		Metadata: &runtime.PodSandboxMetadata{
+			Name:      "test-name",
+			Uid:       "test-uid",
+			Namespace: "test-ns",
+			Attempt:   1,
+		},
+		Hostname: "test-hostname",
+		Linux: &runtime.LinuxPodSandboxConfig{
+			CgroupParent: "/test/cgroup/parent",
+		},
+	}
+	imageConfig := &imagespec.ImageConfig{
+		Env:        []string{"a=b", "c=d"},
+		Entrypoint: []string{"/pause"},
+		Cmd:        []string{"forever"},
+		WorkingDir: "/workspace",
+	}
+	specCheck := func(t *testing.T, spec *runtimespec.Spec) {
+		assert.Equal(t, CgroupsPath("/test/cgroup/parent", "test-id"), spec.Linux.CgroupsPath)
+		assert.Equal(t, RelativeRootfsPath, spec.Root.Path)
+		assert.Equal(t, true, spec.Root.Readonly)
+		assert.Contains(t, spec.Process.Env, "a=b", "c=d")
+		assert.Equal(t, []string{"/pause", "forever"}, spec.Process.Args)
+		assert.Equal(t, "/workspace", spec.Process.Cwd)
+		assert.EqualValues(t, *spec.Linux.Resources.CPU.Shares, defaultSandboxCPUshares)
+		assert.EqualValues(t, *spec.Process.OOMScoreAdj, defaultSandboxOOMAdj)
+	}
+	return SandboxOptions{
+		ID:          "test-id",
+		Config:      config,
+		ImageConfig: imageConfig,
+	}, specCheck
+}
+
+func TestGenerateSandboxSpec(t *testing.T) {
+	for desc, test := range map[string]struct {
+		hostNamespaces bool
+		netNSPath      string
+		seccomp        *SeccompProfile
+		expectSeccomp  bool
+	}{
+		"sandbox should have its own namespaces by default": {},
+		"sandbox should use host namespaces if set": {
+			hostNamespaces: true,
+		},
+		"sandbox should join the network namespace if set": {
+			netNSPath: "/var/run/netns/test",
+		},
+		"sandbox should be unconfined without seccomp profile": {},
+		"sandbox should set seccomp profile": {
+			seccomp:       &SeccompProfile{},
+			expectSeccomp: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		opts, specCheck := getSandboxTestOptions()
+		opts.Seccomp = test.seccomp
+		opts.NetNSPath = test.netNSPath
+		if test.hostNamespaces {
+			opts.Config.Linux.SecurityContext = &runtime.LinuxSandboxSecurityContext{
+				NamespaceOptions: &runtime.NamespaceOption{
+					HostNetwork: true,
+					HostPid:     true,
+					HostIpc:     true,
+				},
+			}
+		}
+		spec, err := GenerateSandboxSpec(opts)
+		require.NoError(t, err)
+		specCheck(t, spec)
+		assert.Equal(t, test.expectSeccomp, spec.Linux.Seccomp != nil)
+		for _, ns := range []runtimespec.LinuxNamespaceType{
+			runtimespec.NetworkNamespace,
+			runtimespec.PIDNamespace,
+			runtimespec.IPCNamespace,
+			runtimespec.UTSNamespace,
+		} {
+			if test.hostNamespaces {
+				assert.NotContains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{Type: ns})
+			} else if ns == runtimespec.NetworkNamespace && test.netNSPath != "" {
+				assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{Type: ns, Path: test.netNSPath})
+			} else {
+				assert.Contains(t, spec.Linux.Namespaces, runtimespec.LinuxNamespace{Type: ns})
+			}
+		}
+		if test.hostNamespaces {
+			assert.Empty(t, spec.Hostname)
+			checkMount(t, spec.Mounts, devMqueue, devMqueue, "bind", []string{"rw"}, nil)
+		} else {
+			assert.Equal(t, "test-hostname", spec.Hostname)
+		}
+	}
+}
+
+func TestNamespacePath(t *testing.T) {
+	for desc, test := range map[string]struct {
+		ns       runtimespec.LinuxNamespaceType
+		expected string
+	}{
+		"network namespace": {
+			ns:       runtimespec.NetworkNamespace,
+			expected: "/proc/1234/ns/net",
+		},
+		"ipc namespace": {
+			ns:       runtimespec.IPCNamespace,
+			expected: "/proc/1234/ns/ipc",
+		},
+		"mount namespace": {
+			ns:       runtimespec.MountNamespace,
+			expected: "/proc/1234/ns/mnt",
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		assert.Equal(t, test.expected, NamespacePath(1234, test.ns))
+	}
+}
@@ -18,6 +18,7 @@ package container
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
 )
@@ -34,8 +35,8 @@ type Container struct {
 }
 
 // NewContainer creates an internally used container type.
-func NewContainer(metadata Metadata, status Status) (Container, error) {
-	s, err := StoreStatus(metadata.ID, status)
+func NewContainer(metadata Metadata, status Status, opts ...Opts) (Container, error) {
+	s, err := StoreStatus(metadata.ID, status, opts...)
 	if err != nil {
 		return Container{}, err
 	}
@@ -59,9 +60,18 @@ func LoadContainer() (Container, error) {
 type Store struct {
 	lock       sync.RWMutex
 	containers map[string]Container
+	// generation is bumped whenever a container is added, deleted or
+	// has its status updated. It must be accessed atomically.
+	generation uint64
 	// TODO(random-liu): Add trunc index.
 }
 
+// generationSetter is implemented by status storages which bump the
+// store generation on update.
+type generationSetter interface {
+	setGeneration(*uint64)
+}
+
 // LoadStore loads containers from runtime.
 // TODO(random-liu): Implement LoadStore.
 func LoadStore() *Store { return nil }
@@ -79,7 +89,11 @@ func (s *Store) Add(c Container) error {
 	if _, ok := s.containers[c.ID]; ok {
 		return store.ErrAlreadyExist
 	}
+	if g, ok := c.Status.(generationSetter); ok {
+		g.setGeneration(&s.generation)
+	}
 	s.containers[c.ID] = c
+	atomic.AddUint64(&s.generation, 1)
 	return nil
 }
 
@@ -109,5 +123,15 @@ func (s *Store) List() []Container {
 func (s *Store) Delete(id string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	if _, ok := s.containers[id]; !ok {
+		return
+	}
 	delete(s.containers, id)
+	atomic.AddUint64(&s.generation, 1)
+}
+
+// Generation returns the current generation of the store. The generation
+// changes whenever the content of the store or any container status changes.
+func (s *Store) Generation() uint64 {
+	return atomic.LoadUint64(&s.generation)
 }
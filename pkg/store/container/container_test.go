@@ -17,6 +17,7 @@ limitations under the License.
 package container
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -135,4 +136,25 @@ func TestContainerStore(t *testing.T) {
 	c, err := s.Get(testID)
 	assert.Equal(Container{}, c)
 	assert.Equal(store.ErrNotExist, err)
+
+	t.Logf("generation should change on status update")
+	generation := s.Generation()
+	assert.NoError(containers["1"].Status.Update(func(status Status) (Status, error) {
+		return status, nil
+	}))
+	assert.NotEqual(generation, s.Generation())
+
+	t.Logf("generation should not change on failed status update")
+	generation = s.Generation()
+	assert.Error(containers["1"].Status.Update(func(status Status) (Status, error) {
+		return status, errors.New("update error")
+	}))
+	assert.Equal(generation, s.Generation())
+
+	t.Logf("generation should change on add and delete")
+	assert.NoError(s.Add(containers[testID]))
+	assert.NotEqual(generation, s.Generation())
+	generation = s.Generation()
+	s.Delete(testID)
+	assert.NotEqual(generation, s.Generation())
 }
@@ -18,9 +18,10 @@ package container
 
 import (
 	"encoding/json"
-	"fmt"
 
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
+
+	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
 )
 
 // NOTE(random-liu):
@@ -30,6 +31,11 @@ import (
 // metadataVersion  is current version of container metadata.
 const metadataVersion = "v1" // nolint
 
+// metadataMigrations migrate container metadata of old versions, keyed by the
+// version they migrate from. A migration must be added here whenever
+// metadataVersion is bumped.
+var metadataMigrations = map[string]store.Migration{}
+
 // versionedMetadata is the internal versioned container metadata.
 // nolint
 type versionedMetadata struct {
@@ -62,15 +68,15 @@ func (c *Metadata) Encode() ([]byte, error) {
 
 // Decode decodes Metadata from bytes.
 func (c *Metadata) Decode(data []byte) error {
+	// Handle old version after upgrade.
+	data, err := store.Migrate(data, metadataVersion, metadataMigrations)
+	if err != nil {
+		return err
+	}
 	versioned := &versionedMetadata{}
 	if err := json.Unmarshal(data, versioned); err != nil {
 		return err
 	}
-	// Handle old version after upgrade.
-	switch versioned.Version {
-	case metadataVersion:
-		*c = versioned.Metadata
-		return nil
-	}
-	return fmt.Errorf("unsupported version")
+	*c = versioned.Metadata
+	return nil
 }
@@ -17,17 +17,25 @@ limitations under the License.
 package container
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
 	"sync"
+	"sync/atomic"
 
 	"k8s.io/kubernetes/pkg/kubelet/apis/cri/v1alpha1/runtime"
-)
 
-// TODO(random-liu): Handle versioning.
-// TODO(random-liu): Add checkpoint support.
+	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
+)
 
 // version is current version of container status.
 const version = "v1" // nolint
 
+// statusMigrations migrate container status of old versions, keyed by the
+// version they migrate from. A migration must be added here whenever version
+// is bumped.
+var statusMigrations = map[string]store.Migration{}
+
 // versionedStatus is the internal used versioned container status.
 // nolint
 type versionedStatus struct {
@@ -56,7 +64,30 @@ type Status struct {
 	// Removing indicates that the container is in removing state.
 	// This field doesn't need to be checkpointed.
 	// TODO(random-liu): Reset this field to false during state recoverry.
-	Removing bool
+	Removing bool `json:"-"`
+}
+
+// Encode encodes Status into bytes in json format.
+func (c *Status) Encode() ([]byte, error) {
+	return json.Marshal(&versionedStatus{
+		Version: version,
+		Status:  *c,
+	})
+}
+
+// Decode decodes Status from bytes.
+func (c *Status) Decode(data []byte) error {
+	// Handle old version after upgrade.
+	data, err := store.Migrate(data, version, statusMigrations)
+	if err != nil {
+		return err
+	}
+	versioned := &versionedStatus{}
+	if err := json.Unmarshal(data, versioned); err != nil {
+		return err
+	}
+	*c = versioned.Status
+	return nil
 }
 
 // State returns current state of the container based on the container status.
@@ -94,25 +125,67 @@ type StatusStorage interface {
 	Delete() error
 }
 
-// TODO(random-liu): Add factory function and configure checkpoint path.
+// Checkpointer writes and removes status checkpoint files.
+type Checkpointer interface {
+	// AtomicWriteFile writes the file atomically.
+	AtomicWriteFile(filename string, data []byte, perm os.FileMode) error
+	// RemoveAll removes the file.
+	RemoveAll(path string) error
+}
+
+// Opts sets optional behaviors of the container status storage.
+type Opts func(*statusStorage)
+
+// WithStatusCheckpoint checkpoints the container status into the file on
+// creation and every update, so that it survives restart.
+func WithStatusCheckpoint(checkpointer Checkpointer, path string) Opts {
+	return func(s *statusStorage) {
+		s.checkpointer = checkpointer
+		s.path = path
+	}
+}
 
 // StoreStatus creates the storage containing the passed in container status with the
-// specified id.
+// specified id. The status is only kept in memory, unless it is checkpointed
+// with WithStatusCheckpoint.
 // The status MUST be created in one transaction.
-func StoreStatus(id string, status Status) (StatusStorage, error) {
-	return &statusStorage{status: status}, nil
-	// TODO(random-liu): Create the data on disk atomically.
+func StoreStatus(id string, status Status, opts ...Opts) (StatusStorage, error) {
+	s := &statusStorage{status: status}
+	for _, o := range opts {
+		o(s)
+	}
+	if err := s.checkpoint(status); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-// LoadStatus loads container status from checkpoint.
-func LoadStatus(id string) (StatusStorage, error) {
-	// TODO(random-liu): Load container status from disk.
-	return nil, nil
+// LoadStatus decodes the container status checkpointed with WithStatusCheckpoint.
+func LoadStatus(data []byte) (Status, error) {
+	var status Status
+	if err := status.Decode(data); err != nil {
+		return Status{}, fmt.Errorf("failed to decode status checkpoint: %v", err)
+	}
+	return status, nil
 }
 
 type statusStorage struct {
 	sync.RWMutex
 	status Status
+	// checkpointer writes the status checkpoint into path, no checkpoint
+	// is written if it is nil.
+	checkpointer Checkpointer
+	path         string
+	// generation is the generation counter of the store the container
+	// belongs to, it is bumped on every successful status update.
+	generation *uint64
+}
+
+// setGeneration sets the generation counter bumped on status update.
+func (m *statusStorage) setGeneration(generation *uint64) {
+	m.Lock()
+	defer m.Unlock()
+	m.generation = generation
 }
 
 // Get a copy of container status.
@@ -130,17 +203,35 @@ func (m *statusStorage) Update(u UpdateFunc) error {
 	if err != nil {
 		return err
 	}
-	// TODO(random-liu) *Update* existing status on disk atomically,
-	// return error if checkpoint failed.
+	if err := m.checkpoint(newStatus); err != nil {
+		return err
+	}
 	m.status = newStatus
+	if m.generation != nil {
+		atomic.AddUint64(m.generation, 1)
+	}
 	return nil
 }
 
-// Delete deletes the container status from disk atomically.
+// Delete deletes the container status checkpoint.
 func (m *statusStorage) Delete() error {
-	// TODO(random-liu): Rename the data on the disk, returns error
-	// if fails. No lock is needed because file rename is atomic.
-	// TODO(random-liu): Cleanup temporary files generated, do not
-	// return error.
+	if m.checkpointer == nil {
+		return nil
+	}
+	return m.checkpointer.RemoveAll(m.path)
+}
+
+// checkpoint writes the status checkpoint if checkpointer is set.
+func (m *statusStorage) checkpoint(status Status) error {
+	if m.checkpointer == nil {
+		return nil
+	}
+	data, err := status.Encode()
+	if err != nil {
+		return fmt.Errorf("failed to encode status: %v", err)
+	}
+	if err := m.checkpointer.AtomicWriteFile(m.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to checkpoint status into %q: %v", m.path, err)
+	}
 	return nil
 }
@@ -17,7 +17,9 @@ limitations under the License.
 package container
 
 import (
+	"encoding/json"
 	"errors"
+	"os"
 	"testing"
 	"time"
 
@@ -96,6 +98,95 @@ func TestStatus(t *testing.T) {
 
 	t.Logf("successful update should not affect existing snapshot")
 	assert.Equal(testStatus, old)
+}
+
+// fakeCheckpointer keeps checkpoint files in memory.
+type fakeCheckpointer struct {
+	files    map[string][]byte
+	writeErr error
+}
+
+func (f *fakeCheckpointer) AtomicWriteFile(filename string, data []byte, _ os.FileMode) error {
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	f.files[filename] = data
+	return nil
+}
+
+func (f *fakeCheckpointer) RemoveAll(path string) error {
+	delete(f.files, path)
+	return nil
+}
+
+func TestStatusCheckpoint(t *testing.T) {
+	testPath := "/test/containers/test-id/status"
+	testStatus := Status{CreatedAt: time.Now().UnixNano()}
+	updateStatus := Status{
+		CreatedAt:  testStatus.CreatedAt,
+		StartedAt:  time.Now().UnixNano(),
+		FinishedAt: time.Now().UnixNano(),
+		ExitCode:   1,
+		Reason:     "Error",
+	}
+	checkpointer := &fakeCheckpointer{files: make(map[string][]byte)}
+	assert := assertlib.New(t)
+
+	t.Logf("status should be checkpointed on creation")
+	s, err := StoreStatus("test-id", testStatus, WithStatusCheckpoint(checkpointer, testPath))
+	assert.NoError(err)
+	loaded, err := LoadStatus(checkpointer.files[testPath])
+	assert.NoError(err)
+	assert.Equal(testStatus, loaded)
+
+	t.Logf("update should not take effect if checkpoint fails")
+	checkpointer.writeErr = errors.New("disk full")
+	err = s.Update(func(Status) (Status, error) { return updateStatus, nil })
+	assert.Error(err)
+	assert.Equal(testStatus, s.Get())
+	checkpointer.writeErr = nil
+
+	t.Logf("successful update should be checkpointed")
+	assert.NoError(s.Update(func(Status) (Status, error) { return updateStatus, nil }))
+	loaded, err = LoadStatus(checkpointer.files[testPath])
+	assert.NoError(err)
+	assert.Equal(updateStatus, loaded)
+
+	t.Logf("delete should remove the checkpoint")
+	assert.NoError(s.Delete())
+	assert.NotContains(checkpointer.files, testPath)
+
+	t.Logf("creation should fail if checkpoint fails")
+	checkpointer.writeErr = errors.New("disk full")
+	_, err = StoreStatus("test-id", testStatus, WithStatusCheckpoint(checkpointer, testPath))
+	assert.Error(err)
+}
 
-	// TODO(random-liu): Test Load and Delete after disc checkpoint is added.
+func TestStatusEncodeDecode(t *testing.T) {
+	status := &Status{
+		Pid:        1234,
+		CreatedAt:  time.Now().UnixNano(),
+		StartedAt:  time.Now().UnixNano(),
+		FinishedAt: time.Now().UnixNano(),
+		ExitCode:   1,
+		Reason:     "test-reason",
+		Message:    "test-message",
+		Removing:   true,
+	}
+	assert := assertlib.New(t)
+	data, err := status.Encode()
+	assert.NoError(err)
+	newStatus := &Status{}
+	assert.NoError(newStatus.Decode(data))
+	// Removing is not encoded.
+	expected := *status
+	expected.Removing = false
+	assert.Equal(expected, *newStatus)
+
+	unsupported, err := json.Marshal(&versionedStatus{
+		Version: "random-test-version",
+		Status:  *status,
+	})
+	assert.NoError(err)
+	assert.Error(newStatus.Decode(unsupported))
 }
@@ -17,6 +17,7 @@ limitations under the License.
 package image
 
 import (
+	"hash/fnv"
 	"sync"
 
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -25,7 +26,8 @@ import (
 )
 
 // Image contains all resources associated with the image. All fields
-// MUST not be mutated directly after created.
+// MUST not be mutated directly after created. Config is shared between copies
+// of the image, so it MUST never be mutated.
 type Image struct {
 	// Id of the image. Normally the digest of image config.
 	ID string
@@ -33,6 +35,10 @@ type Image struct {
 	RepoTags []string
 	// Digests by which this image is known.
 	RepoDigests []string
+	// Targets are digests of manifests or indexes resolved to the image. They
+	// are content addressed, so that references with a known target could be
+	// resolved to the image without reading the content store.
+	Targets []string
 	// ChainID is the chainID of the image.
 	ChainID string
 	// Size is the compressed size of the image.
@@ -42,10 +48,27 @@ type Image struct {
 	// TODO(random-liu): Add containerd image client.
 }
 
-// Store stores all images.
-type Store struct {
+// numShards is the number of shards of the image store. Images are spread
+// across shards by id, so that operations on different images rarely contend
+// on the same lock.
+const numShards = 16
+
+// shard is a shard of the image store.
+type shard struct {
 	lock   sync.RWMutex
 	images map[string]Image
+}
+
+// Store stores all images. Images stored are never mutated in place, and all
+// images returned are copies, so that readers get immutable snapshots which are
+// safe to use without holding any lock.
+type Store struct {
+	shards [numShards]*shard
+	// targetLock protects targets. It is always acquired after the lock of
+	// a shard.
+	targetLock sync.RWMutex
+	// targets maps image targets to image ids.
+	targets map[string]string
 	// TODO(random-liu): Add trunc index.
 }
 
@@ -55,52 +78,137 @@ func LoadStore() *Store { return nil }
 
 // NewStore creates an image store.
 func NewStore() *Store {
-	return &Store{images: make(map[string]Image)}
+	s := &Store{targets: make(map[string]string)}
+	for i := range s.shards {
+		s.shards[i] = &shard{images: make(map[string]Image)}
+	}
+	return s
+}
+
+// shardFor returns the shard storing the image with specified id.
+func (s *Store) shardFor(id string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(id)) // nolint: errcheck
+	return s.shards[h.Sum32()%numShards]
 }
 
 // Add an image into the store.
 func (s *Store) Add(img Image) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	i, ok := s.images[img.ID]
+	sh := s.shardFor(img.ID)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	s.addTargets(img.ID, img.Targets)
+	i, ok := sh.images[img.ID]
 	if !ok {
 		// If the image doesn't exist, add it.
-		s.images[img.ID] = img
+		sh.images[img.ID] = copyImage(img)
 		return
 	}
-	// Or else, merge the repo tags/digests.
+	// Or else, merge the repo tags/digests and targets. Merging creates new
+	// slices, so the stored image is not mutated in place.
 	i.RepoTags = mergeStringSlices(i.RepoTags, img.RepoTags)
 	i.RepoDigests = mergeStringSlices(i.RepoDigests, img.RepoDigests)
-	s.images[img.ID] = i
+	i.Targets = mergeStringSlices(i.Targets, img.Targets)
+	sh.images[img.ID] = i
+}
+
+// AddTarget adds a target into an existing image. Returns store.ErrNotExist if
+// the image doesn't exist.
+func (s *Store) AddTarget(id, target string) error {
+	sh := s.shardFor(id)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	i, ok := sh.images[id]
+	if !ok {
+		return store.ErrNotExist
+	}
+	s.addTargets(id, []string{target})
+	i.Targets = mergeStringSlices(i.Targets, []string{target})
+	sh.images[id] = i
+	return nil
+}
+
+// addTargets indexes targets of the image. The lock of the image shard must
+// be held.
+func (s *Store) addTargets(id string, targets []string) {
+	s.targetLock.Lock()
+	defer s.targetLock.Unlock()
+	for _, t := range targets {
+		s.targets[t] = id
+	}
 }
 
 // Get returns the image with specified id. Returns store.ErrNotExist if the
 // image doesn't exist.
 func (s *Store) Get(id string) (Image, error) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
-	if i, ok := s.images[id]; ok {
-		return i, nil
+	sh := s.shardFor(id)
+	sh.lock.RLock()
+	defer sh.lock.RUnlock()
+	if i, ok := sh.images[id]; ok {
+		return copyImage(i), nil
 	}
 	return Image{}, store.ErrNotExist
 }
 
-// List lists all images.
+// GetByTarget returns the image resolved from the target. Returns
+// store.ErrNotExist if the target is unknown.
+func (s *Store) GetByTarget(target string) (Image, error) {
+	s.targetLock.RLock()
+	id, ok := s.targets[target]
+	s.targetLock.RUnlock()
+	if !ok {
+		return Image{}, store.ErrNotExist
+	}
+	return s.Get(id)
+}
+
+// List lists all images. Each shard is only locked while it is being copied.
 func (s *Store) List() []Image {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
 	var images []Image
-	for _, sb := range s.images {
-		images = append(images, sb)
+	for _, sh := range s.shards {
+		sh.lock.RLock()
+		for _, i := range sh.images {
+			images = append(images, copyImage(i))
+		}
+		sh.lock.RUnlock()
 	}
 	return images
 }
 
 // Delete deletes the image with specified id.
 func (s *Store) Delete(id string) {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-	delete(s.images, id)
+	sh := s.shardFor(id)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	i, ok := sh.images[id]
+	if !ok {
+		return
+	}
+	s.targetLock.Lock()
+	for _, t := range i.Targets {
+		if s.targets[t] == id {
+			delete(s.targets, t)
+		}
+	}
+	s.targetLock.Unlock()
+	delete(sh.images, id)
+}
+
+// copyImage returns a copy of the image whose slices don't share memory with
+// the original one. Config is immutable, so it is shared.
+func copyImage(i Image) Image {
+	i.RepoTags = copyStringSlice(i.RepoTags)
+	i.RepoDigests = copyStringSlice(i.RepoDigests)
+	i.Targets = copyStringSlice(i.Targets)
+	return i
+}
+
+// copyStringSlice returns a copy of the string slice, nil is kept as nil.
+func copyStringSlice(ss []string) []string {
+	if ss == nil {
+		return nil
+	}
+	return append([]string{}, ss...)
 }
 
 // mergeStringSlices merges 2 string slices into one and remove duplicated elements.
@@ -17,6 +17,8 @@ limitations under the License.
 package image
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -104,3 +106,95 @@ func TestImageStore(t *testing.T) {
 	assert.Equal(Image{}, img)
 	assert.Equal(store.ErrNotExist, err)
 }
+
+func TestImageStoreTargets(t *testing.T) {
+	assert := assertlib.New(t)
+	s := NewStore()
+	s.Add(Image{ID: "1", Targets: []string{"manifest-1"}})
+	s.Add(Image{ID: "2"})
+
+	t.Logf("should be able to get image by target")
+	got, err := s.GetByTarget("manifest-1")
+	assert.NoError(err)
+	assert.Equal("1", got.ID)
+	_, err = s.GetByTarget("manifest-2")
+	assert.Equal(store.ErrNotExist, err)
+
+	t.Logf("should be able to add target to existing image")
+	assert.NoError(s.AddTarget("2", "manifest-2"))
+	got, err = s.GetByTarget("manifest-2")
+	assert.NoError(err)
+	assert.Equal([]string{"manifest-2"}, got.Targets)
+	assert.Equal(store.ErrNotExist, s.AddTarget("3", "manifest-3"))
+	_, err = s.GetByTarget("manifest-3")
+	assert.Equal(store.ErrNotExist, err)
+
+	t.Logf("should merge targets when image is added again")
+	s.Add(Image{ID: "1", Targets: []string{"index-1"}})
+	got, err = s.Get("1")
+	assert.NoError(err)
+	assert.Len(got.Targets, 2)
+	assert.Contains(got.Targets, "manifest-1")
+	assert.Contains(got.Targets, "index-1")
+
+	t.Logf("targets should be removed after image is deleted")
+	s.Delete("1")
+	for _, target := range []string{"manifest-1", "index-1"} {
+		_, err = s.GetByTarget(target)
+		assert.Equal(store.ErrNotExist, err)
+	}
+	got, err = s.GetByTarget("manifest-2")
+	assert.NoError(err)
+	assert.Equal("2", got.ID)
+}
+
+func TestImageStoreCopyOnRead(t *testing.T) {
+	assert := assertlib.New(t)
+	s := NewStore()
+	s.Add(Image{ID: "1", RepoTags: []string{"tag-1"}, RepoDigests: []string{"digest-1"}})
+
+	t.Logf("mutating returned image should not change the store")
+	got, err := s.Get("1")
+	assert.NoError(err)
+	got.RepoTags[0] = "mutated"
+	imgs := s.List()
+	imgs[0].RepoDigests[0] = "mutated"
+	got, err = s.Get("1")
+	assert.NoError(err)
+	assert.Equal([]string{"tag-1"}, got.RepoTags)
+	assert.Equal([]string{"digest-1"}, got.RepoDigests)
+
+	t.Logf("mutating added image should not change the store")
+	img := Image{ID: "2", RepoTags: []string{"tag-2"}}
+	s.Add(img)
+	img.RepoTags[0] = "mutated"
+	got, err = s.Get("2")
+	assert.NoError(err)
+	assert.Equal([]string{"tag-2"}, got.RepoTags)
+}
+
+func TestImageStoreConcurrentAdd(t *testing.T) {
+	const (
+		images = 20
+		tags   = 10
+	)
+	assert := assertlib.New(t)
+	s := NewStore()
+	var wg sync.WaitGroup
+	for i := 0; i < images; i++ {
+		for j := 0; j < tags; j++ {
+			wg.Add(1)
+			go func(id, tag string) {
+				defer wg.Done()
+				s.Add(Image{ID: id, RepoTags: []string{tag}})
+				s.List()
+			}(fmt.Sprintf("image-%d", i), fmt.Sprintf("tag-%d", j))
+		}
+	}
+	wg.Wait()
+	imgs := s.List()
+	assert.Len(imgs, images)
+	for _, img := range imgs {
+		assert.Len(img.RepoTags, tags, "all tags of %q should be merged", img.ID)
+	}
+}
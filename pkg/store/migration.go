@@ -0,0 +1,67 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// versionField is the field of the version in json encoded versioned data.
+const versionField = "Version"
+
+// Migration migrates the json fields of versioned data from one version to the
+// next in place, and returns the next version.
+type Migration func(fields map[string]json.RawMessage) (string, error)
+
+// Migrate migrates json encoded versioned data to the current version, so that
+// data persisted by an old version of cri-containerd could be decoded after
+// upgrade. Migrations are keyed by the version they migrate from, and applied
+// in chain until the data is of the current version.
+func Migrate(data []byte, current string, migrations map[string]Migration) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var version string
+	if v, ok := fields[versionField]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, fmt.Errorf("invalid version %s: %v", v, err)
+		}
+	}
+	if version == current {
+		return data, nil
+	}
+	// Each migration is applied at most once, which stops migration cycles.
+	for i := 0; version != current; i++ {
+		migrate, ok := migrations[version]
+		if !ok || i >= len(migrations) {
+			return nil, fmt.Errorf("unsupported version %q", version)
+		}
+		next, err := migrate(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate from version %q: %v", version, err)
+		}
+		version = next
+	}
+	v, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	fields[versionField] = v
+	return json.Marshal(fields)
+}
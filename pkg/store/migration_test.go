@@ -0,0 +1,91 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	// v0 names the field "Name", v1 renames it to "FullName", and v2
+	// adds the "Labels" field.
+	migrations := map[string]Migration{
+		"v0": func(fields map[string]json.RawMessage) (string, error) {
+			fields["FullName"] = fields["Name"]
+			delete(fields, "Name")
+			return "v1", nil
+		},
+		"v1": func(fields map[string]json.RawMessage) (string, error) {
+			fields["Labels"] = json.RawMessage(`{}`)
+			return "v2", nil
+		},
+		"broken": func(map[string]json.RawMessage) (string, error) {
+			return "", errors.New("broken")
+		},
+		"cycle": func(map[string]json.RawMessage) (string, error) {
+			return "cycle", nil
+		},
+	}
+	for desc, test := range map[string]struct {
+		data      string
+		expected  string
+		expectErr bool
+	}{
+		"current version should not be migrated": {
+			data:     `{"Version":"v2","FullName":"a b","Labels":{"k":"v"}}`,
+			expected: `{"Version":"v2","FullName":"a b","Labels":{"k":"v"}}`,
+		},
+		"old version should be migrated in chain": {
+			data:     `{"Version":"v0","Name":"a b"}`,
+			expected: `{"Version":"v2","FullName":"a b","Labels":{}}`,
+		},
+		"data without version should fail without migration": {
+			data:      `{"Name":"a b"}`,
+			expectErr: true,
+		},
+		"unsupported version should fail": {
+			data:      `{"Version":"v3"}`,
+			expectErr: true,
+		},
+		"failed migration should fail": {
+			data:      `{"Version":"broken"}`,
+			expectErr: true,
+		},
+		"migration cycle should fail": {
+			data:      `{"Version":"cycle"}`,
+			expectErr: true,
+		},
+		"invalid version should fail": {
+			data:      `{"Version":1}`,
+			expectErr: true,
+		},
+	} {
+		t.Logf("TestCase %q", desc)
+		migrated, err := Migrate([]byte(test.data), "v2", migrations)
+		if test.expectErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.JSONEq(t, test.expected, string(migrated))
+	}
+}
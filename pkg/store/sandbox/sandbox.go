@@ -18,6 +18,7 @@ package sandbox
 
 import (
 	"sync"
+	"sync/atomic"
 
 	"github.com/kubernetes-incubator/cri-containerd/pkg/store"
 )
@@ -35,6 +36,9 @@ type Sandbox struct {
 type Store struct {
 	lock      sync.RWMutex
 	sandboxes map[string]Sandbox
+	// generation is bumped whenever a sandbox is added or deleted. It
+	// must be accessed atomically.
+	generation uint64
 	// TODO(random-liu): Add trunc index.
 }
 
@@ -55,6 +59,7 @@ func (s *Store) Add(sb Sandbox) error {
 		return store.ErrAlreadyExist
 	}
 	s.sandboxes[sb.ID] = sb
+	atomic.AddUint64(&s.generation, 1)
 	return nil
 }
 
@@ -84,5 +89,15 @@ func (s *Store) List() []Sandbox {
 func (s *Store) Delete(id string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	if _, ok := s.sandboxes[id]; !ok {
+		return
+	}
 	delete(s.sandboxes, id)
+	atomic.AddUint64(&s.generation, 1)
+}
+
+// Generation returns the current generation of the store. The generation
+// changes whenever a sandbox is added or deleted.
+func (s *Store) Generation() uint64 {
+	return atomic.LoadUint64(&s.generation)
 }
@@ -112,4 +112,15 @@ func TestSandboxStore(t *testing.T) {
 	sb, err := s.Get(testID)
 	assert.Equal(Sandbox{}, sb)
 	assert.Equal(store.ErrNotExist, err)
+
+	t.Logf("generation should change on add and delete only")
+	generation := s.Generation()
+	assert.Equal(store.ErrAlreadyExist, s.Add(sandboxes["1"]))
+	s.Delete(testID)
+	assert.Equal(generation, s.Generation())
+	assert.NoError(s.Add(sandboxes[testID]))
+	assert.NotEqual(generation, s.Generation())
+	generation = s.Generation()
+	s.Delete(testID)
+	assert.NotEqual(generation, s.Generation())
 }